@@ -5,34 +5,51 @@ import (
 	"context"
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/slchris/portage-engine/internal/binpkg"
 	"github.com/slchris/portage-engine/internal/builder"
 	"github.com/slchris/portage-engine/internal/gpg"
+	"github.com/slchris/portage-engine/internal/ratelimit"
+	"github.com/slchris/portage-engine/internal/requestid"
+	"github.com/slchris/portage-engine/internal/version"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
 func main() {
-	cfg := loadConfig()
-	signer := initGPGSigner(cfg)
+	cfg, readConfig := loadConfig()
+	// The config as read, before GPG setup rewrites it, to tell which
+	// fields a reload changes.
+	loaded := *cfg
+	signer, signerErr := initGPGSigner(cfg)
 	bldr := builder.NewLocalBuilder(cfg.Workers, signer, cfg)
+	if signerErr != nil {
+		bldr.SetSignerError(signerErr)
+	}
+	bldr.CheckKeyExpiry()
 
-	mux := setupHTTPHandlers(bldr)
-	handler := authMiddleware(cfg.AuthToken, mux)
+	mux := setupHTTPHandlers(bldr, cfg.AdminToken)
+	limiter := ratelimit.New(cfg.RateLimitPerMinute, cfg.RateLimitBurst)
+	handler := authMiddleware(cfg.AuthToken, submitRateLimit(limiter, mux))
 	server := startServer(cfg, handler)
 
 	stopHeartbeat := startHeartbeat(cfg, bldr)
 	defer stopHeartbeat()
 
-	waitForShutdown(server, bldr)
+	waitForShutdown(server, bldr, func() { reloadConfig(bldr, &loaded, readConfig) })
 }
 
 // heartbeatInterval is how often the builder refreshes its registration with
@@ -53,25 +70,32 @@ func startHeartbeat(cfg *config.BuilderConfig, bldr *builder.LocalBuilder) func(
 	client := builder.NewBuilderClient(cfg.ServerURL)
 	client.SetAPIKey(cfg.ServerAPIKey)
 
-	builderID := cfg.InstanceID
+	builderID := bldr.InstanceID()
 	hostname, _ := os.Hostname()
-	if builderID == "" {
-		builderID = hostname
-	}
 	endpoint := cfg.AdvertiseURL
 	if endpoint == "" {
 		endpoint = fmt.Sprintf("http://%s:%d", hostname, cfg.Port)
 	}
 
 	send := func() {
+		status := "online"
+		if bldr.Draining() {
+			status = "draining"
+		}
 		hb := &builder.HeartbeatRequest{
-			BuilderID:  builderID,
-			Status:     "online",
-			Endpoint:   endpoint,
-			Capacity:   cfg.Workers,
-			ActiveJobs: bldr.ActiveJobs(),
-			Timestamp:  time.Now(),
+			BuilderID:    builderID,
+			Status:       status,
+			Endpoint:     endpoint,
+			Capacity:     cfg.Workers,
+			ActiveJobs:   bldr.ActiveJobs(),
+			Version:      version.Version,
+			APIVersion:   builder.APIVersion,
+			Timestamp:    time.Now(),
+			TreeLastSync: bldr.TreeLastSync(),
+			TreeRevision: bldr.TreeRevision(),
+			Architecture: bldr.Architecture(),
 		}
+		hb.EmulatedArchitectures = bldr.EmulatedArchitectures()
 		if err := client.SendHeartbeat(hb); err != nil {
 			log.Printf("Warning: heartbeat to %s failed: %v", cfg.ServerURL, err)
 		}
@@ -96,12 +120,112 @@ func startHeartbeat(cfg *config.BuilderConfig, bldr *builder.LocalBuilder) func(
 	return cancel
 }
 
-// authMiddleware requires a shared token on every endpoint except /health.
+// handleCancelJob serves POST /api/v1/jobs/{id}/cancel: 404 for an unknown
+// job, 409 for one that already finished. A running build is being stopped
+// when the response arrives; its status turns "cancelled" once it exits.
+func handleCancelJob(w http.ResponseWriter, r *http.Request, bldr *builder.LocalBuilder, jobID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if jobID == "" || strings.Contains(jobID, "/") {
+		http.Error(w, "Job ID required", http.StatusBadRequest)
+		return
+	}
+	if err := bldr.CancelJob(jobID); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, builder.ErrJobNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, builder.ErrJobFinished):
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	job, err := bldr.GetJobStatus(jobID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"job_id": jobID, "status": job.Status})
+}
+
+// handleJobScript serves GET /api/v1/jobs/{id}/script: the script the job's
+// build ran, with its secrets redacted; 404 for an unknown job or one that
+// has not run a script.
+func handleJobScript(w http.ResponseWriter, r *http.Request, bldr *builder.LocalBuilder, jobID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if jobID == "" || strings.Contains(jobID, "/") {
+		http.Error(w, "Job ID required", http.StatusBadRequest)
+		return
+	}
+	script, err := bldr.GetJobScript(jobID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"job_id": jobID, "script": script})
+}
+
+// queueAction is the body of the queue admin endpoints.
+type queueAction struct {
+	JobID    string `json:"job_id"`
+	Priority *int   `json:"priority,omitempty"`
+}
+
+// handleQueueAction serves POST /api/v1/queue/reorder ({"job_id", "priority"})
+// and POST /api/v1/queue/drop ({"job_id"}): 404 for an unknown job, 409 for
+// one no longer queued. It answers with the queue as it stands afterwards.
+func handleQueueAction(w http.ResponseWriter, r *http.Request, bldr *builder.LocalBuilder, action string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body queueAction
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.JobID == "" {
+		http.Error(w, "Invalid request: job_id required", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch action {
+	case "reorder":
+		if body.Priority == nil {
+			http.Error(w, "Invalid request: priority required", http.StatusBadRequest)
+			return
+		}
+		err = bldr.ReprioritizeJob(body.JobID, *body.Priority)
+	case "drop":
+		err = bldr.DropQueuedJob(body.JobID)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, builder.ErrJobNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, builder.ErrJobNotQueued):
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(bldr.QueuedJobs())
+}
+
+// authMiddleware requires a shared token on every endpoint except /health,
+// /ready and /api/v1/version.
 // The token is presented as "X-API-Key: <token>" or "Authorization: Bearer <token>".
 // If token is empty, auth is disabled (a startup warning is logged separately).
 func authMiddleware(token string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if token == "" || r.URL.Path == "/health" {
+		if token == "" || r.URL.Path == "/health" || r.URL.Path == "/ready" || r.URL.Path == "/api/v1/version" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -122,36 +246,118 @@ func authMiddleware(token string, next http.Handler) http.Handler {
 	})
 }
 
+// submitRateLimit limits build submissions (POST /api/v1/build) per client
+// IP, answering 429 with a Retry-After header beyond the limit, so no client
+// can fill the job queue. It sits behind authMiddleware, so rejected
+// requests do not use up a client's budget. A nil limiter allows everything.
+func submitRateLimit(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if limiter == nil || r.URL.Path != "/api/v1/build" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ok, wait := limiter.Allow("ip:" + host); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded: too many build submissions", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminOnly additionally requires the admin token, presented as
+// "X-Admin-Key: <token>", on top of the shared builder token. The endpoint is
+// refused outright while no BUILDER_ADMIN_TOKEN is configured.
+func adminOnly(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.Error(w, "forbidden: admin endpoints are disabled (set BUILDER_ADMIN_TOKEN)", http.StatusForbidden)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Key")), []byte(token)) != 1 {
+			http.Error(w, "unauthorized: invalid or missing admin key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // loadConfig loads and parses configuration.
-func loadConfig() *config.BuilderConfig {
+func loadConfig() (*config.BuilderConfig, func() (*config.BuilderConfig, error)) {
 	configPath := flag.String("config", "configs/builder.conf", "Path to configuration file")
 	port := flag.Int("port", 9090, "Builder service port")
+	showVersion := flag.Bool("version", false, "Print version and exit")
 	flag.Parse()
 
-	cfg, err := config.LoadBuilderConfig(*configPath)
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+	if *showVersion {
+		fmt.Printf("portage-builder %s (commit: %s, built: %s)\n", version.Version, version.Commit, version.BuildTime)
+		os.Exit(0)
 	}
 
-	if *port != 9090 {
-		cfg.Port = *port
+	// read loads the config file, at startup and again on each reload.
+	read := func() (*config.BuilderConfig, error) {
+		cfg, err := config.LoadBuilderConfig(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		// An explicit -port wins over the environment and the config
+		// file, even when it names the default port.
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "port" {
+				cfg.Port = *port
+			}
+		})
+		return cfg, nil
+	}
+	cfg, err := read()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
 	for _, w := range cfg.Validate() {
 		log.Printf("WARNING: %s", w)
 	}
 
-	log.Printf("Starting Portage Builder Service on port %d", cfg.Port)
-	return cfg
+	log.Printf("Starting Portage Builder Service %s on port %d", version.Version, cfg.Port)
+	return cfg, read
+}
+
+// reloadConfig reads the config again on SIGHUP and applies what a running
+// builder can change, logging the changed fields, compared with running,
+// that need a restart. The running config stays active when the new one
+// cannot be loaded or is rejected.
+func reloadConfig(bldr *builder.LocalBuilder, running *config.BuilderConfig, read func() (*config.BuilderConfig, error)) {
+	log.Println("Reloading configuration...")
+	next, err := read()
+	if err == nil {
+		err = bldr.ReloadConfig(next)
+	}
+	if err != nil {
+		log.Printf("Config reload rejected, keeping the running config: %v", err)
+		return
+	}
+	for _, w := range next.Validate() {
+		log.Printf("WARNING: %s", w)
+	}
+	if ignored := running.RestartRequired(next); len(ignored) > 0 {
+		log.Printf("Config reloaded; changes to %s need a restart and were ignored", strings.Join(ignored, ", "))
+		return
+	}
+	log.Println("Config reloaded")
 }
 
 // initGPGSigner initializes the GPG signer if enabled. It ensures a signing
 // keypair exists in the builder's GNUPGHOME (auto-creating one when no key ID is
 // configured) so emerge's native binpkg-signing has a private key to sign with,
-// and propagates the resolved key ID into the config for the executor.
-func initGPGSigner(cfg *config.BuilderConfig) *gpg.Signer {
+// and propagates the resolved key ID into the config for the executor. When
+// signing is enabled but cannot be set up it returns why, for /health.
+func initGPGSigner(cfg *config.BuilderConfig) (*gpg.Signer, error) {
 	if !cfg.GPGEnabled {
-		return nil
+		return nil, nil
 	}
 
 	// GPG setup failures disable signing with a loud warning rather than
@@ -160,7 +366,7 @@ func initGPGSigner(cfg *config.BuilderConfig) *gpg.Signer {
 	if err := gpg.CheckGPG(); err != nil {
 		log.Printf("WARNING: GPG unavailable (%v); builds will be UNSIGNED", err)
 		cfg.GPGEnabled = false
-		return nil
+		return nil, err
 	}
 
 	opts := []gpg.SignerOption{}
@@ -177,24 +383,33 @@ func initGPGSigner(cfg *config.BuilderConfig) *gpg.Signer {
 		log.Printf("WARNING: failed to initialize GPG signing key (%v); builds will be UNSIGNED", err)
 		cfg.GPGEnabled = false
 		cfg.GPGKeyID = ""
-		return nil
+		return nil, err
 	}
 
 	// Propagate the resolved key ID so the build executor enables binpkg-signing.
 	cfg.GPGKeyID = signer.KeyID()
 	log.Printf("GPG signing enabled with key: %s (GNUPGHOME=%s)", cfg.GPGKeyID, cfg.GPGHome)
-	return signer
+	return signer, nil
 }
 
 // setupHTTPHandlers sets up all HTTP handlers.
-func setupHTTPHandlers(bldr *builder.LocalBuilder) *http.ServeMux {
+func setupHTTPHandlers(bldr *builder.LocalBuilder, adminToken string) *http.ServeMux {
 	mux := http.NewServeMux()
 
-	// Health check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("OK"))
-	})
+	// Health check: 503 listing the failed checks when the builder cannot
+	// build. /ready is an alias for orchestrators that probe that path.
+	health := func(w http.ResponseWriter, r *http.Request) {
+		report := bldr.CheckHealth(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	}
+	mux.HandleFunc("/health", health)
+	mux.HandleFunc("/ready", health)
+
+	mux.HandleFunc("/api/v1/version", version.Handler("builder"))
 
 	// Status endpoint
 	mux.HandleFunc("/api/v1/status", func(w http.ResponseWriter, _ *http.Request) {
@@ -215,10 +430,20 @@ func setupHTTPHandlers(bldr *builder.LocalBuilder) *http.ServeMux {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
+		// Tag the job with the server's request ID so its log lines can be
+		// matched with the server's; direct submissions get their own.
+		req.RequestID = requestid.FromRequest(r)
+		w.Header().Set(requestid.Header, req.RequestID)
 
 		jobID, err := bldr.SubmitBuild(&req)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			status := http.StatusInternalServerError
+			if errors.Is(err, builder.ErrInvalidBuildRequest) {
+				status = http.StatusBadRequest
+			} else if errors.Is(err, builder.ErrSpotInterruption) || errors.Is(err, builder.ErrShuttingDown) {
+				status = http.StatusServiceUnavailable
+			}
+			http.Error(w, err.Error(), status)
 			return
 		}
 
@@ -231,9 +456,18 @@ func setupHTTPHandlers(bldr *builder.LocalBuilder) *http.ServeMux {
 		_ = json.NewEncoder(w).Encode(response)
 	})
 
-	// Job status endpoint
+	// Job status endpoint; POST /api/v1/jobs/{id}/cancel cancels the job and
+	// GET /api/v1/jobs/{id}/script returns the script its build ran.
 	mux.HandleFunc("/api/v1/jobs/", func(w http.ResponseWriter, r *http.Request) {
 		jobID := r.URL.Path[len("/api/v1/jobs/"):]
+		if id, ok := strings.CutSuffix(jobID, "/cancel"); ok {
+			handleCancelJob(w, r, bldr, id)
+			return
+		}
+		if id, ok := strings.CutSuffix(jobID, "/script"); ok {
+			handleJobScript(w, r, bldr, id)
+			return
+		}
 		if jobID == "" {
 			http.Error(w, "Job ID required", http.StatusBadRequest)
 			return
@@ -249,13 +483,97 @@ func setupHTTPHandlers(bldr *builder.LocalBuilder) *http.ServeMux {
 		_ = json.NewEncoder(w).Encode(status)
 	})
 
-	// List all jobs
-	mux.HandleFunc("/api/v1/jobs", func(w http.ResponseWriter, _ *http.Request) {
-		jobs := bldr.ListJobs()
+	// Live job log as Server-Sent Events.
+	mux.HandleFunc(builder.LogStreamPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		stream, err := bldr.StreamJobLog(r.URL.Query().Get("job_id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		builder.ServeLogStream(w, r, stream)
+	})
+
+	// List jobs, newest first unless ?sort=&order= say otherwise. ?status=
+	// filters and ?limit=&offset= page the list; X-Total-Count carries the
+	// number of matching jobs.
+	mux.HandleFunc("/api/v1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		q := builder.JobQuery{Status: r.URL.Query().Get("status")}
+		order, err := builder.ParseListOrder(r.URL.Query().Get("sort"), r.URL.Query().Get("order"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.Order = order
+		for name, dst := range map[string]*int{"limit": &q.Limit, "offset": &q.Offset} {
+			if v := r.URL.Query().Get(name); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					http.Error(w, "invalid "+name, http.StatusBadRequest)
+					return
+				}
+				*dst = n
+			}
+		}
+		jobs, total, err := bldr.ListJobsPage(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
 		_ = json.NewEncoder(w).Encode(jobs)
 	})
 
+	// Retention sweep on demand (admin only): prunes expired finished jobs and
+	// artifacts now instead of at the next JOB_PRUNE_INTERVAL.
+	mux.Handle("/api/v1/jobs/prune", adminOnly(adminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(bldr.PruneJobs())
+	})))
+
+	// Work queue: the queued jobs in the order workers take them, and (admin
+	// only) reprioritising or dropping one of them.
+	mux.HandleFunc("/api/v1/queue", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(bldr.QueuedJobs())
+	})
+	for _, action := range []string{"reorder", "drop"} {
+		mux.Handle("/api/v1/queue/"+action, adminOnly(adminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleQueueAction(w, r, bldr, action)
+		})))
+	}
+
+	// Portage tree sync (admin only): runs the package manager's sync and
+	// reports the outcome; the sync time then shows up in /api/v1/status.
+	mux.Handle("/api/v1/sync", adminOnly(adminToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		result, err := bldr.TrySyncTree(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !result.Success {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	})))
+
 	// Artifact info endpoint
 	mux.HandleFunc("/api/v1/artifacts/info/", func(w http.ResponseWriter, r *http.Request) {
 		jobID := r.URL.Path[len("/api/v1/artifacts/info/"):]
@@ -274,6 +592,30 @@ func setupHTTPHandlers(bldr *builder.LocalBuilder) *http.ServeMux {
 		_ = json.NewEncoder(w).Encode(info)
 	})
 
+	// Artifact search endpoint: the indexed artifacts matching the query,
+	// newest first.
+	mux.HandleFunc("/api/v1/artifacts/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q, err := binpkg.ParseArtifactQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		artifacts, err := bldr.SearchArtifacts(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if artifacts == nil {
+			artifacts = []*binpkg.ArtifactRecord{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"artifacts": artifacts, "count": len(artifacts)})
+	})
+
 	// Install-verification endpoint: proves a freshly built binpkg installs
 	// cleanly from the binhost in a pristine container.
 	mux.HandleFunc("/api/v1/verify", func(w http.ResponseWriter, r *http.Request) {
@@ -308,44 +650,92 @@ func setupHTTPHandlers(bldr *builder.LocalBuilder) *http.ServeMux {
 			return
 		}
 
-		var artifactPath string
+		var artifactPath, name string
 		var err error
-		if rel := r.URL.Query().Get("path"); rel != "" {
+		rel := r.URL.Query().Get("path")
+		if rel != "" {
 			artifactPath, err = bldr.GetArtifactPathByRel(jobID, rel)
+			name = filepath.Base(rel)
 		} else {
 			artifactPath, err = bldr.GetArtifactPath(jobID)
+			name = bldr.GetArtifactName(jobID)
 		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
-
-		// Get file info for headers
-		fileInfo, err := os.Stat(artifactPath)
-		if err != nil {
-			http.Error(w, "Failed to get file info", http.StatusInternalServerError)
-			return
+		// Serve the compressed form to clients that accept its encoding;
+		// ?original=true always gets the package as built.
+		if r.URL.Query().Get("original") != "true" {
+			if path, enc, ok := bldr.GetCompressedArtifactPath(jobID, rel); ok {
+				w.Header().Add("Vary", "Accept-Encoding")
+				if acceptsEncoding(r.Header.Get("Accept-Encoding"), enc) {
+					w.Header().Set("Content-Encoding", enc)
+					artifactPath = path
+				}
+			}
 		}
+		serveArtifact(w, r, artifactPath, name)
+	})
 
-		// Open the file
-		file, err := os.Open(artifactPath)
-		if err != nil {
-			http.Error(w, "Failed to open artifact file", http.StatusInternalServerError)
-			return
-		}
-		defer func() { _ = file.Close() }()
+	return mux
+}
 
-		// Set headers for download
-		fileName := fileInfo.Name()
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
+// serveArtifact streams the artifact at path as a download. http.ServeContent
+// answers Range requests with 206 Partial Content (multipart/byteranges for
+// several ranges), honours If-Range against the file's modtime, and sets
+// Accept-Ranges and Content-Length, so an interrupted download can resume.
+// The download is named name, or after the file when name is empty.
+func serveArtifact(w http.ResponseWriter, r *http.Request, path, name string) {
+	file, err := os.Open(path) // #nosec G304 -- path is resolved inside the artifact dir.
+	if err != nil {
+		http.Error(w, "Failed to open artifact file", http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = file.Close() }()
 
-		// Stream the file
-		http.ServeContent(w, r, fileName, fileInfo.ModTime(), file)
-	})
+	fileInfo, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Failed to get file info", http.StatusInternalServerError)
+		return
+	}
 
-	return mux
+	fileName := name
+	if fileName == "" {
+		fileName = fileInfo.Name()
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+	http.ServeContent(w, r, fileName, fileInfo.ModTime(), file)
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header accepts enc with
+// a non-zero q, either by name or through *. As RFC 9110 §12.5.3 has it, an
+// entry naming enc overrides *, whatever their order.
+func acceptsEncoding(header, enc string) bool {
+	explicit, wildcard := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch coding = strings.TrimSpace(coding); {
+		case strings.EqualFold(coding, enc):
+			explicit = q
+		case coding == "*":
+			wildcard = q
+		}
+	}
+	if explicit >= 0 {
+		return explicit > 0
+	}
+	return wildcard > 0
 }
 
 // startServer starts the HTTP server.
@@ -361,9 +751,9 @@ func startServer(cfg *config.BuilderConfig, handler http.Handler) *http.Server {
 }
 
 // waitForShutdown waits for shutdown signal and performs cleanup.
-func waitForShutdown(server *http.Server, bldr *builder.LocalBuilder) {
+func waitForShutdown(server *http.Server, bldr *builder.LocalBuilder, reload func()) {
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	go func() {
 		if err := server.ListenAndServe(); err != http.ErrServerClosed {
@@ -371,9 +761,17 @@ func waitForShutdown(server *http.Server, bldr *builder.LocalBuilder) {
 		}
 	}()
 
-	<-sigChan
+	// SIGHUP reloads the config; any other signal shuts down.
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reload()
+	}
 	log.Println("Shutting down builder service...")
 
+	// The API keeps serving while the builder drains, so the server sees
+	// new builds refused and can follow the running ones to the end.
 	bldr.Shutdown()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
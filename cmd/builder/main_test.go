@@ -3,6 +3,9 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -10,6 +13,8 @@ import (
 	"testing"
 
 	"github.com/slchris/portage-engine/internal/builder"
+	"github.com/slchris/portage-engine/internal/ratelimit"
+	"github.com/slchris/portage-engine/internal/requestid"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
@@ -370,6 +375,75 @@ func TestArtifactDownloadEndpointNotFound(t *testing.T) {
 	}
 }
 
+// TestServeArtifactRange verifies artifact downloads honour Range headers so
+// an interrupted download can resume.
+func TestServeArtifactRange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jq-1.7.gpkg.tar")
+	if err := os.WriteFile(path, []byte("0123456789"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	get := func(rangeHeader string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/artifacts/download/j1", nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		w := httptest.NewRecorder()
+		serveArtifact(w, req, path, "")
+		return w.Result()
+	}
+
+	resp := get("")
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "0123456789" {
+		t.Errorf("full download = %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Accept-Ranges = %q, want bytes", resp.Header.Get("Accept-Ranges"))
+	}
+
+	resp = get("bytes=4-")
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "456789" {
+		t.Errorf("range download = %d %q, want 206 \"456789\"", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes 4-9/10" {
+		t.Errorf("Content-Range = %q", got)
+	}
+	if got := resp.Header.Get("Content-Length"); got != "6" {
+		t.Errorf("Content-Length = %q, want 6", got)
+	}
+
+	// Several ranges come back as multipart/byteranges, one part per range.
+	resp = get("bytes=0-1,8-9")
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("multi-range status = %d", resp.StatusCode)
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("multi-range Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	var parts []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading part: %v", err)
+		}
+		data, _ := io.ReadAll(p)
+		parts = append(parts, string(data))
+	}
+	if len(parts) != 2 || parts[0] != "01" || parts[1] != "89" {
+		t.Errorf("multi-range parts = %q", parts)
+	}
+
+	if resp = get("bytes=20-"); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("unsatisfiable range status = %d, want 416", resp.StatusCode)
+	}
+}
+
 // TestAuthMiddleware verifies the builder rejects requests without the shared
 // token on protected paths, allows /health, and accepts the correct token.
 func TestAuthMiddleware(t *testing.T) {
@@ -429,3 +503,279 @@ func TestAuthMiddleware(t *testing.T) {
 		t.Errorf("empty token: expected 200 (auth disabled), got %d", w.Code)
 	}
 }
+
+// TestAdminOnly verifies admin endpoints need the admin key and are refused
+// entirely when no admin token is configured.
+// TestSubmitRateLimit verifies build submissions are limited per client IP,
+// answering 429 with Retry-After, while other endpoints are not.
+func TestSubmitRateLimit(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := submitRateLimit(ratelimit.New(1, 2), ok)
+
+	do := func(method, path, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := do(http.MethodPost, "/api/v1/build", "192.0.2.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("submission %d within the burst: %d", i+1, w.Code)
+		}
+	}
+	w := do(http.MethodPost, "/api/v1/build", "192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("submission beyond the burst: %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	if w := do(http.MethodPost, "/api/v1/build", "192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("another client IP was limited: %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/v1/status", "192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("status endpoint was limited: %d", w.Code)
+	}
+
+	unlimited := submitRateLimit(nil, ok)
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		unlimited.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/build", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("disabled limiter answered %d", w.Code)
+		}
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	tests := []struct {
+		name  string
+		token string
+		key   string
+		want  int
+	}{
+		{"disabled", "", "anything", http.StatusForbidden},
+		{"missing key", "admin-secret", "", http.StatusUnauthorized},
+		{"wrong key", "admin-secret", "wrong", http.StatusUnauthorized},
+		{"correct key", "admin-secret", "admin-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/sync", nil)
+			if tt.key != "" {
+				req.Header.Set("X-Admin-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			adminOnly(tt.token, ok).ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleCancelJob(t *testing.T) {
+	cfg := &config.BuilderConfig{WorkDir: t.TempDir(), ArtifactDir: t.TempDir()}
+	bldr := builder.NewLocalBuilder(0, nil, cfg)
+	jobID, err := bldr.SubmitBuild(&builder.LocalBuildRequest{PackageName: "app-misc/jq"})
+	if err != nil {
+		t.Fatalf("SubmitBuild: %v", err)
+	}
+
+	cancel := func(method, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/jobs/"+id+"/cancel", nil)
+		w := httptest.NewRecorder()
+		handleCancelJob(w, req, bldr, id)
+		return w
+	}
+
+	if w := cancel(http.MethodGet, jobID); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: expected status 405, got %d", w.Code)
+	}
+	if w := cancel(http.MethodPost, "non-existent-job"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown job: expected status 404, got %d", w.Code)
+	}
+
+	w := cancel(http.MethodPost, jobID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("queued job: expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response["status"] != "cancelled" {
+		t.Errorf("Expected status 'cancelled', got %q", response["status"])
+	}
+
+	if w := cancel(http.MethodPost, jobID); w.Code != http.StatusConflict {
+		t.Errorf("finished job: expected status 409, got %d", w.Code)
+	}
+}
+
+func TestHandleJobScript(t *testing.T) {
+	cfg := &config.BuilderConfig{WorkDir: t.TempDir(), ArtifactDir: t.TempDir()}
+	bldr := builder.NewLocalBuilder(0, nil, cfg)
+	jobID, err := bldr.SubmitBuild(&builder.LocalBuildRequest{PackageName: "app-misc/jq"})
+	if err != nil {
+		t.Fatalf("SubmitBuild: %v", err)
+	}
+
+	script := func(method, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/jobs/"+id+"/script", nil)
+		w := httptest.NewRecorder()
+		handleJobScript(w, req, bldr, id)
+		return w
+	}
+
+	if w := script(http.MethodPost, jobID); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: expected status 405, got %d", w.Code)
+	}
+	if w := script(http.MethodGet, "non-existent-job"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown job: expected status 404, got %d", w.Code)
+	}
+	if w := script(http.MethodGet, jobID); w.Code != http.StatusNotFound {
+		t.Errorf("queued job: expected status 404, got %d", w.Code)
+	}
+}
+
+func TestQueueEndpoints(t *testing.T) {
+	cfg := &config.BuilderConfig{WorkDir: t.TempDir(), ArtifactDir: t.TempDir()}
+	bldr := builder.NewLocalBuilder(0, nil, cfg)
+	mux := setupHTTPHandlers(bldr, "admin-secret")
+	var ids []string
+	for _, pkg := range []string{"app-misc/jq", "app-misc/tmux", "app-editors/vim"} {
+		id, err := bldr.SubmitBuild(&builder.LocalBuildRequest{PackageName: pkg, User: "alice"})
+		if err != nil {
+			t.Fatalf("SubmitBuild: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("X-Admin-Key", "admin-secret")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	queue := func(w *httptest.ResponseRecorder) []builder.QueueEntry {
+		t.Helper()
+		var entries []builder.QueueEntry
+		if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+			t.Fatalf("Failed to decode queue: %v (status %d)", err, w.Code)
+		}
+		return entries
+	}
+
+	entries := queue(do(http.MethodGet, "/api/v1/queue", ""))
+	if len(entries) != 3 || entries[0].JobID != ids[0] || entries[0].Position != 1 || entries[0].User != "alice" {
+		t.Fatalf("unexpected queue: %+v", entries)
+	}
+
+	entries = queue(do(http.MethodPost, "/api/v1/queue/reorder", `{"job_id":"`+ids[2]+`","priority":10}`))
+	if entries[0].JobID != ids[2] || entries[0].Priority != 10 || entries[1].JobID != ids[0] {
+		t.Errorf("reorder did not promote the job: %+v", entries)
+	}
+
+	entries = queue(do(http.MethodPost, "/api/v1/queue/drop", `{"job_id":"`+ids[1]+`"}`))
+	if len(entries) != 2 {
+		t.Errorf("drop left %d jobs queued, want 2", len(entries))
+	}
+	if job, _ := bldr.GetJobStatus(ids[1]); job.Status != "cancelled" {
+		t.Errorf("dropped job status = %q, want cancelled", job.Status)
+	}
+
+	if w := do(http.MethodPost, "/api/v1/queue/drop", `{"job_id":"`+ids[1]+`"}`); w.Code != http.StatusConflict {
+		t.Errorf("dropping twice: expected status 409, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/v1/queue/reorder", `{"job_id":"missing","priority":1}`); w.Code != http.StatusNotFound {
+		t.Errorf("unknown job: expected status 404, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/v1/queue/reorder", `{"job_id":"`+ids[0]+`"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("missing priority: expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHealthChecksReadiness(t *testing.T) {
+	cfg := &config.BuilderConfig{WorkDir: t.TempDir(), ArtifactDir: t.TempDir()}
+	bldr := builder.NewLocalBuilder(0, nil, cfg)
+	mux := setupHTTPHandlers(bldr, "")
+
+	get := func(path string) (*httptest.ResponseRecorder, builder.HealthReport) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var report builder.HealthReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", path, err)
+		}
+		return w, report
+	}
+
+	for _, path := range []string{"/health", "/ready"} {
+		if w, report := get(path); w.Code != http.StatusOK || report.Status != "ok" {
+			t.Errorf("%s: got %d %+v, want 200 ok", path, w.Code, report)
+		}
+	}
+
+	bldr.SetSignerError(io.ErrUnexpectedEOF)
+	w, report := get("/health")
+	if w.Code != http.StatusServiceUnavailable || report.Status != "unhealthy" || len(report.Failed) != 1 || report.Failed[0] != "signer" {
+		t.Errorf("got %d %+v, want 503 with the signer check failed", w.Code, report)
+	}
+}
+
+func TestBuildEndpointTagsRequestID(t *testing.T) {
+	cfg := &config.BuilderConfig{WorkDir: t.TempDir(), ArtifactDir: t.TempDir()}
+	bldr := builder.NewLocalBuilder(0, nil, cfg)
+	mux := setupHTTPHandlers(bldr, "")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/build", bytes.NewBufferString(`{"package_name":"app-misc/jq"}`))
+	req.Header.Set(requestid.Header, "req-9")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v (status %d)", err, w.Code)
+	}
+	if w.Header().Get(requestid.Header) != "req-9" {
+		t.Errorf("response %s = %q, want req-9", requestid.Header, w.Header().Get(requestid.Header))
+	}
+	job, err := bldr.GetJobStatus(resp["job_id"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.RequestID != "req-9" {
+		t.Errorf("job RequestID = %q, want the server's req-9", job.RequestID)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		header, enc string
+		want        bool
+	}{
+		{"", "zstd", false},
+		{"gzip, deflate", "zstd", false},
+		{"gzip, deflate", "gzip", true},
+		{"zstd;q=0.5, gzip", "zstd", true},
+		{"zstd;q=0, gzip", "zstd", false},
+		{"*", "zstd", true},
+		{"gzip;q=0.0", "gzip", false},
+		{"*;q=0, zstd", "zstd", true},
+		{"zstd, *;q=0", "zstd", true},
+		{"zstd;q=0, *", "zstd", false},
+		{"*, zstd;q=0", "zstd", false},
+		{"*;q=0", "zstd", false},
+		{"gzip, *;q=0.1", "zstd", true},
+		{"ZSTD;Q=0.8", "zstd", true},
+	} {
+		if got := acceptsEncoding(tt.header, tt.enc); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.enc, got, tt.want)
+		}
+	}
+}
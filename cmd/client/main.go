@@ -11,19 +11,79 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/slchris/portage-engine/internal/binpkg"
 	"github.com/slchris/portage-engine/internal/builder"
 )
 
-const httpTimeout = 60 * time.Second
+const (
+	httpTimeout    = 60 * time.Second
+	connectTimeout = 10 * time.Second
+)
+
+// pollInterval is how often -wait checks a job's status.
+var pollInterval = 5 * time.Second
+
+// systemPortageDir is the Portage configuration -diff compares against.
+var systemPortageDir = "/etc/portage"
+
+// Exit codes of the build and status commands, ordered by severity: when
+// several packages fail differently, the most severe code wins. Usage and
+// other local errors exit 1.
+const (
+	exitOK           = 0
+	exitBuildFailed  = 2 // a build finished as failed, or expired unstarted
+	exitWaitTimeout  = 3 // -wait-timeout elapsed before a build finished
+	exitSubmitFailed = 4 // the server could not be reached or refused the build
+)
+
+// errBuildFailed and errWaitTimeout classify the outcome of waiting on a job.
+var (
+	errBuildFailed = errors.New("build failed")
+	errWaitTimeout = errors.New("timed out waiting for the build")
+)
+
+// jobReport is the machine-readable outcome of one job, printed by -json.
+type jobReport struct {
+	Package     string `json:"package,omitempty"`
+	JobID       string `json:"job_id,omitempty"`
+	Status      string `json:"status"`
+	ArtifactURL string `json:"artifact_url,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// PretendOutput is the job log of a -pretend build, holding the emerge
+	// --pretend output.
+	PretendOutput string `json:"pretend_output,omitempty"`
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// errUnreachable marks a submission that never got a response from the server.
+var errUnreachable = errors.New("server unreachable")
+
+// newHTTPClient returns a client whose connection attempts give up after
+// connect and whose requests, response included, give up after timeout.
+func newHTTPClient(connect, timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}).DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
 
 func main() {
 	log.SetFlags(0)
@@ -67,7 +127,8 @@ Commands:
   build       Request the server build a package (Portage has no native way to
               do this). Optionally wait for completion with -wait.
 
-  status      Show the status of a previously requested build job.
+  status      Show the status of a previously requested build job, by job ID
+              or by package (the most recent build of -package).
 
   bundle      Generate a Portage config bundle file (USE flags, make.conf, ...)
               without submitting a build.
@@ -84,6 +145,26 @@ Examples:
 
   # Check a job later.
   portage-client status -job=<job-id>
+
+  # In CI: JSON report on stdout, branch on the exit code.
+  portage-client build -package=app-misc/jq -wait -wait-timeout=2h -json
+
+  # Preview how a saved configuration differs from /etc/portage.
+  portage-client build -config=config.json -package=app-misc/jq -diff
+
+  # Preview what emerge would merge on a builder, without building.
+  portage-client build -package=dev-lang/python -use=ssl -pretend
+
+  # Build only if the binhost has no matching binary package yet, then
+  # install it (emerge fetches and verifies it from the binhost).
+  portage-client build -package=dev-lang/python -use=ssl -if-missing -wait &&
+    emerge --getbinpkg dev-lang/python
+
+  # Abandon the build if it has not started within 15 minutes.
+  portage-client build -package=app-misc/jq -max-queue-wait=15m -wait
+
+Exit codes (build, status): 0 success, 1 usage error, 2 build failed or expired,
+3 -wait-timeout elapsed, 4 server unreachable or submission rejected.
 `)
 }
 
@@ -130,7 +211,7 @@ func runBuild(args []string) {
 	fs := flag.NewFlagSet("build", flag.ExitOnError)
 	server := fs.String("server", "http://localhost:8080", "Server URL")
 	apiKey := fs.String("api-key", os.Getenv("PORTAGE_ENGINE_API_KEY"), "API key (or PORTAGE_ENGINE_API_KEY)")
-	packageName := fs.String("package", "", "Package atom (e.g., dev-lang/python)")
+	packageName := fs.String("package", "", "Package atom or set (e.g., dev-lang/python, @world)")
 	packageVersion := fs.String("version", "", "Package version")
 	useFlags := fs.String("use", "", "USE flags (comma-separated)")
 	keywords := fs.String("keywords", "", "Keywords (comma-separated)")
@@ -140,40 +221,176 @@ func runBuild(args []string) {
 	profile := fs.String("profile", "default/linux/amd64/23.0", "Portage profile")
 	userID := fs.String("user", "default", "User ID")
 	description := fs.String("desc", "", "Build description")
+	acceptLicense := fs.String("accept-license", "", "ACCEPT_LICENSE for the build (e.g., \"@FREE @BINARY-REDISTRIBUTABLE\"; default: builder's)")
+	forceRebuild := fs.Bool("force-rebuild", false, "Build even if the builder has the result of an identical build against the same portage tree")
 	wait := fs.Bool("wait", false, "Wait for the build to complete")
+	pretend := fs.Bool("pretend", false, "Resolve the build on a builder with emerge --pretend and print the dependency graph and download sizes; nothing is built (implies -wait)")
+	waitTimeout := fs.Duration("wait-timeout", 0, "Give up waiting after this long (with -wait; 0 = no limit)")
+	jsonOut := fs.Bool("json", false, "Print a JSON report on stdout instead of human-readable text")
+	retries := fs.Int("retries", 3, "Retries for a submission the server did not receive or was too busy to accept")
+	retryBackoff := fs.Duration("retry-backoff", time.Second, "Delay before the first retry, doubled for each later one")
+	connect := fs.Duration("connect-timeout", connectTimeout, "Timeout for connecting to the server")
+	timeout := fs.Duration("timeout", httpTimeout, "Timeout for each request to the server")
+	maxQueueWait := fs.Duration("max-queue-wait", 0, "Cancel the build as expired if it has not started within this long (0 = no limit)")
+	diff := fs.Bool("diff", false, "Show how the build's configuration differs from "+systemPortageDir+" and exit without submitting")
+	merge := fs.String("merge", string(builder.MergeIncomingWins), "With both -portage-dir and -config, which side wins a conflicting key: incoming-wins (-config) or system-wins (-portage-dir)")
+	ifMissing := fs.Bool("if-missing", false, "Only build packages the server's binhost has no binary package of for -arch with the requested USE flags")
 	_ = fs.Parse(args)
 
 	if *packageName == "" && *configFile == "" && *portageDir == "" {
 		log.Fatal("build: one of -package, -config, or -portage-dir is required")
 	}
 
-	config := loadPortageConfig(*portageDir, *configFile)
+	config := loadPortageConfig(*portageDir, *configFile, *merge)
+	if *diff {
+		if err := previewConfigDiff(os.Stdout, systemPortageDir, config); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	specs := createPackageSpecs(*packageName, *packageVersion, parseCSV(*useFlags), parseCSV(*keywords))
+	if err := validatePackageSpecs(specs); err != nil {
+		log.Fatal(err)
+	}
 	bundle := createConfigBundle(config, specs, *userID, *arch, *profile, *description)
 
 	base := strings.TrimRight(*server, "/")
-	client := &http.Client{Timeout: httpTimeout}
+	client := newHTTPClient(*connect, *timeout)
+	retry := retryPolicy{retries: *retries, backoff: *retryBackoff}
 
-	var failures int
+	exitCode := exitOK
+	reports := []jobReport{}
 	for _, pkg := range bundle.Packages.Packages {
-		req := &builder.LocalBuildRequest{PackageName: pkg.Atom, Version: pkg.Version, ConfigBundle: bundle}
-		jobID, err := postSubmit(client, base, *apiKey, req)
+		if *ifMissing {
+			found, err := queryBinpkg(client, base, *apiKey, pkg, *arch)
+			if err != nil {
+				log.Printf("binhost query for %s failed: %v; submitting the build", pkg.Atom, err)
+			} else if found != nil {
+				if !*jsonOut {
+					fmt.Printf("%s-%s is already on the binhost; not building it\n", found.Name, found.Version)
+				}
+				reports = append(reports, jobReport{Package: pkg.Atom, Status: "available", ArtifactURL: "/binpkgs/" + found.Path})
+				continue
+			}
+		}
+		req := &submitRequest{
+			LocalBuildRequest: builder.LocalBuildRequest{PackageName: pkg.Atom, Version: pkg.Version, ConfigBundle: bundle, AcceptLicense: *acceptLicense, ForceRebuild: *forceRebuild, Pretend: *pretend},
+		}
+		if *maxQueueWait > 0 {
+			req.MaxQueueWait = maxQueueWait.String()
+		}
+		jobID, err := submitWithRetry(client, base, *apiKey, req, retry)
 		if err != nil {
 			log.Printf("build submit failed for %s: %v", pkg.Atom, err)
-			failures++
+			reports = append(reports, jobReport{Package: pkg.Atom, Status: "submit_failed", Error: err.Error()})
+			exitCode = max(exitCode, exitSubmitFailed)
 			continue
 		}
-		fmt.Printf("Build submitted for %s (job ID: %s)\n", pkg.Atom, jobID)
+		if !*jsonOut {
+			fmt.Printf("Build submitted for %s (job ID: %s)\n", pkg.Atom, jobID)
+		}
 
-		if *wait {
-			if err := pollStatus(client, base, *apiKey, jobID); err != nil {
+		report := jobReport{Package: pkg.Atom, JobID: jobID, Status: "queued"}
+		if *wait || *pretend {
+			report, err = pollStatus(client, base, *apiKey, jobID, *waitTimeout, progressOutput(*jsonOut))
+			report.Package = pkg.Atom
+			if err != nil {
 				log.Printf("build %s did not complete successfully: %v", jobID, err)
-				failures++
+				exitCode = max(exitCode, waitExitCode(err))
 			}
 		}
+		if *pretend && report.Status != "unknown" {
+			// The emerge output is in the job log, whether it resolved or not.
+			if report.PretendOutput, err = fetchLogs(client, base, *apiKey, jobID); err != nil {
+				log.Printf("fetching the pretend output of %s failed: %v", jobID, err)
+			} else if !*jsonOut {
+				fmt.Print(report.PretendOutput)
+			}
+		}
+		reports = append(reports, report)
 	}
-	if failures > 0 {
-		os.Exit(1)
+	if *jsonOut {
+		printJSON(map[string]any{"jobs": reports})
+	}
+	os.Exit(exitCode)
+}
+
+// queryBinpkg asks the server's package API for a binary package of spec
+// built for arch with spec's USE flags, and returns it, or nil when the
+// binhost has none. USE flags spec turns off ("-flag") must be off in the
+// package too, which the server does not check. Sets such as @world are
+// never on the binhost.
+func queryBinpkg(c *http.Client, base, apiKey string, spec builder.PackageSpec, arch string) (*binpkg.Package, error) {
+	if strings.HasPrefix(spec.Atom, "@") {
+		return nil, nil
+	}
+	name, _, _ := strings.Cut(spec.Atom, ":")
+	query := binpkg.QueryRequest{Name: name, Version: spec.Version, Arch: arch}
+	var disabled []string
+	for _, flag := range spec.UseFlags {
+		if off, ok := strings.CutPrefix(flag, "-"); ok {
+			disabled = append(disabled, off)
+		} else {
+			query.UseFlags = append(query.UseFlags, strings.TrimPrefix(flag, "+"))
+		}
+	}
+	data, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, base+"/api/v1/packages/query", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		httpReq.Header.Set("X-API-Key", apiKey)
+	}
+
+	resp, err := c.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var out binpkg.QueryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode query response: %w", err)
+	}
+	if !out.Found || out.Package == nil {
+		return nil, nil
+	}
+	for _, flag := range disabled {
+		if slices.Contains(out.Package.UseFlags, flag) {
+			return nil, nil
+		}
+	}
+	return out.Package, nil
+}
+
+// progressOutput is where polling progress goes: stdout normally, stderr
+// when stdout carries the JSON report.
+func progressOutput(jsonOut bool) io.Writer {
+	if jsonOut {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// waitExitCode maps an error from pollStatus to the command's exit code.
+func waitExitCode(err error) int {
+	switch {
+	case errors.Is(err, errBuildFailed):
+		return exitBuildFailed
+	case errors.Is(err, errWaitTimeout):
+		return exitWaitTimeout
+	default:
+		// The server stopped answering mid-wait.
+		return exitSubmitFailed
 	}
 }
 
@@ -184,21 +401,39 @@ func runStatus(args []string) {
 	server := fs.String("server", "http://localhost:8080", "Server URL")
 	apiKey := fs.String("api-key", os.Getenv("PORTAGE_ENGINE_API_KEY"), "API key (or PORTAGE_ENGINE_API_KEY)")
 	jobID := fs.String("job", "", "Job ID")
+	packageName := fs.String("package", "", "Package atom; shows its most recent build instead of -job")
+	packageVersion := fs.String("version", "", "Package version (with -package)")
+	arch := fs.String("arch", "", "Architecture (with -package)")
+	jsonOut := fs.Bool("json", false, "Print a JSON report on stdout instead of human-readable text")
 	_ = fs.Parse(args)
 
-	if *jobID == "" {
-		log.Fatal("status: -job is required")
+	if *jobID == "" && *packageName == "" {
+		log.Fatal("status: -job or -package is required")
 	}
 
 	base := strings.TrimRight(*server, "/")
-	client := &http.Client{Timeout: httpTimeout}
-	status, errMsg, _, err := fetchStatus(client, base, *apiKey, *jobID)
+	client := newHTTPClient(connectTimeout, httpTimeout)
+	var report jobReport
+	var err error
+	if *jobID != "" {
+		report, _, err = fetchStatus(client, base, *apiKey, *jobID)
+	} else {
+		report, err = fetchStatusByPackage(client, base, *apiKey, *packageName, *packageVersion, *arch)
+	}
 	if err != nil {
-		log.Fatalf("failed to fetch status: %v", err)
+		log.Printf("failed to fetch status: %v", err)
+		os.Exit(exitSubmitFailed)
+	}
+	if *jsonOut {
+		printJSON(report)
+	} else {
+		fmt.Printf("Job %s: %s\n", report.JobID, report.Status)
+		if report.Error != "" {
+			fmt.Printf("  error: %s\n", report.Error)
+		}
 	}
-	fmt.Printf("Job %s: %s\n", *jobID, status)
-	if errMsg != "" {
-		fmt.Printf("  error: %s\n", errMsg)
+	if report.Status == "failed" || report.Status == "expired" {
+		os.Exit(exitBuildFailed)
 	}
 }
 
@@ -216,28 +451,53 @@ func runBundle(args []string) {
 	profile := fs.String("profile", "default/linux/amd64/23.0", "Portage profile")
 	userID := fs.String("user", "default", "User ID")
 	description := fs.String("desc", "", "Build description")
-	out := fs.String("out", "", "Output bundle path (required)")
+	out := fs.String("out", "", "Output bundle path (required): a .tar.gz tarball, or else a directory of loose files")
+	merge := fs.String("merge", string(builder.MergeIncomingWins), "With both -portage-dir and -config, which side wins a conflicting key: incoming-wins (-config) or system-wins (-portage-dir)")
 	_ = fs.Parse(args)
 
 	if *out == "" {
 		log.Fatal("bundle: -out is required")
 	}
 
-	config := loadPortageConfig(*portageDir, *configFile)
+	config := loadPortageConfig(*portageDir, *configFile, *merge)
 	specs := createPackageSpecs(*packageName, *packageVersion, parseCSV(*useFlags), parseCSV(*keywords))
+	if err := validatePackageSpecs(specs); err != nil {
+		log.Fatal(err)
+	}
 	bundle := createConfigBundle(config, specs, *userID, *arch, *profile, *description)
 
 	transfer := builder.NewConfigTransfer("")
-	if err := transfer.ExportBundle(bundle, *out); err != nil {
+	export := transfer.ExportBundleDir
+	if isBundleTarball(*out) {
+		export = transfer.ExportBundle
+	}
+	if err := export(bundle, *out); err != nil {
 		log.Fatalf("failed to export bundle: %v", err)
 	}
 	fmt.Printf("Configuration bundle saved to: %s\n", *out)
 }
 
+// isBundleTarball reports whether a bundle at path is a gzipped tarball
+// rather than a directory, by its .tar.gz or .tgz extension.
+func isBundleTarball(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
 // --- shared helpers ---
 
-func loadPortageConfig(portageDir, configFile string) *builder.PortageConfig {
+// loadPortageConfig loads the configuration from portageDir or configFile.
+// With both, configFile's is merged onto portageDir's, conflicting keys
+// resolved by the merge policy named mergePolicy.
+func loadPortageConfig(portageDir, configFile, mergePolicy string) *builder.PortageConfig {
 	switch {
+	case portageDir != "" && configFile != "":
+		policy, err := builder.ParseMergePolicy(mergePolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		system := loadPortageConfig(portageDir, "", mergePolicy)
+		incoming := loadPortageConfig("", configFile, mergePolicy)
+		return builder.MergeConfig(system, incoming, policy)
 	case portageDir != "":
 		transfer := builder.NewConfigTransfer("")
 		config, err := transfer.ReadSystemPortageConfig(portageDir)
@@ -263,6 +523,18 @@ func loadPortageConfig(portageDir, configFile string) *builder.PortageConfig {
 	}
 }
 
+// previewConfigDiff writes how config differs from the configuration in
+// systemDir to w.
+func previewConfigDiff(w io.Writer, systemDir string, config *builder.PortageConfig) error {
+	transfer := builder.NewConfigTransfer("")
+	system, err := transfer.ReadSystemPortageConfig(systemDir)
+	if err != nil {
+		return fmt.Errorf("failed to read Portage configuration from %s: %w", systemDir, err)
+	}
+	_, err = fmt.Fprintf(w, "Changes against %s:\n%s", systemDir, builder.DiffConfig(system, config))
+	return err
+}
+
 func parseCSV(s string) []string {
 	if s == "" {
 		return nil
@@ -289,6 +561,17 @@ func createPackageSpecs(name, version string, useFlags, keywords []string) []bui
 	}}
 }
 
+// validatePackageSpecs runs the server's package checks locally, so a
+// malformed atom is reported before anything is submitted or exported.
+func validatePackageSpecs(specs []builder.PackageSpec) error {
+	for _, spec := range specs {
+		if err := builder.ValidatePackageSpec(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func createConfigBundle(config *builder.PortageConfig, specs []builder.PackageSpec, userID, arch, profile, desc string) *builder.ConfigBundle {
 	packages := &builder.BuildPackageSpec{Packages: specs}
 	metadata := builder.BundleMetadata{
@@ -318,8 +601,65 @@ func loadConfigFromFile(path string) (*builder.PortageConfig, error) {
 	return &config, nil
 }
 
+// retryPolicy controls how a build submission is retried.
+type retryPolicy struct {
+	retries int           // attempts after the first
+	backoff time.Duration // delay before the first retry, doubled each time
+}
+
+// rejectionError is a non-success response from the server.
+type rejectionError struct {
+	status int
+	body   string
+}
+
+func (e *rejectionError) Error() string {
+	return fmt.Sprintf("server rejected request (%d): %s", e.status, e.body)
+}
+
+// temporary reports whether the rejection is worth retrying: the server (or
+// a proxy in front of it) is overloaded or momentarily unavailable.
+func (e *rejectionError) temporary() bool {
+	switch e.status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// submitWithRetry submits req, retrying with exponential backoff while the
+// server is unreachable or temporarily unable to accept it. A definitive
+// rejection (e.g. an invalid request) is returned at once.
+func submitWithRetry(c *http.Client, base, apiKey string, req *submitRequest, policy retryPolicy) (string, error) {
+	delay := policy.backoff
+	for attempt := 0; ; attempt++ {
+		jobID, err := postSubmit(c, base, apiKey, req)
+		if err == nil {
+			return jobID, nil
+		}
+		var rejected *rejectionError
+		retryable := errors.Is(err, errUnreachable) || (errors.As(err, &rejected) && rejected.temporary())
+		if !retryable || attempt >= policy.retries {
+			if attempt > 0 {
+				return "", fmt.Errorf("%w (after %d attempts)", err, attempt+1)
+			}
+			return "", err
+		}
+		log.Printf("submit %s: %v; retrying in %s", req.PackageName, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// submitRequest is the body of /api/v1/builds/submit: the build plus the
+// server's scheduling limits.
+type submitRequest struct {
+	builder.LocalBuildRequest
+	MaxQueueWait string `json:"max_queue_wait,omitempty"`
+}
+
 // postSubmit POSTs a config-bundle build to /api/v1/builds/submit.
-func postSubmit(c *http.Client, base, apiKey string, req *builder.LocalBuildRequest) (string, error) {
+func postSubmit(c *http.Client, base, apiKey string, req *submitRequest) (string, error) {
 	data, err := json.Marshal(req)
 	if err != nil {
 		return "", fmt.Errorf("marshal request: %w", err)
@@ -336,13 +676,13 @@ func postSubmit(c *http.Client, base, apiKey string, req *builder.LocalBuildRequ
 
 	resp, err := c.Do(httpReq)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w at %s: %v", errUnreachable, base, err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return "", fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return "", &rejectionError{status: resp.StatusCode, body: strings.TrimSpace(string(body))}
 	}
 
 	var out struct {
@@ -358,29 +698,106 @@ func postSubmit(c *http.Client, base, apiKey string, req *builder.LocalBuildRequ
 	return out.JobID, nil
 }
 
-// pollStatus polls until the job succeeds or fails.
-func pollStatus(c *http.Client, base, apiKey, jobID string) error {
+// pollStatus polls until the job succeeds or fails, printing each status to
+// progress. A zero timeout waits indefinitely. It returns the last report,
+// with errBuildFailed or errWaitTimeout when the job did not succeed.
+func pollStatus(c *http.Client, base, apiKey, jobID string, timeout time.Duration, progress io.Writer) (jobReport, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
 	for {
-		status, errMsg, terminal, err := fetchStatus(c, base, apiKey, jobID)
+		report, terminal, err := fetchStatus(c, base, apiKey, jobID)
 		if err != nil {
-			return err
+			return jobReport{JobID: jobID, Status: "unknown", Error: err.Error()}, err
 		}
-		fmt.Printf("  [%s] status: %s\n", jobID, status)
+		_, _ = fmt.Fprintf(progress, "  [%s] status: %s\n", jobID, report.Status)
 		if terminal {
-			if status == "failed" {
-				return fmt.Errorf("build failed: %s", errMsg)
+			if report.Status == "failed" || report.Status == "expired" {
+				return report, fmt.Errorf("%w: %s", errBuildFailed, report.Error)
 			}
-			return nil
+			return report, nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return report, fmt.Errorf("%w after %s (last status: %s)", errWaitTimeout, timeout, report.Status)
 		}
-		time.Sleep(5 * time.Second)
+		time.Sleep(pollInterval)
 	}
 }
 
+// fetchStatusByPackage returns the most recent build of a package.
+func fetchStatusByPackage(c *http.Client, base, apiKey, atom, version, arch string) (jobReport, error) {
+	q := url.Values{"atom": {atom}}
+	if version != "" {
+		q.Set("version", version)
+	}
+	if arch != "" {
+		q.Set("arch", arch)
+	}
+	httpReq, err := http.NewRequest(http.MethodGet, base+"/api/v1/builds/status-by-package?"+q.Encode(), nil)
+	if err != nil {
+		return jobReport{}, err
+	}
+	if apiKey != "" {
+		httpReq.Header.Set("X-API-Key", apiKey)
+	}
+
+	resp, err := c.Do(httpReq)
+	if err != nil {
+		return jobReport{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return jobReport{}, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var out struct {
+		Latest jobReport `json:"latest"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return jobReport{}, fmt.Errorf("decode status: %w", err)
+	}
+	out.Latest.Package = atom
+	return out.Latest, nil
+}
+
+// fetchLogs returns the log of a job.
+func fetchLogs(c *http.Client, base, apiKey, jobID string) (string, error) {
+	httpReq, err := http.NewRequest(http.MethodGet, base+"/api/v1/builds/logs?job_id="+url.QueryEscape(jobID), nil)
+	if err != nil {
+		return "", err
+	}
+	if apiKey != "" {
+		httpReq.Header.Set("X-API-Key", apiKey)
+	}
+
+	resp, err := c.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var out struct {
+		Logs string `json:"logs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("decode logs: %w", err)
+	}
+	return out.Logs, nil
+}
+
 // fetchStatus queries the status endpoint once.
-func fetchStatus(c *http.Client, base, apiKey, jobID string) (status, errMsg string, terminal bool, err error) {
-	httpReq, err := http.NewRequest(http.MethodGet, base+"/api/v1/packages/status?job_id="+jobID, nil)
+func fetchStatus(c *http.Client, base, apiKey, jobID string) (report jobReport, terminal bool, err error) {
+	httpReq, err := http.NewRequest(http.MethodGet, base+"/api/v1/packages/status?job_id="+url.QueryEscape(jobID), nil)
 	if err != nil {
-		return "", "", false, err
+		return jobReport{}, false, err
 	}
 	if apiKey != "" {
 		httpReq.Header.Set("X-API-Key", apiKey)
@@ -388,23 +805,33 @@ func fetchStatus(c *http.Client, base, apiKey, jobID string) (status, errMsg str
 
 	resp, err := c.Do(httpReq)
 	if err != nil {
-		return "", "", false, err
+		return jobReport{}, false, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return "", "", false, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return jobReport{}, false, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
 	}
 
+	// The server's BuildStatus carries these under the same JSON names, plus
+	// package_name, which reports the atom as the job knows it.
 	var out struct {
-		Status string `json:"status"`
-		Error  string `json:"error"`
+		jobReport
+		PackageName string `json:"package_name"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		return "", "", false, fmt.Errorf("decode status: %w", err)
+		return jobReport{}, false, fmt.Errorf("decode status: %w", err)
+	}
+	report = out.jobReport
+	report.Package = out.PackageName
+	if report.JobID == "" {
+		report.JobID = jobID
 	}
 
-	term := out.Status == "success" || out.Status == "completed" || out.Status == "failed"
-	return out.Status, out.Error, term, nil
+	switch report.Status {
+	case "success", "completed", "success_no_artifact", "failed", "expired":
+		terminal = true
+	}
+	return report, terminal, nil
 }
@@ -1,10 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/slchris/portage-engine/internal/binpkg"
 	"github.com/slchris/portage-engine/internal/builder"
 )
 
@@ -50,6 +61,39 @@ func TestLoadConfigFromFile(t *testing.T) {
 	}
 }
 
+func TestPreviewConfigDiff(t *testing.T) {
+	systemDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(systemDir, "make.conf"), []byte("MAKEOPTS=\"-j4\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	system := loadPortageConfig(systemDir, "", string(builder.MergeIncomingWins))
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(`{"make_conf": {"MAKEOPTS": "-j8"}, "package_use": {"app-misc/jq": ["oniguruma"]}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := loadPortageConfig(systemDir, configPath, string(builder.MergeIncomingWins))
+	if config.MakeConf["MAKEOPTS"] != "-j8" || len(config.PackageUse) != len(system.PackageUse)+1 {
+		t.Errorf("merged config = %+v", config)
+	}
+	if kept := loadPortageConfig(systemDir, configPath, string(builder.MergeSystemWins)); kept.MakeConf["MAKEOPTS"] != "-j4" {
+		t.Errorf("system-wins MAKEOPTS = %q", kept.MakeConf["MAKEOPTS"])
+	}
+
+	var out bytes.Buffer
+	if err := previewConfigDiff(&out, systemDir, config); err != nil {
+		t.Fatalf("previewConfigDiff() = %v", err)
+	}
+	for _, want := range []string{"Changes against " + systemDir, "  + app-misc/jq oniguruma", "  ~ MAKEOPTS: -j4 -> -j8"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("diff missing %q:\n%s", want, out.String())
+		}
+	}
+	if err := previewConfigDiff(&out, "/nonexistent/portage", config); err == nil {
+		t.Error("previewConfigDiff() of a missing directory succeeded")
+	}
+}
+
 func TestLoadConfigFromFileInvalid(t *testing.T) {
 	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, "invalid.json")
@@ -176,3 +220,184 @@ func TestParseFlagsAndKeywords(t *testing.T) {
 		})
 	}
 }
+
+func TestIsBundleTarball(t *testing.T) {
+	for path, want := range map[string]bool{
+		"python-build.tar.gz": true,
+		"bundles/python.tgz":  true,
+		"bundles/python":      false,
+		"bundles/python/":     false,
+		"python.tar":          false,
+	} {
+		if got := isBundleTarball(path); got != want {
+			t.Errorf("isBundleTarball(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestValidatePackageSpecs(t *testing.T) {
+	valid := createPackageSpecs(">=dev-lang/python-3.11:3.11[sqlite]", "", nil, nil)
+	if err := validatePackageSpecs(valid); err != nil {
+		t.Errorf("validatePackageSpecs(%v) = %v", valid, err)
+	}
+	invalid := createPackageSpecs("python", "", nil, nil)
+	err := validatePackageSpecs(invalid)
+	if err == nil || !strings.Contains(err.Error(), "missing category") {
+		t.Errorf("validatePackageSpecs(%v) = %v, want a missing category error", invalid, err)
+	}
+}
+
+func TestSubmitWithRetry(t *testing.T) {
+	policy := retryPolicy{retries: 3, backoff: time.Millisecond}
+	req := &submitRequest{LocalBuildRequest: builder.LocalBuildRequest{PackageName: "app-misc/hello"}}
+
+	t.Run("recovers from a busy server", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) < 3 {
+				http.Error(w, "work queue is full", http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(`{"job_id":"job-1","status":"queued"}`))
+		}))
+		defer srv.Close()
+
+		jobID, err := submitWithRetry(srv.Client(), srv.URL, "", req, policy)
+		if err != nil || jobID != "job-1" {
+			t.Fatalf("submitWithRetry() = %q, %v", jobID, err)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("attempts = %d, want 3", calls.Load())
+		}
+	})
+
+	t.Run("does not retry a rejection", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			http.Error(w, "invalid package atom", http.StatusBadRequest)
+		}))
+		defer srv.Close()
+
+		_, err := submitWithRetry(srv.Client(), srv.URL, "", req, policy)
+		var rejected *rejectionError
+		if !errors.As(err, &rejected) || rejected.status != http.StatusBadRequest {
+			t.Fatalf("error = %v, want a 400 rejection", err)
+		}
+		if !strings.Contains(err.Error(), "server rejected request (400): invalid package atom") {
+			t.Errorf("error = %q", err)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("attempts = %d, want 1", calls.Load())
+		}
+	})
+
+	t.Run("reports an unreachable server", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		url := srv.URL
+		srv.Close()
+
+		_, err := submitWithRetry(newHTTPClient(time.Second, time.Second), url, "", req, policy)
+		if !errors.Is(err, errUnreachable) {
+			t.Fatalf("error = %v, want errUnreachable", err)
+		}
+		if !strings.Contains(err.Error(), "after 4 attempts") {
+			t.Errorf("error = %q, want the attempt count", err)
+		}
+	})
+}
+
+func TestPollStatus(t *testing.T) {
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = 5 * time.Second }()
+
+	statuses := map[string]string{
+		"ok":      `{"job_id":"ok","status":"completed","package_name":"app-misc/hello","artifact_url":"/binpkgs/app-misc/hello-1.0-1.gpkg.tar"}`,
+		"broken":  `{"job_id":"broken","status":"failed","error":"compile error"}`,
+		"running": `{"job_id":"running","status":"building"}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(statuses[r.URL.Query().Get("job_id")]))
+	}))
+	defer srv.Close()
+
+	report, err := pollStatus(srv.Client(), srv.URL, "", "ok", 0, io.Discard)
+	if err != nil {
+		t.Fatalf("pollStatus(ok) error = %v", err)
+	}
+	want := jobReport{Package: "app-misc/hello", JobID: "ok", Status: "completed", ArtifactURL: "/binpkgs/app-misc/hello-1.0-1.gpkg.tar"}
+	if report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+
+	_, err = pollStatus(srv.Client(), srv.URL, "", "broken", 0, io.Discard)
+	if !errors.Is(err, errBuildFailed) || waitExitCode(err) != exitBuildFailed {
+		t.Errorf("pollStatus(broken) error = %v, want errBuildFailed", err)
+	}
+
+	report, err = pollStatus(srv.Client(), srv.URL, "", "running", 10*time.Millisecond, io.Discard)
+	if !errors.Is(err, errWaitTimeout) || waitExitCode(err) != exitWaitTimeout {
+		t.Errorf("pollStatus(running) error = %v, want errWaitTimeout", err)
+	}
+	if report.Status != "building" {
+		t.Errorf("timed-out report status = %q, want the last seen status", report.Status)
+	}
+
+	srv.Close()
+	if _, err = pollStatus(srv.Client(), srv.URL, "", "ok", 0, io.Discard); waitExitCode(err) != exitSubmitFailed {
+		t.Errorf("pollStatus() against a dead server error = %v, want exitSubmitFailed", err)
+	}
+}
+
+func TestFetchLogs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/builds/logs" || r.URL.Query().Get("job_id") != "p1" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"job_id":"p1","logs":"[ebuild  N     ] app-misc/jq-1.7.1\n"}`))
+	}))
+	defer srv.Close()
+
+	logs, err := fetchLogs(srv.Client(), srv.URL, "", "p1")
+	if err != nil || logs != "[ebuild  N     ] app-misc/jq-1.7.1\n" {
+		t.Errorf("fetchLogs() = %q, %v", logs, err)
+	}
+	if _, err := fetchLogs(srv.Client(), srv.URL, "", "missing"); err == nil {
+		t.Error("fetchLogs() of an unknown job succeeded")
+	}
+}
+
+func TestQueryBinpkg(t *testing.T) {
+	var got binpkg.QueryRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		if got.Name != "dev-lang/python" || got.Arch != "amd64" {
+			_, _ = w.Write([]byte(`{"found":false}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"found":true,"package":{"name":"dev-lang/python","version":"3.11.9","arch":"amd64","use_flags":["ssl","threads"],"path":"dev-lang/python-3.11.9-1.gpkg.tar"}}`))
+	}))
+	defer srv.Close()
+
+	spec := builder.PackageSpec{Atom: "dev-lang/python:3.11", UseFlags: []string{"ssl", "-sqlite"}}
+	pkg, err := queryBinpkg(srv.Client(), srv.URL, "", spec, "amd64")
+	if err != nil || pkg == nil || pkg.Version != "3.11.9" {
+		t.Fatalf("queryBinpkg = %+v, %v; want python 3.11.9", pkg, err)
+	}
+	if !slices.Equal(got.UseFlags, []string{"ssl"}) {
+		t.Errorf("queried USE flags %v, want only the enabled [ssl]", got.UseFlags)
+	}
+
+	spec.UseFlags = []string{"-threads"}
+	if pkg, err := queryBinpkg(srv.Client(), srv.URL, "", spec, "amd64"); err != nil || pkg != nil {
+		t.Errorf("a package with a USE flag turned off in the request matched: %+v, %v", pkg, err)
+	}
+	if pkg, err := queryBinpkg(srv.Client(), srv.URL, "", spec, "arm64"); err != nil || pkg != nil {
+		t.Errorf("a package for another arch matched: %+v, %v", pkg, err)
+	}
+	if pkg, err := queryBinpkg(srv.Client(), srv.URL, "", builder.PackageSpec{Atom: "@world"}, "amd64"); err != nil || pkg != nil {
+		t.Errorf("a set matched: %+v, %v", pkg, err)
+	}
+}
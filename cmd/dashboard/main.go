@@ -14,17 +14,24 @@ import (
 	"time"
 
 	"github.com/slchris/portage-engine/internal/dashboard"
+	"github.com/slchris/portage-engine/internal/version"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
 var (
-	configPath = flag.String("config", "configs/dashboard.conf", "Path to configuration file")
-	port       = flag.Int("port", 8081, "Dashboard port")
+	configPath  = flag.String("config", "configs/dashboard.conf", "Path to configuration file")
+	port        = flag.Int("port", 8081, "Dashboard port")
+	showVersion = flag.Bool("version", false, "Print version and exit")
 )
 
 func main() {
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Printf("portage-dashboard %s (commit: %s, built: %s)\n", version.Version, version.Commit, version.BuildTime)
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.LoadDashboardConfig(*configPath)
 	if err != nil {
@@ -50,7 +57,10 @@ func main() {
 	}
 
 	// Create dashboard instance
-	dash := dashboard.New(cfg)
+	dash, err := dashboard.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create dashboard: %v", err)
+	}
 
 	// HTTP server configuration
 	httpServer := &http.Server{
@@ -19,7 +19,10 @@ func TestDashboardHealth(t *testing.T) {
 		ServerURL: "http://localhost:8080",
 	}
 
-	dash := dashboard.New(cfg)
+	dash, err := dashboard.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
 	router := dash.Router()
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -65,7 +68,10 @@ func TestDashboardStartStop(t *testing.T) {
 		ServerURL: "http://localhost:8080",
 	}
 
-	dash := dashboard.New(cfg)
+	dash, err := dashboard.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	httpServer := &http.Server{
 		Addr:         ":0",
@@ -93,7 +99,10 @@ func TestDashboardRouter(t *testing.T) {
 		ServerURL: "http://localhost:8080",
 	}
 
-	dash := dashboard.New(cfg)
+	dash, err := dashboard.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
 	router := dash.Router()
 
 	if router == nil {
@@ -107,7 +116,10 @@ func TestDashboardRoutes(t *testing.T) {
 		ServerURL: "http://localhost:8080",
 	}
 
-	dash := dashboard.New(cfg)
+	dash, err := dashboard.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
 	router := dash.Router()
 
 	tests := []struct {
@@ -139,7 +151,10 @@ func TestDashboardGracefulShutdown(t *testing.T) {
 		ServerURL: "http://localhost:8080",
 	}
 
-	dash := dashboard.New(cfg)
+	dash, err := dashboard.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	httpServer := &http.Server{
 		Addr:         ":0",
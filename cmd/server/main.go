@@ -10,10 +10,12 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/slchris/portage-engine/internal/server"
+	"github.com/slchris/portage-engine/internal/version"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
@@ -23,23 +25,16 @@ var (
 	showVersion = flag.Bool("version", false, "Print version and exit")
 )
 
-// Version information (injected at build time via -ldflags).
-var (
-	version   = "dev"
-	commit    = "unknown"
-	buildTime = "unknown"
-)
-
 func main() {
 	flag.Parse()
 
 	if *showVersion {
-		fmt.Printf("portage-server %s (commit: %s, built: %s)\n", version, commit, buildTime)
+		fmt.Printf("portage-server %s (commit: %s, built: %s)\n", version.Version, version.Commit, version.BuildTime)
 		return
 	}
 
 	// Load configuration
-	cfg, err := config.LoadServerConfig(*configPath)
+	cfg, err := readConfig()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
@@ -51,16 +46,6 @@ func main() {
 		}
 	}
 
-	// Override port if specified
-	if *port != 8080 {
-		cfg.Port = *port
-	}
-
-	// Propagate version info to the server package
-	server.Version = version
-	server.Commit = commit
-	server.BuildTime = buildTime
-
 	// Create server instance
 	srv := server.New(cfg)
 
@@ -90,10 +75,15 @@ func main() {
 		}
 	}()
 
-	// Graceful shutdown
+	// Graceful shutdown; SIGHUP reloads the config instead.
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range quit {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloadConfig(srv)
+	}
 
 	log.Println("Shutting down server...")
 
@@ -113,3 +103,43 @@ func main() {
 
 	log.Println("Server exited")
 }
+
+// readConfig loads the config file, at startup and again on each reload.
+func readConfig() (*config.ServerConfig, error) {
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		return nil, err
+	}
+	// Override port if the -port flag was explicitly set, so it wins over
+	// the environment and the config file even when it names the default.
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "port" {
+			cfg.Port = *port
+		}
+	})
+	return cfg, nil
+}
+
+// reloadConfig reads the config again on SIGHUP and applies what a running
+// server can change, logging the changed fields it ignored. The running
+// config stays active when the new one cannot be loaded or is rejected.
+func reloadConfig(srv *server.Server) {
+	log.Println("Reloading configuration...")
+	cfg, err := readConfig()
+	var ignored []string
+	if err == nil {
+		ignored, err = srv.ReloadConfig(cfg)
+	}
+	if err != nil {
+		log.Printf("Config reload rejected, keeping the running config: %v", err)
+		return
+	}
+	for _, w := range cfg.Validate() {
+		log.Printf("WARNING: %s", w)
+	}
+	if len(ignored) > 0 {
+		log.Printf("Config reloaded; changes to %s need a restart and were ignored", strings.Join(ignored, ", "))
+		return
+	}
+	log.Println("Config reloaded")
+}
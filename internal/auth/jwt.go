@@ -0,0 +1,169 @@
+// Package auth provides the operator authentication shared by the server and
+// the dashboard: HS256 JWT issuance and verification with a common signing
+// secret, and a bcrypt user store.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// This is a minimal, dependency-free HS256 JWT implementation. It is
+// deliberately small and does not aim to support the full JWT spec.
+
+// Issuer is the iss claim of every token this package issues and accepts.
+const Issuer = "portage-engine"
+
+// clockSkew is how far in the future a token's iat may lie, to tolerate
+// clock drift between the dashboard and the server.
+const clockSkew = time.Minute
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// Claims are the claims of an operator token.
+type Claims struct {
+	Subject string `json:"sub"`
+	// Role is RoleViewer, RoleSubmitter or RoleAdmin. Tokens issued before
+	// roles existed carry none and verify as RoleViewer.
+	Role      string `json:"role,omitempty"`
+	Issuer    string `json:"iss"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+var (
+	// ErrNoSecret is returned when no signing secret is configured.
+	ErrNoSecret = errors.New("no JWT signing secret configured")
+	// ErrMalformedToken is returned for a token that is not a JWT.
+	ErrMalformedToken = errors.New("malformed token")
+	// ErrBadSignature is returned when the signature does not match.
+	ErrBadSignature = errors.New("invalid token signature")
+	// ErrExpiredToken is returned once the token's exp has passed.
+	ErrExpiredToken = errors.New("token expired")
+	// ErrWrongAlg is returned for a token not signed with HS256.
+	ErrWrongAlg = errors.New("unexpected signing algorithm")
+	// ErrInvalidClaims is returned for a token whose claims are incomplete,
+	// name an unknown role, or were issued by someone else or in the future.
+	ErrInvalidClaims = errors.New("invalid token claims")
+)
+
+// SignToken issues an HS256 token for subject with role valid for ttl,
+// signed with secret.
+func SignToken(secret, subject, role string, now time.Time, ttl time.Duration) (string, error) {
+	if secret == "" {
+		return "", ErrNoSecret
+	}
+	if !ValidRole(role) {
+		return "", fmt.Errorf("unknown role %q", role)
+	}
+	header := jwtHeader{Alg: "HS256", Typ: "JWT"}
+	claims := Claims{
+		Subject:   subject,
+		Role:      role,
+		Issuer:    Issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := b64(headerJSON) + "." + b64(claimsJSON)
+	return signingInput + "." + sign(secret, signingInput), nil
+}
+
+// VerifyToken validates the signature, expiry and claims of token against
+// secret and returns its claims.
+func VerifyToken(secret, token string, now time.Time) (*Claims, error) {
+	if secret == "" {
+		return nil, ErrNoSecret
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+	// Constant-time comparison of the base64 signatures.
+	if !hmac.Equal([]byte(sign(secret, signingInput)), []byte(parts[2])) {
+		return nil, ErrBadSignature
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, ErrMalformedToken
+	}
+	if header.Alg != "HS256" {
+		return nil, ErrWrongAlg
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+	if claims.Role == "" {
+		claims.Role = RoleViewer
+	}
+	if claims.Subject == "" || !ValidRole(claims.Role) || claims.Issuer != Issuer || claims.IssuedAt > now.Add(clockSkew).Unix() {
+		return nil, ErrInvalidClaims
+	}
+	return &claims, nil
+}
+
+// BearerToken returns the token from an "Authorization: Bearer <token>"
+// header, or the raw header value if it has no Bearer prefix.
+func BearerToken(header string) string {
+	return strings.TrimPrefix(header, "Bearer ")
+}
+
+// TokenFromRequest returns the token r presents in its Authorization header
+// or, failing that, in the cookie named cookie (if not empty).
+func TokenFromRequest(r *http.Request, cookie string) string {
+	if token := BearerToken(r.Header.Get("Authorization")); token != "" {
+		return token
+	}
+	if cookie != "" {
+		if c, err := r.Cookie(cookie); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func sign(secret, input string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
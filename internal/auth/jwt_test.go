@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret-that-is-at-least-32-chars-long"
+
+func TestSignAndVerifyToken(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	token, err := SignToken(testSecret, "alice", RoleSubmitter, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := VerifyToken(testSecret, token, now)
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	if claims.Subject != "alice" || claims.Role != RoleSubmitter || claims.Issuer != Issuer || claims.ExpiresAt != now.Add(time.Hour).Unix() {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	if _, err := VerifyToken(testSecret, token, now.Add(2*time.Hour)); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("after expiry: %v, want ErrExpiredToken", err)
+	}
+	if _, err := VerifyToken("another-secret-that-is-32-chars-long", token, now); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("other secret: %v, want ErrBadSignature", err)
+	}
+	if _, err := VerifyToken("", token, now); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("no secret: %v, want ErrNoSecret", err)
+	}
+	if _, err := SignToken("", "alice", RoleViewer, now, time.Hour); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("signing without a secret: %v, want ErrNoSecret", err)
+	}
+	if _, err := SignToken(testSecret, "alice", "root", now, time.Hour); err == nil {
+		t.Error("signing with an unknown role should fail")
+	}
+	if _, err := VerifyToken(testSecret, "not-a-token", now); !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("malformed: %v, want ErrMalformedToken", err)
+	}
+}
+
+// forge signs arbitrary header and claims JSON with the test secret.
+func forge(header, claims string) string {
+	input := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return input + "." + sign(testSecret, input)
+}
+
+func TestVerifyTokenClaims(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", forge(hs256, `{"sub":"admin","role":"admin","iss":"portage-engine","iat":1700000000,"exp":1700003600}`), nil},
+		{"no role", forge(hs256, `{"sub":"admin","iss":"portage-engine","iat":1700000000,"exp":1700003600}`), nil},
+		{"unknown role", forge(hs256, `{"sub":"admin","role":"root","iss":"portage-engine","iat":1700000000,"exp":1700003600}`), ErrInvalidClaims},
+		{"wrong algorithm", forge(`{"alg":"none","typ":"JWT"}`, `{"sub":"admin","iss":"portage-engine","iat":1700000000,"exp":1700003600}`), ErrWrongAlg},
+		{"no expiry", forge(hs256, `{"sub":"admin","iss":"portage-engine","iat":1700000000}`), ErrExpiredToken},
+		{"no subject", forge(hs256, `{"iss":"portage-engine","iat":1700000000,"exp":1700003600}`), ErrInvalidClaims},
+		{"other issuer", forge(hs256, `{"sub":"admin","iss":"someone-else","iat":1700000000,"exp":1700003600}`), ErrInvalidClaims},
+		{"issued in the future", forge(hs256, `{"sub":"admin","iss":"portage-engine","iat":1700001000,"exp":1700003600}`), ErrInvalidClaims},
+	}
+	for _, tt := range tests {
+		if _, err := VerifyToken(testSecret, tt.token, now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestTokenFromRequest(t *testing.T) {
+	t.Parallel()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got := TokenFromRequest(r, "session"); got != "" {
+		t.Errorf("no token: %q", got)
+	}
+	r.AddCookie(&http.Cookie{Name: "session", Value: "from-cookie"})
+	if got := TokenFromRequest(r, "session"); got != "from-cookie" {
+		t.Errorf("cookie: %q", got)
+	}
+	if got := TokenFromRequest(r, ""); got != "" {
+		t.Errorf("cookie ignored without a name: %q", got)
+	}
+	r.Header.Set("Authorization", "Bearer from-header")
+	if got := TokenFromRequest(r, "session"); got != "from-header" {
+		t.Errorf("header: %q", got)
+	}
+	if got := BearerToken("raw-token"); !strings.EqualFold(got, "raw-token") {
+		t.Errorf("BearerToken without prefix: %q", got)
+	}
+}
@@ -0,0 +1,72 @@
+// Package auth provides role-based access control over operator tokens.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Roles, each granting everything the ones before it do.
+const (
+	// RoleViewer may see builds and status.
+	RoleViewer = "viewer"
+	// RoleSubmitter may also submit and cancel builds.
+	RoleSubmitter = "submitter"
+	// RoleAdmin may also rotate keys and manage builders.
+	RoleAdmin = "admin"
+)
+
+// roleRank orders the roles by privilege.
+var roleRank = map[string]int{RoleViewer: 1, RoleSubmitter: 2, RoleAdmin: 3}
+
+// ValidRole reports whether role is a known role.
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// HasRole reports whether a holder of role have may act as need.
+func HasRole(have, need string) bool {
+	return ValidRole(have) && roleRank[have] >= roleRank[need]
+}
+
+type claimsKey struct{}
+
+// WithClaims returns ctx carrying the claims of the request's verified
+// token.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims WithClaims stored in ctx, if any.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok && claims != nil
+}
+
+// RequireRole returns middleware that passes on only requests whose claims
+// (stored with WithClaims by the authenticating middleware) carry role or a
+// higher one. Requests without claims get 401, those with a lesser role
+// 403, so clients can tell authentication from authorization failures.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			switch {
+			case !ok:
+				writeError(w, http.StatusUnauthorized, "unauthorized: a valid token is required")
+			case !HasRole(claims.Role, role):
+				writeError(w, http.StatusForbidden, fmt.Sprintf("forbidden: requires the %s role", role))
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHasRole(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		have, need string
+		want       bool
+	}{
+		{RoleAdmin, RoleViewer, true},
+		{RoleAdmin, RoleAdmin, true},
+		{RoleSubmitter, RoleSubmitter, true},
+		{RoleSubmitter, RoleAdmin, false},
+		{RoleViewer, RoleSubmitter, false},
+		{"", RoleViewer, false},
+		{"root", RoleViewer, false},
+	}
+	for _, tt := range tests {
+		if got := HasRole(tt.have, tt.need); got != tt.want {
+			t.Errorf("HasRole(%q, %q) = %v, want %v", tt.have, tt.need, got, tt.want)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	t.Parallel()
+
+	handler := RequireRole(RoleSubmitter)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func(claims *Claims) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/builds/submit", nil)
+		if claims != nil {
+			r = r.WithContext(WithClaims(r.Context(), claims))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if got := do(nil); got != http.StatusUnauthorized {
+		t.Errorf("no claims: %d, want 401", got)
+	}
+	if got := do(&Claims{Subject: "v", Role: RoleViewer}); got != http.StatusForbidden {
+		t.Errorf("viewer: %d, want 403", got)
+	}
+	for _, role := range []string{RoleSubmitter, RoleAdmin} {
+		if got := do(&Claims{Subject: "u", Role: role}); got != http.StatusOK {
+			t.Errorf("%s: %d, want 200", role, got)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package auth provides a user store of bcrypt-hashed operator passwords.
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against when a login names an unknown user, so the
+// response time does not reveal which usernames exist.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("portage-engine"), bcrypt.DefaultCost)
+	return hash
+})
+
+// user is a UserStore entry.
+type user struct {
+	hash []byte // bcrypt
+	role string
+}
+
+// UserStore maps usernames to bcrypt password hashes and roles.
+type UserStore struct {
+	users map[string]user
+}
+
+// NewUserStore returns an empty user store.
+func NewUserStore() *UserStore {
+	return &UserStore{users: map[string]user{}}
+}
+
+// IsBcryptHash reports whether s looks like a bcrypt hash ($2a$, $2b$ or
+// $2y$, as htpasswd -B writes).
+func IsBcryptHash(s string) bool {
+	_, err := bcrypt.Cost([]byte(s))
+	return err == nil
+}
+
+// HashPassword returns the bcrypt hash of password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Add adds the user with the bcrypt password hash and role.
+func (s *UserStore) Add(username, hash, role string) error {
+	if username == "" {
+		return fmt.Errorf("empty username")
+	}
+	if !IsBcryptHash(hash) {
+		return fmt.Errorf("password of user %q is not a bcrypt hash", username)
+	}
+	if !ValidRole(role) {
+		return fmt.Errorf("user %q has unknown role %q", username, role)
+	}
+	s.users[username] = user{hash: []byte(hash), role: role}
+	return nil
+}
+
+// LoadUserFile adds the users of an htpasswd-style file: one
+// "username:bcrypt-hash[:role]" per line, with blank lines and # comments
+// ignored. A user without a role is a RoleViewer.
+func (s *UserStore) LoadUserFile(path string) error {
+	f, err := os.Open(path) // #nosec G304 -- path comes from the config.
+	if err != nil {
+		return fmt.Errorf("failed to open users file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, rest, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("%s:%d: want username:hash[:role]", path, n)
+		}
+		hash, role, _ := strings.Cut(rest, ":")
+		if role = strings.TrimSpace(role); role == "" {
+			role = RoleViewer
+		}
+		if err := s.Add(strings.TrimSpace(username), strings.TrimSpace(hash), role); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Len returns the number of users.
+func (s *UserStore) Len() int {
+	return len(s.users)
+}
+
+// Authenticate reports whether password is the password of username, and
+// if so returns the user's role.
+func (s *UserStore) Authenticate(username, password string) (role string, ok bool) {
+	u, known := s.users[username]
+	if !known {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return "", false
+	}
+	if bcrypt.CompareHashAndPassword(u.hash, []byte(password)) != nil {
+		return "", false
+	}
+	return u.role, true
+}
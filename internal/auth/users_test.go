@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testHash returns a cheap bcrypt hash of password.
+func testHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestUserStore(t *testing.T) {
+	t.Parallel()
+
+	users := NewUserStore()
+	if err := users.Add("alice", testHash(t, "wonderland"), RoleSubmitter); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Add("bob", "plaintext", RoleViewer); err == nil {
+		t.Error("a plaintext password should be rejected")
+	}
+	if err := users.Add("bob", testHash(t, "x"), "root"); err == nil {
+		t.Error("an unknown role should be rejected")
+	}
+	if role, ok := users.Authenticate("alice", "wonderland"); !ok || role != RoleSubmitter {
+		t.Errorf("alice: %q, %v", role, ok)
+	}
+	if _, ok := users.Authenticate("alice", "wrong"); ok {
+		t.Error("wrong password accepted")
+	}
+	if _, ok := users.Authenticate("mallory", "wonderland"); ok {
+		t.Error("unknown user accepted")
+	}
+	if !IsBcryptHash(testHash(t, "x")) || IsBcryptHash("x") {
+		t.Error("IsBcryptHash misclassifies")
+	}
+}
+
+func TestLoadUserFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "users")
+	// htpasswd -B writes $2y$ hashes.
+	carol := "$2y" + testHash(t, "secret")[3:]
+	content := "# operators\nalice:" + testHash(t, "wonderland") + "\n\ncarol:" + carol + ":admin\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	users := NewUserStore()
+	if err := users.LoadUserFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if users.Len() != 2 {
+		t.Errorf("loaded %d users, want 2", users.Len())
+	}
+	if role, ok := users.Authenticate("alice", "wonderland"); !ok || role != RoleViewer {
+		t.Errorf("alice (no role given): %q, %v", role, ok)
+	}
+	if role, ok := users.Authenticate("carol", "secret"); !ok || role != RoleAdmin {
+		t.Errorf("carol: %q, %v", role, ok)
+	}
+
+	if err := os.WriteFile(path, []byte("alice\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewUserStore().LoadUserFile(path); err == nil {
+		t.Error("expected an error for a line without a hash")
+	}
+	if err := NewUserStore().LoadUserFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,251 @@
+package binpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArtifactRecord describes one binary package a build produced.
+type ArtifactRecord struct {
+	// Path is the artifact's path relative to the artifact dir; it
+	// identifies the record, so rebuilding a path replaces its record.
+	Path     string    `json:"path"`
+	Name     string    `json:"name"`
+	Version  string    `json:"version"`
+	Arch     string    `json:"arch"`
+	UseFlags []string  `json:"use_flags"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256,omitempty"`
+	SHA512   string    `json:"sha512,omitempty"`
+	Signed   bool      `json:"signed"`
+	JobID    string    `json:"job_id"`
+	BuiltAt  time.Time `json:"built_at"`
+}
+
+// ArtifactQuery selects artifact records. Empty fields match anything; a
+// record matches UseFlags when all of them are enabled in it. Limit 0
+// returns every match.
+type ArtifactQuery struct {
+	Name     string
+	Version  string
+	Arch     string
+	UseFlags []string
+	// SignedOnly keeps only signed artifacts.
+	SignedOnly bool
+	Limit      int
+}
+
+// ParseArtifactQuery reads an ArtifactQuery from the parameters of
+// GET /api/v1/artifacts/search: name, version, arch, use (comma-separated
+// or repeated), signed and limit.
+func ParseArtifactQuery(v url.Values) (ArtifactQuery, error) {
+	q := ArtifactQuery{Name: v.Get("name"), Version: v.Get("version"), Arch: v.Get("arch")}
+	for _, use := range v["use"] {
+		for _, flag := range strings.Split(use, ",") {
+			if flag = strings.TrimSpace(flag); flag != "" {
+				q.UseFlags = append(q.UseFlags, flag)
+			}
+		}
+	}
+	if s := v.Get("signed"); s != "" {
+		signed, err := strconv.ParseBool(s)
+		if err != nil {
+			return ArtifactQuery{}, fmt.Errorf("invalid signed %q", s)
+		}
+		q.SignedOnly = signed
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return ArtifactQuery{}, fmt.Errorf("invalid limit %q", s)
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// Values encodes q as the parameters ParseArtifactQuery reads.
+func (q ArtifactQuery) Values() url.Values {
+	v := url.Values{}
+	for key, val := range map[string]string{"name": q.Name, "version": q.Version, "arch": q.Arch} {
+		if val != "" {
+			v.Set(key, val)
+		}
+	}
+	if len(q.UseFlags) > 0 {
+		v.Set("use", strings.Join(q.UseFlags, ","))
+	}
+	if q.SignedOnly {
+		v.Set("signed", "true")
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// Matches reports whether rec is selected by q, ignoring Limit.
+func (q ArtifactQuery) Matches(rec *ArtifactRecord) bool {
+	return (q.Name == "" || rec.Name == q.Name) &&
+		(q.Version == "" || rec.Version == q.Version) &&
+		(q.Arch == "" || rec.Arch == q.Arch) &&
+		(!q.SignedOnly || rec.Signed) &&
+		useFlagsMatch(rec.UseFlags, q.UseFlags)
+}
+
+// SortArtifacts orders recs newest build first, newer versions first among
+// builds of the same time.
+func SortArtifacts(recs []*ArtifactRecord) {
+	sort.SliceStable(recs, func(i, j int) bool {
+		if !recs[i].BuiltAt.Equal(recs[j].BuiltAt) {
+			return recs[i].BuiltAt.After(recs[j].BuiltAt)
+		}
+		return versionLess(recs[j].Version, recs[i].Version)
+	})
+}
+
+// ArtifactIndex persists the records of the artifacts builds produced, so
+// they can be searched without going through the jobs. FileArtifactIndex
+// keeps them in one JSON file; SQLiteArtifactIndex keeps a row per artifact.
+type ArtifactIndex interface {
+	// Put adds rec, replacing the record of the same path.
+	Put(rec *ArtifactRecord) error
+	// Remove drops the record of path, if any.
+	Remove(path string) error
+	// Search returns the records q selects, sorted by SortArtifacts.
+	Search(q ArtifactQuery) ([]*ArtifactRecord, error)
+}
+
+// NewArtifactRecord describes the binary package rel in dir from its file
+// and Portage metadata: path, name, version, USE flags and size. The
+// caller fills in the rest.
+func NewArtifactRecord(dir, rel string) (*ArtifactRecord, error) {
+	path := filepath.Join(dir, rel)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	cpv := cpvFromPath(rel, strings.HasSuffix(rel, ".gpkg.tar"))
+	meta := ReadPackageMetadata(path)
+	if c := composeCPV(meta); c != "" {
+		cpv = c
+	}
+	rec := &ArtifactRecord{Path: filepath.ToSlash(rel), Size: info.Size(), UseFlags: []string{}}
+	if name, version, ok := splitCPV(cpv); ok {
+		rec.Name, rec.Version = name, version
+	} else {
+		rec.Name = cpv
+	}
+	if use := strings.Fields(meta["USE"]); len(use) > 0 {
+		rec.UseFlags = use
+	}
+	return rec, nil
+}
+
+// FileArtifactIndex is an ArtifactIndex held in memory and saved whole to
+// a JSON file after every change.
+type FileArtifactIndex struct {
+	filename string
+	mu       sync.RWMutex
+	records  map[string]*ArtifactRecord
+}
+
+// NewFileArtifactIndex opens (creating if needed) artifacts.json in dataDir.
+// An empty dataDir keeps the records in memory only.
+func NewFileArtifactIndex(dataDir string) (*FileArtifactIndex, error) {
+	idx := &FileArtifactIndex{records: map[string]*ArtifactRecord{}}
+	if dataDir == "" {
+		return idx, nil
+	}
+	if err := os.MkdirAll(dataDir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create data directory %s: %w", dataDir, err)
+	}
+	idx.filename = filepath.Join(dataDir, "artifacts.json")
+	data, err := os.ReadFile(idx.filename)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifact index: %w", err)
+	}
+	var recs []*ArtifactRecord
+	if err := json.Unmarshal(data, &recs); err != nil {
+		return nil, fmt.Errorf("failed to parse artifact index: %w", err)
+	}
+	for _, rec := range recs {
+		idx.records[rec.Path] = rec
+	}
+	return idx, nil
+}
+
+// Put adds rec, replacing the record of the same path.
+func (idx *FileArtifactIndex) Put(rec *ArtifactRecord) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	copied := *rec
+	idx.records[rec.Path] = &copied
+	return idx.saveLocked()
+}
+
+// Remove drops the record of path, if any.
+func (idx *FileArtifactIndex) Remove(path string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if _, ok := idx.records[path]; !ok {
+		return nil
+	}
+	delete(idx.records, path)
+	return idx.saveLocked()
+}
+
+// Search returns the records q selects, newest first.
+func (idx *FileArtifactIndex) Search(q ArtifactQuery) ([]*ArtifactRecord, error) {
+	idx.mu.RLock()
+	var recs []*ArtifactRecord
+	for _, rec := range idx.records {
+		if q.Matches(rec) {
+			copied := *rec
+			recs = append(recs, &copied)
+		}
+	}
+	idx.mu.RUnlock()
+	SortArtifacts(recs)
+	if q.Limit > 0 && len(recs) > q.Limit {
+		recs = recs[:q.Limit]
+	}
+	return recs, nil
+}
+
+// saveLocked writes every record to the index file, through a temp file so
+// a crash leaves the old index. Callers hold idx.mu.
+func (idx *FileArtifactIndex) saveLocked() error {
+	if idx.filename == "" {
+		return nil
+	}
+	recs := slices.SortedFunc(maps.Values(idx.records), func(a, b *ArtifactRecord) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	data, err := json.MarshalIndent(recs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal artifact index: %w", err)
+	}
+	tempFile := idx.filename + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := os.Rename(tempFile, idx.filename); err != nil {
+		_ = os.Remove(tempFile)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
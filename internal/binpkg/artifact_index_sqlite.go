@@ -0,0 +1,127 @@
+package binpkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	// Pure-Go SQLite driver, so static (CGO_ENABLED=0) builds keep working.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteArtifactSchema keeps one row per artifact. The record is stored as
+// JSON in data; the fields searches filter and sort on are copied out so
+// they can use the indexes.
+const sqliteArtifactSchema = `
+CREATE TABLE IF NOT EXISTS artifacts (
+	path     TEXT PRIMARY KEY,
+	name     TEXT NOT NULL,
+	version  TEXT NOT NULL,
+	arch     TEXT NOT NULL,
+	signed   INTEGER NOT NULL,
+	built_at INTEGER NOT NULL,
+	data     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS artifacts_name_arch ON artifacts(name, arch);
+CREATE INDEX IF NOT EXISTS artifacts_built_at ON artifacts(built_at);
+`
+
+// SQLiteArtifactIndex is an ArtifactIndex kept as rows of a SQLite
+// database, read on demand rather than held in memory.
+type SQLiteArtifactIndex struct {
+	db *sql.DB
+}
+
+// NewSQLiteArtifactIndex opens (creating if needed) artifacts.db in dataDir.
+func NewSQLiteArtifactIndex(dataDir string) (*SQLiteArtifactIndex, error) {
+	if err := os.MkdirAll(dataDir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create data directory %s: %w", dataDir, err)
+	}
+	dsn := "file:" + filepath.Join(dataDir, "artifacts.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open artifact database: %w", err)
+	}
+	// One connection serialises writers; SQLite allows only one at a time.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteArtifactSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialise artifact database: %w", err)
+	}
+	return &SQLiteArtifactIndex{db: db}, nil
+}
+
+// Close closes the database.
+func (idx *SQLiteArtifactIndex) Close() error {
+	return idx.db.Close()
+}
+
+// Put inserts or replaces the row for rec's path.
+func (idx *SQLiteArtifactIndex) Put(rec *ArtifactRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal artifact %s: %w", rec.Path, err)
+	}
+	_, err = idx.db.Exec(`INSERT INTO artifacts (path, name, version, arch, signed, built_at, data) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(path) DO UPDATE SET name = excluded.name, version = excluded.version, arch = excluded.arch,
+			signed = excluded.signed, built_at = excluded.built_at, data = excluded.data`,
+		rec.Path, rec.Name, rec.Version, rec.Arch, rec.Signed, rec.BuiltAt.UnixNano(), string(data))
+	if err != nil {
+		return fmt.Errorf("failed to save artifact %s: %w", rec.Path, err)
+	}
+	return nil
+}
+
+// Remove deletes the row for path, if any.
+func (idx *SQLiteArtifactIndex) Remove(path string) error {
+	if _, err := idx.db.Exec(`DELETE FROM artifacts WHERE path = ?`, path); err != nil {
+		return fmt.Errorf("failed to delete artifact %s: %w", path, err)
+	}
+	return nil
+}
+
+// Search returns the records q selects, newest first. Name, version, arch
+// and signature are filtered in SQL, USE flags on the decoded records.
+func (idx *SQLiteArtifactIndex) Search(q ArtifactQuery) ([]*ArtifactRecord, error) {
+	query := `SELECT data FROM artifacts WHERE 1 = 1`
+	var args []interface{}
+	for col, val := range map[string]string{"name": q.Name, "version": q.Version, "arch": q.Arch} {
+		if val != "" {
+			query += ` AND ` + col + ` = ?`
+			args = append(args, val)
+		}
+	}
+	if q.SignedOnly {
+		query += ` AND signed = 1`
+	}
+	rows, err := idx.db.Query(query+` ORDER BY built_at DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search artifacts: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var recs []*ArtifactRecord
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read artifact row: %w", err)
+		}
+		var rec ArtifactRecord
+		if err := json.Unmarshal([]byte(data), &rec); err != nil {
+			return nil, fmt.Errorf("failed to decode artifact: %w", err)
+		}
+		if q.Matches(&rec) {
+			recs = append(recs, &rec)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search artifacts: %w", err)
+	}
+	SortArtifacts(recs)
+	if q.Limit > 0 && len(recs) > q.Limit {
+		recs = recs[:q.Limit]
+	}
+	return recs, nil
+}
@@ -0,0 +1,145 @@
+package binpkg
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testArtifactIndexes returns one index of each kind, backed by t's temp dir.
+func testArtifactIndexes(t *testing.T) map[string]ArtifactIndex {
+	t.Helper()
+	file, err := NewFileArtifactIndex(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := NewSQLiteArtifactIndex(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqlite.Close() })
+	return map[string]ArtifactIndex{"file": file, "sqlite": sqlite}
+}
+
+func TestArtifactIndexSearch(t *testing.T) {
+	base := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	recs := []*ArtifactRecord{
+		{Path: "dev-lang/python/python-3.12.7-1.gpkg.tar", Name: "dev-lang/python", Version: "3.12.7", Arch: "amd64", UseFlags: []string{"ssl", "sqlite"}, Signed: true, JobID: "j1", BuiltAt: base},
+		{Path: "dev-lang/python/python-3.13.1-1.gpkg.tar", Name: "dev-lang/python", Version: "3.13.1", Arch: "amd64", UseFlags: []string{"ssl"}, JobID: "j2", BuiltAt: base.Add(time.Hour)},
+		{Path: "dev-lang/python/python-3.12.7-2.gpkg.tar", Name: "dev-lang/python", Version: "3.12.7", Arch: "arm64", UseFlags: []string{"ssl"}, Signed: true, JobID: "j3", BuiltAt: base.Add(2 * time.Hour)},
+		{Path: "app-misc/jq/jq-1.7.1-1.gpkg.tar", Name: "app-misc/jq", Version: "1.7.1", Arch: "amd64", UseFlags: []string{}, Signed: true, JobID: "j4", BuiltAt: base.Add(3 * time.Hour)},
+	}
+	tests := []struct {
+		name string
+		q    ArtifactQuery
+		want []string
+	}{
+		{"by name newest first", ArtifactQuery{Name: "dev-lang/python"}, []string{"j3", "j2", "j1"}},
+		{"by arch", ArtifactQuery{Name: "dev-lang/python", Arch: "amd64"}, []string{"j2", "j1"}},
+		{"by version", ArtifactQuery{Version: "3.12.7"}, []string{"j3", "j1"}},
+		{"signed with USE", ArtifactQuery{Name: "dev-lang/python", Arch: "amd64", UseFlags: []string{"ssl"}, SignedOnly: true}, []string{"j1"}},
+		{"USE not enabled", ArtifactQuery{UseFlags: []string{"tk"}}, nil},
+		{"limit", ArtifactQuery{Limit: 2}, []string{"j4", "j3"}},
+	}
+	for kind, idx := range testArtifactIndexes(t) {
+		for _, rec := range recs {
+			if err := idx.Put(rec); err != nil {
+				t.Fatalf("%s: Put() error = %v", kind, err)
+			}
+		}
+		for _, tt := range tests {
+			got, err := idx.Search(tt.q)
+			if err != nil {
+				t.Fatalf("%s %s: Search() error = %v", kind, tt.name, err)
+			}
+			var ids []string
+			for _, rec := range got {
+				ids = append(ids, rec.JobID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("%s %s: Search() = %v, want %v", kind, tt.name, ids, tt.want)
+			}
+		}
+	}
+}
+
+func TestArtifactIndexPutReplacesAndRemove(t *testing.T) {
+	for kind, idx := range testArtifactIndexes(t) {
+		rec := &ArtifactRecord{Path: "app-misc/jq/jq-1.7.1-1.gpkg.tar", Name: "app-misc/jq", Version: "1.7.1", JobID: "old"}
+		_ = idx.Put(rec)
+		rec.JobID = "new"
+		_ = idx.Put(rec)
+		if got, _ := idx.Search(ArtifactQuery{Name: "app-misc/jq"}); len(got) != 1 || got[0].JobID != "new" {
+			t.Errorf("%s: after rebuilding the path, Search() = %+v, want the new record only", kind, got)
+		}
+		if err := idx.Remove(rec.Path); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := idx.Search(ArtifactQuery{}); len(got) != 0 {
+			t.Errorf("%s: after Remove, Search() = %+v", kind, got)
+		}
+	}
+}
+
+func TestFileArtifactIndexReload(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := NewFileArtifactIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Put(&ArtifactRecord{Path: "app-misc/jq/jq-1.7.1-1.gpkg.tar", Name: "app-misc/jq", JobID: "j1"}); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileArtifactIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reopened.Search(ArtifactQuery{Name: "app-misc/jq"}); len(got) != 1 || got[0].JobID != "j1" {
+		t.Errorf("reopened index = %+v, want the saved record", got)
+	}
+}
+
+func TestParseArtifactQuery(t *testing.T) {
+	v, _ := url.ParseQuery("name=dev-lang/python&arch=amd64&use=ssl,sqlite&use=readline&signed=true&limit=5")
+	q, err := ParseArtifactQuery(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ArtifactQuery{Name: "dev-lang/python", Arch: "amd64", UseFlags: []string{"ssl", "sqlite", "readline"}, SignedOnly: true, Limit: 5}
+	if !reflect.DeepEqual(q, want) {
+		t.Errorf("ParseArtifactQuery() = %+v, want %+v", q, want)
+	}
+	if back, _ := ParseArtifactQuery(q.Values()); !reflect.DeepEqual(back, want) {
+		t.Errorf("Values() round trip = %+v", back)
+	}
+	for _, bad := range []string{"signed=maybe", "limit=-1", "limit=x"} {
+		v, _ := url.ParseQuery(bad)
+		if _, err := ParseArtifactQuery(v); err == nil {
+			t.Errorf("ParseArtifactQuery(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestNewArtifactRecordFromPath(t *testing.T) {
+	dir := t.TempDir()
+	rel := "app-misc/jq/jq-1.7.1-r1-2.gpkg.tar"
+	if err := os.MkdirAll(filepath.Join(dir, "app-misc/jq"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, rel), []byte("not a real gpkg"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := NewArtifactRecord(dir, rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Name != "app-misc/jq" || rec.Version != "1.7.1-r1" || rec.Size != 15 || rec.Path != rel {
+		t.Errorf("NewArtifactRecord() = %+v", rec)
+	}
+	if _, err := NewArtifactRecord(dir, "missing.gpkg.tar"); err == nil {
+		t.Error("NewArtifactRecord() of a missing file succeeded")
+	}
+}
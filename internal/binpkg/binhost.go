@@ -15,7 +15,9 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/klauspost/compress/zstd"
@@ -59,7 +61,33 @@ func GenerateIndex(pkgDir, arch string) (int, error) {
 // entries, so Store.RegenerateIndex can refresh its in-memory query view from
 // the same single scan.
 func generateIndex(pkgDir, arch string) ([]pkgEntry, error) {
-	entries, err := scanPackages(pkgDir)
+	return writeIndex(pkgDir, arch, nil)
+}
+
+// binhostIndexLocks serializes GenerateBinhostIndex per directory: the index
+// is written through a fixed temp file.
+var binhostIndexLocks sync.Map // dir -> *sync.Mutex
+
+// GenerateBinhostIndex brings the Packages index in dir up to date with the
+// binary packages in it, so dir can be served as a PORTAGE_BINHOST. Entries
+// of the existing index whose file is unchanged (same size and mtime) are
+// reused rather than hashed and parsed again, so refreshing the index after
+// each build stays cheap as dir grows. Concurrent calls for the same dir run
+// one at a time.
+func GenerateBinhostIndex(dir string) error {
+	mu, _ := binhostIndexLocks.LoadOrStore(filepath.Clean(dir), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	arch, known := readIndex(filepath.Join(dir, "Packages"))
+	_, err := writeIndex(dir, arch, known)
+	return err
+}
+
+// writeIndex scans pkgDir, reusing the entries in known (by path) whose file
+// is unchanged, and writes the Packages index.
+func writeIndex(pkgDir, arch string, known map[string]pkgEntry) ([]pkgEntry, error) {
+	entries, err := scanPackages(pkgDir, known)
 	if err != nil {
 		return nil, err
 	}
@@ -125,7 +153,8 @@ func generateIndex(pkgDir, arch string) ([]pkgEntry, error) {
 }
 
 // scanPackages walks pkgDir and returns an entry for every binary package.
-func scanPackages(pkgDir string) ([]pkgEntry, error) {
+// An entry in known for the same path, size and mtime is reused as is.
+func scanPackages(pkgDir string, known map[string]pkgEntry) ([]pkgEntry, error) {
 	var entries []pkgEntry
 
 	err := filepath.Walk(pkgDir, func(path string, info os.FileInfo, err error) error {
@@ -146,6 +175,10 @@ func scanPackages(pkgDir string) ([]pkgEntry, error) {
 		if err != nil {
 			return err
 		}
+		if e, ok := known[filepath.ToSlash(rel)]; ok && e.size == info.Size() && e.mtime == info.ModTime().Unix() {
+			entries = append(entries, e)
+			return nil
+		}
 
 		e := pkgEntry{
 			path:  filepath.ToSlash(rel),
@@ -192,6 +225,69 @@ func scanPackages(pkgDir string) ([]pkgEntry, error) {
 	return entries, nil
 }
 
+// readIndex parses a Packages index written by generateIndex, returning its
+// ARCH and its entries by path. A missing or unreadable index yields none.
+func readIndex(path string) (arch string, entries map[string]pkgEntry) {
+	f, err := os.Open(path) // #nosec G304 -- the index in our own PKGDIR.
+	if err != nil {
+		return "", nil
+	}
+	defer func() { _ = f.Close() }()
+
+	entries = map[string]pkgEntry{}
+	inPreamble := true
+	e := pkgEntry{extra: map[string]string{}}
+	flush := func() {
+		if e.path != "" && e.cpv != "" {
+			entries[e.path] = e
+		}
+		e = pkgEntry{extra: map[string]string{}}
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			if !inPreamble {
+				flush()
+			}
+			inPreamble = false
+			continue
+		}
+		k, v, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		if inPreamble {
+			if k == "ARCH" {
+				arch = v
+			}
+			continue
+		}
+		switch k {
+		case "CPV":
+			e.cpv = v
+		case "PATH":
+			e.path = v
+		case "MD5":
+			e.md5 = v
+		case "SHA1":
+			e.sha1 = v
+		case "SIZE":
+			e.size, _ = strconv.ParseInt(v, 10, 64)
+		case "MTIME":
+			e.mtime, _ = strconv.ParseInt(v, 10, 64)
+		default:
+			e.extra[k] = v
+		}
+	}
+	flush()
+	if sc.Err() != nil {
+		return arch, nil
+	}
+	return arch, entries
+}
+
 // cpvFromPath derives category/package-version from the file path relative to
 // PKGDIR. Modern gpkg layout is category/package/package-version-BUILDID.gpkg.tar;
 // legacy layout is category/package-version.tbz2. For gpkg the trailing
@@ -290,6 +386,13 @@ func fileHashes(path string) (sha1Hex, md5Hex string, err error) {
 	return hex.EncodeToString(h1.Sum(nil)), hex.EncodeToString(h5.Sum(nil)), nil
 }
 
+// ReadPackageMetadata returns the Portage metadata (CATEGORY, PF, USE,
+// repository, ...) of the binary package at path, a .gpkg.tar or a
+// .tbz2/.xpak, or nil if it cannot be read.
+func ReadPackageMetadata(path string) map[string]string {
+	return extractMetadata(path, strings.HasSuffix(path, ".gpkg.tar"))
+}
+
 // extractMetadata pulls Portage metadata (SLOT, USE, KEYWORDS, *DEPEND, ...)
 // from a binary package. Returns nil if it cannot be read. A panic while parsing
 // a single (possibly corrupt) package is recovered and treated as "no metadata"
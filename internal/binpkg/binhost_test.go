@@ -141,3 +141,100 @@ func binaryBigEndianPut(b []byte, v uint32) {
 	b[2] = byte(v >> 8)
 	b[3] = byte(v)
 }
+
+func TestGenerateBinhostIndex_Incremental(t *testing.T) {
+	dir := t.TempDir()
+	pkgDir := filepath.Join(dir, "app-misc", "jq")
+	if err := os.MkdirAll(pkgDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	jq := filepath.Join(pkgDir, "jq-1.7.gpkg.tar")
+	if err := os.WriteFile(jq, []byte("fake-gpkg-payload"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateIndex(dir, "amd64"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mark the stanza so a reuse is visible: an unchanged package is not
+	// hashed again.
+	index := filepath.Join(dir, "Packages")
+	data, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	marked := strings.Replace(string(data), "SHA1: ", "SHA1: reused", 1)
+	if err := os.WriteFile(index, []byte(marked), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	other := filepath.Join(dir, "app-misc", "tmux")
+	if err := os.MkdirAll(other, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(other, "tmux-3.4.gpkg.tar"), []byte("another-payload"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateBinhostIndex(dir); err != nil {
+		t.Fatalf("GenerateBinhostIndex failed: %v", err)
+	}
+	data, err = os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := string(data)
+	for _, want := range []string{"ARCH: amd64", "PACKAGES: 2", "SHA1: reused", "CPV: app-misc/tmux-3.4"} {
+		if !strings.Contains(idx, want) {
+			t.Errorf("index missing %q; got:\n%s", want, idx)
+		}
+	}
+
+	// A rebuilt package is hashed again, a removed one drops out.
+	if err := os.WriteFile(jq, []byte("rebuilt-gpkg-payload"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateBinhostIndex(dir); err != nil {
+		t.Fatalf("GenerateBinhostIndex failed: %v", err)
+	}
+	data, err = os.ReadFile(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx = string(data)
+	if strings.Contains(idx, "reused") || strings.Contains(idx, "tmux") {
+		t.Errorf("stale entries kept; got:\n%s", idx)
+	}
+	if !strings.Contains(idx, "PACKAGES: 1") {
+		t.Errorf("expected 1 package; got:\n%s", idx)
+	}
+}
+
+func TestGenerateBinhostIndex_Concurrent(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "app-misc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app-misc", "jq-1.7.tbz2"), []byte("payload"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- GenerateBinhostIndex(dir) }()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("GenerateBinhostIndex failed: %v", err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "Packages"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "CPV: app-misc/jq-1.7") {
+		t.Errorf("index missing package; got:\n%s", data)
+	}
+}
@@ -2,6 +2,7 @@
 package binpkg
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -49,6 +50,10 @@ type Store struct {
 	indexMu sync.Mutex
 }
 
+// ErrStoreUnwritable is returned when packages cannot be written to the
+// store's PKGDIR (missing permissions, read-only mount, ...).
+var ErrStoreUnwritable = errors.New("binpkg store is not writable")
+
 // NewStore creates a new package store. The in-memory view starts empty and is
 // filled by the first RegenerateIndex call (the server does this at startup
 // and on a refresh interval).
@@ -66,6 +71,29 @@ func NewStore(basePath string) *Store {
 	return store
 }
 
+// CheckWritable verifies the store's PKGDIR exists and accepts new files.
+func (s *Store) CheckWritable() error {
+	return CheckWritable(s.basePath)
+}
+
+// CheckWritable verifies dir exists (creating it if needed) and accepts new
+// files, returning an error wrapping ErrStoreUnwritable otherwise.
+func CheckWritable(dir string) error {
+	if dir == "" {
+		return fmt.Errorf("%w: no path configured (set BINPKG_PATH)", ErrStoreUnwritable)
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("%w: %v", ErrStoreUnwritable, err)
+	}
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrStoreUnwritable, err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return nil
+}
+
 // Query searches for a package matching the request. Version may be empty
 // ("is any version of this package available?" — the natural binhost query),
 // in which case the newest matching version is returned. Arch may be empty to
@@ -1,6 +1,7 @@
 package binpkg
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -26,6 +27,30 @@ func TestNewStore(t *testing.T) {
 	}
 }
 
+// TestCheckWritable tests the PKGDIR writability check.
+func TestCheckWritable(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "binpkgs")
+	if err := NewStore(dir).CheckWritable(); err != nil {
+		t.Fatalf("CheckWritable() on a fresh dir error = %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("CheckWritable() left %d file(s) behind", len(entries))
+	}
+
+	// A path under a regular file can never be created, even as root.
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckWritable(filepath.Join(blocker, "binpkgs")); !errors.Is(err, ErrStoreUnwritable) {
+		t.Errorf("CheckWritable() error = %v, want ErrStoreUnwritable", err)
+	}
+	if err := CheckWritable(""); !errors.Is(err, ErrStoreUnwritable) {
+		t.Errorf("CheckWritable(\"\") error = %v, want ErrStoreUnwritable", err)
+	}
+}
+
 // TestQuery tests querying for packages.
 func TestQuery(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "binpkg-test-*")
@@ -0,0 +1,135 @@
+// Package builder provides the memory admission check run before builds.
+package builder
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// memoryWaitInterval is how often a job waiting for memory checks again.
+var memoryWaitInterval = 10 * time.Second
+
+// memoryAdmission tracks the memory the builds this builder started are
+// estimated to need, so a burst of starts is judged against their estimates
+// before their compilers have allocated anything.
+type memoryAdmission struct {
+	mu       sync.Mutex
+	running  int
+	reserved uint64
+	// sysInfo reads the builder's memory; nil reads it with GetSystemInfo.
+	sysInfo func() *SystemInfo
+}
+
+// gbBytes converts a size in GB to bytes.
+func gbBytes(gb float64) uint64 {
+	if gb <= 0 {
+		return 0
+	}
+	return uint64(gb * (1 << 30))
+}
+
+// memoryEstimate returns the memory the build of job is estimated to need:
+// the largest PACKAGE_MEMORY_GB entry of the packages it builds, or
+// BUILD_MEMORY_GB for packages without one.
+func (lb *LocalBuilder) memoryEstimate(job *BuildJob) uint64 {
+	targets := []string{job.Request.PackageName}
+	if bundle := job.Request.ConfigBundle; bundle != nil && bundle.Packages != nil {
+		for _, pkg := range bundle.Packages.Packages {
+			targets = append(targets, pkg.Atom)
+		}
+	}
+	need := gbBytes(lb.cfg.BuildMemoryGB)
+	for _, target := range targets {
+		if gb, ok := lb.cfg.PackageMemoryGB[atomCP(target)]; ok {
+			need = max(need, gbBytes(gb))
+		}
+	}
+	return need
+}
+
+// admitBuild reserves need bytes for a build about to start and returns the
+// function releasing them once it ends, or an error saying why starting it
+// would likely exhaust the builder's memory: the memory free now, or the
+// memory left by the estimates of the builds already running, would not
+// cover need plus MEMORY_HEADROOM_GB. A build is always admitted while no
+// other runs, so one estimated above the builder's memory still builds.
+func (lb *LocalBuilder) admitBuild(need uint64) (func(), error) {
+	a := &lb.admission
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running > 0 {
+		if err := a.checkMemory(need, gbBytes(lb.cfg.MemoryHeadroomGB)); err != nil {
+			return nil, err
+		}
+	}
+	a.running++
+	a.reserved += need
+	return func() {
+		a.mu.Lock()
+		a.running--
+		a.reserved -= need
+		a.mu.Unlock()
+	}, nil
+}
+
+// checkMemory returns an error when need plus headroom exceeds the memory
+// free or the memory the running builds' reservations leave. A builder
+// whose memory cannot be read is not held against the build. a.mu is held.
+func (a *memoryAdmission) checkMemory(need, headroom uint64) error {
+	sysInfo := a.sysInfo
+	if sysInfo == nil {
+		sysInfo = GetSystemInfo
+	}
+	info := sysInfo()
+	if info.MemoryTotal == 0 {
+		return nil
+	}
+	free := info.MemoryTotal - min(info.MemoryUsed, info.MemoryTotal)
+	if free < need+headroom {
+		return fmt.Errorf("insufficient memory: %s free, the build is estimated to need %s and %s must stay free",
+			formatGB(free), formatGB(need), formatGB(headroom))
+	}
+	if a.reserved+need+headroom > info.MemoryTotal {
+		return fmt.Errorf("insufficient memory: the %d builds running are estimated to need %s of %s, the build %s more and %s must stay free",
+			a.running, formatGB(a.reserved), formatGB(info.MemoryTotal), formatGB(need), formatGB(headroom))
+	}
+	return nil
+}
+
+// awaitMemory holds a queued job until starting its build would likely not
+// exhaust the builder's memory and reports whether the worker should build
+// it, returning then the function to call once the build ends. The job is
+// dropped when cancelled or failed by a drain meanwhile. With
+// MEMORY_HEADROOM_GB unset every job starts at once.
+func (lb *LocalBuilder) awaitMemory(job *BuildJob) (func(), bool) {
+	if lb.cfg == nil || lb.cfg.MemoryHeadroomGB <= 0 {
+		return func() {}, true
+	}
+	need := lb.memoryEstimate(job)
+	waiting := false
+	for {
+		release, err := lb.admitBuild(need)
+		if err == nil {
+			if waiting {
+				job.appendLog("[memory] memory freed; starting the build\n")
+				job.setMetadata("waiting_for_memory", false)
+			}
+			return release, true
+		}
+		if !waiting {
+			waiting = true
+			log.Printf("Job %s waiting: %v", job.ref(), err)
+			job.appendLog("[memory] " + err.Error() + "; waiting for memory to be freed\n")
+			job.setMetadata("waiting_for_memory", true)
+		}
+		time.Sleep(memoryWaitInterval)
+		job.mu.Lock()
+		status := job.Status
+		job.mu.Unlock()
+		if status != "queued" {
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// memoryBuilder returns a builder with MEMORY_HEADROOM_GB=1 on a host with
+// 16 GB of memory, usedGB of it in use.
+func memoryBuilder(usedGB float64) *LocalBuilder {
+	lb := &LocalBuilder{
+		cfg: &config.BuilderConfig{
+			MemoryHeadroomGB: 1,
+			BuildMemoryGB:    2,
+			PackageMemoryGB:  map[string]float64{"www-client/chromium": 10},
+		},
+		jobs: map[string]*BuildJob{},
+	}
+	lb.admission.sysInfo = func() *SystemInfo {
+		return &SystemInfo{MemoryTotal: 16 << 30, MemoryUsed: gbBytes(usedGB)}
+	}
+	return lb
+}
+
+func TestMemoryEstimate(t *testing.T) {
+	t.Parallel()
+
+	lb := memoryBuilder(0)
+	for _, tt := range []struct {
+		req  *LocalBuildRequest
+		want float64
+	}{
+		{&LocalBuildRequest{PackageName: "app-misc/jq"}, 2},
+		{&LocalBuildRequest{PackageName: ">=www-client/chromium-120:0"}, 10},
+		{&LocalBuildRequest{PackageName: "app-misc/jq", ConfigBundle: &ConfigBundle{Packages: &BuildPackageSpec{Packages: []PackageSpec{
+			{Atom: "app-misc/jq"}, {Atom: "www-client/chromium"},
+		}}}}, 10},
+	} {
+		if got := lb.memoryEstimate(&BuildJob{Request: tt.req}); got != gbBytes(tt.want) {
+			t.Errorf("memoryEstimate(%s) = %s, want %v GB", tt.req.PackageName, formatGB(got), tt.want)
+		}
+	}
+}
+
+func TestAdmitBuild(t *testing.T) {
+	t.Parallel()
+
+	lb := memoryBuilder(4)
+	// The first build starts whatever its estimate.
+	releaseFirst, err := lb.admitBuild(gbBytes(20))
+	if err != nil {
+		t.Fatalf("first build: %v", err)
+	}
+	releaseFirst()
+
+	releaseFirst, err = lb.admitBuild(gbBytes(10))
+	if err != nil {
+		t.Fatalf("first build: %v", err)
+	}
+	// 12 GB are free, but the running build is estimated to take 10 of 16.
+	if _, err := lb.admitBuild(gbBytes(6)); err == nil || !strings.Contains(err.Error(), "builds running") {
+		t.Errorf("admitBuild over the reservations = %v", err)
+	}
+	release, err := lb.admitBuild(gbBytes(2))
+	if err != nil {
+		t.Fatalf("a build that fits: %v", err)
+	}
+	release()
+	releaseFirst()
+
+	// Memory in use by others counts too.
+	releaseFirst, _ = lb.admitBuild(gbBytes(2))
+	defer releaseFirst()
+	lb.admission.sysInfo = func() *SystemInfo { return &SystemInfo{MemoryTotal: 16 << 30, MemoryUsed: 14 << 30} }
+	if _, err := lb.admitBuild(gbBytes(2)); err == nil || !strings.Contains(err.Error(), "2.0 GB free") {
+		t.Errorf("admitBuild with 2 GB free = %v", err)
+	}
+}
+
+func TestAwaitMemoryWaitsWhileQueued(t *testing.T) {
+	defer func(d time.Duration) { memoryWaitInterval = d }(memoryWaitInterval)
+	memoryWaitInterval = 10 * time.Millisecond
+
+	lb := memoryBuilder(0)
+	releaseRunning, err := lb.admitBuild(gbBytes(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &BuildJob{ID: "j", Status: "queued", Request: &LocalBuildRequest{PackageName: "www-client/chromium"}}
+	lb.jobs[job.ID] = job
+	started := make(chan bool)
+	go func() {
+		release, ok := lb.awaitMemory(job)
+		if ok {
+			release()
+		}
+		started <- ok
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	job.mu.Lock()
+	waiting := job.Metadata["waiting_for_memory"]
+	job.mu.Unlock()
+	if waiting != true {
+		t.Fatalf("waiting_for_memory = %v, want true", waiting)
+	}
+	releaseRunning()
+	if !<-started {
+		t.Fatal("the job was not started once memory was freed")
+	}
+	if !strings.Contains(job.Log, "[memory] memory freed") || job.Metadata["waiting_for_memory"] != false {
+		t.Errorf("log %q, metadata %v", job.Log, job.Metadata)
+	}
+
+	// A job cancelled while waiting is dropped.
+	releaseRunning, _ = lb.admitBuild(gbBytes(10))
+	defer releaseRunning()
+	cancelled := &BuildJob{ID: "k", Status: "queued", Request: &LocalBuildRequest{PackageName: "www-client/chromium"}}
+	lb.jobs[cancelled.ID] = cancelled
+	go func() {
+		_, ok := lb.awaitMemory(cancelled)
+		started <- ok
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if !lb.cancelQueued(cancelled, "build cancelled before it started") {
+		t.Fatal("the waiting job could not be cancelled")
+	}
+	if <-started {
+		t.Error("a job cancelled while waiting for memory was started")
+	}
+
+	lb.cfg.MemoryHeadroomGB = 0
+	if _, ok := lb.awaitMemory(&BuildJob{Status: "queued", Request: &LocalBuildRequest{PackageName: "www-client/chromium"}}); !ok {
+		t.Error("with the check disabled the job was not started")
+	}
+}
@@ -0,0 +1,134 @@
+// Package builder provides server/builder API version negotiation.
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// APIVersion is the version of the server↔builder protocol this binary speaks:
+// the LocalBuildRequest the server forwards and the job/status documents the
+// builder returns. Bump it when a change would make an older peer misread a
+// request, e.g. a new field a builder must honour rather than ignore.
+const APIVersion = 1
+
+// MinBuilderAPIVersion is the oldest builder API version the server still
+// routes jobs to. Builders that predate negotiation report no version (0).
+const MinBuilderAPIVersion = 1
+
+// ErrIncompatibleBuilder is returned when a builder's API version is below
+// MinBuilderAPIVersion; jobs are not routed to such builders.
+var ErrIncompatibleBuilder = errors.New("builder API version is incompatible")
+
+// builderAPIVersionTTL is how long the API version a builder reported is
+// trusted before its status endpoint is queried again. Heartbeats refresh it.
+const builderAPIVersionTTL = 5 * time.Minute
+
+// builderAPIVersion is the API version a builder reported and when.
+type builderAPIVersion struct {
+	version int
+	at      time.Time
+}
+
+// builderAPICompatible reports whether the server may route jobs to a builder
+// speaking apiVersion.
+func builderAPICompatible(apiVersion int) bool {
+	return apiVersion >= MinBuilderAPIVersion
+}
+
+// checkRequestAPIVersion rejects a forwarded request from a server newer than
+// this builder, which may carry fields the builder would silently ignore.
+// Requests without a version (direct clients) are accepted.
+func checkRequestAPIVersion(v int) error {
+	if v > APIVersion {
+		return fmt.Errorf("request uses API version %d but this builder supports up to %d; upgrade the builder", v, APIVersion)
+	}
+	return nil
+}
+
+// RecordBuilderAPIVersion remembers the API version a builder reported for
+// addr, from its registration, heartbeat or status endpoint. Builders that
+// predate negotiation report 0.
+func (m *Manager) RecordBuilderAPIVersion(addr string, apiVersion int) {
+	if addr == "" {
+		return
+	}
+	key := config.CanonicalBuilderURL(addr)
+	m.builderIDsMu.Lock()
+	defer m.builderIDsMu.Unlock()
+	if m.builderAPIVersions == nil {
+		m.builderAPIVersions = make(map[string]builderAPIVersion)
+	}
+	m.builderAPIVersions[key] = builderAPIVersion{version: apiVersion, at: time.Now()}
+}
+
+// knownBuilderAPIVersion returns the API version last learned for addr, and
+// false when none was learned within builderAPIVersionTTL.
+func (m *Manager) knownBuilderAPIVersion(addr string) (int, bool) {
+	m.builderIDsMu.RLock()
+	defer m.builderIDsMu.RUnlock()
+	v, ok := m.builderAPIVersions[config.CanonicalBuilderURL(addr)]
+	if !ok || time.Since(v.at) > builderAPIVersionTTL {
+		return 0, false
+	}
+	return v.version, true
+}
+
+// compatibleBuilders drops the builders known to speak an API older than
+// MinBuilderAPIVersion from builders, keeping their order. Builders whose
+// version is not known yet are kept; checkBuilderAPI vets them on submit.
+// It fails when every builder is incompatible.
+func (m *Manager) compatibleBuilders(builders []string) ([]string, error) {
+	compatible := make([]string, 0, len(builders))
+	for _, addr := range builders {
+		if v, ok := m.knownBuilderAPIVersion(addr); ok && !builderAPICompatible(v) {
+			continue
+		}
+		compatible = append(compatible, addr)
+	}
+	if len(compatible) == 0 && len(builders) > 0 {
+		return nil, fmt.Errorf("%w: all %d remote builders speak an API older than version %d",
+			ErrIncompatibleBuilder, len(builders), MinBuilderAPIVersion)
+	}
+	return compatible, nil
+}
+
+// checkBuilderAPI returns ErrIncompatibleBuilder if the builder at baseURL
+// speaks an API older than MinBuilderAPIVersion. The version it last
+// reported is used while fresh; otherwise its status endpoint is probed.
+func (m *Manager) checkBuilderAPI(baseURL string) error {
+	if v, ok := m.knownBuilderAPIVersion(baseURL); ok {
+		if !builderAPICompatible(v) {
+			return fmt.Errorf("%w: %s speaks API version %d, server requires at least %d",
+				ErrIncompatibleBuilder, baseURL, v, MinBuilderAPIVersion)
+		}
+		return nil
+	}
+
+	resp, err := m.getFromBuilder(baseURL + "/api/v1/status")
+	if err != nil {
+		return fmt.Errorf("failed to query builder status: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("builder status returned %d", resp.StatusCode)
+	}
+
+	var status struct {
+		APIVersion int `json:"api_version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return fmt.Errorf("failed to parse builder status: %w", err)
+	}
+	m.RecordBuilderAPIVersion(baseURL, status.APIVersion)
+	if !builderAPICompatible(status.APIVersion) {
+		return fmt.Errorf("%w: %s speaks API version %d, server requires at least %d",
+			ErrIncompatibleBuilder, baseURL, status.APIVersion, MinBuilderAPIVersion)
+	}
+	return nil
+}
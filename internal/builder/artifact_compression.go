@@ -0,0 +1,209 @@
+// Package builder provides the optional compressed form of stored artifacts.
+package builder
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Values of ARTIFACT_COMPRESSION other than "none", which are also the
+// Content-Encoding the compressed form is served with.
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// compressedExts are the extensions of the compressed form of an artifact,
+// written next to it, by encoding.
+var compressedExts = map[string]string{compressionGzip: ".gz", compressionZstd: ".zst"}
+
+// Job metadata describing the compressed forms of a job's artifacts:
+// their encoding, and the SHA-256 digests of each artifact (relative to the
+// artifact dir) compressed and as built.
+const (
+	compressionKey      = "compression"
+	compressedSHA256Key = "compressed_sha256"
+	originalSHA256Key   = "original_sha256"
+)
+
+// acceptArtifactEncodings is the Accept-Encoding of artifact downloads whose
+// body is read through decodeArtifactBody.
+const acceptArtifactEncodings = "zstd, gzip"
+
+// artifactCompression returns the encoding artifacts are compressed with,
+// or "" when they are not.
+func (lb *LocalBuilder) artifactCompression() string {
+	if lb.cfg == nil {
+		return ""
+	}
+	if _, ok := compressedExts[lb.cfg.ArtifactCompression]; ok {
+		return lb.cfg.ArtifactCompression
+	}
+	return ""
+}
+
+// compressFile writes src compressed with enc at level (0 for the codec's
+// default) to dst, through a temp file so dst is never half-written.
+func compressFile(src, dst, enc string, level int) error {
+	in, err := os.Open(src) // #nosec G304 -- an artifact in our own artifact dir.
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".compress-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() { _ = os.Remove(tmpName) }()
+
+	var w io.WriteCloser
+	switch enc {
+	case compressionGzip:
+		if level < gzip.BestSpeed || level > gzip.BestCompression {
+			level = gzip.DefaultCompression
+		}
+		w, err = gzip.NewWriterLevel(tmp, level)
+	case compressionZstd:
+		var opts []zstd.EOption
+		if level > 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		w, err = zstd.NewWriter(tmp, opts...)
+	default:
+		err = fmt.Errorf("unsupported compression %q", enc)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		_ = w.Close()
+		_ = tmp.Close()
+		return fmt.Errorf("failed to compress %s: %w", src, err)
+	}
+	if err := w.Close(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to compress %s: %w", src, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, 0644); err != nil { // #nosec G302 -- binpkgs are public.
+		return err
+	}
+	return os.Rename(tmpName, dst)
+}
+
+// compressArtifacts writes the compressed form of each artifact rels next
+// to it when ARTIFACT_COMPRESSION is set, with checksum files and a
+// detached signature of its own, and records the digests of both forms in
+// the job metadata. A failure is logged and leaves the artifact
+// uncompressed; it does not fail the build.
+func (lb *LocalBuilder) compressArtifacts(job *BuildJob, rels []string) {
+	enc := lb.artifactCompression()
+	if enc == "" {
+		return
+	}
+	compressed, original := map[string]string{}, map[string]string{}
+	for _, rel := range rels {
+		path := filepath.Join(lb.artifactDir, rel)
+		orig, err := readArtifactDigests(path)
+		if err != nil {
+			log.Printf("Warning: failed to compress %s for job %s: %v", rel, job.ref(), err)
+			continue
+		}
+		cpath := path + compressedExts[enc]
+		if err := compressFile(path, cpath, enc, lb.cfg.ArtifactCompressionLevel); err != nil {
+			log.Printf("Warning: failed to compress %s for job %s: %v", rel, job.ref(), err)
+			job.appendLog(fmt.Sprintf("Warning: failed to compress %s: %v\n", rel, err))
+			continue
+		}
+		digests, err := writeChecksumFiles(cpath)
+		if err != nil {
+			log.Printf("Warning: failed to write checksums for %s: %v", cpath, err)
+			_ = os.Remove(cpath)
+			continue
+		}
+		if lb.signer != nil && lb.signer.IsEnabled() && !lb.skipExpiredSigning(job, rel+compressedExts[enc]) {
+			if err := lb.signer.SignPackage(cpath); err != nil {
+				log.Printf("Warning: failed to sign %s: %v", cpath, err)
+			}
+		}
+		compressed[rel], original[rel] = digests.SHA256, orig.SHA256
+	}
+	if len(compressed) == 0 {
+		return
+	}
+	job.setMetadata(compressionKey, enc)
+	job.setMetadata(compressedSHA256Key, compressed)
+	job.setMetadata(originalSHA256Key, original)
+}
+
+// compressedForm returns the compressed form of the artifact rel of job and
+// its encoding, or "" when it has none or the file there no longer is the
+// one job produced.
+func (lb *LocalBuilder) compressedForm(job *BuildJob, rel string) (string, string) {
+	job.mu.Lock()
+	enc, _ := job.Metadata[compressionKey].(string)
+	want := stringMap(job.Metadata[compressedSHA256Key])[rel]
+	job.mu.Unlock()
+	ext, ok := compressedExts[enc]
+	if !ok || want == "" {
+		return "", ""
+	}
+	cpath := filepath.Join(lb.artifactDir, rel) + ext
+	if d, err := readArtifactDigests(cpath); err != nil || d.SHA256 != want {
+		return "", ""
+	}
+	return cpath, enc
+}
+
+// GetCompressedArtifactPath returns the compressed form of the artifact rel
+// of a job, or of its primary artifact when rel is empty, and the
+// Content-Encoding to serve it with. ok is false when the job or artifact
+// is unknown or was not compressed.
+func (lb *LocalBuilder) GetCompressedArtifactPath(jobID, rel string) (path, encoding string, ok bool) {
+	job, exists := lb.findJob(jobID)
+	if !exists {
+		return "", "", false
+	}
+	if rel == "" {
+		_, artifactURL := job.snapshot()
+		r, err := filepath.Rel(lb.artifactDir, artifactURL)
+		if err != nil || !filepath.IsLocal(r) {
+			return "", "", false
+		}
+		rel = r
+	}
+	path, encoding = lb.compressedForm(job, rel)
+	return path, encoding, path != ""
+}
+
+// decodeArtifactBody returns the body of an artifact download with its
+// Content-Encoding, set when the builder served the compressed form,
+// undone. The caller closes both it and resp.Body.
+func decodeArtifactBody(resp *http.Response) (io.ReadCloser, error) {
+	switch enc := resp.Header.Get("Content-Encoding"); enc {
+	case "", "identity":
+		return io.NopCloser(resp.Body), nil
+	case compressionGzip:
+		return gzip.NewReader(resp.Body)
+	case compressionZstd:
+		zr, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", enc)
+	}
+}
@@ -0,0 +1,171 @@
+package builder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/slchris/portage-engine/internal/storage"
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestCompressArtifacts(t *testing.T) {
+	t.Parallel()
+
+	for enc, decode := range map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	} {
+		t.Run(enc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			rel := "app-misc/jq-1.7-1.gpkg.tar"
+			path := writeBuiltArtifact(t, dir, rel, "payload")
+			job := &BuildJob{ID: "j1", Status: "success", ArtifactURL: path, Artifacts: []string{rel}}
+			lb := &LocalBuilder{
+				artifactDir: dir,
+				cfg:         &config.BuilderConfig{ArtifactCompression: enc, ArtifactCompressionLevel: 3},
+				jobs:        map[string]*BuildJob{"j1": job},
+			}
+
+			lb.compressArtifacts(job, job.Artifacts)
+
+			cpath, got, ok := lb.GetCompressedArtifactPath("j1", "")
+			if !ok || got != enc || cpath != path+compressedExts[enc] {
+				t.Fatalf("GetCompressedArtifactPath = %q, %q, %v", cpath, got, ok)
+			}
+			f, err := os.Open(cpath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = f.Close() }()
+			r, err := decode(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := io.ReadAll(r); string(data) != "payload" {
+				t.Errorf("compressed form decodes to %q", data)
+			}
+
+			stored, err := hashArtifact(cpath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := readChecksumFile(cpath+sha256FileExt, sha256.New()); d != stored.SHA256 {
+				t.Errorf("checksum file of the compressed form = %q, want %q", d, stored.SHA256)
+			}
+			if got := stringMap(job.Metadata[compressedSHA256Key])[rel]; got != stored.SHA256 {
+				t.Errorf("compressed digest = %q, want %q", got, stored.SHA256)
+			}
+			if got := stringMap(job.Metadata[originalSHA256Key])[rel]; got != payloadSHA256 {
+				t.Errorf("original digest = %q, want %q", got, payloadSHA256)
+			}
+
+			// A rebuild that replaced the compressed form is not served for
+			// this job.
+			if err := os.WriteFile(cpath, []byte("other"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := writeChecksumFiles(cpath); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, ok := lb.GetCompressedArtifactPath("j1", rel); ok {
+				t.Error("a compressed form the job did not produce was served")
+			}
+		})
+	}
+}
+
+func TestCompressArtifactsDisabled(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	rel := "app-misc/jq-1.7-1.gpkg.tar"
+	path := writeBuiltArtifact(t, dir, rel, "payload")
+	job := &BuildJob{ID: "j1", Artifacts: []string{rel}}
+	lb := &LocalBuilder{artifactDir: dir, cfg: &config.BuilderConfig{ArtifactCompression: "none"}}
+
+	lb.compressArtifacts(job, job.Artifacts)
+
+	for _, ext := range compressedExts {
+		if artifactExists(path + ext) {
+			t.Errorf("%s written with compression disabled", path+ext)
+		}
+	}
+	if job.Metadata[compressionKey] != nil {
+		t.Errorf("compression = %v, want none recorded", job.Metadata[compressionKey])
+	}
+}
+
+func TestFetchArtifactDecodesCompressedForm(t *testing.T) {
+	var compressed bytes.Buffer
+	zw, err := zstd.NewWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = zw.Write([]byte("fake gpkg bytes"))
+	_ = zw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != acceptArtifactEncodings {
+			t.Errorf("Accept-Encoding = %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "zstd")
+		_, _ = w.Write(compressed.Bytes())
+	}))
+	defer srv.Close()
+
+	binhost := t.TempDir()
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 1, BinpkgPath: binhost})
+	defer mgr.Shutdown()
+
+	dest, _, err := mgr.fetchArtifactRelToBinhost(srv.URL, "rjob-1", "app-misc/jq-1.7-1.gpkg.tar")
+	if err != nil {
+		t.Fatalf("fetchArtifactRelToBinhost: %v", err)
+	}
+	if dest != filepath.Join(binhost, "app-misc", "jq-1.7-1.gpkg.tar") {
+		t.Errorf("dest = %q", dest)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "fake gpkg bytes" {
+		t.Errorf("stored %q, want the decoded package", data)
+	}
+}
+
+func TestUploadCompressedArtifact(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	rel := "app-misc/jq-1.7-1.gpkg.tar"
+	path := writeBuiltArtifact(t, filepath.Join(dir, "artifacts"), rel, "payload")
+	remote, err := storage.NewLocalStorage(filepath.Join(dir, "remote"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := &LocalBuilder{
+		artifactDir:   filepath.Join(dir, "artifacts"),
+		cfg:           &config.BuilderConfig{ArtifactCompression: "zstd"},
+		storageUpload: &StorageUploader{storage: remote, enabled: true},
+	}
+	job := &BuildJob{ID: "j1", Artifacts: []string{rel}}
+	lb.compressArtifacts(job, job.Artifacts)
+
+	lb.uploadArtifact(job, path)
+
+	blob := BlobPath(payloadSHA256) + ".zst"
+	for _, name := range []string{blob, blob + sha256FileExt} {
+		if !artifactExists(filepath.Join(dir, "remote", name)) {
+			t.Errorf("%s not uploaded", name)
+		}
+	}
+	if artifactExists(filepath.Join(dir, "remote", BlobPath(payloadSHA256))) {
+		t.Error("the uncompressed package was uploaded too")
+	}
+}
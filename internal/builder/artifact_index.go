@@ -0,0 +1,104 @@
+// Package builder provides the searchable index of produced artifacts.
+package builder
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/slchris/portage-engine/internal/binpkg"
+	"github.com/slchris/portage-engine/internal/gpg"
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// initArtifactIndex opens the artifact index next to the job store: a
+// SQLite database with JOB_STORE=sqlite, else a JSON file, and in memory
+// only when persistence is disabled or the store cannot be opened.
+func initArtifactIndex(cfg *config.BuilderConfig) binpkg.ArtifactIndex {
+	memory, _ := binpkg.NewFileArtifactIndex("")
+	if cfg == nil || !cfg.PersistenceEnabled {
+		return memory
+	}
+
+	dataDir := cfg.DataDir
+	if dataDir == "" {
+		dataDir = "/var/lib/portage-engine"
+	}
+
+	if cfg.JobStore == jobStoreSQLite {
+		idx, err := binpkg.NewSQLiteArtifactIndex(dataDir)
+		if err != nil {
+			log.Printf("Failed to initialize SQLite artifact index: %v (index kept in memory)", err)
+			return memory
+		}
+		return idx
+	}
+
+	idx, err := binpkg.NewFileArtifactIndex(dataDir)
+	if err != nil {
+		log.Printf("Failed to initialize artifact index: %v (index kept in memory)", err)
+		return memory
+	}
+	return idx
+}
+
+// indexArtifacts records the artifacts rels (relative to the artifact dir)
+// job just produced in the artifact index, after they were signed and
+// checksummed. A failure is logged but does not fail the build.
+func (lb *LocalBuilder) indexArtifacts(job *BuildJob, rels []string) {
+	if lb.artifactIndex == nil {
+		return
+	}
+	arch := jobTargetArch(job.Request)
+	if arch == "" {
+		arch = lb.architecture
+	}
+	now := time.Now()
+	for _, rel := range rels {
+		path := filepath.Join(lb.artifactDir, rel)
+		rec, err := binpkg.NewArtifactRecord(lb.artifactDir, rel)
+		if err != nil {
+			log.Printf("Warning: failed to index artifact %s of job %s: %v", rel, job.ref(), err)
+			continue
+		}
+		if digests, err := readArtifactDigests(path); err == nil {
+			rec.SHA256, rec.SHA512 = digests.SHA256, digests.SHA512
+		}
+		rec.Arch = arch
+		rec.Signed = artifactSigned(path)
+		rec.JobID = job.ID
+		rec.BuiltAt = now
+		if err := lb.artifactIndex.Put(rec); err != nil {
+			log.Printf("Warning: failed to index artifact %s of job %s: %v", rel, job.ref(), err)
+		}
+	}
+}
+
+// artifactSigned reports whether the package at path carries an embedded
+// signature or has a detached one next to it.
+func artifactSigned(path string) bool {
+	if gpkgIsSigned(path) {
+		return true
+	}
+	_, err := os.Stat(path + gpg.SignatureFileExt)
+	return err == nil
+}
+
+// unindexArtifact drops the artifact rel from the index once it is deleted.
+func (lb *LocalBuilder) unindexArtifact(rel string) {
+	if lb.artifactIndex == nil {
+		return
+	}
+	if err := lb.artifactIndex.Remove(filepath.ToSlash(rel)); err != nil {
+		log.Printf("Warning: failed to drop artifact %s from the index: %v", rel, err)
+	}
+}
+
+// SearchArtifacts returns the indexed artifacts q selects, newest first.
+func (lb *LocalBuilder) SearchArtifacts(q binpkg.ArtifactQuery) ([]*binpkg.ArtifactRecord, error) {
+	if lb.artifactIndex == nil {
+		return nil, nil
+	}
+	return lb.artifactIndex.Search(q)
+}
@@ -0,0 +1,32 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/slchris/portage-engine/internal/binpkg"
+)
+
+func TestIndexArtifacts(t *testing.T) {
+	t.Parallel()
+
+	idx, _ := binpkg.NewFileArtifactIndex("")
+	lb := &LocalBuilder{architecture: "amd64", artifactDir: t.TempDir(), artifactIndex: idx}
+	rel := "dev-lang/python/python-3.12.7-1.gpkg.tar"
+	writeTestArtifact(t, lb.artifactDir, rel)
+	job := &BuildJob{ID: "j1", Request: &LocalBuildRequest{PackageName: "dev-lang/python", Arch: "arm64"}}
+
+	lb.indexArtifacts(job, []string{rel})
+	got, err := lb.SearchArtifacts(binpkg.ArtifactQuery{Name: "dev-lang/python", SignedOnly: true})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("SearchArtifacts() = %v, %v; want the signed artifact", got, err)
+	}
+	rec := got[0]
+	if rec.Version != "3.12.7" || rec.Arch != "arm64" || rec.JobID != "j1" || rec.Size != 4 || rec.SHA256 == "" || rec.SHA512 == "" || rec.BuiltAt.IsZero() {
+		t.Errorf("record = %+v", rec)
+	}
+
+	lb.unindexArtifact(rel)
+	if got, _ := lb.SearchArtifacts(binpkg.ArtifactQuery{}); len(got) != 0 {
+		t.Errorf("deleted artifact still indexed: %+v", got)
+	}
+}
@@ -0,0 +1,136 @@
+// Package builder provides validation of Portage package atoms.
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The pieces of Portage's atom grammar (PMS, "Dependency specification
+// format"): [OP]category/package[-version][:slot][::repo][use-deps].
+var (
+	atomCategoryPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9+_.-]*$`)
+	atomNamePattern     = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9+_-]*$`)
+	atomRepoPattern     = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*$`)
+	atomSlotPattern     = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9+_.-]*(/[A-Za-z0-9_][A-Za-z0-9+_.-]*)?=?$`)
+	atomUseDepPattern   = regexp.MustCompile(`^([!-]?)([A-Za-z0-9][A-Za-z0-9+_@-]*)(\([+-]\))?([?=]?)$`)
+
+	// A package name followed by a version, e.g. "python-3.11.4_rc1-r2".
+	atomVersionedPattern = regexp.MustCompile(`^(.+)-([0-9]+(?:\.[0-9]+)*[a-z]?(?:_(?:alpha|beta|pre|rc|p)[0-9]*)*(?:-r[0-9]+)?)$`)
+)
+
+// atomOperators are the version operators an atom may start with, longest
+// first so "<=" is not read as "<".
+var atomOperators = []string{"<=", ">=", "<", ">", "=", "~"}
+
+// ValidateAtom checks a package atom against Portage's atom grammar: a
+// category/package name, optionally prefixed by a version operator (=, >=,
+// >, <=, <, ~) with a version (=category/package-1.2*), followed by an
+// optional :slot (with /subslot and = or *), ::repo and [use,deps]. Package
+// sets (@world) are not atoms; blockers cannot be built. The error says what
+// is wrong.
+func ValidateAtom(atom string) error {
+	if err := validateAtom(atom); err != nil {
+		return fmt.Errorf("invalid package atom %q: %w", atom, err)
+	}
+	return nil
+}
+
+func validateAtom(atom string) error {
+	if atom == "" {
+		return fmt.Errorf("empty atom")
+	}
+	if strings.HasPrefix(atom, "!") {
+		return fmt.Errorf("blockers cannot be built")
+	}
+	rest := atom
+
+	if i := strings.Index(rest, "["); i >= 0 {
+		if !strings.HasSuffix(rest, "]") || strings.Count(rest, "[") != 1 {
+			return fmt.Errorf("USE dependencies must be one [...] group at the end")
+		}
+		for _, dep := range strings.Split(rest[i+1:len(rest)-1], ",") {
+			if err := validateUseDep(dep); err != nil {
+				return err
+			}
+		}
+		rest = rest[:i]
+	}
+	if i := strings.Index(rest, "::"); i >= 0 {
+		if repo := rest[i+2:]; !atomRepoPattern.MatchString(repo) {
+			return fmt.Errorf("invalid repository %q after ::", repo)
+		}
+		rest = rest[:i]
+	}
+	if i := strings.Index(rest, ":"); i >= 0 {
+		if slot := rest[i+1:]; slot != "*" && slot != "=" && !atomSlotPattern.MatchString(slot) {
+			return fmt.Errorf("invalid slot %q after :", slot)
+		}
+		rest = rest[:i]
+	}
+
+	op := ""
+	for _, o := range atomOperators {
+		if strings.HasPrefix(rest, o) {
+			op = o
+			break
+		}
+	}
+	rest = rest[len(op):]
+
+	category, pn, ok := strings.Cut(rest, "/")
+	if !ok {
+		return fmt.Errorf("missing category, want category/package")
+	}
+	if !atomCategoryPattern.MatchString(category) {
+		return fmt.Errorf("invalid category %q", category)
+	}
+
+	if strings.HasSuffix(pn, "*") {
+		if op != "=" {
+			return fmt.Errorf("a version wildcard (*) needs the = operator")
+		}
+		pn = strings.TrimSuffix(pn, "*")
+	}
+	if op != "" {
+		m := atomVersionedPattern.FindStringSubmatch(pn)
+		if m == nil {
+			return fmt.Errorf("operator %s needs a version, e.g. %s%s/%s-1.0", op, op, category, pn)
+		}
+		if op == "~" && strings.Contains(m[2], "-r") {
+			return fmt.Errorf("operator ~ matches every revision and takes none")
+		}
+		pn = m[1]
+	}
+	if op == "" && atomVersionedPattern.MatchString(pn) {
+		return fmt.Errorf("a version needs an operator, e.g. =%s", rest)
+	}
+	// A name may not itself end in what looks like a version (PMS 3.1.2).
+	if !atomNamePattern.MatchString(pn) || atomVersionedPattern.MatchString(pn) {
+		return fmt.Errorf("invalid package name %q", pn)
+	}
+	return nil
+}
+
+// validateUseDep checks one entry of an atom's [use,deps]: flag, -flag,
+// flag?, !flag?, flag= or !flag=, each optionally with a (+) or (-) default.
+func validateUseDep(dep string) error {
+	m := atomUseDepPattern.FindStringSubmatch(dep)
+	if m == nil {
+		return fmt.Errorf("invalid USE dependency %q", dep)
+	}
+	switch prefix, suffix := m[1], m[4]; {
+	case prefix == "!" && suffix == "":
+		return fmt.Errorf("invalid USE dependency %q: ! needs a trailing ? or =", dep)
+	case prefix == "-" && suffix != "":
+		return fmt.Errorf("invalid USE dependency %q: - cannot be conditional", dep)
+	}
+	return nil
+}
+
+// atomHasVersion reports whether a valid atom carries its own version, i.e.
+// starts with a version operator.
+func atomHasVersion(atom string) bool {
+	return strings.IndexAny(atom, "<>=~") == 0
+}
@@ -0,0 +1,68 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAtom(t *testing.T) {
+	valid := []string{
+		"dev-lang/python",
+		"dev-lang/python:3.11",
+		"dev-lang/python:3.11/3.11=",
+		"dev-lang/python:*",
+		"dev-lang/python:=",
+		">=dev-lang/python-3.11",
+		"<sys-libs/glibc-2.40",
+		"=sys-devel/gcc-13.2*",
+		"=dev-libs/openssl-3.0.13-r1",
+		"~app-misc/foo-1.0_rc2",
+		"dev-libs/openssl::gentoo",
+		"media-libs/mesa[vulkan,-llvm,X?,!wayland=,foo(+)]",
+		"dev-qt/qtbase:6::gentoo[gui,widgets]",
+		"app-misc/foo-bar",
+		"dev-util/gtk+",
+	}
+	for _, atom := range valid {
+		if err := ValidateAtom(atom); err != nil {
+			t.Errorf("ValidateAtom(%q) = %v, want nil", atom, err)
+		}
+	}
+
+	invalid := []struct {
+		atom, want string
+	}{
+		{"", "empty atom"},
+		{"python", "missing category"},
+		{"!dev-lang/python", "blockers"},
+		{">=dev-lang/python", "needs a version"},
+		{"dev-lang/python-3.11", "needs an operator"},
+		{"~app-misc/foo-1.0-r1", "takes none"},
+		{">dev-lang/python-3*", "wildcard"},
+		{"dev-lang/python:", "invalid slot"},
+		{"dev-lang/python::", "invalid repository"},
+		{"media-libs/mesa[vulkan", "one [...] group"},
+		{"media-libs/mesa[]", "invalid USE dependency"},
+		{"media-libs/mesa[!vulkan]", "needs a trailing"},
+		{"media-libs/mesa[-vulkan?]", "cannot be conditional"},
+		{"dev-lang/python;rm -rf /", "invalid package name"},
+		{"dev-lang/$(id)", "invalid package name"},
+		{"-dev-lang/python", "invalid category"},
+		{"dev-lang/python'", "invalid package name"},
+	}
+	for _, tt := range invalid {
+		err := ValidateAtom(tt.atom)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ValidateAtom(%q) = %v, want an error containing %q", tt.atom, err, tt.want)
+		}
+	}
+}
+
+func TestValidateTargetVersionedAtom(t *testing.T) {
+	if err := validateTarget(">=dev-lang/python-3.11", "3.12"); err == nil {
+		t.Error("validateTarget accepted an explicit version on a versioned atom")
+	}
+	if err := validateTarget(">=dev-lang/python-3.11", ""); err != nil {
+		t.Errorf("validateTarget(>=dev-lang/python-3.11) = %v", err)
+	}
+}
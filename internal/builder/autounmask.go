@@ -0,0 +1,68 @@
+// Package builder provides capture of autounmask-suggested config changes.
+package builder
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Emerge introduces each block of autounmask changes with a header like
+// "The following keyword changes are necessary to proceed:" followed by
+// ` (see "package.accept_keywords" in the portage(5) man page for more details)`,
+// which names the file the entries belong in.
+var autounmaskSeePattern = regexp.MustCompile(`^\(see "(package\.[a-z_]+)" in the portage\(5\) man page`)
+
+// parseAutounmaskChanges collects the config changes autounmask proposed in a
+// build log: package.use, package.accept_keywords and package.unmask entries.
+// Inside the build container --autounmask-write applies them and they are
+// lost with it, so they are returned for the user to fold into their own
+// /etc/portage or config bundle. License changes are not collected; those are
+// granted only through ACCEPT_LICENSE. Returns nil if there were none.
+func parseAutounmaskChanges(buildLog string) *PortageConfig {
+	var cfg *PortageConfig
+	seen := map[string]bool{}
+	file := ""
+	for _, line := range strings.Split(buildLog, "\n") {
+		line = strings.TrimSpace(line)
+		if m := autounmaskSeePattern.FindStringSubmatch(line); m != nil {
+			file = m[1]
+			continue
+		}
+		if file == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		// A blank line or anything that is not "atom [values...]" ends the block.
+		if len(fields) == 0 || !strings.Contains(fields[0], "/") {
+			file = ""
+			continue
+		}
+		if seen[file+" "+line] {
+			continue
+		}
+		seen[file+" "+line] = true
+
+		if cfg == nil {
+			cfg = &PortageConfig{
+				PackageUse:      make(map[string][]string),
+				PackageKeywords: make(map[string][]string),
+			}
+		}
+		switch file {
+		case "package.use":
+			if len(fields) >= 2 {
+				cfg.PackageUse[fields[0]] = append(cfg.PackageUse[fields[0]], fields[1:]...)
+			}
+		case "package.accept_keywords", "package.keywords":
+			if len(fields) >= 2 {
+				cfg.PackageKeywords[fields[0]] = append(cfg.PackageKeywords[fields[0]], fields[1:]...)
+			}
+		case "package.unmask":
+			cfg.PackageUnmask = append(cfg.PackageUnmask, fields[0])
+		}
+	}
+	if cfg == nil || len(cfg.PackageUse)+len(cfg.PackageKeywords)+len(cfg.PackageUnmask) == 0 {
+		return nil
+	}
+	return cfg
+}
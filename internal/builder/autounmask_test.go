@@ -0,0 +1,55 @@
+package builder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAutounmaskChanges(t *testing.T) {
+	buildLog := `Calculating dependencies... done!
+
+The following keyword changes are necessary to proceed:
+ (see "package.accept_keywords" in the portage(5) man page for more details)
+# required by dev-lang/foo (argument)
+=dev-lang/foo-2.0 ~amd64
+
+The following USE changes are necessary to proceed:
+ (see "package.use" in the portage(5) man page for more details)
+# required by dev-lang/foo-2.0::gentoo
+# required by dev-lang/foo (argument)
+>=dev-libs/bar-1.0 python_targets_python3_12 -test
+
+The following mask changes are necessary to proceed:
+ (see "package.unmask" in the portage(5) man page for more details)
+# required by dev-lang/foo (argument)
+=dev-libs/baz-3.1
+
+The following license changes are necessary to proceed:
+ (see "package.license" in the portage(5) man page for more details)
+=sys-kernel/linux-firmware-20240312 linux-fw-redistributable
+
+Autounmask changes successfully written.
+First emerge attempt failed, applying autounmask changes...
+
+The following keyword changes are necessary to proceed:
+ (see "package.accept_keywords" in the portage(5) man page for more details)
+=dev-lang/foo-2.0 ~amd64
+`
+	got := parseAutounmaskChanges(buildLog)
+	if got == nil {
+		t.Fatal("parseAutounmaskChanges() = nil, want changes")
+	}
+	if want := map[string][]string{"=dev-lang/foo-2.0": {"~amd64"}}; !reflect.DeepEqual(got.PackageKeywords, want) {
+		t.Errorf("PackageKeywords = %v, want %v", got.PackageKeywords, want)
+	}
+	if want := map[string][]string{">=dev-libs/bar-1.0": {"python_targets_python3_12", "-test"}}; !reflect.DeepEqual(got.PackageUse, want) {
+		t.Errorf("PackageUse = %v, want %v", got.PackageUse, want)
+	}
+	if want := []string{"=dev-libs/baz-3.1"}; !reflect.DeepEqual(got.PackageUnmask, want) {
+		t.Errorf("PackageUnmask = %v, want %v", got.PackageUnmask, want)
+	}
+
+	if got := parseAutounmaskChanges(">>> Emerging (1 of 1) app-misc/jq-1.7::gentoo\n"); got != nil {
+		t.Errorf("parseAutounmaskChanges() without autounmask output = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,260 @@
+// Package builder provides content-addressed storage of artifacts, so an
+// identical package produced by several jobs is kept once.
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// blobDirName is the directory in the artifact dir the blobs are kept in.
+// Blobs have no package extension, so the binhost index skips them.
+const blobDirName = ".blobs"
+
+// blobRefsFile is the file in the blob dir recording which jobs use each
+// blob.
+const blobRefsFile = "refs.json"
+
+// artifactBlobsKey is the job metadata mapping each artifact (relative to
+// the artifact dir) to the SHA-256 digest of its blob.
+const artifactBlobsKey = "artifact_blobs"
+
+// blobStore keeps artifacts by SHA-256 digest. The package at its logical
+// path in the artifact dir is a hard link to its blob, and a blob is
+// deleted once no job references it any more.
+type blobStore struct {
+	dir string
+
+	mu sync.Mutex
+	// refs maps a digest to the IDs of the jobs whose artifacts it is.
+	refs map[string]map[string]bool
+}
+
+// newBlobStore returns the blob store of artifactDir with its saved
+// references.
+func newBlobStore(artifactDir string) *blobStore {
+	s := &blobStore{dir: filepath.Join(artifactDir, blobDirName), refs: map[string]map[string]bool{}}
+	data, err := os.ReadFile(filepath.Join(s.dir, blobRefsFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read artifact blob references: %v", err)
+		}
+		return s
+	}
+	var saved map[string][]string
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Warning: failed to parse artifact blob references: %v", err)
+		return s
+	}
+	for digest, jobs := range saved {
+		s.refs[digest] = map[string]bool{}
+		for _, id := range jobs {
+			s.refs[digest][id] = true
+		}
+	}
+	return s
+}
+
+// blobPath returns where the blob of digest is kept, sharded by its first
+// two hex digits.
+func (s *blobStore) blobPath(digest string) string {
+	return filepath.Join(s.dir, "sha256", digest[:2], digest)
+}
+
+// validDigest reports whether digest is a hex SHA-256 digest, so it is safe
+// to use in a path.
+func validDigest(digest string) bool {
+	if len(digest) != 64 {
+		return false
+	}
+	for _, c := range digest {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// store makes the artifact at path, whose SHA-256 digest is digest, a
+// reference of jobID to the blob of digest. A new blob is linked to path;
+// when the blob already exists path is replaced by a link to it, dropping
+// the duplicate. It reports whether the blob already existed.
+func (s *blobStore) store(path, digest, jobID string) (bool, error) {
+	if !validDigest(digest) {
+		return false, fmt.Errorf("invalid digest %q", digest)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob := s.blobPath(digest)
+	_, err := os.Stat(blob)
+	existed := err == nil
+	if existed {
+		tmp := path + ".blob-tmp"
+		_ = os.Remove(tmp)
+		if err := os.Link(blob, tmp); err != nil {
+			return true, fmt.Errorf("failed to link %s to its blob: %w", path, err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			_ = os.Remove(tmp)
+			return true, fmt.Errorf("failed to link %s to its blob: %w", path, err)
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(blob), 0750); err != nil {
+			return false, fmt.Errorf("failed to create blob dir: %w", err)
+		}
+		if err := os.Link(path, blob); err != nil {
+			return false, fmt.Errorf("failed to store blob of %s: %w", path, err)
+		}
+	}
+
+	if s.refs[digest] == nil {
+		s.refs[digest] = map[string]bool{}
+	}
+	s.refs[digest][jobID] = true
+	s.saveLocked()
+	return existed, nil
+}
+
+// refCount returns how many jobs reference the blob of digest.
+func (s *blobStore) refCount(digest string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.refs[digest])
+}
+
+// release drops the references of the jobs in jobIDs and deletes the blobs
+// no job references any more, returning the bytes freed.
+func (s *blobStore) release(jobIDs map[string]bool) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var freed int64
+	changed := false
+	for digest, jobs := range s.refs {
+		for id := range jobs {
+			if jobIDs[id] {
+				delete(jobs, id)
+				changed = true
+			}
+		}
+		if len(jobs) > 0 {
+			continue
+		}
+		delete(s.refs, digest)
+		blob := s.blobPath(digest)
+		info, err := os.Stat(blob)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(blob); err != nil {
+			log.Printf("Failed to delete artifact blob %s: %v", digest, err)
+			continue
+		}
+		freed += info.Size()
+	}
+	if changed {
+		s.saveLocked()
+	}
+	return freed
+}
+
+// saveLocked writes the references atomically. The caller holds s.mu.
+func (s *blobStore) saveLocked() {
+	saved := make(map[string][]string, len(s.refs))
+	for digest, jobs := range s.refs {
+		ids := make([]string, 0, len(jobs))
+		for id := range jobs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		saved[digest] = ids
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		log.Printf("Warning: failed to marshal artifact blob references: %v", err)
+		return
+	}
+	if err := os.MkdirAll(s.dir, 0750); err != nil {
+		log.Printf("Warning: failed to save artifact blob references: %v", err)
+		return
+	}
+	path := filepath.Join(s.dir, blobRefsFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("Warning: failed to save artifact blob references: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		log.Printf("Warning: failed to save artifact blob references: %v", err)
+	}
+}
+
+// storeArtifactBlobs moves the artifacts rels job just produced into the
+// blob store, after their checksum files were written, and records their
+// digests in the job metadata. A failure leaves the artifact as a plain
+// file and does not fail the build.
+func (lb *LocalBuilder) storeArtifactBlobs(job *BuildJob, rels []string) {
+	if lb.blobs == nil {
+		return
+	}
+	blobs := map[string]string{}
+	for _, rel := range rels {
+		path := filepath.Join(lb.artifactDir, rel)
+		digests, err := readArtifactDigests(path)
+		if err == nil {
+			var existed bool
+			existed, err = lb.blobs.store(path, digests.SHA256, job.ID)
+			if existed && err == nil {
+				job.appendLog(fmt.Sprintf("Artifact %s is identical to a stored one, kept once\n", rel))
+			}
+		}
+		if err != nil {
+			log.Printf("Warning: failed to store the blob of %s for job %s: %v", rel, job.ref(), err)
+			continue
+		}
+		blobs[rel] = digests.SHA256
+	}
+	if len(blobs) == 0 {
+		return
+	}
+	job.mu.Lock()
+	for rel, digest := range stringMap(job.Metadata[artifactBlobsKey]) {
+		if _, ok := blobs[rel]; !ok {
+			blobs[rel] = digest
+		}
+	}
+	job.mu.Unlock()
+	job.setMetadata(artifactBlobsKey, blobs)
+}
+
+// artifactBlob returns the SHA-256 digest recorded for the artifact rel of
+// job, or "".
+func artifactBlob(job *BuildJob, rel string) string {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return stringMap(job.Metadata[artifactBlobsKey])[rel]
+}
+
+// resolveArtifact returns the blob the artifact rel of job is stored as,
+// or "" when it has none, so a job keeps its own package even after a
+// later build replaced the file at rel.
+func (lb *LocalBuilder) resolveArtifact(job *BuildJob, rel string) string {
+	if lb.blobs == nil {
+		return ""
+	}
+	digest := artifactBlob(job, rel)
+	if !validDigest(digest) {
+		return ""
+	}
+	blob := lb.blobs.blobPath(digest)
+	if _, err := os.Stat(blob); err != nil {
+		return ""
+	}
+	return blob
+}
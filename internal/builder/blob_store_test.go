@@ -0,0 +1,130 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// writeBuiltArtifact writes the artifact rel with content and its checksum
+// files, as a finished build leaves it.
+func writeBuiltArtifact(t *testing.T, dir, rel, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Remove(path)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeChecksumFiles(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStoreArtifactBlobs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	a := &BuildJob{ID: "a", Status: "success"}
+	b := &BuildJob{ID: "b", Status: "success"}
+	lb := &LocalBuilder{artifactDir: dir, blobs: newBlobStore(dir), jobs: map[string]*BuildJob{"a": a, "b": b}}
+
+	pathA := writeBuiltArtifact(t, dir, "app-misc/jq-1.7-1.gpkg.tar", "payload")
+	a.ArtifactURL = pathA
+	a.Artifacts = []string{"app-misc/jq-1.7-1.gpkg.tar"}
+	lb.storeArtifactBlobs(a, a.Artifacts)
+	pathB := writeBuiltArtifact(t, dir, "app-misc/jq-1.7-2.gpkg.tar", "payload")
+	b.Artifacts = []string{"app-misc/jq-1.7-2.gpkg.tar"}
+	lb.storeArtifactBlobs(b, b.Artifacts)
+
+	if got := artifactBlob(a, "app-misc/jq-1.7-1.gpkg.tar"); got != payloadSHA256 {
+		t.Fatalf("blob of a = %q, want %q", got, payloadSHA256)
+	}
+	blob := lb.blobs.blobPath(payloadSHA256)
+	for _, p := range []string{pathA, pathB} {
+		if !sameFile(t, p, blob) {
+			t.Errorf("%s is not a link to the shared blob", p)
+		}
+	}
+	if n := lb.blobs.refCount(payloadSHA256); n != 2 {
+		t.Errorf("refCount = %d, want 2", n)
+	}
+	if n := newBlobStore(dir).refCount(payloadSHA256); n != 2 {
+		t.Errorf("refCount after reopening = %d, want 2", n)
+	}
+	if got, err := lb.GetArtifactPathByRel("b", "app-misc/jq-1.7-2.gpkg.tar"); err != nil || got != blob {
+		t.Errorf("GetArtifactPathByRel = %q, %v; want the blob", got, err)
+	}
+
+	// A rebuild replacing the package leaves the first job its own.
+	writeBuiltArtifact(t, dir, "app-misc/jq-1.7-1.gpkg.tar", "rebuilt")
+	got, err := lb.GetArtifactPath("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(got); string(data) != "payload" {
+		t.Errorf("GetArtifactPath resolved to %q, want the original package", data)
+	}
+	if name := lb.GetArtifactName("a"); name != "jq-1.7-1.gpkg.tar" {
+		t.Errorf("GetArtifactName = %q", name)
+	}
+}
+
+func TestPruneJobsSharedBlob(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	now := time.Now()
+	old := &BuildJob{ID: "old", Status: "success", EndTime: now.Add(-48 * time.Hour), Artifacts: []string{"app-misc/jq-1.7-1.gpkg.tar"}}
+	recent := &BuildJob{ID: "recent", Status: "success", EndTime: now, Artifacts: []string{"app-misc/jq-1.7-2.gpkg.tar"}}
+	lb := &LocalBuilder{
+		artifactDir: dir,
+		blobs:       newBlobStore(dir),
+		cfg:         &config.BuilderConfig{JobRetention: 24 * time.Hour, PruneArtifacts: true},
+		jobs:        map[string]*BuildJob{"old": old, "recent": recent},
+	}
+	for _, job := range []*BuildJob{old, recent} {
+		writeBuiltArtifact(t, dir, job.Artifacts[0], "payload")
+		lb.storeArtifactBlobs(job, job.Artifacts)
+	}
+	blob := lb.blobs.blobPath(payloadSHA256)
+
+	res := lb.PruneJobs()
+	if res.Jobs != 1 || res.Artifacts != 1 || res.FreedBytes != 0 {
+		t.Errorf("PruneJobs() = %+v, want 1 job and 1 artifact freeing nothing", res)
+	}
+	if !artifactExists(blob) || lb.blobs.refCount(payloadSHA256) != 1 {
+		t.Fatal("the blob another job references was deleted")
+	}
+
+	lb.cfg.JobRetention = 0
+	lb.cfg.JobRetentionMaxJobs = 1
+	recent.EndTime = now.Add(-time.Hour)
+	lb.jobs["newer"] = &BuildJob{ID: "newer", Status: "failed", EndTime: now}
+	res = lb.PruneJobs()
+	if res.Jobs != 1 || res.Artifacts != 1 || res.FreedBytes != int64(len("payload")) {
+		t.Errorf("PruneJobs() = %+v, want 1 job and 1 artifact of 7 bytes", res)
+	}
+	if artifactExists(blob) {
+		t.Error("a blob no job references was kept")
+	}
+}
+
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	fa, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return os.SameFile(fa, fb)
+}
@@ -0,0 +1,92 @@
+// Package builder provides the record of the script each build ran, kept on
+// its job for debugging with the secrets it embeds redacted.
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ErrNoBuildScript is returned for a job that has not run a build script: it
+// is still queued, or its build was simulated or ran a config bundle.
+var ErrNoBuildScript = errors.New("job has no build script")
+
+// gpgSetupBegin and gpgSetupEnd enclose the signing setup of a generated
+// build script, which a job's recorded script leaves out.
+const (
+	gpgSetupBegin = "# >>> gpg signing setup"
+	gpgSetupEnd   = "# <<< gpg signing setup"
+)
+
+// gpgSetupPattern matches the signing setup of a generated build script.
+var gpgSetupPattern = regexp.MustCompile(`(?s)` + regexp.QuoteMeta(gpgSetupBegin) + `\n.*?` + regexp.QuoteMeta(gpgSetupEnd) + `\n`)
+
+// redactedGPGSetup replaces the signing setup in a recorded build script.
+const redactedGPGSetup = "# [redacted] GPG signing setup: key import, trust and make.conf signing settings\n"
+
+// redactBuildScript returns script without its GPG signing setup and with
+// every secret r masks replaced.
+func redactBuildScript(script string, r *logRedactor) string {
+	return r.redact(gpgSetupPattern.ReplaceAllLiteralString(script, redactedGPGSetup))
+}
+
+// setScript records script as the one the job's build runs, redacted.
+func (j *BuildJob) setScript(script string) {
+	script = redactBuildScript(script, j.redactor)
+	j.mu.Lock()
+	j.Script = script
+	j.mu.Unlock()
+}
+
+// GetJobScript returns the redacted script the build of job jobID ran.
+func (lb *LocalBuilder) GetJobScript(jobID string) (string, error) {
+	job, exists := lb.findJob(jobID)
+	if !exists {
+		return "", fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+	job.mu.Lock()
+	script := job.Script
+	job.mu.Unlock()
+	if script == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoBuildScript, jobID)
+	}
+	return script, nil
+}
+
+// nativeBuildScript renders a native build as the shell script equivalent to
+// it: the variables env sets on top of the builder's own environment, then
+// cmds in order.
+func nativeBuildScript(env []string, cmds ...[]string) string {
+	inherited := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		inherited[kv] = true
+	}
+	var sb strings.Builder
+	sb.WriteString("#!/bin/bash\n# Native build, in the builder's environment plus:\n")
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && !inherited[kv] {
+			fmt.Fprintf(&sb, "export %s=%s\n", k, shellQuote(v))
+		}
+	}
+	for _, cmd := range cmds {
+		quoted := make([]string, len(cmd))
+		for i, arg := range cmd {
+			quoted[i] = shellQuote(arg)
+		}
+		sb.WriteString(strings.Join(quoted, " ") + "\n")
+	}
+	return sb.String()
+}
+
+// shellQuote quotes s for a POSIX shell, leaving plain words as they are.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r))
+	}) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
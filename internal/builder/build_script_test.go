@@ -0,0 +1,98 @@
+package builder
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestRecordedBuildScriptRedactsGPGSetup(t *testing.T) {
+	t.Parallel()
+
+	lb := &LocalBuilder{cfg: &config.BuilderConfig{GPGEnabled: true, GPGKeyID: "0123456789ABCDEF"}}
+	job := &BuildJob{Request: &LocalBuildRequest{
+		PackageName: "app-misc/jq",
+		UseFlags:    map[string]string{"oniguruma": "true"},
+	}}
+	script := lb.prepareDockerBuildScript(job)
+	if !strings.Contains(script, gpgSetupBegin) || !strings.Contains(script, "--edit-key") {
+		t.Fatal("generated script lacks the marked GPG setup")
+	}
+
+	job.setScript(script)
+	for _, leaked := range []string{"0123456789ABCDEF", "--edit-key", "secret.asc", gpgSetupEnd} {
+		if strings.Contains(job.Script, leaked) {
+			t.Errorf("recorded script contains %q", leaked)
+		}
+	}
+	for _, want := range []string{redactedGPGSetup, `export USE="oniguruma "`, "emerge --ask=n", "'app-misc/jq'"} {
+		if !strings.Contains(job.Script, want) {
+			t.Errorf("recorded script lacks %q:\n%s", want, job.Script)
+		}
+	}
+}
+
+func TestNativeBuildScript(t *testing.T) {
+	t.Parallel()
+
+	job := &BuildJob{redactor: newLogRedactor(nil, []string{"hunter22"})}
+	env := append(os.Environ(), "USE=ssl -X", "API_TOKEN=hunter22", "PKGDIR=/var/tmp/job/binpkgs")
+	emerge := []string{"emerge", "--ask=n", "=app-misc/jq-1.7*"}
+	job.setScript(nativeBuildScript(env, fetchOnlyCommand(emerge), emerge))
+
+	want := "#!/bin/bash\n# Native build, in the builder's environment plus:\n" +
+		"export USE='ssl -X'\n" +
+		"export API_TOKEN=***\n" +
+		"export PKGDIR=/var/tmp/job/binpkgs\n" +
+		"emerge --fetchonly --ask=n '=app-misc/jq-1.7*'\n" +
+		"emerge --ask=n '=app-misc/jq-1.7*'\n"
+	if job.Script != want {
+		t.Errorf("script = %q, want %q", job.Script, want)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	t.Parallel()
+
+	for in, want := range map[string]string{
+		"emerge":    "emerge",
+		"--jobs=4":  "--jobs=4",
+		"":          "''",
+		">=dev/x-1": "'>=dev/x-1'",
+		"ssl -X":    "'ssl -X'",
+		"it's":      `'it'\''s'`,
+		"-j8 -l8.5": "'-j8 -l8.5'",
+	} {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestGetJobScript(t *testing.T) {
+	lb := NewLocalBuilder(0, nil, &config.BuilderConfig{WorkDir: t.TempDir(), ArtifactDir: t.TempDir()})
+	t.Cleanup(lb.Shutdown)
+	jobID, err := lb.SubmitBuild(&LocalBuildRequest{PackageName: "app-misc/jq"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lb.GetJobScript(jobID); !errors.Is(err, ErrNoBuildScript) {
+		t.Errorf("GetJobScript(queued) error = %v, want ErrNoBuildScript", err)
+	}
+	if _, err := lb.GetJobScript("no-such-job"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("GetJobScript(unknown) error = %v, want ErrJobNotFound", err)
+	}
+
+	job, _ := lb.findJob(jobID)
+	job.setScript("emerge app-misc/jq\n")
+	if script, err := lb.GetJobScript(jobID); err != nil || script != "emerge app-misc/jq\n" {
+		t.Errorf("GetJobScript() = %q, %v", script, err)
+	}
+	if clone := job.Clone(); clone.Script != job.Script {
+		t.Error("Clone() drops the script")
+	}
+}
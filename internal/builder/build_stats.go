@@ -0,0 +1,337 @@
+// Package builder provides per-package build duration statistics.
+package builder
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/slchris/portage-engine/internal/iac"
+)
+
+// buildStatsFile is the file in DATA_DIR the build statistics are kept in.
+const buildStatsFile = "build_stats.json"
+
+// buildStatsMaxSamples caps the builds kept per package, arch and machine
+// type; the oldest are dropped first.
+const buildStatsMaxSamples = 500
+
+// defaultBuildStatsWindow is used when BUILD_STATS_WINDOW is not set.
+const defaultBuildStatsWindow = 30 * 24 * time.Hour
+
+// buildStatsKey groups builds whose durations are comparable.
+type buildStatsKey struct {
+	Package     string `json:"package"`
+	Arch        string `json:"arch"`
+	MachineType string `json:"machine_type"`
+}
+
+// buildSample is one finished build.
+type buildSample struct {
+	End     time.Time `json:"end"`
+	Seconds float64   `json:"seconds"`
+	Success bool      `json:"success"`
+}
+
+// buildStatsGroup is the saved form of the builds of one key.
+type buildStatsGroup struct {
+	buildStatsKey
+	Builds []buildSample `json:"builds"`
+}
+
+// buildStats holds the recent finished builds of each package, arch and
+// machine type, saved to path by a background writer when path is set.
+type buildStats struct {
+	mu     sync.Mutex
+	window time.Duration
+	groups map[buildStatsKey][]buildSample
+
+	path    string
+	saveCh  chan struct{}
+	done    chan struct{}
+	closing sync.Once
+}
+
+// newBuildStats returns the build statistics saved in dataDir, keeping the
+// builds of the last window. An empty dataDir keeps them in memory only.
+func newBuildStats(dataDir string, window time.Duration) *buildStats {
+	if window <= 0 {
+		window = defaultBuildStatsWindow
+	}
+	s := &buildStats{window: window, groups: make(map[buildStatsKey][]buildSample)}
+	if dataDir == "" {
+		return s
+	}
+	s.path = filepath.Join(dataDir, buildStatsFile)
+	s.load()
+	s.saveCh = make(chan struct{}, 1)
+	s.done = make(chan struct{})
+	go s.writer()
+	return s
+}
+
+// load reads the saved statistics, dropping builds outside the window.
+func (s *buildStats) load() {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read build statistics: %v", err)
+		}
+		return
+	}
+	var groups []buildStatsGroup
+	if err := json.Unmarshal(data, &groups); err != nil {
+		log.Printf("Warning: failed to parse build statistics %s: %v", s.path, err)
+		return
+	}
+	cutoff := time.Now().Add(-s.window)
+	for _, g := range groups {
+		builds := slices.DeleteFunc(g.Builds, func(b buildSample) bool { return b.End.Before(cutoff) })
+		if len(builds) > 0 {
+			s.groups[g.buildStatsKey] = builds
+		}
+	}
+}
+
+// add records a finished build and schedules a save.
+func (s *buildStats) add(key buildStatsKey, sample buildSample) {
+	s.mu.Lock()
+	cutoff := sample.End.Add(-s.window)
+	builds := slices.DeleteFunc(s.groups[key], func(b buildSample) bool { return b.End.Before(cutoff) })
+	builds = append(builds, sample)
+	if len(builds) > buildStatsMaxSamples {
+		builds = builds[len(builds)-buildStatsMaxSamples:]
+	}
+	s.groups[key] = builds
+	s.mu.Unlock()
+
+	if s.saveCh != nil {
+		select {
+		case s.saveCh <- struct{}{}:
+		default: // a save is already pending
+		}
+	}
+}
+
+// writer saves the statistics whenever add asks, until close.
+func (s *buildStats) writer() {
+	defer close(s.done)
+	for range s.saveCh {
+		s.save()
+	}
+	s.save()
+}
+
+// save writes the statistics to path atomically.
+func (s *buildStats) save() {
+	s.mu.Lock()
+	groups := make([]buildStatsGroup, 0, len(s.groups))
+	for key, builds := range s.groups {
+		groups = append(groups, buildStatsGroup{buildStatsKey: key, Builds: builds})
+	}
+	data, err := json.Marshal(groups)
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("Warning: failed to marshal build statistics: %v", err)
+		return
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("Warning: failed to save build statistics: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		_ = os.Remove(tmp)
+		log.Printf("Warning: failed to save build statistics: %v", err)
+	}
+}
+
+// close stops the writer after a final save.
+func (s *buildStats) close() {
+	if s.saveCh == nil {
+		return
+	}
+	s.closing.Do(func() { close(s.saveCh) })
+	<-s.done
+}
+
+// PackageBuildStats summarizes the builds of a package that finished within
+// a window. Durations are those of the successful builds, in seconds, and
+// are all zero when none succeeded.
+type PackageBuildStats struct {
+	Package       string  `json:"package"`
+	Arch          string  `json:"arch,omitempty"`
+	MachineType   string  `json:"machine_type,omitempty"`
+	WindowSeconds int64   `json:"window_seconds"`
+	Builds        int     `json:"builds"`
+	Succeeded     int     `json:"succeeded"`
+	SuccessRate   float64 `json:"success_rate"` // percentage
+	MinSeconds    int64   `json:"min_seconds"`
+	MedianSeconds int64   `json:"median_seconds"`
+	P95Seconds    int64   `json:"p95_seconds"`
+	MaxSeconds    int64   `json:"max_seconds"`
+	// Groups breaks the statistics down per arch and machine type.
+	Groups []PackageBuildStats `json:"groups,omitempty"`
+}
+
+// query summarizes the builds of atom that finished within window, limited
+// to arch and machineType when they are set. atom matches like
+// FindBuildsByPackage.
+func (s *buildStats) query(atom, arch, machineType string, window time.Duration) PackageBuildStats {
+	if window <= 0 || window > s.window {
+		window = s.window
+	}
+	cutoff := time.Now().Add(-window)
+	total := PackageBuildStats{Package: atom, Arch: arch, MachineType: machineType, WindowSeconds: int64(window / time.Second)}
+	var all []buildSample
+
+	s.mu.Lock()
+	for key, builds := range s.groups {
+		if !packageMatches(key.Package, atom) ||
+			(arch != "" && key.Arch != arch) ||
+			(machineType != "" && key.MachineType != machineType) {
+			continue
+		}
+		var recent []buildSample
+		for _, b := range builds {
+			if !b.End.Before(cutoff) {
+				recent = append(recent, b)
+			}
+		}
+		if len(recent) == 0 {
+			continue
+		}
+		group := PackageBuildStats{Package: key.Package, Arch: key.Arch, MachineType: key.MachineType, WindowSeconds: total.WindowSeconds}
+		group.summarize(recent)
+		total.Groups = append(total.Groups, group)
+		all = append(all, recent...)
+	}
+	s.mu.Unlock()
+
+	total.summarize(all)
+	sort.Slice(total.Groups, func(i, j int) bool {
+		a, b := total.Groups[i], total.Groups[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		if a.Arch != b.Arch {
+			return a.Arch < b.Arch
+		}
+		return a.MachineType < b.MachineType
+	})
+	return total
+}
+
+// summarize fills in the counts and durations of builds.
+func (p *PackageBuildStats) summarize(builds []buildSample) {
+	var durations []float64
+	for _, b := range builds {
+		if b.Success {
+			durations = append(durations, b.Seconds)
+		}
+	}
+	p.Builds = len(builds)
+	p.Succeeded = len(durations)
+	if p.Builds > 0 {
+		p.SuccessRate = float64(p.Succeeded) / float64(p.Builds) * 100
+	}
+	if len(durations) == 0 {
+		return
+	}
+	sort.Float64s(durations)
+	p.MinSeconds = int64(durations[0])
+	p.MedianSeconds = int64(percentile(durations, 50))
+	p.P95Seconds = int64(percentile(durations, 95))
+	p.MaxSeconds = int64(durations[len(durations)-1])
+}
+
+// percentile returns the nearest-rank pth percentile of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+// PackageBuildStats returns the build time statistics of atom over window,
+// or over BUILD_STATS_WINDOW when window is 0 or longer. Empty arch and
+// machineType match any.
+func (m *Manager) PackageBuildStats(atom, arch, machineType string, window time.Duration) PackageBuildStats {
+	return m.buildStats.query(atom, arch, machineType, window)
+}
+
+// recordBuildLocked adds a job that just finished to the build statistics.
+// Only builds that started count: a job that failed before reaching a
+// builder, or was cancelled, says nothing about how long the package takes.
+// The caller holds m.jobsMu.
+func (m *Manager) recordBuildLocked(job *BuildStatus, now time.Time) {
+	if m.buildStats == nil || job.buildStart.IsZero() {
+		return
+	}
+	var success bool
+	switch job.Status {
+	case "completed", "success", "success_no_artifact":
+		success = true
+	case "failed":
+	default:
+		return
+	}
+	end := job.buildEnd
+	if end.IsZero() {
+		end = now
+	}
+	if end.Before(job.buildStart) {
+		return
+	}
+	m.buildStats.add(
+		buildStatsKey{Package: job.PackageName, Arch: job.Arch, MachineType: job.machineType},
+		buildSample{End: now, Seconds: end.Sub(job.buildStart).Seconds(), Success: success},
+	)
+}
+
+// setMachineType records what kind of machine builds jobID, for the build
+// statistics.
+func (m *Manager) setMachineType(jobID, machineType string) {
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+	if job, ok := m.jobs[jobID]; ok {
+		job.machineType = machineType
+	}
+}
+
+// setBuildTimes records when the builder of jobID reports the build started
+// and ended, which are more precise than when the server saw those states.
+// Zero times are ignored.
+func (m *Manager) setBuildTimes(jobID string, start, end time.Time) {
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return
+	}
+	if !start.IsZero() {
+		job.buildStart = start
+	}
+	if !end.IsZero() {
+		job.buildEnd = end
+	}
+}
+
+// instanceMachineType describes the machine of a cloud instance from the
+// spec it was created with, e.g. "gcp/n1-standard-4", or just the provider
+// when the spec names no type.
+func instanceMachineType(inst *iac.Instance) string {
+	for _, key := range []string{"machine_type", "instance_type", "server_type"} {
+		if t := inst.Metadata[key]; t != "" {
+			return inst.Provider + "/" + t
+		}
+	}
+	if cores, mem := inst.Metadata["cores"], inst.Metadata["memory_mb"]; cores != "" && mem != "" {
+		return inst.Provider + "/" + cores + "c-" + mem + "mb"
+	}
+	return inst.Provider
+}
@@ -0,0 +1,115 @@
+package builder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/internal/iac"
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestBuildStatsQuery(t *testing.T) {
+	s := newBuildStats("", 24*time.Hour)
+	now := time.Now()
+	amd64 := buildStatsKey{Package: "dev-lang/python", Arch: "amd64", MachineType: "gcp/n1-standard-4"}
+	arm64 := buildStatsKey{Package: "dev-lang/python", Arch: "arm64", MachineType: "builder-arm"}
+	for i := 1; i <= 20; i++ {
+		s.add(amd64, buildSample{End: now, Seconds: float64(i * 60), Success: true})
+	}
+	s.add(amd64, buildSample{End: now, Seconds: 5, Success: false})
+	s.add(arm64, buildSample{End: now, Seconds: 3600, Success: true})
+	s.add(arm64, buildSample{End: now.Add(-2 * time.Hour), Seconds: 7200, Success: true})
+	s.add(buildStatsKey{Package: "dev-lang/perl", Arch: "amd64"}, buildSample{End: now, Seconds: 30, Success: true})
+
+	got := s.query("dev-lang/python", "amd64", "", 0)
+	if got.Builds != 21 || got.Succeeded != 20 {
+		t.Errorf("builds = %d, succeeded = %d, want 21 and 20", got.Builds, got.Succeeded)
+	}
+	if got.MinSeconds != 60 || got.MedianSeconds != 600 || got.P95Seconds != 1140 || got.MaxSeconds != 1200 {
+		t.Errorf("durations = %d/%d/%d/%d, want 60/600/1140/1200", got.MinSeconds, got.MedianSeconds, got.P95Seconds, got.MaxSeconds)
+	}
+	if got.SuccessRate < 95.2 || got.SuccessRate > 95.3 {
+		t.Errorf("success rate = %.2f, want 20 of 21", got.SuccessRate)
+	}
+	if got.WindowSeconds != 24*3600 {
+		t.Errorf("window = %ds, want BUILD_STATS_WINDOW", got.WindowSeconds)
+	}
+
+	all := s.query("dev-lang/python", "", "", time.Hour)
+	if all.Builds != 22 || len(all.Groups) != 2 || all.Groups[0].Arch != "amd64" || all.Groups[1].MachineType != "builder-arm" {
+		t.Errorf("query over every arch = %+v, want both groups without the build older than the window", all)
+	}
+	if g := all.Groups[1]; g.Builds != 1 || g.MaxSeconds != 3600 {
+		t.Errorf("arm64 group = %+v, want only the recent build", g)
+	}
+
+	if none := s.query("dev-lang/ruby", "", "", 0); none.Builds != 0 || none.MedianSeconds != 0 || none.Groups != nil {
+		t.Errorf("query for an unbuilt package = %+v, want nothing", none)
+	}
+}
+
+func TestBuildStatsPersist(t *testing.T) {
+	dir := t.TempDir()
+	key := buildStatsKey{Package: "app-misc/jq", Arch: "amd64"}
+	s := newBuildStats(dir, time.Hour)
+	s.add(key, buildSample{End: time.Now(), Seconds: 90, Success: true})
+	s.add(key, buildSample{End: time.Now().Add(-2 * time.Hour), Seconds: 10, Success: true})
+	s.close()
+	s.close()
+
+	reloaded := newBuildStats(dir, time.Hour)
+	defer reloaded.close()
+	got := reloaded.query("app-misc/jq", "", "", 0)
+	if got.Builds != 1 || got.MedianSeconds != 90 {
+		t.Errorf("after reload = %+v, want the one build within the window", got)
+	}
+}
+
+func TestBuildStatsRecordsFinishedBuilds(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+
+	mgr.jobsMu.Lock()
+	mgr.putJobLocked("job-1", &BuildStatus{JobID: "job-1", Status: "queued", PackageName: "app-misc/jq", Arch: "amd64"})
+	mgr.putJobLocked("job-2", &BuildStatus{JobID: "job-2", Status: "queued", PackageName: "app-misc/jq", Arch: "amd64"})
+	mgr.putJobLocked("job-3", &BuildStatus{JobID: "job-3", Status: "queued", PackageName: "app-misc/jq", Arch: "amd64"})
+	mgr.jobsMu.Unlock()
+
+	mgr.setMachineType("job-1", "gcp/e2-standard-8")
+	mgr.updateStatus("job-1", "building", "", "")
+	mgr.updateStatus("job-1", "completed", "", "")
+	mgr.updateStatus("job-1", "completed", "", "")
+	// A job that never started building, e.g. failed provisioning, is left out.
+	mgr.updateStatus("job-2", "failed", "", "provisioning failed")
+	// Times reported by a remote builder win over when the server saw them.
+	start := time.Now().Add(-10 * time.Minute)
+	mgr.setBuildTimes("job-3", start, start.Add(5*time.Minute))
+	mgr.updateStatus("job-3", "failed", "", "compile error")
+
+	got := mgr.PackageBuildStats("app-misc/jq", "amd64", "", 0)
+	if got.Builds != 2 || got.Succeeded != 1 {
+		t.Fatalf("stats = %+v, want job-1 and job-3 counted once each", got)
+	}
+	if len(got.Groups) != 2 || got.Groups[1].MachineType != "gcp/e2-standard-8" {
+		t.Errorf("groups = %+v, want job-1 under its machine type", got.Groups)
+	}
+	if g := got.Groups[0]; g.Succeeded != 0 || g.Builds != 1 {
+		t.Errorf("job-3 group = %+v, want one failed build", g)
+	}
+}
+
+func TestInstanceMachineType(t *testing.T) {
+	for _, tt := range []struct {
+		inst *iac.Instance
+		want string
+	}{
+		{&iac.Instance{Provider: "gcp", Metadata: map[string]string{"machine_type": "n1-standard-4"}}, "gcp/n1-standard-4"},
+		{&iac.Instance{Provider: "aws", Metadata: map[string]string{"instance_type": "c6i.2xlarge"}}, "aws/c6i.2xlarge"},
+		{&iac.Instance{Provider: "pve", Metadata: map[string]string{"cores": "8", "memory_mb": "16384"}}, "pve/8c-16384mb"},
+		{&iac.Instance{Provider: "hetzner"}, "hetzner"},
+	} {
+		if got := instanceMachineType(tt.inst); got != tt.want {
+			t.Errorf("instanceMachineType(%+v) = %q, want %q", tt.inst.Metadata, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package builder provides architecture-aware remote builder selection.
+package builder
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// builderArchTTL is how long the architecture a builder reported is used
+// before its status endpoint is queried again. Heartbeats refresh it too.
+const builderArchTTL = 5 * time.Minute
+
+// builderArch is the architecture a builder reported, "" when it reported
+// none or did not answer, the other arches it builds for under QEMU, and
+// when it was learned.
+type builderArch struct {
+	arch     string
+	emulated []string
+	at       time.Time
+}
+
+// recordBuilderArch remembers the architecture a builder reported for addr,
+// and those it emulates, from its heartbeat or status endpoint.
+func (m *Manager) recordBuilderArch(addr, arch string, emulated ...string) {
+	if addr == "" {
+		return
+	}
+	key := config.CanonicalBuilderURL(addr)
+	m.builderIDsMu.Lock()
+	defer m.builderIDsMu.Unlock()
+	if m.builderArchs == nil {
+		m.builderArchs = make(map[string]builderArch)
+	}
+	m.builderArchs[key] = builderArch{arch: arch, emulated: emulated, at: time.Now()}
+}
+
+// knownBuilderArch returns the architecture last learned for addr, and
+// false when none was learned within builderArchTTL.
+func (m *Manager) knownBuilderArch(addr string) (string, bool) {
+	a, ok := m.knownBuilderArchs(addr)
+	return a.arch, ok
+}
+
+// knownBuilderArchs is knownBuilderArch with the emulated arches.
+func (m *Manager) knownBuilderArchs(addr string) (builderArch, bool) {
+	m.builderIDsMu.RLock()
+	defer m.builderIDsMu.RUnlock()
+	a, ok := m.builderArchs[config.CanonicalBuilderURL(addr)]
+	if !ok || time.Since(a.at) > builderArchTTL {
+		return builderArch{}, false
+	}
+	return a, true
+}
+
+// archBuilders returns the builders that can build for arch: those that
+// reported arch first, then those that emulate it with QEMU, which builds
+// much slower, then those whose architecture is unknown (older
+// builders that do not report one, or ones that did not answer), in the
+// given order. Builders not heard from within builderArchTTL are queried
+// first. It fails when every builder reported another architecture. An
+// empty arch keeps every builder.
+func (m *Manager) archBuilders(builders []string, arch string) ([]string, error) {
+	if arch == "" {
+		return builders, nil
+	}
+	var expired []string
+	for _, addr := range builders {
+		if _, ok := m.knownBuilderArch(addr); !ok {
+			expired = append(expired, addr)
+		}
+	}
+	if len(expired) > 0 {
+		// probeBuilderLoads records what each builder reports.
+		for _, l := range m.probeBuilderLoads(expired) {
+			if !l.reachable {
+				m.recordBuilderArch(l.addr, "")
+			}
+		}
+	}
+
+	var matching, emulating, unknown []string
+	others := map[string]bool{}
+	for _, addr := range builders {
+		a, _ := m.knownBuilderArchs(addr)
+		switch {
+		case a.arch == arch:
+			matching = append(matching, addr)
+		case slices.Contains(a.emulated, arch):
+			emulating = append(emulating, addr)
+		case a.arch == "":
+			unknown = append(unknown, addr)
+		default:
+			others[a.arch] = true
+		}
+	}
+	matching = append(matching, emulating...)
+	if len(matching) == 0 && len(unknown) == 0 {
+		return nil, fmt.Errorf("no builder available for arch %s (remote builders are %s)",
+			arch, strings.Join(slices.Sorted(maps.Keys(others)), ", "))
+	}
+	return append(matching, unknown...), nil
+}
@@ -0,0 +1,97 @@
+package builder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// archBuilder is a fake builder whose status reports arch ("" for none),
+// counting the status queries it answers.
+func archBuilder(t *testing.T, arch string, probes *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/status" {
+			http.NotFound(w, r)
+			return
+		}
+		probes.Add(1)
+		status := map[string]interface{}{"api_version": APIVersion, "workers": 1}
+		if arch != "" {
+			status["architecture"] = arch
+		}
+		_ = json.NewEncoder(w).Encode(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestArchBuilders(t *testing.T) {
+	var probes atomic.Int32
+	amd64 := archBuilder(t, "amd64", &probes).URL
+	arm64 := archBuilder(t, "arm64", &probes).URL
+	legacy := archBuilder(t, "", &probes).URL
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+
+	builders := []string{legacy, amd64, arm64}
+	got, err := mgr.archBuilders(builders, "arm64")
+	if err != nil || strings.Join(got, ",") != arm64+","+legacy {
+		t.Errorf("archBuilders(arm64) = %v, %v; want the arm64 builder, then the one of unknown arch", got, err)
+	}
+	if n := probes.Load(); n != 3 {
+		t.Errorf("%d status queries, want one per builder", n)
+	}
+	if got, _ := mgr.archBuilders(builders, "amd64"); strings.Join(got, ",") != amd64+","+legacy {
+		t.Errorf("archBuilders(amd64) = %v", got)
+	}
+	if n := probes.Load(); n != 3 {
+		t.Errorf("%d status queries, want the cached architectures reused", n)
+	}
+	if got, _ := mgr.archBuilders(builders, ""); len(got) != 3 {
+		t.Errorf("archBuilders without an arch = %v, want every builder", got)
+	}
+
+	_, err = mgr.archBuilders([]string{amd64, arm64}, "riscv")
+	if err == nil || !strings.Contains(err.Error(), "no builder available for arch riscv") {
+		t.Errorf("archBuilders(riscv) error = %v", err)
+	}
+}
+
+func TestHeartbeatRecordsArch(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+
+	addr := "http://builder-1:9090"
+	if err := mgr.UpdateBuilderHeartbeat(&HeartbeatRequest{BuilderID: "b1", Status: "online", Endpoint: addr, Architecture: "arm64"}); err != nil {
+		t.Fatal(err)
+	}
+	if arch, ok := mgr.knownBuilderArch(addr); !ok || arch != "arm64" {
+		t.Errorf("knownBuilderArch() = %q, %v; want the heartbeat's arm64", arch, ok)
+	}
+	if _, err := mgr.archBuilders([]string{addr}, "amd64"); err == nil {
+		t.Error("an amd64 build was scheduled on an arm64 builder")
+	}
+}
+
+func TestSubmitToRemoteBuilderNoArch(t *testing.T) {
+	var probes atomic.Int32
+	srv := archBuilder(t, "amd64", &probes)
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0, RemoteBuilders: []string{srv.URL}})
+	defer mgr.Shutdown()
+	mgr.LoadJobs(map[string]*BuildStatus{"j1": {JobID: "j1", Status: "queued", PackageName: "app-misc/jq", Arch: "arm64"}})
+
+	mgr.submitToRemoteBuilder("j1", &BuildRequest{PackageName: "app-misc/jq", Arch: "arm64"})
+	status, err := mgr.GetStatus("j1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != "failed" || !strings.Contains(status.Error, "no builder available for arch arm64") {
+		t.Errorf("job = %s %q, want it failed for want of an arm64 builder", status.Status, status.Error)
+	}
+}
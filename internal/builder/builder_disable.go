@@ -0,0 +1,47 @@
+// Package builder provides disabling remote builders for maintenance.
+package builder
+
+import "fmt"
+
+// SetBuilderDisabled marks the builder with instance ID id as disabled, or
+// enabled again. A disabled builder gets no new jobs; jobs already running
+// on it finish and are still polled. The builder is matched by the ID it
+// reports in its heartbeats, so it is skipped once its address is known.
+func (m *Manager) SetBuilderDisabled(id string, disabled bool) {
+	m.builderIDsMu.Lock()
+	defer m.builderIDsMu.Unlock()
+	if !disabled {
+		delete(m.disabledBuilders, id)
+		return
+	}
+	if m.disabledBuilders == nil {
+		m.disabledBuilders = make(map[string]bool)
+	}
+	m.disabledBuilders[id] = true
+}
+
+// builderDisabled reports whether the builder at addr was disabled.
+func (m *Manager) builderDisabled(addr string) bool {
+	id := m.builderID(addr)
+	if id == "" {
+		return false
+	}
+	m.builderIDsMu.RLock()
+	defer m.builderIDsMu.RUnlock()
+	return m.disabledBuilders[id]
+}
+
+// enabledBuilders drops the disabled builders from builders, keeping their
+// order. It fails when every builder is disabled.
+func (m *Manager) enabledBuilders(builders []string) ([]string, error) {
+	enabled := make([]string, 0, len(builders))
+	for _, addr := range builders {
+		if !m.builderDisabled(addr) {
+			enabled = append(enabled, addr)
+		}
+	}
+	if len(enabled) == 0 && len(builders) > 0 {
+		return nil, fmt.Errorf("no remote builder can accept the job: all %d are disabled", len(builders))
+	}
+	return enabled, nil
+}
@@ -0,0 +1,45 @@
+package builder
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestEnabledBuilders(t *testing.T) {
+	builders := []string{"10.0.0.5:9090", "10.0.0.6:9090", "10.0.0.7:9090"}
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0, RemoteBuilders: builders})
+	defer mgr.Shutdown()
+
+	for i, id := range []string{"builder-1", "builder-2"} {
+		if err := mgr.UpdateBuilderHeartbeat(&HeartbeatRequest{BuilderID: id, Status: "online", Endpoint: "http://" + builders[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mgr.SetBuilderDisabled("builder-1", true)
+	got, err := mgr.enabledBuilders(builders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := builders[1:]; !slices.Equal(got, want) {
+		t.Errorf("enabledBuilders() = %q, want %q", got, want)
+	}
+
+	if got, err := mgr.enabledBuilders(builders[:1]); err == nil {
+		t.Errorf("enabledBuilders() = %q, want an error with every builder disabled", got)
+	} else if !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("error = %v, want it to say the builders are disabled", err)
+	}
+	// A builder whose ID is not known yet is kept.
+	if got, err := mgr.enabledBuilders(builders[2:]); err != nil || len(got) != 1 {
+		t.Errorf("enabledBuilders() = %q, %v, want the unknown builder kept", got, err)
+	}
+
+	mgr.SetBuilderDisabled("builder-1", false)
+	if got, _ := mgr.enabledBuilders(builders); !slices.Equal(got, builders) {
+		t.Errorf("enabledBuilders() = %q after enabling, want every builder", got)
+	}
+}
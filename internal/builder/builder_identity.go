@@ -0,0 +1,84 @@
+// Package builder provides remote builder de-duplication.
+package builder
+
+import (
+	"log"
+	"strings"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// recordBuilderID remembers the instance ID a builder reported for addr, from
+// its heartbeat or status endpoint.
+func (m *Manager) recordBuilderID(addr, id string) {
+	if addr == "" || id == "" {
+		return
+	}
+	key := config.CanonicalBuilderURL(addr)
+	m.builderIDsMu.Lock()
+	defer m.builderIDsMu.Unlock()
+	if m.builderIDs == nil {
+		m.builderIDs = make(map[string]string)
+	}
+	m.builderIDs[key] = id
+}
+
+// builderID returns the instance ID last reported for addr, or "".
+func (m *Manager) builderID(addr string) string {
+	m.builderIDsMu.RLock()
+	defer m.builderIDsMu.RUnlock()
+	return m.builderIDs[config.CanonicalBuilderURL(addr)]
+}
+
+// dedupeByInstanceID drops addresses whose builder reported the same instance
+// ID as an earlier address (e.g. one builder listed by hostname and by IP).
+// Addresses whose ID is not yet known are kept.
+func (m *Manager) dedupeByInstanceID(addrs []string) []string {
+	seen := make(map[string]string, len(addrs))
+	kept := addrs[:0:0]
+	for _, addr := range addrs {
+		id := m.builderID(addr)
+		if id != "" {
+			if prev, ok := seen[id]; ok {
+				m.warnDuplicateBuilder(addr, prev, id)
+				continue
+			}
+			seen[id] = addr
+		}
+		kept = append(kept, addr)
+	}
+	return kept
+}
+
+// warnDuplicateBuilder logs a builder reachable at two configured addresses,
+// once per address.
+func (m *Manager) warnDuplicateBuilder(addr, prev, id string) {
+	m.builderIDsMu.Lock()
+	defer m.builderIDsMu.Unlock()
+	if m.warnedDuplicates == nil {
+		m.warnedDuplicates = make(map[string]bool)
+	}
+	if m.warnedDuplicates[addr] {
+		return
+	}
+	m.warnedDuplicates[addr] = true
+	log.Printf("WARNING: remote builder %q is %s, already listed as %q; ignoring the duplicate", addr, id, prev)
+}
+
+// RemoteBuilders returns the configured static builders, one entry per
+// builder: duplicates by address were dropped when the settings were loaded,
+// and duplicates by reported instance ID are dropped here.
+func (m *Manager) RemoteBuilders() []string {
+	return m.dedupeByInstanceID(m.CloudSettings().RemoteBuilders)
+}
+
+// dedupeSettingsBuilders drops duplicate addresses from runtime settings,
+// logging each one.
+func dedupeSettingsBuilders(s *config.CloudSettings) {
+	kept, duplicates := config.DedupeBuilders(s.RemoteBuilders)
+	if len(duplicates) == 0 {
+		return
+	}
+	log.Printf("WARNING: remote builders list %s; ignoring the duplicates", strings.Join(duplicates, ", "))
+	s.RemoteBuilders = kept
+}
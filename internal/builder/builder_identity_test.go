@@ -0,0 +1,65 @@
+package builder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// statusServer fakes a builder status endpoint reporting instanceID.
+func statusServer(t *testing.T, instanceID string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"instance_id": instanceID, "workers": 2, "total": 3, "completed": 3,
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRemoteBuildersDedupesByInstanceID(t *testing.T) {
+	a := statusServer(t, "builder-a")
+	// The same builder reached at a second address.
+	alias := statusServer(t, "builder-a")
+	b := statusServer(t, "builder-b")
+
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0, RemoteBuilders: []string{a.URL, alias.URL, b.URL}})
+	defer mgr.Shutdown()
+
+	stats := mgr.fetchRemoteBuilderStats()
+	if stats.TotalBuilds != 6 || stats.ActiveInstances != 2 {
+		t.Errorf("stats = %+v, want builder-a counted once", stats)
+	}
+	if got := mgr.RemoteBuilders(); len(got) != 2 || got[0] != a.URL || got[1] != b.URL {
+		t.Errorf("RemoteBuilders() = %q, want the alias dropped", got)
+	}
+}
+
+func TestHeartbeatRecordsBuilderID(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0, RemoteBuilders: []string{"10.0.0.5:9090", "builder1:9090"}})
+	defer mgr.Shutdown()
+
+	for _, endpoint := range []string{"http://10.0.0.5:9090", "http://builder1:9090/"} {
+		if err := mgr.UpdateBuilderHeartbeat(&HeartbeatRequest{BuilderID: "builder-1", Status: "online", Endpoint: endpoint}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := mgr.RemoteBuilders(); len(got) != 1 || got[0] != "10.0.0.5:9090" {
+		t.Errorf("RemoteBuilders() = %q, want one entry for builder-1", got)
+	}
+}
+
+func TestUpdateCloudSettingsDedupesBuilders(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+
+	mgr.UpdateCloudSettings(&config.CloudSettings{RemoteBuilders: []string{"builder1:9090", "http://builder1:9090"}})
+	if got := mgr.RemoteBuilders(); len(got) != 1 || !strings.Contains(got[0], "builder1") {
+		t.Errorf("RemoteBuilders() = %q, want one entry", got)
+	}
+}
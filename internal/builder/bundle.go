@@ -0,0 +1,261 @@
+// Package builder provides multi-package config bundle builds.
+package builder
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Per-package outcomes of a bundle build, in BundlePackageResult.Status.
+const (
+	bundlePackageBuilt      = "built"
+	bundlePackageFailed     = "failed"
+	bundlePackageNoArtifact = "no_artifact"
+)
+
+// BundlePackageResult is the outcome of one package of a bundle build, with
+// the artifacts (relative to the artifact dir) that belong to it.
+type BundlePackageResult struct {
+	Atom      string   `json:"atom"`
+	Status    string   `json:"status"`
+	Artifacts []string `json:"artifacts,omitempty"`
+}
+
+// bundleBuildSpec returns the bundle to build and the single spec its emerge
+// runs with. A one-package bundle is returned as is. For several packages,
+// each package's USE flags and keywords move into package.use and
+// package.accept_keywords entries of a copy of the config (emerge takes them
+// only globally on its command line), and their environments are merged, so
+// all of them can be emerged together. A package's own MakeOpts goes into a
+// package.env entry; as the environment would override it, makeOpts, the
+// builder's MAKEOPTS, then moves into make.conf unless that sets one.
+func bundleBuildSpec(bundle *ConfigBundle, makeOpts string) (*ConfigBundle, PackageSpec) {
+	pkgs := bundle.Packages.Packages
+	if len(pkgs) == 1 {
+		return bundle, pkgs[0]
+	}
+
+	cfg := PortageConfig{}
+	if bundle.Config != nil {
+		cfg = *bundle.Config
+	}
+	cfg.PackageUse = copyAtomMap(cfg.PackageUse)
+	cfg.PackageKeywords = copyAtomMap(cfg.PackageKeywords)
+	cfg.PackageEnv = copyStringMap(cfg.PackageEnv)
+	cfg.EnvFiles = copyStringMap(cfg.EnvFiles)
+	combined := PackageSpec{Environment: map[string]string{}}
+	ownMakeOpts := false
+	for i, pkg := range pkgs {
+		target := emergeTarget(pkg.Atom, pkg.Version)
+		if !isPackageSet(target) {
+			if len(pkg.UseFlags) > 0 {
+				cfg.PackageUse[target] = append(cfg.PackageUse[target], pkg.UseFlags...)
+			}
+			if len(pkg.Keywords) > 0 {
+				cfg.PackageKeywords[target] = append(cfg.PackageKeywords[target], pkg.Keywords...)
+			}
+			if pkg.MakeOpts != "" {
+				name := fmt.Sprintf("portage-engine-makeopts-%d.conf", i)
+				cfg.EnvFiles[name] = fmt.Sprintf("MAKEOPTS=\"%s\"\n", pkg.MakeOpts)
+				cfg.PackageEnv[target] = strings.TrimSpace(cfg.PackageEnv[target] + " " + name)
+				ownMakeOpts = true
+			}
+		}
+		for k, v := range pkg.Environment {
+			combined.Environment[k] = v
+		}
+	}
+	if ownMakeOpts && makeOpts != "" && cfg.MakeConf["MAKEOPTS"] == "" {
+		cfg.MakeConf = copyStringMap(cfg.MakeConf)
+		cfg.MakeConf["MAKEOPTS"] = makeOpts
+	}
+	out := *bundle
+	out.Config = &cfg
+	return &out, combined
+}
+
+// copyAtomMap copies an atom -> values map so appending to it leaves the
+// request's own config untouched.
+func copyAtomMap(m map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(m))
+	for k, v := range m {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
+
+// copyStringMap copies m, returning an empty map for a nil one.
+func copyStringMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	maps.Copy(out, m)
+	return out
+}
+
+// bundleEmergeCommand is the emerge command building every package of a
+// bundle in one invocation, so Portage resolves their combined dependency
+// graph once. With several packages it keeps going past a failed package,
+// so the others still build and the partial result can be reported.
+func (be *BuildExecutor) bundleEmergeCommand(bundle *ConfigBundle) []string {
+	pkgs := bundle.Packages.Packages
+	if len(pkgs) == 1 {
+		return be.constructEmergeCommand(pkgs[0], bundle, "")
+	}
+	cmd := append(be.emergeBaseCommand(), "--keep-going=y")
+	for _, pkg := range pkgs {
+		cmd = append(cmd, emergeTarget(pkg.Atom, pkg.Version))
+	}
+	return cmd
+}
+
+// findAllPackages returns every binary package (.gpkg.tar or .tbz2) under
+// pkgDir, as paths relative to it with the category preserved.
+func findAllPackages(pkgDir string) ([]string, error) {
+	var rels []string
+	err := filepath.Walk(pkgDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == pkgDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() || !(strings.HasSuffix(name, ".gpkg.tar") || strings.HasSuffix(name, ".tbz2")) {
+			return nil
+		}
+		rel, err := filepath.Rel(pkgDir, path)
+		if err != nil {
+			return err
+		}
+		rels = append(rels, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for packages: %w", err)
+	}
+	return rels, nil
+}
+
+// collectBundleArtifacts copies every package the bundle build left in
+// pkgDir into the artifact dir and records them on the job: the artifact
+// list, the primary artifact (the first requested package's), and in
+// Metadata "artifacts" and "package_results". buildErr is the emerge result;
+// when some packages built and others did not, the job is marked "partial"
+// and the returned error names the failed ones.
+func (be *BuildExecutor) collectBundleArtifacts(job *BuildJob, pkgs []PackageSpec, pkgDir string, buildErr error) error {
+	rels, err := findAllPackages(pkgDir)
+	if err != nil {
+		if buildErr != nil {
+			return buildErr
+		}
+		return fmt.Errorf("failed to collect artifacts: %w", err)
+	}
+	for _, rel := range rels {
+		dest := filepath.Join(be.artifactDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dest), 0750); err != nil {
+			return fmt.Errorf("failed to create artifact dir: %w", err)
+		}
+		_ = os.Remove(dest) // may be a link to a blob another job uses
+		if err := be.copyFile(filepath.Join(pkgDir, rel), dest); err != nil {
+			return fmt.Errorf("failed to copy artifact: %w", err)
+		}
+		job.appendLog(fmt.Sprintf("Artifact collected: %s\n", dest))
+	}
+
+	results := make([]BundlePackageResult, 0, len(pkgs))
+	var built, failed []string
+	for _, pkg := range pkgs {
+		res := BundlePackageResult{Atom: pkg.Atom, Artifacts: packageArtifacts(rels, pkg.Atom)}
+		switch {
+		case isPackageSet(pkg.Atom) && buildErr == nil, len(res.Artifacts) > 0:
+			res.Status = bundlePackageBuilt
+			built = append(built, pkg.Atom)
+		case buildErr == nil:
+			res.Status = bundlePackageNoArtifact
+		default:
+			res.Status = bundlePackageFailed
+			failed = append(failed, pkg.Atom)
+		}
+		results = append(results, res)
+	}
+
+	if len(rels) > 0 {
+		primary := rels[0]
+		if len(results[0].Artifacts) > 0 {
+			primary = primaryArtifact(be.artifactDir, results[0].Artifacts, atomCP(pkgs[0].Atom), be.opts.ArtifactDebug)
+		}
+		job.setArtifactURL(filepath.Join(be.artifactDir, primary))
+		job.setArtifacts(rels)
+		job.setMetadata("artifacts", rels)
+	}
+	if len(pkgs) > 1 {
+		job.setMetadata("package_results", results)
+	}
+
+	switch {
+	case buildErr != nil && len(built) > 0:
+		job.setMetadata("partial", true)
+		job.appendLog(fmt.Sprintf("Partial bundle build: built %s; failed %s\n", strings.Join(built, ", "), strings.Join(failed, ", ")))
+		return fmt.Errorf("partial bundle build: %d of %d packages built, failed: %s: %w",
+			len(built), len(pkgs), strings.Join(failed, ", "), buildErr)
+	case buildErr != nil:
+		return buildErr
+	case len(rels) == 0:
+		return fmt.Errorf("%w for %s", errNoArtifact, bundleAtoms(pkgs))
+	}
+	return nil
+}
+
+// packageArtifacts returns the artifacts among rels that belong to atom.
+func packageArtifacts(rels []string, atom string) []string {
+	if isPackageSet(atom) {
+		return nil
+	}
+	category, pn := splitCategory(atomCP(atom))
+	var out []string
+	for _, rel := range rels {
+		if artifactIsPackage(rel, category, pn) {
+			out = append(out, rel)
+		}
+	}
+	return out
+}
+
+// bundleAtoms lists a bundle's atoms for messages.
+func bundleAtoms(pkgs []PackageSpec) string {
+	atoms := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		atoms = append(atoms, pkg.Atom)
+	}
+	return strings.Join(atoms, " ")
+}
+
+// signBundleArtifacts signs every artifact of a bundle build that emerge did
+// not already sign (binpkg-signing), as collectAndUploadArtifact does for
+// legacy builds.
+func (lb *LocalBuilder) signBundleArtifacts(job *BuildJob) {
+	for _, rel := range job.artifactsSnapshot() {
+		path := filepath.Join(lb.artifactDir, rel)
+		if gpkgIsSigned(path) {
+			job.setMetadata("signed", true)
+			continue
+		}
+		if lb.signer != nil && lb.signer.IsEnabled() {
+			if lb.skipExpiredSigning(job, rel) {
+				continue
+			}
+			embedded, err := lb.signPackage(path)
+			if err != nil {
+				job.appendLog(fmt.Sprintf("Warning: failed to sign %s: %v\n", rel, err))
+				continue
+			}
+			if embedded {
+				job.setMetadata("signed", true)
+				continue
+			}
+			job.recordSignature(rel)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBundleBuildSpec(t *testing.T) {
+	bundle := &ConfigBundle{
+		Config: &PortageConfig{PackageUse: map[string][]string{"app-misc/jq": {"oniguruma"}}},
+		Packages: &BuildPackageSpec{Packages: []PackageSpec{
+			{Atom: "app-misc/jq", UseFlags: []string{"-static"}, Environment: map[string]string{"MAKEOPTS": "-j2"}},
+			{Atom: "app-editors/vim", Version: "9.1", Keywords: []string{"~amd64"}, Environment: map[string]string{"MAKEOPTS": "-j4"}},
+			{Atom: "@system"},
+		}},
+	}
+
+	built, spec := bundleBuildSpec(bundle, "")
+	if got := built.Config.PackageUse["app-misc/jq"]; !reflect.DeepEqual(got, []string{"oniguruma", "-static"}) {
+		t.Errorf("package.use for jq = %v", got)
+	}
+	if got := built.Config.PackageKeywords["=app-editors/vim-9.1"]; !reflect.DeepEqual(got, []string{"~amd64"}) {
+		t.Errorf("package.accept_keywords for vim = %v", got)
+	}
+	if spec.Environment["MAKEOPTS"] != "-j4" {
+		t.Errorf("merged environment = %v, want the later package's MAKEOPTS", spec.Environment)
+	}
+	if got := bundle.Config.PackageUse["app-misc/jq"]; len(got) != 1 {
+		t.Errorf("request config was modified: %v", got)
+	}
+
+	cmd := NewBuildExecutor("/work", "/art").bundleEmergeCommand(built)
+	tail := strings.Join(cmd[len(cmd)-4:], " ")
+	if tail != "--keep-going=y app-misc/jq =app-editors/vim-9.1 @system" {
+		t.Errorf("emerge command ends %q, want every target in one invocation", tail)
+	}
+
+	single := &ConfigBundle{Packages: &BuildPackageSpec{Packages: []PackageSpec{{Atom: "app-misc/jq"}}}}
+	if got, _ := bundleBuildSpec(single, ""); got != single {
+		t.Error("a one-package bundle should be built as is")
+	}
+}
+
+// writePackages creates empty binary packages under dir.
+func writePackages(t *testing.T, dir string, rels ...string) {
+	t.Helper()
+	for _, rel := range rels {
+		path := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(rel), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollectBundleArtifacts(t *testing.T) {
+	pkgs := []PackageSpec{{Atom: "app-misc/jq"}, {Atom: "app-editors/vim"}}
+	pkgDir, artDir := t.TempDir(), t.TempDir()
+	writePackages(t, pkgDir,
+		"app-misc/jq/jq-1.7-1.gpkg.tar",
+		"dev-libs/oniguruma/oniguruma-6.9-1.gpkg.tar",
+		"app-editors/vim/vim-9.1-1.gpkg.tar",
+	)
+	be := NewBuildExecutor(t.TempDir(), artDir)
+
+	job := &BuildJob{ID: "j1"}
+	if err := be.collectBundleArtifacts(job, pkgs, pkgDir, nil); err != nil {
+		t.Fatalf("collectBundleArtifacts: %v", err)
+	}
+	if len(job.Artifacts) != 3 || !reflect.DeepEqual(job.Metadata["artifacts"], job.Artifacts) {
+		t.Errorf("artifacts = %v, metadata %v", job.Artifacts, job.Metadata["artifacts"])
+	}
+	if want := filepath.Join(artDir, "app-misc/jq/jq-1.7-1.gpkg.tar"); job.ArtifactURL != want {
+		t.Errorf("primary artifact = %q, want %q", job.ArtifactURL, want)
+	}
+	if _, err := os.Stat(filepath.Join(artDir, "dev-libs/oniguruma/oniguruma-6.9-1.gpkg.tar")); err != nil {
+		t.Errorf("dependency package not collected: %v", err)
+	}
+	results := job.Metadata["package_results"].([]BundlePackageResult)
+	if results[1].Status != bundlePackageBuilt || results[1].Artifacts[0] != "app-editors/vim/vim-9.1-1.gpkg.tar" {
+		t.Errorf("package results = %+v", results)
+	}
+	if job.Metadata["partial"] != nil {
+		t.Error("a complete build was marked partial")
+	}
+}
+
+func TestCollectBundleArtifactsPartial(t *testing.T) {
+	pkgs := []PackageSpec{{Atom: "app-misc/jq"}, {Atom: "app-editors/vim"}}
+	pkgDir := t.TempDir()
+	writePackages(t, pkgDir, "app-misc/jq/jq-1.7-1.gpkg.tar")
+	be := NewBuildExecutor(t.TempDir(), t.TempDir())
+
+	buildErr := errors.New("emerge failed: exit status 1")
+	job := &BuildJob{ID: "j1"}
+	err := be.collectBundleArtifacts(job, pkgs, pkgDir, buildErr)
+	if !errors.Is(err, buildErr) || !strings.Contains(err.Error(), "1 of 2 packages built, failed: app-editors/vim") {
+		t.Errorf("error = %v, want a partial build naming vim", err)
+	}
+	if job.Metadata["partial"] != true {
+		t.Error("partial build not marked in metadata")
+	}
+	results := job.Metadata["package_results"].([]BundlePackageResult)
+	if results[0].Status != bundlePackageBuilt || results[1].Status != bundlePackageFailed {
+		t.Errorf("package results = %+v", results)
+	}
+
+	empty := &BuildJob{ID: "j2"}
+	if err := be.collectBundleArtifacts(empty, pkgs, t.TempDir(), nil); !errors.Is(err, errNoArtifact) {
+		t.Errorf("no packages: error = %v, want errNoArtifact", err)
+	}
+}
+
+func TestExecuteBundleBuildOneEmerge(t *testing.T) {
+	// A stand-in emerge that records its arguments and produces a package
+	// for each target in PKGDIR.
+	bin := t.TempDir()
+	argsFile := filepath.Join(t.TempDir(), "args")
+	script := `#!/bin/sh
+echo "$@" >> ` + argsFile + `
+for a in "$@"; do
+  case "$a" in
+    */*) pn="${a##*/}"; mkdir -p "$PKGDIR/$a" && touch "$PKGDIR/$a/$pn-1.0-1.gpkg.tar" ;;
+  esac
+done
+`
+	if err := os.WriteFile(filepath.Join(bin, "emerge"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	artDir := t.TempDir()
+	be := NewBuildExecutor(t.TempDir(), artDir)
+	job := &BuildJob{ID: "j1"}
+	bundle := &ConfigBundle{
+		Packages: &BuildPackageSpec{Packages: []PackageSpec{{Atom: "app-misc/jq"}, {Atom: "app-editors/vim"}}},
+		Metadata: BundleMetadata{TargetArch: "amd64"},
+	}
+	if err := be.ExecuteBuild(context.Background(), bundle, job); err != nil {
+		t.Fatalf("ExecuteBuild: %v\n%s", err, job.Log)
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(args)), "\n"); len(lines) != 1 || !strings.HasSuffix(lines[0], "app-misc/jq app-editors/vim") {
+		t.Errorf("emerge runs = %q, want one run with both packages", lines)
+	}
+	if len(job.Artifacts) != 2 {
+		t.Errorf("artifacts = %v, want one per package", job.Artifacts)
+	}
+}
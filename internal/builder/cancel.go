@@ -0,0 +1,73 @@
+// Package builder provides cancellation of queued and running builds.
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// cancelledStatus is the terminal status of a build cancelled on request,
+// whether it was still queued or already running.
+const cancelledStatus = "cancelled"
+
+// containerStopTimeout bounds stopping and removing a build container after
+// its build ended or was cancelled.
+const containerStopTimeout = 30 * time.Second
+
+// ErrJobNotFound is returned for a job ID the builder does not know.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobFinished is returned by CancelJob for a job that already finished.
+var ErrJobFinished = errors.New("job already finished")
+
+// CancelJob cancels a queued or running build. A queued job is taken off
+// the queue and marked cancelled at once. A running job has its build
+// context cancelled, which kills the build process; its worker marks it
+// cancelled once the process has exited. Cancelling a finished job returns
+// ErrJobFinished.
+func (lb *LocalBuilder) CancelJob(jobID string) error {
+	job, exists := lb.findJob(jobID)
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+	if lb.cancelQueued(job, "build cancelled before it started") {
+		log.Printf("Job %s cancelled while queued", jobID)
+		return nil
+	}
+
+	job.mu.Lock()
+	status, cancel := job.Status, job.cancel
+	job.mu.Unlock()
+	if status != "building" {
+		return fmt.Errorf("%w: job %s is %s", ErrJobFinished, jobID, status)
+	}
+	job.appendLog("[cancel] cancellation requested; stopping the build\n")
+	if cancel != nil {
+		cancel()
+	}
+	log.Printf("Job %s cancellation requested while building", jobID)
+	return nil
+}
+
+// cancelQueued marks job cancelled with reason and takes it off the queue if
+// it is still queued, reporting whether it was.
+func (lb *LocalBuilder) cancelQueued(job *BuildJob, reason string) bool {
+	job.mu.Lock()
+	if job.Status != "queued" {
+		job.mu.Unlock()
+		return false
+	}
+	job.Status = cancelledStatus
+	job.EndTime = time.Now()
+	job.Error = reason
+	job.logSubs.closeAll()
+	job.mu.Unlock()
+
+	if lb.jobQueue != nil {
+		lb.jobQueue.remove(job.ID)
+	}
+	lb.retireJob(job)
+	return true
+}
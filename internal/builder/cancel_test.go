@@ -0,0 +1,79 @@
+package builder
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCancelQueuedJob(t *testing.T) {
+	job := &BuildJob{ID: "j1", Status: "queued"}
+	lb := &LocalBuilder{jobs: map[string]*BuildJob{"j1": job}}
+
+	if err := lb.CancelJob("j1"); err != nil {
+		t.Fatalf("CancelJob: %v", err)
+	}
+	if job.Status != cancelledStatus {
+		t.Errorf("status = %q, want %q", job.Status, cancelledStatus)
+	}
+	if job.EndTime.IsZero() {
+		t.Error("EndTime not set on a cancelled job")
+	}
+	if err := lb.CancelJob("j1"); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("cancelling twice: got %v, want ErrJobFinished", err)
+	}
+}
+
+func TestCancelBuildingJob(t *testing.T) {
+	called := false
+	job := &BuildJob{ID: "j1", Status: "building", cancel: func() { called = true }}
+	lb := &LocalBuilder{jobs: map[string]*BuildJob{"j1": job}}
+
+	if err := lb.CancelJob("j1"); err != nil {
+		t.Fatalf("CancelJob: %v", err)
+	}
+	if !called {
+		t.Error("build context was not cancelled")
+	}
+	// The worker sets the final status once the build process has exited.
+	if job.Status != "building" {
+		t.Errorf("status = %q, want building until the worker finishes", job.Status)
+	}
+}
+
+func TestCancelJobErrors(t *testing.T) {
+	lb := &LocalBuilder{jobs: map[string]*BuildJob{
+		"done": {ID: "done", Status: "success"},
+	}}
+
+	if err := lb.CancelJob("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("unknown job: got %v, want ErrJobNotFound", err)
+	}
+	if err := lb.CancelJob("done"); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("finished job: got %v, want ErrJobFinished", err)
+	}
+}
+
+func TestWorkerSkipsCancelledJob(t *testing.T) {
+	job := &BuildJob{ID: "j1", Status: "queued", Request: &LocalBuildRequest{PackageName: "app-misc/jq"}}
+	lb := &LocalBuilder{jobs: map[string]*BuildJob{"j1": job}, jobQueue: newJobQueue(1)}
+	if err := lb.jobQueue.push(job, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := lb.CancelJob("j1"); err != nil {
+		t.Fatalf("CancelJob: %v", err)
+	}
+	if n := lb.jobQueue.len(); n != 0 {
+		t.Errorf("cancelled job still queued (%d queued)", n)
+	}
+
+	// A cancelled job that still reaches a worker is skipped.
+	if err := lb.jobQueue.push(job, 0); err != nil {
+		t.Fatal(err)
+	}
+	lb.jobQueue.close()
+	lb.worker(0)
+
+	if job.Status != cancelledStatus {
+		t.Errorf("status = %q, want %q", job.Status, cancelledStatus)
+	}
+}
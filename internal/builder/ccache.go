@@ -0,0 +1,178 @@
+// Package builder provides ccache support for repeated builds.
+package builder
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ccacheMountPoint is where the persistent ccache directory is mounted in the
+// build container.
+const ccacheMountPoint = "/var/cache/ccache"
+
+// The container build script brackets `ccache -s` output with these marker
+// lines so the statistics can be told apart from the emerge output.
+const (
+	ccacheStatsBegin = "[ccache] stats"
+	ccacheStatsEnd   = "[ccache] end"
+)
+
+var (
+	// ccache >= 4 prints "Hits: 80 / 100 (80.00%)" and "Misses: 20 / 100".
+	ccacheHitsPattern   = regexp.MustCompile(`(?m)^\s*Hits:\s+(\d+)`)
+	ccacheMissesPattern = regexp.MustCompile(`(?m)^\s*Misses:\s+(\d+)`)
+	// ccache 3 prints "cache hit (direct) 70", "cache hit (preprocessed) 10"
+	// and "cache miss 20".
+	ccacheLegacyHitPattern  = regexp.MustCompile(`(?m)^cache hit \((?:direct|preprocessed)\)\s+(\d+)`)
+	ccacheLegacyMissPattern = regexp.MustCompile(`(?m)^cache miss\s+(\d+)`)
+)
+
+// ccacheDir returns the host directory holding the persistent ccache, or ""
+// when ccache is disabled.
+func (lb *LocalBuilder) ccacheDir() string {
+	if lb.cfg == nil || !lb.cfg.CCacheEnabled {
+		return ""
+	}
+	if lb.cfg.CCacheDir != "" {
+		return lb.cfg.CCacheDir
+	}
+	return ccacheMountPoint
+}
+
+// addCCacheMount mounts the persistent ccache directory into the container.
+func (lb *LocalBuilder) addCCacheMount(args []string) []string {
+	dir := lb.ccacheDir()
+	if dir == "" {
+		return args
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Warning: failed to create ccache dir %s: %v", dir, err)
+		return args
+	}
+	return append(args, "-v", dir+":"+ccacheMountPoint)
+}
+
+// ccacheScriptSetup returns the build script lines enabling ccache, or "" when
+// it is disabled. An image without ccache builds without it rather than
+// failing on FEATURES=ccache.
+func (lb *LocalBuilder) ccacheScriptSetup() string {
+	if lb.ccacheDir() == "" {
+		return ""
+	}
+	return `
+if command -v ccache >/dev/null 2>&1; then
+    export CCACHE_DIR=` + ccacheMountPoint + `
+    export FEATURES="${FEATURES} ccache"
+    ccache -z >/dev/null 2>&1 || true
+else
+    echo "ccache is not installed in the build image; building without it"
+fi
+`
+}
+
+// ccacheScriptStats returns the build script lines printing this build's
+// ccache statistics, or "" when ccache is disabled.
+func (lb *LocalBuilder) ccacheScriptStats() string {
+	if lb.ccacheDir() == "" {
+		return ""
+	}
+	return `if [ -n "${CCACHE_DIR:-}" ]; then
+    echo "` + ccacheStatsBegin + `"
+    ccache -s 2>/dev/null || true
+    echo "` + ccacheStatsEnd + `"
+fi`
+}
+
+// ccacheNativeEnv adds CCACHE_DIR and the ccache feature to a native build's
+// environment, keeping any FEATURES already set for it.
+func (lb *LocalBuilder) ccacheNativeEnv(env []string, reqEnv map[string]string) []string {
+	dir := lb.ccacheDir()
+	if dir == "" {
+		return env
+	}
+	features, ok := reqEnv["FEATURES"]
+	if !ok {
+		features = os.Getenv("FEATURES")
+	}
+	return append(env, "CCACHE_DIR="+dir, "FEATURES="+strings.TrimSpace(features+" ccache"))
+}
+
+// runCCache runs ccache with args in a native build's environment and returns
+// its output, or "" when ccache is disabled or not installed.
+func (lb *LocalBuilder) runCCache(env []string, args ...string) string {
+	if lb.ccacheDir() == "" {
+		return ""
+	}
+	path, err := exec.LookPath("ccache")
+	if err != nil {
+		return ""
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Printf("Warning: ccache %s failed: %v", strings.Join(args, " "), err)
+		return ""
+	}
+	return string(out)
+}
+
+// scriptCCacheStats extracts the ccache statistics a container build script
+// printed between its marker lines.
+func scriptCCacheStats(output string) string {
+	_, rest, found := strings.Cut(output, ccacheStatsBegin+"\n")
+	if !found {
+		return ""
+	}
+	stats, _, _ := strings.Cut(rest, ccacheStatsEnd)
+	return stats
+}
+
+// parseCCacheStats returns the cache hits and misses in `ccache -s` output.
+func parseCCacheStats(stats string) (hits, misses int, ok bool) {
+	if m := ccacheHitsPattern.FindStringSubmatch(stats); m != nil {
+		hits, _ = strconv.Atoi(m[1])
+		if m := ccacheMissesPattern.FindStringSubmatch(stats); m != nil {
+			misses, _ = strconv.Atoi(m[1])
+		}
+		return hits, misses, true
+	}
+	legacy := ccacheLegacyHitPattern.FindAllStringSubmatch(stats, -1)
+	miss := ccacheLegacyMissPattern.FindStringSubmatch(stats)
+	if legacy == nil && miss == nil {
+		return 0, 0, false
+	}
+	for _, m := range legacy {
+		n, _ := strconv.Atoi(m[1])
+		hits += n
+	}
+	if miss != nil {
+		misses, _ = strconv.Atoi(miss[1])
+	}
+	return hits, misses, true
+}
+
+// recordCCacheStats stores the ccache hits, misses and hit rate (percent) in
+// the job metadata when stats can be parsed. The statistics are the shared
+// cache's since the build zeroed them, so concurrent builds on one cache
+// directory blur each other's numbers.
+func recordCCacheStats(job *BuildJob, stats string) {
+	hits, misses, ok := parseCCacheStats(stats)
+	if !ok {
+		return
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.Metadata == nil {
+		job.Metadata = map[string]interface{}{}
+	}
+	job.Metadata["ccache_hits"] = hits
+	job.Metadata["ccache_misses"] = misses
+	if total := hits + misses; total > 0 {
+		job.Metadata["ccache_hit_rate"] = float64(hits) / float64(total) * 100
+	}
+}
@@ -0,0 +1,87 @@
+package builder
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestParseCCacheStats(t *testing.T) {
+	modern := `Cacheable calls:   100 / 120 (83.33%)
+  Hits:             80 / 100 (80.00%)
+    Direct:         70 /  80 (87.50%)
+    Preprocessed:   10 /  80 (12.50%)
+  Misses:           20 / 100 (20.00%)
+`
+	if hits, misses, ok := parseCCacheStats(modern); !ok || hits != 80 || misses != 20 {
+		t.Errorf("ccache 4: got %d/%d/%v", hits, misses, ok)
+	}
+
+	legacy := `cache directory                     /var/cache/ccache
+cache hit (direct)                    70
+cache hit (preprocessed)              10
+cache miss                            20
+`
+	if hits, misses, ok := parseCCacheStats(legacy); !ok || hits != 80 || misses != 20 {
+		t.Errorf("ccache 3: got %d/%d/%v", hits, misses, ok)
+	}
+
+	if _, _, ok := parseCCacheStats(">>> Emerging app-misc/jq\n"); ok {
+		t.Error("expected no stats in plain emerge output")
+	}
+}
+
+func TestRecordCCacheStatsFromScript(t *testing.T) {
+	output := "Hits: 1 in emerge noise\n" + ccacheStatsBegin + "\n  Hits: 3 / 4\n  Misses: 1 / 4\n" + ccacheStatsEnd + "\n"
+	job := &BuildJob{}
+	recordCCacheStats(job, scriptCCacheStats(output))
+	if job.Metadata["ccache_hits"] != 3 || job.Metadata["ccache_misses"] != 1 || job.Metadata["ccache_hit_rate"] != 75.0 {
+		t.Errorf("metadata = %v", job.Metadata)
+	}
+
+	job = &BuildJob{}
+	recordCCacheStats(job, scriptCCacheStats("no stats here\n"))
+	if job.Metadata != nil {
+		t.Errorf("metadata = %v, want none", job.Metadata)
+	}
+}
+
+func TestCCacheDisabledByDefault(t *testing.T) {
+	lb := &LocalBuilder{cfg: &config.BuilderConfig{}, pkgMgr: &GentooPackageManager{}}
+	if script := lb.generateBuildScript("app-misc/jq", "", "", "", jobControl{}); strings.Contains(script, "ccache") {
+		t.Error("script should not use ccache unless enabled")
+	}
+	if args := lb.buildDockerArgs("/tmp/out", "", nil, ""); strings.Contains(strings.Join(args, " "), "ccache") {
+		t.Errorf("unexpected ccache mount: %v", args)
+	}
+	if env := lb.ccacheNativeEnv(nil, nil); len(env) != 0 {
+		t.Errorf("unexpected ccache env: %v", env)
+	}
+}
+
+func TestCCacheEnabled(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ccache")
+	lb := &LocalBuilder{cfg: &config.BuilderConfig{CCacheEnabled: true, CCacheDir: dir}, pkgMgr: &GentooPackageManager{}}
+
+	script := lb.generateBuildScript("app-misc/jq", "", "", "", jobControl{})
+	for _, want := range []string{"export CCACHE_DIR=" + ccacheMountPoint, `FEATURES="${FEATURES} ccache"`, ccacheStatsBegin} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q", want)
+		}
+	}
+	if strings.Index(script, "ccache -z") > strings.Index(script, "if ! emerge") {
+		t.Error("ccache stats must be zeroed before the build")
+	}
+
+	if args := strings.Join(lb.buildDockerArgs("/tmp/out", "", nil, ""), " "); !strings.Contains(args, "-v "+dir+":"+ccacheMountPoint) {
+		t.Errorf("docker args missing the ccache mount: %s", args)
+	}
+
+	env := lb.ccacheNativeEnv(nil, map[string]string{"FEATURES": "test"})
+	if !slices.Contains(env, "CCACHE_DIR="+dir) || !slices.Contains(env, "FEATURES=test ccache") {
+		t.Errorf("native env = %v", env)
+	}
+}
@@ -0,0 +1,117 @@
+// Package builder provides SHA-256 and SHA-512 checksum files for artifacts.
+package builder
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extensions of the checksum files written next to an artifact, in the
+// format of sha256sum and sha512sum, so `sha256sum -c` can check it.
+const (
+	sha256FileExt = ".sha256"
+	sha512FileExt = ".sha512"
+)
+
+// artifactDigests are the hex digests of an artifact.
+type artifactDigests struct {
+	SHA256 string
+	SHA512 string
+}
+
+// hashArtifact computes the SHA-256 and SHA-512 digests of the file at path
+// in one streaming pass.
+func hashArtifact(path string) (artifactDigests, error) {
+	f, err := os.Open(path) // #nosec G304 -- an artifact in our own artifact dir.
+	if err != nil {
+		return artifactDigests{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	h256, h512 := sha256.New(), sha512.New()
+	if _, err := io.Copy(io.MultiWriter(h256, h512), f); err != nil {
+		return artifactDigests{}, fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return artifactDigests{
+		SHA256: hex.EncodeToString(h256.Sum(nil)),
+		SHA512: hex.EncodeToString(h512.Sum(nil)),
+	}, nil
+}
+
+// writeChecksumFiles hashes the artifact at path and writes path.sha256 and
+// path.sha512 next to it, returning the digests.
+func writeChecksumFiles(path string) (artifactDigests, error) {
+	d, err := hashArtifact(path)
+	if err != nil {
+		return artifactDigests{}, err
+	}
+	name := filepath.Base(path)
+	for ext, sum := range map[string]string{sha256FileExt: d.SHA256, sha512FileExt: d.SHA512} {
+		line := fmt.Sprintf("%s  %s\n", sum, name)
+		if err := os.WriteFile(path+ext, []byte(line), 0644); err != nil { // #nosec G306 -- checksums are public.
+			return artifactDigests{}, fmt.Errorf("failed to write checksum file: %w", err)
+		}
+	}
+	return d, nil
+}
+
+// readArtifactDigests returns the digests of the artifact at path from its
+// checksum files, hashing the artifact for any that are missing.
+func readArtifactDigests(path string) (artifactDigests, error) {
+	d := artifactDigests{
+		SHA256: readChecksumFile(path+sha256FileExt, sha256.New()),
+		SHA512: readChecksumFile(path+sha512FileExt, sha512.New()),
+	}
+	if d.SHA256 != "" && d.SHA512 != "" {
+		return d, nil
+	}
+	return hashArtifact(path)
+}
+
+// readChecksumFile returns the digest in a sha*sum style checksum file, or ""
+// if it is missing or does not hold a digest of h's size.
+func readChecksumFile(path string, h hash.Hash) string {
+	data, err := os.ReadFile(path) // #nosec G304 -- a checksum file in our own artifact dir.
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != 2*h.Size() {
+		return ""
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
+
+// checksumFiles returns the checksum files written for the artifact at path
+// that exist.
+func checksumFiles(path string) []string {
+	var files []string
+	for _, ext := range []string{sha256FileExt, sha512FileExt} {
+		if _, err := os.Stat(path + ext); err == nil {
+			files = append(files, path+ext)
+		}
+	}
+	return files
+}
+
+// writeArtifactChecksums writes the checksum files of each artifact (relative
+// to the artifact dir). A failure is logged but does not fail the build.
+func (lb *LocalBuilder) writeArtifactChecksums(job *BuildJob, rels []string) {
+	for _, rel := range rels {
+		if _, err := writeChecksumFiles(filepath.Join(lb.artifactDir, rel)); err != nil {
+			log.Printf("Warning: failed to write checksums for %s: %v", rel, err)
+			job.appendLog(fmt.Sprintf("Warning: failed to write checksums for %s: %v\n", rel, err))
+		}
+	}
+}
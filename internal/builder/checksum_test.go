@@ -0,0 +1,139 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/slchris/portage-engine/internal/storage"
+)
+
+const (
+	// Digests of "payload".
+	payloadSHA256 = "239f59ed55e737c77147cf55ad0c1b030b6d7ee748a7426952f9b852d5a935e5"
+	payloadSHA512 = "70b33ce9c9047e30f917e7ea13e42f7767008c3f4f9c9baf49e4390fc625549e9625eee39b94545074e8a1824cf3f238463b11bc03d97348e0fc2999ca1fff7f"
+)
+
+func TestWriteChecksumFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jq-1.7.gpkg.tar")
+	if err := os.WriteFile(path, []byte("payload"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := writeChecksumFiles(path)
+	if err != nil {
+		t.Fatalf("writeChecksumFiles: %v", err)
+	}
+	if d.SHA256 != payloadSHA256 || d.SHA512 != payloadSHA512 {
+		t.Errorf("digests = %+v", d)
+	}
+	data, err := os.ReadFile(path + sha256FileExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := payloadSHA256 + "  jq-1.7.gpkg.tar\n"; string(data) != want {
+		t.Errorf("%s = %q, want %q", sha256FileExt, data, want)
+	}
+	if got := checksumFiles(path); len(got) != 2 {
+		t.Errorf("checksumFiles = %v, want both files", got)
+	}
+}
+
+func TestReadArtifactDigests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jq-1.7.gpkg.tar")
+	if err := os.WriteFile(path, []byte("payload"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without checksum files the artifact is hashed.
+	d, err := readArtifactDigests(path)
+	if err != nil {
+		t.Fatalf("readArtifactDigests: %v", err)
+	}
+	if d.SHA256 != payloadSHA256 || d.SHA512 != payloadSHA512 {
+		t.Errorf("digests = %+v", d)
+	}
+
+	// With them, their digests are used as is.
+	if _, err := writeChecksumFiles(path); err != nil {
+		t.Fatal(err)
+	}
+	sum := strings.Repeat("a", 64)
+	if err := os.WriteFile(path+sha256FileExt, []byte(sum+"  jq-1.7.gpkg.tar\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d, err = readArtifactDigests(path)
+	if err != nil {
+		t.Fatalf("readArtifactDigests: %v", err)
+	}
+	if d.SHA256 != sum || d.SHA512 != payloadSHA512 {
+		t.Errorf("digests = %+v, want the checksum files' values", d)
+	}
+}
+
+func TestGetArtifactInfoDigests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jq-1.7.gpkg.tar")
+	if err := os.WriteFile(path, []byte("payload"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lb := &LocalBuilder{jobs: map[string]*BuildJob{
+		"j1": {ID: "j1", Status: "success", ArtifactURL: path, Request: &LocalBuildRequest{PackageName: "app-misc/jq"}},
+	}}
+
+	info, err := lb.GetArtifactInfo("j1")
+	if err != nil {
+		t.Fatalf("GetArtifactInfo: %v", err)
+	}
+	if info.SHA256 != payloadSHA256 || info.SHA512 != payloadSHA512 {
+		t.Errorf("info digests = %q, %q", info.SHA256, info.SHA512)
+	}
+}
+
+func TestUploadArtifactWithChecksums(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "artifacts", "jq-1.7.gpkg.tar")
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("payload"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeChecksumFiles(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+provenanceFileExt, []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	remote, err := storage.NewLocalStorage(filepath.Join(dir, "remote"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := &LocalBuilder{storageUpload: &StorageUploader{storage: remote, enabled: true}}
+	job := &BuildJob{ID: "j1"}
+
+	lb.uploadArtifact(job, path)
+
+	blob := BlobPath(payloadSHA256)
+	for _, name := range []string{blob, blob + sha256FileExt, blob + sha512FileExt, blob + provenanceFileExt} {
+		if _, err := os.Stat(filepath.Join(dir, "remote", name)); err != nil {
+			t.Errorf("%s not uploaded: %v", name, err)
+		}
+	}
+	if job.Metadata["uploaded"] != true {
+		t.Error("job not marked uploaded")
+	}
+
+	// The same package built again is not uploaded twice.
+	if err := os.Remove(filepath.Join(dir, "remote", blob+sha512FileExt)); err != nil {
+		t.Fatal(err)
+	}
+	again := &BuildJob{ID: "j2"}
+	lb.uploadArtifact(again, path)
+	if _, err := os.Stat(filepath.Join(dir, "remote", blob+sha512FileExt)); err == nil {
+		t.Error("an already stored package was uploaded again")
+	}
+	if again.Metadata["uploaded"] != true || !strings.HasSuffix(again.ArtifactURL, blob) {
+		t.Errorf("second job: uploaded=%v url=%q, want the stored blob", again.Metadata["uploaded"], again.ArtifactURL)
+	}
+}
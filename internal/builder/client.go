@@ -20,7 +20,17 @@ type HeartbeatRequest struct {
 	Endpoint   string    `json:"endpoint"`
 	Capacity   int       `json:"capacity"`
 	ActiveJobs int       `json:"active_jobs"`
+	Version    string    `json:"version,omitempty"`
+	APIVersion int       `json:"api_version,omitempty"`
 	Timestamp  time.Time `json:"timestamp"`
+	// TreeLastSync and TreeRevision describe the builder's portage tree;
+	// they are unset when the sync time or git revision is unknown.
+	TreeLastSync time.Time `json:"tree_last_sync,omitzero"`
+	TreeRevision string    `json:"tree_revision,omitempty"`
+	// Architecture is the Gentoo arch the builder builds for.
+	Architecture string `json:"architecture,omitempty"`
+	// EmulatedArchitectures are the other arches it builds for under QEMU.
+	EmulatedArchitectures []string `json:"emulated_architectures,omitempty"`
 }
 
 // HeartbeatResponse represents the server's response to a heartbeat.
@@ -190,7 +200,7 @@ func (bc *Client) WaitForCompletion(jobID string, timeout time.Duration) (*Build
 			return nil, err
 		}
 
-		if job.Status == "success" || job.Status == "failed" {
+		if job.Status == "success" || job.Status == "success_no_artifact" || job.Status == "failed" {
 			return job, nil
 		}
 
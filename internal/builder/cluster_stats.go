@@ -0,0 +1,94 @@
+// Package builder provides incrementally maintained cluster statistics.
+package builder
+
+import (
+	"time"
+)
+
+// remoteStatsTTL is how long the aggregated remote builder stats are reused
+// before the builders are queried again.
+const remoteStatsTTL = 5 * time.Second
+
+// jobCounts are the aggregates of the server's local jobs. They are updated
+// on every insert, status transition and delete, always under jobsMu, so
+// GetClusterStatus never has to scan the job table.
+type jobCounts struct {
+	total     int
+	queued    int
+	active    int
+	completed int
+	failed    int
+	expired   int
+	cancelled int
+}
+
+// add counts (delta 1) or uncounts (delta -1) one job in status.
+func (c *jobCounts) add(status string, delta int) {
+	c.total += delta
+	switch {
+	case status == "queued":
+		c.queued += delta
+	case status == "failed":
+		c.failed += delta
+	case status == expiredStatus:
+		c.expired += delta
+	case status == cancelledStatus:
+		c.cancelled += delta
+	case terminalStatus(status):
+		// completed, success and success_no_artifact all finished cleanly.
+		c.completed += delta
+	default:
+		c.active += delta
+	}
+}
+
+// putJobLocked stores job under id, keeping the counters in step. Callers
+// hold jobsMu for writing.
+func (m *Manager) putJobLocked(id string, job *BuildStatus) {
+	if old, ok := m.jobs[id]; ok {
+		m.counts.add(old.Status, -1)
+	}
+	m.jobs[id] = job
+	m.counts.add(job.Status, 1)
+}
+
+// deleteJobLocked removes the job id, keeping the counters in step. Callers
+// hold jobsMu for writing.
+func (m *Manager) deleteJobLocked(id string) {
+	if old, ok := m.jobs[id]; ok {
+		m.counts.add(old.Status, -1)
+		delete(m.jobs, id)
+	}
+	m.endLogStreamsLocked(id)
+}
+
+// setStatusLocked transitions job to status, keeping the counters in step.
+// Callers hold jobsMu for writing.
+func (m *Manager) setStatusLocked(job *BuildStatus, status string) {
+	finished := terminalStatus(status) && !terminalStatus(job.Status)
+	m.counts.add(job.Status, -1)
+	job.Status = status
+	m.counts.add(status, 1)
+	if status == "building" && job.buildStart.IsZero() {
+		job.buildStart = time.Now()
+	}
+	if finished {
+		m.recordBuildLocked(job, time.Now())
+	}
+	if terminalStatus(status) {
+		m.endLogStreamsLocked(job.JobID)
+	}
+}
+
+// cachedRemoteStats returns the aggregated remote builder stats, querying the
+// builders at most once per remoteStatsTTL. Concurrent callers share one
+// fan-out instead of each polling every builder.
+func (m *Manager) cachedRemoteStats() ClusterStatus {
+	m.remoteStatsMu.Lock()
+	defer m.remoteStatsMu.Unlock()
+	if m.remoteStats == nil || time.Since(m.remoteStatsAt) >= remoteStatsTTL {
+		m.remoteStats = m.fetchRemoteBuilderStats()
+		m.remoteStatsAt = time.Now()
+	}
+	return *m.remoteStats
+}
@@ -0,0 +1,113 @@
+package builder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/slchris/portage-engine/internal/iac"
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestClusterStatusTracksTransitions(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+
+	var ids []string
+	for i := 0; i < 5; i++ {
+		id, err := mgr.SubmitBuild(&BuildRequest{PackageName: "app-misc/hello", Version: "1." + strconv.Itoa(i), Arch: "amd64"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	mgr.updateStatus(ids[0], "deploying", "", "")
+	mgr.updateStatus(ids[1], "success", "", "")
+	mgr.updateStatus(ids[2], "completed", "", "")
+	mgr.updateStatus(ids[3], "failed", "", "boom")
+
+	status := mgr.GetClusterStatus()
+	if status.TotalBuilds != 5 || status.QueuedBuilds != 1 || status.ActiveBuilds != 1 ||
+		status.CompletedBuilds != 2 || status.FailedBuilds != 1 {
+		t.Errorf("status = %+v", status)
+	}
+	// 2 completed / (2 completed + 1 failed); the queued and deploying
+	// builds do not count.
+	if want := float64(2) / float64(3) * 100; status.SuccessRate != want {
+		t.Errorf("SuccessRate = %f, want %f", status.SuccessRate, want)
+	}
+
+	if err := mgr.DeleteJob(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if n := mgr.CleanupFailedJobs(); n != 1 {
+		t.Fatalf("CleanupFailedJobs() = %d, want 1", n)
+	}
+	status = mgr.GetClusterStatus()
+	if status.TotalBuilds != 3 || status.CompletedBuilds != 1 || status.FailedBuilds != 0 || status.SuccessRate != 100 {
+		t.Errorf("status after deletes = %+v", status)
+	}
+}
+
+func TestClusterStatusLoadJobs(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+
+	mgr.LoadJobs(map[string]*BuildStatus{
+		"a": {JobID: "a", Status: "completed"},
+		"b": {JobID: "b", Status: "building"}, // failed on restart
+	})
+	status := mgr.GetClusterStatus()
+	if status.TotalBuilds != 2 || status.CompletedBuilds != 1 || status.FailedBuilds != 1 || status.ActiveBuilds != 0 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestClusterStatusCachesRemoteStats(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = w.Write([]byte(`{"workers":2,"completed":3,"failed":1,"total":4}`))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0, RemoteBuilders: []string{srv.URL}})
+	defer mgr.Shutdown()
+
+	for i := 0; i < 3; i++ {
+		status := mgr.GetClusterStatus()
+		if status.CompletedBuilds != 3 || status.FailedBuilds != 1 || status.SuccessRate != 75 {
+			t.Fatalf("status = %+v", status)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("remote builder queried %d times, want 1 within the cache TTL", n)
+	}
+}
+
+func TestInstanceActiveJobs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/status" || r.Header.Get("X-API-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"queued":1,"building":2,"completed":7}`))
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0, BuilderToken: "secret"})
+	defer mgr.Shutdown()
+
+	n, err := mgr.instanceActiveJobs(&iac.Instance{ID: "gcp-1", BuilderEndpoint: srv.URL})
+	if err != nil || n != 3 {
+		t.Errorf("instanceActiveJobs = %d, %v; want 3 queued or building", n, err)
+	}
+	if _, err := mgr.instanceActiveJobs(&iac.Instance{ID: "gcp-2"}); err == nil {
+		t.Error("instance without a builder endpoint reported no error")
+	}
+	if status := mgr.GetClusterStatus(); status.ReapedInstances == nil {
+		t.Error("cluster status has no reaped instance counts")
+	}
+}
@@ -0,0 +1,302 @@
+// Package builder provides diffing and merging of Portage configurations, so
+// a bundle can be previewed against, or layered onto, the system's
+// /etc/portage instead of replacing its files.
+package builder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MergePolicy decides which side wins when both configurations set the same
+// key (a package atom, a make.conf variable, a repository name, ...).
+type MergePolicy string
+
+const (
+	// MergeIncomingWins keeps the incoming configuration's value.
+	MergeIncomingWins MergePolicy = "incoming-wins"
+	// MergeSystemWins keeps the system configuration's value.
+	MergeSystemWins MergePolicy = "system-wins"
+)
+
+// ParseMergePolicy parses a merge policy name.
+func ParseMergePolicy(s string) (MergePolicy, error) {
+	switch p := MergePolicy(s); p {
+	case MergeIncomingWins, MergeSystemWins:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown merge policy %q: want %s or %s", s, MergeIncomingWins, MergeSystemWins)
+}
+
+// DiffEntry is one added, removed or changed key. Old is empty for added
+// keys and New for removed ones.
+type DiffEntry struct {
+	Key string `json:"key"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// CategoryDiff is the difference in one configuration category, each list
+// sorted by key.
+type CategoryDiff struct {
+	Added   []DiffEntry `json:"added,omitempty"`
+	Removed []DiffEntry `json:"removed,omitempty"`
+	Changed []DiffEntry `json:"changed,omitempty"`
+}
+
+// Empty reports whether the category is unchanged.
+func (d CategoryDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// ConfigDiff is the difference between two Portage configurations, by
+// category.
+type ConfigDiff struct {
+	PackageUse      CategoryDiff `json:"package_use"`
+	PackageKeywords CategoryDiff `json:"package_keywords"`
+	PackageMask     CategoryDiff `json:"package_mask"`
+	PackageUnmask   CategoryDiff `json:"package_unmask"`
+	PackageEnv      CategoryDiff `json:"package_env"`
+	EnvFiles        CategoryDiff `json:"env_files"`
+	MakeConf        CategoryDiff `json:"make_conf"`
+	GlobalUse       CategoryDiff `json:"global_use"`
+	Repos           CategoryDiff `json:"repos"`
+}
+
+// categories returns the diff's categories with their Portage file names,
+// in display order.
+func (d *ConfigDiff) categories() []struct {
+	name string
+	diff CategoryDiff
+} {
+	return []struct {
+		name string
+		diff CategoryDiff
+	}{
+		{"package.use", d.PackageUse},
+		{"package.accept_keywords", d.PackageKeywords},
+		{"package.mask", d.PackageMask},
+		{"package.unmask", d.PackageUnmask},
+		{"package.env", d.PackageEnv},
+		{"env", d.EnvFiles},
+		{"make.conf", d.MakeConf},
+		{"global USE", d.GlobalUse},
+		{"repos.conf", d.Repos},
+	}
+}
+
+// Empty reports whether the configurations are the same.
+func (d *ConfigDiff) Empty() bool {
+	for _, c := range d.categories() {
+		if !c.diff.Empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// String renders the diff for people: per changed category, one line per
+// key, prefixed + (added), - (removed) or ~ (changed).
+func (d *ConfigDiff) String() string {
+	if d.Empty() {
+		return "no changes\n"
+	}
+	var sb strings.Builder
+	for _, c := range d.categories() {
+		if c.diff.Empty() {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s:\n", c.name)
+		for _, e := range c.diff.Added {
+			fmt.Fprintf(&sb, "  + %s\n", diffLine(e.Key, e.New))
+		}
+		for _, e := range c.diff.Removed {
+			fmt.Fprintf(&sb, "  - %s\n", diffLine(e.Key, e.Old))
+		}
+		for _, e := range c.diff.Changed {
+			fmt.Fprintf(&sb, "  ~ %s: %s -> %s\n", e.Key, quoteDiffValue(e.Old), quoteDiffValue(e.New))
+		}
+	}
+	return sb.String()
+}
+
+// diffLine renders an added or removed key with its value, if it has one.
+func diffLine(key, value string) string {
+	if value == "" {
+		return key
+	}
+	return fmt.Sprintf("%s %s", key, quoteDiffValue(value))
+}
+
+// quoteDiffValue quotes values that would be ambiguous on one line, such as
+// env file contents.
+func quoteDiffValue(value string) string {
+	if value == "" || strings.ContainsAny(value, "\n\t") {
+		return fmt.Sprintf("%q", value)
+	}
+	return value
+}
+
+// DiffConfig reports what applying incoming would change in current: keys
+// only incoming has are added, keys only current has are removed, and keys
+// whose values differ are changed. A nil configuration is empty.
+func DiffConfig(current, incoming *PortageConfig) *ConfigDiff {
+	if current == nil {
+		current = &PortageConfig{}
+	}
+	if incoming == nil {
+		incoming = &PortageConfig{}
+	}
+	return &ConfigDiff{
+		PackageUse:      diffMaps(flagMap(current.PackageUse), flagMap(incoming.PackageUse)),
+		PackageKeywords: diffMaps(flagMap(current.PackageKeywords), flagMap(incoming.PackageKeywords)),
+		PackageMask:     diffMaps(setMap(current.PackageMask), setMap(incoming.PackageMask)),
+		PackageUnmask:   diffMaps(setMap(current.PackageUnmask), setMap(incoming.PackageUnmask)),
+		PackageEnv:      diffMaps(current.PackageEnv, incoming.PackageEnv),
+		EnvFiles:        diffMaps(current.EnvFiles, incoming.EnvFiles),
+		MakeConf:        diffMaps(current.MakeConf, incoming.MakeConf),
+		GlobalUse:       diffMaps(setMap(current.GlobalUse), setMap(incoming.GlobalUse)),
+		Repos:           diffMaps(repoMap(current.Repos), repoMap(incoming.Repos)),
+	}
+}
+
+// diffMaps diffs two key/value maps.
+func diffMaps(current, incoming map[string]string) CategoryDiff {
+	var d CategoryDiff
+	for key, newValue := range incoming {
+		oldValue, ok := current[key]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, DiffEntry{Key: key, New: newValue})
+		case oldValue != newValue:
+			d.Changed = append(d.Changed, DiffEntry{Key: key, Old: oldValue, New: newValue})
+		}
+	}
+	for key, oldValue := range current {
+		if _, ok := incoming[key]; !ok {
+			d.Removed = append(d.Removed, DiffEntry{Key: key, Old: oldValue})
+		}
+	}
+	for _, entries := range [][]DiffEntry{d.Added, d.Removed, d.Changed} {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	}
+	return d
+}
+
+// flagMap flattens per-atom flag lists to their package.* line values.
+func flagMap(m map[string][]string) map[string]string {
+	flat := make(map[string]string, len(m))
+	for atom, flags := range m {
+		flat[atom] = strings.Join(flags, " ")
+	}
+	return flat
+}
+
+// setMap turns a list such as package.mask into a set of keys.
+func setMap(items []string) map[string]string {
+	set := make(map[string]string, len(items))
+	for _, item := range items {
+		set[item] = ""
+	}
+	return set
+}
+
+// repoMap maps repositories by name to a summary of their settings.
+func repoMap(repos []RepoConfig) map[string]string {
+	m := make(map[string]string, len(repos))
+	for _, repo := range repos {
+		m[repo.Name] = fmt.Sprintf("location=%s sync-type=%s sync-uri=%s priority=%d",
+			repo.Location, repo.SyncType, repo.SyncURI, repo.Priority)
+	}
+	return m
+}
+
+// MergeConfig unions system and incoming into a new configuration. Keys
+// only one side sets are kept; a key both set takes the value of the side
+// policy picks, as a whole (a package.use entry's flags are not mixed).
+// List entries such as package.mask atoms and global USE flags are unioned.
+// Neither input is modified.
+func MergeConfig(system, incoming *PortageConfig, policy MergePolicy) *PortageConfig {
+	if system == nil {
+		system = &PortageConfig{}
+	}
+	if incoming == nil {
+		incoming = &PortageConfig{}
+	}
+	// Apply the losing side first so the winning side overwrites it.
+	first, second := system, incoming
+	if policy == MergeSystemWins {
+		first, second = incoming, system
+	}
+
+	return &PortageConfig{
+		PackageUse:      mergeFlagMaps(first.PackageUse, second.PackageUse),
+		PackageKeywords: mergeFlagMaps(first.PackageKeywords, second.PackageKeywords),
+		PackageMask:     unionLists(system.PackageMask, incoming.PackageMask),
+		PackageUnmask:   unionLists(system.PackageUnmask, incoming.PackageUnmask),
+		MakeConf:        mergeStringMaps(first.MakeConf, second.MakeConf),
+		Environment:     mergeStringMaps(first.Environment, second.Environment),
+		GlobalUse:       unionLists(system.GlobalUse, incoming.GlobalUse),
+		Repos:           mergeRepos(first.Repos, second.Repos),
+		PackageEnv:      mergeStringMaps(first.PackageEnv, second.PackageEnv),
+		EnvFiles:        mergeStringMaps(first.EnvFiles, second.EnvFiles),
+	}
+}
+
+// mergeStringMaps copies a and then b into a new map.
+func mergeStringMaps(a, b map[string]string) map[string]string {
+	merged := make(map[string]string, len(a)+len(b))
+	for _, m := range []map[string]string{a, b} {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// mergeFlagMaps copies a and then b into a new map, copying the flag lists.
+func mergeFlagMaps(a, b map[string][]string) map[string][]string {
+	merged := make(map[string][]string, len(a)+len(b))
+	for _, m := range []map[string][]string{a, b} {
+		for k, v := range m {
+			merged[k] = append([]string(nil), v...)
+		}
+	}
+	return merged
+}
+
+// unionLists returns the entries of a and then those of b not already in a.
+func unionLists(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	union := []string{}
+	for _, list := range [][]string{a, b} {
+		for _, item := range list {
+			if !seen[item] {
+				seen[item] = true
+				union = append(union, item)
+			}
+		}
+	}
+	return union
+}
+
+// mergeRepos merges repositories by name: a's in order, each replaced by
+// b's repository of the same name, then b's new ones.
+func mergeRepos(a, b []RepoConfig) []RepoConfig {
+	byName := make(map[string]int, len(a))
+	merged := make([]RepoConfig, 0, len(a)+len(b))
+	for _, repo := range a {
+		byName[repo.Name] = len(merged)
+		merged = append(merged, repo)
+	}
+	for _, repo := range b {
+		if i, ok := byName[repo.Name]; ok {
+			merged[i] = repo
+			continue
+		}
+		byName[repo.Name] = len(merged)
+		merged = append(merged, repo)
+	}
+	return merged
+}
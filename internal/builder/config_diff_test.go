@@ -0,0 +1,119 @@
+package builder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffConfig(t *testing.T) {
+	current := &PortageConfig{
+		PackageUse:  map[string][]string{"dev-lang/python": {"ssl"}, "app-misc/jq": {"oniguruma"}},
+		PackageMask: []string{">=dev-lang/rust-1.80"},
+		MakeConf:    map[string]string{"MAKEOPTS": "-j4", "CFLAGS": "-O2"},
+		Repos:       []RepoConfig{{Name: "gentoo", Location: "/var/db/repos/gentoo"}},
+	}
+	incoming := &PortageConfig{
+		PackageUse:  map[string][]string{"dev-lang/python": {"ssl", "threads"}, "app-editors/vim": {"python"}},
+		PackageMask: []string{">=dev-lang/rust-1.80"},
+		MakeConf:    map[string]string{"MAKEOPTS": "-j8", "CFLAGS": "-O2"},
+		Repos:       []RepoConfig{{Name: "gentoo", Location: "/var/db/repos/gentoo"}, {Name: "guru", Location: "/var/db/repos/guru"}},
+	}
+
+	d := DiffConfig(current, incoming)
+	if want := (CategoryDiff{
+		Added:   []DiffEntry{{Key: "app-editors/vim", New: "python"}},
+		Removed: []DiffEntry{{Key: "app-misc/jq", Old: "oniguruma"}},
+		Changed: []DiffEntry{{Key: "dev-lang/python", Old: "ssl", New: "ssl threads"}},
+	}); !reflect.DeepEqual(d.PackageUse, want) {
+		t.Errorf("PackageUse = %+v, want %+v", d.PackageUse, want)
+	}
+	if !d.PackageMask.Empty() {
+		t.Errorf("PackageMask = %+v, want no changes", d.PackageMask)
+	}
+	if len(d.MakeConf.Changed) != 1 || d.MakeConf.Changed[0] != (DiffEntry{Key: "MAKEOPTS", Old: "-j4", New: "-j8"}) {
+		t.Errorf("MakeConf = %+v", d.MakeConf)
+	}
+	if len(d.Repos.Added) != 1 || d.Repos.Added[0].Key != "guru" || len(d.Repos.Changed) != 0 {
+		t.Errorf("Repos = %+v", d.Repos)
+	}
+
+	out := d.String()
+	for _, want := range []string{"package.use:\n", "  + app-editors/vim python\n", "  - app-misc/jq oniguruma\n",
+		"  ~ dev-lang/python: ssl -> ssl threads\n", "  ~ MAKEOPTS: -j4 -> -j8\n", "repos.conf:\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("String() missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "package.mask") {
+		t.Errorf("String() lists an unchanged category:\n%s", out)
+	}
+
+	if d := DiffConfig(current, current); !d.Empty() || d.String() != "no changes\n" {
+		t.Errorf("DiffConfig(x, x) = %s", d)
+	}
+	if d := DiffConfig(nil, incoming); len(d.PackageUse.Added) != 2 {
+		t.Errorf("DiffConfig(nil, x).PackageUse = %+v", d.PackageUse)
+	}
+}
+
+func TestMergeConfig(t *testing.T) {
+	system := &PortageConfig{
+		PackageUse:  map[string][]string{"dev-lang/python": {"ssl"}, "app-misc/jq": {"oniguruma"}},
+		PackageMask: []string{"a/b"},
+		MakeConf:    map[string]string{"MAKEOPTS": "-j4"},
+		GlobalUse:   []string{"X"},
+		Repos:       []RepoConfig{{Name: "gentoo", Location: "/var/db/repos/gentoo"}},
+	}
+	incoming := &PortageConfig{
+		PackageUse:  map[string][]string{"dev-lang/python": {"threads"}},
+		PackageMask: []string{"a/b", "c/d"},
+		MakeConf:    map[string]string{"MAKEOPTS": "-j8", "CFLAGS": "-O2"},
+		GlobalUse:   []string{"X", "wayland"},
+		Repos:       []RepoConfig{{Name: "gentoo", Location: "/srv/gentoo"}, {Name: "guru", Location: "/var/db/repos/guru"}},
+	}
+
+	merged := MergeConfig(system, incoming, MergeIncomingWins)
+	if got := merged.PackageUse["dev-lang/python"]; !reflect.DeepEqual(got, []string{"threads"}) {
+		t.Errorf("incoming-wins python USE = %v", got)
+	}
+	if got := merged.PackageUse["app-misc/jq"]; !reflect.DeepEqual(got, []string{"oniguruma"}) {
+		t.Errorf("system-only entry lost: %v", got)
+	}
+	if merged.MakeConf["MAKEOPTS"] != "-j8" || merged.MakeConf["CFLAGS"] != "-O2" {
+		t.Errorf("incoming-wins make.conf = %v", merged.MakeConf)
+	}
+	if !reflect.DeepEqual(merged.PackageMask, []string{"a/b", "c/d"}) || !reflect.DeepEqual(merged.GlobalUse, []string{"X", "wayland"}) {
+		t.Errorf("lists not unioned: mask %v, USE %v", merged.PackageMask, merged.GlobalUse)
+	}
+	if len(merged.Repos) != 2 || merged.Repos[0].Location != "/srv/gentoo" || merged.Repos[1].Name != "guru" {
+		t.Errorf("incoming-wins repos = %+v", merged.Repos)
+	}
+
+	merged = MergeConfig(system, incoming, MergeSystemWins)
+	if got := merged.PackageUse["dev-lang/python"]; !reflect.DeepEqual(got, []string{"ssl"}) {
+		t.Errorf("system-wins python USE = %v", got)
+	}
+	if merged.MakeConf["MAKEOPTS"] != "-j4" || merged.MakeConf["CFLAGS"] != "-O2" {
+		t.Errorf("system-wins make.conf = %v", merged.MakeConf)
+	}
+	if merged.Repos[0].Location != "/var/db/repos/gentoo" {
+		t.Errorf("system-wins repos = %+v", merged.Repos)
+	}
+
+	merged.PackageUse["app-misc/jq"][0] = "changed"
+	if system.PackageUse["app-misc/jq"][0] != "oniguruma" {
+		t.Error("MergeConfig shares flag slices with its input")
+	}
+}
+
+func TestParseMergePolicy(t *testing.T) {
+	for _, s := range []string{"incoming-wins", "system-wins"} {
+		if p, err := ParseMergePolicy(s); err != nil || string(p) != s {
+			t.Errorf("ParseMergePolicy(%q) = %q, %v", s, p, err)
+		}
+	}
+	if _, err := ParseMergePolicy("newest"); err == nil {
+		t.Error("ParseMergePolicy accepted an unknown policy")
+	}
+}
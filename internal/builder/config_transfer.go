@@ -7,7 +7,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -31,6 +33,10 @@ type PortageConfig struct {
 	GlobalUse []string `json:"global_use"`
 	// Repository configurations
 	Repos []RepoConfig `json:"repos"`
+	// Package.env entries: atom -> env file name(s), space-separated
+	PackageEnv map[string]string `json:"package_env,omitempty"`
+	// Env files from /etc/portage/env: file name -> contents
+	EnvFiles map[string]string `json:"env_files,omitempty"`
 }
 
 // RepoConfig represents a repository configuration.
@@ -40,6 +46,9 @@ type RepoConfig struct {
 	SyncType string `json:"sync_type"`
 	SyncURI  string `json:"sync_uri"`
 	Priority int    `json:"priority"`
+	// Ref is the branch or tag a git repository is cloned at, instead of
+	// its default branch.
+	Ref string `json:"ref,omitempty"`
 }
 
 // BuildPackageSpec specifies packages to build with their configurations.
@@ -54,6 +63,14 @@ type PackageSpec struct {
 	UseFlags    []string          `json:"use_flags,omitempty"`
 	Keywords    []string          `json:"keywords,omitempty"`
 	Environment map[string]string `json:"environment,omitempty"`
+	// OverlayURL is the git URL of an ebuild overlay the package comes
+	// from, synced into the build before emerging (see overlayRepos).
+	OverlayURL string `json:"overlay_url,omitempty"`
+	// OverlayRef is the branch or tag of OverlayURL to build from.
+	OverlayRef string `json:"overlay_ref,omitempty"`
+	// MakeOpts replaces the builder's MAKEOPTS for this package, e.g. "-j2"
+	// for one whose compiler jobs need a lot of memory.
+	MakeOpts string `json:"makeopts,omitempty"`
 }
 
 // ConfigBundle bundles Portage configuration and package specifications.
@@ -105,6 +122,8 @@ func (ct *ConfigTransfer) ReadSystemPortageConfig(portageDir string) (*PortageCo
 		Environment:     make(map[string]string),
 		GlobalUse:       []string{},
 		Repos:           []RepoConfig{},
+		PackageEnv:      make(map[string]string),
+		EnvFiles:        make(map[string]string),
 	}
 
 	// Read make.conf
@@ -128,6 +147,10 @@ func (ct *ConfigTransfer) ReadSystemPortageConfig(portageDir string) (*PortageCo
 	// Read repos.conf
 	_ = ct.readReposConf(filepath.Join(portageDir, "repos.conf"), config)
 
+	// Read package.env and the env files it refers to
+	_ = ct.readPackageEnv(filepath.Join(portageDir, "package.env"), config)
+	_ = ct.readEnvFiles(filepath.Join(portageDir, "env"), config)
+
 	return config, nil
 }
 
@@ -267,6 +290,108 @@ func (ct *ConfigTransfer) parsePackageKeywordsFile(path string, config *PortageC
 	return nil
 }
 
+// readPackageEnv reads package.env file or directory.
+func (ct *ConfigTransfer) readPackageEnv(path string, config *PortageConfig) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				_ = ct.parsePackageEnvFile(filepath.Join(path, entry.Name()), config)
+			}
+		}
+	} else {
+		return ct.parsePackageEnvFile(path, config)
+	}
+
+	return nil
+}
+
+// parsePackageEnvFile parses a package.env file.
+func (ct *ConfigTransfer) parsePackageEnvFile(path string, config *PortageConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Parse: package-atom env-file [env-file...]
+		fields := strings.Fields(line)
+		if len(fields) >= 2 {
+			pkgAtom := fields[0]
+			names := fields[1:]
+			if prev := config.PackageEnv[pkgAtom]; prev != "" {
+				names = append(strings.Fields(prev), names...)
+			}
+			config.PackageEnv[pkgAtom] = strings.Join(names, " ")
+		}
+	}
+
+	return nil
+}
+
+// readEnvFiles reads the env files in the env directory. Only files directly
+// inside it are read, as package.env entries name them by file name.
+func (ct *ConfigTransfer) readEnvFiles(path string, config *PortageConfig) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			continue
+		}
+		config.EnvFiles[entry.Name()] = string(data)
+	}
+	return nil
+}
+
+// parseEnvFile parses the variable assignments of an env file: VAR=value,
+// VAR="value" or VAR='value' lines, with blank lines and comments. Anything
+// else, such as shell commands, is an error, since env files are sourced by
+// bash in the build.
+func parseEnvFile(content string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || !envKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d is not a variable assignment", i+1)
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			quote := value[:1]
+			value = value[1 : len(value)-1]
+			if strings.Contains(value, quote) {
+				return nil, fmt.Errorf("line %d: value of %s ends its quotes early", i+1, key)
+			}
+		} else if strings.ContainsAny(value, " \t\"';&|<>()") {
+			return nil, fmt.Errorf("line %d: unquoted value of %s contains whitespace, a quote or a shell operator", i+1, key)
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
+
 // readPackageMask reads package.mask file or directory.
 func (ct *ConfigTransfer) readPackageMask(path string, config *PortageConfig) error {
 	return ct.readPackageList(path, &config.PackageMask)
@@ -418,6 +543,10 @@ func (ct *ConfigTransfer) setRepoConfigField(key, value string, repo *RepoConfig
 		repo.SyncURI = value
 	case "priority":
 		_, _ = fmt.Sscanf(value, "%d", &repo.Priority)
+	case "sync-git-clone-extra-opts":
+		if ref, ok := strings.CutPrefix(value, "--branch "); ok {
+			repo.Ref = strings.TrimSpace(ref)
+		}
 	}
 }
 
@@ -473,18 +602,59 @@ func (ct *ConfigTransfer) ExportBundle(bundle *ConfigBundle, outputPath string)
 		_ = tarWriter.Close()
 	}()
 
+	return ct.writeBundle(bundle, func(name string, data []byte) error {
+		return ct.addFileToTar(tarWriter, name, data)
+	})
+}
+
+// ExportBundleDir exports the configuration bundle as loose files in dir,
+// the same files ExportBundle puts in a tarball, for keeping a bundle in
+// version control or editing it by hand. dir is created if needed. It must
+// be empty or hold an earlier export, whose files are replaced.
+func (ct *ConfigTransfer) ExportBundleDir(bundle *ConfigBundle, dir string) error {
+	entries, err := os.ReadDir(dir)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return fmt.Errorf("failed to read output directory: %w", err)
+	case len(entries) > 0:
+		if _, err := os.Stat(filepath.Join(dir, "bundle.json")); err != nil {
+			return fmt.Errorf("output directory %s is not empty and holds no bundle", dir)
+		}
+		// Drop the earlier export's Portage files, so a section the bundle
+		// no longer has is not read back by ImportBundleDir.
+		if err := os.RemoveAll(filepath.Join(dir, "etc", "portage")); err != nil {
+			return fmt.Errorf("failed to replace the earlier export: %w", err)
+		}
+	}
+
+	return ct.writeBundle(bundle, func(name string, data []byte) error {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// writeBundle passes each file of the bundle to add: bundle.json, the
+// Portage configuration under etc/portage/, then packages.json.
+func (ct *ConfigTransfer) writeBundle(bundle *ConfigBundle, add bundleFileFunc) error {
 	// Add bundle metadata
 	metadataJSON, err := json.MarshalIndent(bundle, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal bundle: %w", err)
 	}
 
-	if err := ct.addFileToTar(tarWriter, "bundle.json", metadataJSON); err != nil {
+	if err := add("bundle.json", metadataJSON); err != nil {
 		return fmt.Errorf("failed to add bundle.json: %w", err)
 	}
 
 	// Generate and add Portage configuration files
-	if err := ct.addPortageConfigToTar(tarWriter, bundle.Config); err != nil {
+	if err := ct.addPortageConfig(add, bundle.Config); err != nil {
 		return fmt.Errorf("failed to add portage config: %w", err)
 	}
 
@@ -494,13 +664,17 @@ func (ct *ConfigTransfer) ExportBundle(bundle *ConfigBundle, outputPath string)
 		return fmt.Errorf("failed to marshal packages: %w", err)
 	}
 
-	if err := ct.addFileToTar(tarWriter, "packages.json", packagesJSON); err != nil {
+	if err := add("packages.json", packagesJSON); err != nil {
 		return fmt.Errorf("failed to add packages.json: %w", err)
 	}
 
 	return nil
 }
 
+// bundleFileFunc adds a file, named by its slash-separated path in the
+// bundle, to a bundle being exported.
+type bundleFileFunc func(name string, data []byte) error
+
 // addFileToTar adds a file to the tar archive.
 func (ct *ConfigTransfer) addFileToTar(tw *tar.Writer, name string, data []byte) error {
 	header := &tar.Header{
@@ -520,37 +694,45 @@ func (ct *ConfigTransfer) addFileToTar(tw *tar.Writer, name string, data []byte)
 	return nil
 }
 
-// addPortageConfigToTar adds Portage configuration files to the tar archive.
-func (ct *ConfigTransfer) addPortageConfigToTar(tw *tar.Writer, config *PortageConfig) error {
-	if err := ct.addPackageUseToTar(tw, config.PackageUse); err != nil {
+// addPortageConfig adds Portage configuration files to the bundle.
+func (ct *ConfigTransfer) addPortageConfig(add bundleFileFunc, config *PortageConfig) error {
+	if err := ct.addPackageUse(add, config.PackageUse); err != nil {
+		return err
+	}
+
+	if err := ct.addPackageKeywords(add, config.PackageKeywords); err != nil {
+		return err
+	}
+
+	if err := ct.addPackageMask(add, config.PackageMask); err != nil {
 		return err
 	}
 
-	if err := ct.addPackageKeywordsToTar(tw, config.PackageKeywords); err != nil {
+	if err := ct.addPackageUnmask(add, config.PackageUnmask); err != nil {
 		return err
 	}
 
-	if err := ct.addPackageMaskToTar(tw, config.PackageMask); err != nil {
+	if err := ct.addMakeConf(add, config.MakeConf); err != nil {
 		return err
 	}
 
-	if err := ct.addPackageUnmaskToTar(tw, config.PackageUnmask); err != nil {
+	if err := ct.addReposConf(add, config.Repos); err != nil {
 		return err
 	}
 
-	if err := ct.addMakeConfToTar(tw, config.MakeConf); err != nil {
+	if err := ct.addPackageEnv(add, config.PackageEnv); err != nil {
 		return err
 	}
 
-	if err := ct.addReposConfToTar(tw, config.Repos); err != nil {
+	if err := ct.addEnvFiles(add, config.EnvFiles); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// addPackageUseToTar adds package.use to tarball.
-func (ct *ConfigTransfer) addPackageUseToTar(tw *tar.Writer, packageUse map[string][]string) error {
+// addPackageUse adds package.use to the bundle.
+func (ct *ConfigTransfer) addPackageUse(add bundleFileFunc, packageUse map[string][]string) error {
 	if len(packageUse) == 0 {
 		return nil
 	}
@@ -559,12 +741,13 @@ func (ct *ConfigTransfer) addPackageUseToTar(tw *tar.Writer, packageUse map[stri
 	for pkg, flags := range packageUse {
 		lines = append(lines, fmt.Sprintf("%s %s", pkg, strings.Join(flags, " ")))
 	}
+	sort.Strings(lines)
 	content := strings.Join(lines, "\n") + "\n"
-	return ct.addFileToTar(tw, "etc/portage/package.use/00-user", []byte(content))
+	return add("etc/portage/package.use/00-user", []byte(content))
 }
 
-// addPackageKeywordsToTar adds package.accept_keywords to tarball.
-func (ct *ConfigTransfer) addPackageKeywordsToTar(tw *tar.Writer, packageKeywords map[string][]string) error {
+// addPackageKeywords adds package.accept_keywords to the bundle.
+func (ct *ConfigTransfer) addPackageKeywords(add bundleFileFunc, packageKeywords map[string][]string) error {
 	if len(packageKeywords) == 0 {
 		return nil
 	}
@@ -573,28 +756,70 @@ func (ct *ConfigTransfer) addPackageKeywordsToTar(tw *tar.Writer, packageKeyword
 	for pkg, keywords := range packageKeywords {
 		lines = append(lines, fmt.Sprintf("%s %s", pkg, strings.Join(keywords, " ")))
 	}
+	sort.Strings(lines)
 	content := strings.Join(lines, "\n") + "\n"
-	return ct.addFileToTar(tw, "etc/portage/package.accept_keywords/00-user", []byte(content))
+	return add("etc/portage/package.accept_keywords/00-user", []byte(content))
 }
 
-// addPackageMaskToTar adds package.mask to tarball.
-func (ct *ConfigTransfer) addPackageMaskToTar(tw *tar.Writer, packageMask []string) error {
+// addPackageMask adds package.mask to the bundle.
+func (ct *ConfigTransfer) addPackageMask(add bundleFileFunc, packageMask []string) error {
 	if len(packageMask) == 0 {
 		return nil
 	}
 
 	content := strings.Join(packageMask, "\n") + "\n"
-	return ct.addFileToTar(tw, "etc/portage/package.mask/00-user", []byte(content))
+	return add("etc/portage/package.mask/00-user", []byte(content))
 }
 
-// addPackageUnmaskToTar adds package.unmask to tarball.
-func (ct *ConfigTransfer) addPackageUnmaskToTar(tw *tar.Writer, packageUnmask []string) error {
+// addPackageUnmask adds package.unmask to the bundle.
+func (ct *ConfigTransfer) addPackageUnmask(add bundleFileFunc, packageUnmask []string) error {
 	if len(packageUnmask) == 0 {
 		return nil
 	}
 
 	content := strings.Join(packageUnmask, "\n") + "\n"
-	return ct.addFileToTar(tw, "etc/portage/package.unmask/00-user", []byte(content))
+	return add("etc/portage/package.unmask/00-user", []byte(content))
+}
+
+// renderPackageEnv renders package.env lines, sorted by atom.
+func renderPackageEnv(packageEnv map[string]string) []byte {
+	atoms := make([]string, 0, len(packageEnv))
+	for atom := range packageEnv {
+		atoms = append(atoms, atom)
+	}
+	sort.Strings(atoms)
+	lines := make([]string, 0, len(atoms))
+	for _, atom := range atoms {
+		lines = append(lines, fmt.Sprintf("%s %s", atom, packageEnv[atom]))
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// addPackageEnv adds package.env to the bundle.
+func (ct *ConfigTransfer) addPackageEnv(add bundleFileFunc, packageEnv map[string]string) error {
+	if len(packageEnv) == 0 {
+		return nil
+	}
+	return add("etc/portage/package.env/00-user", renderPackageEnv(packageEnv))
+}
+
+// addEnvFiles adds the env files to the bundle, where the Docker executor
+// copies them into the container's /etc/portage/env.
+func (ct *ConfigTransfer) addEnvFiles(add bundleFileFunc, envFiles map[string]string) error {
+	names := make([]string, 0, len(envFiles))
+	for name := range envFiles {
+		if err := validatePortageFileName(name); err != nil {
+			return fmt.Errorf("invalid env file name: %w", err)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := add("etc/portage/env/"+name, []byte(envFiles[name])); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // makeConfFragmentPath is where the user's make.conf overrides are stored inside
@@ -619,39 +844,49 @@ func renderMakeConf(makeConf map[string]string) []byte {
 	return []byte(strings.Join(lines, "\n") + "\n")
 }
 
-// addMakeConfToTar adds the make.conf override fragment to the tarball.
-func (ct *ConfigTransfer) addMakeConfToTar(tw *tar.Writer, makeConf map[string]string) error {
+// addMakeConf adds the make.conf override fragment to the bundle.
+func (ct *ConfigTransfer) addMakeConf(add bundleFileFunc, makeConf map[string]string) error {
 	if len(makeConf) == 0 {
 		return nil
 	}
-	return ct.addFileToTar(tw, makeConfFragmentPath, renderMakeConf(makeConf))
+	return add(makeConfFragmentPath, renderMakeConf(makeConf))
+}
+
+// renderRepoConf renders the repos.conf section of repo.
+func renderRepoConf(repo RepoConfig) string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("[%s]", repo.Name))
+	if repo.Location != "" {
+		lines = append(lines, fmt.Sprintf("location = %s", repo.Location))
+	}
+	if repo.SyncType != "" {
+		lines = append(lines, fmt.Sprintf("sync-type = %s", repo.SyncType))
+	}
+	if repo.SyncURI != "" {
+		lines = append(lines, fmt.Sprintf("sync-uri = %s", repo.SyncURI))
+	}
+	if repo.Ref != "" {
+		lines = append(lines, fmt.Sprintf("sync-git-clone-extra-opts = --branch %s", repo.Ref))
+	}
+	if repo.Priority != 0 {
+		lines = append(lines, fmt.Sprintf("priority = %d", repo.Priority))
+	}
+	return strings.Join(lines, "\n") + "\n"
 }
 
-// addReposConfToTar adds repos.conf to tarball.
-func (ct *ConfigTransfer) addReposConfToTar(tw *tar.Writer, repos []RepoConfig) error {
+// addReposConf adds repos.conf to the bundle.
+func (ct *ConfigTransfer) addReposConf(add bundleFileFunc, repos []RepoConfig) error {
 	if len(repos) == 0 {
 		return nil
 	}
 
 	for _, repo := range repos {
-		var lines []string
-		lines = append(lines, fmt.Sprintf("[%s]", repo.Name))
-		if repo.Location != "" {
-			lines = append(lines, fmt.Sprintf("location = %s", repo.Location))
-		}
-		if repo.SyncType != "" {
-			lines = append(lines, fmt.Sprintf("sync-type = %s", repo.SyncType))
-		}
-		if repo.SyncURI != "" {
-			lines = append(lines, fmt.Sprintf("sync-uri = %s", repo.SyncURI))
-		}
-		if repo.Priority != 0 {
-			lines = append(lines, fmt.Sprintf("priority = %d", repo.Priority))
+		if err := validateRepoName(repo.Name); err != nil {
+			return err
 		}
-
-		content := strings.Join(lines, "\n") + "\n"
+		content := renderRepoConf(repo)
 		filename := fmt.Sprintf("etc/portage/repos.conf/%s.conf", repo.Name)
-		if err := ct.addFileToTar(tw, filename, []byte(content)); err != nil {
+		if err := add(filename, []byte(content)); err != nil {
 			return err
 		}
 	}
@@ -659,7 +894,33 @@ func (ct *ConfigTransfer) addReposConfToTar(tw *tar.Writer, repos []RepoConfig)
 	return nil
 }
 
-// ImportBundle imports a configuration bundle from a tarball.
+// validateBundleEntry rejects a tarball entry ExportBundle would not have
+// written: anything but bundle.json and packages.json must be a regular file
+// or directory under etc/portage/, and links are refused outright, so a
+// crafted archive cannot reach outside the Portage configuration.
+func validateBundleEntry(header *tar.Header) error {
+	name := path.Clean(header.Name)
+	if path.IsAbs(header.Name) || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("bundle entry %q escapes the bundle", header.Name)
+	}
+	switch header.Typeflag {
+	case tar.TypeReg:
+		if name == "bundle.json" || name == "packages.json" || strings.HasPrefix(name, "etc/portage/") {
+			return nil
+		}
+	case tar.TypeDir:
+		if name == "etc" || name == "etc/portage" || strings.HasPrefix(name, "etc/portage/") {
+			return nil
+		}
+	default:
+		return fmt.Errorf("bundle entry %q is not a regular file or directory", header.Name)
+	}
+	return fmt.Errorf("bundle entry %q is outside etc/portage/", header.Name)
+}
+
+// ImportBundle imports a configuration bundle from a tarball. Every entry of
+// the tarball is checked (see validateBundleEntry) and the bundle itself must
+// pass ValidateBundle.
 func (ct *ConfigTransfer) ImportBundle(bundlePath string) (*ConfigBundle, error) {
 	// Open the tarball
 	file, err := os.Open(bundlePath)
@@ -682,7 +943,7 @@ func (ct *ConfigTransfer) ImportBundle(bundlePath string) (*ConfigBundle, error)
 	// Create tar reader
 	tarReader := tar.NewReader(gzReader)
 
-	// Read bundle.json
+	// Read bundle.json, checking every entry on the way.
 	var bundle *ConfigBundle
 	for {
 		header, err := tarReader.Next()
@@ -692,8 +953,14 @@ func (ct *ConfigTransfer) ImportBundle(bundlePath string) (*ConfigBundle, error)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read tar header: %w", err)
 		}
+		if err := validateBundleEntry(header); err != nil {
+			return nil, err
+		}
 
 		if header.Name == "bundle.json" {
+			if bundle != nil {
+				return nil, fmt.Errorf("bundle contains more than one bundle.json")
+			}
 			data, err := io.ReadAll(tarReader)
 			if err != nil {
 				return nil, fmt.Errorf("failed to read bundle.json: %w", err)
@@ -703,18 +970,210 @@ func (ct *ConfigTransfer) ImportBundle(bundlePath string) (*ConfigBundle, error)
 			if err := json.Unmarshal(data, bundle); err != nil {
 				return nil, fmt.Errorf("failed to unmarshal bundle.json: %w", err)
 			}
-			break
 		}
 	}
 
 	if bundle == nil {
 		return nil, fmt.Errorf("bundle.json not found in tarball")
 	}
+	if err := ValidateBundle(bundle); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
 
 	return bundle, nil
 }
 
+// ImportBundleDir imports a configuration bundle from a directory written by
+// ExportBundleDir. The metadata comes from bundle.json and the packages from
+// packages.json, but the Portage configuration is read back from the
+// etc/portage/ tree, so hand edits to those files take effect. Only the
+// environment, which the tree does not hold, is taken from bundle.json.
+// Hidden entries such as .git are ignored; every other entry is checked like
+// a tarball entry (see validateBundleEntry), and the bundle must pass
+// ValidateBundle.
+func (ct *ConfigTransfer) ImportBundleDir(dir string) (*ConfigBundle, error) {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("bundle entry %s: %w", path, err)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		return validateBundleEntry(header)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "bundle.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle.json: %w", err)
+	}
+	bundle := &ConfigBundle{}
+	if err := json.Unmarshal(data, bundle); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal bundle.json: %w", err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, "packages.json"))
+	switch {
+	case err == nil:
+		packages := &BuildPackageSpec{}
+		if err := json.Unmarshal(data, packages); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal packages.json: %w", err)
+		}
+		bundle.Packages = packages
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read packages.json: %w", err)
+	}
+
+	config, err := ct.readBundlePortageConfig(dir, bundle.Config)
+	if err != nil {
+		return nil, err
+	}
+	bundle.Config = config
+
+	if err := ValidateBundle(bundle); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	return bundle, nil
+}
+
+// readBundlePortageConfig reads the Portage configuration of a bundle
+// directory from its etc/portage/ tree. The environment comes from saved, as
+// do the global USE flags unless the make.conf fragment sets USE. A section
+// missing from the tree is empty.
+func (ct *ConfigTransfer) readBundlePortageConfig(dir string, saved *PortageConfig) (*PortageConfig, error) {
+	config := &PortageConfig{
+		PackageUse:      make(map[string][]string),
+		PackageKeywords: make(map[string][]string),
+		PackageMask:     []string{},
+		PackageUnmask:   []string{},
+		MakeConf:        make(map[string]string),
+		Environment:     make(map[string]string),
+		GlobalUse:       []string{},
+		Repos:           []RepoConfig{},
+		PackageEnv:      make(map[string]string),
+		EnvFiles:        make(map[string]string),
+	}
+	if saved != nil {
+		if saved.Environment != nil {
+			config.Environment = saved.Environment
+		}
+		if saved.GlobalUse != nil {
+			config.GlobalUse = saved.GlobalUse
+		}
+	}
+
+	portageDir := filepath.Join(dir, "etc", "portage")
+	for _, r := range []struct {
+		path string
+		read func(string, *PortageConfig) error
+	}{
+		{filepath.Join(dir, filepath.FromSlash(makeConfFragmentPath)), ct.readMakeConf},
+		{filepath.Join(portageDir, "package.use"), ct.readPackageUse},
+		{filepath.Join(portageDir, "package.accept_keywords"), ct.readPackageKeywords},
+		{filepath.Join(portageDir, "package.mask"), ct.readPackageMask},
+		{filepath.Join(portageDir, "package.unmask"), ct.readPackageUnmask},
+		{filepath.Join(portageDir, "repos.conf"), ct.readReposConf},
+		{filepath.Join(portageDir, "package.env"), ct.readPackageEnv},
+		{filepath.Join(portageDir, "env"), ct.readEnvFiles},
+	} {
+		if err := r.read(r.path, config); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", r.path, err)
+		}
+	}
+	return config, nil
+}
+
+// validatePortageFileName rejects a file name that could resolve anywhere but
+// directly inside its directory: empty, containing a path separator or NUL,
+// "..", or hidden (leading dot).
+func validatePortageFileName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("empty file name")
+	case strings.ContainsAny(name, "/\\\x00"):
+		return fmt.Errorf("file name %q contains a path separator or NUL", name)
+	case strings.Contains(name, ".."):
+		return fmt.Errorf("file name %q contains \"..\"", name)
+	case strings.HasPrefix(name, "."):
+		return fmt.Errorf("file name %q starts with a dot", name)
+	}
+	return nil
+}
+
+// validateRepoName checks a repository name, which becomes both a
+// repos.conf section header and a file name: Portage's repository name
+// grammar admits no path separators, dots or brackets.
+func validateRepoName(name string) error {
+	if err := validatePortageFileName(name); err != nil {
+		return fmt.Errorf("invalid repository name: %w", err)
+	}
+	if !atomRepoPattern.MatchString(name) {
+		return fmt.Errorf("invalid repository name %q", name)
+	}
+	return nil
+}
+
+// portageFilePath returns the path of the file name in the subdirectory dir
+// ("" for portageDir itself) of portageDir, refusing a name that could
+// escape (see validatePortageFileName), a result outside portageDir once
+// resolved, a directory symlinked out of portageDir, and an existing
+// symlink at the path itself, which a write would follow.
+func portageFilePath(portageDir, dir, name string) (string, error) {
+	if err := validatePortageFileName(name); err != nil {
+		return "", fmt.Errorf("refusing to write %s: %w", filepath.Join(dir, name), err)
+	}
+	fullPath := filepath.Join(portageDir, dir, name)
+
+	absPortageDir, err := filepath.Abs(portageDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", portageDir, err)
+	}
+	absFullPath, err := filepath.Abs(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", fullPath, err)
+	}
+	if !strings.HasPrefix(absFullPath, absPortageDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to write %s: outside %s", fullPath, portageDir)
+	}
+
+	realPortageDir, err := filepath.EvalSymlinks(absPortageDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", portageDir, err)
+	}
+	realDir, err := filepath.EvalSymlinks(filepath.Dir(absFullPath))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", filepath.Dir(fullPath), err)
+	}
+	if realDir != realPortageDir && !strings.HasPrefix(realDir, realPortageDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to write %s: %s is a symlink out of %s", fullPath, filepath.Dir(fullPath), portageDir)
+	}
+	if info, err := os.Lstat(fullPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("refusing to write %s: it is a symlink", fullPath)
+	}
+	return fullPath, nil
+}
+
 // ApplyConfigToSystem applies the configuration bundle to a target system.
+// Every file is written through portageFilePath, so nothing lands outside
+// targetRoot/etc/portage.
 func (ct *ConfigTransfer) ApplyConfigToSystem(bundle *ConfigBundle, targetRoot string) error {
 	if targetRoot == "" {
 		targetRoot = "/"
@@ -751,6 +1210,14 @@ func (ct *ConfigTransfer) ApplyConfigToSystem(bundle *ConfigBundle, targetRoot s
 		return err
 	}
 
+	if err := ct.writePackageEnv(portageDir, config.PackageEnv); err != nil {
+		return err
+	}
+
+	if err := ct.writeEnvFiles(portageDir, config.EnvFiles); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -763,6 +1230,8 @@ func (ct *ConfigTransfer) createPortageDirs(portageDir string) error {
 		filepath.Join(portageDir, "package.unmask"),
 		filepath.Join(portageDir, "make.conf.d"),
 		filepath.Join(portageDir, "repos.conf"),
+		filepath.Join(portageDir, "package.env"),
+		filepath.Join(portageDir, "env"),
 	}
 
 	for _, dir := range dirs {
@@ -783,8 +1252,12 @@ func (ct *ConfigTransfer) writePackageUse(portageDir string, packageUse map[stri
 	for pkg, flags := range packageUse {
 		lines = append(lines, fmt.Sprintf("%s %s", pkg, strings.Join(flags, " ")))
 	}
+	sort.Strings(lines)
 	content := strings.Join(lines, "\n") + "\n"
-	path := filepath.Join(portageDir, "package.use", "00-user")
+	path, err := portageFilePath(portageDir, "package.use", "00-user")
+	if err != nil {
+		return err
+	}
 
 	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
 		return fmt.Errorf("failed to write package.use: %w", err)
@@ -802,8 +1275,12 @@ func (ct *ConfigTransfer) writePackageKeywords(portageDir string, packageKeyword
 	for pkg, keywords := range packageKeywords {
 		lines = append(lines, fmt.Sprintf("%s %s", pkg, strings.Join(keywords, " ")))
 	}
+	sort.Strings(lines)
 	content := strings.Join(lines, "\n") + "\n"
-	path := filepath.Join(portageDir, "package.accept_keywords", "00-user")
+	path, err := portageFilePath(portageDir, "package.accept_keywords", "00-user")
+	if err != nil {
+		return err
+	}
 
 	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
 		return fmt.Errorf("failed to write package.accept_keywords: %w", err)
@@ -818,7 +1295,10 @@ func (ct *ConfigTransfer) writePackageMask(portageDir string, packageMask []stri
 	}
 
 	content := strings.Join(packageMask, "\n") + "\n"
-	path := filepath.Join(portageDir, "package.mask", "00-user")
+	path, err := portageFilePath(portageDir, "package.mask", "00-user")
+	if err != nil {
+		return err
+	}
 
 	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
 		return fmt.Errorf("failed to write package.mask: %w", err)
@@ -833,7 +1313,10 @@ func (ct *ConfigTransfer) writePackageUnmask(portageDir string, packageUnmask []
 	}
 
 	content := strings.Join(packageUnmask, "\n") + "\n"
-	path := filepath.Join(portageDir, "package.unmask", "00-user")
+	path, err := portageFilePath(portageDir, "package.unmask", "00-user")
+	if err != nil {
+		return err
+	}
 
 	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
 		return fmt.Errorf("failed to write package.unmask: %w", err)
@@ -841,6 +1324,37 @@ func (ct *ConfigTransfer) writePackageUnmask(portageDir string, packageUnmask []
 	return nil
 }
 
+// writePackageEnv writes package.env configuration.
+func (ct *ConfigTransfer) writePackageEnv(portageDir string, packageEnv map[string]string) error {
+	if len(packageEnv) == 0 {
+		return nil
+	}
+
+	path, err := portageFilePath(portageDir, "package.env", "00-user")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, renderPackageEnv(packageEnv), 0600); err != nil {
+		return fmt.Errorf("failed to write package.env: %w", err)
+	}
+	return nil
+}
+
+// writeEnvFiles writes the env files package.env refers to.
+func (ct *ConfigTransfer) writeEnvFiles(portageDir string, envFiles map[string]string) error {
+	for name, content := range envFiles {
+		path, err := portageFilePath(portageDir, "env", name)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			return fmt.Errorf("failed to write env file %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
 // writeMakeConf appends the make.conf overrides to the real make.conf. Portage
 // only reads the make.conf file itself (not make.conf.d/), so we append to it
 // rather than dropping a file into make.conf.d, and we append rather than
@@ -850,7 +1364,10 @@ func (ct *ConfigTransfer) writeMakeConf(portageDir string, makeConf map[string]s
 		return nil
 	}
 
-	path := filepath.Join(portageDir, "make.conf")
+	path, err := portageFilePath(portageDir, "", "make.conf")
+	if err != nil {
+		return err
+	}
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644) // #nosec G302 -- make.conf must be world-readable.
 	if err != nil {
 		return fmt.Errorf("failed to open make.conf: %w", err)
@@ -870,24 +1387,15 @@ func (ct *ConfigTransfer) writeReposConf(portageDir string, repos []RepoConfig)
 	}
 
 	for _, repo := range repos {
-		var lines []string
-		lines = append(lines, fmt.Sprintf("[%s]", repo.Name))
-		if repo.Location != "" {
-			lines = append(lines, fmt.Sprintf("location = %s", repo.Location))
-		}
-		if repo.SyncType != "" {
-			lines = append(lines, fmt.Sprintf("sync-type = %s", repo.SyncType))
-		}
-		if repo.SyncURI != "" {
-			lines = append(lines, fmt.Sprintf("sync-uri = %s", repo.SyncURI))
+		if err := validateRepoName(repo.Name); err != nil {
+			return err
 		}
-		if repo.Priority != 0 {
-			lines = append(lines, fmt.Sprintf("priority = %d", repo.Priority))
+		content := renderRepoConf(repo)
+		path, err := portageFilePath(portageDir, "repos.conf", repo.Name+".conf")
+		if err != nil {
+			return err
 		}
 
-		content := strings.Join(lines, "\n") + "\n"
-		path := filepath.Join(portageDir, "repos.conf", fmt.Sprintf("%s.conf", repo.Name))
-
 		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
 			return fmt.Errorf("failed to write repos.conf for %s: %w", repo.Name, err)
 		}
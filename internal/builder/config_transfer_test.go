@@ -1,8 +1,12 @@
 package builder
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -134,6 +138,137 @@ func TestExportImportBundle(t *testing.T) {
 	}
 }
 
+// writeBundleTarball writes a gzipped tarball of the given entries; a
+// "->" in a name makes it a symlink to the part after the arrow.
+func writeBundleTarball(t *testing.T, entries map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range entries {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if link, target, ok := strings.Cut(name, "->"); ok {
+			hdr = &tar.Header{Name: link, Linkname: target, Mode: 0o777, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, c := range []interface{ Close() error }{tw, gz, f} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// TestImportBundleRejectsInvalid tests that crafted or invalid bundles are
+// refused on import.
+func TestImportBundleRejectsInvalid(t *testing.T) {
+	valid := `{"packages":{"packages":[{"atom":"app-misc/hello"}]},"metadata":{"target_arch":"amd64"}}`
+	transfer := NewConfigTransfer(t.TempDir())
+
+	if _, err := transfer.ImportBundle(writeBundleTarball(t, map[string]string{
+		"bundle.json": valid, "etc/portage/package.use/00-user": "app-misc/hello test\n",
+	})); err != nil {
+		t.Fatalf("ImportBundle() of a valid bundle = %v", err)
+	}
+
+	tests := map[string]struct {
+		entries map[string]string
+		wantErr string
+	}{
+		"traversal":      {map[string]string{"bundle.json": valid, "etc/portage/../../etc/cron.d/evil": "x"}, "outside etc/portage/"},
+		"parent":         {map[string]string{"bundle.json": valid, "../evil": "x"}, "escapes the bundle"},
+		"absolute":       {map[string]string{"bundle.json": valid, "/etc/portage/make.conf": "x"}, "escapes the bundle"},
+		"outside":        {map[string]string{"bundle.json": valid, "root/.ssh/authorized_keys": "x"}, "outside etc/portage/"},
+		"symlink":        {map[string]string{"bundle.json": valid, "etc/portage/make.conf->/etc/shadow": ""}, "not a regular file"},
+		"bad atom":       {map[string]string{"bundle.json": `{"packages":{"packages":[{"atom":"foo; id"}]},"metadata":{"target_arch":"amd64"}}`}, "invalid bundle"},
+		"missing arch":   {map[string]string{"bundle.json": `{"packages":{"packages":[{"atom":"app-misc/hello"}]}}`}, "target_arch is required"},
+		"no bundle.json": {map[string]string{"packages.json": "{}"}, "bundle.json not found"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := transfer.ImportBundle(writeBundleTarball(t, tt.entries))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ImportBundle() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestApplyConfigToSystemRejectsTraversal tests that crafted repository
+// names and symlinks cannot make ApplyConfigToSystem write outside
+// etc/portage.
+func TestApplyConfigToSystemRejectsTraversal(t *testing.T) {
+	transfer := NewConfigTransfer("")
+	for _, name := range []string{"../../etc/cron.d/evil", "a/b", "..", ".hidden", "", "gentoo]\n[evil", `a\b`} {
+		root := t.TempDir()
+		bundle := &ConfigBundle{Config: &PortageConfig{Repos: []RepoConfig{{Name: name, Location: "/var/db/repos/x"}}}}
+		err := transfer.ApplyConfigToSystem(bundle, root)
+		if err == nil || !strings.Contains(err.Error(), "invalid repository name") {
+			t.Errorf("repo %q: ApplyConfigToSystem() = %v, want an invalid repository name error", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "etc", "cron.d")); err == nil {
+			t.Errorf("repo %q: wrote outside etc/portage", name)
+		}
+	}
+
+	// A file or directory symlinked out of etc/portage is not written through.
+	outside := t.TempDir()
+	symlinked := map[string]*PortageConfig{
+		"make.conf":   {MakeConf: map[string]string{"MAKEOPTS": "-j2"}},
+		"package.use": {PackageUse: map[string][]string{"app-misc/hello": {"test"}}},
+	}
+	for link, config := range symlinked {
+		root := t.TempDir()
+		portageDir := filepath.Join(root, "etc", "portage")
+		if err := os.MkdirAll(portageDir, 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(outside, link), filepath.Join(portageDir, link)); err != nil {
+			t.Fatal(err)
+		}
+		if link == "package.use" {
+			if err := os.Mkdir(filepath.Join(outside, link), 0o750); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := transfer.ApplyConfigToSystem(&ConfigBundle{Config: config}, root)
+		if err == nil || !strings.Contains(err.Error(), "symlink") {
+			t.Errorf("%s: ApplyConfigToSystem() = %v, want a symlink error", link, err)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Join(outside, "package.use")); len(entries) != 0 {
+		t.Error("wrote through a symlinked package.use")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "make.conf")); err == nil {
+		t.Error("wrote through a symlinked make.conf")
+	}
+}
+
+func TestValidatePortageFileName(t *testing.T) {
+	for _, name := range []string{"00-user", "gentoo.conf", "my_overlay.conf"} {
+		if err := validatePortageFileName(name); err != nil {
+			t.Errorf("validatePortageFileName(%q) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "a/b", `a\b`, "..", "x..y", ".conf", "a\x00b"} {
+		if err := validatePortageFileName(name); err == nil {
+			t.Errorf("validatePortageFileName(%q) accepted", name)
+		}
+	}
+}
+
 // TestApplyConfigToSystem tests applying configuration to a system.
 func TestApplyConfigToSystem(t *testing.T) {
 	// Create a temporary directory to simulate a target system
@@ -454,6 +589,77 @@ func verifyRepos(t *testing.T, config *PortageConfig) {
 }
 
 // TestReadSystemPortageConfigNonExistent tests reading from non-existent directory.
+func TestPackageEnvRoundTrip(t *testing.T) {
+	portageDir := t.TempDir()
+	files := map[string]string{
+		"package.env":      "# per-package overrides\napp-misc/hello no-lto.conf\nsys-libs/glibc debug.conf no-lto.conf\n",
+		"env/no-lto.conf":  "CFLAGS=\"${CFLAGS} -fno-lto\"\n",
+		"env/debug.conf":   "# keep symbols\nFEATURES='splitdebug'\n",
+		"env/.hidden.conf": "IGNORED=1\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(portageDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	transfer := NewConfigTransfer("")
+	config, err := transfer.ReadSystemPortageConfig(portageDir)
+	if err != nil {
+		t.Fatalf("ReadSystemPortageConfig() = %v", err)
+	}
+	if config.PackageEnv["app-misc/hello"] != "no-lto.conf" || config.PackageEnv["sys-libs/glibc"] != "debug.conf no-lto.conf" {
+		t.Errorf("PackageEnv = %v", config.PackageEnv)
+	}
+	if len(config.EnvFiles) != 2 || config.EnvFiles["no-lto.conf"] != files["env/no-lto.conf"] {
+		t.Errorf("EnvFiles = %v", config.EnvFiles)
+	}
+
+	bundle := &ConfigBundle{
+		Config:   config,
+		Packages: &BuildPackageSpec{Packages: []PackageSpec{{Atom: "app-misc/hello"}}},
+		Metadata: BundleMetadata{TargetArch: "amd64"},
+	}
+	if err := ValidateBundle(bundle); err != nil {
+		t.Fatalf("ValidateBundle() = %v", err)
+	}
+	bundlePath := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	if err := transfer.ExportBundle(bundle, bundlePath); err != nil {
+		t.Fatalf("ExportBundle() = %v", err)
+	}
+	imported, err := transfer.ImportBundle(bundlePath)
+	if err != nil {
+		t.Fatalf("ImportBundle() = %v", err)
+	}
+	if imported.Config.PackageEnv["sys-libs/glibc"] != "debug.conf no-lto.conf" || imported.Config.EnvFiles["debug.conf"] != files["env/debug.conf"] {
+		t.Errorf("imported config = %+v", imported.Config)
+	}
+
+	root := t.TempDir()
+	if err := transfer.ApplyConfigToSystem(imported, root); err != nil {
+		t.Fatalf("ApplyConfigToSystem() = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "etc", "portage", "package.env", "00-user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "app-misc/hello no-lto.conf\nsys-libs/glibc debug.conf no-lto.conf\n" {
+		t.Errorf("package.env = %q", data)
+	}
+	data, err = os.ReadFile(filepath.Join(root, "etc", "portage", "env", "no-lto.conf"))
+	if err != nil || string(data) != files["env/no-lto.conf"] {
+		t.Errorf("env/no-lto.conf = %q, %v", data, err)
+	}
+
+	if err := transfer.ApplyConfigToSystem(&ConfigBundle{Config: &PortageConfig{EnvFiles: map[string]string{"../evil": "X=1"}}}, t.TempDir()); err == nil {
+		t.Error("ApplyConfigToSystem() wrote an env file outside env/")
+	}
+}
+
 func TestReadSystemPortageConfigNonExistent(t *testing.T) {
 	transfer := NewConfigTransfer("")
 	_, err := transfer.ReadSystemPortageConfig("/nonexistent/portage")
@@ -476,3 +682,108 @@ func findSubstring(s, substr string) bool {
 	}
 	return false
 }
+
+// TestExportImportBundleDir tests that a bundle exported as a directory
+// reads back unchanged, and that edits to its files take effect.
+func TestExportImportBundleDir(t *testing.T) {
+	bundle := &ConfigBundle{
+		Config: &PortageConfig{
+			PackageUse:      map[string][]string{"dev-lang/python:3.11": {"ssl", "threads"}, "app-misc/hello": {"-nls"}},
+			PackageKeywords: map[string][]string{"dev-lang/python:3.11": {"~amd64"}},
+			PackageMask:     []string{">=dev-lang/python-3.13"},
+			PackageUnmask:   []string{"app-misc/hello"},
+			MakeConf:        map[string]string{"MAKEOPTS": "-j8", "USE": "systemd -consolekit"},
+			Environment:     map[string]string{"TZ": "UTC"},
+			GlobalUse:       []string{"systemd", "-consolekit"},
+			Repos: []RepoConfig{
+				{Name: "gentoo", Location: "/var/db/repos/gentoo", SyncType: "git", SyncURI: "https://github.com/gentoo-mirror/gentoo.git", Priority: -1000},
+				{Name: "guru", Location: "/var/db/repos/guru", SyncType: "git", SyncURI: "https://github.com/gentoo-mirror/guru.git", Ref: "dev"},
+			},
+			PackageEnv: map[string]string{"app-misc/hello": "no-lto.conf"},
+			EnvFiles:   map[string]string{"no-lto.conf": "CFLAGS=\"-O2\"\n"},
+		},
+		Packages: &BuildPackageSpec{Packages: []PackageSpec{{Atom: "dev-lang/python:3.11", UseFlags: []string{"ssl"}}}},
+		Metadata: BundleMetadata{UserID: "test-user", TargetArch: "amd64", Profile: "default/linux/amd64/23.0"},
+	}
+	transfer := NewConfigTransfer("")
+	dir := filepath.Join(t.TempDir(), "bundle")
+	if err := transfer.ExportBundleDir(bundle, dir); err != nil {
+		t.Fatalf("ExportBundleDir() = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "etc", "portage", "package.use", "00-user"))
+	if err != nil || string(data) != "app-misc/hello -nls\ndev-lang/python:3.11 ssl threads\n" {
+		t.Errorf("package.use = %q, %v; want sorted lines", data, err)
+	}
+
+	imported, err := transfer.ImportBundleDir(dir)
+	if err != nil {
+		t.Fatalf("ImportBundleDir() = %v", err)
+	}
+	if !reflect.DeepEqual(imported, bundle) {
+		t.Errorf("ImportBundleDir() = %+v\nwant %+v", imported.Config, bundle.Config)
+	}
+
+	// Hand edits to the tree and packages.json win over bundle.json.
+	appendFile(t, filepath.Join(dir, "etc", "portage", "package.use", "00-user"), "sys-libs/zlib minizip\n")
+	if err := os.WriteFile(filepath.Join(dir, "packages.json"), []byte(`{"packages":[{"atom":"sys-libs/zlib"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "etc", "portage", "package.mask", "00-user")); err != nil {
+		t.Fatal(err)
+	}
+	imported, err = transfer.ImportBundleDir(dir)
+	if err != nil {
+		t.Fatalf("ImportBundleDir() after editing = %v", err)
+	}
+	if got := imported.Config.PackageUse["sys-libs/zlib"]; !reflect.DeepEqual(got, []string{"minizip"}) {
+		t.Errorf("edited package.use entry = %v", got)
+	}
+	if len(imported.Config.PackageMask) != 0 {
+		t.Errorf("PackageMask = %v, want the removed file dropped", imported.Config.PackageMask)
+	}
+	if len(imported.Packages.Packages) != 1 || imported.Packages.Packages[0].Atom != "sys-libs/zlib" {
+		t.Errorf("Packages = %+v, want packages.json", imported.Packages)
+	}
+
+	// Exporting over an earlier export replaces it, stale files included.
+	bundle.Config.Repos = bundle.Config.Repos[:1]
+	if err := transfer.ExportBundleDir(bundle, dir); err != nil {
+		t.Fatalf("ExportBundleDir() over an earlier export = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "etc", "portage", "repos.conf", "guru.conf")); !os.IsNotExist(err) {
+		t.Errorf("stale guru.conf kept: %v", err)
+	}
+	other := t.TempDir()
+	if err := os.WriteFile(filepath.Join(other, "notes.txt"), []byte("keep"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := transfer.ExportBundleDir(bundle, other); err == nil {
+		t.Error("ExportBundleDir() wrote into a non-empty directory holding no bundle")
+	}
+
+	// Hidden entries are skipped, but links are refused.
+	if err := os.MkdirAll(filepath.Join(dir, ".git"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transfer.ImportBundleDir(dir); err != nil {
+		t.Errorf("ImportBundleDir() with a .git dir = %v", err)
+	}
+	if err := os.Symlink("/etc/shadow", filepath.Join(dir, "etc", "portage", "env", "evil.conf")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transfer.ImportBundleDir(dir); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("ImportBundleDir() with a symlink = %v", err)
+	}
+}
+
+func appendFile(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
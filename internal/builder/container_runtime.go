@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"strings"
 )
@@ -15,6 +17,9 @@ type ContainerRuntime interface {
 	Name() string
 	// Run executes a container with the given arguments.
 	Run(ctx context.Context, args []string) ([]byte, error)
+	// RunStream executes a container like Run, writing its combined output to
+	// out as it is produced.
+	RunStream(ctx context.Context, args []string, out io.Writer) error
 	// Create creates a container with the given arguments.
 	Create(ctx context.Context, args []string) error
 	// Start starts a container by name.
@@ -33,6 +38,14 @@ type ContainerRuntime interface {
 	Copy(ctx context.Context, src, dst string) error
 	// IsAvailable checks if the runtime is available.
 	IsAvailable() bool
+	// Ping checks that the runtime answers, returning why it does not.
+	Ping(ctx context.Context) error
+	// ImageDigest returns the ID (sha256:...) of a local image.
+	ImageDigest(ctx context.Context, image string) (string, error)
+	// BuildRunArgs returns the run or create options args (everything before
+	// the image) with the flags this runtime needs added, such as the user
+	// namespace mapping of rootless Podman.
+	BuildRunArgs(args []string) []string
 }
 
 // DockerRuntime implements ContainerRuntime for Docker.
@@ -59,6 +72,15 @@ func (d *DockerRuntime) Run(ctx context.Context, args []string) ([]byte, error)
 	return cmd.CombinedOutput()
 }
 
+// RunStream executes a container, streaming its combined output to out.
+func (d *DockerRuntime) RunStream(ctx context.Context, args []string, out io.Writer) error {
+	cmdArgs := append([]string{"run"}, args...)
+	cmd := exec.CommandContext(ctx, d.executable, cmdArgs...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
+
 // Create creates a container with the given arguments.
 func (d *DockerRuntime) Create(ctx context.Context, args []string) error {
 	cmdArgs := append([]string{"create"}, args...)
@@ -115,19 +137,38 @@ func (d *DockerRuntime) Copy(ctx context.Context, src, dst string) error {
 
 // IsAvailable checks if Docker is available.
 func (d *DockerRuntime) IsAvailable() bool {
-	cmd := exec.Command(d.executable, "version")
-	return cmd.Run() == nil
+	return d.Ping(context.Background()) == nil
+}
+
+// Ping checks that the Docker daemon answers.
+func (d *DockerRuntime) Ping(ctx context.Context) error {
+	return pingRuntime(ctx, d.executable)
+}
+
+// ImageDigest returns the ID of a local image.
+func (d *DockerRuntime) ImageDigest(ctx context.Context, image string) (string, error) {
+	return inspectImageID(ctx, d.executable, image)
+}
+
+// BuildRunArgs returns args unchanged: Docker runs containers as root of the
+// host, so bind mounts need no mapping.
+func (d *DockerRuntime) BuildRunArgs(args []string) []string {
+	return args
 }
 
 // PodmanRuntime implements ContainerRuntime for Podman.
 type PodmanRuntime struct {
 	executable string
+	// rootless is set when the builder runs Podman as a non-root user.
+	rootless bool
 }
 
-// NewPodmanRuntime creates a new Podman runtime.
+// NewPodmanRuntime creates a new Podman runtime. It runs rootless when the
+// builder's effective UID is not 0.
 func NewPodmanRuntime() *PodmanRuntime {
 	return &PodmanRuntime{
 		executable: "podman",
+		rootless:   os.Geteuid() != 0,
 	}
 }
 
@@ -143,6 +184,15 @@ func (p *PodmanRuntime) Run(ctx context.Context, args []string) ([]byte, error)
 	return cmd.CombinedOutput()
 }
 
+// RunStream executes a container, streaming its combined output to out.
+func (p *PodmanRuntime) RunStream(ctx context.Context, args []string, out io.Writer) error {
+	cmdArgs := append([]string{"run"}, args...)
+	cmd := exec.CommandContext(ctx, p.executable, cmdArgs...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
+
 // Create creates a container with the given arguments.
 func (p *PodmanRuntime) Create(ctx context.Context, args []string) error {
 	cmdArgs := append([]string{"create"}, args...)
@@ -199,8 +249,59 @@ func (p *PodmanRuntime) Copy(ctx context.Context, src, dst string) error {
 
 // IsAvailable checks if Podman is available.
 func (p *PodmanRuntime) IsAvailable() bool {
-	cmd := exec.Command(p.executable, "version")
-	return cmd.Run() == nil
+	return p.Ping(context.Background()) == nil
+}
+
+// Ping checks that Podman answers.
+func (p *PodmanRuntime) Ping(ctx context.Context) error {
+	return pingRuntime(ctx, p.executable)
+}
+
+// ImageDigest returns the ID of a local image.
+func (p *PodmanRuntime) ImageDigest(ctx context.Context, image string) (string, error) {
+	return inspectImageID(ctx, p.executable, image)
+}
+
+// BuildRunArgs maps the builder's own UID and GID to root in the container
+// when Podman runs rootless. The build runs as root inside the container, so
+// what it writes to the /output bind mount is then owned by the builder on the
+// host, and the builder's 0700 /gpg-keys directory is readable to it. Without
+// the mapping the files land under a subordinate UID the builder cannot copy.
+// Args that already choose a user namespace are left alone.
+func (p *PodmanRuntime) BuildRunArgs(args []string) []string {
+	if !p.rootless {
+		return args
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--userns") || strings.HasPrefix(arg, "--uidmap") || strings.HasPrefix(arg, "--gidmap") {
+			return args
+		}
+	}
+	return append([]string{"--userns=keep-id:uid=0,gid=0"}, args...)
+}
+
+// inspectImageID runs `<executable> image inspect` for the ID of image.
+func inspectImageID(ctx context.Context, executable, image string) (string, error) {
+	out, err := exec.CommandContext(ctx, executable, "image", "inspect", "--format", "{{.Id}}", image).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// pingRuntime runs `executable version`, which fails unless the runtime's
+// daemon or service answers.
+func pingRuntime(ctx context.Context, executable string) error {
+	out, err := exec.CommandContext(ctx, executable, "version").CombinedOutput()
+	if err != nil {
+		// The client half of the output comes first; the last line says why
+		// the server half is missing.
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s version: %w: %s", executable, err, msg[strings.LastIndex(msg, "\n")+1:])
+		}
+		return fmt.Errorf("%s version: %w", executable, err)
+	}
+	return nil
 }
 
 // envFlags expands a KEY=VALUE slice into ["-e", "KEY=VALUE", ...] flags for a
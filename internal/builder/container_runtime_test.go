@@ -2,6 +2,7 @@ package builder
 
 import (
 	"context"
+	"reflect"
 	"testing"
 )
 
@@ -117,3 +118,33 @@ func TestPodmanRuntimeMethods(t *testing.T) {
 		t.Log("Remove returned nil for non-existent container (expected on some systems)")
 	}
 }
+
+func TestBuildRunArgs(t *testing.T) {
+	args := []string{"--rm", "-v", "/w/output:/output", "-v", "/w/gpg-keys:/gpg-keys:ro"}
+
+	if got := NewDockerRuntime().BuildRunArgs(args); !reflect.DeepEqual(got, args) {
+		t.Errorf("docker: BuildRunArgs = %v, want args unchanged", got)
+	}
+	if got := (&PodmanRuntime{}).BuildRunArgs(args); !reflect.DeepEqual(got, args) {
+		t.Errorf("rootful podman: BuildRunArgs = %v, want args unchanged", got)
+	}
+
+	rootless := &PodmanRuntime{rootless: true}
+	want := append([]string{"--userns=keep-id:uid=0,gid=0"}, args...)
+	if got := rootless.BuildRunArgs(args); !reflect.DeepEqual(got, want) {
+		t.Errorf("rootless podman: BuildRunArgs = %v, want %v", got, want)
+	}
+
+	custom := []string{"--uidmap", "0:1:65536", "--rm"}
+	if got := rootless.BuildRunArgs(custom); !reflect.DeepEqual(got, custom) {
+		t.Errorf("explicit mapping: BuildRunArgs = %v, want args unchanged", got)
+	}
+}
+
+func TestBuildDockerArgsRootlessPodman(t *testing.T) {
+	lb := &LocalBuilder{containerRuntime: &PodmanRuntime{executable: "podman", rootless: true}}
+	args := lb.buildDockerArgs("/w/output", "/w/gpg-keys", nil, "")
+	if args[0] != "--userns=keep-id:uid=0,gid=0" {
+		t.Errorf("buildDockerArgs = %v, want the rootless user namespace first", args)
+	}
+}
@@ -0,0 +1,174 @@
+// Package builder provides cross-architecture builds under QEMU emulation.
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultBinfmtMiscDir is where the kernel lists binfmt_misc handlers.
+const defaultBinfmtMiscDir = "/proc/sys/fs/binfmt_misc"
+
+// crossArch is how a Gentoo arch runs in a container: the container
+// platform and the binfmt_misc handler QEMU user-mode emulation registers
+// for it.
+type crossArch struct {
+	platform string
+	binfmt   string
+}
+
+// crossArches are the Gentoo arches a builder can build for under QEMU.
+var crossArches = map[string]crossArch{
+	"amd64": {"linux/amd64", "qemu-x86_64"},
+	"arm64": {"linux/arm64", "qemu-aarch64"},
+	"arm":   {"linux/arm/v7", "qemu-arm"},
+	"x86":   {"linux/386", "qemu-i386"},
+	"ppc64": {"linux/ppc64le", "qemu-ppc64le"},
+	"riscv": {"linux/riscv64", "qemu-riscv64"},
+	"s390":  {"linux/s390x", "qemu-s390x"},
+	"loong": {"linux/loong64", "qemu-loongarch64"},
+}
+
+// nativeForeignArches are the other arches a host arch runs natively, so
+// building for them needs a platform but no emulation.
+var nativeForeignArches = map[string][]string{
+	"amd64": {"x86"},
+}
+
+// crossTarget is the container a build for another arch runs in. The zero
+// value builds for the builder's own arch with its image.
+type crossTarget struct {
+	arch     string
+	platform string
+	image    string
+	emulated bool
+}
+
+// crossArchEnabled reports whether builds for other arches run in a
+// container of that arch.
+func (lb *LocalBuilder) crossArchEnabled() bool {
+	return lb.cfg != nil && lb.cfg.EnableQEMU
+}
+
+// jobTargetArch is the arch req is built for: its arch, or else its
+// config bundle's target_arch.
+func jobTargetArch(req *LocalBuildRequest) string {
+	if req.Arch != "" {
+		return req.Arch
+	}
+	if req.ConfigBundle != nil {
+		return req.ConfigBundle.Metadata.TargetArch
+	}
+	return ""
+}
+
+// crossTargetFor returns the container job's Docker build runs in. A job
+// for another arch than the builder's runs with that arch's platform and
+// image when ENABLE_QEMU is set; without it, the builder's own arch is
+// built as before. It fails when the arch needs emulation and no QEMU
+// binfmt_misc handler is registered for it.
+func (lb *LocalBuilder) crossTargetFor(job *BuildJob) (crossTarget, error) {
+	arch := jobTargetArch(job.Request)
+	if arch == "" || arch == lb.architecture || !lb.crossArchEnabled() {
+		return crossTarget{}, nil
+	}
+	ca, ok := crossArches[arch]
+	if !ok {
+		return crossTarget{}, fmt.Errorf("cannot build for arch %s on this %s builder: no QEMU platform is known for it", arch, lb.architecture)
+	}
+	target := crossTarget{arch: arch, platform: ca.platform, image: lb.crossArchImage(arch), emulated: !lb.runsNatively(arch)}
+	if target.emulated && !binfmtRegistered(lb.binfmtDir(), ca.binfmt) {
+		return crossTarget{}, fmt.Errorf("cannot build for arch %s on this %s builder: the QEMU binfmt_misc handler %s is not registered "+
+			"(install qemu-user-static, or run: docker run --privileged --rm tonistiigi/binfmt --install %s)",
+			arch, lb.architecture, ca.binfmt, strings.TrimPrefix(ca.platform, "linux/"))
+	}
+	return target, nil
+}
+
+// runsNatively reports whether the builder's CPU runs arch without QEMU.
+func (lb *LocalBuilder) runsNatively(arch string) bool {
+	for _, a := range nativeForeignArches[lb.architecture] {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// crossArchImage is the stage3 image of arch: CROSS_ARCH_IMAGE with
+// "{arch}" replaced by arch, or else the builder's image, whose manifest
+// list the runtime resolves for the requested platform.
+func (lb *LocalBuilder) crossArchImage(arch string) string {
+	if lb.cfg != nil && lb.cfg.CrossArchImage != "" {
+		return strings.ReplaceAll(lb.cfg.CrossArchImage, "{arch}", arch)
+	}
+	return lb.dockerImage
+}
+
+// binfmtDir is the binfmt_misc directory QEMU handlers are looked up in.
+func (lb *LocalBuilder) binfmtDir() string {
+	if lb.binfmtMiscDir != "" {
+		return lb.binfmtMiscDir
+	}
+	return defaultBinfmtMiscDir
+}
+
+// binfmtRegistered reports whether the binfmt_misc handler name is
+// registered and enabled.
+func binfmtRegistered(dir, name string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, name)) // #nosec G304 -- name comes from crossArches.
+	if err != nil {
+		return false
+	}
+	first, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSpace(first) == "enabled"
+}
+
+// EmulatedArchitectures returns the other arches this builder can build for
+// under ENABLE_QEMU: those its CPU runs natively and those with a QEMU
+// handler registered, sorted. It is nil without ENABLE_QEMU or Docker.
+func (lb *LocalBuilder) EmulatedArchitectures() []string {
+	if !lb.crossArchEnabled() || !lb.useDocker {
+		return nil
+	}
+	var arches []string
+	for arch, ca := range crossArches {
+		if arch != lb.architecture && (lb.runsNatively(arch) || binfmtRegistered(lb.binfmtDir(), ca.binfmt)) {
+			arches = append(arches, arch)
+		}
+	}
+	sort.Strings(arches)
+	return arches
+}
+
+// recordCrossTarget notes in job's log and metadata that it builds for
+// another arch: "platform", "emulated" (QEMU builds are much slower) and the
+// "image" when it is not the builder's own.
+func (lb *LocalBuilder) recordCrossTarget(job *BuildJob, target crossTarget) {
+	if target.platform == "" {
+		return
+	}
+	how := "natively"
+	if target.emulated {
+		how = "under QEMU emulation"
+	}
+	job.appendLog(fmt.Sprintf("Building for %s (%s) %s on this %s builder\n", target.arch, target.platform, how, lb.architecture))
+	job.setMetadata("platform", target.platform)
+	job.setMetadata("emulated", target.emulated)
+	if target.image != lb.dockerImage {
+		job.setMetadata("image", target.image)
+	}
+}
+
+// jobImage is the image job was built in.
+func (lb *LocalBuilder) jobImage(job *BuildJob) string {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if image, ok := job.Metadata["image"].(string); ok {
+		return image
+	}
+	return lb.dockerImage
+}
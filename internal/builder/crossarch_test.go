@@ -0,0 +1,131 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// newCrossArchBuilder returns an amd64 Docker builder with ENABLE_QEMU set
+// and the QEMU handlers registered in its binfmt_misc dir.
+func newCrossArchBuilder(t *testing.T, handlers ...string) *LocalBuilder {
+	t.Helper()
+	dir := t.TempDir()
+	for _, h := range handlers {
+		if err := os.WriteFile(filepath.Join(dir, h), []byte("enabled\ninterpreter /usr/bin/"+h+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "qemu-riscv64"), []byte("disabled\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return &LocalBuilder{
+		architecture:  "amd64",
+		useDocker:     true,
+		dockerImage:   "gentoo/stage3:latest",
+		cfg:           &config.BuilderConfig{EnableQEMU: true},
+		binfmtMiscDir: dir,
+		pkgMgr:        &GentooPackageManager{},
+	}
+}
+
+func TestCrossTargetFor(t *testing.T) {
+	t.Parallel()
+
+	lb := newCrossArchBuilder(t, "qemu-aarch64")
+	jobFor := func(arch string) *BuildJob {
+		return &BuildJob{ID: "j", Request: &LocalBuildRequest{PackageName: "app-misc/jq", Arch: arch}}
+	}
+
+	target, err := lb.crossTargetFor(jobFor("arm64"))
+	want := crossTarget{arch: "arm64", platform: "linux/arm64", image: "gentoo/stage3:latest", emulated: true}
+	if err != nil || target != want {
+		t.Errorf("crossTargetFor(arm64) = %+v, %v; want %+v", target, err, want)
+	}
+	if target, err := lb.crossTargetFor(jobFor("x86")); err != nil || target.platform != "linux/386" || target.emulated {
+		t.Errorf("crossTargetFor(x86) = %+v, %v; want linux/386 without emulation", target, err)
+	}
+	for _, arch := range []string{"", "amd64"} {
+		if target, err := lb.crossTargetFor(jobFor(arch)); err != nil || target != (crossTarget{}) {
+			t.Errorf("crossTargetFor(%q) = %+v, %v; want a native build", arch, target, err)
+		}
+	}
+
+	_, err = lb.crossTargetFor(jobFor("riscv"))
+	if err == nil || !strings.Contains(err.Error(), "qemu-riscv64 is not registered") || !strings.Contains(err.Error(), "--install riscv64") {
+		t.Errorf("crossTargetFor(riscv) error = %v, want the missing handler named", err)
+	}
+	if _, err := lb.crossTargetFor(jobFor("mips")); err == nil {
+		t.Error("crossTargetFor(mips) built for an arch without a platform")
+	}
+
+	lb.cfg.CrossArchImage = "registry.example.org/stage3-{arch}:latest"
+	if target, _ := lb.crossTargetFor(jobFor("arm64")); target.image != "registry.example.org/stage3-arm64:latest" {
+		t.Errorf("image = %q, want CROSS_ARCH_IMAGE for arm64", target.image)
+	}
+	lb.cfg.EnableQEMU = false
+	if target, err := lb.crossTargetFor(jobFor("arm64")); err != nil || target != (crossTarget{}) {
+		t.Errorf("crossTargetFor(arm64) without ENABLE_QEMU = %+v, %v; want a native build", target, err)
+	}
+}
+
+func TestCrossTargetBundleArch(t *testing.T) {
+	t.Parallel()
+
+	lb := newCrossArchBuilder(t, "qemu-aarch64")
+	job := &BuildJob{ID: "j", Request: &LocalBuildRequest{ConfigBundle: &ConfigBundle{Metadata: BundleMetadata{TargetArch: "arm64"}}}}
+	target, err := lb.crossTargetFor(job)
+	if err != nil || target.platform != "linux/arm64" {
+		t.Fatalf("crossTargetFor() = %+v, %v; want the bundle's target arch", target, err)
+	}
+	lb.recordCrossTarget(job, target)
+	if job.Metadata["emulated"] != true || job.Metadata["platform"] != "linux/arm64" {
+		t.Errorf("metadata = %v, want the build recorded as emulated", job.Metadata)
+	}
+}
+
+func TestBuildDockerArgsPlatform(t *testing.T) {
+	t.Parallel()
+
+	lb := newCrossArchBuilder(t)
+	if args := strings.Join(lb.buildDockerArgs("/tmp/out", "", nil, "linux/arm64"), " "); !strings.Contains(args, "--platform linux/arm64") {
+		t.Errorf("buildDockerArgs = %s, want the target platform", args)
+	}
+	if args := strings.Join(lb.buildDockerArgs("/tmp/out", "", nil, ""), " "); strings.Contains(args, "--platform") {
+		t.Errorf("buildDockerArgs = %s, want no platform for a native build", args)
+	}
+}
+
+func TestEmulatedArchitectures(t *testing.T) {
+	t.Parallel()
+
+	lb := newCrossArchBuilder(t, "qemu-aarch64", "qemu-arm")
+	if got := lb.EmulatedArchitectures(); !slices.Equal(got, []string{"arm", "arm64", "x86"}) {
+		t.Errorf("EmulatedArchitectures() = %v", got)
+	}
+	lb.useDocker = false
+	if got := lb.EmulatedArchitectures(); got != nil {
+		t.Errorf("EmulatedArchitectures() without Docker = %v", got)
+	}
+}
+
+func TestArchBuildersEmulated(t *testing.T) {
+	var probes atomic.Int32
+	legacy := archBuilder(t, "", &probes).URL
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+
+	emulating, native := "http://amd64-builder:9090", "http://arm64-builder:9090"
+	mgr.recordBuilderArch(emulating, "amd64", "arm64")
+	mgr.recordBuilderArch(native, "arm64")
+
+	got, err := mgr.archBuilders([]string{legacy, emulating, native}, "arm64")
+	if err != nil || strings.Join(got, ",") != native+","+emulating+","+legacy {
+		t.Errorf("archBuilders(arm64) = %v, %v; want the arm64 builder, then the emulating one, then the unknown one", got, err)
+	}
+}
@@ -0,0 +1,65 @@
+// Package builder provides build start deadlines.
+package builder
+
+import (
+	"fmt"
+	"time"
+)
+
+// expiredStatus is the terminal status of a job that was still queued when
+// its start deadline passed. It never ran.
+const expiredStatus = "expired"
+
+// startDeadline resolves when a request must have started building: the
+// earlier of its absolute Deadline and now+MaxQueueWait. Zero means none.
+func startDeadline(req *BuildRequest, now time.Time) (time.Time, error) {
+	var deadline time.Time
+	if req.Deadline != nil {
+		deadline = *req.Deadline
+	}
+	if req.MaxQueueWait != "" {
+		wait, err := time.ParseDuration(req.MaxQueueWait)
+		if err != nil || wait <= 0 {
+			return time.Time{}, fmt.Errorf("invalid max_queue_wait %q: want a duration such as \"15m\"", req.MaxQueueWait)
+		}
+		if at := now.Add(wait); deadline.IsZero() || at.Before(deadline) {
+			deadline = at
+		}
+	}
+	if !deadline.IsZero() && !deadline.After(now) {
+		return time.Time{}, fmt.Errorf("deadline %s has already passed", deadline.Format(time.RFC3339))
+	}
+	return deadline, nil
+}
+
+// scheduleExpiry expires job jobID at deadline if it has not started by then,
+// so its status reads "expired" even while it still sits in the work queue.
+func (m *Manager) scheduleExpiry(jobID string, deadline time.Time) {
+	time.AfterFunc(time.Until(deadline), func() {
+		m.jobsMu.Lock()
+		defer m.jobsMu.Unlock()
+		if job, ok := m.jobs[jobID]; ok && job.Status == "queued" {
+			m.expireJobLocked(job)
+		}
+	})
+}
+
+// expireJobLocked moves a queued job past its deadline to expiredStatus.
+// Callers hold jobsMu for writing.
+func (m *Manager) expireJobLocked(job *BuildStatus) {
+	m.setStatusLocked(job, expiredStatus)
+	job.Error = fmt.Sprintf("not started before its deadline %s; cancelled", job.Deadline.Format(time.RFC3339))
+	job.UpdatedAt = time.Now()
+}
+
+// statusView copies job for a caller, filling in the time left before a
+// queued job's deadline. Callers hold jobsMu.
+func statusView(job *BuildStatus, now time.Time) *BuildStatus {
+	view := *job
+	if view.Status == "queued" && view.Deadline != nil {
+		if left := view.Deadline.Sub(now); left > 0 {
+			view.DeadlineRemaining = int64(left.Round(time.Second) / time.Second)
+		}
+	}
+	return &view
+}
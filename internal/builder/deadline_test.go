@@ -0,0 +1,91 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestStartDeadline(t *testing.T) {
+	now := time.Date(2025, 12, 11, 10, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+
+	if d, err := startDeadline(&BuildRequest{}, now); err != nil || !d.IsZero() {
+		t.Errorf("no deadline: got %v, %v", d, err)
+	}
+	if d, err := startDeadline(&BuildRequest{MaxQueueWait: "15m"}, now); err != nil || !d.Equal(now.Add(15*time.Minute)) {
+		t.Errorf("max_queue_wait: got %v, %v", d, err)
+	}
+	// The earlier of the two limits applies.
+	if d, err := startDeadline(&BuildRequest{Deadline: &later, MaxQueueWait: "2h"}, now); err != nil || !d.Equal(later) {
+		t.Errorf("deadline before wait: got %v, %v", d, err)
+	}
+	if d, err := startDeadline(&BuildRequest{Deadline: &later, MaxQueueWait: "5m"}, now); err != nil || !d.Equal(now.Add(5*time.Minute)) {
+		t.Errorf("wait before deadline: got %v, %v", d, err)
+	}
+
+	past := now.Add(-time.Minute)
+	if _, err := startDeadline(&BuildRequest{Deadline: &past}, now); err == nil {
+		t.Error("expected a past deadline to be rejected")
+	}
+	if _, err := startDeadline(&BuildRequest{MaxQueueWait: "soon"}, now); err == nil || !strings.Contains(err.Error(), "max_queue_wait") {
+		t.Errorf("invalid max_queue_wait: got %v", err)
+	}
+}
+
+func TestQueuedJobExpiresAtDeadline(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+
+	jobID, err := mgr.SubmitBuild(&BuildRequest{PackageName: "app-misc/hello", Arch: "amd64", MaxQueueWait: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := mgr.GetStatus(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Deadline == nil || status.DeadlineRemaining <= 3500 || status.DeadlineRemaining > 3600 {
+		t.Errorf("queued job: deadline %v, remaining %d", status.Deadline, status.DeadlineRemaining)
+	}
+
+	shortID, err := mgr.SubmitBuild(&BuildRequest{PackageName: "app-misc/hello", Arch: "amd64", MaxQueueWait: "20ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	status, err = mgr.GetStatus(shortID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != expiredStatus || status.Error == "" || status.DeadlineRemaining != 0 {
+		t.Errorf("expired job: %+v", status)
+	}
+	if got := mgr.GetClusterStatus(); got.ExpiredBuilds != 1 || got.QueuedBuilds != 1 || got.FailedBuilds != 0 {
+		t.Errorf("cluster status = %+v", got)
+	}
+}
+
+func TestClaimJobSkipsExpired(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+
+	jobID, err := mgr.SubmitBuild(&BuildRequest{PackageName: "app-misc/hello", Arch: "amd64", MaxQueueWait: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The worker reaches the job after its deadline, before the timer fires.
+	mgr.jobsMu.Lock()
+	past := time.Now().Add(-time.Second)
+	mgr.jobs[jobID].Deadline = &past
+	mgr.jobsMu.Unlock()
+
+	if mgr.claimJob(jobID) {
+		t.Fatal("claimJob claimed a job past its deadline")
+	}
+	if status, _ := mgr.GetStatus(jobID); status.Status != expiredStatus {
+		t.Errorf("status = %q, want %q", status.Status, expiredStatus)
+	}
+}
@@ -0,0 +1,147 @@
+// Package builder provides the disk space pre-flight check run before builds.
+package builder
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/slchris/portage-engine/internal/notification"
+)
+
+// FailureInsufficientDisk is the failure category of jobs failed because the
+// builder lacked the disk space to start them. It is retryable: another
+// builder, or this one once cleaned up, can build the package.
+const FailureInsufficientDisk = "insufficient_disk"
+
+// diskSpaceWait is the DISK_SPACE_ACTION that leaves a job queued until
+// enough disk space is free instead of failing it.
+const diskSpaceWait = "wait"
+
+// diskWaitInterval is how often a job waiting for disk space checks again.
+var diskWaitInterval = 30 * time.Second
+
+// minFreeDiskBytes returns the free space a build needs in the work and
+// artifact directories, 0 when the check is disabled.
+func (lb *LocalBuilder) minFreeDiskBytes() uint64 {
+	if lb.cfg == nil || lb.cfg.MinFreeDiskGB <= 0 {
+		return 0
+	}
+	return uint64(lb.cfg.MinFreeDiskGB) << 30
+}
+
+// checkDiskSpace returns an error naming the first of the work and artifact
+// directories with less than MIN_FREE_DISK_GB free, or nil. A directory
+// whose usage cannot be read is not held against the build.
+func (lb *LocalBuilder) checkDiskSpace() error {
+	need := lb.minFreeDiskBytes()
+	if need == 0 {
+		return nil
+	}
+	for _, d := range []struct{ kind, dir string }{{"work", lb.workDir}, {"artifact", lb.artifactDir}} {
+		total, used, _ := getDiskInfo(d.dir)
+		if total == 0 {
+			continue
+		}
+		if free := total - used; free < need {
+			return fmt.Errorf("insufficient disk space: %s directory %s has %s free, builds need %d GB",
+				d.kind, d.dir, formatGB(free), lb.cfg.MinFreeDiskGB)
+		}
+	}
+	return nil
+}
+
+// formatGB formats a byte count in gigabytes.
+func formatGB(bytes uint64) string {
+	return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+}
+
+// awaitDiskSpace runs the disk space pre-flight check for a queued job and
+// reports whether the worker should build it. Short of space, the job fails
+// as a retryable FailureInsufficientDisk failure, or with
+// DISK_SPACE_ACTION=wait stays queued until space is freed; it is dropped
+// when cancelled or failed by a drain meanwhile.
+func (lb *LocalBuilder) awaitDiskSpace(job *BuildJob) bool {
+	lb.checkDiskWatermark()
+	waiting := false
+	for {
+		err := lb.checkDiskSpace()
+		if err == nil {
+			if waiting {
+				job.appendLog("[disk] disk space freed; starting the build\n")
+				job.setMetadata("waiting_for_disk", false)
+			}
+			return true
+		}
+		if lb.cfg.DiskSpaceAction != diskSpaceWait {
+			job.mu.Lock()
+			if job.Status != "queued" {
+				job.mu.Unlock()
+				return false
+			}
+			markInterrupted(job, FailureInsufficientDisk, err.Error())
+			job.EndTime = time.Now()
+			job.logSubs.closeAll()
+			job.mu.Unlock()
+			log.Printf("Job %s failed: %v", job.ref(), err)
+			lb.retireJob(job)
+			return false
+		}
+		if !waiting {
+			waiting = true
+			log.Printf("Job %s waiting: %v", job.ref(), err)
+			job.appendLog("[disk] " + err.Error() + "; waiting for space to be freed\n")
+			job.setMetadata("waiting_for_disk", true)
+		}
+		time.Sleep(diskWaitInterval)
+		job.mu.Lock()
+		status := job.Status
+		job.mu.Unlock()
+		if status != "queued" {
+			return false
+		}
+	}
+}
+
+// checkDiskWatermark sends a warning notification when the usage of the
+// work or artifact directory's filesystem crosses DISK_HIGH_WATERMARK
+// percent, once until it drops below again.
+func (lb *LocalBuilder) checkDiskWatermark() {
+	if lb.cfg == nil || lb.cfg.DiskHighWatermark <= 0 {
+		return
+	}
+	for _, dir := range []string{lb.workDir, lb.artifactDir} {
+		total, used, usage := getDiskInfo(dir)
+		if total == 0 || usage < float64(lb.cfg.DiskHighWatermark) {
+			continue
+		}
+		if lb.diskWarned.CompareAndSwap(false, true) {
+			msg := fmt.Sprintf("disk usage of %s is %.0f%% (%s of %s), above the %d%% high watermark; free space before builds start failing",
+				dir, usage, formatGB(used), formatGB(total), lb.cfg.DiskHighWatermark)
+			log.Printf("WARNING: %s", msg)
+			lb.sendDiskWarning(msg)
+		}
+		return
+	}
+	lb.diskWarned.Store(false)
+}
+
+// sendDiskWarning notifies the operators of a disk space warning for the
+// builder through the build notification channels.
+func (lb *LocalBuilder) sendDiskWarning(msg string) {
+	notifier := lb.notifier.Load()
+	if notifier == nil {
+		return
+	}
+	now := time.Now()
+	notify := &notification.BuildNotification{
+		PackageName: "builder " + lb.instanceID,
+		Status:      "warning",
+		StartTime:   now,
+		EndTime:     now,
+		Error:       msg,
+	}
+	if err := notifier.Notify(notify); err != nil {
+		log.Printf("Failed to send disk space warning: %v", err)
+	}
+}
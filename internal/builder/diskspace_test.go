@@ -0,0 +1,83 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// noRoomBuilder returns a builder whose builds need more disk space than any
+// test machine has, failing or waiting per action.
+func noRoomBuilder(t *testing.T, action string) *LocalBuilder {
+	return &LocalBuilder{
+		cfg:         &config.BuilderConfig{MinFreeDiskGB: 1 << 20, DiskSpaceAction: action},
+		workDir:     t.TempDir(),
+		artifactDir: t.TempDir(),
+		jobs:        map[string]*BuildJob{},
+	}
+}
+
+func TestAwaitDiskSpaceFailsJob(t *testing.T) {
+	lb := noRoomBuilder(t, "fail")
+	job := &BuildJob{ID: "j", Status: "queued", Request: &LocalBuildRequest{PackageName: "dev-lang/rust"}}
+	if lb.awaitDiskSpace(job) {
+		t.Fatal("a build short of disk space was started")
+	}
+	if job.Status != "failed" || !strings.Contains(job.Error, "insufficient disk space") ||
+		job.Metadata["failure_category"] != FailureInsufficientDisk || job.Metadata["retryable"] != true {
+		t.Errorf("job = %s %q %v, want a retryable insufficient_disk failure", job.Status, job.Error, job.Metadata)
+	}
+
+	lb.cfg.MinFreeDiskGB = 0
+	queued := &BuildJob{ID: "k", Status: "queued", Request: &LocalBuildRequest{PackageName: "app-misc/jq"}}
+	if !lb.awaitDiskSpace(queued) || queued.Status != "queued" {
+		t.Errorf("with the check disabled the job was not started: %s", queued.Status)
+	}
+}
+
+func TestAwaitDiskSpaceWaitsWhileQueued(t *testing.T) {
+	defer func(d time.Duration) { diskWaitInterval = d }(diskWaitInterval)
+	diskWaitInterval = 10 * time.Millisecond
+
+	lb := noRoomBuilder(t, "wait")
+	job := &BuildJob{ID: "j", Status: "queued", Request: &LocalBuildRequest{PackageName: "dev-lang/rust"}}
+	lb.jobs[job.ID] = job
+	started := make(chan bool)
+	go func() { started <- lb.awaitDiskSpace(job) }()
+
+	time.Sleep(50 * time.Millisecond)
+	job.mu.Lock()
+	status, waiting := job.Status, job.Metadata["waiting_for_disk"]
+	job.mu.Unlock()
+	if status != "queued" || waiting != true {
+		t.Fatalf("waiting job = %s %v, want queued and waiting_for_disk", status, waiting)
+	}
+	if !lb.cancelQueued(job, "build cancelled before it started") {
+		t.Fatal("the waiting job could not be cancelled")
+	}
+	if <-started {
+		t.Error("a job cancelled while waiting for disk space was started")
+	}
+}
+
+func TestCheckDiskWatermark(t *testing.T) {
+	lb := &LocalBuilder{
+		cfg:         &config.BuilderConfig{DiskHighWatermark: 1},
+		workDir:     t.TempDir(),
+		artifactDir: t.TempDir(),
+	}
+	if total, used, _ := getDiskInfo(lb.workDir); total == 0 || used == 0 {
+		t.Skip("disk usage unavailable")
+	}
+	lb.checkDiskWatermark()
+	if !lb.diskWarned.Load() {
+		t.Error("crossing the high watermark sent no warning")
+	}
+	lb.cfg.DiskHighWatermark = 101
+	lb.checkDiskWatermark()
+	if lb.diskWarned.Load() {
+		t.Error("the warning was not reset below the watermark")
+	}
+}
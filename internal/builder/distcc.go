@@ -0,0 +1,164 @@
+// Package builder provides distcc support for distributing compilation.
+package builder
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// distccPort is distccd's default TCP port.
+	distccPort = "3632"
+	// distccDefaultLimit is distcc's default number of jobs sent to a remote
+	// host whose spec has no "/LIMIT".
+	distccDefaultLimit = 4
+	// distccPingTimeout bounds the reachability check of one host.
+	distccPingTimeout = 2 * time.Second
+)
+
+// distccHost is a parsed DISTCC_HOSTS entry.
+type distccHost struct {
+	spec  string // the entry as configured, passed on to distcc unchanged
+	addr  string // host:port to probe, "" for hosts that are not probed
+	limit int    // jobs distcc sends to the host
+}
+
+// parseDistccHost parses a distcc host spec: HOST[:PORT][/LIMIT][,OPTIONS].
+// SSH hosts (USER@HOST or @HOST) and localhost are not probed, as they do not
+// reach a distccd over TCP.
+func parseDistccHost(spec string) distccHost {
+	h := distccHost{spec: spec, limit: distccDefaultLimit}
+	hostPart := spec
+	if i := strings.Index(hostPart, ","); i >= 0 {
+		hostPart = hostPart[:i]
+	}
+	if i := strings.LastIndex(hostPart, "/"); i >= 0 {
+		if n, err := strconv.Atoi(hostPart[i+1:]); err == nil && n > 0 {
+			h.limit = n
+		}
+		hostPart = hostPart[:i]
+	}
+	if strings.Contains(hostPart, "@") || hostPart == "localhost" || hostPart == "" {
+		return h
+	}
+	if _, _, err := net.SplitHostPort(hostPart); err == nil {
+		h.addr = hostPart
+	} else {
+		h.addr = net.JoinHostPort(strings.Trim(hostPart, "[]"), distccPort)
+	}
+	return h
+}
+
+// reachableDistccHosts probes each host in specs concurrently and returns
+// those that accept a connection, in their configured order, along with the
+// ones that did not.
+func reachableDistccHosts(specs []string, timeout time.Duration) (up []distccHost, down []string) {
+	hosts := make([]distccHost, len(specs))
+	ok := make([]bool, len(specs))
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		hosts[i] = parseDistccHost(spec)
+		if hosts[i].addr == "" {
+			ok[i] = true
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", hosts[i].addr, timeout)
+			if err == nil {
+				_ = conn.Close()
+				ok[i] = true
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, h := range hosts {
+		if ok[i] {
+			up = append(up, h)
+		} else {
+			down = append(down, h.spec)
+		}
+	}
+	return up, down
+}
+
+// distccMakeOpts returns the MAKEOPTS for a build distributed over hosts:
+// enough jobs to fill every host's slots plus the local CPUs, with the load
+// limit keeping local preprocessing from overloading the builder.
+func distccMakeOpts(hosts []distccHost) string {
+	jobs := runtime.NumCPU()
+	for _, h := range hosts {
+		jobs += h.limit
+	}
+	return fmt.Sprintf("-j%d -l%d", jobs, runtime.NumCPU())
+}
+
+// distccHostsForJob checks the configured distcc hosts before a build and
+// returns the reachable ones, recording them in the job metadata as
+// "distcc_hosts" and any dropped ones as "distcc_unreachable". It returns nil
+// when distcc is not configured or no host is reachable.
+func (lb *LocalBuilder) distccHostsForJob(job *BuildJob) []distccHost {
+	if lb.cfg == nil || len(lb.cfg.DistccHosts) == 0 {
+		return nil
+	}
+	up, down := reachableDistccHosts(lb.cfg.DistccHosts, distccPingTimeout)
+	if len(down) > 0 {
+		job.setMetadata("distcc_unreachable", down)
+		job.appendLog(fmt.Sprintf("[distcc] unreachable, not used: %s\n", strings.Join(down, " ")))
+	}
+	if len(up) == 0 {
+		job.appendLog("[distcc] no distcc host is reachable; compiling locally\n")
+		return nil
+	}
+	specs := make([]string, 0, len(up))
+	for _, h := range up {
+		specs = append(specs, h.spec)
+	}
+	job.setMetadata("distcc_hosts", specs)
+	job.appendLog(fmt.Sprintf("[distcc] distributing compilation to: %s\n", strings.Join(specs, " ")))
+	return up
+}
+
+// distccEnv returns the container environment handing hosts to the build
+// script: DISTCC_HOSTS, and the MAKEOPTS to use with them in
+// DISTCC_MAKEOPTS, applied only once the script found distcc in the image.
+func distccEnv(hosts []distccHost) map[string]string {
+	if len(hosts) == 0 {
+		return nil
+	}
+	specs := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		specs = append(specs, h.spec)
+	}
+	return map[string]string{
+		"DISTCC_HOSTS":    strings.Join(specs, " "),
+		"DISTCC_MAKEOPTS": distccMakeOpts(hosts),
+	}
+}
+
+// distccScriptSetup returns the build script lines enabling distcc when the
+// container was given DISTCC_HOSTS, or "" when distcc is not configured. An
+// image without distcc builds locally rather than failing on FEATURES=distcc.
+func (lb *LocalBuilder) distccScriptSetup() string {
+	if lb.cfg == nil || len(lb.cfg.DistccHosts) == 0 {
+		return ""
+	}
+	return `
+if [ -n "${DISTCC_HOSTS:-}" ]; then
+    if command -v distcc >/dev/null 2>&1; then
+        export FEATURES="${FEATURES} distcc"
+        export MAKEOPTS="${DISTCC_MAKEOPTS}"
+        echo "distcc enabled: DISTCC_HOSTS=\"${DISTCC_HOSTS}\" MAKEOPTS=\"${MAKEOPTS}\""
+    else
+        echo "distcc is not installed in the build image; compiling locally"
+    fi
+fi
+`
+}
@@ -0,0 +1,118 @@
+package builder
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestParseDistccHost(t *testing.T) {
+	tests := []struct {
+		spec  string
+		addr  string
+		limit int
+	}{
+		{"10.0.0.5", "10.0.0.5:3632", 4},
+		{"10.0.0.5:4000/12", "10.0.0.5:4000", 12},
+		{"helper/8,lzo", "helper:3632", 8},
+		{"[fd00::1]:3632", "[fd00::1]:3632", 4},
+		{"builder@helper/6", "", 6},
+		{"localhost/2", "", 2},
+	}
+	for _, tt := range tests {
+		h := parseDistccHost(tt.spec)
+		if h.addr != tt.addr || h.limit != tt.limit || h.spec != tt.spec {
+			t.Errorf("parseDistccHost(%q) = %+v, want addr %q limit %d", tt.spec, h, tt.addr, tt.limit)
+		}
+	}
+}
+
+// listenDistcc returns the address of a listener standing in for distccd, and
+// one nothing listens on.
+func listenDistcc(t *testing.T) (up, down string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down = closed.Addr().String()
+	_ = closed.Close()
+	return ln.Addr().String(), down
+}
+
+func TestReachableDistccHosts(t *testing.T) {
+	upAddr, downAddr := listenDistcc(t)
+	up, down := reachableDistccHosts([]string{downAddr + "/4", upAddr + "/8", "localhost"}, time.Second)
+
+	if len(up) != 2 || up[0].spec != upAddr+"/8" || up[1].spec != "localhost" {
+		t.Errorf("up = %+v", up)
+	}
+	if len(down) != 1 || down[0] != downAddr+"/4" {
+		t.Errorf("down = %v", down)
+	}
+}
+
+func TestDistccHostsForJob(t *testing.T) {
+	upAddr, downAddr := listenDistcc(t)
+	lb := &LocalBuilder{cfg: &config.BuilderConfig{DistccHosts: []string{upAddr, downAddr}}, pkgMgr: &GentooPackageManager{}}
+	job := &BuildJob{ID: "j1"}
+
+	hosts := lb.distccHostsForJob(job)
+	if len(hosts) != 1 {
+		t.Fatalf("hosts = %+v, want the reachable one", hosts)
+	}
+	if used, _ := job.Metadata["distcc_hosts"].([]string); !slices.Equal(used, []string{upAddr}) {
+		t.Errorf("distcc_hosts = %v", job.Metadata["distcc_hosts"])
+	}
+	if dropped, _ := job.Metadata["distcc_unreachable"].([]string); !slices.Equal(dropped, []string{downAddr}) {
+		t.Errorf("distcc_unreachable = %v", job.Metadata["distcc_unreachable"])
+	}
+
+	args := strings.Join(lb.buildDockerArgs("/tmp/out", "", hosts, ""), " ")
+	if !strings.Contains(args, "-e DISTCC_HOSTS="+upAddr) || !strings.Contains(args, "-e DISTCC_MAKEOPTS=-j") {
+		t.Errorf("docker args missing the distcc env: %s", args)
+	}
+	script := lb.generateBuildScript("app-misc/jq", "", "", "", jobControl{})
+	if !strings.Contains(script, `FEATURES="${FEATURES} distcc"`) || !strings.Contains(script, `MAKEOPTS="${DISTCC_MAKEOPTS}"`) {
+		t.Error("script does not enable distcc")
+	}
+
+	// With every host down the build compiles locally.
+	lb.cfg.DistccHosts = []string{downAddr}
+	if hosts := lb.distccHostsForJob(&BuildJob{ID: "j2"}); hosts != nil {
+		t.Errorf("hosts = %+v, want none", hosts)
+	}
+}
+
+func TestDistccDisabledByDefault(t *testing.T) {
+	lb := &LocalBuilder{cfg: &config.BuilderConfig{}, pkgMgr: &GentooPackageManager{}}
+	if script := lb.generateBuildScript("app-misc/jq", "", "", "", jobControl{}); strings.Contains(script, "distcc") {
+		t.Error("script should not use distcc unless configured")
+	}
+	job := &BuildJob{}
+	if hosts := lb.distccHostsForJob(job); hosts != nil || job.Metadata != nil {
+		t.Errorf("hosts = %v, metadata = %v", hosts, job.Metadata)
+	}
+}
+
+func TestDistccMakeOpts(t *testing.T) {
+	opts := distccMakeOpts([]distccHost{{limit: 8}, {limit: 4}})
+	if !strings.HasPrefix(opts, "-j") || !strings.Contains(opts, " -l") {
+		t.Errorf("MAKEOPTS = %q", opts)
+	}
+	var jobs int
+	if _, err := fmt.Sscanf(opts, "-j%d", &jobs); err != nil || jobs != 12+runtime.NumCPU() {
+		t.Errorf("MAKEOPTS = %q, want -j%d", opts, 12+runtime.NumCPU())
+	}
+}
@@ -0,0 +1,141 @@
+// Package builder provides the graceful drain of a builder that shuts down.
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// drainCancelWait bounds how long a drain waits for builds it cancelled at
+// the end of the grace period to stop their containers and exit.
+const drainCancelWait = containerStopTimeout + 20*time.Second
+
+// FailureBuilderShutdown is the failure category of jobs failed because
+// their builder shut down before they could finish. It is retryable: the
+// build itself did nothing wrong.
+const FailureBuilderShutdown = "builder_shutdown"
+
+// ErrShuttingDown is returned by SubmitBuild once the builder has started to
+// drain.
+var ErrShuttingDown = errors.New("builder is shutting down")
+
+// shutdownGracePeriod is how long a shutdown lets running builds finish.
+func (lb *LocalBuilder) shutdownGracePeriod() time.Duration {
+	if lb.cfg == nil {
+		return 0
+	}
+	return lb.cfg.ShutdownGracePeriod
+}
+
+// Draining reports whether the builder is shutting down.
+func (lb *LocalBuilder) Draining() bool {
+	return lb.draining.Load()
+}
+
+// Drain stops the builder taking work: SubmitBuild refuses new builds, the
+// jobs still queued fail as retryable FailureBuilderShutdown failures so the
+// server submits them again elsewhere, and the running builds get up to
+// grace to finish. Builds still running then are cancelled through their
+// contexts and fail the same way. Drain returns once every worker has
+// exited, or drainCancelWait after cancelling them; only the first call
+// drains.
+func (lb *LocalBuilder) Drain(grace time.Duration) {
+	if !lb.draining.CompareAndSwap(false, true) {
+		return
+	}
+
+	queued := lb.failQueuedJobs("builder shut down before the build started; submit it again")
+	if lb.jobQueue != nil {
+		lb.jobQueue.close()
+	}
+	building := lb.countJobs("building")
+	log.Printf("Draining: %d queued job(s) failed, waiting up to %s for %d running build(s)", queued, grace, building)
+
+	if lb.waitWorkers(grace) {
+		log.Printf("Drained: every running build finished")
+		return
+	}
+	reason := fmt.Sprintf("build cancelled: the builder shut down and its %s grace period ran out", grace)
+	for _, job := range lb.jobsWithStatus("building") {
+		job.mu.Lock()
+		if job.Status != "building" {
+			job.mu.Unlock()
+			continue
+		}
+		job.interruption = reason
+		job.interruptCategory = FailureBuilderShutdown
+		cancel := job.cancel
+		job.mu.Unlock()
+		job.appendLog("[shutdown] " + reason + "\n")
+		if cancel != nil {
+			cancel()
+		}
+	}
+	if !lb.waitWorkers(drainCancelWait) {
+		log.Printf("Warning: builds still running %s after they were cancelled; shutting down anyway", drainCancelWait)
+	}
+}
+
+// failQueuedJobs fails every queued job with reason as a retryable
+// FailureBuilderShutdown failure, taking it off the queue, and returns how
+// many it failed.
+func (lb *LocalBuilder) failQueuedJobs(reason string) int {
+	failed := 0
+	for _, job := range lb.jobsWithStatus("queued") {
+		job.mu.Lock()
+		if job.Status != "queued" {
+			job.mu.Unlock()
+			continue
+		}
+		markInterrupted(job, FailureBuilderShutdown, reason)
+		job.EndTime = time.Now()
+		job.logSubs.closeAll()
+		job.mu.Unlock()
+		if lb.jobQueue != nil {
+			lb.jobQueue.remove(job.ID)
+		}
+		lb.retireJob(job)
+		failed++
+	}
+	return failed
+}
+
+// jobsWithStatus returns the in-memory jobs whose status is status.
+func (lb *LocalBuilder) jobsWithStatus(status string) []*BuildJob {
+	lb.jobsMutex.RLock()
+	defer lb.jobsMutex.RUnlock()
+	var jobs []*BuildJob
+	for _, job := range lb.jobs {
+		job.mu.Lock()
+		if job.Status == status {
+			jobs = append(jobs, job)
+		}
+		job.mu.Unlock()
+	}
+	return jobs
+}
+
+// countJobs returns the number of in-memory jobs whose status is status.
+func (lb *LocalBuilder) countJobs(status string) int {
+	return len(lb.jobsWithStatus(status))
+}
+
+// waitWorkers waits up to timeout for the workers to exit and reports
+// whether they did.
+func (lb *LocalBuilder) waitWorkers(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		lb.workersWG.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// runFakeWorker stands in for a worker building job: it exits when finish is
+// closed or the job is cancelled, reporting a cancellation on cancelled.
+func runFakeWorker(lb *LocalBuilder, job *BuildJob, finish <-chan struct{}) (cancelled <-chan struct{}) {
+	cancelCh := make(chan struct{})
+	job.cancel = func() { close(cancelCh) }
+	lb.workersWG.Add(1)
+	go func() {
+		defer lb.workersWG.Done()
+		select {
+		case <-finish:
+		case <-cancelCh:
+		}
+	}()
+	return cancelCh
+}
+
+func TestDrainLetsRunningBuildsFinish(t *testing.T) {
+	queued := &BuildJob{ID: "q", Status: "queued", Request: &LocalBuildRequest{PackageName: "app-misc/jq"}}
+	building := &BuildJob{ID: "b", Status: "building", Request: &LocalBuildRequest{PackageName: "dev-lang/python"}}
+	lb := &LocalBuilder{
+		jobs:     map[string]*BuildJob{"q": queued, "b": building},
+		jobQueue: newJobQueue(2),
+	}
+	if err := lb.jobQueue.push(queued, 0); err != nil {
+		t.Fatal(err)
+	}
+	finish := make(chan struct{})
+	cancelled := runFakeWorker(lb, building, finish)
+	time.AfterFunc(50*time.Millisecond, func() { close(finish) })
+
+	lb.Drain(time.Minute)
+
+	if queued.Status != "failed" || queued.Metadata["failure_category"] != FailureBuilderShutdown || queued.Metadata["retryable"] != true {
+		t.Errorf("queued job = %s %v, want a retryable builder_shutdown failure", queued.Status, queued.Metadata)
+	}
+	if n := lb.jobQueue.len(); n != 0 {
+		t.Errorf("%d jobs still queued", n)
+	}
+	select {
+	case <-cancelled:
+		t.Error("a build that finished within the grace period was cancelled")
+	default:
+	}
+	if _, err := lb.SubmitBuild(&LocalBuildRequest{PackageName: "app-misc/jq"}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("SubmitBuild while draining = %v, want ErrShuttingDown", err)
+	}
+	if err := lb.jobQueue.push(queued, 0); err == nil {
+		t.Error("the queue still takes jobs after the drain")
+	}
+}
+
+func TestDrainCancelsAfterGracePeriod(t *testing.T) {
+	building := &BuildJob{ID: "b", Status: "building", Request: &LocalBuildRequest{PackageName: "dev-lang/python"}}
+	lb := &LocalBuilder{jobs: map[string]*BuildJob{"b": building}, jobQueue: newJobQueue(1)}
+	cancelled := runFakeWorker(lb, building, nil)
+
+	start := time.Now()
+	lb.Drain(20 * time.Millisecond)
+
+	select {
+	case <-cancelled:
+	default:
+		t.Fatal("the build running past the grace period was not cancelled")
+	}
+	if time.Since(start) > drainCancelWait {
+		t.Errorf("Drain took %s after the build stopped", time.Since(start))
+	}
+	// The worker fails the job once the build process has exited.
+	if building.interruptCategory != FailureBuilderShutdown || building.interruption == "" {
+		t.Errorf("interruption = %q (%s), want a builder_shutdown one", building.interruption, building.interruptCategory)
+	}
+	markInterrupted(building, building.interruptCategory, building.interruption)
+	if !RetryableFailure(building.Metadata["failure_category"].(string)) {
+		t.Errorf("shutdown failure %v is not retryable", building.Metadata)
+	}
+}
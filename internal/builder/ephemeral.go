@@ -0,0 +1,354 @@
+// Package builder provides ephemeral artifact delivery.
+package builder
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// EphemeralDownloadPath is the server endpoint serving a held ephemeral
+// artifact exactly once (?job_id=<id>&token=<token>).
+const EphemeralDownloadPath = "/api/v1/builds/artifact"
+
+// defaultEphemeralTTL is how long an undownloaded ephemeral artifact is held.
+const defaultEphemeralTTL = 30 * time.Minute
+
+// ErrEphemeralNotFound is returned when a job has no ephemeral artifact
+// waiting: it was never held, was already downloaded, or expired.
+var ErrEphemeralNotFound = errors.New("no ephemeral artifact held for job")
+
+// ephemeralArtifact is a built package spooled outside the binhost until its
+// one-time download or TTL expiry. token, random per artifact and carried in
+// the job's DownloadURL, must accompany the download, so guessing a job ID
+// cannot claim (and so destroy) someone else's artifact.
+type ephemeralArtifact struct {
+	path     string
+	filename string
+	token    string
+	timer    *time.Timer
+}
+
+// callbackResolveTimeout bounds the DNS lookup checking a callback host.
+const callbackResolveTimeout = 5 * time.Second
+
+// cgnatPrefix is the shared address space (RFC 6598), private in practice.
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// Callback clients never follow redirects, which could point a vetted
+// callback anywhere. publicCallbackClient also refuses to connect to
+// non-public addresses, so a host re-resolving after the submit-time check
+// cannot reach internal services either.
+var (
+	publicCallbackClient      = newCallbackClient(true)
+	allowlistedCallbackClient = newCallbackClient(false)
+)
+
+// validateCallbackURL checks the callback an ephemeral artifact is POSTed to.
+func validateCallbackURL(raw string) error {
+	u, err := neturl.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback_url %q: want an http(s) URL", raw)
+	}
+	return nil
+}
+
+// callbackHosts returns the operator's callback host allowlist, if any.
+func (m *Manager) callbackHosts() []string {
+	if m.config == nil {
+		return nil
+	}
+	return m.config.EphemeralCallbackHosts
+}
+
+// checkCallbackTarget rejects a callback the server must not POST to: with
+// EPHEMERAL_CALLBACK_HOSTS set, a host outside it; otherwise a host that
+// resolves to a loopback, private, link-local (cloud metadata included) or
+// other non-public address.
+func (m *Manager) checkCallbackTarget(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := neturl.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid callback_url %q: %w", raw, err)
+	}
+	host := u.Hostname()
+	if allowed := m.callbackHosts(); len(allowed) > 0 {
+		if !slices.ContainsFunc(allowed, func(h string) bool { return strings.EqualFold(h, host) }) {
+			return fmt.Errorf("callback_url host %q is not in EPHEMERAL_CALLBACK_HOSTS", host)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), callbackResolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("resolve callback_url host %q: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return fmt.Errorf("callback_url host %q resolves to non-public address %s", host, addr)
+		}
+	}
+	return nil
+}
+
+// publicAddr reports whether addr is a public unicast address.
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !cgnatPrefix.Contains(addr)
+}
+
+// newCallbackClient returns the HTTP client streaming artifacts to
+// callbacks; publicOnly makes it refuse to dial non-public addresses.
+func newCallbackClient(publicOnly bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if publicOnly {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddr(ap.Addr()) {
+				return fmt.Errorf("refusing to connect to non-public callback address %s", ap.Addr())
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: artifactHTTPClient.Timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// callbackClient returns the client for this server's callbacks: an
+// operator-allowlisted host may be internal, any other must be public.
+func (m *Manager) callbackClient() *http.Client {
+	if len(m.callbackHosts()) > 0 {
+		return allowlistedCallbackClient
+	}
+	return publicCallbackClient
+}
+
+// ephemeralTTL returns how long held artifacts wait for their download.
+func (m *Manager) ephemeralTTL() time.Duration {
+	if m.config != nil && m.config.EphemeralArtifactTTL > 0 {
+		return time.Duration(m.config.EphemeralArtifactTTL) * time.Minute
+	}
+	return defaultEphemeralTTL
+}
+
+// ephemeralDir is the spool directory for held artifacts, kept apart from
+// the binhost so they never appear in the Packages index. It is cleared on
+// startup since nothing can claim a spool file from a previous run.
+func (m *Manager) ephemeralDir() string {
+	if m.config != nil && m.config.DataDir != "" {
+		return filepath.Join(m.config.DataDir, "ephemeral")
+	}
+	return filepath.Join(os.TempDir(), "portage-engine-ephemeral")
+}
+
+// ephemeralTarget reports whether a job opted into ephemeral delivery and the
+// callback its artifact is streamed to, if any.
+func (m *Manager) ephemeralTarget(jobID string) (bool, string) {
+	m.jobsMu.RLock()
+	defer m.jobsMu.RUnlock()
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return false, ""
+	}
+	return job.Ephemeral, job.callbackURL
+}
+
+// deliverEphemeral downloads a finished job's primary artifact from its
+// builder without storing it in the binhost: it is streamed straight to the
+// job's callback URL, or otherwise spooled for a one-time download.
+func (m *Manager) deliverEphemeral(jobID, baseURL, remoteJobID, remoteArtifact string) error {
+	_, callback := m.ephemeralTarget(jobID)
+
+	url := fmt.Sprintf("%s/api/v1/artifacts/download/%s", baseURL, remoteJobID)
+	resp, err := m.builderGet(artifactHTTPClient, url)
+	if err != nil {
+		return fmt.Errorf("download artifact: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("artifact download returned %d: %s", resp.StatusCode, string(body))
+	}
+	filename := artifactFilename(resp.Header.Get("Content-Disposition"), remoteArtifact)
+	if filename == "" {
+		return fmt.Errorf("cannot determine artifact filename")
+	}
+
+	if callback != "" {
+		m.appendJobLog(jobID, "[collect] streaming ephemeral artifact "+filename+" to the callback URL…")
+		if err := m.checkCallbackTarget(callback); err != nil {
+			return err
+		}
+		if err := streamArtifactToCallback(m.callbackClient(), callback, jobID, filename, resp); err != nil {
+			return err
+		}
+		m.appendJobLog(jobID, "[collect] ephemeral artifact delivered; nothing was stored")
+		return nil
+	}
+
+	if err := m.holdEphemeral(jobID, filename, resp.Body); err != nil {
+		return err
+	}
+	m.appendJobLog(jobID, fmt.Sprintf("[collect] ephemeral artifact %s held for one download (expires in %s)", filename, m.ephemeralTTL()))
+	return nil
+}
+
+// streamArtifactToCallback POSTs the builder's artifact response body to
+// callback as it arrives, without buffering it on the server.
+func streamArtifactToCallback(client *http.Client, callback, jobID, filename string, src *http.Response) error {
+	req, err := http.NewRequest(http.MethodPost, callback, src.Body)
+	if err != nil {
+		return fmt.Errorf("callback request: %w", err)
+	}
+	req.ContentLength = src.ContentLength
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	req.Header.Set("X-Portage-Job-ID", jobID)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("stream artifact to callback: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("callback returned %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// holdEphemeral spools an artifact for a one-time download and schedules its
+// removal after the TTL.
+func (m *Manager) holdEphemeral(jobID, filename string, body io.Reader) error {
+	dir := m.ephemeralDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create ephemeral dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "artifact-*")
+	if err != nil {
+		return fmt.Errorf("spool artifact: %w", err)
+	}
+	tmpName := tmp.Name()
+	if _, err := io.Copy(tmp, body); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("write artifact: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("write artifact: %w", err)
+	}
+
+	art := &ephemeralArtifact{path: tmpName, filename: filename, token: rand.Text()}
+	art.timer = time.AfterFunc(m.ephemeralTTL(), func() { m.expireEphemeral(jobID, art) })
+
+	m.ephemeralMu.Lock()
+	if m.ephemeral == nil {
+		m.ephemeral = make(map[string]*ephemeralArtifact)
+	}
+	m.ephemeral[jobID] = art
+	m.ephemeralMu.Unlock()
+
+	m.jobsMu.Lock()
+	if job, ok := m.jobs[jobID]; ok {
+		job.DownloadURL = EphemeralDownloadPath + "?" + neturl.Values{"job_id": {jobID}, "token": {art.token}}.Encode()
+	}
+	m.jobsMu.Unlock()
+	return nil
+}
+
+// takeEphemeral removes a job's held artifact from the table, so it can be
+// claimed at most once, provided token is the artifact's. A wrong token
+// leaves the artifact in place.
+func (m *Manager) takeEphemeral(jobID, token string) *ephemeralArtifact {
+	m.ephemeralMu.Lock()
+	art := m.ephemeral[jobID]
+	if art == nil || subtle.ConstantTimeCompare([]byte(token), []byte(art.token)) != 1 {
+		m.ephemeralMu.Unlock()
+		return nil
+	}
+	delete(m.ephemeral, jobID)
+	m.ephemeralMu.Unlock()
+	art.timer.Stop()
+	m.jobsMu.Lock()
+	if job, ok := m.jobs[jobID]; ok {
+		job.DownloadURL = ""
+	}
+	m.jobsMu.Unlock()
+	return art
+}
+
+// expireEphemeral deletes an artifact nobody downloaded within the TTL.
+func (m *Manager) expireEphemeral(jobID string, art *ephemeralArtifact) {
+	m.ephemeralMu.Lock()
+	current := m.ephemeral[jobID] == art
+	m.ephemeralMu.Unlock()
+	if !current || m.takeEphemeral(jobID, art.token) == nil {
+		return
+	}
+	if err := os.Remove(art.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Job %s: could not remove expired ephemeral artifact: %v", jobID, err)
+	}
+	m.appendJobLog(jobID, "[cleanup] ephemeral artifact expired without being downloaded and was removed")
+}
+
+// TakeEphemeralArtifact claims a job's held ephemeral artifact for its single
+// download with the token from its DownloadURL, returning the open file and
+// its filename. The spool file is unlinked immediately, so the data is gone
+// once the caller closes it. A wrong token is ErrEphemeralNotFound too.
+func (m *Manager) TakeEphemeralArtifact(jobID, token string) (*os.File, string, error) {
+	art := m.takeEphemeral(jobID, token)
+	if art == nil {
+		return nil, "", fmt.Errorf("%w: %s", ErrEphemeralNotFound, jobID)
+	}
+	f, err := os.Open(art.path)
+	_ = os.Remove(art.path)
+	if err != nil {
+		return nil, "", fmt.Errorf("open ephemeral artifact: %w", err)
+	}
+	m.appendJobLog(jobID, "[cleanup] ephemeral artifact downloaded and removed")
+	return f, art.filename, nil
+}
+
+// discardEphemeral removes every held artifact (server shutdown).
+func (m *Manager) discardEphemeral() {
+	m.ephemeralMu.Lock()
+	held := m.ephemeral
+	m.ephemeral = nil
+	m.ephemeralMu.Unlock()
+	for _, art := range held {
+		art.timer.Stop()
+		_ = os.Remove(art.path)
+	}
+}
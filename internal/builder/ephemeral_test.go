@@ -0,0 +1,214 @@
+package builder
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func newEphemeralManager(t *testing.T) *Manager {
+	t.Helper()
+	mgr := &Manager{
+		config: &config.ServerConfig{DataDir: t.TempDir(), BinpkgPath: t.TempDir()},
+		jobs:   map[string]*BuildStatus{},
+	}
+	t.Cleanup(mgr.discardEphemeral)
+	return mgr
+}
+
+// artifactBuilder serves a fake builder artifact download for remote job "rj".
+func artifactBuilder(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/artifacts/download/rj" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="hello-1.0-1.gpkg.tar"`)
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEphemeralHeldForOneDownload(t *testing.T) {
+	mgr := newEphemeralManager(t)
+	mgr.jobs["job"] = &BuildStatus{JobID: "job", Ephemeral: true}
+	builderSrv := artifactBuilder(t, "binpkg-bytes")
+
+	if err := mgr.deliverEphemeral("job", builderSrv.URL, "rj", ""); err != nil {
+		t.Fatalf("deliverEphemeral() error = %v", err)
+	}
+	token := mgr.ephemeral["job"].token
+	if got := mgr.jobs["job"].DownloadURL; got != EphemeralDownloadPath+"?job_id=job&token="+token || token == "" {
+		t.Errorf("DownloadURL = %q", got)
+	}
+	if entries, _ := os.ReadDir(mgr.config.BinpkgPath); len(entries) != 0 {
+		t.Errorf("ephemeral artifact written to the binhost: %v", entries)
+	}
+
+	// Without the token the artifact is neither served nor used up.
+	for _, wrong := range []string{"", "guess", token + "x"} {
+		if _, _, err := mgr.TakeEphemeralArtifact("job", wrong); !errors.Is(err, ErrEphemeralNotFound) {
+			t.Errorf("download with token %q: error = %v, want ErrEphemeralNotFound", wrong, err)
+		}
+	}
+
+	f, name, err := mgr.TakeEphemeralArtifact("job", token)
+	if err != nil {
+		t.Fatalf("TakeEphemeralArtifact() error = %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	_ = f.Close()
+	if string(data) != "binpkg-bytes" || name != "hello-1.0-1.gpkg.tar" {
+		t.Errorf("download = %q (%s)", data, name)
+	}
+	if entries, _ := os.ReadDir(mgr.ephemeralDir()); len(entries) != 0 {
+		t.Errorf("spool not cleaned after download: %v", entries)
+	}
+	if mgr.jobs["job"].DownloadURL != "" {
+		t.Error("DownloadURL still set after the download")
+	}
+	if _, _, err := mgr.TakeEphemeralArtifact("job", token); !errors.Is(err, ErrEphemeralNotFound) {
+		t.Errorf("second download error = %v, want ErrEphemeralNotFound", err)
+	}
+}
+
+func TestEphemeralExpiry(t *testing.T) {
+	mgr := newEphemeralManager(t)
+	mgr.jobs["job"] = &BuildStatus{JobID: "job", Ephemeral: true}
+	if err := mgr.holdEphemeral("job", "hello-1.0-1.gpkg.tar", strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	art := mgr.ephemeral["job"]
+
+	mgr.expireEphemeral("job", art)
+	if _, err := os.Stat(art.path); !os.IsNotExist(err) {
+		t.Errorf("expired artifact still on disk: %v", err)
+	}
+	if _, _, err := mgr.TakeEphemeralArtifact("job", art.token); !errors.Is(err, ErrEphemeralNotFound) {
+		t.Errorf("download after expiry error = %v, want ErrEphemeralNotFound", err)
+	}
+}
+
+func TestEphemeralStreamsToCallback(t *testing.T) {
+	mgr := newEphemeralManager(t)
+	var got, gotJob string
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		got, gotJob = string(data), r.Header.Get("X-Portage-Job-ID")
+	}))
+	defer callback.Close()
+	mgr.config.EphemeralCallbackHosts = []string{"127.0.0.1"}
+	mgr.jobs["job"] = &BuildStatus{JobID: "job", Ephemeral: true, callbackURL: callback.URL}
+	builderSrv := artifactBuilder(t, "binpkg-bytes")
+
+	if err := mgr.deliverEphemeral("job", builderSrv.URL, "rj", ""); err != nil {
+		t.Fatalf("deliverEphemeral() error = %v", err)
+	}
+	if got != "binpkg-bytes" || gotJob != "job" {
+		t.Errorf("callback received %q for job %q", got, gotJob)
+	}
+	if mgr.jobs["job"].DownloadURL != "" {
+		t.Error("a streamed artifact must not also be held for download")
+	}
+	if entries, _ := os.ReadDir(mgr.ephemeralDir()); len(entries) != 0 {
+		t.Errorf("streamed artifact was spooled: %v", entries)
+	}
+}
+
+func TestEphemeralCallbackFailure(t *testing.T) {
+	mgr := newEphemeralManager(t)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer callback.Close()
+	mgr.config.EphemeralCallbackHosts = []string{"127.0.0.1"}
+	mgr.jobs["job"] = &BuildStatus{JobID: "job", Ephemeral: true, callbackURL: callback.URL}
+
+	err := mgr.deliverEphemeral("job", artifactBuilder(t, "x").URL, "rj", "")
+	if err == nil || !strings.Contains(err.Error(), "callback returned 403") {
+		t.Errorf("deliverEphemeral() error = %v, want the callback status", err)
+	}
+}
+
+func TestEphemeralCallbackRefusesInternalHosts(t *testing.T) {
+	mgr := newEphemeralManager(t)
+	var called bool
+	callback := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true }))
+	defer callback.Close()
+	mgr.jobs["job"] = &BuildStatus{JobID: "job", Ephemeral: true, callbackURL: callback.URL}
+
+	err := mgr.deliverEphemeral("job", artifactBuilder(t, "x").URL, "rj", "")
+	if err == nil || !strings.Contains(err.Error(), "non-public") {
+		t.Errorf("deliverEphemeral() error = %v, want a non-public address rejection", err)
+	}
+	if called {
+		t.Error("artifact was POSTed to a loopback callback")
+	}
+}
+
+func TestCheckCallbackTarget(t *testing.T) {
+	mgr := newEphemeralManager(t)
+	for _, raw := range []string{
+		"http://127.0.0.1:8080/upload",
+		"http://[::1]/upload",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.5/upload",
+		"http://192.168.1.10/upload",
+		"http://100.64.0.1/upload",
+		"http://[fd00:ec2::254]/upload",
+		"http://[::ffff:127.0.0.1]/upload",
+		"http://0.0.0.0/upload",
+	} {
+		if err := mgr.checkCallbackTarget(raw); err == nil {
+			t.Errorf("checkCallbackTarget(%s) accepted an internal address", raw)
+		}
+	}
+	for _, raw := range []string{"", "https://93.184.215.14/upload", "http://[2606:4700::1111]/upload"} {
+		if err := mgr.checkCallbackTarget(raw); err != nil {
+			t.Errorf("checkCallbackTarget(%s) error = %v", raw, err)
+		}
+	}
+
+	mgr.config.EphemeralCallbackHosts = []string{"ci.internal", "10.0.0.5"}
+	for raw, wantErr := range map[string]bool{
+		"https://CI.internal:8443/upload":  false,
+		"http://10.0.0.5/upload":           false,
+		"https://93.184.215.14/upload":     true,
+		"http://169.254.169.254/latest/":   true,
+		"https://ci.internal.example/hook": true,
+	} {
+		if err := mgr.checkCallbackTarget(raw); (err != nil) != wantErr {
+			t.Errorf("allowlisted checkCallbackTarget(%s) error = %v, wantErr %v", raw, err, wantErr)
+		}
+	}
+}
+
+func TestValidateBuildRequestCallbackURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     BuildRequest
+		wantErr bool
+	}{
+		{"ephemeral without callback", BuildRequest{Ephemeral: true}, false},
+		{"https callback", BuildRequest{Ephemeral: true, CallbackURL: "https://client.example/upload"}, false},
+		{"callback without ephemeral", BuildRequest{CallbackURL: "https://client.example/upload"}, true},
+		{"non-http callback", BuildRequest{Ephemeral: true, CallbackURL: "file:///etc/passwd"}, true},
+		{"no host", BuildRequest{Ephemeral: true, CallbackURL: "http:///upload"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.PackageName = "app-misc/hello"
+			if err := validateBuildRequest(&tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("validateBuildRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -27,6 +27,16 @@ type BuildOptions struct {
 	// SignHostGnupgHome is the host directory holding the signing keyring; the
 	// Docker executor bind-mounts it into the container at SignGnupgHome.
 	SignHostGnupgHome string
+	// SeparateFetch runs `emerge --fetchonly` before the build, so source
+	// download failures are reported separately from compile failures.
+	SeparateFetch bool
+	// ArtifactDebug logs every artifact considered when picking a build's
+	// primary package.
+	ArtifactDebug bool
+	// MakeOpts is the MAKEOPTS of packages whose bundle sets none, and
+	// EmergeJobs the emerge options building several packages at once.
+	MakeOpts   string
+	EmergeJobs string
 }
 
 // signingEnabled reports whether native binpkg signing should be configured.
@@ -69,7 +79,7 @@ func (be *BuildExecutor) ExecuteBuild(
 ) error {
 	// Reject any bundle whose fields contain shell metacharacters or option
 	// injection before constructing any command.
-	if err := validateBundle(bundle); err != nil {
+	if err := ValidateBundle(bundle); err != nil {
 		return fmt.Errorf("invalid build request: %w", err)
 	}
 
@@ -83,76 +93,85 @@ func (be *BuildExecutor) ExecuteBuild(
 		_ = os.RemoveAll(buildWorkDir)
 	}()
 
+	pkgs := bundle.Packages.Packages
+	bundle, spec := bundleBuildSpec(bundle, be.opts.MakeOpts)
+	be.recordMakeOpts(job, spec, bundle, pkgs)
+	// The host's emerge reads the host's repos.conf, not the one applied to
+	// the workspace, so it could not sync or build from an overlay.
+	if repos, err := overlayRepos(pkgs); err != nil {
+		return err
+	} else if len(repos) > 0 {
+		return fmt.Errorf("building from an overlay needs the Docker executor")
+	}
+
 	// Apply configuration to build environment
 	if err := be.configTransfer.ApplyConfigToSystem(bundle, buildWorkDir); err != nil {
 		return fmt.Errorf("failed to apply configuration: %w", err)
 	}
 
-	// Build each package
-	for _, pkg := range bundle.Packages.Packages {
-		if err := be.buildPackage(ctx, pkg, bundle, buildWorkDir, job); err != nil {
-			return fmt.Errorf("failed to build package %s: %w", pkg.Atom, err)
-		}
+	// Build every package in one emerge, into a PKGDIR of this build's own,
+	// and collect everything it produced.
+	pkgDir := be.nativePkgDir(buildWorkDir)
+	err := be.buildPackages(ctx, spec, bundle, buildWorkDir, pkgDir, job)
+	if err != nil {
+		err = fmt.Errorf("failed to build %s: %w", bundleAtoms(pkgs), err)
 	}
-
-	return nil
+	if job.pretending() {
+		return pretendResult(err)
+	}
+	return be.collectBundleArtifacts(job, pkgs, pkgDir, err)
 }
 
-// buildPackage builds a single package.
-func (be *BuildExecutor) buildPackage(
+// buildPackages emerges a bundle's packages, with spec's environment.
+func (be *BuildExecutor) buildPackages(
 	ctx context.Context,
-	pkg PackageSpec,
+	spec PackageSpec,
 	bundle *ConfigBundle,
 	buildWorkDir string,
+	pkgDir string,
 	job *BuildJob,
 ) error {
-	// Construct emerge command
-	cmd := be.constructEmergeCommand(pkg, bundle, buildWorkDir)
-
-	// Execute build
-	var stdout, stderr bytes.Buffer
-	execCmd := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
-	execCmd.Stdout = &stdout
-	execCmd.Stderr = &stderr
-	execCmd.Dir = buildWorkDir
+	emergeCmd := be.bundleEmergeCommand(bundle)
+	opts := be.opts
+	if job.pretending() {
+		emergeCmd, opts.SeparateFetch = pretendCommand(emergeCmd), false
+	}
+	env := append(os.Environ(), be.buildEnvironment(spec, bundle, pkgDir)...)
 
-	// Set environment variables
-	execCmd.Env = append(os.Environ(), be.buildEnvironment(pkg, bundle, be.nativePkgDir())...)
+	job.appendLog(fmt.Sprintf("Building packages: %s\n", bundleAtoms(bundle.Packages.Packages)))
 
-	job.appendLog(fmt.Sprintf("Building package: %s\n", pkg.Atom))
-	job.appendLog(fmt.Sprintf("Command: %s\n", strings.Join(cmd, " ")))
+	return opts.runPhases(job, emergeCmd, func(cmd []string) error {
+		var stdout, stderr bytes.Buffer
+		execCmd := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+		execCmd.Stdout = &stdout
+		execCmd.Stderr = &stderr
+		execCmd.Dir = buildWorkDir
+		execCmd.Env = env
 
-	startTime := time.Now()
-	err := execCmd.Run()
-	duration := time.Since(startTime)
+		job.appendLog(fmt.Sprintf("Command: %s\n", strings.Join(cmd, " ")))
 
-	job.appendLog(fmt.Sprintf("Build duration: %s\n", duration))
-	job.appendLog(fmt.Sprintf("STDOUT:\n%s\n", stdout.String()))
-	if stderr.Len() > 0 {
-		job.appendLog(fmt.Sprintf("STDERR:\n%s\n", stderr.String()))
-	}
+		startTime := time.Now()
+		err := execCmd.Run()
+		duration := time.Since(startTime)
 
-	if err != nil {
-		return fmt.Errorf("emerge failed: %w", err)
-	}
-
-	// Find and collect built packages
-	if err := be.collectArtifacts(pkg, buildWorkDir, job); err != nil {
-		return fmt.Errorf("failed to collect artifacts: %w", err)
-	}
-
-	return nil
+		job.appendLog(fmt.Sprintf("Build duration: %s\n", duration))
+		job.appendLog(fmt.Sprintf("STDOUT:\n%s\n", stdout.String()))
+		if stderr.Len() > 0 {
+			job.appendLog(fmt.Sprintf("STDERR:\n%s\n", stderr.String()))
+		}
+		if err != nil {
+			return fmt.Errorf("emerge failed: %w", err)
+		}
+		return nil
+	})
 }
 
-// constructEmergeCommand constructs the emerge command for a package.
-func (be *BuildExecutor) constructEmergeCommand(
-	pkg PackageSpec,
-	_ *ConfigBundle,
-	_ string,
-) []string {
+// emergeBaseCommand is emerge with the options every bundle build uses.
+func (be *BuildExecutor) emergeBaseCommand() []string {
 	cmd := []string{"emerge"}
 
 	// Add global options
+	cmd = append(cmd, "--ask=n")         // Never prompt (no TTY)
 	cmd = append(cmd, "--buildpkg")      // Build binary package
 	cmd = append(cmd, "--usepkg=n")      // Don't use existing binpkgs
 	cmd = append(cmd, "--oneshot")       // Don't add to world file
@@ -160,10 +179,22 @@ func (be *BuildExecutor) constructEmergeCommand(
 	cmd = append(cmd, "--quiet-build=n") // Show build output
 
 	// Add options to automatically resolve dependency conflicts
-	cmd = append(cmd, "--autounmask")          // Automatically unmask packages
-	cmd = append(cmd, "--autounmask-write")    // Write unmask changes to config
-	cmd = append(cmd, "--autounmask-continue") // Continue after writing changes
-	cmd = append(cmd, "--backtrack=50")        // Increase backtrack for complex deps
+	cmd = append(cmd, "--autounmask")           // Automatically unmask packages
+	cmd = append(cmd, "--autounmask-write")     // Write unmask changes to config
+	cmd = append(cmd, "--autounmask-license=n") // Licenses come only from ACCEPT_LICENSE
+	cmd = append(cmd, "--autounmask-continue")  // Continue after writing changes
+	cmd = append(cmd, "--backtrack=50")         // Increase backtrack for complex deps
+	cmd = append(cmd, strings.Fields(be.opts.EmergeJobs)...)
+	return cmd
+}
+
+// constructEmergeCommand constructs the emerge command for a package.
+func (be *BuildExecutor) constructEmergeCommand(
+	pkg PackageSpec,
+	_ *ConfigBundle,
+	_ string,
+) []string {
+	cmd := be.emergeBaseCommand()
 
 	// Add package-specific USE flags if provided
 	if len(pkg.UseFlags) > 0 {
@@ -177,12 +208,8 @@ func (be *BuildExecutor) constructEmergeCommand(
 		cmd = append(cmd, fmt.Sprintf("--accept-keywords=%s", keywords))
 	}
 
-	// Add the package atom
-	if pkg.Version != "" {
-		cmd = append(cmd, fmt.Sprintf("%s-%s", pkg.Atom, pkg.Version))
-	} else {
-		cmd = append(cmd, pkg.Atom)
-	}
+	// Add the package atom (or set; sets are never version-pinned)
+	cmd = append(cmd, emergeTarget(pkg.Atom, pkg.Version))
 
 	return cmd
 }
@@ -204,6 +231,9 @@ func (be *BuildExecutor) buildEnvironment(pkg PackageSpec, bundle *ConfigBundle,
 				}
 				continue
 			}
+			if key == "MAKEOPTS" {
+				continue
+			}
 			env = append(env, fmt.Sprintf("%s=%s", key, value))
 		}
 	}
@@ -211,6 +241,9 @@ func (be *BuildExecutor) buildEnvironment(pkg PackageSpec, bundle *ConfigBundle,
 		appendUserEnv(bundle.Config.Environment)
 	}
 	appendUserEnv(pkg.Environment)
+	if makeOpts := be.makeOpts(pkg, bundle); makeOpts != "" {
+		env = append(env, "MAKEOPTS="+makeOpts)
+	}
 
 	// Select the binary package format (gpkg by default; only gpkg is signable).
 	env = append(env, fmt.Sprintf("BINPKG_FORMAT=%s", be.opts.Format))
@@ -240,66 +273,16 @@ func (be *BuildExecutor) buildEnvironment(pkg PackageSpec, bundle *ConfigBundle,
 	return env
 }
 
-// nativePkgDir returns the PKGDIR for native builds (a host path under workDir).
-func (be *BuildExecutor) nativePkgDir() string {
-	return filepath.Join(be.workDir, "packages")
+// nativePkgDir returns the PKGDIR for a native build: a host path in its
+// workspace, so only the packages this build produced are collected.
+func (be *BuildExecutor) nativePkgDir(buildWorkDir string) string {
+	return filepath.Join(buildWorkDir, "packages")
 }
 
 // containerPkgDir is the in-container PKGDIR the Docker executor uses; it matches
 // the path artifacts are copied from after the build.
 const containerPkgDir = "/var/cache/binpkgs"
 
-// collectArtifacts collects built package artifacts.
-func (be *BuildExecutor) collectArtifacts(
-	pkg PackageSpec,
-	_ string,
-	job *BuildJob,
-) error {
-	// Find binary package in PKGDIR
-	pkgDir := be.nativePkgDir()
-
-	// The package structure is typically:
-	//   GPKG:  PKGDIR/category/package/package-version.gpkg.tar
-	//   XPAK:  PKGDIR/category/package-version.tbz2
-	var foundPackages []string
-
-	err := filepath.Walk(pkgDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		// Match modern GPKG (.gpkg.tar) and legacy XPAK (.tbz2) so artifacts are
-		// found regardless of the configured format.
-		if !info.IsDir() && (strings.HasSuffix(info.Name(), ".gpkg.tar") || strings.HasSuffix(info.Name(), ".tbz2")) {
-			// Check if this package matches the atom
-			if strings.Contains(path, strings.ReplaceAll(pkg.Atom, "/", string(os.PathSeparator))) {
-				foundPackages = append(foundPackages, path)
-			}
-		}
-		return nil
-	})
-
-	if err != nil {
-		return fmt.Errorf("failed to search for packages: %w", err)
-	}
-
-	if len(foundPackages) == 0 {
-		return fmt.Errorf("no binary packages found for %s", pkg.Atom)
-	}
-
-	// Copy artifacts to artifact directory
-	for _, pkgPath := range foundPackages {
-		destPath := filepath.Join(be.artifactDir, filepath.Base(pkgPath))
-		if err := be.copyFile(pkgPath, destPath); err != nil {
-			return fmt.Errorf("failed to copy artifact: %w", err)
-		}
-
-		job.setArtifactURL(destPath)
-		job.appendLog(fmt.Sprintf("Artifact collected: %s\n", destPath))
-	}
-
-	return nil
-}
-
 // copyFile copies a file from src to dst.
 func (be *BuildExecutor) copyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
@@ -355,10 +338,21 @@ func (dbe *DockerBuildExecutor) ExecuteBuild(
 	ctx context.Context,
 	bundle *ConfigBundle,
 	job *BuildJob,
+) error {
+	return dbe.executeBuildOn(ctx, bundle, job, crossTarget{})
+}
+
+// executeBuildOn executes a build inside a container of target's platform
+// and image, or of the executor's image for the zero target.
+func (dbe *DockerBuildExecutor) executeBuildOn(
+	ctx context.Context,
+	bundle *ConfigBundle,
+	job *BuildJob,
+	target crossTarget,
 ) error {
 	// Reject any bundle whose fields contain shell metacharacters or option
 	// injection before constructing any command.
-	if err := validateBundle(bundle); err != nil {
+	if err := ValidateBundle(bundle); err != nil {
 		return fmt.Errorf("invalid build request: %w", err)
 	}
 
@@ -372,6 +366,14 @@ func (dbe *DockerBuildExecutor) ExecuteBuild(
 		_ = os.RemoveAll(buildWorkDir)
 	}()
 
+	pkgs := bundle.Packages.Packages
+	bundle, spec := bundleBuildSpec(bundle, dbe.opts.MakeOpts)
+	dbe.recordMakeOpts(job, spec, bundle, pkgs)
+	bundle, overlays, err := withOverlayRepos(bundle, pkgs)
+	if err != nil {
+		return err
+	}
+
 	// Export configuration bundle
 	bundlePath := filepath.Join(buildWorkDir, "config-bundle.tar.gz")
 	if err := dbe.configTransfer.ExportBundle(bundle, bundlePath); err != nil {
@@ -394,9 +396,15 @@ func (dbe *DockerBuildExecutor) ExecuteBuild(
 		createArgs = append(createArgs,
 			"-v", fmt.Sprintf("%s:%s:ro", dbe.opts.SignHostGnupgHome, dbe.opts.SignGnupgHome+"-src"))
 	}
+	createArgs = dbe.containerRuntime.BuildRunArgs(createArgs)
+	image := dbe.dockerImage
+	if target.platform != "" {
+		createArgs = append(createArgs, "--platform", target.platform)
+		image = target.image
+	}
 	createArgs = append(createArgs,
 		"-w", "/workspace",
-		dbe.dockerImage,
+		image,
 		"/bin/bash", "-c", "sleep infinity",
 	)
 
@@ -417,7 +425,7 @@ func (dbe *DockerBuildExecutor) ExecuteBuild(
 	}()
 
 	// Extract configuration bundle inside container
-	_, err := dbe.containerRuntime.Exec(ctx, containerName, []string{
+	_, err = dbe.containerRuntime.Exec(ctx, containerName, []string{
 		"/bin/bash", "-c",
 		"mkdir -p /tmp/config && tar -xzf /workspace/config-bundle.tar.gz -C /tmp/config",
 	})
@@ -425,12 +433,19 @@ func (dbe *DockerBuildExecutor) ExecuteBuild(
 		return fmt.Errorf("failed to extract config bundle: %w", err)
 	}
 
-	// Apply configuration. The make.conf override fragment is appended to the
-	// real /etc/portage/make.conf (Portage does not source make.conf.d/), so it
-	// is not left as a stray copied file but appended explicitly.
+	// Apply configuration. The image's single-file package.* configs (e.g. a
+	// package.env file) become directories first, keeping their entries as
+	// 00-system, so the bundle's package.*/00-user files can be copied in. The
+	// make.conf override fragment is appended to the real
+	// /etc/portage/make.conf (Portage does not source make.conf.d/), so it is
+	// not left as a stray copied file but appended explicitly.
 	_, err = dbe.containerRuntime.Exec(ctx, containerName, []string{
 		"/bin/bash", "-c",
-		"cp -r /tmp/config/etc/portage/* /etc/portage/ 2>/dev/null; " +
+		"for d in /tmp/config/etc/portage/package.*; do " +
+			"t=/etc/portage/${d##*/}; " +
+			"if [ -d \"$d\" ] && [ -f \"$t\" ]; then mv \"$t\" \"$t.pe\" && mkdir \"$t\" && mv \"$t.pe\" \"$t/00-system\"; fi; " +
+			"done; " +
+			"cp -r /tmp/config/etc/portage/* /etc/portage/ 2>/dev/null; " +
 			"rm -f /etc/portage/make.conf.portage-engine; " +
 			"if [ -f /tmp/config/" + makeConfFragmentPath + " ]; then " +
 			"cat /tmp/config/" + makeConfFragmentPath + " >> /etc/portage/make.conf; fi",
@@ -439,6 +454,16 @@ func (dbe *DockerBuildExecutor) ExecuteBuild(
 		return fmt.Errorf("failed to apply configuration: %w", err)
 	}
 
+	// Clone the requested overlays, now that repos.conf names them.
+	for _, name := range overlays {
+		cmd := overlaySyncCommand(name)
+		job.appendLog(fmt.Sprintf("Syncing overlay: %s\n", strings.Join(cmd, " ")))
+		if output, err := dbe.containerRuntime.Exec(ctx, containerName, cmd); err != nil {
+			job.appendLog(fmt.Sprintf("Output:\n%s\n", string(output)))
+			return fmt.Errorf("failed to sync overlay %s: %w", name, err)
+		}
+	}
+
 	// Prepare a writable GNUPGHOME for binpkg-signing: GnuPG requires a 0700,
 	// writable home, but the host keyring is mounted read-only.
 	if dbe.opts.signingEnabled() && dbe.opts.SignHostGnupgHome != "" {
@@ -454,20 +479,28 @@ func (dbe *DockerBuildExecutor) ExecuteBuild(
 		}
 	}
 
-	// Build packages
-	for _, pkg := range bundle.Packages.Packages {
-		if err := dbe.buildPackageInDocker(ctx, pkg, bundle, containerName, job); err != nil {
-			return fmt.Errorf("failed to build package %s: %w", pkg.Atom, err)
-		}
+	// Build every package in one emerge, then copy the container's PKGDIR
+	// out to collect everything it produced.
+	err = dbe.buildPackagesInDocker(ctx, spec, bundle, containerName, job)
+	if err != nil {
+		err = fmt.Errorf("failed to build %s: %w", bundleAtoms(pkgs), err)
 	}
-
-	return nil
+	if job.pretending() {
+		return pretendResult(err)
+	}
+	pkgDir := filepath.Join(buildWorkDir, "packages")
+	src := fmt.Sprintf("%s:%s/.", containerName, containerPkgDir)
+	if copyErr := dbe.containerRuntime.Copy(ctx, src, pkgDir); copyErr != nil {
+		job.appendLog(fmt.Sprintf("Warning: Failed to copy artifacts: %v\n", copyErr))
+	}
+	return dbe.collectBundleArtifacts(job, pkgs, pkgDir, err)
 }
 
-// buildPackageInDocker builds a package inside container.
-func (dbe *DockerBuildExecutor) buildPackageInDocker(
+// buildPackagesInDocker emerges a bundle's packages inside the container,
+// with spec's environment.
+func (dbe *DockerBuildExecutor) buildPackagesInDocker(
 	ctx context.Context,
-	pkg PackageSpec,
+	spec PackageSpec,
 	bundle *ConfigBundle,
 	containerName string,
 	job *BuildJob,
@@ -475,37 +508,40 @@ func (dbe *DockerBuildExecutor) buildPackageInDocker(
 	// Construct emerge command as an argv slice. The container runtime passes
 	// it directly to `docker exec` (no shell), so none of the atom/USE/keyword
 	// values can be interpreted as shell metacharacters.
-	emergeCmd := dbe.constructEmergeCommand(pkg, bundle, "")
+	emergeCmd := dbe.bundleEmergeCommand(bundle)
+	opts := dbe.opts
+	if job.pretending() {
+		emergeCmd, opts.SeparateFetch = pretendCommand(emergeCmd), false
+	}
 
 	// Environment variables are passed via `docker exec -e KEY=VALUE`, again
 	// avoiding any shell interpretation of the values.
-	envVars := dbe.buildEnvironment(pkg, bundle, containerPkgDir)
-
-	job.appendLog(fmt.Sprintf("Building package in container: %s\n", pkg.Atom))
-	job.appendLog(fmt.Sprintf("Command: %s\n", strings.Join(emergeCmd, " ")))
-
-	startTime := time.Now()
-	output, err := dbe.containerRuntime.ExecEnv(ctx, containerName, envVars, emergeCmd)
-	duration := time.Since(startTime)
+	envVars := dbe.buildEnvironment(spec, bundle, containerPkgDir)
 
-	job.appendLog(fmt.Sprintf("Build duration: %s\n", duration))
-	job.appendLog(fmt.Sprintf("Output:\n%s\n", string(output)))
+	job.appendLog(fmt.Sprintf("Building packages in container: %s\n", bundleAtoms(bundle.Packages.Packages)))
 
-	if err != nil {
-		return fmt.Errorf("emerge failed in container: %w", err)
-	}
+	return opts.runPhases(job, emergeCmd, func(cmd []string) error {
+		job.appendLog(fmt.Sprintf("Command: %s\n", strings.Join(cmd, " ")))
 
-	// Copy artifacts from the container's PKGDIR (matches the PKGDIR env above).
-	src := fmt.Sprintf("%s:%s/.", containerName, containerPkgDir)
-	if err := dbe.containerRuntime.Copy(ctx, src, dbe.artifactDir); err != nil {
-		job.appendLog(fmt.Sprintf("Warning: Failed to copy artifacts: %v\n", err))
-	}
+		startTime := time.Now()
+		output, err := dbe.containerRuntime.ExecEnv(ctx, containerName, envVars, cmd)
+		duration := time.Since(startTime)
 
-	return nil
+		job.appendLog(fmt.Sprintf("Build duration: %s\n", duration))
+		job.appendLog(fmt.Sprintf("Output:\n%s\n", string(output)))
+		if err != nil {
+			return fmt.Errorf("emerge failed in container: %w", err)
+		}
+		return nil
+	})
 }
 
 // cleanupContainer stops and removes a container.
 func (dbe *DockerBuildExecutor) cleanupContainer(ctx context.Context, containerName string) error {
+	// Clean up even when the build was cancelled or timed out.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), containerStopTimeout)
+	defer cancel()
+
 	// Stop container
 	_ = dbe.containerRuntime.Stop(ctx, containerName)
 
@@ -0,0 +1,147 @@
+// Package builder provides the server-side FEATURES denylist.
+package builder
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+// featuresKey is the make.conf / environment variable the denylist polices.
+const featuresKey = "FEATURES"
+
+// FeaturesPolicy strips denied Portage FEATURES tokens (e.g. "-sandbox",
+// "unprivileged") from client-supplied build configuration. Operator settings
+// such as the builder's BUILD_FEATURES are trusted and never filtered.
+type FeaturesPolicy struct {
+	denied map[string]bool
+}
+
+// NewFeaturesPolicy returns a policy denying the given FEATURES tokens. An
+// empty list yields a policy that strips nothing.
+func NewFeaturesPolicy(denied []string) *FeaturesPolicy {
+	p := &FeaturesPolicy{denied: make(map[string]bool, len(denied))}
+	for _, token := range denied {
+		if token = strings.TrimSpace(token); token != "" {
+			p.denied[token] = true
+		}
+	}
+	return p
+}
+
+// deniesToken reports whether a single FEATURES token is denied. "-*" clears
+// every feature, including the sandboxes, so it is denied whenever any
+// negated feature is.
+func (p *FeaturesPolicy) deniesToken(token string) bool {
+	if p.denied[token] {
+		return true
+	}
+	if token == "-*" {
+		for d := range p.denied {
+			if strings.HasPrefix(d, "-") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filter removes denied tokens from a FEATURES value, returning the kept
+// value and the tokens removed.
+func (p *FeaturesPolicy) filter(value string) (string, []string) {
+	var kept, stripped []string
+	for _, token := range strings.Fields(value) {
+		if p.deniesToken(token) {
+			stripped = append(stripped, token)
+		} else {
+			kept = append(kept, token)
+		}
+	}
+	return strings.Join(kept, " "), stripped
+}
+
+// filterMap collects the denied tokens in the FEATURES entry of a make.conf
+// or environment map into found, removing them in place when strip is set.
+func (p *FeaturesPolicy) filterMap(m map[string]string, strip bool, found map[string]bool) {
+	value, ok := m[featuresKey]
+	if !ok {
+		return
+	}
+	kept, removed := p.filter(value)
+	if len(removed) == 0 {
+		return
+	}
+	if strip {
+		m[featuresKey] = kept
+	}
+	for _, token := range removed {
+		found[token] = true
+	}
+}
+
+// Apply strips denied FEATURES from every source a client can set on req:
+// the bundle's make.conf and environment, and each package's environment. It
+// modifies req in place and returns the sorted, de-duplicated tokens removed.
+func (p *FeaturesPolicy) Apply(req *BuildRequest) []string {
+	return p.scan(req, true)
+}
+
+// Denied returns the denied FEATURES req carries without modifying it.
+func (p *FeaturesPolicy) Denied(req *BuildRequest) []string {
+	return p.scan(req, false)
+}
+
+// scan collects the denied FEATURES in req, stripping them when strip is set.
+func (p *FeaturesPolicy) scan(req *BuildRequest, strip bool) []string {
+	if p == nil || len(p.denied) == 0 || req == nil || req.ConfigBundle == nil {
+		return nil
+	}
+	stripped := map[string]bool{}
+	bundle := req.ConfigBundle
+	if bundle.Config != nil {
+		p.filterMap(bundle.Config.MakeConf, strip, stripped)
+		p.filterMap(bundle.Config.Environment, strip, stripped)
+	}
+	if bundle.Packages != nil {
+		for _, pkg := range bundle.Packages.Packages {
+			p.filterMap(pkg.Environment, strip, stripped)
+		}
+	}
+	if len(stripped) == 0 {
+		return nil
+	}
+	tokens := make([]string, 0, len(stripped))
+	for token := range stripped {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// featuresPolicy returns the policy configured by FEATURES_DENYLIST.
+func (m *Manager) featuresPolicy() *FeaturesPolicy {
+	if m.config == nil {
+		return nil
+	}
+	return NewFeaturesPolicy(m.config.FeaturesDenylist)
+}
+
+// enforceFeaturesPolicy applies the FEATURES denylist to req and logs the
+// outcome for job jobID. Requests escalated by an admin keep their FEATURES;
+// the override is logged so it can be audited.
+func (m *Manager) enforceFeaturesPolicy(jobID string, req *BuildRequest) {
+	policy := m.featuresPolicy()
+	if req.AllowDeniedFeatures {
+		if denied := policy.Denied(req); len(denied) > 0 {
+			log.Printf("Job %s: admin override keeps denied FEATURES %s", jobID, strings.Join(denied, " "))
+			m.appendJobLog(jobID, "[policy] admin override: keeping denied FEATURES "+strings.Join(denied, " "))
+		}
+		return
+	}
+	stripped := append(req.strippedFeatures, policy.Apply(req)...)
+	if len(stripped) == 0 {
+		return
+	}
+	log.Printf("Job %s: stripped denied FEATURES %s", jobID, strings.Join(stripped, " "))
+	m.appendJobLog(jobID, "[policy] stripped denied FEATURES "+strings.Join(stripped, " "))
+}
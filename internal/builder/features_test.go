@@ -0,0 +1,111 @@
+package builder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func featuresBundle() *ConfigBundle {
+	return &ConfigBundle{
+		Config: &PortageConfig{
+			MakeConf:    map[string]string{"FEATURES": "-sandbox parallel-fetch", "MAKEOPTS": "-j4"},
+			Environment: map[string]string{"FEATURES": "-*"},
+		},
+		Packages: &BuildPackageSpec{Packages: []PackageSpec{
+			{Atom: "app-misc/hello", Environment: map[string]string{"FEATURES": "unprivileged test"}},
+		}},
+		Metadata: BundleMetadata{TargetArch: "amd64"},
+	}
+}
+
+func TestFeaturesPolicyApply(t *testing.T) {
+	policy := NewFeaturesPolicy([]string{"-sandbox", "-usersandbox", "unprivileged", "-strict"})
+	req := &BuildRequest{PackageName: "app-misc/hello", ConfigBundle: featuresBundle()}
+
+	if got := policy.Denied(req); !reflect.DeepEqual(got, []string{"-*", "-sandbox", "unprivileged"}) {
+		t.Errorf("Denied() = %v", got)
+	}
+	if req.ConfigBundle.Config.MakeConf["FEATURES"] != "-sandbox parallel-fetch" {
+		t.Error("Denied() must not modify the request")
+	}
+
+	stripped := policy.Apply(req)
+	if !reflect.DeepEqual(stripped, []string{"-*", "-sandbox", "unprivileged"}) {
+		t.Errorf("Apply() = %v", stripped)
+	}
+	cfg := req.ConfigBundle.Config
+	if cfg.MakeConf["FEATURES"] != "parallel-fetch" || cfg.MakeConf["MAKEOPTS"] != "-j4" {
+		t.Errorf("make.conf = %v", cfg.MakeConf)
+	}
+	if cfg.Environment["FEATURES"] != "" {
+		t.Errorf("environment FEATURES = %q, want empty", cfg.Environment["FEATURES"])
+	}
+	if env := req.ConfigBundle.Packages.Packages[0].Environment; env["FEATURES"] != "test" {
+		t.Errorf("package FEATURES = %q, want test", env["FEATURES"])
+	}
+	if again := policy.Apply(req); again != nil {
+		t.Errorf("second Apply() = %v, want nothing left to strip", again)
+	}
+}
+
+func TestFeaturesPolicyEmpty(t *testing.T) {
+	req := &BuildRequest{ConfigBundle: featuresBundle()}
+	if got := NewFeaturesPolicy(nil).Apply(req); got != nil {
+		t.Errorf("Apply() = %v, want nil for an empty denylist", got)
+	}
+	// "-*" is only denied alongside a negated feature.
+	if got := NewFeaturesPolicy([]string{"unprivileged"}).Apply(req); !reflect.DeepEqual(got, []string{"unprivileged"}) {
+		t.Errorf("Apply() = %v, want [unprivileged]", got)
+	}
+}
+
+func TestSubmitBuildEnforcesFeaturesDenylist(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0, FeaturesDenylist: []string{"-sandbox", "unprivileged"}})
+	defer mgr.Shutdown()
+
+	req := &BuildRequest{PackageName: "app-misc/hello", Arch: "amd64", ConfigBundle: featuresBundle()}
+	jobID, err := mgr.SubmitBuild(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.ConfigBundle.Config.MakeConf["FEATURES"]; got != "parallel-fetch" {
+		t.Errorf("make.conf FEATURES = %q, want denied features stripped", got)
+	}
+	status, _ := mgr.GetStatus(jobID)
+	if !strings.Contains(status.Log, "stripped denied FEATURES -* -sandbox unprivileged") {
+		t.Errorf("job log = %q, want the stripped features", status.Log)
+	}
+
+	admin := &BuildRequest{PackageName: "app-misc/hello", Arch: "amd64", ConfigBundle: featuresBundle(), AllowDeniedFeatures: true}
+	jobID, err = mgr.SubmitBuild(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := admin.ConfigBundle.Config.MakeConf["FEATURES"]; got != "-sandbox parallel-fetch" {
+		t.Errorf("admin make.conf FEATURES = %q, want it kept", got)
+	}
+	status, _ = mgr.GetStatus(jobID)
+	if !strings.Contains(status.Log, "admin override") {
+		t.Errorf("job log = %q, want the override recorded", status.Log)
+	}
+}
+
+func TestSubmitMultiArchBuildLogsStrippedFeatures(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0, FeaturesDenylist: []string{"-sandbox"}})
+	defer mgr.Shutdown()
+
+	req := &BuildRequest{PackageName: "app-misc/hello", ConfigBundle: featuresBundle()}
+	_, jobs, err := mgr.SubmitMultiArchBuild(req, []string{"amd64", "arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for arch, jobID := range jobs {
+		status, _ := mgr.GetStatus(jobID)
+		if !strings.Contains(status.Log, "stripped denied FEATURES -* -sandbox") {
+			t.Errorf("%s job log = %q, want the stripped features", arch, status.Log)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package builder
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +11,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/slchris/portage-engine/internal/gpg"
 )
 
 // GPGKeyClient handles fetching and importing GPG keys from server.
@@ -35,7 +38,12 @@ func (g *GPGKeyClient) WithGnupgHome(home string) *GPGKeyClient {
 	return g
 }
 
-// FetchGPGKey downloads the GPG public key from server.
+// maxKeySize bounds a downloaded public key; real keys are a few KiB.
+const maxKeySize = 1 << 20
+
+// FetchGPGKey downloads the GPG public key from server and stores it armored
+// at destPath. The server may send the key armored or binary; anything that
+// is not an OpenPGP key (e.g. a proxy error page) is rejected.
 func (g *GPGKeyClient) FetchGPGKey(destPath string) error {
 	url := g.serverURL + "/api/v1/gpg/public-key"
 
@@ -49,6 +57,15 @@ func (g *GPGKeyClient) FetchGPGKey(destPath string) error {
 		return fmt.Errorf("server returned status %d", resp.StatusCode)
 	}
 
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKeySize))
+	if err != nil {
+		return fmt.Errorf("failed to read key: %w", err)
+	}
+	key, err := gpg.ConvertKey(data, gpg.FormatArmored)
+	if err != nil {
+		return fmt.Errorf("server returned an unusable key: %w", err)
+	}
+
 	// Ensure directory exists
 	dir := filepath.Dir(destPath)
 	if err := os.MkdirAll(dir, 0750); err != nil {
@@ -64,7 +81,7 @@ func (g *GPGKeyClient) FetchGPGKey(destPath string) error {
 	defer func() { _ = f.Close() }()
 
 	// Write key to file
-	if _, err := io.Copy(f, resp.Body); err != nil {
+	if _, err := f.Write(key); err != nil {
 		_ = os.Remove(tmpFile)
 		return fmt.Errorf("failed to write key: %w", err)
 	}
@@ -78,7 +95,8 @@ func (g *GPGKeyClient) FetchGPGKey(destPath string) error {
 	return nil
 }
 
-// ImportGPGKey imports a GPG public key into the keyring.
+// ImportGPGKey imports a GPG public key (armored .asc or binary .gpg) into
+// the keyring.
 func (g *GPGKeyClient) ImportGPGKey(keyPath string) error {
 	args := []string{"--batch", "--yes", "--import", keyPath}
 	if g.gnupgHome != "" {
@@ -96,7 +114,9 @@ func (g *GPGKeyClient) ImportGPGKey(keyPath string) error {
 	return nil
 }
 
-// FetchAndImportGPGKey fetches the GPG key from server and imports it.
+// FetchAndImportGPGKey fetches the GPG key from server and imports it, then
+// trusts the server's keys along their succession chain (see
+// TrustKeySuccession), so a rotated key is picked up without intervention.
 func (g *GPGKeyClient) FetchAndImportGPGKey(destPath string) error {
 	if err := g.FetchGPGKey(destPath); err != nil {
 		return fmt.Errorf("failed to fetch GPG key: %w", err)
@@ -106,9 +126,128 @@ func (g *GPGKeyClient) FetchAndImportGPGKey(destPath string) error {
 		return fmt.Errorf("failed to import GPG key: %w", err)
 	}
 
+	if err := g.TrustKeySuccession(filepath.Dir(destPath)); err != nil {
+		return fmt.Errorf("failed to trust server GPG keys: %w", err)
+	}
+
+	return nil
+}
+
+// fetchKeyHistory fetches the server's signing key history, oldest first. A
+// server without key rotation has none.
+func (g *GPGKeyClient) fetchKeyHistory() ([]gpg.KeyRecord, error) {
+	resp, err := g.httpClient.Get(g.serverURL + "/api/v1/keys/history")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key history: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key history: server returned status %d", resp.StatusCode)
+	}
+	var history struct {
+		Keys []gpg.KeyRecord `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16*maxKeySize)).Decode(&history); err != nil {
+		return nil, fmt.Errorf("invalid key history: %w", err)
+	}
+	return history.Keys, nil
+}
+
+// TrustKeySuccession trusts the server's signing keys in history order. A
+// key is trusted when its succession certificate verifies against the
+// already-trusted key it replaced; when the builder trusts none of the
+// server's keys yet, the oldest is trusted on first use. A key whose chain
+// is broken is not trusted and reported. dir holds the imported key files.
+func (g *GPGKeyClient) TrustKeySuccession(dir string) error {
+	keys, err := g.fetchKeyHistory()
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	trusted, err := g.trustedFingerprints()
+	if err != nil {
+		return err
+	}
+	anchored := false
+	for _, k := range keys {
+		anchored = anchored || trusted[strings.ToUpper(k.KeyID)]
+	}
+
+	for i, k := range keys {
+		fpr := strings.ToUpper(k.KeyID)
+		if trusted[fpr] {
+			continue
+		}
+		keyPath := filepath.Join(dir, "server-key-"+fpr+".asc")
+		if err := os.WriteFile(keyPath, []byte(k.PublicKey), 0600); err != nil {
+			return fmt.Errorf("failed to write key %s: %w", fpr, err)
+		}
+		switch {
+		case i == 0 && !anchored:
+			// Trust on first use.
+		case trusted[strings.ToUpper(k.PreviousKeyID)]:
+			if err := g.verifySuccession(k, keyPath); err != nil {
+				return fmt.Errorf("key %s: %w", fpr, err)
+			}
+		default:
+			return fmt.Errorf("key %s does not succeed a trusted key", fpr)
+		}
+		if err := g.ImportGPGKey(keyPath); err != nil {
+			return err
+		}
+		if err := g.TrustKey(fpr); err != nil {
+			return err
+		}
+		trusted[fpr] = true
+	}
+	return nil
+}
+
+// verifySuccession checks k's succession certificate: a signature by its
+// previous key over its public key, as written to keyPath.
+func (g *GPGKeyClient) verifySuccession(k gpg.KeyRecord, keyPath string) error {
+	sigPath := keyPath + gpg.SignatureFileExt
+	if err := os.WriteFile(sigPath, []byte(k.Succession), 0600); err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(sigPath) }()
+	verifier := gpg.NewSigner(k.PreviousKeyID, "", true, gpg.WithGnupgHome(g.gnupgHome))
+	if r := verifier.VerifyDetached(keyPath, sigPath); !r.Valid {
+		return fmt.Errorf("invalid succession certificate: %s", r.Error)
+	}
 	return nil
 }
 
+// trustedFingerprints returns the fingerprints of the ultimately trusted keys
+// in the keyring.
+func (g *GPGKeyClient) trustedFingerprints() (map[string]bool, error) {
+	args := []string{"--batch", "--with-colons", "--list-keys"}
+	if g.gnupgHome != "" {
+		args = append([]string{"--homedir", g.gnupgHome}, args...)
+	}
+	out, err := exec.Command("gpg", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+	// A pub line's ninth field is the owner trust ("u" for ultimate); the
+	// key's fingerprint follows on the next fpr line.
+	trusted := map[string]bool{}
+	ultimate := false
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "pub":
+			ultimate = len(fields) > 8 && fields[8] == "u"
+		case fields[0] == "fpr" && ultimate && len(fields) > 9:
+			trusted[strings.ToUpper(fields[9])] = true
+			ultimate = false
+		}
+	}
+	return trusted, nil
+}
+
 // GetKeyID extracts the key ID from an imported key file.
 func (g *GPGKeyClient) GetKeyID(keyPath string) (string, error) {
 	args := []string{"--batch", "--with-colons", "--import-options", "show-only", "--import", keyPath}
@@ -139,15 +278,30 @@ func (g *GPGKeyClient) GetKeyID(keyPath string) (string, error) {
 
 // TrustKey sets ultimate trust for a key ID.
 func (g *GPGKeyClient) TrustKey(keyID string) error {
-	args := []string{"--batch", "--yes"}
+	var homeArgs []string
 	if g.gnupgHome != "" {
-		args = append([]string{"--homedir", g.gnupgHome}, args...)
+		homeArgs = []string{"--homedir", g.gnupgHome}
 	}
-	args = append(args, "--edit-key", keyID, "trust", "quit")
 
-	// Create input for trust level (5 = ultimate)
-	cmd := exec.Command("gpg", args...)
-	cmd.Stdin = strings.NewReader("5\ny\n")
+	// Owner trust is keyed by fingerprint, and unlike --edit-key trust,
+	// --import-ownertrust works in batch mode.
+	out, err := exec.Command("gpg", append(homeArgs, "--batch", "--with-colons", "--fingerprint", keyID)...).Output()
+	if err != nil {
+		return fmt.Errorf("failed to trust key: %s not found: %w", keyID, err)
+	}
+	fpr := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Split(line, ":"); len(fields) > 9 && fields[0] == "fpr" && fpr == "" {
+			fpr = fields[9]
+		}
+	}
+	if fpr == "" {
+		return fmt.Errorf("failed to trust key: no fingerprint for %s", keyID)
+	}
+
+	// 6 is ultimate trust.
+	cmd := exec.Command("gpg", append(homeArgs, "--batch", "--import-ownertrust")...)
+	cmd.Stdin = strings.NewReader(fpr + ":6:\n")
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -1,11 +1,17 @@
 package builder
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/slchris/portage-engine/internal/gpg"
 )
 
 func TestNewGPGKeyClient(t *testing.T) {
@@ -34,6 +40,12 @@ func TestFetchGPGKey(t *testing.T) {
 			serverResponse: "-----BEGIN PGP PUBLIC KEY BLOCK-----\ntest key\n-----END PGP PUBLIC KEY BLOCK-----\n",
 			expectError:    false,
 		},
+		{
+			name:           "not a key",
+			serverStatus:   http.StatusOK,
+			serverResponse: "<html>502 Bad Gateway</html>",
+			expectError:    true,
+		},
 		{
 			name:         "server error",
 			serverStatus: http.StatusInternalServerError,
@@ -93,6 +105,31 @@ func TestFetchGPGKey(t *testing.T) {
 	}
 }
 
+func TestFetchGPGKeyBinary(t *testing.T) {
+	binaryKey := []byte{0x99, 0x01, 0x0d, 0x04, 0x65, 0x00, 0x00, 0x00}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(binaryKey)
+	}))
+	defer server.Close()
+
+	destPath := filepath.Join(t.TempDir(), "gpg-key.asc")
+	if err := NewGPGKeyClient(server.URL).FetchGPGKey(destPath); err != nil {
+		t.Fatalf("FetchGPGKey() error = %v", err)
+	}
+
+	// A binary key is stored armored, matching the .asc path.
+	content, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("failed to read key file: %v", err)
+	}
+	if !gpg.IsArmored(content) {
+		t.Fatalf("stored key is not armored: %q", content)
+	}
+	if decoded, err := gpg.Dearmor(content); err != nil || !bytes.Equal(decoded, binaryKey) {
+		t.Errorf("Dearmor(stored) = %x, %v; want %x", decoded, err, binaryKey)
+	}
+}
+
 func TestFetchGPGKeyServerDown(t *testing.T) {
 	client := NewGPGKeyClient("http://localhost:9999")
 
@@ -108,7 +145,7 @@ func TestFetchGPGKeyServerDown(t *testing.T) {
 func TestFetchGPGKeyCreateDirectory(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("test key"))
+		_, _ = w.Write([]byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\ntest key\n-----END PGP PUBLIC KEY BLOCK-----\n"))
 	}))
 	defer server.Close()
 
@@ -165,3 +202,96 @@ func TestFetchAndImportGPGKeyServerDown(t *testing.T) {
 		t.Error("expected error when server is down")
 	}
 }
+
+// gpgTestHome returns a fresh GNUPGHOME and a runner for gpg in it, skipping
+// the test when gpg is not installed.
+func gpgTestHome(t *testing.T) (string, func(stdin string, args ...string) string) {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	// Not t.TempDir(): gpg-agent's socket path must stay short.
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+		_ = os.RemoveAll(home)
+	})
+	return home, func(stdin string, args ...string) string {
+		cmd := exec.Command("gpg", append([]string{"--batch", "--yes", "--homedir", home}, args...)...)
+		cmd.Stdin = strings.NewReader(stdin)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("gpg %v: %v", args, err)
+		}
+		return string(out)
+	}
+}
+
+// TestTrustKeySuccession verifies a builder trusts the server's first key on
+// first use and each later key only through a valid succession certificate.
+func TestTrustKeySuccession(t *testing.T) {
+	_, serverGPG := gpgTestHome(t)
+	fingerprint := func(email string) string {
+		for _, line := range strings.Split(serverGPG("", "--with-colons", "--fingerprint", email), "\n") {
+			if fields := strings.Split(line, ":"); fields[0] == "fpr" {
+				return fields[9]
+			}
+		}
+		t.Fatalf("no fingerprint for %s", email)
+		return ""
+	}
+	var keys []gpg.KeyRecord
+	for _, email := range []string{"first@example.com", "second@example.com", "rogue@example.com"} {
+		serverGPG("", "--passphrase", "", "--quick-gen-key", "Server <"+email+">", "ed25519", "sign", "never")
+		keys = append(keys, gpg.KeyRecord{KeyID: fingerprint(email)})
+	}
+	// second succeeds first; rogue claims to succeed second but signs its
+	// own certificate.
+	keys[1].PreviousKeyID = keys[0].KeyID
+	keys[2].PreviousKeyID = keys[1].KeyID
+	serverGPG("", "--local-user", keys[0].KeyID, "--quick-sign-key", keys[1].KeyID)
+	for i := range keys {
+		keys[i].PublicKey = serverGPG("", "--armor", "--export", keys[i].KeyID)
+	}
+	keys[1].Succession = serverGPG(keys[1].PublicKey, "--local-user", keys[0].KeyID, "--armor", "--detach-sign")
+	keys[2].Succession = serverGPG(keys[2].PublicKey, "--local-user", keys[2].KeyID, "--armor", "--detach-sign")
+
+	history := keys[:2]
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/keys/history" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": history})
+	}))
+	defer server.Close()
+
+	builderHome, _ := gpgTestHome(t)
+	client := NewGPGKeyClient(server.URL).WithGnupgHome(builderHome)
+	if err := client.TrustKeySuccession(t.TempDir()); err != nil {
+		t.Fatalf("TrustKeySuccession() error = %v", err)
+	}
+	trusted, err := client.trustedFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trusted[keys[0].KeyID] || !trusted[keys[1].KeyID] {
+		t.Errorf("trusted = %v, want both server keys", trusted)
+	}
+
+	history = keys
+	if err := client.TrustKeySuccession(t.TempDir()); err == nil {
+		t.Error("a key with a self-signed succession certificate must not be trusted")
+	}
+	if trusted, _ := client.trustedFingerprints(); trusted[keys[2].KeyID] {
+		t.Error("rogue key was trusted")
+	}
+
+	// A server without key rotation has no history.
+	if err := NewGPGKeyClient(server.URL + "/none").WithGnupgHome(builderHome).TrustKeySuccession(t.TempDir()); err != nil {
+		t.Errorf("no history: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Package builder provides the readiness checks behind the builder's /health.
+package builder
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// healthCheckTimeout bounds how long CheckHealth waits for the container
+// runtime to answer.
+const healthCheckTimeout = 5 * time.Second
+
+// HealthCheck is the outcome of one readiness check.
+type HealthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthReport is the outcome of every readiness check; Healthy is false
+// when any of them failed.
+type HealthReport struct {
+	Healthy bool          `json:"-"`
+	Status  string        `json:"status"`
+	Checks  []HealthCheck `json:"checks"`
+	Failed  []string      `json:"failed,omitempty"`
+}
+
+// SetSignerError records that GPG signing was requested but could not be set
+// up, because err, so the health check reports the builder unready instead
+// of producing unsigned packages unnoticed.
+func (lb *LocalBuilder) SetSignerError(err error) {
+	lb.signerErr = err
+}
+
+// CheckHealth checks that the builder can actually build: its work and
+// artifact directories are writable, the container runtime answers when
+// builds run in containers, the signing key is usable when GPG is enabled,
+// and it is not draining.
+func (lb *LocalBuilder) CheckHealth(ctx context.Context) HealthReport {
+	checks := []HealthCheck{
+		newHealthCheck("work_dir", verifyDirectoryWritable(lb.workDir)),
+		newHealthCheck("artifact_dir", verifyDirectoryWritable(lb.artifactDir)),
+	}
+	if lb.useDocker && lb.containerRuntime != nil {
+		pingCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := lb.containerRuntime.Ping(pingCtx)
+		cancel()
+		checks = append(checks, newHealthCheck("container_runtime", err))
+	}
+	if lb.signerErr != nil || (lb.signer != nil && lb.signer.IsEnabled()) {
+		checks = append(checks, newHealthCheck("signer", lb.checkSigner()))
+	}
+	var draining error
+	if lb.Draining() {
+		draining = ErrShuttingDown
+	}
+	checks = append(checks, newHealthCheck("accepting_builds", draining))
+
+	report := HealthReport{Healthy: true, Status: "ok", Checks: checks}
+	for _, c := range checks {
+		if !c.OK {
+			report.Healthy = false
+			report.Failed = append(report.Failed, c.Name)
+		}
+	}
+	if !report.Healthy {
+		report.Status = "unhealthy"
+	}
+	return report
+}
+
+// checkSigner checks the signing key can be used.
+func (lb *LocalBuilder) checkSigner() error {
+	if lb.signerErr != nil {
+		return fmt.Errorf("GPG signing could not be set up: %w", lb.signerErr)
+	}
+	if lb.signer.KeyID() == "" {
+		return fmt.Errorf("GPG enabled but no signing key")
+	}
+	expires, ok := lb.signingKeyExpiry()
+	if !ok {
+		return fmt.Errorf("GPG key %s cannot be read", lb.signer.KeyID())
+	}
+	if !expires.IsZero() && time.Now().After(expires) {
+		return fmt.Errorf("GPG key %s expired on %s", lb.signer.KeyID(), expires.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// newHealthCheck returns the check name, failed when err is not nil.
+func newHealthCheck(name string, err error) HealthCheck {
+	if err != nil {
+		return HealthCheck{Name: name, Error: err.Error()}
+	}
+	return HealthCheck{Name: name, OK: true}
+}
@@ -0,0 +1,56 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// pingErrRuntime is a container runtime whose Ping returns err.
+type pingErrRuntime struct {
+	ContainerRuntime
+	err error
+}
+
+func (r pingErrRuntime) Ping(context.Context) error { return r.err }
+
+func TestCheckHealth(t *testing.T) {
+	healthy := func() *LocalBuilder {
+		return &LocalBuilder{
+			workDir:          t.TempDir(),
+			artifactDir:      t.TempDir(),
+			useDocker:        true,
+			containerRuntime: pingErrRuntime{},
+		}
+	}
+	tests := []struct {
+		name   string
+		mutate func(lb *LocalBuilder)
+		failed []string
+	}{
+		{"healthy", func(*LocalBuilder) {}, nil},
+		{"artifact dir missing", func(lb *LocalBuilder) { lb.artifactDir = filepath.Join(lb.artifactDir, "missing") }, []string{"artifact_dir"}},
+		{"runtime down", func(lb *LocalBuilder) {
+			lb.containerRuntime = pingErrRuntime{err: errors.New("Cannot connect to the Docker daemon")}
+		}, []string{"container_runtime"}},
+		{"signer failed", func(lb *LocalBuilder) { lb.SetSignerError(errors.New("gpg not found")) }, []string{"signer"}},
+		{"draining", func(lb *LocalBuilder) { lb.draining.Store(true) }, []string{"accepting_builds"}},
+	}
+	for _, tt := range tests {
+		lb := healthy()
+		tt.mutate(lb)
+		report := lb.CheckHealth(context.Background())
+		if !reflect.DeepEqual(report.Failed, tt.failed) || report.Healthy != (tt.failed == nil) {
+			t.Errorf("%s: CheckHealth() = %+v, want failed %v", tt.name, report, tt.failed)
+		}
+	}
+
+	lb := healthy()
+	lb.useDocker = false
+	lb.containerRuntime = pingErrRuntime{err: errors.New("not running")}
+	if report := lb.CheckHealth(context.Background()); !report.Healthy {
+		t.Errorf("native builder checked the container runtime: %+v", report)
+	}
+}
@@ -0,0 +1,237 @@
+// Package builder provides the priority queue of jobs waiting for a worker.
+package builder
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errQueueFull is returned when a job is submitted to a full queue.
+var errQueueFull = errors.New("builder queue full")
+
+// QueueEntry describes a queued job, as listed by the queue endpoint.
+type QueueEntry struct {
+	JobID    string    `json:"job_id"`
+	Atom     string    `json:"atom"`
+	Priority int       `json:"priority"`
+	User     string    `json:"user,omitempty"`
+	QueuedAt time.Time `json:"queued_at"`
+	// Position is 1 for the job a worker takes next.
+	Position int `json:"position"`
+}
+
+// queueItem is a job in the queue.
+type queueItem struct {
+	job      *BuildJob
+	priority int
+	seq      uint64
+	queuedAt time.Time
+	index    int
+}
+
+// queueHeap orders items by descending priority, then by submission order.
+type queueHeap []*queueItem
+
+func (h queueHeap) Len() int { return len(h) }
+
+func (h queueHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h queueHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *queueHeap) Push(x interface{}) {
+	item := x.(*queueItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *queueHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// jobQueue holds the jobs waiting for a worker, highest priority first and
+// first-come-first-served within a priority. Unlike a channel it lets queued
+// jobs be listed, reprioritised and removed.
+type jobQueue struct {
+	mu       sync.Mutex
+	nonEmpty *sync.Cond
+	items    queueHeap
+	byID     map[string]*queueItem
+	capacity int
+	seq      uint64
+	closed   bool
+}
+
+// newJobQueue returns a queue holding at most capacity jobs.
+func newJobQueue(capacity int) *jobQueue {
+	q := &jobQueue{byID: make(map[string]*queueItem), capacity: capacity}
+	q.nonEmpty = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues job at priority, failing with errQueueFull when the queue is
+// full.
+func (q *jobQueue) push(job *BuildJob, priority int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return errors.New("builder is shutting down")
+	}
+	if len(q.items) >= q.capacity {
+		return errQueueFull
+	}
+	q.seq++
+	item := &queueItem{job: job, priority: priority, seq: q.seq, queuedAt: time.Now()}
+	heap.Push(&q.items, item)
+	q.byID[job.ID] = item
+	q.nonEmpty.Signal()
+	return nil
+}
+
+// pop waits for the next job. It returns false once the queue is closed and
+// drained.
+func (q *jobQueue) pop() (*BuildJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 {
+		if q.closed {
+			return nil, false
+		}
+		q.nonEmpty.Wait()
+	}
+	item := heap.Pop(&q.items).(*queueItem)
+	delete(q.byID, item.job.ID)
+	return item.job, true
+}
+
+// remove takes jobID out of the queue, reporting whether it was queued.
+func (q *jobQueue) remove(jobID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.byID[jobID]
+	if !ok {
+		return false
+	}
+	heap.Remove(&q.items, item.index)
+	delete(q.byID, jobID)
+	return true
+}
+
+// setPriority changes the priority of queued job jobID, reporting whether it
+// was queued. Among equal priorities the job keeps its submission order.
+func (q *jobQueue) setPriority(jobID string, priority int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.byID[jobID]
+	if !ok {
+		return false
+	}
+	item.priority = priority
+	heap.Fix(&q.items, item.index)
+	return true
+}
+
+// entries lists the queued jobs in the order workers will take them.
+func (q *jobQueue) entries() []QueueEntry {
+	q.mu.Lock()
+	ordered := append(queueHeap(nil), q.items...)
+	q.mu.Unlock()
+
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].priority != ordered[j].priority {
+			return ordered[i].priority > ordered[j].priority
+		}
+		return ordered[i].seq < ordered[j].seq
+	})
+	entries := make([]QueueEntry, len(ordered))
+	for i, item := range ordered {
+		entries[i] = QueueEntry{
+			JobID:    item.job.ID,
+			Atom:     emergeTarget(item.job.Request.PackageName, item.job.Request.Version),
+			Priority: item.priority,
+			User:     requestUser(item.job.Request),
+			QueuedAt: item.queuedAt,
+			Position: i + 1,
+		}
+	}
+	return entries
+}
+
+// len returns the number of queued jobs.
+func (q *jobQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// close wakes the workers waiting on an empty queue so they exit.
+func (q *jobQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.nonEmpty.Broadcast()
+}
+
+// requestUser is who asked for a build: the request's user, else the user of
+// its configuration bundle.
+func requestUser(req *LocalBuildRequest) string {
+	if req.User != "" {
+		return req.User
+	}
+	if req.ConfigBundle != nil {
+		return req.ConfigBundle.Metadata.UserID
+	}
+	return ""
+}
+
+// ErrJobNotQueued is returned by the queue actions for a job that is no
+// longer waiting in the queue.
+var ErrJobNotQueued = errors.New("job is not queued")
+
+// QueuedJobs lists the queued jobs in the order workers will take them.
+func (lb *LocalBuilder) QueuedJobs() []QueueEntry {
+	return lb.jobQueue.entries()
+}
+
+// ReprioritizeJob sets the priority of a queued job, moving it ahead of jobs
+// with a lower priority.
+func (lb *LocalBuilder) ReprioritizeJob(jobID string, priority int) error {
+	if _, exists := lb.findJob(jobID); !exists {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+	if !lb.jobQueue.setPriority(jobID, priority) {
+		return fmt.Errorf("%w: %s", ErrJobNotQueued, jobID)
+	}
+	log.Printf("Job %s reprioritised to %d", jobID, priority)
+	return nil
+}
+
+// DropQueuedJob takes a queued job off the queue and marks it cancelled.
+func (lb *LocalBuilder) DropQueuedJob(jobID string) error {
+	job, exists := lb.findJob(jobID)
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+	if !lb.cancelQueued(job, "dropped from the queue by an administrator") {
+		return fmt.Errorf("%w: %s", ErrJobNotQueued, jobID)
+	}
+	log.Printf("Job %s dropped from the queue", jobID)
+	return nil
+}
@@ -0,0 +1,83 @@
+package builder
+
+import (
+	"testing"
+	"time"
+)
+
+func newQueuedJob(id string) *BuildJob {
+	return &BuildJob{ID: id, Status: "queued", Request: &LocalBuildRequest{PackageName: "app-misc/" + id}}
+}
+
+func TestJobQueueOrder(t *testing.T) {
+	q := newJobQueue(10)
+	for _, tc := range []struct {
+		id       string
+		priority int
+	}{{"a", 0}, {"b", 5}, {"c", 0}, {"d", 5}, {"e", -1}} {
+		if err := q.push(newQueuedJob(tc.id), tc.priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"b", "d", "a", "c", "e"}
+	for i, entry := range q.entries() {
+		if entry.JobID != want[i] || entry.Position != i+1 {
+			t.Errorf("entry %d = %s at %d, want %s", i, entry.JobID, entry.Position, want[i])
+		}
+	}
+	for _, id := range want {
+		job, ok := q.pop()
+		if !ok || job.ID != id {
+			t.Fatalf("pop = %v, want %s", job, id)
+		}
+	}
+}
+
+func TestJobQueueReprioritiseAndRemove(t *testing.T) {
+	q := newJobQueue(10)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := q.push(newQueuedJob(id), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !q.setPriority("c", 1) || !q.remove("a") {
+		t.Fatal("queued jobs not found")
+	}
+	if q.setPriority("a", 1) || q.remove("a") {
+		t.Error("a removed job is still in the queue")
+	}
+	if job, _ := q.pop(); job.ID != "c" {
+		t.Errorf("pop = %s, want the promoted job c", job.ID)
+	}
+	if job, _ := q.pop(); job.ID != "b" {
+		t.Errorf("pop = %s, want b", job.ID)
+	}
+}
+
+func TestJobQueueFullAndClose(t *testing.T) {
+	q := newJobQueue(1)
+	if err := q.push(newQueuedJob("a"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.push(newQueuedJob("b"), 0); err != errQueueFull {
+		t.Errorf("push to a full queue: %v, want errQueueFull", err)
+	}
+	q.pop()
+
+	done := make(chan bool)
+	go func() {
+		_, ok := q.pop()
+		done <- ok
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.close()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("pop on a closed, empty queue returned a job")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close did not wake a waiting worker")
+	}
+}
@@ -0,0 +1,141 @@
+// Package builder provides signing key expiry checks.
+package builder
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultKeyExpiryWarnDays is the warning window when
+	// GPG_EXPIRY_WARN_DAYS is not set.
+	defaultKeyExpiryWarnDays = 30
+	// keyExpiryCacheTTL bounds how long a looked-up key expiry is reused.
+	keyExpiryCacheTTL = time.Hour
+	// keyExpiryLogInterval rate-limits the expiry warning GetStatus logs.
+	keyExpiryLogInterval = 24 * time.Hour
+)
+
+// keyExpiryCache holds the signing key's last looked-up expiry.
+type keyExpiryCache struct {
+	mu        sync.Mutex
+	keyID     string
+	checkedAt time.Time
+	expires   time.Time // zero: the key never expires
+	err       error
+	loggedAt  time.Time // last time a warning was logged
+}
+
+// signingKeyExpiry returns when the signing key expires (zero if never),
+// looking it up at most once per keyExpiryCacheTTL. ok is false without an
+// enabled signer or when the lookup failed.
+func (lb *LocalBuilder) signingKeyExpiry() (expires time.Time, ok bool) {
+	if lb.signer == nil || !lb.signer.IsEnabled() || lb.signer.KeyID() == "" {
+		return time.Time{}, false
+	}
+	c := &lb.keyExpiry
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keyID := lb.signer.KeyID()
+	if c.keyID != keyID || time.Since(c.checkedAt) > keyExpiryCacheTTL {
+		c.expires, c.err = lb.signer.KeyExpiry()
+		c.keyID = keyID
+		c.checkedAt = time.Now()
+		if c.err != nil {
+			log.Printf("Warning: failed to look up expiry of GPG key %s: %v", keyID, c.err)
+		}
+	}
+	return c.expires, c.err == nil
+}
+
+// keyExpiryWarnDays returns the configured warning window in days.
+func (lb *LocalBuilder) keyExpiryWarnDays() int {
+	if lb.cfg != nil && lb.cfg.GPGExpiryWarnDays > 0 {
+		return lb.cfg.GPGExpiryWarnDays
+	}
+	return defaultKeyExpiryWarnDays
+}
+
+// daysUntil returns the whole days until t: rounded up while t is ahead, so
+// a key with hours left expires in 1 day, and down once it has passed.
+func daysUntil(t time.Time, now time.Time) int {
+	days := t.Sub(now).Hours() / 24
+	if days > 0 {
+		return int(math.Ceil(days))
+	}
+	return int(math.Floor(days))
+}
+
+// keyExpiryStatus returns the days until the signing key expires and a
+// warning when that is within the warning window. ok is false when the key
+// never expires or its expiry is unknown.
+func (lb *LocalBuilder) keyExpiryStatus() (days int, warning string, ok bool) {
+	expires, ok := lb.signingKeyExpiry()
+	if !ok || expires.IsZero() {
+		return 0, "", false
+	}
+	now := time.Now()
+	days = daysUntil(expires, now)
+	keyID := lb.signer.KeyID()
+	switch {
+	case !expires.After(now):
+		warning = fmt.Sprintf("GPG signing key %s expired on %s; artifacts are left unsigned",
+			keyID, expires.Format(time.RFC3339))
+	case days <= lb.keyExpiryWarnDays():
+		warning = fmt.Sprintf("GPG signing key %s expires in %d days (%s)",
+			keyID, days, expires.Format(time.RFC3339))
+	}
+	return days, warning, true
+}
+
+// CheckKeyExpiry logs and returns a warning when the signing key has expired
+// or expires within GPG_EXPIRY_WARN_DAYS, or "" when it does not.
+func (lb *LocalBuilder) CheckKeyExpiry() string {
+	_, warning, _ := lb.keyExpiryStatus()
+	if warning != "" {
+		log.Printf("WARNING: %s", warning)
+		lb.keyExpiry.mu.Lock()
+		lb.keyExpiry.loggedAt = time.Now()
+		lb.keyExpiry.mu.Unlock()
+	}
+	return warning
+}
+
+// logKeyExpiryWarning logs warning unless one was logged within
+// keyExpiryLogInterval, so the periodic status does not flood the log.
+func (lb *LocalBuilder) logKeyExpiryWarning(warning string) {
+	c := &lb.keyExpiry
+	c.mu.Lock()
+	due := time.Since(c.loggedAt) >= keyExpiryLogInterval
+	if due {
+		c.loggedAt = time.Now()
+	}
+	c.mu.Unlock()
+	if due {
+		log.Printf("WARNING: %s", warning)
+	}
+}
+
+// signingKeyExpired reports whether the signing key has expired, in which
+// case signing would only produce signatures nobody accepts.
+func (lb *LocalBuilder) signingKeyExpired() (time.Time, bool) {
+	expires, ok := lb.signingKeyExpiry()
+	return expires, ok && !expires.IsZero() && !expires.After(time.Now())
+}
+
+// skipExpiredSigning reports whether signing the artifact rel of job must be
+// skipped because the key has expired, logging it loudly if so.
+func (lb *LocalBuilder) skipExpiredSigning(job *BuildJob, rel string) bool {
+	expires, expired := lb.signingKeyExpired()
+	if !expired {
+		return false
+	}
+	msg := fmt.Sprintf("ERROR: GPG signing key %s expired on %s; %s is left UNSIGNED",
+		lb.signer.KeyID(), expires.Format(time.RFC3339), rel)
+	log.Print(msg)
+	job.appendLog(msg + "\n")
+	return true
+}
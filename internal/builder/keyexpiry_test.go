@@ -0,0 +1,173 @@
+package builder
+
+import (
+	"archive/tar"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/internal/gpg"
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// newExpiryTestBuilder returns a builder whose signing key expires at
+// expires, with the expiry pre-cached so gpg is never run.
+func newExpiryTestBuilder(t *testing.T, expires time.Time, warnDays int) *LocalBuilder {
+	t.Helper()
+	cfg := &config.BuilderConfig{GPGExpiryWarnDays: warnDays}
+	lb := newLocalBuilderWithConfig(0, gpg.NewSigner("ABCD1234", "", true), cfg)
+	lb.artifactDir = t.TempDir()
+	lb.keyExpiry.keyID = "ABCD1234"
+	lb.keyExpiry.checkedAt = time.Now()
+	lb.keyExpiry.expires = expires
+	return lb
+}
+
+func TestDaysUntil(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	tests := []struct {
+		t    time.Time
+		want int
+	}{
+		{now.Add(10 * 24 * time.Hour), 10},
+		{now.Add(2 * time.Hour), 1},
+		{now.Add(-2 * time.Hour), -1},
+		{now.Add(-3 * 24 * time.Hour), -3},
+	}
+	for _, tt := range tests {
+		if got := daysUntil(tt.t, now); got != tt.want {
+			t.Errorf("daysUntil(%v) = %d, want %d", tt.t.Sub(now), got, tt.want)
+		}
+	}
+}
+
+func TestKeyExpiryStatus(t *testing.T) {
+	t.Parallel()
+
+	lb := newExpiryTestBuilder(t, time.Now().Add(10*24*time.Hour+time.Minute), 0)
+	days, warning, ok := lb.keyExpiryStatus()
+	if !ok || days != 11 || !strings.Contains(warning, "expires in 11 days") {
+		t.Errorf("within the default window: %d, %q, %v", days, warning, ok)
+	}
+	status := lb.GetStatus()
+	if status["key_expires_in_days"] != 11 || status["key_expiry_warning"] == nil {
+		t.Errorf("status = %v", status)
+	}
+	if w := lb.CheckKeyExpiry(); w != warning {
+		t.Errorf("CheckKeyExpiry = %q, want %q", w, warning)
+	}
+
+	lb = newExpiryTestBuilder(t, time.Now().Add(10*24*time.Hour), 5)
+	if _, warning, ok := lb.keyExpiryStatus(); !ok || warning != "" {
+		t.Errorf("outside the configured window: %q, %v", warning, ok)
+	}
+	if status := lb.GetStatus(); status["key_expiry_warning"] != nil || status["key_expires_in_days"] == nil {
+		t.Errorf("status = %v", status)
+	}
+
+	lb = newExpiryTestBuilder(t, time.Time{}, 0)
+	if _, _, ok := lb.keyExpiryStatus(); ok {
+		t.Error("a key that never expires has no expiry status")
+	}
+	if _, ok := lb.GetStatus()["key_expires_in_days"]; ok {
+		t.Error("status reports an expiry for a key that never expires")
+	}
+}
+
+func TestSignArtifactExpiredKey(t *testing.T) {
+	t.Parallel()
+
+	lb := newExpiryTestBuilder(t, time.Now().Add(-time.Hour), 0)
+	if _, warning, _ := lb.keyExpiryStatus(); !strings.Contains(warning, "expired") {
+		t.Errorf("warning = %q", warning)
+	}
+	rel := "app-misc/jq-1.7.1-1.gpkg.tar"
+	path := filepath.Join(lb.artifactDir, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("package"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	job := &BuildJob{ID: "job-1"}
+	lb.signArtifact(job, rel)
+	if _, err := os.Stat(path + gpg.SignatureFileExt); !os.IsNotExist(err) {
+		t.Errorf("signature written with an expired key: %v", err)
+	}
+	if job.Metadata["signed"] != nil {
+		t.Errorf("job marked signed: %v", job.Metadata)
+	}
+	if log := job.logSnapshot(); !strings.Contains(log, "ERROR: GPG signing key ABCD1234 expired") {
+		t.Errorf("job log = %q", log)
+	}
+}
+
+func TestSignArtifactEmbedsGpkgSignatures(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	// Not t.TempDir(): gpg-agent's socket path must stay short.
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+		_ = os.RemoveAll(home)
+	})
+	if err := os.Chmod(home, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("gpg", "--batch", "--homedir", home, "--passphrase", "",
+		"--quick-gen-key", "Builder <builder@example.com>", "ed25519", "sign", "never").CombinedOutput(); err != nil {
+		t.Fatalf("gpg --quick-gen-key: %v\n%s", err, out)
+	}
+	signer := gpg.NewSigner("builder@example.com", "", true, gpg.WithGnupgHome(home))
+	lb := &LocalBuilder{artifactDir: t.TempDir(), signer: signer}
+
+	gpkg := "app-misc/jq-1.7.1-1.gpkg.tar"
+	path := filepath.Join(lb.artifactDir, gpkg)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for _, name := range []string{"gpkg-1", "metadata.tar.zst", "image.tar.zst"} {
+		if err := tw.WriteHeader(&tar.Header{Name: "jq-1.7.1-1/" + name, Mode: 0644, Size: int64(len(name))}); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = tw.Write([]byte(name))
+	}
+	_ = tw.Close()
+	_ = f.Close()
+	tbz2 := "app-misc/jq-1.6-1.tbz2"
+	if err := os.WriteFile(filepath.Join(lb.artifactDir, tbz2), []byte("bzip2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	job := &BuildJob{ID: "job-1"}
+	lb.signArtifact(job, gpkg)
+	lb.signArtifact(job, tbz2)
+
+	if artifactExists(path + gpg.SignatureFileExt) {
+		t.Error("a gpkg got a detached signature")
+	}
+	if !gpkgIsSigned(path) || job.Metadata["signed"] != true {
+		t.Errorf("gpkg not signed: metadata %v", job.Metadata)
+	}
+	if sigs := stringMap(job.Metadata["signatures"]); len(sigs) != 1 || sigs[tbz2] != tbz2+gpg.SignatureFileExt {
+		t.Errorf("signatures = %v, want only the tbz2's detached one", sigs)
+	}
+	if !artifactExists(filepath.Join(lb.artifactDir, tbz2) + gpg.SignatureFileExt) {
+		t.Error("the tbz2 got no detached signature")
+	}
+}
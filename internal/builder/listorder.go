@@ -0,0 +1,113 @@
+// Package builder provides the sort orders of job and build lists.
+package builder
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sort keys of job and build lists.
+const (
+	SortCreatedAt   = "created_at"
+	SortStatus      = "status"
+	SortPackageName = "package_name"
+)
+
+// ListOrder is the order of a job or build list. The zero value lists
+// newest first. Ties on Key fall back to newest first and then the job ID,
+// so a list of the same jobs always comes back in the same order and
+// offset-based pages neither repeat nor skip jobs.
+type ListOrder struct {
+	// Key is SortCreatedAt (the default), SortStatus or SortPackageName.
+	Key string
+	// Ascending lists the smallest key first.
+	Ascending bool
+}
+
+// ParseListOrder parses a sort key and an "asc" or "desc" direction, as
+// given in the sort and order query parameters. Without a direction,
+// created_at sorts newest first and the text keys alphabetically.
+func ParseListOrder(key, direction string) (ListOrder, error) {
+	order := ListOrder{Key: strings.TrimSpace(key)}
+	switch order.Key {
+	case "", SortCreatedAt:
+		order.Key = SortCreatedAt
+	case SortStatus, SortPackageName:
+		order.Ascending = true
+	default:
+		return ListOrder{}, fmt.Errorf("unknown sort key %q (want %s, %s or %s)",
+			key, SortCreatedAt, SortStatus, SortPackageName)
+	}
+	switch strings.ToLower(strings.TrimSpace(direction)) {
+	case "":
+	case "asc":
+		order.Ascending = true
+	case "desc":
+		order.Ascending = false
+	default:
+		return ListOrder{}, fmt.Errorf("unknown sort order %q (want asc or desc)", direction)
+	}
+	return order, nil
+}
+
+// sortFields are the fields of a listed job that ListOrder compares.
+type sortFields struct {
+	created time.Time
+	status  string
+	pkg     string
+	id      string
+}
+
+// less reports whether a is listed before b.
+func (o ListOrder) less(a, b sortFields) bool {
+	var c int
+	switch o.Key {
+	case SortStatus:
+		c = strings.Compare(a.status, b.status)
+	case SortPackageName:
+		c = strings.Compare(a.pkg, b.pkg)
+	default:
+		c = a.created.Compare(b.created)
+	}
+	if c != 0 {
+		if o.Ascending {
+			return c < 0
+		}
+		return c > 0
+	}
+	if !a.created.Equal(b.created) {
+		return a.created.After(b.created)
+	}
+	return a.id < b.id
+}
+
+// jobSortFields returns the sort fields of a builder job.
+func jobSortFields(job *BuildJob) sortFields {
+	f := sortFields{created: job.StartTime, status: job.Status, id: job.ID}
+	if job.Request != nil {
+		f.pkg = job.Request.PackageName
+	}
+	return f
+}
+
+// buildSortFields returns the sort fields of a server build.
+func buildSortFields(b *BuildStatus) sortFields {
+	return sortFields{created: b.CreatedAt, status: b.Status, pkg: b.PackageName, id: b.JobID}
+}
+
+// sqlOrderBy returns the ORDER BY clause listing jobs rows in this order.
+func (o ListOrder) sqlOrderBy() string {
+	dir := " DESC"
+	if o.Ascending {
+		dir = " ASC"
+	}
+	switch o.Key {
+	case SortStatus:
+		return " ORDER BY status" + dir + ", start_time DESC, id"
+	case SortPackageName:
+		return " ORDER BY json_extract(data, '$.request.package_name')" + dir + ", start_time DESC, id"
+	default:
+		return " ORDER BY start_time" + dir + ", id"
+	}
+}
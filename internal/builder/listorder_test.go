@@ -0,0 +1,120 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestParseListOrder(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		key, dir string
+		want     ListOrder
+		wantErr  bool
+	}{
+		{"", "", ListOrder{Key: SortCreatedAt}, false},
+		{"created_at", "asc", ListOrder{Key: SortCreatedAt, Ascending: true}, false},
+		{"status", "", ListOrder{Key: SortStatus, Ascending: true}, false},
+		{"package_name", "DESC", ListOrder{Key: SortPackageName}, false},
+		{"", "asc", ListOrder{Key: SortCreatedAt, Ascending: true}, false},
+		{"arch", "", ListOrder{}, true},
+		{"status", "up", ListOrder{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseListOrder(tt.key, tt.dir)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseListOrder(%q, %q) = %+v, %v; want %+v, error %v", tt.key, tt.dir, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// orderTestJobs have ties on every sort key, so only the tie-breakers make
+// the order deterministic.
+func orderTestJobs(now time.Time) map[string]*BuildJob {
+	job := func(id, status, pkg string, age time.Duration) *BuildJob {
+		return &BuildJob{ID: id, Status: status, StartTime: now.Add(-age), Request: &LocalBuildRequest{PackageName: pkg}}
+	}
+	return map[string]*BuildJob{
+		"a": job("a", "success", "dev-lang/python", 3*time.Minute),
+		"b": job("b", "failed", "app-misc/jq", 2*time.Minute),
+		"c": job("c", "success", "app-misc/jq", 2*time.Minute),
+		"d": job("d", "failed", "dev-lang/python", time.Minute),
+		"e": job("e", "success", "sys-apps/portage", time.Minute),
+	}
+}
+
+var orderTests = []struct {
+	key, dir string
+	want     string
+}{
+	{"", "", "d,e,b,c,a"},
+	{"created_at", "asc", "a,b,c,d,e"},
+	{"status", "", "d,b,e,c,a"},
+	{"status", "desc", "e,c,a,d,b"},
+	{"package_name", "", "b,c,d,a,e"},
+	{"package_name", "desc", "e,d,a,b,c"},
+}
+
+func TestListJobsPageOrder(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now().Truncate(time.Second)
+	inMemory := &LocalBuilder{jobs: orderTestJobs(now)}
+	store := newTestSQLiteStore(t)
+	if err := store.Save(orderTestJobs(now)); err != nil {
+		t.Fatal(err)
+	}
+	withSQLite := &LocalBuilder{jobs: map[string]*BuildJob{}, jobStore: store}
+
+	for name, lb := range map[string]*LocalBuilder{"memory": inMemory, "sqlite": withSQLite} {
+		for _, tt := range orderTests {
+			order, err := ParseListOrder(tt.key, tt.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Map iteration order varies between calls; the list must not.
+			for i := 0; i < 20; i++ {
+				jobs, _, err := lb.ListJobsPage(JobQuery{Order: order})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := strings.Join(jobIDs(jobs), ","); got != tt.want {
+					t.Errorf("%s, sort %q %q, call %d: %s, want %s", name, tt.key, tt.dir, i, got, tt.want)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestListBuildsOrder(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 1})
+	defer mgr.Shutdown()
+
+	builds := map[string]*BuildStatus{}
+	for id, job := range orderTestJobs(time.Now()) {
+		builds[id] = &BuildStatus{JobID: id, Status: job.Status, PackageName: job.Request.PackageName, CreatedAt: job.StartTime}
+	}
+	mgr.LoadJobs(builds)
+
+	for _, tt := range orderTests {
+		order, err := ParseListOrder(tt.key, tt.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			var ids []string
+			for _, b := range mgr.ListBuilds(BuildFilter{Order: order}).Builds {
+				ids = append(ids, b.JobID)
+			}
+			if got := strings.Join(ids, ","); got != tt.want {
+				t.Errorf("sort %q %q, call %d: %s, want %s", tt.key, tt.dir, i, got, tt.want)
+				break
+			}
+		}
+	}
+}
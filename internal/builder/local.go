@@ -4,20 +4,30 @@ package builder
 import (
 	"archive/tar"
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/slchris/portage-engine/internal/binpkg"
 	"github.com/slchris/portage-engine/internal/gpg"
 	"github.com/slchris/portage-engine/internal/notification"
+	"github.com/slchris/portage-engine/internal/storage"
+	"github.com/slchris/portage-engine/internal/version"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
@@ -30,6 +40,30 @@ type LocalBuildRequest struct {
 	Environment  map[string]string `json:"environment"`
 	ConfigBundle *ConfigBundle     `json:"config_bundle,omitempty"`
 	PackageSpecs []PackageSpec     `json:"package_specs,omitempty"`
+	// AcceptLicense overrides the builder's ACCEPT_LICENSE for this build
+	// (e.g. "@FREE @BINARY-REDISTRIBUTABLE"). Empty uses the builder's.
+	AcceptLicense string `json:"accept_license,omitempty"`
+	// APIVersion is the server's APIVersion on forwarded requests; a builder
+	// rejects requests newer than it understands. Zero for direct clients.
+	APIVersion int `json:"api_version,omitempty"`
+	// BuildTimeout bounds this build as a duration string (e.g. "6h"); empty
+	// uses the builder's DefaultBuildTimeout.
+	BuildTimeout string `json:"build_timeout,omitempty"`
+	// Priority orders the queue: higher runs first, equal priorities in
+	// submission order. Zero is the default.
+	Priority int `json:"priority,omitempty"`
+	// User is who asked for the build, shown in the queue listing.
+	User string `json:"user,omitempty"`
+	// ForceRebuild builds even when an identical build already succeeded
+	// against the same portage tree (see BUILD_RESULT_CACHE).
+	ForceRebuild bool `json:"force_rebuild,omitempty"`
+	// Pretend runs emerge --pretend instead of the build: the job log shows
+	// the resolved dependency graph and download sizes, and no artifact is
+	// produced. The job ends "success_no_artifact" once emerge resolved it.
+	Pretend bool `json:"pretend,omitempty"`
+	// RequestID correlates the build with the server request that asked
+	// for it. Set from the X-Request-ID header, never from client JSON.
+	RequestID string `json:"-"`
 }
 
 // BuildJob represents a build job with its status.
@@ -41,7 +75,7 @@ type BuildJob struct {
 	mu          sync.Mutex         `json:"-"`
 	ID          string             `json:"id"`
 	Request     *LocalBuildRequest `json:"request"`
-	Status      string             `json:"status"` // queued, building, success, failed
+	Status      string             `json:"status"` // queued, building, success, success_no_artifact, failed, cancelled
 	StartTime   time.Time          `json:"start_time"`
 	EndTime     time.Time          `json:"end_time"`
 	Log         string             `json:"log"`
@@ -52,15 +86,100 @@ type BuildJob struct {
 	Artifacts []string               `json:"artifacts,omitempty"`
 	Error     string                 `json:"error,omitempty"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
-}
-
-// appendLog appends to the job log under the job lock.
+	// RequestID is the ID of the request that submitted the build; it is
+	// set once, when the job is created.
+	RequestID string `json:"request_id,omitempty"`
+	// Script is what the build ran: the generated container script, or the
+	// environment and emerge command of a native build, with its secrets
+	// redacted.
+	Script string `json:"script,omitempty"`
+	// logSubs are the live streams of Log, ended when the job finishes.
+	logSubs logSubscribers
+	// cancel stops the running build; it is set while Status is "building".
+	cancel context.CancelFunc
+	// interruption is why the builder itself stopped the running build (a
+	// spot interruption or a shutdown), as opposed to a cancellation on
+	// request; interruptCategory is the failure category it fails with.
+	interruption      string
+	interruptCategory string
+	// redactor masks secrets in everything appended to Log.
+	redactor *logRedactor
+}
+
+// ref names the job in log lines: its ID, followed by the request ID that
+// correlates it across services when it has one.
+func (j *BuildJob) ref() string {
+	if j.RequestID == "" {
+		return j.ID
+	}
+	return j.ID + " (request " + j.RequestID + ")"
+}
+
+// errNoArtifact marks a build whose emerge exited successfully but left no
+// binary package to collect (e.g. a virtual, or a package that only installs
+// files). Such jobs end as "success_no_artifact" instead of a "success" whose
+// download would be broken.
+var errNoArtifact = errors.New("build succeeded but produced no binary package")
+
+// appendLog appends to the job log under the job lock, with secrets masked,
+// and hands the new text to any live log streams.
 func (j *BuildJob) appendLog(s string) {
+	s = j.redactor.redact(s)
 	j.mu.Lock()
 	j.Log += s
+	j.logSubs.publish(s)
 	j.mu.Unlock()
 }
 
+// jobLogWriter appends everything written to it to a job's log. It passes
+// output on in whole lines, so a secret split across two writes is still
+// redacted; Flush appends a trailing partial line.
+type jobLogWriter struct {
+	job     *BuildJob
+	partial []byte
+}
+
+func (w *jobLogWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	if i := bytes.LastIndexAny(w.partial, "\n\r"); i >= 0 {
+		w.job.appendLog(string(w.partial[:i+1]))
+		w.partial = append(w.partial[:0], w.partial[i+1:]...)
+	}
+	return len(p), nil
+}
+
+// Flush appends any output not yet ended by a newline.
+func (w *jobLogWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.job.appendLog(string(w.partial))
+		w.partial = nil
+	}
+}
+
+// subscribeLog starts a live stream of the job log. A finished job yields
+// just its log, with no chunks to follow.
+func (j *BuildJob) subscribeLog() *LogStream {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	stream := &LogStream{
+		Snapshot: j.Log,
+		Status: func() string {
+			status, _ := j.snapshot()
+			return status
+		},
+	}
+	if j.Status == "queued" || j.Status == "building" {
+		ch := j.logSubs.add()
+		stream.Chunks = ch
+		stream.cancel = func() {
+			j.mu.Lock()
+			j.logSubs.remove(ch)
+			j.mu.Unlock()
+		}
+	}
+	return stream
+}
+
 // setArtifacts records the full produced-artifact list under the job lock.
 func (j *BuildJob) setArtifacts(rels []string) {
 	j.mu.Lock()
@@ -82,13 +201,6 @@ func (j *BuildJob) setArtifactURL(url string) {
 	j.mu.Unlock()
 }
 
-// setLog replaces the job log under the job lock.
-func (j *BuildJob) setLog(s string) {
-	j.mu.Lock()
-	j.Log = s
-	j.mu.Unlock()
-}
-
 // snapshot returns a copy of the status and artifact URL under the job lock.
 func (j *BuildJob) snapshot() (status, artifactURL string) {
 	j.mu.Lock()
@@ -96,6 +208,23 @@ func (j *BuildJob) snapshot() (status, artifactURL string) {
 	return j.Status, j.ArtifactURL
 }
 
+// logSnapshot returns the job log under the job lock.
+func (j *BuildJob) logSnapshot() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Log
+}
+
+// setMetadata sets one job metadata entry under the job lock.
+func (j *BuildJob) setMetadata(key string, value interface{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.Metadata == nil {
+		j.Metadata = map[string]interface{}{}
+	}
+	j.Metadata[key] = value
+}
+
 // Clone returns a deep copy of the job (without the mutex) taken under the job
 // lock. Use this instead of copying a BuildJob by value, which would copy the
 // mutex.
@@ -112,6 +241,8 @@ func (j *BuildJob) Clone() *BuildJob {
 		ArtifactURL: j.ArtifactURL,
 		Artifacts:   append([]string(nil), j.Artifacts...),
 		Error:       j.Error,
+		RequestID:   j.RequestID,
+		Script:      j.Script,
 	}
 	if j.Metadata != nil {
 		c.Metadata = make(map[string]interface{}, len(j.Metadata))
@@ -125,7 +256,7 @@ func (j *BuildJob) Clone() *BuildJob {
 // LocalBuilder handles build jobs locally using Docker or native builds.
 type LocalBuilder struct {
 	workers          int
-	jobQueue         chan *BuildJob
+	jobQueue         *jobQueue
 	jobs             map[string]*BuildJob
 	jobsMutex        sync.RWMutex
 	signer           *gpg.Signer
@@ -138,13 +269,52 @@ type LocalBuilder struct {
 	containerRuntime ContainerRuntime
 	executor         *BuildExecutor
 	dockerExecutor   *DockerBuildExecutor
-	notifier         *notification.Notifier
-	jobStore         *JobStore
+	notifier         atomic.Pointer[notification.Notifier]
+	jobStore         JobStorage
+	artifactIndex    binpkg.ArtifactIndex
+	blobs            *blobStore
 	persister        *JobPersister
 	instanceID       string
 	architecture     string
 	pkgMgr           PackageManager
 	cfg              *config.BuilderConfig
+	// gpgKeySynced reports whether the server's public key has been imported.
+	gpgKeySynced atomic.Bool
+	// keyExpiry caches the signing key's expiry.
+	keyExpiry keyExpiryCache
+	// signerErr is why GPG signing was requested but could not be set up.
+	signerErr error
+	// treeSyncMu serialises portage tree syncs; treeSyncedAt is the last
+	// successful one (unix nanoseconds, 0 until this builder has synced).
+	// treeMu is held for reading while a job builds against the shared tree
+	// and for writing while a sync rewrites it, so no build sees a
+	// half-synced tree.
+	treeSyncMu     sync.Mutex
+	treeMu         sync.RWMutex
+	treeSyncedAt   atomic.Int64
+	treeSyncRunner func(ctx context.Context, argv []string) ([]byte, error)
+	// spotNotice is the spot interruption notice, once one has arrived.
+	spotNotice atomic.Pointer[spotInterruption]
+	// targetLocks keeps identical builds from running concurrently.
+	targetLocks targetLocks
+	// resultCache finds the successful build of an identical request.
+	resultCache resultCache
+	// admission holds queued builds back while memory is short.
+	admission memoryAdmission
+	// binfmtMiscDir overrides where QEMU binfmt_misc handlers are looked up.
+	binfmtMiscDir string
+	// redactor masks LOG_REDACT_PATTERNS and the builder's secrets in job
+	// logs; each job extends it with its request's secrets.
+	redactor *logRedactor
+	stop     chan struct{}
+	stopOnce sync.Once
+	// draining is set once Drain starts; workersWG tracks the workers it
+	// waits for.
+	draining  atomic.Bool
+	workersWG sync.WaitGroup
+	// diskWarned is set while disk usage is above the high watermark and
+	// the warning for it was sent.
+	diskWarned atomic.Bool
 }
 
 // NewLocalBuilder creates a new local builder instance.
@@ -171,13 +341,14 @@ func newLocalBuilderWithConfig(workers int, signer *gpg.Signer, cfg *config.Buil
 	dockerExecutor := initDockerExecutor(cfg, containerRuntime, dockerImage)
 	pkgMgr := initPackageManager(cfg)
 	jobStore := initJobStore(cfg)
+	artifactIndex := initArtifactIndex(cfg)
 
 	instanceID := generateInstanceID(cfg)
 	architecture := getArchitecture(cfg)
 
 	lb := &LocalBuilder{
 		workers:          workers,
-		jobQueue:         make(chan *BuildJob, 100),
+		jobQueue:         newJobQueue(100),
 		jobs:             make(map[string]*BuildJob),
 		signer:           signer,
 		gpgClient:        gpgClient,
@@ -189,12 +360,28 @@ func newLocalBuilderWithConfig(workers int, signer *gpg.Signer, cfg *config.Buil
 		containerRuntime: containerRuntime,
 		executor:         executor,
 		dockerExecutor:   dockerExecutor,
-		notifier:         notifier,
 		jobStore:         jobStore,
+		artifactIndex:    artifactIndex,
+		blobs:            newBlobStore(artifactDir),
 		instanceID:       instanceID,
 		architecture:     architecture,
 		pkgMgr:           pkgMgr,
 		cfg:              cfg,
+		redactor:         builderRedactor(cfg),
+		stop:             make(chan struct{}),
+	}
+	lb.notifier.Store(notifier)
+
+	if gpgClient != nil && cfg.GPGAutoSync && cfg.GPGEnabled {
+		lb.startGPGKeySync()
+	}
+
+	if interval := lb.treeSyncInterval(); interval > 0 {
+		go lb.treeSyncLoop(interval)
+	}
+
+	if cfg != nil && cfg.SpotInterruptionCheck {
+		go lb.spotInterruptionLoop(ec2MetadataURL, spotCheckInterval)
 	}
 
 	if jobStore != nil {
@@ -205,17 +392,27 @@ func newLocalBuilderWithConfig(workers int, signer *gpg.Signer, cfg *config.Buil
 			lb.jobs = loadedJobs
 			log.Printf("Loaded %d persisted jobs", len(loadedJobs))
 			reconcileLoadedJobs(jobStore, loadedJobs)
+			lb.loadResultCache(loadedJobs)
 		}
 
 		// Construct the persister so job state actually survives restarts.
 		// Without this, saveJobState()/Stop() were no-ops (lb.persister was nil).
-		retention := time.Duration(cfg.RetentionDays) * 24 * time.Hour
-		lb.persister = NewJobPersister(jobStore, lb.jobsSnapshot, 30*time.Second, retention)
+		// Retention is applied by pruneLoop, which also drops the jobs from
+		// memory and can delete their artifacts.
+		lb.persister = NewJobPersister(jobStore, lb.jobsSnapshot, 30*time.Second, 0)
 		lb.persister.Start()
 	}
 
+	if lb.retentionEnabled() && cfg.JobPruneInterval > 0 {
+		go lb.pruneLoop(cfg.JobPruneInterval)
+	}
+
 	for i := 0; i < workers; i++ {
-		go lb.worker(i)
+		lb.workersWG.Add(1)
+		go func() {
+			defer lb.workersWG.Done()
+			lb.worker(i)
+		}()
 	}
 
 	return lb
@@ -281,32 +478,28 @@ func getContainerRuntime(cfg *config.BuilderConfig) string {
 func ensureDirectories(workDir, artifactDir string) {
 	_ = os.MkdirAll(workDir, 0750)
 	_ = os.MkdirAll(artifactDir, 0750)
-	verifyDirectoryWritable(workDir, "Work")
-	verifyDirectoryWritable(artifactDir, "Artifact")
+	for _, d := range []struct{ kind, dir string }{{"Work", workDir}, {"Artifact", artifactDir}} {
+		if err := verifyDirectoryWritable(d.dir); err != nil {
+			log.Printf("WARNING: %s directory %s is not writable: %v", d.kind, d.dir, err)
+			log.Printf("Please ensure the directory exists and is owned by the service user")
+		}
+	}
 }
 
-// verifyDirectoryWritable checks if a directory is writable.
-func verifyDirectoryWritable(dir, dirType string) {
-	testFile := filepath.Join(dir, ".write_test")
-	if err := os.WriteFile(testFile, []byte("test"), 0600); err != nil {
-		log.Printf("WARNING: %s directory %s is not writable: %v", dirType, dir, err)
-		log.Printf("Please ensure the directory exists and is owned by the service user")
-	} else {
-		_ = os.Remove(testFile)
+// verifyDirectoryWritable checks that a file can be created in dir.
+func verifyDirectoryWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".write_test")
+	if err != nil {
+		return err
 	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return nil
 }
 
 // loadNotifier loads the notification configuration.
 func loadNotifier(cfg *config.BuilderConfig) *notification.Notifier {
-	notifyConfigPath := os.Getenv("NOTIFY_CONFIG")
-	if notifyConfigPath == "" {
-		if cfg != nil && cfg.NotifyConfig != "" {
-			notifyConfigPath = cfg.NotifyConfig
-		} else {
-			notifyConfigPath = "configs/notification.json"
-		}
-	}
-
+	notifyConfigPath := notifyConfigPath(cfg)
 	notifyConfig, err := notification.LoadConfig(notifyConfigPath)
 	if err == nil {
 		log.Printf("Notification system loaded from %s", notifyConfigPath)
@@ -316,6 +509,41 @@ func loadNotifier(cfg *config.BuilderConfig) *notification.Notifier {
 	return nil
 }
 
+// notifyConfigPath returns the notification config file: NOTIFY_CONFIG from
+// the environment, else from the config, else configs/notification.json.
+func notifyConfigPath(cfg *config.BuilderConfig) string {
+	if path := os.Getenv("NOTIFY_CONFIG"); path != "" {
+		return path
+	}
+	if cfg != nil && cfg.NotifyConfig != "" {
+		return cfg.NotifyConfig
+	}
+	return "configs/notification.json"
+}
+
+// ReloadConfig applies the reloadable fields of cfg, the builder config read
+// again on SIGHUP: it rereads the notification config. It rejects cfg,
+// keeping the running notifier, when cfg fails CheckReload or names a
+// notification config that cannot be read; with none configured a missing
+// default file disables notifications, as at startup. The other fields need
+// a restart and are ignored.
+func (lb *LocalBuilder) ReloadConfig(cfg *config.BuilderConfig) error {
+	if err := cfg.CheckReload(); err != nil {
+		return err
+	}
+	path := notifyConfigPath(cfg)
+	var notifier *notification.Notifier
+	notifyConfig, err := notification.LoadConfig(path)
+	switch {
+	case err == nil:
+		notifier = notification.NewNotifier(notifyConfig)
+	case os.Getenv("NOTIFY_CONFIG") != "" || cfg.NotifyConfig != "":
+		return fmt.Errorf("notification config %s: %w", path, err)
+	}
+	lb.notifier.Store(notifier)
+	return nil
+}
+
 // initGPGClient initializes the GPG key client if configured.
 func initGPGClient(cfg *config.BuilderConfig) *GPGKeyClient {
 	if cfg == nil || cfg.ServerURL == "" {
@@ -328,32 +556,115 @@ func initGPGClient(cfg *config.BuilderConfig) *GPGKeyClient {
 	}
 	log.Printf("GPG key client initialized with server URL: %s", cfg.ServerURL)
 
-	if cfg.GPGAutoSync && cfg.GPGEnabled {
-		syncGPGKey(gpgClient, cfg)
+	return gpgClient
+}
+
+// gpgSyncRecheckInterval is how often a builder whose server key sync used up
+// its backoff retries tries again, so a server that comes up late is still
+// picked up eventually.
+const gpgSyncRecheckInterval = 10 * time.Minute
+
+// startGPGKeySync imports the server's public key. The first attempt runs
+// synchronously so a reachable server is synced before any build starts; on
+// failure a background goroutine retries until it succeeds or the builder
+// shuts down. Once synced, the key is refreshed every gpgSyncRecheckInterval
+// so a rotated server key is picked up.
+func (lb *LocalBuilder) startGPGKeySync() {
+	err := syncGPGKey(lb.gpgClient, lb.cfg)
+	if err == nil {
+		lb.gpgKeySynced.Store(true)
+		log.Printf("GPG key sync complete; %s", lb.signingState())
+		go lb.refreshGPGKey()
+		return
 	}
+	log.Printf("Failed to sync GPG key from server: %v; retrying in the background (%s)", err, lb.signingState())
+	go lb.retryGPGKeySync()
+}
 
-	return gpgClient
+// retryGPGKeySync retries the server key sync with exponential backoff for
+// GPG_SYNC_RETRIES attempts, then every gpgSyncRecheckInterval.
+func (lb *LocalBuilder) retryGPGKeySync() {
+	base := time.Duration(lb.cfg.GPGSyncBackoff) * time.Second
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-lb.stop:
+			return
+		case <-time.After(gpgSyncDelay(attempt, lb.cfg.GPGSyncRetries, base)):
+		}
+
+		err := syncGPGKey(lb.gpgClient, lb.cfg)
+		if err == nil {
+			lb.gpgKeySynced.Store(true)
+			log.Printf("GPG key sync succeeded on retry %d; %s", attempt, lb.signingState())
+			lb.refreshGPGKey()
+			return
+		}
+		if attempt == lb.cfg.GPGSyncRetries {
+			log.Printf("GPG key sync still failing after %d retries (%v); re-attempting every %s", attempt, err, gpgSyncRecheckInterval)
+		} else if attempt < lb.cfg.GPGSyncRetries {
+			log.Printf("GPG key sync retry %d/%d failed: %v", attempt, lb.cfg.GPGSyncRetries, err)
+		}
+	}
+}
+
+// refreshGPGKey re-syncs the server key every gpgSyncRecheckInterval until
+// the builder shuts down, trusting a rotated key through its succession
+// certificate.
+func (lb *LocalBuilder) refreshGPGKey() {
+	for {
+		select {
+		case <-lb.stop:
+			return
+		case <-time.After(gpgSyncRecheckInterval):
+		}
+		if err := syncGPGKey(lb.gpgClient, lb.cfg); err != nil {
+			log.Printf("Warning: GPG key refresh failed: %v", err)
+		}
+	}
+}
+
+// gpgSyncDelay returns the wait before key sync retry attempt (1-based):
+// base doubling per retry while attempt <= retries, capped at
+// gpgSyncRecheckInterval, and gpgSyncRecheckInterval once retries are used up.
+func gpgSyncDelay(attempt, retries int, base time.Duration) time.Duration {
+	if attempt > retries || base <= 0 {
+		return gpgSyncRecheckInterval
+	}
+	delay := base
+	for i := 1; i < attempt && delay < gpgSyncRecheckInterval; i++ {
+		delay *= 2
+	}
+	return min(delay, gpgSyncRecheckInterval)
+}
+
+// signingState describes whether this builder signs the packages it produces.
+// Signing uses the builder's own key and does not depend on the server key
+// sync, which only provides the key for verification.
+func (lb *LocalBuilder) signingState() string {
+	if keyID := lb.getGPGKeyID(); keyID != "" {
+		return "packages are signed with builder key " + keyID
+	}
+	return "packages are UNSIGNED (no builder signing key)"
 }
 
 // syncGPGKey imports the server's public key so the builder can verify
 // server-signed material. It does NOT change cfg.GPGKeyID: that is the builder's
 // own signing key, and signing requires a private key (the server key is public
 // only). Overwriting it would break binpkg-signing.
-func syncGPGKey(gpgClient *GPGKeyClient, cfg *config.BuilderConfig) {
+func syncGPGKey(gpgClient *GPGKeyClient, cfg *config.BuilderConfig) error {
 	gpgKeyPath := filepath.Join(cfg.GPGHome, "server-public.asc")
 
 	if err := gpgClient.FetchAndImportGPGKey(gpgKeyPath); err != nil {
-		log.Printf("Failed to sync GPG key from server: %v", err)
-		return
+		return err
 	}
 
 	keyID, err := gpgClient.GetKeyID(gpgKeyPath)
 	if err != nil {
-		log.Printf("Failed to get server GPG key ID: %v", err)
-		return
+		return fmt.Errorf("failed to get server GPG key ID: %w", err)
 	}
 
 	log.Printf("Imported server GPG public key for verification: %s", keyID)
+	return nil
 }
 
 // initStorageUploader initializes the storage uploader if configured.
@@ -374,6 +685,13 @@ func initStorageUploader(cfg *config.BuilderConfig) *StorageUploader {
 		cfg.StorageS3Region,
 		cfg.StorageS3Prefix,
 		cfg.StorageHTTPBase,
+		storage.S3Options{
+			Endpoint:           cfg.StorageS3Endpoint,
+			AccessKeyID:        cfg.StorageS3AccessKey,
+			SecretAccessKey:    cfg.StorageS3SecretKey,
+			MultipartThreshold: cfg.S3MultipartThreshold,
+			UploadConcurrency:  cfg.S3UploadConcurrency,
+		},
 	)
 	if err != nil {
 		log.Printf("Failed to initialize storage uploader: %v", err)
@@ -398,7 +716,14 @@ func buildOptionsFromConfig(cfg *config.BuilderConfig, forDocker bool) BuildOpti
 	if cfg != nil && cfg.BinpkgFormat != "" {
 		format = cfg.BinpkgFormat
 	}
-	opts := BuildOptions{Format: format}
+	jobs := builderJobControl(cfg)
+	opts := BuildOptions{
+		Format:        format,
+		SeparateFetch: cfg != nil && cfg.SeparateFetch,
+		ArtifactDebug: cfg != nil && cfg.ArtifactDebug,
+		MakeOpts:      jobs.makeOpts,
+		EmergeJobs:    jobs.emergeJobs,
+	}
 	if cfg != nil && cfg.GPGEnabled && cfg.GPGKeyID != "" && format != "xpak" {
 		opts.SignKeyID = cfg.GPGKeyID
 		opts.SignHostGnupgHome = cfg.GPGHome
@@ -441,8 +766,9 @@ func initPackageManager(cfg *config.BuilderConfig) PackageManager {
 	return NewPackageManager(cfg)
 }
 
-// initJobStore initializes the job store and loads persisted jobs.
-func initJobStore(cfg *config.BuilderConfig) *JobStore {
+// initJobStore initializes the job store selected by JOB_STORE: the JSON file
+// store by default, or SQLite.
+func initJobStore(cfg *config.BuilderConfig) JobStorage {
 	if cfg == nil || !cfg.PersistenceEnabled {
 		return nil
 	}
@@ -452,6 +778,15 @@ func initJobStore(cfg *config.BuilderConfig) *JobStore {
 		dataDir = "/var/lib/portage-engine"
 	}
 
+	if cfg.JobStore == jobStoreSQLite {
+		store, err := NewSQLiteJobStore(dataDir)
+		if err != nil {
+			log.Printf("Failed to initialize SQLite job store: %v (persistence disabled)", err)
+			return nil
+		}
+		return store
+	}
+
 	jobStore, err := NewJobStore(dataDir)
 	if err != nil {
 		log.Printf("Failed to initialize job store: %v (persistence disabled)", err)
@@ -464,7 +799,7 @@ func initJobStore(cfg *config.BuilderConfig) *JobStore {
 // reconcileLoadedJobs marks any persisted jobs that were left in the
 // "building" state (e.g. due to a crash) as "failed", since the build
 // process did not survive the restart. The reconciled state is persisted.
-func reconcileLoadedJobs(jobStore *JobStore, jobs map[string]*BuildJob) {
+func reconcileLoadedJobs(jobStore JobStorage, jobs map[string]*BuildJob) {
 	reconciled := 0
 	for _, job := range jobs {
 		if job.Status == "building" || job.Status == "queued" {
@@ -489,19 +824,34 @@ func reconcileLoadedJobs(jobStore *JobStore, jobs map[string]*BuildJob) {
 	}
 }
 
-// generateInstanceID generates or retrieves the instance ID.
+// generateInstanceID generates or retrieves the instance ID. Without an
+// explicit INSTANCE_ID it is "<hostname>-<port>", so several builders on one
+// host (necessarily on different ports) get distinct IDs.
 func generateInstanceID(cfg *config.BuilderConfig) string {
 	if cfg != nil && cfg.InstanceID != "" {
 		return cfg.InstanceID
 	}
 
 	if hostname, err := os.Hostname(); err == nil {
+		if cfg != nil && cfg.Port > 0 {
+			return fmt.Sprintf("%s-%d", hostname, cfg.Port)
+		}
 		return hostname
 	}
 
 	return uuid.New().String()[:8]
 }
 
+// InstanceID returns the ID this builder registers under with the server.
+func (lb *LocalBuilder) InstanceID() string {
+	return lb.instanceID
+}
+
+// Architecture returns the Gentoo arch this builder builds for.
+func (lb *LocalBuilder) Architecture() string {
+	return lb.architecture
+}
+
 // getArchitecture detects or retrieves the system architecture.
 func getArchitecture(cfg *config.BuilderConfig) string {
 	if cfg != nil && cfg.Architecture != "" {
@@ -517,7 +867,20 @@ func (lb *LocalBuilder) SubmitBuild(req *LocalBuildRequest) (string, error) {
 	// config-bundle executor). This is the single choke point that closes shell
 	// injection and emerge option injection.
 	if err := validateLocalBuildRequest(req); err != nil {
-		return "", fmt.Errorf("invalid build request: %w", err)
+		return "", fmt.Errorf("%w: %w", ErrInvalidBuildRequest, err)
+	}
+	if lb.resultCacheEnabled() && !req.ForceRebuild && !req.Pretend {
+		if key := lb.resultCacheKey(req); key != "" {
+			if from, ok := lb.cachedResult(key); ok {
+				return lb.submitCachedBuild(req, from), nil
+			}
+		}
+	}
+	if err := lb.spotInterrupted(); err != nil {
+		return "", err
+	}
+	if lb.Draining() {
+		return "", fmt.Errorf("%w; submit the build elsewhere", ErrShuttingDown)
 	}
 
 	jobID := uuid.New().String()
@@ -527,32 +890,42 @@ func (lb *LocalBuilder) SubmitBuild(req *LocalBuildRequest) (string, error) {
 		Request:   req,
 		Status:    "queued",
 		StartTime: time.Now(),
-		Metadata:  make(map[string]interface{}),
+		Metadata:  map[string]interface{}{"build_timeout": formatTimeout(lb.buildTimeout(req))},
+		RequestID: req.RequestID,
+		redactor:  lb.jobRedactor(req),
 	}
 
 	lb.jobsMutex.Lock()
 	lb.jobs[jobID] = job
 	lb.jobsMutex.Unlock()
+	// Store the queued row before a worker can pick the job up, so it never
+	// overwrites the worker's later transitions.
+	store := lb.sqliteJobs()
+	if store != nil {
+		if err := store.SaveJob(jobID, job.Clone(), nil); err != nil {
+			log.Printf("Failed to save job %s: %v", jobID, err)
+		}
+	}
 
-	// Non-blocking send: if the queue is full, reject the job instead of
-	// blocking the calling (HTTP handler) goroutine indefinitely.
-	select {
-	case lb.jobQueue <- job:
-		return jobID, nil
-	default:
+	// If the queue is full, reject the job instead of blocking the calling
+	// (HTTP handler) goroutine indefinitely.
+	if err := lb.jobQueue.push(job, req.Priority); err != nil {
 		lb.jobsMutex.Lock()
 		delete(lb.jobs, jobID)
 		lb.jobsMutex.Unlock()
-		return "", fmt.Errorf("builder queue full")
+		if store != nil {
+			if err := store.DeleteJob(jobID); err != nil {
+				log.Printf("Failed to remove rejected job %s: %v", jobID, err)
+			}
+		}
+		return "", err
 	}
+	return jobID, nil
 }
 
 // GetJobStatus returns the status of a build job.
 func (lb *LocalBuilder) GetJobStatus(jobID string) (*BuildJob, error) {
-	lb.jobsMutex.RLock()
-	defer lb.jobsMutex.RUnlock()
-
-	job, exists := lb.jobs[jobID]
+	job, exists := lb.findJob(jobID)
 	if !exists {
 		return nil, fmt.Errorf("job not found: %s", jobID)
 	}
@@ -562,18 +935,91 @@ func (lb *LocalBuilder) GetJobStatus(jobID string) (*BuildJob, error) {
 	return job.Clone(), nil
 }
 
-// ListJobs returns all build jobs.
-func (lb *LocalBuilder) ListJobs() []*BuildJob {
+// StreamJobLog subscribes to a job's log as it is written.
+func (lb *LocalBuilder) StreamJobLog(jobID string) (*LogStream, error) {
+	job, exists := lb.findJob(jobID)
+	if !exists {
+		return nil, fmt.Errorf("job not found: %s", jobID)
+	}
+	return job.subscribeLog(), nil
+}
+
+// findJob returns the live job jobID or, with the SQLite store, the finished
+// job read back from the database.
+func (lb *LocalBuilder) findJob(jobID string) (*BuildJob, bool) {
 	lb.jobsMutex.RLock()
-	defer lb.jobsMutex.RUnlock()
+	job, exists := lb.jobs[jobID]
+	lb.jobsMutex.RUnlock()
+	if exists {
+		return job, true
+	}
+	store := lb.sqliteJobs()
+	if store == nil {
+		return nil, false
+	}
+	job, exists, err := store.Job(jobID)
+	if err != nil {
+		log.Printf("Failed to look up job %s: %v", jobID, err)
+	}
+	return job, exists
+}
+
+// ListJobs returns all build jobs, newest first.
+func (lb *LocalBuilder) ListJobs() []*BuildJob {
+	jobs, _, err := lb.ListJobsPage(JobQuery{})
+	if err != nil {
+		log.Printf("Failed to list jobs: %v", err)
+	}
+	return jobs
+}
+
+// ListJobsPage returns the page of jobs q selects, in q.Order, and the
+// number of jobs matching q. With the SQLite store the page is queried from
+// the database, with live jobs taking their in-memory state.
+func (lb *LocalBuilder) ListJobsPage(q JobQuery) ([]*BuildJob, int, error) {
+	if store := lb.sqliteJobs(); store != nil {
+		jobs, total, err := store.ListJobs(q)
+		if err != nil {
+			return nil, 0, err
+		}
+		lb.jobsMutex.RLock()
+		for i, job := range jobs {
+			if live, ok := lb.jobs[job.ID]; ok {
+				jobs[i] = live.Clone()
+			}
+		}
+		lb.jobsMutex.RUnlock()
+		return jobs, total, nil
+	}
 
+	lb.jobsMutex.RLock()
 	jobs := make([]*BuildJob, 0, len(lb.jobs))
 	for _, job := range lb.jobs {
 		// Clone so concurrent worker writes don't race the caller's reads.
-		jobs = append(jobs, job.Clone())
+		job := job.Clone()
+		if q.Status == "" || job.Status == q.Status {
+			jobs = append(jobs, job)
+		}
 	}
+	lb.jobsMutex.RUnlock()
 
-	return jobs
+	sort.Slice(jobs, func(i, j int) bool {
+		return q.Order.less(jobSortFields(jobs[i]), jobSortFields(jobs[j]))
+	})
+	total := len(jobs)
+	start := min(max(q.Offset, 0), total)
+	end := total
+	if q.Limit > 0 {
+		end = min(start+q.Limit, total)
+	}
+	return jobs[start:end], total, nil
+}
+
+// sqliteJobs returns the SQLite job store, or nil when jobs are kept in
+// memory (and, if persistence is on, the JSON file).
+func (lb *LocalBuilder) sqliteJobs() *SQLiteJobStore {
+	store, _ := lb.jobStore.(*SQLiteJobStore)
+	return store
 }
 
 // jobsSnapshot returns a deep copy of the jobs map for persistence. Clone()
@@ -589,11 +1035,26 @@ func (lb *LocalBuilder) jobsSnapshot() map[string]*BuildJob {
 	return out
 }
 
-// Shutdown gracefully shuts down the builder and persists jobs.
+// Shutdown gracefully shuts down the builder: it drains the workers for up
+// to SHUTDOWN_GRACE_PERIOD (see Drain) and persists the final job state.
 func (lb *LocalBuilder) Shutdown() {
+	lb.Drain(lb.shutdownGracePeriod())
+	if lb.stop != nil {
+		lb.stopOnce.Do(func() { close(lb.stop) })
+	}
 	if lb.persister != nil {
 		lb.persister.Stop()
 	}
+	if store := lb.sqliteJobs(); store != nil {
+		if err := store.Close(); err != nil {
+			log.Printf("Failed to close job database: %v", err)
+		}
+	}
+	if idx, ok := lb.artifactIndex.(*binpkg.SQLiteArtifactIndex); ok {
+		if err := idx.Close(); err != nil {
+			log.Printf("Failed to close artifact database: %v", err)
+		}
+	}
 }
 
 // ActiveJobs returns the number of jobs currently queued or building, for
@@ -614,12 +1075,11 @@ func (lb *LocalBuilder) ActiveJobs() int {
 // GetStatus returns a snapshot of the builder's job counts and mode.
 func (lb *LocalBuilder) GetStatus() map[string]interface{} {
 	lb.jobsMutex.RLock()
-	defer lb.jobsMutex.RUnlock()
-
 	queued := 0
 	building := 0
 	completed := 0
 	failed := 0
+	cancelled := 0
 
 	for _, job := range lb.jobs {
 		switch job.Status {
@@ -627,10 +1087,29 @@ func (lb *LocalBuilder) GetStatus() map[string]interface{} {
 			queued++
 		case "building":
 			building++
-		case "success":
+		case "success", "success_no_artifact":
 			completed++
 		case "failed":
 			failed++
+		case cancelledStatus:
+			cancelled++
+		}
+	}
+	total := len(lb.jobs)
+	lb.jobsMutex.RUnlock()
+
+	// Finished jobs live in the database rather than in memory.
+	if store := lb.sqliteJobs(); store != nil {
+		if counts, err := store.StatusCounts(); err != nil {
+			log.Printf("Failed to count stored jobs: %v", err)
+		} else {
+			completed = counts["success"] + counts["success_no_artifact"]
+			failed = counts["failed"]
+			cancelled = counts[cancelledStatus]
+			total = 0
+			for _, n := range counts {
+				total += n
+			}
 		}
 	}
 
@@ -642,9 +1121,14 @@ func (lb *LocalBuilder) GetStatus() map[string]interface{} {
 	if building >= lb.workers {
 		status = "busy"
 	}
+	if lb.Draining() {
+		status = "draining"
+	}
 
-	return map[string]interface{}{
+	result := map[string]interface{}{
 		"instance_id":    lb.instanceID,
+		"version":        version.Version,
+		"api_version":    APIVersion,
 		"architecture":   lb.architecture,
 		"status":         status,
 		"workers":        lb.workers,
@@ -654,7 +1138,8 @@ func (lb *LocalBuilder) GetStatus() map[string]interface{} {
 		"building":       building,
 		"completed":      completed,
 		"failed":         failed,
-		"total":          len(lb.jobs),
+		"cancelled":      cancelled,
+		"total":          total,
 		"success_builds": completed,
 		"failed_builds":  failed,
 		"total_builds":   completed + failed,
@@ -669,79 +1154,218 @@ func (lb *LocalBuilder) GetStatus() map[string]interface{} {
 		"disk_total":     sysInfo.DiskTotal,
 		"disk_used":      sysInfo.DiskUsed,
 		"enabled":        true,
+		"gpg_signing":    lb.getGPGKeyID() != "",
+		"gpg_key_synced": lb.gpgKeySynced.Load(),
 	}
+	if lb.simulating() {
+		result["simulation_mode"] = true
+	}
+	for k, v := range lb.treeStatus() {
+		result[k] = v
+	}
+	if emulated := lb.EmulatedArchitectures(); len(emulated) > 0 {
+		result["emulated_architectures"] = emulated
+	}
+	if days, warning, ok := lb.keyExpiryStatus(); ok {
+		result["key_expires_in_days"] = days
+		if warning != "" {
+			result["key_expiry_warning"] = warning
+			lb.logKeyExpiryWarning(warning)
+		}
+	}
+	return result
 }
 
 // worker processes build jobs from the queue.
 func (lb *LocalBuilder) worker(id int) {
 	log.Printf("Worker %d started", id)
 
-	for job := range lb.jobQueue {
-		log.Printf("Worker %d processing job %s", id, job.ID)
+	for {
+		job, ok := lb.jobQueue.pop()
+		if !ok {
+			return
+		}
+		log.Printf("Worker %d processing job %s", id, job.ref())
+
+		if !lb.awaitDiskSpace(job) {
+			continue
+		}
+		releaseMemory, ok := lb.awaitMemory(job)
+		if !ok {
+			continue
+		}
 
 		job.mu.Lock()
+		if job.Status != "queued" {
+			// Cancelled, or failed by a spot interruption, while queued.
+			status := job.Status
+			job.mu.Unlock()
+			releaseMemory()
+			log.Printf("Worker %d skipping %s job %s", id, status, job.ref())
+			continue
+		}
 		job.Status = "building"
+		ctx, cancel := context.WithCancel(context.Background())
+		job.cancel = cancel
 		job.mu.Unlock()
 
+		lb.ensureFreshTree(job)
+
 		// Persist the "building" transition so a crash mid-build can be
 		// reconciled on the next startup instead of leaving a stuck job.
-		lb.saveJobState()
-
-		var err error
-		// Check if this is a new-style config bundle build
-		if job.Request.ConfigBundle != nil {
-			err = lb.executeConfigBundleBuild(job)
-		} else {
-			// Legacy build method
-			if lb.useDocker {
-				err = lb.executeDockerBuild(job)
-			} else {
-				err = lb.executeNativeBuild(job)
+		lb.saveJobState(job)
+
+		err := lb.buildExclusive(ctx, job, func(ctx context.Context, job *BuildJob) error {
+			lb.treeMu.RLock()
+			defer lb.treeMu.RUnlock()
+			err := lb.buildWithRetries(ctx, job, lb.executeBuild)
+			if err == nil {
+				lb.recordCacheKey(job)
 			}
-		}
+			return err
+		})
+		releaseMemory()
 
 		job.mu.Lock()
+		job.cancel = nil
+		cancelled := ctx.Err() != nil
+		cancel()
 		job.EndTime = time.Now()
-		if err != nil {
-			job.Status = "failed"
+		// Whatever the outcome, hand back the config changes autounmask made
+		// inside the (ephemeral) build environment so the user can keep them.
+		if changes := parseAutounmaskChanges(job.Log); changes != nil {
+			if job.Metadata == nil {
+				job.Metadata = map[string]interface{}{}
+			}
+			job.Metadata["suggested_config"] = changes
+		}
+		if cancelled && err != nil && job.interruption != "" {
+			markInterrupted(job, job.interruptCategory, job.interruption)
+			log.Printf("Worker %d: Job %s failed: %s", id, job.ref(), job.interruption)
+		} else if cancelled && err != nil {
+			// A build that finished before the cancellation took effect
+			// keeps its result.
+			job.Status = cancelledStatus
+			job.Error = "build cancelled"
+			log.Printf("Worker %d: Job %s cancelled", id, job.ref())
+		} else if errors.Is(err, errPretended) {
+			job.Status = "success_no_artifact"
 			job.Error = err.Error()
+			if job.Metadata == nil {
+				job.Metadata = map[string]interface{}{}
+			}
+			job.Metadata["pretend"] = true
+			log.Printf("Worker %d: Job %s resolved (pretend)", id, job.ref())
+		} else if errors.Is(err, errNoArtifact) {
+			job.Status = "success_no_artifact"
+			job.Error = err.Error() + "; nothing to download (check that the package is not a virtual/meta package and that FEATURES includes buildpkg)"
+			if job.Metadata == nil {
+				job.Metadata = map[string]interface{}{}
+			}
+			job.Metadata["no_artifact"] = true
+			log.Printf("Worker %d: Job %s completed without an artifact", id, job.ref())
+		} else if err != nil {
+			job.Status = "failed"
+			// A timeout is reported as such, not as whatever wrapped the
+			// killed process's error.
+			var timedOut *buildTimeoutError
+			if errors.As(err, &timedOut) {
+				err = timedOut
+			}
+			// Lead with actionable guidance when emerge gave up on a masked
+			// package; the raw emerge output is still appended below.
+			if masked := parseMaskedPackages(job.Log); len(masked) > 0 {
+				err = fmt.Errorf("%s: %w", maskReport(masked), err)
+				if job.Metadata == nil {
+					job.Metadata = map[string]interface{}{}
+				}
+				job.Metadata["masked_packages"] = masked
+				if licenses := missingLicenses(masked); len(licenses) > 0 {
+					job.Metadata["licenses_required"] = licenses
+				}
+			}
+			if resolution := parseResolutionErrors(job.Log); len(resolution) > 0 {
+				if job.Metadata == nil {
+					job.Metadata = map[string]interface{}{}
+				}
+				job.Metadata["resolution_errors"] = resolution
+			}
+			if category := FailureCategory(err); category != "" {
+				if job.Metadata == nil {
+					job.Metadata = map[string]interface{}{}
+				}
+				job.Metadata["failure_category"] = category
+				job.Metadata["retryable"] = RetryableFailure(category)
+			}
+			job.Error = job.redactor.redact(err.Error())
 			// Append log to error for visibility in API
 			if job.Log != "" {
 				job.Error = fmt.Sprintf("%s\n\nBuild Log:\n%s", job.Error, job.Log)
 			}
-			log.Printf("Worker %d: Job %s failed: %v", id, job.ID, err)
+			log.Printf("Worker %d: Job %s failed: %v", id, job.ref(), err)
 		} else {
 			job.Status = "success"
-			log.Printf("Worker %d: Job %s completed successfully", id, job.ID)
+			lb.cacheResult(job)
+			log.Printf("Worker %d: Job %s completed successfully", id, job.ref())
 		}
+		job.logSubs.closeAll()
 		job.mu.Unlock()
 
-		// Persist job state immediately after completion
-		lb.saveJobState()
+		lb.retireJob(job)
+		lb.checkDiskWatermark()
+	}
+}
 
-		// Notify asynchronously: notification channels (SMTP/webhook/Slack/
-		// Telegram) run serially with timeouts up to ~30s each, which must not
-		// stall the build worker. A clone is passed so the notifier never races
-		// with later writes to the live job.
-		go lb.sendNotification(job.Clone())
+// retireJob persists a job that just finished and notifies about it. A
+// finished job stored in SQLite is served from there, so it leaves memory.
+func (lb *LocalBuilder) retireJob(job *BuildJob) {
+	if lb.saveJobState(job) {
+		lb.jobsMutex.Lock()
+		delete(lb.jobs, job.ID)
+		lb.jobsMutex.Unlock()
 	}
+
+	// Notify asynchronously: notification channels (SMTP/webhook/Slack/
+	// Telegram) run serially with timeouts up to ~30s each, which must not
+	// stall the build worker. A clone is passed so the notifier never races
+	// with later writes to the live job.
+	go lb.sendNotification(job.Clone())
 }
 
-// saveJobState saves the current job state to persistent storage.
-func (lb *LocalBuilder) saveJobState() {
+// saveJobState saves job's state transition to persistent storage: just that
+// job with the SQLite store, the whole job map with the JSON file. It reports
+// whether job was written to SQLite.
+func (lb *LocalBuilder) saveJobState(job *BuildJob) bool {
+	if store := lb.sqliteJobs(); store != nil {
+		if err := store.SaveJob(job.ID, job.Clone(), nil); err != nil {
+			log.Printf("Failed to save job state: %v", err)
+			return false
+		}
+		return true
+	}
 	if lb.persister != nil {
 		if err := lb.persister.SaveNow(); err != nil {
 			log.Printf("Failed to save job state: %v", err)
 		}
 	}
+	return false
 }
 
-// executeConfigBundleBuild executes a build using configuration bundle.
-func (lb *LocalBuilder) executeConfigBundleBuild(job *BuildJob) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+// executeConfigBundleBuild executes a build using configuration bundle, in
+// target's container when it runs in Docker.
+func (lb *LocalBuilder) executeConfigBundleBuild(ctx context.Context, job *BuildJob, target crossTarget) error {
+	ctx, cancel, timeout := lb.buildContext(ctx, job)
 	defer cancel()
 
 	bundle := job.Request.ConfigBundle
+	if bundle == nil {
+		arch := job.Request.Arch
+		if arch == "" {
+			arch = lb.architecture
+		}
+		bundle = &ConfigBundle{Config: &PortageConfig{}, Metadata: BundleMetadata{TargetArch: arch}}
+		job.Request.ConfigBundle = bundle
+	}
 
 	// If no package specs provided, create from legacy request
 	if bundle.Packages == nil || len(bundle.Packages.Packages) == 0 {
@@ -767,18 +1391,43 @@ func (lb *LocalBuilder) executeConfigBundleBuild(job *BuildJob) error {
 		}
 	}
 
+	// An ACCEPT_LICENSE in the bundle's own environment wins; otherwise apply
+	// the request's or the builder's.
+	if license := lb.acceptLicense(job.Request); license != "" {
+		if bundle.Config == nil {
+			bundle.Config = &PortageConfig{}
+		}
+		if _, ok := bundle.Config.Environment["ACCEPT_LICENSE"]; !ok {
+			if bundle.Config.Environment == nil {
+				bundle.Config.Environment = map[string]string{}
+			}
+			bundle.Config.Environment["ACCEPT_LICENSE"] = license
+		}
+	}
+
 	var err error
 	if lb.useDocker {
-		err = lb.dockerExecutor.ExecuteBuild(ctx, bundle, job)
+		err = lb.dockerExecutor.executeBuildOn(ctx, bundle, job, target)
 	} else {
 		err = lb.executor.ExecuteBuild(ctx, bundle, job)
 	}
+	// Sign whatever was built, including the packages of a partial build.
+	lb.signBundleArtifacts(job)
+	if rels := job.artifactsSnapshot(); len(rels) > 0 {
+		lb.writeArtifactChecksums(job, rels)
+		lb.writeArtifactProvenance(job, rels)
+		lb.compressArtifacts(job, rels)
+		lb.storeArtifactBlobs(job, rels)
+		lb.indexArtifacts(job, rels)
+		lb.updateBinhostIndex(job)
+	}
 
-	return err
+	return timeoutError(ctx, timeout, err)
 }
 
-// generateBuildScript creates a Gentoo build script for Docker container.
-func (lb *LocalBuilder) generateBuildScript(pkgAtom string, useFlags string, gpgKeyID string) string {
+// generateBuildScript creates a Gentoo build script for Docker container,
+// running as parallel as jobs says.
+func (lb *LocalBuilder) generateBuildScript(pkgAtom, useFlags, gpgKeyID, acceptLicense string, jobs jobControl) string {
 	features := "buildpkg"
 	buildFeatures := "-userpriv -usersandbox"
 	if lb.cfg != nil && lb.cfg.BuildFeatures != "" {
@@ -842,16 +1491,52 @@ if [ -f /gpg-keys/public.asc ]; then
     gpg --homedir /etc/portage/gnupg --with-colons --list-keys 2>/dev/null | awk -F: '/^fpr:/{print $10":6:"}' | gpg --homedir /etc/portage/gnupg --batch --yes --import-ownertrust 2>/dev/null || true
 fi
 `, gpgKeyID, gpgKeyID, buildFeaturesLine)
+		gpgSetup = "\n" + gpgSetupBegin + gpgSetup + gpgSetupEnd + "\n"
+	}
+
+	licenseLine := ""
+	if acceptLicense != "" {
+		licenseLine = fmt.Sprintf("export ACCEPT_LICENSE=\"%s\"\n", acceptLicense)
+	}
+	// Set before the distcc setup, which sizes MAKEOPTS to its hosts instead.
+	makeOptsLine := ""
+	if jobs.makeOpts != "" {
+		makeOptsLine = fmt.Sprintf("export MAKEOPTS=\"%s\"\n", jobs.makeOpts)
+	}
+
+	// An atom may hold shell metacharacters (>=, *, [use]); the atom grammar
+	// excludes quotes, so single quotes pass it to emerge verbatim.
+	emergeAtom := "'" + pkgAtom + "'"
+
+	// Build emerge command with automatic dependency conflict resolution. It
+	// must never prompt (there is no TTY), and autounmask must not accept
+	// licenses on the user's behalf: only ACCEPT_LICENSE grants them.
+	emergeOpts := "--ask=n --usepkg=n --autounmask --autounmask-write --autounmask-license=n --autounmask-continue --backtrack=50"
+	if jobs.emergeJobs != "" {
+		emergeOpts += " " + jobs.emergeJobs
 	}
 
-	// Build emerge command with automatic dependency conflict resolution
-	emergeOpts := "--usepkg=n --autounmask --autounmask-write --autounmask-continue --backtrack=50"
+	// With separate fetch, download the sources first and mark a failure so
+	// runDockerBuild can tell it from a compile failure; both phases report
+	// their wall time for the job metadata.
+	fetchPhase, compileTiming := "", ""
+	if lb.separateFetch() {
+		fetchPhase = fmt.Sprintf(`
+SECONDS=0
+if ! emerge --fetchonly %s %s; then
+    echo "%s"
+    exit 1
+fi
+echo "[phase] fetch took ${SECONDS}s"
+`, emergeOpts, emergeAtom, fetchFailedMarker)
+		compileTiming = `echo "[phase] compile took ${SECONDS}s"`
+	}
 
 	return fmt.Sprintf(`#!/bin/bash
 set -e
 export USE="%s"
 export FEATURES="%s"
-
+%s%s
 # /etc/portage is bind-mounted read-only at /tmp/pconf; copy it to a writable
 # /etc/portage so signing config and getuto's trust store can be created.
 if [ -d /tmp/pconf ]; then
@@ -859,10 +1544,13 @@ if [ -d /tmp/pconf ]; then
     cp -a /tmp/pconf/. /etc/portage/ 2>/dev/null || true
 fi
 %s
+%s
+%s
 echo "Starting Gentoo package build for %s"
-
+%s
 # Run emerge with automatic dependency resolution
 # First attempt: try with autounmask options
+SECONDS=0
 if ! emerge %s %s; then
     echo "First emerge attempt failed, applying autounmask changes..."
     # Dispatch any pending config updates
@@ -871,15 +1559,44 @@ if ! emerge %s %s; then
     # Retry emerge after applying changes
     emerge %s %s || exit 1
 fi
+%s
+%s
 
 echo "Build completed, copying artifacts..."
 cd /var/cache/binpkgs && find . -type f \( -name '*.gpkg.tar' -o -name '*.tbz2' \) | while read -r f; do rel="${f#./}"; mkdir -p "/output/$(dirname "$rel")"; cp "$f" "/output/$rel"; done; cd /
 ls -lh /output/
-`, useFlags, features, gpgSetup, pkgAtom, emergeOpts, pkgAtom, emergeOpts, pkgAtom)
+`, useFlags, features, licenseLine, makeOptsLine, gpgSetup, lb.ccacheScriptSetup(), lb.distccScriptSetup(), pkgAtom, fetchPhase, emergeOpts, emergeAtom, emergeOpts, emergeAtom, compileTiming, lb.ccacheScriptStats())
+}
+
+// executeBuild runs job's build with the method its request calls for.
+func (lb *LocalBuilder) executeBuild(ctx context.Context, job *BuildJob) error {
+	if lb.simulating() {
+		return lb.executeSimulatedBuild(ctx, job)
+	}
+	target, err := lb.crossTargetFor(job)
+	if err != nil {
+		return err
+	}
+	if target.platform != "" && !lb.useDocker {
+		return fmt.Errorf("building for arch %s on this %s builder needs the Docker executor", target.arch, lb.architecture)
+	}
+	lb.recordCrossTarget(job, target)
+
+	// Check if this is a new-style config bundle build; a pretend build of a
+	// legacy request runs as one too.
+	if job.Request.ConfigBundle != nil || job.Request.Pretend {
+		return lb.executeConfigBundleBuild(ctx, job, target)
+	}
+	// Legacy build method
+	if lb.useDocker {
+		return lb.executeDockerBuild(ctx, job, target)
+	}
+	return lb.executeNativeBuild(ctx, job)
 }
 
-// executeDockerBuild performs the build using Docker container.
-func (lb *LocalBuilder) executeDockerBuild(job *BuildJob) error {
+// executeDockerBuild performs the build using Docker container, in target's
+// container for a build for another arch.
+func (lb *LocalBuilder) executeDockerBuild(ctx context.Context, job *BuildJob, target crossTarget) error {
 	jobWorkDir, err := lb.prepareJobWorkDir(job.ID)
 	if err != nil {
 		return err
@@ -887,18 +1604,23 @@ func (lb *LocalBuilder) executeDockerBuild(job *BuildJob) error {
 	defer func() { _ = os.RemoveAll(jobWorkDir) }()
 
 	script := lb.prepareDockerBuildScript(job)
+	job.setScript(script)
 	outputDir := filepath.Join(jobWorkDir, "output")
 	_ = os.MkdirAll(outputDir, 0750)
 
 	gpgKeyDir := lb.prepareGPGKeys(jobWorkDir)
-	args := lb.buildDockerArgs(outputDir, gpgKeyDir)
-	args = append(args, lb.dockerImage, "/bin/bash", "-c", script)
+	image := lb.dockerImage
+	if target.image != "" {
+		image = target.image
+	}
+	args := lb.buildDockerArgs(outputDir, gpgKeyDir, lb.distccHostsForJob(job), target.platform)
+	args = append(args, image, "/bin/bash", "-c", script)
 
-	if err := lb.runDockerBuild(job, args); err != nil {
+	if err := lb.runDockerBuild(ctx, job, args); err != nil {
 		return err
 	}
 
-	return lb.collectAndUploadArtifact(job, outputDir)
+	return lb.collectAndUploadArtifact(ctx, job, outputDir)
 }
 
 // prepareJobWorkDir creates and returns the job-specific work directory.
@@ -913,15 +1635,24 @@ func (lb *LocalBuilder) prepareJobWorkDir(jobID string) (string, error) {
 // prepareDockerBuildScript generates the build script for Docker.
 func (lb *LocalBuilder) prepareDockerBuildScript(job *BuildJob) string {
 	req := job.Request
-	pkgAtom := req.PackageName
-	if req.Version != "" {
-		pkgAtom = fmt.Sprintf("=%s-%s", req.PackageName, req.Version)
-	}
+	pkgAtom := emergeTarget(req.PackageName, req.Version)
 
 	useFlags := buildUseFlagsString(req.UseFlags)
 	gpgKeyID := lb.getGPGKeyID()
 
-	return lb.generateBuildScript(pkgAtom, useFlags, gpgKeyID)
+	return lb.generateBuildScript(pkgAtom, useFlags, gpgKeyID, lb.acceptLicense(req), lb.jobControlFor(job))
+}
+
+// acceptLicense returns the ACCEPT_LICENSE for a build: the request's own,
+// else the builder-wide setting, else "" (keep make.conf/profile's value).
+func (lb *LocalBuilder) acceptLicense(req *LocalBuildRequest) string {
+	if req.AcceptLicense != "" {
+		return req.AcceptLicense
+	}
+	if lb.cfg != nil {
+		return lb.cfg.AcceptLicense
+	}
+	return ""
 }
 
 // buildUseFlagsString constructs the USE flags string.
@@ -968,8 +1699,10 @@ func (lb *LocalBuilder) prepareGPGKeys(jobWorkDir string) string {
 	return gpgKeyDir
 }
 
-// buildDockerArgs constructs the Docker run arguments.
-func (lb *LocalBuilder) buildDockerArgs(outputDir, gpgKeyDir string) []string {
+// buildDockerArgs constructs the Docker run arguments. distccHosts are the
+// reachable distcc hosts the build may use; platform, when set, is the
+// container platform of a build for another arch.
+func (lb *LocalBuilder) buildDockerArgs(outputDir, gpgKeyDir string, distccHosts []distccHost, platform string) []string {
 	args := []string{"--rm", "-i", "-v", outputDir + ":/output"}
 
 	if gpgKeyDir != "" {
@@ -978,11 +1711,18 @@ func (lb *LocalBuilder) buildDockerArgs(outputDir, gpgKeyDir string) []string {
 
 	if lb.cfg != nil {
 		args = lb.addPackageManagerMounts(args)
-		args = lb.addEnvironmentVars(args)
+		args = lb.addEnvironmentVars(args, distccHosts)
+		args = lb.addCCacheMount(args)
 	} else {
 		args = lb.addDefaultGentooMounts(args)
 	}
 
+	if lb.containerRuntime != nil {
+		args = lb.containerRuntime.BuildRunArgs(args)
+	}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
 	return args
 }
 
@@ -995,12 +1735,16 @@ func (lb *LocalBuilder) addPackageManagerMounts(args []string) []string {
 	return args
 }
 
-// addEnvironmentVars adds package manager specific environment variables.
-func (lb *LocalBuilder) addEnvironmentVars(args []string) []string {
+// addEnvironmentVars adds package manager specific environment variables,
+// and the distcc settings when distccHosts is not empty.
+func (lb *LocalBuilder) addEnvironmentVars(args []string, distccHosts []distccHost) []string {
 	envVars := lb.pkgMgr.GetEnvVars(lb.cfg)
 	for k, v := range envVars {
 		args = append(args, "-e", fmt.Sprintf("%s=%s", k, v))
 	}
+	for k, v := range distccEnv(distccHosts) {
+		args = append(args, "-e", fmt.Sprintf("%s=%s", k, v))
+	}
 	return args
 }
 
@@ -1014,29 +1758,46 @@ func (lb *LocalBuilder) addDefaultGentooMounts(args []string) []string {
 }
 
 // runDockerBuild executes the Docker build command.
-func (lb *LocalBuilder) runDockerBuild(job *BuildJob, args []string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+func (lb *LocalBuilder) runDockerBuild(ctx context.Context, job *BuildJob, args []string) error {
+	ctx, cancel, timeout := lb.buildContext(ctx, job)
 	defer cancel()
 
-	output, err := lb.containerRuntime.Run(ctx, args)
-	job.setLog(string(output))
+	// Name the container so it can be stopped: killing the client on
+	// cancellation or timeout leaves the container itself running.
+	containerName := "portage-build-" + job.ID
+	args = append([]string{"--name", containerName}, args...)
+
+	// Stream the container output into the job log as it arrives, keeping a
+	// copy for the ccache and phase markers parsed below.
+	var buf bytes.Buffer
+	logw := &jobLogWriter{job: job}
+	err := lb.containerRuntime.RunStream(ctx, args, io.MultiWriter(&buf, logw))
+	logw.Flush()
+	if ctx.Err() != nil {
+		stopCtx, stop := context.WithTimeout(context.WithoutCancel(ctx), containerStopTimeout)
+		_ = lb.containerRuntime.Stop(stopCtx, containerName)
+		stop()
+	}
+	output := buf.Bytes()
+	recordCCacheStats(job, scriptCCacheStats(string(output)))
+	err = recordScriptPhases(job, string(output), err)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Printf("Container build timed out for job %s after %s", job.ref(), timeout)
+		return timeoutError(ctx, timeout, err)
+	}
 
 	if err != nil {
-		log.Printf("Container build failed for job %s: %v", job.ID, err)
+		log.Printf("Container build failed for job %s: %v", job.ref(), err)
 		return fmt.Errorf("container build failed: %w", err)
 	}
 
-	log.Printf("Container build completed for job %s, output size: %d bytes", job.ID, len(output))
+	log.Printf("Container build completed for job %s, output size: %d bytes", job.ref(), len(output))
 	return nil
 }
 
 // collectAndUploadArtifact finds, copies, signs, and uploads the artifact.
-func (lb *LocalBuilder) collectAndUploadArtifact(job *BuildJob, outputDir string) error {
-	// Ensure filesystem is synced
-	_ = exec.Command("sync").Run()
-	time.Sleep(10 * time.Second)
-
-	rels, err := lb.waitForArtifacts(outputDir)
+func (lb *LocalBuilder) collectAndUploadArtifact(ctx context.Context, job *BuildJob, outputDir string) error {
+	rels, err := waitForArtifacts(ctx, outputDir, lb.artifactWaitTimeout())
 	if err != nil {
 		return err
 	}
@@ -1047,21 +1808,20 @@ func (lb *LocalBuilder) collectAndUploadArtifact(job *BuildJob, outputDir string
 		if err := os.MkdirAll(filepath.Dir(dest), 0750); err != nil {
 			return fmt.Errorf("failed to create artifact dir: %w", err)
 		}
+		// The old file may be a link to a blob another job uses: replace it
+		// rather than overwriting it in place.
+		_ = os.Remove(dest)
 		if err := exec.Command("cp", filepath.Join(outputDir, rel), dest).Run(); err != nil {
 			return fmt.Errorf("failed to copy artifact %s: %w", rel, err)
 		}
 	}
 
-	primary := primaryArtifact(rels, job.Request.PackageName, func(rel string) int64 {
-		if info, err := os.Stat(filepath.Join(lb.artifactDir, rel)); err == nil {
-			return info.Size()
-		}
-		return 0
-	})
+	primary := primaryArtifact(lb.artifactDir, rels, job.Request.PackageName, lb.cfg != nil && lb.cfg.ArtifactDebug)
 	destPath := filepath.Join(lb.artifactDir, primary)
 
 	job.setArtifactURL(destPath)
 	job.setArtifacts(rels)
+	recordTargetExpansion(job, rels)
 
 	// In native mode the gpkg is already signed in-emerge (binpkg-signing);
 	// detect that rather than adding a redundant detached signature. In docker
@@ -1073,14 +1833,59 @@ func (lb *LocalBuilder) collectAndUploadArtifact(job *BuildJob, outputDir string
 		job.Metadata["signed"] = true
 	} else {
 		for _, rel := range rels {
-			lb.signArtifact(job, filepath.Join(lb.artifactDir, rel))
+			lb.signArtifact(job, rel)
 		}
 	}
+	lb.writeArtifactChecksums(job, rels)
+	lb.writeArtifactProvenance(job, rels)
+	lb.compressArtifacts(job, rels)
+	lb.storeArtifactBlobs(job, rels)
+	lb.indexArtifacts(job, rels)
+	lb.updateBinhostIndex(job)
 	lb.uploadArtifact(job, destPath)
 
 	return nil
 }
 
+// updateBinhostIndex refreshes the Packages index in the artifact dir after a
+// build added packages to it, so the dir can be used as a PORTAGE_BINHOST or
+// PKGDIR as is. A failure is logged but does not fail the build.
+func (lb *LocalBuilder) updateBinhostIndex(job *BuildJob) {
+	if err := binpkg.GenerateBinhostIndex(lb.artifactDir); err != nil {
+		log.Printf("Warning: failed to update Packages index for job %s: %v", job.ref(), err)
+		job.appendLog(fmt.Sprintf("Warning: failed to update Packages index: %v\n", err))
+	}
+}
+
+// recordTargetExpansion notes in the job metadata which concrete packages a
+// set or virtual target resolved to, derived from the binpkgs it produced.
+func recordTargetExpansion(job *BuildJob, rels []string) {
+	target := job.Request.PackageName
+	kind := ""
+	switch {
+	case isPackageSet(target):
+		kind = "set"
+	case isVirtual(target):
+		kind = "virtual"
+	default:
+		return
+	}
+	expanded := make([]string, 0, len(rels))
+	for _, rel := range rels {
+		expanded = append(expanded, artifactCPV(rel))
+	}
+	sort.Strings(expanded)
+
+	job.mu.Lock()
+	if job.Metadata == nil {
+		job.Metadata = map[string]interface{}{}
+	}
+	job.Metadata["target_type"] = kind
+	job.Metadata["expanded_to"] = expanded
+	job.mu.Unlock()
+	job.appendLog(fmt.Sprintf("%s %s expanded to: %s\n", kind, target, strings.Join(expanded, " ")))
+}
+
 // gpkgIsSigned reports whether a .gpkg.tar carries an embedded OpenPGP
 // signature (a *.sig member), i.e. it was produced with binpkg-signing.
 func gpkgIsSigned(path string) bool {
@@ -1101,106 +1906,263 @@ func gpkgIsSigned(path string) bool {
 	}
 }
 
-// waitForArtifacts scans the container output dir for every produced binary
-// package, returning paths relative to outputDir (category preserved).
-func (lb *LocalBuilder) waitForArtifacts(outputDir string) ([]string, error) {
-	var rels []string
-	for i := 0; i < 10; i++ {
-		if i > 0 {
-			_ = exec.Command("sync").Run()
-			time.Sleep(2 * time.Second)
+// Artifact polling intervals: the first re-scan follows quickly, later ones
+// back off up to artifactPollMax.
+const (
+	artifactPollMin = 100 * time.Millisecond
+	artifactPollMax = 2 * time.Second
+	// defaultArtifactWaitTimeout applies when ARTIFACT_WAIT_TIMEOUT is unset.
+	defaultArtifactWaitTimeout = 30 * time.Second
+)
+
+// artifactWaitTimeout returns the configured bound on waitForArtifacts.
+func (lb *LocalBuilder) artifactWaitTimeout() time.Duration {
+	if lb.cfg != nil && lb.cfg.ArtifactWaitTimeout > 0 {
+		return lb.cfg.ArtifactWaitTimeout
+	}
+	return defaultArtifactWaitTimeout
+}
+
+// waitForArtifacts returns every binary package in the container output dir,
+// as paths relative to outputDir (category preserved). It scans at once and
+// then at backing-off intervals, returning as soon as packages are there
+// and two consecutive scans saw the same files with the same sizes, so a
+// package still being written is not picked up. It fails once timeout has
+// passed without that, or when ctx is done. Only an empty output dir is
+// errNoArtifact: packages that never settled fail the build instead.
+func waitForArtifacts(ctx context.Context, outputDir string, timeout time.Duration) ([]string, error) {
+	// Flush the container's writes through to the bind-mounted output dir.
+	_ = exec.Command("sync").Run()
+
+	deadline := time.Now().Add(timeout)
+	interval := artifactPollMin
+	var last map[string]int64
+	for {
+		sizes := scanArtifacts(outputDir)
+		if len(sizes) > 0 && maps.Equal(sizes, last) {
+			return slices.Sorted(maps.Keys(sizes)), nil
 		}
-		rels = rels[:0]
-		_ = filepath.Walk(outputDir, func(path string, info os.FileInfo, walkErr error) error {
-			if walkErr != nil || info.IsDir() {
-				return nil
-			}
-			name := filepath.Base(path)
-			if strings.HasSuffix(name, ".gpkg.tar") || strings.HasSuffix(name, ".tbz2") {
-				if rel, err := filepath.Rel(outputDir, path); err == nil {
-					rels = append(rels, rel)
-				}
-			}
-			return nil
-		})
-		if len(rels) > 0 {
-			return rels, nil
+		last = sizes
+
+		left := time.Until(deadline)
+		if left <= 0 {
+			break
 		}
-		if i < 4 {
-			log.Printf("No artifacts found on attempt %d, retrying...", i+1)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for artifacts: %w", ctx.Err())
+		case <-time.After(min(interval, left)):
 		}
+		interval = min(2*interval, artifactPollMax)
+	}
+	if len(last) > 0 {
+		return nil, fmt.Errorf("artifacts in %s still changing after %s", outputDir, timeout)
 	}
-	return nil, fmt.Errorf("no artifacts found in %s", outputDir)
+	return nil, fmt.Errorf("%w: no artifacts found in %s within %s", errNoArtifact, outputDir, timeout)
+}
+
+// scanArtifacts returns the size of every binary package under outputDir,
+// keyed by its path relative to outputDir.
+func scanArtifacts(outputDir string) map[string]int64 {
+	sizes := map[string]int64{}
+	_ = filepath.Walk(outputDir, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil || info.IsDir() {
+			return nil
+		}
+		name := filepath.Base(path)
+		if strings.HasSuffix(name, ".gpkg.tar") || strings.HasSuffix(name, ".tbz2") {
+			if rel, err := filepath.Rel(outputDir, path); err == nil {
+				sizes[rel] = info.Size()
+			}
+		}
+		return nil
+	})
+	return sizes
 }
 
-// primaryArtifact picks the artifact belonging to the requested package
-// (matching "<pn>-<digit>" and, when present, the category directory);
-// falls back to the largest file when nothing matches.
-func primaryArtifact(rels []string, pkgName string, sizeOf func(string) int64) string {
+// primaryArtifact picks, among the artifacts rels in dir, the one belonging
+// to the requested package (matching "<pn>-<digit>" and, when present, the
+// category directory). Of several versions of it the most recently built
+// (by mtime) wins; when nothing matches it falls back to the largest file.
+// With debug every candidate is logged.
+func primaryArtifact(dir string, rels []string, pkgName string, debug bool) string {
 	if len(rels) == 0 {
 		return ""
 	}
-	category, pn := "", pkgName
-	if idx := strings.LastIndex(pkgName, "/"); idx >= 0 {
-		category, pn = pkgName[:idx], pkgName[idx+1:]
-	}
-	matches := make([]string, 0, len(rels))
+	category, pn := splitCategory(atomCP(pkgName))
+	best, matched := rels[0], false
+	var bestInfo os.FileInfo
 	for _, rel := range rels {
-		base := filepath.Base(rel)
-		if !strings.HasPrefix(base, pn+"-") || len(base) <= len(pn)+1 {
+		isPkg := artifactIsPackage(rel, category, pn)
+		info, err := os.Stat(filepath.Join(dir, rel))
+		if err != nil {
+			if debug {
+				log.Printf("Artifact candidate %s for %s: %v", rel, pkgName, err)
+			}
 			continue
 		}
-		if c := base[len(pn)+1]; c < '0' || c > '9' {
-			continue // e.g. "jq-extras-1.0" must not match "jq"
+		if debug {
+			log.Printf("Artifact candidate %s for %s: %d bytes, built %s, matches: %v",
+				rel, pkgName, info.Size(), info.ModTime().Format(time.RFC3339), isPkg)
 		}
-		if category != "" && strings.Contains(rel, "/") && !strings.HasPrefix(rel, category+"/") {
-			continue
+		switch {
+		case isPkg && (!matched || info.ModTime().After(bestInfo.ModTime())):
+			best, bestInfo, matched = rel, info, true
+		case !isPkg && !matched && (bestInfo == nil || info.Size() > bestInfo.Size()):
+			best, bestInfo = rel, info
 		}
-		matches = append(matches, rel)
 	}
-	pool := matches
-	if len(pool) == 0 {
-		pool = rels
+	return best
+}
+
+// splitCategory splits "category/name" into its parts; a bare name has no
+// category.
+func splitCategory(pkgName string) (category, pn string) {
+	if idx := strings.LastIndex(pkgName, "/"); idx >= 0 {
+		return pkgName[:idx], pkgName[idx+1:]
+	}
+	return "", pkgName
+}
+
+// artifactIsPackage reports whether the artifact rel is a build of package
+// pn ("<pn>-<digit>...") in category, when rel and category both carry one.
+func artifactIsPackage(rel, category, pn string) bool {
+	base := filepath.Base(rel)
+	if !strings.HasPrefix(base, pn+"-") || len(base) <= len(pn)+1 {
+		return false
 	}
-	best, bestSize := pool[0], int64(-1)
-	for _, rel := range pool {
-		if s := sizeOf(rel); s > bestSize {
-			best, bestSize = rel, s
-		}
+	if c := base[len(pn)+1]; c < '0' || c > '9' {
+		return false // e.g. "jq-extras-1.0" must not match "jq"
 	}
-	return best
+	return category == "" || !strings.Contains(rel, "/") || strings.HasPrefix(rel, category+"/")
 }
 
-// signArtifact signs the artifact if a signer is available.
-func (lb *LocalBuilder) signArtifact(job *BuildJob, artifactPath string) {
+// signArtifact signs the artifact rel (relative to the artifact dir) if a
+// signer is available.
+func (lb *LocalBuilder) signArtifact(job *BuildJob, rel string) {
 	if lb.signer != nil && lb.signer.IsEnabled() {
-		if err := lb.signer.SignPackage(artifactPath); err != nil {
+		if lb.skipExpiredSigning(job, rel) {
+			return
+		}
+		artifactPath := filepath.Join(lb.artifactDir, rel)
+		embedded, err := lb.signPackage(artifactPath)
+		switch {
+		case err != nil:
 			log.Printf("Warning: failed to sign package: %v", err)
-		} else {
-			job.Metadata["signed"] = true
+		case embedded:
+			job.setMetadata("signed", true)
 			log.Printf("Package signed: %s", artifactPath)
+		default:
+			job.recordSignature(rel)
+			log.Printf("Package signed: %s", artifactPath)
+		}
+	}
+}
+
+// signPackage signs the package at path. A gpkg gets its signatures
+// embedded, which emerge verifies natively; a tbz2 or any other file gets a
+// detached signature next to it. It reports whether the signature is
+// embedded.
+func (lb *LocalBuilder) signPackage(path string) (bool, error) {
+	if strings.HasSuffix(path, ".gpkg.tar") {
+		return true, lb.signer.SignGPKG(path)
+	}
+	return false, lb.signer.SignPackage(path)
+}
+
+// recordSignature notes in the job metadata that the artifact rel has a
+// detached signature, under "signatures" (artifact path to signature path,
+// both relative to the artifact dir), so the server can fetch and verify it.
+func (j *BuildJob) recordSignature(rel string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.Metadata == nil {
+		j.Metadata = map[string]interface{}{}
+	}
+	// Copy rather than update the map: Clone shares metadata values.
+	sigs := map[string]string{rel: rel + gpg.SignatureFileExt}
+	for k, v := range stringMap(j.Metadata["signatures"]) {
+		if k != rel {
+			sigs[k] = v
+		}
+	}
+	j.Metadata["signatures"] = sigs
+	j.Metadata["signed"] = true
+}
+
+// stringMap reads job metadata holding a map of strings, such as
+// "signatures", which is a map[string]interface{} once the job went through
+// JSON.
+func stringMap(v interface{}) map[string]string {
+	switch m := v.(type) {
+	case map[string]string:
+		return m
+	case map[string]interface{}:
+		sigs := make(map[string]string, len(m))
+		for k, v := range m {
+			if s, ok := v.(string); ok {
+				sigs[k] = s
+			}
 		}
+		return sigs
 	}
+	return nil
 }
 
-// uploadArtifact uploads the artifact to storage if configured.
+// uploadArtifact uploads the artifact to storage if configured, along with
+// its checksum files and provenance. It is stored under its SHA-256 digest,
+// and nothing is uploaded when storage already holds the same package. With
+// ARTIFACT_COMPRESSION the compressed form is uploaded instead, with its own
+// checksum files and signature.
 func (lb *LocalBuilder) uploadArtifact(job *BuildJob, artifactPath string) {
 	if lb.storageUpload != nil && lb.storageUpload.IsEnabled() {
-		artifactName := filepath.Base(artifactPath)
-		remotePath := artifactName
-		if err := lb.storageUpload.Upload(artifactPath, remotePath); err != nil {
+		digests, err := readArtifactDigests(artifactPath)
+		if err != nil {
 			log.Printf("Warning: failed to upload artifact to storage: %v", err)
-		} else {
-			uploadedURL, _ := lb.storageUpload.GetURL(remotePath)
-			job.setArtifactURL(uploadedURL)
-			job.Metadata["uploaded"] = true
-			log.Printf("Artifact uploaded to storage: %s", uploadedURL)
+			return
+		}
+		localPath, ext := artifactPath, ""
+		if rel, err := filepath.Rel(lb.artifactDir, artifactPath); err == nil {
+			if cpath, enc := lb.compressedForm(job, rel); cpath != "" {
+				localPath, ext = cpath, compressedExts[enc]
+			}
+		}
+		remotePath, uploaded, err := lb.storageUpload.UploadBlob(localPath, digests.SHA256, ext)
+		if err != nil {
+			log.Printf("Warning: failed to upload artifact to storage: %v", err)
+			return
+		}
+		uploadedURL, _ := lb.storageUpload.GetURL(remotePath)
+		job.setArtifactURL(uploadedURL)
+		job.setMetadata("uploaded", true)
+		if !uploaded {
+			job.appendLog(fmt.Sprintf("Artifact already in storage as %s, not uploaded again\n", remotePath))
+			return
+		}
+		log.Printf("Artifact uploaded to storage: %s", uploadedURL)
+
+		for _, sum := range checksumFiles(localPath) {
+			if err := lb.storageUpload.Upload(sum, remotePath+strings.TrimPrefix(sum, localPath)); err != nil {
+				log.Printf("Warning: failed to upload checksum file to storage: %v", err)
+			}
+		}
+		if ext != "" {
+			if _, err := os.Stat(localPath + gpg.SignatureFileExt); err == nil {
+				if err := lb.storageUpload.Upload(localPath+gpg.SignatureFileExt, remotePath+gpg.SignatureFileExt); err != nil {
+					log.Printf("Warning: failed to upload signature to storage: %v", err)
+				}
+			}
+		}
+		if _, err := os.Stat(artifactPath + provenanceFileExt); err == nil {
+			if err := lb.storageUpload.Upload(artifactPath+provenanceFileExt, remotePath+provenanceFileExt); err != nil {
+				log.Printf("Warning: failed to upload provenance to storage: %v", err)
+			}
 		}
 	}
 }
 
 // executeNativeBuild performs the build natively using the system package manager.
-func (lb *LocalBuilder) executeNativeBuild(job *BuildJob) error {
+func (lb *LocalBuilder) executeNativeBuild(ctx context.Context, job *BuildJob) error {
 	jobWorkDir, err := lb.prepareJobWorkDir(job.ID)
 	if err != nil {
 		return err
@@ -1214,25 +2176,24 @@ func (lb *LocalBuilder) executeNativeBuild(job *BuildJob) error {
 		return err
 	}
 
-	pkgAtom, env := lb.prepareNativeBuildEnv(job)
+	jobs := lb.jobControlFor(job)
+	pkgAtom, env := lb.prepareNativeBuildEnv(job, jobs)
 	env = append(env, "PKGDIR="+pkgDir)
 
-	if err := lb.runNativeBuild(job, pkgAtom, env, jobWorkDir); err != nil {
+	if err := lb.runNativeBuild(ctx, job, pkgAtom, env, jobWorkDir, jobs); err != nil {
 		return err
 	}
 
 	// Same collector as the docker path: copy every produced gpkg into the
 	// artifact dir (category preserved), pick the requested package as primary.
-	return lb.collectAndUploadArtifact(job, pkgDir)
+	return lb.collectAndUploadArtifact(ctx, job, pkgDir)
 }
 
-// prepareNativeBuildEnv prepares the package atom and environment variables.
-func (lb *LocalBuilder) prepareNativeBuildEnv(job *BuildJob) (string, []string) {
+// prepareNativeBuildEnv prepares the package atom and environment variables,
+// with the MAKEOPTS of jobs.
+func (lb *LocalBuilder) prepareNativeBuildEnv(job *BuildJob, jobs jobControl) (string, []string) {
 	req := job.Request
-	pkgAtom := req.PackageName
-	if req.Version != "" {
-		pkgAtom = fmt.Sprintf("=%s-%s", req.PackageName, req.Version)
-	}
+	pkgAtom := emergeTarget(req.PackageName, req.Version)
 
 	env := os.Environ()
 
@@ -1252,41 +2213,72 @@ func (lb *LocalBuilder) prepareNativeBuildEnv(job *BuildJob) (string, []string)
 		env = append(env, fmt.Sprintf("USE=%s", useFlags))
 	}
 
+	if license := lb.acceptLicense(req); license != "" {
+		env = append(env, "ACCEPT_LICENSE="+license)
+	}
+	if jobs.makeOpts != "" {
+		env = append(env, "MAKEOPTS="+jobs.makeOpts)
+	}
+	env = lb.ccacheNativeEnv(env, req.Environment)
+
 	return pkgAtom, env
 }
 
-// runNativeBuild executes the native build command.
-func (lb *LocalBuilder) runNativeBuild(job *BuildJob, pkgAtom string, env []string, workDir string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+// runNativeBuild executes the native build command, an emerge one with the
+// emerge job options of jobs.
+func (lb *LocalBuilder) runNativeBuild(ctx context.Context, job *BuildJob, pkgAtom string, env []string, workDir string, jobs jobControl) error {
+	ctx, cancel, timeout := lb.buildContext(ctx, job)
 	defer cancel()
 
 	buildCmd := lb.pkgMgr.BuildCommand(pkgAtom, nil)
-	cmd := exec.CommandContext(ctx, buildCmd[0], buildCmd[1:]...)
-	cmd.Env = env
-	cmd.Dir = workDir
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
-	cmd.Stderr = cmd.Stdout
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("build failed to start: %w", err)
+	if buildCmd[0] == "emerge" {
+		buildCmd = lb.pkgMgr.BuildCommand(pkgAtom, strings.Fields(jobs.emergeJobs))
 	}
-	sc := bufio.NewScanner(stdout)
-	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
-	for sc.Scan() {
-		job.appendLog(sc.Text() + "\n")
+	opts := BuildOptions{SeparateFetch: lb.separateFetch() && buildCmd[0] == "emerge"}
+	if opts.SeparateFetch {
+		job.setScript(nativeBuildScript(env, fetchOnlyCommand(buildCmd), buildCmd))
+	} else {
+		job.setScript(nativeBuildScript(env, buildCmd))
 	}
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("build failed: %w", err)
+	lb.runCCache(env, "-z")
+	err := opts.runPhases(job, buildCmd, func(buildCmd []string) error {
+		cmd := exec.CommandContext(ctx, buildCmd[0], buildCmd[1:]...)
+		cmd.Env = env
+		cmd.Dir = workDir
+
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		cmd.Stderr = cmd.Stdout
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("build failed to start: %w", err)
+		}
+		sc := bufio.NewScanner(stdout)
+		sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for sc.Scan() {
+			job.appendLog(sc.Text() + "\n")
+		}
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("build failed: %w", err)
+		}
+		return nil
+	})
+	if err == nil {
+		recordCCacheStats(job, lb.runCCache(env, "-s"))
 	}
-	return nil
+	return timeoutError(ctx, timeout, err)
+}
+
+// separateFetch reports whether builds run the fetch phase on its own.
+func (lb *LocalBuilder) separateFetch() bool {
+	return lb.cfg != nil && lb.cfg.SeparateFetch
 }
 
 // sendNotification sends build completion notification.
 func (lb *LocalBuilder) sendNotification(job *BuildJob) {
-	if lb.notifier == nil {
+	notifier := lb.notifier.Load()
+	if notifier == nil {
 		return
 	}
 
@@ -1302,25 +2294,27 @@ func (lb *LocalBuilder) sendNotification(job *BuildJob) {
 		BuildLog:    job.Log,
 		Error:       job.Error,
 		ArtifactURL: job.ArtifactURL,
+		RequestID:   job.RequestID,
 	}
 
-	if err := lb.notifier.Notify(notify); err != nil {
-		log.Printf("Failed to send notification for job %s: %v", job.ID, err)
+	if err := notifier.Notify(notify); err != nil {
+		log.Printf("Failed to send notification for job %s: %v", job.ref(), err)
 	}
 }
 
 // GetArtifactPath returns the local file path of the artifact for a job.
 // Returns empty string if job not found or artifact not available.
 func (lb *LocalBuilder) GetArtifactPath(jobID string) (string, error) {
-	lb.jobsMutex.RLock()
-	job, exists := lb.jobs[jobID]
-	lb.jobsMutex.RUnlock()
+	job, exists := lb.findJob(jobID)
 
 	if !exists {
 		return "", fmt.Errorf("job not found: %s", jobID)
 	}
 
 	status, artifactURL := job.snapshot()
+	if status == "success_no_artifact" {
+		return "", fmt.Errorf("no artifact available for job %s: %w", jobID, errNoArtifact)
+	}
 	if status != "success" {
 		return "", fmt.Errorf("job not completed successfully: status=%s", status)
 	}
@@ -1329,6 +2323,12 @@ func (lb *LocalBuilder) GetArtifactPath(jobID string) (string, error) {
 		return "", fmt.Errorf("no artifact available for job: %s", jobID)
 	}
 
+	if rel, err := filepath.Rel(lb.artifactDir, artifactURL); err == nil {
+		if blob := lb.resolveArtifact(job, rel); blob != "" {
+			return blob, nil
+		}
+	}
+
 	// Check if ArtifactURL is a local file path
 	if _, err := os.Stat(artifactURL); err != nil {
 		return "", fmt.Errorf("artifact file not found: %s", artifactURL)
@@ -1337,17 +2337,37 @@ func (lb *LocalBuilder) GetArtifactPath(jobID string) (string, error) {
 	return artifactURL, nil
 }
 
-// GetArtifactPathByRel returns the absolute path of one produced artifact,
-// validated against the job's recorded artifact list (no path traversal).
+// GetArtifactName returns the file name the primary artifact of a job is
+// downloaded as: that of the package, which GetArtifactPath may resolve to
+// a blob named by its digest. Returns empty string if job not found.
+func (lb *LocalBuilder) GetArtifactName(jobID string) string {
+	job, exists := lb.findJob(jobID)
+	if !exists {
+		return ""
+	}
+	_, artifactURL := job.snapshot()
+	return filepath.Base(artifactURL)
+}
+
+// GetArtifactPathByRel returns the absolute path of one produced artifact or
+// its detached signature, validated against the job's recorded artifact and
+// signature lists (no path traversal).
 func (lb *LocalBuilder) GetArtifactPathByRel(jobID, rel string) (string, error) {
-	lb.jobsMutex.RLock()
-	job, exists := lb.jobs[jobID]
-	lb.jobsMutex.RUnlock()
+	job, exists := lb.findJob(jobID)
 	if !exists {
 		return "", fmt.Errorf("job not found: %s", jobID)
 	}
-	for _, known := range job.artifactsSnapshot() {
-		if known == rel {
+	known := job.artifactsSnapshot()
+	job.mu.Lock()
+	for _, sig := range stringMap(job.Metadata["signatures"]) {
+		known = append(known, sig)
+	}
+	job.mu.Unlock()
+	for _, k := range known {
+		if k == rel {
+			if blob := lb.resolveArtifact(job, rel); blob != "" {
+				return blob, nil
+			}
 			p := filepath.Join(lb.artifactDir, rel)
 			if _, err := os.Stat(p); err != nil {
 				return "", fmt.Errorf("artifact file not found: %s", rel)
@@ -1366,19 +2386,24 @@ type ArtifactInfo struct {
 	FileSize    int64  `json:"file_size"`
 	PackageName string `json:"package_name"`
 	Version     string `json:"version"`
+	SHA256      string `json:"sha256,omitempty"`
+	SHA512      string `json:"sha512,omitempty"`
+	// Provenance is the artifact's provenance document, if one was written.
+	Provenance *Provenance `json:"provenance,omitempty"`
 }
 
 // GetArtifactInfo returns metadata about the artifact for a job.
 func (lb *LocalBuilder) GetArtifactInfo(jobID string) (*ArtifactInfo, error) {
-	lb.jobsMutex.RLock()
-	job, exists := lb.jobs[jobID]
-	lb.jobsMutex.RUnlock()
+	job, exists := lb.findJob(jobID)
 
 	if !exists {
 		return nil, fmt.Errorf("job not found: %s", jobID)
 	}
 
 	status, artifactURL := job.snapshot()
+	if status == "success_no_artifact" {
+		return nil, fmt.Errorf("no artifact available for job %s: %w", jobID, errNoArtifact)
+	}
 	if status != "success" {
 		return nil, fmt.Errorf("job not completed successfully: status=%s", status)
 	}
@@ -1392,6 +2417,10 @@ func (lb *LocalBuilder) GetArtifactInfo(jobID string) (*ArtifactInfo, error) {
 	if err != nil {
 		return nil, fmt.Errorf("artifact file not found: %s", artifactURL)
 	}
+	digests, err := readArtifactDigests(artifactURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum artifact: %w", err)
+	}
 
 	return &ArtifactInfo{
 		JobID:       jobID,
@@ -1400,6 +2429,9 @@ func (lb *LocalBuilder) GetArtifactInfo(jobID string) (*ArtifactInfo, error) {
 		FileSize:    fileInfo.Size(),
 		PackageName: job.Request.PackageName,
 		Version:     job.Request.Version,
+		SHA256:      digests.SHA256,
+		SHA512:      digests.SHA512,
+		Provenance:  readProvenance(artifactURL),
 	}, nil
 }
 
@@ -1445,6 +2477,7 @@ func (lb *LocalBuilder) verifyInstallNative(pkgAtom, binhostURL string, requireS
 		"--usepkgonly=y",
 		"--getbinpkg=y",
 		"--oneshot",
+		"--ask=n",
 		"--color=n", "-q",
 		"--",
 		pkgAtom,
@@ -1525,7 +2558,7 @@ func (lb *LocalBuilder) VerifyInstall(pkgAtom, binhostURL, gpgPubkey string, req
 	script := "cp -a /tmp/pconf/. /etc/portage/ 2>/dev/null || true; " +
 		"getuto >/dev/null 2>&1 || true; " +
 		keyImport +
-		"emerge --color=n -q --getbinpkg=y --usepkgonly=y " + pkgAtom
+		"emerge --ask=n --color=n -q --getbinpkg=y --usepkgonly=y " + pkgAtom
 	args = append(args, lb.dockerImage, "sh", "-c", script)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Minute)
@@ -1,10 +1,19 @@
 package builder
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/slchris/portage-engine/internal/gpg"
+	"github.com/slchris/portage-engine/pkg/config"
 )
 
 // TestNewLocalBuilder tests creating a new LocalBuilder.
@@ -217,6 +226,115 @@ func TestLocalBuilderGetArtifactInfo(t *testing.T) {
 	}
 }
 
+// TestLocalBuilderSuccessNoArtifact verifies a build that succeeded without a
+// binary package reports a clear no-artifact error instead of a broken
+// download, and that the server treats the status as terminal.
+func TestLocalBuilderSuccessNoArtifact(t *testing.T) {
+	builder := &LocalBuilder{jobs: map[string]*BuildJob{
+		"job-1": {ID: "job-1", Status: "success_no_artifact", Request: &LocalBuildRequest{PackageName: "virtual/libc"}},
+	}}
+
+	if _, err := builder.GetArtifactPath("job-1"); !errors.Is(err, errNoArtifact) {
+		t.Errorf("GetArtifactPath() error = %v, want errNoArtifact", err)
+	}
+	if _, err := builder.GetArtifactInfo("job-1"); !errors.Is(err, errNoArtifact) {
+		t.Errorf("GetArtifactInfo() error = %v, want errNoArtifact", err)
+	}
+	if got := builder.GetStatus()["completed"]; got != 1 {
+		t.Errorf("success_no_artifact should count as completed, got %v", got)
+	}
+	if !terminalStatus("success_no_artifact") {
+		t.Error("success_no_artifact must be a terminal status")
+	}
+}
+
+func TestBuildScriptAcceptLicense(t *testing.T) {
+	lb := &LocalBuilder{cfg: &config.BuilderConfig{AcceptLicense: "@FREE @BINARY-REDISTRIBUTABLE"}}
+
+	job := &BuildJob{Request: &LocalBuildRequest{PackageName: "sys-kernel/linux-firmware"}}
+	script := lb.prepareDockerBuildScript(job)
+	for _, want := range []string{
+		`export ACCEPT_LICENSE="@FREE @BINARY-REDISTRIBUTABLE"`,
+		"--ask=n",
+		"--autounmask-license=n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("build script missing %q", want)
+		}
+	}
+
+	// The request's accept_license overrides the builder's.
+	job.Request.AcceptLicense = "linux-fw-redistributable"
+	if got := lb.acceptLicense(job.Request); got != "linux-fw-redistributable" {
+		t.Errorf("acceptLicense() = %q, want the request's", got)
+	}
+
+	// With neither set, the script leaves make.conf's ACCEPT_LICENSE alone.
+	lb.cfg.AcceptLicense = ""
+	job.Request.AcceptLicense = ""
+	if script := lb.prepareDockerBuildScript(job); strings.Contains(script, "ACCEPT_LICENSE") {
+		t.Error("build script should not set ACCEPT_LICENSE by default")
+	}
+}
+
+func TestGenerateInstanceID(t *testing.T) {
+	if got := generateInstanceID(&config.BuilderConfig{InstanceID: "builder-a", Port: 9090}); got != "builder-a" {
+		t.Errorf("explicit INSTANCE_ID = %q, want builder-a", got)
+	}
+
+	// Two builders on one host must not default to the same ID.
+	a := generateInstanceID(&config.BuilderConfig{Port: 9090})
+	b := generateInstanceID(&config.BuilderConfig{Port: 9091})
+	if a == b {
+		t.Errorf("builders on ports 9090 and 9091 share instance ID %q", a)
+	}
+	if !strings.HasSuffix(a, "-9090") {
+		t.Errorf("default instance ID %q should include the port", a)
+	}
+}
+
+func TestGPGSyncDelay(t *testing.T) {
+	tests := []struct {
+		attempt, retries int
+		want             time.Duration
+	}{
+		{1, 5, 2 * time.Second},
+		{2, 5, 4 * time.Second},
+		{5, 5, 32 * time.Second},
+		{6, 5, gpgSyncRecheckInterval}, // retries used up: periodic re-attempts
+		{1, 0, gpgSyncRecheckInterval},
+		{20, 30, gpgSyncRecheckInterval}, // backoff is capped
+	}
+	for _, tt := range tests {
+		if got := gpgSyncDelay(tt.attempt, tt.retries, 2*time.Second); got != tt.want {
+			t.Errorf("gpgSyncDelay(%d, %d) = %v, want %v", tt.attempt, tt.retries, got, tt.want)
+		}
+	}
+}
+
+func TestSyncGPGKeyServerUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "starting up", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cfg := &config.BuilderConfig{GPGHome: t.TempDir(), GPGSyncRetries: 3, GPGSyncBackoff: 1}
+	lb := &LocalBuilder{gpgClient: NewGPGKeyClient(srv.URL), cfg: cfg, stop: make(chan struct{})}
+	if err := syncGPGKey(lb.gpgClient, cfg); err == nil {
+		t.Fatal("syncGPGKey() should fail while the server is unavailable")
+	}
+
+	// A failed first sync leaves a background retry that Shutdown stops.
+	lb.startGPGKeySync()
+	if lb.gpgKeySynced.Load() {
+		t.Error("key must not be marked synced after a failed sync")
+	}
+	if !strings.Contains(lb.signingState(), "UNSIGNED") {
+		t.Errorf("signingState() = %q, want UNSIGNED without a builder key", lb.signingState())
+	}
+	lb.Shutdown()
+}
+
 // TestArtifactInfo tests ArtifactInfo struct.
 func TestArtifactInfo(t *testing.T) {
 	info := &ArtifactInfo{
@@ -246,7 +364,7 @@ func TestLocalBuilderSubmitBuildQueueFull(t *testing.T) {
 	// fills immediately.
 	lb := &LocalBuilder{
 		workers:  0,
-		jobQueue: make(chan *BuildJob, 1),
+		jobQueue: newJobQueue(1),
 		jobs:     make(map[string]*BuildJob),
 	}
 
@@ -320,3 +438,265 @@ func TestReconcileLoadedJobs(t *testing.T) {
 		t.Errorf("expected persisted job a to be failed, got %s", loaded["a"].Status)
 	}
 }
+
+func TestUpdateBinhostIndex(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "app-misc", "jq"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app-misc", "jq", "jq-1.7.gpkg.tar"), []byte("payload"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lb := &LocalBuilder{artifactDir: dir}
+	job := &BuildJob{ID: "j1"}
+
+	lb.updateBinhostIndex(job)
+
+	data, err := os.ReadFile(filepath.Join(dir, "Packages"))
+	if err != nil {
+		t.Fatalf("Packages index not written: %v", err)
+	}
+	if !strings.Contains(string(data), "CPV: app-misc/jq-1.7") {
+		t.Errorf("index missing the built package:\n%s", data)
+	}
+	if strings.Contains(job.Log, "Warning") {
+		t.Errorf("unexpected warning in job log: %s", job.Log)
+	}
+}
+
+// TestRecordSignature verifies a detached signature is recorded in the job
+// metadata and can then be fetched like an artifact, also after the job went
+// through JSON.
+func TestRecordSignature(t *testing.T) {
+	dir := t.TempDir()
+	for _, rel := range []string{"app-misc/jq-1.7.1-1.gpkg.tar", "app-misc/jq-1.7.1-1.gpkg.tar.sig", "dev-libs/oniguruma-6.9.9-1.gpkg.tar.sig"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(rel)), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, rel), []byte(rel), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job := &BuildJob{ID: "job-1", Status: "success", Artifacts: []string{"app-misc/jq-1.7.1-1.gpkg.tar", "dev-libs/oniguruma-6.9.9-1.gpkg.tar"}}
+	lb := &LocalBuilder{artifactDir: dir, jobs: map[string]*BuildJob{"job-1": job}}
+
+	before := job.Clone()
+	job.recordSignature("app-misc/jq-1.7.1-1.gpkg.tar")
+	if before.Metadata["signatures"] != nil {
+		t.Error("recordSignature changed an earlier clone's metadata")
+	}
+	if job.Metadata["signed"] != true {
+		t.Error("signed not set")
+	}
+
+	if _, err := lb.GetArtifactPathByRel("job-1", "app-misc/jq-1.7.1-1.gpkg.tar.sig"); err != nil {
+		t.Errorf("recorded signature: %v", err)
+	}
+	if _, err := lb.GetArtifactPathByRel("job-1", "dev-libs/oniguruma-6.9.9-1.gpkg.tar.sig"); err == nil {
+		t.Error("an unrecorded signature must not be served")
+	}
+
+	data, err := json.Marshal(job.Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded BuildJob
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	sigs := stringMap(loaded.Metadata["signatures"])
+	if sigs["app-misc/jq-1.7.1-1.gpkg.tar"] != "app-misc/jq-1.7.1-1.gpkg.tar.sig" {
+		t.Errorf("signatures after JSON = %v", sigs)
+	}
+	loaded.recordSignature("dev-libs/oniguruma-6.9.9-1.gpkg.tar")
+	if got := stringMap(loaded.Metadata["signatures"]); len(got) != 2 {
+		t.Errorf("signatures = %v, want both artifacts", got)
+	}
+}
+
+func TestWaitForArtifacts(t *testing.T) {
+	t.Parallel()
+
+	t.Run("present", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		writeTestArtifact(t, dir, "app-misc/jq-1.7-1.gpkg.tar")
+		writeTestArtifact(t, dir, "dev-libs/oniguruma-6.9-1.gpkg.tar")
+		start := time.Now()
+		rels, err := waitForArtifacts(context.Background(), dir, 10*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(rels, ",") != "app-misc/jq-1.7-1.gpkg.tar,dev-libs/oniguruma-6.9-1.gpkg.tar" {
+			t.Errorf("rels = %v", rels)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("took %s for artifacts already present", d)
+		}
+	})
+
+	t.Run("still written", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		path := writeTestArtifact(t, dir, "app-misc/jq-1.7-1.gpkg.tar")
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				return
+			}
+			defer func() { _ = f.Close() }()
+			for i := 0; i < 5; i++ {
+				time.Sleep(50 * time.Millisecond)
+				_, _ = f.WriteString("more")
+			}
+		}()
+		if _, err := waitForArtifacts(context.Background(), dir, 10*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+		default:
+			t.Error("returned while the package was still growing")
+		}
+		<-done
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		start := time.Now()
+		_, err := waitForArtifacts(context.Background(), t.TempDir(), 300*time.Millisecond)
+		if !errors.Is(err, errNoArtifact) {
+			t.Errorf("err = %v, want errNoArtifact", err)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("waited %s past a 300ms timeout", d)
+		}
+	})
+
+	t.Run("never settles", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		path := writeTestArtifact(t, dir, "app-misc/jq-1.7-1.gpkg.tar")
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(20 * time.Millisecond):
+					f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+					if err != nil {
+						return
+					}
+					_, _ = f.WriteString("more")
+					_ = f.Close()
+				}
+			}
+		}()
+		_, err := waitForArtifacts(context.Background(), dir, 500*time.Millisecond)
+		if err == nil || errors.Is(err, errNoArtifact) {
+			t.Errorf("err = %v, want a build failure rather than errNoArtifact", err)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		start := time.Now()
+		_, err := waitForArtifacts(ctx, t.TempDir(), time.Minute)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("waited %s after the job was cancelled", d)
+		}
+	})
+}
+
+func TestPrimaryArtifact(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	now := time.Now()
+	files := []struct {
+		rel  string
+		size int
+		age  time.Duration
+	}{
+		{"app-misc/jq-1.7-1.gpkg.tar", 300, 2 * time.Hour},
+		{"app-misc/jq-1.8-1.gpkg.tar", 100, time.Minute},
+		{"app-misc/jq-extras-2.0-1.gpkg.tar", 200, 0},
+		{"dev-libs/oniguruma-6.9-1.gpkg.tar", 500, time.Hour},
+	}
+	var rels []string
+	for _, f := range files {
+		path := filepath.Join(dir, f.rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, f.size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(-f.age)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		rels = append(rels, f.rel)
+	}
+
+	tests := []struct {
+		pkg, want string
+	}{
+		// The newest of the requested package's versions, not the largest.
+		{"app-misc/jq", "app-misc/jq-1.8-1.gpkg.tar"},
+		{"jq", "app-misc/jq-1.8-1.gpkg.tar"},
+		{"app-misc/jq-extras", "app-misc/jq-extras-2.0-1.gpkg.tar"},
+		{"dev-libs/oniguruma", "dev-libs/oniguruma-6.9-1.gpkg.tar"},
+		// Nothing matches: the largest file.
+		{"dev-lang/jq", "dev-libs/oniguruma-6.9-1.gpkg.tar"},
+		{"@world", "dev-libs/oniguruma-6.9-1.gpkg.tar"},
+	}
+	for _, tt := range tests {
+		if got := primaryArtifact(dir, rels, tt.pkg, true); got != tt.want {
+			t.Errorf("primaryArtifact(%q) = %s, want %s", tt.pkg, got, tt.want)
+		}
+	}
+	if got := primaryArtifact(dir, []string{"missing/x-1.gpkg.tar"}, "missing/x", false); got != "missing/x-1.gpkg.tar" {
+		t.Errorf("missing file: %s", got)
+	}
+}
+
+func TestReloadConfigNotifier(t *testing.T) {
+	t.Setenv("NOTIFY_CONFIG", "")
+	path := filepath.Join(t.TempDir(), "notification.json")
+	if err := os.WriteFile(path, []byte(`{"email":{"enabled":false}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	lb := &LocalBuilder{}
+	cfg := &config.BuilderConfig{Port: 9090, Workers: 1, NotifyConfig: path}
+	if err := lb.ReloadConfig(cfg); err != nil {
+		t.Fatalf("ReloadConfig: %v", err)
+	}
+	notifier := lb.notifier.Load()
+	if notifier == nil {
+		t.Fatal("the reloaded notification config was not applied")
+	}
+
+	missing := *cfg
+	missing.NotifyConfig = filepath.Join(t.TempDir(), "missing.json")
+	if err := lb.ReloadConfig(&missing); err == nil {
+		t.Error("ReloadConfig accepted an unreadable notification config")
+	}
+	invalid := *cfg
+	invalid.Workers = 0
+	if err := lb.ReloadConfig(&invalid); err == nil {
+		t.Error("ReloadConfig accepted 0 workers")
+	}
+	if lb.notifier.Load() != notifier {
+		t.Error("a rejected reload replaced the notifier")
+	}
+}
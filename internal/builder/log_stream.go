@@ -0,0 +1,191 @@
+// Package builder provides live build log streaming over Server-Sent Events.
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// LogStreamPath is the endpoint, on both the server and the builders,
+// streaming a job's log as Server-Sent Events (?job_id=<id>).
+const LogStreamPath = "/api/v1/builds/logs/stream"
+
+// logStreamBuffer is how many unread chunks a subscriber may fall behind
+// before it is dropped; its stream then ends and the client reconnects.
+const logStreamBuffer = 256
+
+// logStreamKeepalive is how often an idle stream sends a comment line, so
+// proxies do not time the connection out during a quiet build phase.
+const logStreamKeepalive = 15 * time.Second
+
+// LogStream is a live subscription to a job's log.
+type LogStream struct {
+	// Snapshot is the log written before the subscription started.
+	Snapshot string
+	// Chunks carries the text appended after Snapshot. It is closed when the
+	// job finishes or the subscriber falls too far behind, and is nil when
+	// the job had already finished.
+	Chunks <-chan string
+	// Status returns the job's current status.
+	Status func() string
+	cancel func()
+}
+
+// Close ends the subscription.
+func (s *LogStream) Close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// logSubscribers are the live streams of one job's log. Callers hold the
+// lock guarding that log.
+type logSubscribers map[chan string]struct{}
+
+// add registers a new subscriber.
+func (subs *logSubscribers) add() chan string {
+	if *subs == nil {
+		*subs = make(logSubscribers)
+	}
+	ch := make(chan string, logStreamBuffer)
+	(*subs)[ch] = struct{}{}
+	return ch
+}
+
+// publish hands chunk to every subscriber without blocking the writer. A
+// subscriber whose buffer is full is dropped.
+func (subs logSubscribers) publish(chunk string) {
+	for ch := range subs {
+		select {
+		case ch <- chunk:
+		default:
+			subs.remove(ch)
+		}
+	}
+}
+
+// remove drops and closes ch if it is still subscribed.
+func (subs logSubscribers) remove(ch chan string) {
+	if _, ok := subs[ch]; ok {
+		delete(subs, ch)
+		close(ch)
+	}
+}
+
+// closeAll ends every subscription.
+func (subs logSubscribers) closeAll() {
+	for ch := range subs {
+		subs.remove(ch)
+	}
+}
+
+// StreamBuildLog subscribes to a server job's live log.
+func (m *Manager) StreamBuildLog(jobID string) (*LogStream, error) {
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+	job, ok := m.jobs[jobID]
+	if !ok {
+		return nil, fmt.Errorf("job not found: %s", jobID)
+	}
+	stream := &LogStream{
+		Snapshot: job.Log,
+		Status: func() string {
+			m.jobsMu.RLock()
+			defer m.jobsMu.RUnlock()
+			if job, ok := m.jobs[jobID]; ok {
+				return job.Status
+			}
+			return ""
+		},
+	}
+	if terminalStatus(job.Status) {
+		return stream, nil
+	}
+	if m.logSubs == nil {
+		m.logSubs = make(map[string]logSubscribers)
+	}
+	subs := m.logSubs[jobID]
+	ch := subs.add()
+	m.logSubs[jobID] = subs
+	stream.Chunks = ch
+	stream.cancel = func() {
+		m.jobsMu.Lock()
+		defer m.jobsMu.Unlock()
+		if subs, ok := m.logSubs[jobID]; ok {
+			subs.remove(ch)
+			if len(subs) == 0 {
+				delete(m.logSubs, jobID)
+			}
+		}
+	}
+	return stream, nil
+}
+
+// endLogStreamsLocked closes the live log streams of job jobID. Callers hold
+// jobsMu for writing.
+func (m *Manager) endLogStreamsLocked(jobID string) {
+	if subs, ok := m.logSubs[jobID]; ok {
+		subs.closeAll()
+		delete(m.logSubs, jobID)
+	}
+}
+
+// ServeLogStream writes s to w as Server-Sent Events until the job finishes
+// or the client disconnects: a "snapshot" event with the log so far, a "log"
+// event per appended chunk, and a final "done" event carrying the job status.
+// Event data is JSON, so log text keeps its exact line breaks. A stream that
+// ends without "done" (the client fell behind) is reconnected by EventSource
+// and starts again from a fresh snapshot.
+func ServeLogStream(w http.ResponseWriter, r *http.Request, s *LogStream) {
+	defer s.Close()
+
+	rc := http.NewResponseController(w)
+	// The stream outlives any server-wide write timeout.
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, v interface{}) bool {
+		data, _ := json.Marshal(v)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	_, _ = fmt.Fprint(w, "retry: 2000\n")
+	if !send("snapshot", s.Snapshot) {
+		return
+	}
+
+	keepalive := time.NewTicker(logStreamKeepalive)
+	defer keepalive.Stop()
+	chunks := s.Chunks
+	for chunks != nil {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				chunks = nil
+				break
+			}
+			if !send("log", chunk) {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if status := s.Status(); terminalStatus(status) {
+		send("done", map[string]string{"status": status})
+	}
+}
@@ -0,0 +1,124 @@
+package builder
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// serveStream runs ServeLogStream for s in the background and returns a
+// function that waits for it to finish and returns the response body.
+func serveStream(t *testing.T, s *LogStream) func() string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", LogStreamPath+"?job_id=x", nil)
+	done := make(chan struct{})
+	go func() {
+		ServeLogStream(rec, req, s)
+		close(done)
+	}()
+	return func() string {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("log stream did not end")
+		}
+		return rec.Body.String()
+	}
+}
+
+func TestBuildJobLogStream(t *testing.T) {
+	job := &BuildJob{ID: "j1", Status: "building", Log: "starting\n"}
+	stream := job.subscribeLog()
+	wait := serveStream(t, stream)
+
+	job.appendLog("Emerging app-misc/jq\n")
+	job.mu.Lock()
+	job.Status = "success"
+	job.logSubs.closeAll()
+	job.mu.Unlock()
+
+	body := wait()
+	for _, want := range []string{
+		"event: snapshot\ndata: \"starting\\n\"\n\n",
+		"event: log\ndata: \"Emerging app-misc/jq\\n\"\n\n",
+		"event: done\ndata: {\"status\":\"success\"}\n\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stream missing %q:\n%s", want, body)
+		}
+	}
+	if len(job.logSubs) != 0 {
+		t.Errorf("%d subscribers left after the stream ended", len(job.logSubs))
+	}
+}
+
+func TestFinishedJobLogStream(t *testing.T) {
+	job := &BuildJob{ID: "j1", Status: "failed", Log: "boom\n"}
+	stream := job.subscribeLog()
+	if stream.Chunks != nil {
+		t.Fatal("finished job should not get a live subscription")
+	}
+	body := serveStream(t, stream)()
+	if !strings.Contains(body, "event: snapshot") || !strings.Contains(body, `"status":"failed"`) {
+		t.Errorf("unexpected stream:\n%s", body)
+	}
+}
+
+func TestLogSubscriberDroppedWhenBehind(t *testing.T) {
+	var subs logSubscribers
+	ch := subs.add()
+	for i := 0; i <= logStreamBuffer; i++ {
+		subs.publish("line\n")
+	}
+	if len(subs) != 0 {
+		t.Fatal("a subscriber that fell behind was kept")
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != logStreamBuffer {
+		t.Errorf("drained %d chunks, want %d", n, logStreamBuffer)
+	}
+}
+
+func TestManagerBuildLogStream(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+
+	mgr.jobsMu.Lock()
+	mgr.putJobLocked("job-1", &BuildStatus{JobID: "job-1", Status: "building", Log: "[build] submitted\n"})
+	mgr.jobsMu.Unlock()
+
+	if _, err := mgr.StreamBuildLog("missing"); err == nil {
+		t.Error("expected an error for an unknown job")
+	}
+	stream, err := mgr.StreamBuildLog("job-1")
+	if err != nil {
+		t.Fatalf("StreamBuildLog: %v", err)
+	}
+	wait := serveStream(t, stream)
+
+	mgr.appendJobLog("job-1", "[collect] artifact stored")
+	mgr.updateStatus("job-1", "completed", "", "")
+
+	body := wait()
+	for _, want := range []string{
+		`data: "[build] submitted\n"`,
+		`data: "[collect] artifact stored\n"`,
+		`data: {"status":"completed"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("stream missing %q:\n%s", want, body)
+		}
+	}
+	mgr.jobsMu.RLock()
+	defer mgr.jobsMu.RUnlock()
+	if len(mgr.logSubs) != 0 {
+		t.Errorf("log subscribers left after the job finished: %v", mgr.logSubs)
+	}
+}
@@ -0,0 +1,133 @@
+// Package builder provides the make and emerge parallelism of builds.
+package builder
+
+import (
+	"fmt"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// makeJobMemory is the memory each make job of the default MAKEOPTS is
+// sized for: compiler jobs of large C++ packages need about this much, so a
+// builder with many CPUs but little memory runs fewer of them.
+const makeJobMemory = 2 << 30
+
+// cpusPerEmergeJob is how many CPUs each package the default emerge --jobs
+// builds at once gets. With fewer, packages build one at a time, which also
+// keeps their build output in the job log: emerge only writes it to the
+// package's log file when building several at once.
+const cpusPerEmergeJob = 8
+
+// Job metadata recording the MAKEOPTS a build ran with, and in a bundle
+// build, the packages with MAKEOPTS of their own by emerge target.
+const (
+	makeOptsKey        = "makeopts"
+	packageMakeOptsKey = "package_makeopts"
+)
+
+// jobControl is how parallel a build runs: the MAKEOPTS of its packages and
+// the emerge options building several of them at once.
+type jobControl struct {
+	makeOpts   string
+	emergeJobs string
+}
+
+// defaultJobControl derives the parallelism of a builder with info's
+// resources: a make job per CPU, fewer when memory is short for them, with
+// the load limited to the CPU count, and a package at once per
+// cpusPerEmergeJob CPUs within the same load limit.
+func defaultJobControl(info *SystemInfo) jobControl {
+	cpus := max(info.CPUCount, 1)
+	makeJobs := cpus
+	if info.MemoryTotal > 0 {
+		makeJobs = min(makeJobs, max(int(info.MemoryTotal/makeJobMemory), 1))
+	}
+	return jobControl{
+		makeOpts:   fmt.Sprintf("-j%d -l%d", makeJobs, cpus),
+		emergeJobs: fmt.Sprintf("--jobs=%d --load-average=%d", max(cpus/cpusPerEmergeJob, 1), cpus),
+	}
+}
+
+// builderJobControl returns the parallelism cfg sets, with this host's
+// defaults for MAKEOPTS or EMERGE_JOBS when unset or invalid (Validate warns
+// of invalid ones).
+func builderJobControl(cfg *config.BuilderConfig) jobControl {
+	jobs := defaultJobControl(GetSystemInfo())
+	if cfg == nil {
+		return jobs
+	}
+	if allFieldsMatch(makeOptPattern, cfg.MakeOpts) {
+		jobs.makeOpts = cfg.MakeOpts
+	}
+	if allFieldsMatch(emergeJobOptPattern, cfg.EmergeJobs) {
+		jobs.emergeJobs = cfg.EmergeJobs
+	}
+	return jobs
+}
+
+// jobControlFor returns the parallelism of the legacy build of job: the
+// builder's, with the MAKEOPTS of the request's spec for the package, or
+// else of its environment, in place of the builder's. The MAKEOPTS is
+// recorded in the job metadata.
+func (lb *LocalBuilder) jobControlFor(job *BuildJob) jobControl {
+	req := job.Request
+	jobs := builderJobControl(lb.cfg)
+	if v, ok := req.Environment["MAKEOPTS"]; ok {
+		jobs.makeOpts = v
+	}
+	for _, spec := range req.PackageSpecs {
+		if spec.Atom == req.PackageName && spec.MakeOpts != "" {
+			jobs.makeOpts = spec.MakeOpts
+		}
+	}
+	if jobs.makeOpts != "" {
+		job.setMetadata(makeOptsKey, jobs.makeOpts)
+	}
+	return jobs
+}
+
+// makeOpts returns the MAKEOPTS a bundle build with spec exports: spec's
+// own MakeOpts, else the MAKEOPTS of its or the bundle's environment, else
+// the builder's, or "" when the bundle's make.conf sets one.
+func (be *BuildExecutor) makeOpts(spec PackageSpec, bundle *ConfigBundle) string {
+	if spec.MakeOpts != "" {
+		return spec.MakeOpts
+	}
+	if v, ok := spec.Environment["MAKEOPTS"]; ok {
+		return v
+	}
+	if bundle.Config != nil {
+		if v, ok := bundle.Config.Environment["MAKEOPTS"]; ok {
+			return v
+		}
+		if bundle.Config.MakeConf["MAKEOPTS"] != "" {
+			return ""
+		}
+	}
+	return be.opts.MakeOpts
+}
+
+// recordMakeOpts records the MAKEOPTS of the build of bundle (as returned
+// by bundleBuildSpec with spec) from the requested pkgs in the job
+// metadata: that of the build, and those of packages setting their own.
+func (be *BuildExecutor) recordMakeOpts(job *BuildJob, spec PackageSpec, bundle *ConfigBundle, pkgs []PackageSpec) {
+	makeOpts := be.makeOpts(spec, bundle)
+	if makeOpts == "" && bundle.Config != nil {
+		makeOpts = bundle.Config.MakeConf["MAKEOPTS"]
+	}
+	if makeOpts != "" {
+		job.setMetadata(makeOptsKey, makeOpts)
+	}
+	if len(pkgs) < 2 {
+		return
+	}
+	own := map[string]string{}
+	for _, pkg := range pkgs {
+		if target := emergeTarget(pkg.Atom, pkg.Version); pkg.MakeOpts != "" && !isPackageSet(target) {
+			own[target] = pkg.MakeOpts
+		}
+	}
+	if len(own) > 0 {
+		job.setMetadata(packageMakeOptsKey, own)
+	}
+}
@@ -0,0 +1,145 @@
+package builder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestDefaultJobControl(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		info       SystemInfo
+		makeOpts   string
+		emergeJobs string
+	}{
+		{SystemInfo{CPUCount: 4}, "-j4 -l4", "--jobs=1 --load-average=4"},
+		{SystemInfo{CPUCount: 32, MemoryTotal: 128 << 30}, "-j32 -l32", "--jobs=4 --load-average=32"},
+		// Memory, not CPUs, bounds the make jobs of a small-memory builder.
+		{SystemInfo{CPUCount: 16, MemoryTotal: 8 << 30}, "-j4 -l16", "--jobs=2 --load-average=16"},
+		{SystemInfo{CPUCount: 2, MemoryTotal: 1 << 30}, "-j1 -l2", "--jobs=1 --load-average=2"},
+		{SystemInfo{}, "-j1 -l1", "--jobs=1 --load-average=1"},
+	} {
+		got := defaultJobControl(&tt.info)
+		if got.makeOpts != tt.makeOpts || got.emergeJobs != tt.emergeJobs {
+			t.Errorf("defaultJobControl(%+v) = %+v, want %q, %q", tt.info, got, tt.makeOpts, tt.emergeJobs)
+		}
+	}
+}
+
+func TestBuilderJobControl(t *testing.T) {
+	t.Parallel()
+
+	got := builderJobControl(&config.BuilderConfig{MakeOpts: "-j6", EmergeJobs: "--jobs=3"})
+	if got.makeOpts != "-j6" || got.emergeJobs != "--jobs=3" {
+		t.Errorf("configured = %+v", got)
+	}
+	want := defaultJobControl(GetSystemInfo())
+	if got := builderJobControl(&config.BuilderConfig{MakeOpts: "-j6; id", EmergeJobs: "--usepkg"}); got != want {
+		t.Errorf("invalid values = %+v, want the defaults %+v", got, want)
+	}
+}
+
+func TestLegacyBuildJobControl(t *testing.T) {
+	t.Parallel()
+
+	lb := &LocalBuilder{
+		cfg:    &config.BuilderConfig{MakeOpts: "-j16 -l16", EmergeJobs: "--jobs=2 --load-average=16"},
+		pkgMgr: &GentooPackageManager{},
+	}
+	job := &BuildJob{Request: &LocalBuildRequest{
+		PackageName: "www-client/chromium",
+		PackageSpecs: []PackageSpec{
+			{Atom: "dev-libs/icu", MakeOpts: "-j8"},
+			{Atom: "www-client/chromium", MakeOpts: "-j4"},
+		},
+	}}
+	script := lb.prepareDockerBuildScript(job)
+	if !strings.Contains(script, `export MAKEOPTS="-j4"`) {
+		t.Error("script does not use the package's MAKEOPTS")
+	}
+	if !strings.Contains(script, "--backtrack=50 --jobs=2 --load-average=16 'www-client/chromium'") {
+		t.Error("script does not pass the emerge job options")
+	}
+	if job.Metadata[makeOptsKey] != "-j4" {
+		t.Errorf("makeopts = %v, want -j4", job.Metadata[makeOptsKey])
+	}
+
+	job = &BuildJob{Request: &LocalBuildRequest{PackageName: "app-misc/jq"}}
+	_, env := lb.prepareNativeBuildEnv(job, lb.jobControlFor(job))
+	if got := envMap(env)["MAKEOPTS"]; got != "-j16 -l16" {
+		t.Errorf("native MAKEOPTS = %q, want the builder's", got)
+	}
+
+	// A MAKEOPTS in the request's environment replaces the builder's.
+	job = &BuildJob{Request: &LocalBuildRequest{PackageName: "app-misc/jq", Environment: map[string]string{"MAKEOPTS": "-j1"}}}
+	if jobs := lb.jobControlFor(job); jobs.makeOpts != "-j1" || job.Metadata[makeOptsKey] != "-j1" {
+		t.Errorf("jobControlFor = %+v, metadata %v; want the request's -j1", jobs, job.Metadata)
+	}
+}
+
+func TestBundleMakeOpts(t *testing.T) {
+	t.Parallel()
+
+	be := NewBuildExecutorWithOptions("/work", "/art", BuildOptions{MakeOpts: "-j16", EmergeJobs: "--jobs=2"})
+
+	// A single package's own MAKEOPTS is exported for the build.
+	single := &ConfigBundle{Packages: &BuildPackageSpec{Packages: []PackageSpec{{Atom: "www-client/chromium", MakeOpts: "-j4"}}}}
+	built, spec := bundleBuildSpec(single, be.opts.MakeOpts)
+	if env := envMap(be.buildEnvironment(spec, built, "/p")); env["MAKEOPTS"] != "-j4" {
+		t.Errorf("MAKEOPTS = %q, want the package's -j4", env["MAKEOPTS"])
+	}
+	job := &BuildJob{}
+	be.recordMakeOpts(job, spec, built, single.Packages.Packages)
+	if job.Metadata[makeOptsKey] != "-j4" || job.Metadata[packageMakeOptsKey] != nil {
+		t.Errorf("metadata = %v", job.Metadata)
+	}
+	if cmd := strings.Join(be.bundleEmergeCommand(built), " "); !strings.Contains(cmd, "--jobs=2") {
+		t.Errorf("emerge command lacks the job options: %s", cmd)
+	}
+
+	// Among several packages, it goes into package.env, with the builder's
+	// in make.conf rather than the environment, which would override it.
+	bundle := &ConfigBundle{Packages: &BuildPackageSpec{Packages: []PackageSpec{
+		{Atom: "app-misc/jq"},
+		{Atom: "www-client/chromium", Version: "120.0", MakeOpts: "-j4"},
+	}}}
+	built, spec = bundleBuildSpec(bundle, be.opts.MakeOpts)
+	cfg := built.Config
+	if got := cfg.PackageEnv["=www-client/chromium-120.0"]; got != "portage-engine-makeopts-1.conf" {
+		t.Errorf("package.env = %v", cfg.PackageEnv)
+	}
+	if got := cfg.EnvFiles["portage-engine-makeopts-1.conf"]; got != "MAKEOPTS=\"-j4\"\n" {
+		t.Errorf("env file = %q", got)
+	}
+	if cfg.MakeConf["MAKEOPTS"] != "-j16" {
+		t.Errorf("make.conf MAKEOPTS = %q, want the builder's", cfg.MakeConf["MAKEOPTS"])
+	}
+	if err := ValidateBundle(&ConfigBundle{Config: cfg, Packages: built.Packages, Metadata: BundleMetadata{TargetArch: "amd64"}}); err != nil {
+		t.Errorf("ValidateBundle() of the built bundle = %v", err)
+	}
+	if env := envMap(be.buildEnvironment(spec, built, "/p")); env["MAKEOPTS"] != "" {
+		t.Errorf("MAKEOPTS = %q exported, overriding package.env", env["MAKEOPTS"])
+	}
+	job = &BuildJob{}
+	be.recordMakeOpts(job, spec, built, bundle.Packages.Packages)
+	if job.Metadata[makeOptsKey] != "-j16" || !reflect.DeepEqual(job.Metadata[packageMakeOptsKey], map[string]string{"=www-client/chromium-120.0": "-j4"}) {
+		t.Errorf("metadata = %v", job.Metadata)
+	}
+
+	// Without packages of their own, the builder's is exported, unless the
+	// bundle's make.conf sets one.
+	bundle.Packages.Packages[1].MakeOpts = ""
+	built, spec = bundleBuildSpec(bundle, be.opts.MakeOpts)
+	if env := envMap(be.buildEnvironment(spec, built, "/p")); env["MAKEOPTS"] != "-j16" {
+		t.Errorf("MAKEOPTS = %q, want the builder's", env["MAKEOPTS"])
+	}
+	bundle.Config = &PortageConfig{MakeConf: map[string]string{"MAKEOPTS": "-j2"}}
+	built, spec = bundleBuildSpec(bundle, be.opts.MakeOpts)
+	if env := envMap(be.buildEnvironment(spec, built, "/p")); env["MAKEOPTS"] != "" {
+		t.Errorf("MAKEOPTS = %q exported over make.conf's", env["MAKEOPTS"])
+	}
+}
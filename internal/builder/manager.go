@@ -4,6 +4,7 @@ package builder
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"maps"
@@ -14,6 +15,8 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -21,7 +24,9 @@ import (
 
 	"github.com/google/uuid"
 
+	"github.com/slchris/portage-engine/internal/binpkg"
 	"github.com/slchris/portage-engine/internal/iac"
+	"github.com/slchris/portage-engine/internal/requestid"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
@@ -37,6 +42,44 @@ type BuildRequest struct {
 	// with. It is forwarded verbatim to the remote builder so the build applies
 	// the exact USE flags / make.conf / repos the client specified.
 	ConfigBundle *ConfigBundle `json:"config_bundle,omitempty"`
+	// AcceptLicense grants this build's ACCEPT_LICENSE (e.g. "@FREE
+	// @BINARY-REDISTRIBUTABLE"); empty uses the builder's setting.
+	AcceptLicense string `json:"accept_license,omitempty"`
+	// BuildTimeout bounds the build on its builder (e.g. "6h"); empty uses
+	// the builder's default.
+	BuildTimeout string `json:"build_timeout,omitempty"`
+	// Deadline is when the build must have started; a job still queued then
+	// is cancelled as "expired". MaxQueueWait (e.g. "15m") sets the same
+	// relative to submission; the earlier of the two applies.
+	Deadline     *time.Time `json:"deadline,omitempty"`
+	MaxQueueWait string     `json:"max_queue_wait,omitempty"`
+	// GroupID correlates the per-arch jobs of a multi-arch request. Set only
+	// by SubmitMultiArchBuild, never from client JSON.
+	GroupID string `json:"-"`
+	// RequestID is the submitting request's X-Request-ID, forwarded to the
+	// builder. Set by the server from the header, never from client JSON.
+	RequestID string `json:"-"`
+	// Owner is the authenticated user submitting the request, labelled on
+	// the cloud instances built on for cost allocation. Set by the server,
+	// never from client JSON.
+	Owner string `json:"-"`
+	// Ephemeral delivers the artifact to the client instead of storing it in
+	// the binhost: streamed to CallbackURL when set, otherwise held for one
+	// download from EphemeralDownloadPath until downloaded or expired.
+	Ephemeral   bool   `json:"ephemeral,omitempty"`
+	CallbackURL string `json:"callback_url,omitempty"`
+	// ForceRebuild makes the builder build even when it has the result of
+	// an identical build against the same portage tree.
+	ForceRebuild bool `json:"force_rebuild,omitempty"`
+	// Pretend only resolves the build on the builder (emerge --pretend),
+	// producing no artifact.
+	Pretend bool `json:"pretend,omitempty"`
+	// AllowDeniedFeatures bypasses the FEATURES denylist. Set only by the
+	// server for requests carrying a valid admin key, never from client JSON.
+	AllowDeniedFeatures bool `json:"-"`
+	// strippedFeatures records denied FEATURES already removed from a shared
+	// bundle before a multi-arch fan-out, so each per-arch job logs them.
+	strippedFeatures []string
 }
 
 // BuildResponse represents a build request response.
@@ -71,6 +114,44 @@ type BuildStatus struct {
 	// (provision/deploy/build/collect/verify), for accurate UI attribution.
 	FailedStage string `json:"failed_stage,omitempty"`
 	Log         string `json:"log,omitempty"`
+	// SuggestedConfig holds the package.use/accept_keywords/unmask changes
+	// autounmask applied inside the builder, for the user to adopt.
+	SuggestedConfig *PortageConfig `json:"suggested_config,omitempty"`
+	// Script is the build script the builder ran, secrets redacted; it
+	// shows how the request's USE flags and autounmask retries were run.
+	Script string `json:"script,omitempty"`
+	// GroupID is the multi-arch request this job belongs to, if any.
+	GroupID string `json:"group_id,omitempty"`
+	// RequestID is the ID of the request that submitted the job, passed on
+	// to its builder to correlate their log lines.
+	RequestID string `json:"request_id,omitempty"`
+	// FailureCategory is the build phase a failed build died in on the
+	// builder ("fetch" or "compile"), when the builder fetches separately.
+	// Retryable marks failures worth retrying (source downloads).
+	FailureCategory string `json:"failure_category,omitempty"`
+	Retryable       bool   `json:"retryable,omitempty"`
+	// SpotReschedules counts the times the build moved to a fresh cloud
+	// instance because its spot instance was reclaimed mid-build.
+	SpotReschedules int `json:"spot_reschedules,omitempty"`
+	// ResolutionErrors lists why emerge could not resolve a failed build's
+	// dependencies (blockers, REQUIRED_USE, masks), as the builder parsed
+	// them from the log.
+	ResolutionErrors []ResolutionError `json:"resolution_errors,omitempty"`
+	// Ephemeral marks a job whose artifact bypasses the binhost. DownloadURL
+	// is its one-time download while the artifact is held.
+	Ephemeral   bool   `json:"ephemeral,omitempty"`
+	DownloadURL string `json:"download_url,omitempty"`
+	callbackURL string
+	// buildStart and buildEnd bound the build itself, without queueing or
+	// provisioning, and machineType is what built it, for the build
+	// statistics.
+	buildStart  time.Time
+	buildEnd    time.Time
+	machineType string
+	// Deadline is when a queued job expires unstarted. DeadlineRemaining is
+	// the whole seconds left while it is queued, filled in on status reads.
+	Deadline          *time.Time `json:"deadline,omitempty"`
+	DeadlineRemaining int64      `json:"deadline_remaining_seconds,omitempty"`
 }
 
 // queuedJob pairs a build request with the job ID assigned at submission, so a
@@ -88,10 +169,33 @@ type Manager struct {
 	iacMgr       *iac.Manager
 	jobs         map[string]*BuildStatus
 	jobsMu       sync.RWMutex
+	counts       jobCounts // aggregates of jobs, guarded by jobsMu
 	workQueue    chan *queuedJob
 	remoteBuilds map[string]string // jobID -> builderURL
 	rrNext       atomic.Uint32     // round-robin cursor over RemoteBuilders
 
+	// logSubs are the live log streams of each job, guarded by jobsMu.
+	logSubs map[string]logSubscribers
+	// buildStats are the durations of recently finished builds.
+	buildStats *buildStats
+
+	// builderIDs maps canonical builder URLs to the instance IDs the builders
+	// reported, so one builder configured under two addresses is used once.
+	builderIDs       map[string]string
+	warnedDuplicates map[string]bool
+	// builderTrees holds the portage tree each builder last reported, keyed
+	// like builderIDs; staleTrees marks those already warned about.
+	builderTrees map[string]builderTree
+	staleTrees   map[string]bool
+	// builderArchs holds the architecture each builder last reported.
+	builderArchs map[string]builderArch
+	// builderAPIVersions holds the API version each builder last reported.
+	builderAPIVersions map[string]builderAPIVersion
+	// disabledBuilders holds the instance IDs of the builders disabled for
+	// maintenance.
+	disabledBuilders map[string]bool
+	builderIDsMu     sync.RWMutex
+
 	// onArtifactStored, when set, is called after an artifact lands in the
 	// binhost PKGDIR (the server uses it to refresh the Packages index).
 	onArtifactStored func()
@@ -104,6 +208,19 @@ type Manager struct {
 	// swapped atomically by the settings API. Workers take a snapshot per
 	// build, so an update never races an in-flight provision.
 	cloudSettings atomic.Pointer[config.CloudSettings]
+	// strategy is the scheduling strategy a config reload swapped in (nil
+	// until then: config.SchedulingStrategy applies).
+	strategy atomic.Pointer[string]
+
+	// ephemeral holds artifacts of ephemeral jobs awaiting their one-time
+	// download, keyed by job ID.
+	ephemeral   map[string]*ephemeralArtifact
+	ephemeralMu sync.Mutex
+
+	// remoteStats caches the remote builders' aggregate for remoteStatsTTL.
+	remoteStats   *ClusterStatus
+	remoteStatsAt time.Time
+	remoteStatsMu sync.Mutex
 }
 
 // SetArtifactStoredHook registers a callback invoked after an artifact has
@@ -124,20 +241,47 @@ func NewManager(cfg *config.ServerConfig) *Manager {
 	if cfg.CloudInstanceTTL > 0 {
 		iacOpts = append(iacOpts, iac.WithDefaultTTL(time.Duration(cfg.CloudInstanceTTL)*time.Minute))
 	}
+	if cfg.CloudInstanceIdleTimeout > 0 {
+		iacOpts = append(iacOpts, iac.WithIdleTimeout(time.Duration(cfg.CloudInstanceIdleTimeout)*time.Minute))
+	}
+	if cfg.CloudInstanceMaxLifetime > 0 {
+		iacOpts = append(iacOpts, iac.WithMaxLifetime(time.Duration(cfg.CloudInstanceMaxLifetime)*time.Minute))
+	}
+	if cfg.CloudMaxInstances > 0 {
+		iacOpts = append(iacOpts, iac.WithMaxInstances(cfg.CloudMaxInstances))
+	}
+	if cfg.CloudMaxHourlySpend > 0 {
+		iacOpts = append(iacOpts, iac.WithMaxHourlySpend(cfg.CloudMaxHourlySpend))
+	}
+	if len(cfg.CloudHourlyPrices) > 0 {
+		iacOpts = append(iacOpts, iac.WithHourlyPrices(cfg.CloudHourlyPrices))
+	}
+	if cfg.CloudBuilderReadyDelay > 0 || cfg.CloudBuilderReadyTimeout > 0 {
+		iacOpts = append(iacOpts, iac.WithBuilderReadiness(
+			time.Duration(cfg.CloudBuilderReadyDelay)*time.Second,
+			time.Duration(cfg.CloudBuilderReadyTimeout)*time.Second))
+	}
 	if cfg.DataDir != "" {
 		// Persist instances so live VMs survive server restarts instead of
 		// becoming orphans.
 		iacOpts = append(iacOpts, iac.WithStateFile(filepath.Join(cfg.DataDir, "instances.json")))
 	}
+	if sb := cfg.StateBackend; sb.GCSBucket != "" || sb.S3Bucket != "" || sb.OSSBucket != "" {
+		iacOpts = append(iacOpts, iac.WithStateBackend(iac.StateBackend(sb)))
+	}
 
 	mgr := &Manager{
 		config:       cfg,
-		iacMgr:       iac.NewManager(iacOpts...),
 		jobs:         make(map[string]*BuildStatus),
 		workQueue:    make(chan *queuedJob, 100),
 		remoteBuilds: make(map[string]string),
+		buildStats:   newBuildStats(cfg.DataDir, cfg.BuildStatsWindow),
 	}
+	// The reaper asks an instance's builder for active jobs before
+	// terminating it, so a build the server lost track of is not killed.
+	mgr.iacMgr = iac.NewManager(append(iacOpts, iac.WithActiveJobsProbe(mgr.instanceActiveJobs))...)
 	mgr.cloudSettings.Store(config.CloudSettingsFromServerConfig(cfg))
+	_ = os.RemoveAll(mgr.ephemeralDir())
 
 	// Start the IaC cleanup routine so expired / orphaned cloud instances are
 	// reclaimed (without this, TTL auto-termination never runs and leaked VMs
@@ -182,7 +326,9 @@ func (m *Manager) LoadJobs(jobs map[string]*BuildStatus) {
 			job.Error = "server restarted before the job completed; please resubmit"
 			job.UpdatedAt = time.Now()
 		}
-		m.jobs[id] = job
+		// Held ephemeral artifacts do not survive a restart.
+		job.DownloadURL = ""
+		m.putJobLocked(id, job)
 	}
 }
 
@@ -192,24 +338,84 @@ func (m *Manager) Shutdown() {
 	close(m.workQueue)
 	// Give the IaC manager a chance to clean up
 	m.iacMgr.StopCleanupRoutine()
+	m.discardEphemeral()
+	m.buildStats.close()
 }
 
-// SubmitBuild submits a new build request.
-func (m *Manager) SubmitBuild(req *BuildRequest) (string, error) {
-	// Validate the untrusted package fields early (defense-in-depth: the builder
-	// validates again, but rejecting here avoids provisioning/forwarding for a
-	// bad request and rejects atom/option injection at the server boundary).
-	if !atomPattern.MatchString(req.PackageName) {
-		return "", fmt.Errorf("invalid package name %q", req.PackageName)
-	}
-	if req.Version != "" && !versionPattern.MatchString(req.Version) {
-		return "", fmt.Errorf("invalid package version %q", req.Version)
+// validateBuildRequest checks the untrusted package fields of a build request
+// (defense-in-depth: the builder validates again, but rejecting here avoids
+// provisioning/forwarding for a bad request and rejects atom/option injection
+// at the server boundary).
+func validateBuildRequest(req *BuildRequest) error {
+	if err := validateTarget(req.PackageName, req.Version); err != nil {
+		return err
 	}
 	for _, flag := range req.UseFlags {
 		if !useFlagPattern.MatchString(flag) {
-			return "", fmt.Errorf("invalid USE flag %q", flag)
+			return fmt.Errorf("invalid USE flag %q", flag)
 		}
 	}
+	if !licensePattern.MatchString(req.AcceptLicense) {
+		return fmt.Errorf("invalid accept_license %q", req.AcceptLicense)
+	}
+	if _, err := parseBuildTimeout(req.BuildTimeout); err != nil {
+		return err
+	}
+	if _, err := startDeadline(req, time.Now()); err != nil {
+		return err
+	}
+	// The bundle's metadata and atoms are checked here so a typo is rejected
+	// before it queues; the rest of the bundle is validated by the builder
+	// (see ValidateBundle), after the FEATURES policy has been applied.
+	if req.ConfigBundle != nil {
+		if err := validateBundleMetadata(req.ConfigBundle.Metadata); err != nil {
+			return err
+		}
+		if req.ConfigBundle.Packages != nil {
+			for _, pkg := range req.ConfigBundle.Packages.Packages {
+				if err := validateTarget(pkg.Atom, pkg.Version); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if req.CallbackURL != "" {
+		if !req.Ephemeral {
+			return fmt.Errorf("callback_url requires ephemeral")
+		}
+		if err := validateCallbackURL(req.CallbackURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBinhostWritable rejects new builds while the binhost PKGDIR cannot
+// accept packages, instead of building something that cannot be stored.
+func (m *Manager) checkBinhostWritable() error {
+	if m.config.BinpkgPath == "" {
+		return nil
+	}
+	return binpkg.CheckWritable(m.config.BinpkgPath)
+}
+
+// SubmitBuild submits a new build request.
+func (m *Manager) SubmitBuild(req *BuildRequest) (string, error) {
+	if err := validateBuildRequest(req); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidBuildRequest, err)
+	}
+	if err := m.checkCallbackTarget(req.CallbackURL); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidBuildRequest, err)
+	}
+	if err := m.checkBinhostWritable(); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	deadline, err := startDeadline(req, now)
+	if err != nil {
+		return "", err
+	}
 
 	jobID := uuid.New().String()
 
@@ -219,23 +425,35 @@ func (m *Manager) SubmitBuild(req *BuildRequest) (string, error) {
 		PackageName: req.PackageName,
 		Version:     req.Version,
 		Arch:        req.Arch,
+		GroupID:     req.GroupID,
+		RequestID:   req.RequestID,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
+		Ephemeral:   req.Ephemeral,
+		callbackURL: req.CallbackURL,
+	}
+	if !deadline.IsZero() {
+		status.Deadline = &deadline
 	}
 
 	m.jobsMu.Lock()
-	m.jobs[jobID] = status
+	m.putJobLocked(jobID, status)
 	m.jobsMu.Unlock()
 
+	m.enforceFeaturesPolicy(jobID, req)
+
 	// Enqueue the job ID alongside the request so the worker processes exactly
 	// this job. If the queue is full, remove the just-added job so it does not
 	// linger as a permanently "queued" orphan.
 	select {
 	case m.workQueue <- &queuedJob{jobID: jobID, req: req}:
+		if !deadline.IsZero() {
+			m.scheduleExpiry(jobID, deadline)
+		}
 		return jobID, nil
 	default:
 		m.jobsMu.Lock()
-		delete(m.jobs, jobID)
+		m.deleteJobLocked(jobID)
 		m.jobsMu.Unlock()
 		return "", fmt.Errorf("work queue is full")
 	}
@@ -269,9 +487,9 @@ func (m *Manager) GetStatus(jobID string) (*BuildStatus, error) {
 	m.jobsMu.RLock()
 	status, exists := m.jobs[jobID]
 	if exists {
-		statusCopy := *status
+		view := statusView(status, time.Now())
 		m.jobsMu.RUnlock()
-		return &statusCopy, nil
+		return view, nil
 	}
 	m.jobsMu.RUnlock()
 
@@ -361,7 +579,8 @@ func (m *Manager) fetchRemoteJobStatus(jobID string) *BuildStatus {
 
 // claimJob atomically transitions a job from "queued" to "claimed" under the
 // write lock. It returns true only for the caller that performed the
-// transition; a job that is missing or already past "queued" returns false.
+// transition; a job that is missing or already past "queued" returns false,
+// and one whose start deadline has passed is expired instead of claimed.
 func (m *Manager) claimJob(jobID string) bool {
 	m.jobsMu.Lock()
 	defer m.jobsMu.Unlock()
@@ -370,7 +589,11 @@ func (m *Manager) claimJob(jobID string) bool {
 	if !ok || job.Status != "queued" {
 		return false
 	}
-	job.Status = "claimed"
+	if job.Deadline != nil && !time.Now().Before(*job.Deadline) {
+		m.expireJobLocked(job)
+		return false
+	}
+	m.setStatusLocked(job, "claimed")
 	job.UpdatedAt = time.Now()
 	return true
 }
@@ -416,6 +639,7 @@ func (m *Manager) processCloudBuild(jobID string, req *BuildRequest) {
 		m.updateStatus(jobID, "failed", "", err.Error())
 		return
 	}
+	provReq.Labels[iac.LabelJobID] = jobID
 
 	// Stream provisioning/deployment progress into the job's live log so the
 	// dashboard's logs page can be used to follow and debug the whole flow.
@@ -433,11 +657,18 @@ func (m *Manager) processCloudBuild(jobID string, req *BuildRequest) {
 	// deploy turns a ~15 minute cold start into a couple of minutes.
 	instance := m.iacMgr.AcquireIdleInstance(provReq.Provider, req.Arch)
 	if instance != nil && !m.builderHealthy(instance) {
-		// A warm instance whose builder does not answer is useless — destroy
-		// it and fall through to provisioning a fresh one.
-		m.appendJobLog(jobID, fmt.Sprintf("[provision] warm instance %s failed its health check — destroying it and provisioning fresh", instance.ID))
-		if termErr := m.iacMgr.Terminate(instance.ID); termErr != nil {
-			m.appendJobLog(jobID, fmt.Sprintf("[provision] destroy of unhealthy instance failed (cleanup will retry): %v", termErr))
+		if instance.Provider == iac.ExternalProvider {
+			// Not ours to destroy: leave the external builder registered and
+			// provision a fresh instance for this build.
+			m.appendJobLog(jobID, fmt.Sprintf("[provision] external instance %s failed its health check — provisioning fresh", instance.ID))
+			m.iacMgr.SetInstanceActiveTasks(instance.ID, 0)
+		} else {
+			// A warm instance whose builder does not answer is useless —
+			// destroy it and fall through to provisioning a fresh one.
+			m.appendJobLog(jobID, fmt.Sprintf("[provision] warm instance %s failed its health check — destroying it and provisioning fresh", instance.ID))
+			if termErr := m.iacMgr.Terminate(instance.ID); termErr != nil {
+				m.appendJobLog(jobID, fmt.Sprintf("[provision] destroy of unhealthy instance failed (cleanup will retry): %v", termErr))
+			}
 		}
 		instance = nil
 	}
@@ -465,8 +696,13 @@ func (m *Manager) processCloudBuild(jobID string, req *BuildRequest) {
 
 	// On exit, release the instance back to the warm pool instead of
 	// destroying it: idle instances serve subsequent builds and the TTL
-	// cleanup reclaims them after the configured idle window.
+	// cleanup reclaims them after the configured idle window. A reclaimed
+	// spot instance is destroyed instead.
+	reclaimed := false
 	defer func() {
+		if reclaimed {
+			return
+		}
 		m.iacMgr.SetInstanceActiveTasks(instance.ID, 0)
 		ttl := "the configured idle TTL"
 		if instance.TTL > 0 {
@@ -480,22 +716,24 @@ func (m *Manager) processCloudBuild(jobID string, req *BuildRequest) {
 		return
 	}
 
-	// The builder service is (re)started at the very end of the deploy script;
-	// on a fast deploy (native Gentoo) it may not have bound its port yet when
-	// deploy returns. Wait for /health before submitting, so the first build
-	// doesn't race the builder startup with a connection-refused.
-	if !m.waitForBuilderReady(jobID, instance) {
-		m.setFailedStage(jobID, "deploy")
-		m.updateStatus(jobID, "failed", instance.ID, "builder did not become ready after deployment")
-		return
-	}
-
+	m.setMachineType(jobID, instanceMachineType(instance))
 	m.updateStatus(jobID, "building", instance.ID, "")
 	m.appendJobLog(jobID, "[build] submitting build to the instance builder…")
 
 	// Submit and wait for the build on the builder, then pull the resulting
 	// artifact back to the server's binpkg dir.
 	if err := m.runBuildOnInstance(jobID, instance, req); err != nil {
+		if errors.Is(err, errNoArtifact) {
+			// Nothing was published, so there is nothing to upload or verify.
+			m.appendJobLog(jobID, "[collect] "+err.Error())
+			m.updateStatus(jobID, "success_no_artifact", instance.ID, err.Error())
+			return
+		}
+		if m.rescheduleSpotInterrupted(jobID, instance) {
+			reclaimed = true
+			m.processCloudBuild(jobID, req)
+			return
+		}
 		stage := "build"
 		if strings.Contains(err.Error(), "artifact retrieval failed") {
 			stage = "collect"
@@ -505,6 +743,13 @@ func (m *Manager) processCloudBuild(jobID string, req *BuildRequest) {
 		return
 	}
 
+	// An ephemeral artifact never reached the binhost, so there is nothing
+	// to mirror or install-verify from it.
+	if req.Ephemeral {
+		m.updateStatus(jobID, "completed", instance.ID, "")
+		return
+	}
+
 	// Push the fresh packages (and index/pubkey) to the internal mirror when
 	// one is configured; verification then exercises the mirror URL end-to-end.
 	verifyBinhost := ""
@@ -521,7 +766,12 @@ func (m *Manager) processCloudBuild(jobID string, req *BuildRequest) {
 	// Verify the freshly published binpkg actually installs from the binhost
 	// before declaring success (a broken artifact must never sit in the repo
 	// marked "success").
-	if !m.CloudSettings().SkipVerifyInstall {
+	// A set has no single binpkg to install-verify; its members were each
+	// collected above.
+	if isPackageSet(req.PackageName) && !m.CloudSettings().SkipVerifyInstall {
+		m.appendJobLog(jobID, "[verify] skipped for package set "+req.PackageName)
+		m.updateStatus(jobID, "completed", instance.ID, "")
+	} else if !m.CloudSettings().SkipVerifyInstall {
 		if err := m.verifyOnInstance(jobID, instance, req, verifyBinhost); err != nil {
 			m.setFailedStage(jobID, "verify")
 			m.removeJobArtifact(jobID)
@@ -532,31 +782,6 @@ func (m *Manager) processCloudBuild(jobID string, req *BuildRequest) {
 	}
 }
 
-// waitForBuilderReady polls the instance builder's /health until it responds
-// or a timeout elapses (the builder binds its port a moment after the deploy
-// script's systemctl restart returns). Returns false if it never comes up.
-func (m *Manager) waitForBuilderReady(jobID string, instance *iac.Instance) bool {
-	baseURL := normalizeBuilderURL(instance.BuilderEndpoint)
-	for attempt := 0; attempt < 24; attempt++ {
-		resp, err := builderHTTPClient.Get(baseURL + "/health")
-		if err == nil {
-			ok := resp.StatusCode == http.StatusOK
-			_ = resp.Body.Close()
-			if ok {
-				if attempt > 0 {
-					m.appendJobLog(jobID, "[deploy] builder is up")
-				}
-				return true
-			}
-		}
-		if attempt == 0 {
-			m.appendJobLog(jobID, "[deploy] waiting for the builder service to come up…")
-		}
-		time.Sleep(5 * time.Second)
-	}
-	return false
-}
-
 // builderHealthy probes a warm instance's builder, tolerating a short
 // restart window (e.g. a just-updated builder binary coming back up).
 func (m *Manager) builderHealthy(instance *iac.Instance) bool {
@@ -801,6 +1026,8 @@ func (m *Manager) buildProvisionRequest(req *BuildRequest) (*iac.ProvisionReques
 		spec = gcpSpecWithDefaults(cs, req.MachineSpec)
 	case "aws":
 		spec = awsSpecWithDefaults(cs, req.MachineSpec)
+	case "hetzner":
+		spec = hetznerSpecWithDefaults(m.config, req.MachineSpec)
 	}
 
 	preq := &iac.ProvisionRequest{
@@ -832,6 +1059,11 @@ func (m *Manager) buildProvisionRequest(req *BuildRequest) (*iac.ProvisionReques
 		MakeConfExtra:        cs.MakeConfExtra,
 		BuildFeatures:        cs.BuildFeatures,
 		BuildMode:            cs.BuildMode,
+		PrebakedImage:        cs.PrebakedImage && (provider == "gcp" || provider == "aws"),
+		Labels:               map[string]string{},
+	}
+	if req.Owner != "" {
+		preq.Labels[iac.LabelOwner] = req.Owner
 	}
 	// Deploy in-emerge signing when explicitly enabled, or always for native
 	// Gentoo VMs (where portage's post-sign self-verify actually works). The
@@ -862,13 +1094,27 @@ func (m *Manager) CloudSettings() *config.CloudSettings {
 // In-flight builds keep the snapshot they started with; subsequent builds use
 // the new values. Used by the server's settings API (dashboard-managed config).
 func (m *Manager) UpdateCloudSettings(s *config.CloudSettings) {
-	m.cloudSettings.Store(s.Clone())
+	cs := s.Clone()
+	dedupeSettingsBuilders(cs)
+	m.cloudSettings.Store(cs)
+}
+
+// ReloadConfig applies the reloadable fields of a re-read server config: the
+// remote builder list and the scheduling strategy. Like a settings update it
+// affects the builds dispatched from now on, never those in flight. The
+// caller serializes it with the other cloud settings updates.
+func (m *Manager) ReloadConfig(cfg *config.ServerConfig) {
+	cs := m.CloudSettings().Clone()
+	cs.RemoteBuilders = cfg.RemoteBuilders
+	m.UpdateCloudSettings(cs)
+	strategy := cfg.SchedulingStrategy
+	m.strategy.Store(&strategy)
 }
 
 // remoteBuilders returns the current static builder list (runtime-adjustable
-// via the settings API).
+// via the settings API), one entry per builder.
 func (m *Manager) remoteBuilders() []string {
-	return m.CloudSettings().RemoteBuilders
+	return m.RemoteBuilders()
 }
 
 // pveSpecWithDefaults merges the runtime PVE settings into the per-request
@@ -908,7 +1154,7 @@ func pveSpecWithDefaults(cs *config.CloudSettings, reqSpec map[string]string) ma
 // gcpSpecWithDefaults merges the runtime GCP settings into the per-request
 // machine spec (request values win).
 func gcpSpecWithDefaults(cs *config.CloudSettings, reqSpec map[string]string) map[string]string {
-	spec := make(map[string]string, len(reqSpec)+3)
+	spec := make(map[string]string, len(reqSpec)+4)
 	set := func(key, value string) {
 		if value != "" {
 			spec[key] = value
@@ -917,6 +1163,7 @@ func gcpSpecWithDefaults(cs *config.CloudSettings, reqSpec map[string]string) ma
 	set("project", cs.GCPProject)
 	set("region", cs.GCPRegion)
 	set("zone", cs.GCPZone)
+	set("image", cs.GCPImage)
 	maps.Copy(spec, reqSpec)
 	return spec
 }
@@ -924,7 +1171,7 @@ func gcpSpecWithDefaults(cs *config.CloudSettings, reqSpec map[string]string) ma
 // awsSpecWithDefaults merges the runtime AWS settings into the per-request
 // machine spec (request values win).
 func awsSpecWithDefaults(cs *config.CloudSettings, reqSpec map[string]string) map[string]string {
-	spec := make(map[string]string, len(reqSpec)+2)
+	spec := make(map[string]string, len(reqSpec)+5)
 	set := func(key, value string) {
 		if value != "" {
 			spec[key] = value
@@ -932,13 +1179,32 @@ func awsSpecWithDefaults(cs *config.CloudSettings, reqSpec map[string]string) ma
 	}
 	set("region", cs.AWSRegion)
 	set("zone", cs.AWSZone)
+	set("ami", cs.AWSAMI)
+	if cs.AWSSpot {
+		spec["spot"] = "true"
+		set("max_spot_price", cs.AWSMaxSpotPrice)
+	}
+	maps.Copy(spec, reqSpec)
+	return spec
+}
+
+// hetznerSpecWithDefaults merges the configured Hetzner location and server
+// type into the per-request machine spec (request values win).
+func hetznerSpecWithDefaults(cfg *config.ServerConfig, reqSpec map[string]string) map[string]string {
+	spec := make(map[string]string, len(reqSpec)+2)
+	if cfg.CloudHetznerLocation != "" {
+		spec["location"] = cfg.CloudHetznerLocation
+	}
+	if cfg.CloudHetznerServerType != "" {
+		spec["server_type"] = cfg.CloudHetznerServerType
+	}
 	maps.Copy(spec, reqSpec)
 	return spec
 }
 
 // cloudCredentials maps configuration into IaC cloud credentials. PVE, GCP,
-// and AWS credentials come from the runtime-adjustable settings; Aliyun (a
-// non-functional stub provider) remains conf/env-only.
+// and AWS credentials come from the runtime-adjustable settings; the Hetzner
+// token and Aliyun (a non-functional stub provider) remain conf/env-only.
 func (m *Manager) cloudCredentials(cs *config.CloudSettings) *iac.CloudCredentials {
 	return &iac.CloudCredentials{
 		AliyunAccessKey: m.config.CloudAliyunAK,
@@ -946,6 +1212,7 @@ func (m *Manager) cloudCredentials(cs *config.CloudSettings) *iac.CloudCredentia
 		GCPKeyFile:      cs.GCPKeyFile,
 		AWSAccessKey:    cs.AWSAccessKey,
 		AWSSecretKey:    cs.AWSSecretKey,
+		HetznerToken:    m.config.CloudHetznerToken,
 		PVETokenID:      cs.PVETokenID,
 		PVETokenSecret:  cs.PVETokenSecret,
 		PVEUsername:     cs.PVEUsername,
@@ -992,14 +1259,27 @@ func (m *Manager) runBuildOnInstance(jobID string, instance *iac.Instance, req *
 		m.updateStatus(jobID, snap.Status, instance.ID, snap.Error)
 
 		if snap.Terminal {
+			m.setSuggestedConfig(jobID, snap.SuggestedConfig)
+			m.setBuildScript(jobID, snap.Script)
 			if snap.Status == "failed" {
+				m.setFailureCategory(jobID, snap.FailureCategory)
+				m.setResolutionErrors(jobID, snap.ResolutionErrors)
 				return fmt.Errorf("remote build failed: %s", snap.Error)
 			}
+			if snap.Status == "success_no_artifact" {
+				return fmt.Errorf("%w: %s", errNoArtifact, snap.Error)
+			}
 			// Success: pull every produced package off the instance into the
 			// central binhost BEFORE the VM can go away. The requested
 			// package's own file becomes the job's primary artifact;
 			// dependencies land alongside it (category preserved).
-			if err := m.collectInstanceArtifacts(jobID, baseURL, remoteJobID, req.PackageName, snap); err != nil {
+			// An ephemeral job's artifact goes to the client instead.
+			if req.Ephemeral {
+				err = m.deliverEphemeral(jobID, baseURL, remoteJobID, snap.ArtifactURL)
+			} else {
+				err = m.collectInstanceArtifacts(jobID, baseURL, remoteJobID, req.PackageName, snap)
+			}
+			if err != nil {
 				return fmt.Errorf("build succeeded on instance but artifact retrieval failed: %w", err)
 			}
 			return nil
@@ -1007,15 +1287,24 @@ func (m *Manager) runBuildOnInstance(jobID string, instance *iac.Instance, req *
 	}
 }
 
-// postBuildToBuilder submits a build to a builder base URL and returns its job ID.
+// postBuildToBuilder submits a build to a builder base URL and returns its job
+// ID. Builders speaking an older API than the server supports are refused.
 func (m *Manager) postBuildToBuilder(baseURL string, req *BuildRequest) (string, error) {
+	if err := m.checkBuilderAPI(baseURL); err != nil {
+		return "", err
+	}
 	localReq := LocalBuildRequest{
-		PackageName:  req.PackageName,
-		Version:      req.Version,
-		Arch:         req.Arch,
-		UseFlags:     make(map[string]string),
-		Environment:  make(map[string]string),
-		ConfigBundle: req.ConfigBundle,
+		PackageName:   req.PackageName,
+		Version:       req.Version,
+		Arch:          req.Arch,
+		UseFlags:      make(map[string]string),
+		Environment:   make(map[string]string),
+		ConfigBundle:  req.ConfigBundle,
+		AcceptLicense: req.AcceptLicense,
+		BuildTimeout:  req.BuildTimeout,
+		ForceRebuild:  req.ForceRebuild,
+		Pretend:       req.Pretend,
+		APIVersion:    APIVersion,
 	}
 	for _, flag := range req.UseFlags {
 		if name, found := strings.CutPrefix(flag, "-"); found {
@@ -1058,8 +1347,15 @@ func (m *Manager) postBuildToBuilder(baseURL string, req *BuildRequest) (string,
 	return buildResp.JobID, nil
 }
 
-// fetchInstanceJob queries a builder job's status once. buildLog is the remote
-// job's full build log so far (streamed into the local job log as a delta).
+// remoteMetadata is the part of a builder job's Metadata the server uses.
+type remoteMetadata struct {
+	Signed          bool           `json:"signed"`
+	SuggestedConfig *PortageConfig `json:"suggested_config"`
+	FailureCategory string         `json:"failure_category"`
+	// ResolutionErrors is the builder's Metadata["resolution_errors"].
+	ResolutionErrors []ResolutionError `json:"resolution_errors"`
+}
+
 // remoteJobSnapshot is one poll of a builder-side job.
 type remoteJobSnapshot struct {
 	Status      string
@@ -1069,8 +1365,19 @@ type remoteJobSnapshot struct {
 	Artifacts   []string
 	Signed      bool
 	Terminal    bool
-}
-
+	// SuggestedConfig is the builder's Metadata["suggested_config"].
+	SuggestedConfig *PortageConfig
+	// FailureCategory is the builder's Metadata["failure_category"].
+	FailureCategory string
+	// ResolutionErrors is the builder's Metadata["resolution_errors"].
+	ResolutionErrors []ResolutionError
+	// Script is the build script the builder ran.
+	Script string
+}
+
+// fetchInstanceJob queries a builder job's status once. The snapshot's Log is
+// the remote job's full build log so far (streamed into the local job log as
+// a delta).
 func (m *Manager) fetchInstanceJob(statusURL string) (*remoteJobSnapshot, error) {
 	resp, err := m.getFromBuilder(statusURL)
 	if err != nil {
@@ -1088,29 +1395,32 @@ func (m *Manager) fetchInstanceJob(statusURL string) (*remoteJobSnapshot, error)
 		Log         string         `json:"log"`
 		ArtifactURL string         `json:"artifact_url"`
 		Artifacts   []string       `json:"artifacts"`
-		Metadata    map[string]any `json:"metadata"`
+		Metadata    remoteMetadata `json:"metadata"`
+		Script      string         `json:"script"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
 		return nil, err
 	}
 
-	signed, _ := job.Metadata["signed"].(bool)
 	return &remoteJobSnapshot{
-		Status:      job.Status,
-		Error:       job.Error,
-		Log:         job.Log,
-		ArtifactURL: job.ArtifactURL,
-		Artifacts:   job.Artifacts,
-		Signed:      signed,
-		Terminal:    job.Status == "completed" || job.Status == "failed" || job.Status == "success",
+		Status:           job.Status,
+		Error:            job.Error,
+		Log:              job.Log,
+		ArtifactURL:      job.ArtifactURL,
+		Artifacts:        job.Artifacts,
+		Signed:           job.Metadata.Signed,
+		Terminal:         terminalStatus(job.Status),
+		SuggestedConfig:  job.Metadata.SuggestedConfig,
+		FailureCategory:  job.Metadata.FailureCategory,
+		ResolutionErrors: job.Metadata.ResolutionErrors,
+		Script:           job.Script,
 	}, nil
 }
 
 // submitToRemoteBuilder forwards a build request to a configured static remote
-// builder. Builders are tried round-robin starting from a rotating cursor
-// (previously every job went to RemoteBuilders[0], so additional builders
-// never received work); if submission to one fails, the next is tried before
-// the job is marked failed.
+// builder. Disabled builders are skipped, and the scheduling strategy orders
+// the rest (see builderOrder); if submission to one fails, the next is tried
+// before the job is marked failed.
 func (m *Manager) submitToRemoteBuilder(jobID string, req *BuildRequest) {
 	builders := m.remoteBuilders()
 	if len(builders) == 0 {
@@ -1118,40 +1428,67 @@ func (m *Manager) submitToRemoteBuilder(jobID string, req *BuildRequest) {
 		return
 	}
 
-	start := int(m.rrNext.Add(1)-1) % len(builders)
+	builders, err := m.enabledBuilders(builders)
+	if err == nil {
+		builders, err = m.compatibleBuilders(builders)
+	}
+	if err == nil {
+		builders, err = m.archBuilders(builders, req.Arch)
+	}
+	if err != nil {
+		m.updateStatus(jobID, "failed", "", err.Error())
+		return
+	}
+	order, err := m.builderOrder(builders)
+	if err == nil {
+		order, err = m.treeFreshOrder(order)
+	}
+	if err != nil {
+		m.updateStatus(jobID, "failed", "", err.Error())
+		return
+	}
 	var lastErr error
-	for i := 0; i < len(builders); i++ {
-		builderURL := normalizeBuilderURL(builders[(start+i)%len(builders)])
+	for _, addr := range order {
+		builderURL := normalizeBuilderURL(addr)
 		if err := m.submitToBuilderAt(jobID, "", builderURL, req); err != nil {
 			lastErr = err
-			fmt.Printf("Warning: build %s submission to builder %s failed: %v\n", jobID, builderURL, err)
+			fmt.Printf("Warning: build %s (request %s) submission to builder %s failed: %v\n", jobID, req.RequestID, builderURL, err)
 			continue
 		}
 		return
 	}
-	m.updateStatus(jobID, "failed", "", fmt.Sprintf("all %d remote builder(s) rejected the build, last error: %v", len(builders), lastErr))
+	m.updateStatus(jobID, "failed", "", fmt.Sprintf("all %d remote builder(s) rejected the build, last error: %v", len(order), lastErr))
 }
 
 // submitToBuilderAt forwards a build request to a specific builder base URL and
 // starts polling it. builderAddr is the address recorded for status polling; if
 // empty, baseURL is used. Submission failures are returned (not written to the
-// job) so the caller can try another builder before giving up.
+// job) so the caller can try another builder before giving up; that includes
+// builders whose API version is incompatible with the server's.
 func (m *Manager) submitToBuilderAt(jobID, builderAddr, baseURL string, req *BuildRequest) error {
 	if builderAddr == "" {
 		builderAddr = baseURL
 	}
+	if err := m.checkBuilderAPI(baseURL); err != nil {
+		return err
+	}
 	builderURL := fmt.Sprintf("%s/api/v1/build", baseURL)
 
 	m.updateStatus(jobID, "forwarding", "", "")
 
 	// Convert BuildRequest to LocalBuildRequest format
 	localReq := LocalBuildRequest{
-		PackageName:  req.PackageName, // Already in category/package format from server
-		Version:      req.Version,
-		Arch:         req.Arch,
-		UseFlags:     make(map[string]string),
-		Environment:  make(map[string]string),
-		ConfigBundle: req.ConfigBundle, // Forward the full config bundle when present.
+		PackageName:   req.PackageName, // Already in category/package format from server
+		Version:       req.Version,
+		Arch:          req.Arch,
+		UseFlags:      make(map[string]string),
+		Environment:   make(map[string]string),
+		ConfigBundle:  req.ConfigBundle, // Forward the full config bundle when present.
+		AcceptLicense: req.AcceptLicense,
+		BuildTimeout:  req.BuildTimeout,
+		ForceRebuild:  req.ForceRebuild,
+		Pretend:       req.Pretend,
+		APIVersion:    APIVersion,
 	}
 
 	// Convert UseFlags from []string to map[string]string
@@ -1175,6 +1512,9 @@ func (m *Manager) submitToBuilderAt(jobID, builderAddr, baseURL string, req *Bui
 		return fmt.Errorf("failed to build request: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	if req.RequestID != "" {
+		httpReq.Header.Set(requestid.Header, req.RequestID)
+	}
 	setBuilderAuth(httpReq, m.config.BuilderToken)
 
 	resp, err := builderHTTPClient.Do(httpReq)
@@ -1198,6 +1538,11 @@ func (m *Manager) submitToBuilderAt(jobID, builderAddr, baseURL string, req *Bui
 	m.jobsMu.Lock()
 	m.remoteBuilds[jobID] = buildResp.JobID
 	m.jobsMu.Unlock()
+	machine := m.builderID(builderAddr)
+	if machine == "" {
+		machine = config.CanonicalBuilderURL(builderAddr)
+	}
+	m.setMachineType(jobID, machine)
 
 	// Start polling remote builder for status
 	go m.pollRemoteBuilder(jobID, builderAddr, buildResp.JobID)
@@ -1218,6 +1563,7 @@ func (m *Manager) pollRemoteBuilder(localJobID, builderAddr, remoteJobID string)
 	defer ticker.Stop()
 
 	failures := 0
+	lastLogLen := 0
 	for range ticker.C {
 		resp, err := m.getFromBuilder(statusURL)
 		if err != nil {
@@ -1240,13 +1586,15 @@ func (m *Manager) pollRemoteBuilder(localJobID, builderAddr, remoteJobID string)
 		failures = 0
 
 		var remoteJob struct {
-			ID          string    `json:"id"`
-			Status      string    `json:"status"`
-			Error       string    `json:"error,omitempty"`
-			Log         string    `json:"log"`
-			ArtifactURL string    `json:"artifact_url"`
-			StartTime   time.Time `json:"start_time"`
-			EndTime     time.Time `json:"end_time"`
+			ID          string         `json:"id"`
+			Status      string         `json:"status"`
+			Error       string         `json:"error,omitempty"`
+			Log         string         `json:"log"`
+			ArtifactURL string         `json:"artifact_url"`
+			StartTime   time.Time      `json:"start_time"`
+			EndTime     time.Time      `json:"end_time"`
+			Metadata    remoteMetadata `json:"metadata"`
+			Script      string         `json:"script"`
 		}
 		if err := json.NewDecoder(resp.Body).Decode(&remoteJob); err != nil {
 			_ = resp.Body.Close()
@@ -1255,11 +1603,21 @@ func (m *Manager) pollRemoteBuilder(localJobID, builderAddr, remoteJobID string)
 		}
 		_ = resp.Body.Close()
 
+		// Forward the remote build log incrementally into the local job log,
+		// so live log streams follow the build before it finishes.
+		if len(remoteJob.Log) > lastLogLen {
+			m.appendJobLog(localJobID, strings.TrimRight(remoteJob.Log[lastLogLen:], "\n"))
+			lastLogLen = len(remoteJob.Log)
+		}
+
 		// Update local job with remote status including log
 		errorMsg := remoteJob.Error
 		if remoteJob.Log != "" {
 			errorMsg = remoteJob.Log
 		}
+		if terminalStatus(remoteJob.Status) && !remoteJob.StartTime.IsZero() && !remoteJob.EndTime.IsZero() {
+			m.setBuildTimes(localJobID, remoteJob.StartTime, remoteJob.EndTime)
+		}
 		m.updateStatus(localJobID, remoteJob.Status, "", errorMsg)
 
 		// Update artifact path if available
@@ -1272,12 +1630,26 @@ func (m *Manager) pollRemoteBuilder(localJobID, builderAddr, remoteJobID string)
 		}
 
 		// Stop polling if terminal state reached
-		if remoteJob.Status == "completed" || remoteJob.Status == "failed" || remoteJob.Status == "success" {
+		if terminalStatus(remoteJob.Status) {
+			m.setSuggestedConfig(localJobID, remoteJob.Metadata.SuggestedConfig)
+			m.setBuildScript(localJobID, remoteJob.Script)
+			if remoteJob.Status == "failed" {
+				m.setFailureCategory(localJobID, remoteJob.Metadata.FailureCategory)
+				m.setResolutionErrors(localJobID, remoteJob.Metadata.ResolutionErrors)
+			}
 			// On success, pull the artifact into the central binhost so builds
 			// from every builder converge into one consumable Packages index.
 			// A static builder stays alive, so on failure the remote reference
 			// is kept (the artifact proxy can still serve it) and we only warn.
-			if remoteJob.Status != "failed" && remoteJob.ArtifactURL != "" {
+			if ephemeral, _ := m.ephemeralTarget(localJobID); ephemeral && remoteJob.Status != "failed" && remoteJob.ArtifactURL != "" {
+				// The client wants the bytes, not a binhost entry; a failed
+				// delivery fails the job since no copy was kept.
+				if err := m.deliverEphemeral(localJobID, baseURL, remoteJobID, remoteJob.ArtifactURL); err != nil {
+					m.appendJobLog(localJobID, "[collect] ephemeral delivery failed: "+err.Error())
+					m.setFailedStage(localJobID, "collect")
+					m.updateStatus(localJobID, "failed", "", fmt.Sprintf("artifact delivery failed: %v", err))
+				}
+			} else if remoteJob.Status != "failed" && remoteJob.ArtifactURL != "" {
 				if localPath, webPath, err := m.fetchArtifactToBinhost(baseURL, remoteJobID, m.jobPackageName(localJobID), remoteJob.ArtifactURL); err != nil {
 					fmt.Printf("Warning: failed to pull artifact for job %s into binhost: %v\n", localJobID, err)
 				} else {
@@ -1321,6 +1693,7 @@ func (m *Manager) appendJobLog(jobID, line string) {
 	line = ansiEscapes.ReplaceAllString(line, "")
 	line = strings.ReplaceAll(line, "\r", "")
 	job.Log += line + "\n"
+	m.logSubs[jobID].publish(line + "\n")
 	if len(job.Log) > maxJobLogBytes {
 		head := job.Log[:maxJobLogBytes/4]
 		if i := strings.LastIndexByte(head, '\n'); i > 0 {
@@ -1341,9 +1714,42 @@ func (m *Manager) ListInstances() []*iac.Instance {
 	return m.iacMgr.ListInstances()
 }
 
+// RegisterExternalInstance tracks the builder at endpoint, e.g. a physical
+// build box, as an instance for cloud builds to run on (see
+// iac.Manager.RegisterExternalInstance).
+func (m *Manager) RegisterExternalInstance(endpoint, arch string) (*iac.Instance, error) {
+	return m.iacMgr.RegisterExternalInstance(endpoint, arch)
+}
+
+// DeregisterExternalInstance stops tracking an external instance. It refuses
+// provisioned instances, which only the server's own lifecycle destroys.
+func (m *Manager) DeregisterExternalInstance(instanceID string) error {
+	inst, err := m.iacMgr.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+	if inst.Provider != iac.ExternalProvider {
+		return fmt.Errorf("instance %s is not an external instance", instanceID)
+	}
+	return m.iacMgr.Terminate(instanceID)
+}
+
+// PlanInstance runs `terraform plan` for the instance a cloud build of req
+// would provision, without creating anything. The returned instance has
+// status "planned" and carries the plan summary.
+func (m *Manager) PlanInstance(req *BuildRequest) (*iac.Instance, error) {
+	provReq, err := m.buildProvisionRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	provReq.DryRun = true
+	return m.iacMgr.Provision(provReq)
+}
+
 // terminalStatus reports whether a job status is final.
 func terminalStatus(s string) bool {
-	return s == "failed" || s == "completed" || s == "success"
+	return s == "failed" || s == "completed" || s == "success" || s == "success_no_artifact" || s == expiredStatus ||
+		s == cancelledStatus
 }
 
 // DeleteJob removes a terminal job record. In-flight jobs are refused so a
@@ -1358,7 +1764,7 @@ func (m *Manager) DeleteJob(jobID string) error {
 	if !terminalStatus(job.Status) {
 		return fmt.Errorf("job %s is %s; only finished jobs can be deleted", jobID, job.Status)
 	}
-	delete(m.jobs, jobID)
+	m.deleteJobLocked(jobID)
 	return nil
 }
 
@@ -1369,7 +1775,7 @@ func (m *Manager) CleanupFailedJobs() int {
 	n := 0
 	for id, job := range m.jobs {
 		if job.Status == "failed" {
-			delete(m.jobs, id)
+			m.deleteJobLocked(id)
 			n++
 		}
 	}
@@ -1385,6 +1791,61 @@ func (m *Manager) setFailedStage(jobID, stage string) {
 	}
 }
 
+// setFailureCategory records the build phase a builder attributed a failure
+// to, and whether the failure is retryable; "" leaves the job untouched.
+func (m *Manager) setFailureCategory(jobID, category string) {
+	if category == "" {
+		return
+	}
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+	if job, ok := m.jobs[jobID]; ok {
+		job.FailureCategory = category
+		job.Retryable = RetryableFailure(category)
+	}
+}
+
+// rescheduleSpotInterrupted reports whether a build that failed on instance
+// should run again on a fresh one: its builder failed it because the spot
+// instance is being reclaimed, and it has been rescheduled fewer than
+// maxSpotReschedules times. The reclaimed instance is destroyed.
+func (m *Manager) rescheduleSpotInterrupted(jobID string, instance *iac.Instance) bool {
+	m.jobsMu.Lock()
+	job, ok := m.jobs[jobID]
+	if !ok || job.FailureCategory != FailureSpotInterruption || job.SpotReschedules >= maxSpotReschedules {
+		m.jobsMu.Unlock()
+		return false
+	}
+	job.SpotReschedules++
+	attempt := job.SpotReschedules
+	job.FailureCategory = ""
+	job.Retryable = false
+	job.buildStart = time.Time{} // time the build on the fresh instance
+	m.jobsMu.Unlock()
+
+	m.appendJobLog(jobID, fmt.Sprintf("[build] spot instance %s is being reclaimed; rescheduling the build on a fresh instance (%d of %d)",
+		instance.ID, attempt, maxSpotReschedules))
+	go func() {
+		if err := m.iacMgr.Terminate(instance.ID); err != nil {
+			m.appendJobLog(jobID, fmt.Sprintf("[cleanup] destroy of reclaimed instance %s failed (cleanup will retry): %v", instance.ID, err))
+		}
+	}()
+	return true
+}
+
+// setResolutionErrors records the dependency resolution errors a remote
+// builder extracted from a failed job's log.
+func (m *Manager) setResolutionErrors(jobID string, errs []ResolutionError) {
+	if len(errs) == 0 {
+		return
+	}
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+	if job, ok := m.jobs[jobID]; ok {
+		job.ResolutionErrors = errs
+	}
+}
+
 // jobPackageName returns a job's package atom ("category/name"), or "".
 func (m *Manager) jobPackageName(jobID string) string {
 	m.jobsMu.RLock()
@@ -1395,13 +1856,39 @@ func (m *Manager) jobPackageName(jobID string) string {
 	return ""
 }
 
+// setSuggestedConfig records the autounmask changes a builder reported for a
+// job; nil leaves the job untouched.
+func (m *Manager) setSuggestedConfig(jobID string, cfg *PortageConfig) {
+	if cfg == nil {
+		return
+	}
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+	if job, exists := m.jobs[jobID]; exists {
+		job.SuggestedConfig = cfg
+	}
+}
+
+// setBuildScript records the build script a builder reported for a job; ""
+// leaves the job untouched.
+func (m *Manager) setBuildScript(jobID, script string) {
+	if script == "" {
+		return
+	}
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+	if job, exists := m.jobs[jobID]; exists {
+		job.Script = script
+	}
+}
+
 // updateStatus updates the status of a build job.
 func (m *Manager) updateStatus(jobID, status, instanceID, errorMsg string) {
 	m.jobsMu.Lock()
 	defer m.jobsMu.Unlock()
 
 	if job, exists := m.jobs[jobID]; exists {
-		job.Status = status
+		m.setStatusLocked(job, status)
 		job.UpdatedAt = time.Now()
 		if instanceID != "" {
 			job.InstanceID = instanceID
@@ -1412,34 +1899,120 @@ func (m *Manager) updateStatus(jobID, status, instanceID, errorMsg string) {
 	}
 }
 
-// ListAllBuilds returns all build jobs, including those from remote builders.
-func (m *Manager) ListAllBuilds() []*BuildStatus {
+// FindBuildsByPackage returns copies of the server's jobs for a package,
+// newest first. atom is a category/package atom; a bare package name matches
+// any category. Empty version or arch match any value.
+func (m *Manager) FindBuildsByPackage(atom, version, arch string) []*BuildStatus {
 	m.jobsMu.RLock()
-	localBuilds := make([]*BuildStatus, 0, len(m.jobs))
+	now := time.Now()
+	var matches []*BuildStatus
 	for _, job := range m.jobs {
-		// Copy under the lock so concurrent updateStatus writes don't race the
-		// caller's reads / JSON encoding.
-		jobCopy := *job
-		localBuilds = append(localBuilds, &jobCopy)
+		if !packageMatches(job.PackageName, atom) ||
+			(version != "" && job.Version != version) ||
+			(arch != "" && job.Arch != arch) {
+			continue
+		}
+		matches = append(matches, statusView(job, now))
 	}
 	m.jobsMu.RUnlock()
 
-	// Aggregate builds from remote builders
-	remoteBuilds := m.fetchRemoteBuilderJobs()
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].CreatedAt.After(matches[j].CreatedAt)
+	})
+	return matches
+}
 
-	// Merge local and remote builds, avoiding duplicates
-	allBuilds := localBuilds
-	localJobIDs := make(map[string]bool)
-	for _, job := range localBuilds {
+// packageMatches reports whether a job's package name is the queried atom.
+func packageMatches(name, atom string) bool {
+	if name == atom {
+		return true
+	}
+	if !strings.Contains(atom, "/") {
+		_, pkg, found := strings.Cut(name, "/")
+		return found && pkg == atom
+	}
+	return false
+}
+
+// BuildFilter selects and pages the builds ListBuilds returns. Zero fields
+// select everything.
+type BuildFilter struct {
+	// Statuses keeps only builds in one of these statuses.
+	Statuses []string
+	// Package keeps only builds whose package name contains it, ignoring case.
+	Package string
+	// Arch keeps only builds for this architecture.
+	Arch string
+	// Order is the list order, newest first by default.
+	Order ListOrder
+	// Offset skips this many matching builds.
+	Offset int
+	// Limit caps the page size; 0 means no limit.
+	Limit int
+}
+
+// matches reports whether b passes the filter's selection.
+func (f BuildFilter) matches(b *BuildStatus) bool {
+	if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, b.Status) {
+		return false
+	}
+	if f.Package != "" && !strings.Contains(strings.ToLower(b.PackageName), strings.ToLower(f.Package)) {
+		return false
+	}
+	return f.Arch == "" || b.Arch == f.Arch
+}
+
+// BuildPage is one page of a filtered build list.
+type BuildPage struct {
+	Builds []*BuildStatus `json:"builds"`
+	// Total is the number of builds matching the filter across all pages.
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// ListAllBuilds returns all build jobs, including those from remote
+// builders, newest first.
+func (m *Manager) ListAllBuilds() []*BuildStatus {
+	return m.ListBuilds(BuildFilter{}).Builds
+}
+
+// ListBuilds returns the page of build jobs, local and from remote builders,
+// that match filter, in filter.Order.
+func (m *Manager) ListBuilds(filter BuildFilter) BuildPage {
+	m.jobsMu.RLock()
+	now := time.Now()
+	localJobIDs := make(map[string]bool, len(m.jobs))
+	builds := make([]*BuildStatus, 0, len(m.jobs))
+	for _, job := range m.jobs {
 		localJobIDs[job.JobID] = true
+		// Copy under the lock so concurrent updateStatus writes don't race the
+		// caller's reads / JSON encoding.
+		if view := statusView(job, now); filter.matches(view) {
+			builds = append(builds, view)
+		}
 	}
-	for _, job := range remoteBuilds {
-		if !localJobIDs[job.JobID] {
-			allBuilds = append(allBuilds, job)
+	m.jobsMu.RUnlock()
+
+	// Aggregate builds from remote builders, skipping jobs also known locally
+	for _, job := range m.fetchRemoteBuilderJobs() {
+		if !localJobIDs[job.JobID] && filter.matches(job) {
+			builds = append(builds, job)
 		}
 	}
 
-	return allBuilds
+	sort.Slice(builds, func(i, j int) bool {
+		return filter.Order.less(buildSortFields(builds[i]), buildSortFields(builds[j]))
+	})
+
+	page := BuildPage{Total: len(builds), Offset: filter.Offset, Limit: filter.Limit}
+	start := min(max(filter.Offset, 0), len(builds))
+	end := len(builds)
+	if filter.Limit > 0 {
+		end = min(start+filter.Limit, end)
+	}
+	page.Builds = builds[start:end]
+	return page
 }
 
 // fetchRemoteBuilderJobs fetches jobs from all configured remote builders.
@@ -1529,51 +2102,59 @@ func (m *Manager) fetchRemoteBuilderJobs() []*BuildStatus {
 
 // ClusterStatus represents the overall cluster status.
 type ClusterStatus struct {
-	ActiveBuilds    int       `json:"active_builds"`
-	QueuedBuilds    int       `json:"queued_builds"`
-	ActiveInstances int       `json:"active_instances"`
-	TotalBuilds     int       `json:"total_builds"`
-	CompletedBuilds int       `json:"completed_builds"`
-	FailedBuilds    int       `json:"failed_builds"`
-	SuccessRate     float64   `json:"success_rate"`
-	LastUpdated     time.Time `json:"last_updated"`
+	ActiveBuilds    int `json:"active_builds"`
+	QueuedBuilds    int `json:"queued_builds"`
+	ActiveInstances int `json:"active_instances"`
+	TotalBuilds     int `json:"total_builds"`
+	CompletedBuilds int `json:"completed_builds"`
+	FailedBuilds    int `json:"failed_builds"`
+	// ExpiredBuilds were cancelled unstarted when their deadline passed.
+	ExpiredBuilds int `json:"expired_builds"`
+	// CancelledBuilds were cancelled on request, queued or running.
+	CancelledBuilds int `json:"cancelled_builds"`
+	// ReapedInstances counts the cloud instances auto-terminated since the
+	// server started, by reason: idle, stale_heartbeat or max_lifetime.
+	ReapedInstances map[string]int `json:"reaped_instances"`
+	// SuccessRate is CompletedBuilds / (CompletedBuilds + FailedBuilds) as a
+	// percentage, i.e. over finished builds only: queued, in-progress and
+	// expired builds are excluded, and success_no_artifact counts as
+	// completed. It is 0 while nothing has finished.
+	SuccessRate float64   `json:"success_rate"`
+	LastUpdated time.Time `json:"last_updated"`
 }
 
 // GetClusterStatus returns the current cluster status.
-// It aggregates status from local jobs and remote builders.
+// It aggregates status from local jobs and remote builders. Local counts are
+// maintained incrementally on status transitions and remote builder stats
+// are cached for remoteStatsTTL, so frequent polling stays cheap.
 func (m *Manager) GetClusterStatus() *ClusterStatus {
 	status := &ClusterStatus{
 		LastUpdated: time.Now(),
 	}
 
-	// Count local jobs
 	m.jobsMu.RLock()
-	for _, job := range m.jobs {
-		status.TotalBuilds++
-		switch job.Status {
-		case "claimed", "building", "provisioning", "forwarding":
-			status.ActiveBuilds++
-		case "queued":
-			status.QueuedBuilds++
-		case "completed":
-			status.CompletedBuilds++
-		case "failed":
-			status.FailedBuilds++
-		}
-	}
+	status.TotalBuilds = m.counts.total
+	status.QueuedBuilds = m.counts.queued
+	status.ActiveBuilds = m.counts.active
+	status.CompletedBuilds = m.counts.completed
+	status.FailedBuilds = m.counts.failed
+	status.ExpiredBuilds = m.counts.expired
+	status.CancelledBuilds = m.counts.cancelled
 	m.jobsMu.RUnlock()
 
 	// Aggregate stats from remote builders
-	remoteStats := m.fetchRemoteBuilderStats()
+	remoteStats := m.cachedRemoteStats()
 	status.TotalBuilds += remoteStats.TotalBuilds
 	status.ActiveBuilds += remoteStats.ActiveBuilds
 	status.QueuedBuilds += remoteStats.QueuedBuilds
 	status.CompletedBuilds += remoteStats.CompletedBuilds
 	status.FailedBuilds += remoteStats.FailedBuilds
+	status.CancelledBuilds += remoteStats.CancelledBuilds
 	status.ActiveInstances += remoteStats.ActiveInstances
 
 	// Get active instances count from IaC manager
 	status.ActiveInstances += len(m.iacMgr.ListInstances())
+	status.ReapedInstances = m.iacMgr.ReapedCounts()
 
 	// Calculate success rate
 	if status.CompletedBuilds+status.FailedBuilds > 0 {
@@ -1593,6 +2174,7 @@ func (m *Manager) fetchRemoteBuilderStats() *ClusterStatus {
 
 	var mu sync.Mutex
 	var wg sync.WaitGroup
+	counted := make(map[string]bool)
 
 	client := &http.Client{Timeout: 5 * time.Second}
 
@@ -1614,24 +2196,37 @@ func (m *Manager) fetchRemoteBuilderStats() *ClusterStatus {
 			}
 
 			var builderStatus struct {
-				Workers   int `json:"workers"`
-				Queued    int `json:"queued"`
-				Building  int `json:"building"`
-				Completed int `json:"completed"`
-				Failed    int `json:"failed"`
-				Total     int `json:"total"`
+				InstanceID string `json:"instance_id"`
+				Workers    int    `json:"workers"`
+				Queued     int    `json:"queued"`
+				Building   int    `json:"building"`
+				Completed  int    `json:"completed"`
+				Failed     int    `json:"failed"`
+				Cancelled  int    `json:"cancelled"`
+				Total      int    `json:"total"`
 			}
 
 			if err := json.NewDecoder(resp.Body).Decode(&builderStatus); err != nil {
 				return
 			}
+			m.recordBuilderID(addr, builderStatus.InstanceID)
 
 			mu.Lock()
+			// Addresses not yet known to be duplicates are caught here by the
+			// instance ID each one reports.
+			if id := builderStatus.InstanceID; id != "" {
+				if counted[id] {
+					mu.Unlock()
+					return
+				}
+				counted[id] = true
+			}
 			stats.TotalBuilds += builderStatus.Total
 			stats.QueuedBuilds += builderStatus.Queued
 			stats.ActiveBuilds += builderStatus.Building
 			stats.CompletedBuilds += builderStatus.Completed
 			stats.FailedBuilds += builderStatus.Failed
+			stats.CancelledBuilds += builderStatus.Cancelled
 			// Count each remote builder with workers as an active instance
 			if builderStatus.Workers > 0 {
 				stats.ActiveInstances++
@@ -1694,6 +2289,8 @@ func (m *Manager) formatLocalLogs(status *BuildStatus) string {
 		if status.ArtifactPath != "" {
 			logs += fmt.Sprintf("Artifact: %s\n", status.ArtifactPath)
 		}
+	case "success_no_artifact":
+		logs += fmt.Sprintf("\nBuild completed but produced no artifact: %s\n", status.Error)
 	case "failed":
 		logs += fmt.Sprintf("\nBuild failed: %s\n", status.Error)
 	case "building":
@@ -1817,15 +2414,53 @@ func (m *Manager) UpdateBuilderHeartbeat(req *HeartbeatRequest) error {
 		return fmt.Errorf("builder_id is required")
 	}
 
-	// For now, just validate the request
-	// In the future, this could update scheduler state
 	if req.Status == "" {
 		return fmt.Errorf("status is required")
 	}
 
+	// Learn the builder's identity so a builder configured under two
+	// addresses is only scheduled and counted once.
+	m.recordBuilderID(req.Endpoint, req.BuilderID)
+	m.RecordBuilderTree(req.Endpoint, req.TreeLastSync, req.TreeRevision)
+	m.RecordBuilderAPIVersion(req.Endpoint, req.APIVersion)
+	if req.Architecture != "" {
+		m.recordBuilderArch(req.Endpoint, req.Architecture, req.EmulatedArchitectures...)
+	}
+	// Builders deployed on cloud instances register under the instance ID;
+	// their heartbeats keep the instance from being reaped as stale. External
+	// instances are matched by endpoint instead. Other builders are not
+	// tracked by the IaC manager.
+	if err := m.iacMgr.UpdateHeartbeat(req.BuilderID); err != nil && req.Endpoint != "" {
+		_ = m.iacMgr.UpdateExternalHeartbeat(req.Endpoint)
+	}
 	return nil
 }
 
+// instanceActiveJobs asks the builder on a cloud instance how many jobs it
+// has queued or building.
+func (m *Manager) instanceActiveJobs(inst *iac.Instance) (int, error) {
+	if inst.BuilderEndpoint == "" {
+		return 0, fmt.Errorf("instance %s has no builder endpoint", inst.ID)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := m.builderGet(client, strings.TrimRight(normalizeBuilderURL(inst.BuilderEndpoint), "/")+"/api/v1/status")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("builder status returned %d", resp.StatusCode)
+	}
+	var status struct {
+		Queued   int `json:"queued"`
+		Building int `json:"building"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return 0, err
+	}
+	return status.Queued + status.Building, nil
+}
+
 // normalizeBuilderURL ensures the builder address has the correct URL format.
 // It handles cases where the address may or may not include the http:// prefix.
 func normalizeBuilderURL(address string) string {
@@ -1949,6 +2584,7 @@ func (m *Manager) fetchArtifactRelToBinhost(baseURL, remoteJobID, rel string) (s
 		return "", "", err
 	}
 	setBuilderAuth(httpReq, m.config.BuilderToken)
+	httpReq.Header.Set("Accept-Encoding", acceptArtifactEncodings)
 
 	resp, err := artifactHTTPClient.Do(httpReq)
 	if err != nil {
@@ -1959,33 +2595,38 @@ func (m *Manager) fetchArtifactRelToBinhost(baseURL, remoteJobID, rel string) (s
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
 		return "", "", fmt.Errorf("artifact download returned %d: %s", resp.StatusCode, string(body))
 	}
+	body, err := decodeArtifactBody(resp)
+	if err != nil {
+		return "", "", fmt.Errorf("download artifact: %w", err)
+	}
+	defer func() { _ = body.Close() }()
 
 	dest := filepath.Join(m.config.BinpkgPath, filepath.FromSlash(clean))
 	destDir := filepath.Dir(dest)
 	if err := os.MkdirAll(destDir, 0o755); err != nil { // #nosec G301 -- binhost dirs are served publicly.
-		return "", "", fmt.Errorf("create binhost dir: %w", err)
+		return "", "", binhostWriteError(fmt.Errorf("create binhost dir: %w", err))
 	}
 	tmp, err := os.CreateTemp(destDir, ".artifact-*")
 	if err != nil {
-		return "", "", err
+		return "", "", binhostWriteError(err)
 	}
 	tmpName := tmp.Name()
-	if _, err := io.Copy(tmp, resp.Body); err != nil {
+	if _, err := io.Copy(tmp, body); err != nil {
 		_ = tmp.Close()
 		_ = os.Remove(tmpName)
 		return "", "", fmt.Errorf("write artifact: %w", err)
 	}
 	if err := tmp.Close(); err != nil {
 		_ = os.Remove(tmpName)
-		return "", "", err
+		return "", "", binhostWriteError(err)
 	}
 	if err := os.Chmod(tmpName, 0o644); err != nil { // #nosec G302 -- binpkgs are served publicly.
 		_ = os.Remove(tmpName)
-		return "", "", err
+		return "", "", binhostWriteError(err)
 	}
 	if err := os.Rename(tmpName, dest); err != nil {
 		_ = os.Remove(tmpName)
-		return "", "", err
+		return "", "", binhostWriteError(err)
 	}
 	return dest, "/binpkgs/" + clean, nil
 }
@@ -2001,6 +2642,7 @@ func (m *Manager) fetchArtifactToBinhost(baseURL, remoteJobID, packageName, remo
 		return "", "", err
 	}
 	setBuilderAuth(httpReq, m.config.BuilderToken)
+	httpReq.Header.Set("Accept-Encoding", acceptArtifactEncodings)
 
 	resp, err := artifactHTTPClient.Do(httpReq)
 	if err != nil {
@@ -2011,6 +2653,11 @@ func (m *Manager) fetchArtifactToBinhost(baseURL, remoteJobID, packageName, remo
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
 		return "", "", fmt.Errorf("artifact download returned %d: %s", resp.StatusCode, string(body))
 	}
+	body, err := decodeArtifactBody(resp)
+	if err != nil {
+		return "", "", fmt.Errorf("download artifact: %w", err)
+	}
+	defer func() { _ = body.Close() }()
 
 	filename := artifactFilename(resp.Header.Get("Content-Disposition"), remoteArtifact)
 	if filename == "" {
@@ -2025,33 +2672,33 @@ func (m *Manager) fetchArtifactToBinhost(baseURL, remoteJobID, packageName, remo
 	}
 	destDir := filepath.Join(m.config.BinpkgPath, category)
 	if err := os.MkdirAll(destDir, 0o755); err != nil { // #nosec G301 -- binhost dirs must be world-readable for the HTTP file server.
-		return "", "", fmt.Errorf("create binhost dir: %w", err)
+		return "", "", binhostWriteError(fmt.Errorf("create binhost dir: %w", err))
 	}
 
 	// Download to a temp file and rename, so a concurrent index scan never
 	// sees a half-written package.
 	tmp, err := os.CreateTemp(destDir, ".artifact-*")
 	if err != nil {
-		return "", "", err
+		return "", "", binhostWriteError(err)
 	}
 	tmpName := tmp.Name()
-	if _, err := io.Copy(tmp, resp.Body); err != nil {
+	if _, err := io.Copy(tmp, body); err != nil {
 		_ = tmp.Close()
 		_ = os.Remove(tmpName)
 		return "", "", fmt.Errorf("write artifact: %w", err)
 	}
 	if err := tmp.Close(); err != nil {
 		_ = os.Remove(tmpName)
-		return "", "", err
+		return "", "", binhostWriteError(err)
 	}
 	if err := os.Chmod(tmpName, 0o644); err != nil { // #nosec G302 -- binpkgs are served publicly by the binhost.
 		_ = os.Remove(tmpName)
-		return "", "", err
+		return "", "", binhostWriteError(err)
 	}
 	dest := filepath.Join(destDir, filename)
 	if err := os.Rename(tmpName, dest); err != nil {
 		_ = os.Remove(tmpName)
-		return "", "", err
+		return "", "", binhostWriteError(err)
 	}
 
 	if m.onArtifactStored != nil {
@@ -2064,6 +2711,12 @@ func (m *Manager) fetchArtifactToBinhost(baseURL, remoteJobID, packageName, remo
 	return dest, "/binpkgs/" + rel, nil
 }
 
+// binhostWriteError marks a failed write into the binhost PKGDIR, so a
+// misconfigured store is reported as such rather than as a generic failure.
+func binhostWriteError(err error) error {
+	return fmt.Errorf("%w: %v", binpkg.ErrStoreUnwritable, err)
+}
+
 // artifactFilename extracts a safe filename from a Content-Disposition header,
 // falling back to the basename of the builder-side artifact path.
 func artifactFilename(disposition, remoteArtifact string) string {
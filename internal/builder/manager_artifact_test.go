@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"sync/atomic"
 	"testing"
 
+	"github.com/slchris/portage-engine/internal/binpkg"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
@@ -75,3 +77,28 @@ func TestArtifactFilename(t *testing.T) {
 		}
 	}
 }
+
+// TestFetchArtifactToUnwritableBinhost verifies a PKGDIR write failure is
+// reported as a storage error, and that new builds are refused up front.
+func TestFetchArtifactToUnwritableBinhost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="jq-1.7-1.gpkg.tar"`)
+		_, _ = w.Write([]byte("fake gpkg bytes"))
+	}))
+	defer srv.Close()
+
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 1, BinpkgPath: filepath.Join(blocker, "binpkgs")})
+	defer mgr.Shutdown()
+
+	_, _, err := mgr.fetchArtifactToBinhost(srv.URL, "rjob-1", "app-misc/jq", "jq-1.7-1.gpkg.tar")
+	if !errors.Is(err, binpkg.ErrStoreUnwritable) {
+		t.Errorf("fetchArtifactToBinhost() error = %v, want ErrStoreUnwritable", err)
+	}
+	if _, err := mgr.SubmitBuild(&BuildRequest{PackageName: "app-misc/jq", Arch: "amd64"}); !errors.Is(err, binpkg.ErrStoreUnwritable) {
+		t.Errorf("SubmitBuild() error = %v, want ErrStoreUnwritable", err)
+	}
+}
@@ -2,14 +2,18 @@ package builder
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/slchris/portage-engine/internal/iac"
+	"github.com/slchris/portage-engine/internal/requestid"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
@@ -441,6 +445,31 @@ func TestUpdateBuilderHeartbeat(t *testing.T) {
 	}
 }
 
+// TestUpdateBuilderHeartbeatExternalInstance verifies an external builder's
+// heartbeat, sent under its own ID, reaches its instance by endpoint.
+func TestUpdateBuilderHeartbeatExternalInstance(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 1})
+	inst, err := mgr.RegisterExternalInstance("http://buildbox:9090", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := time.Now()
+
+	if err := mgr.UpdateBuilderHeartbeat(&HeartbeatRequest{BuilderID: "buildbox", Status: "healthy", Endpoint: "buildbox:9090"}); err != nil {
+		t.Fatal(err)
+	}
+	if inst.LastHeartbeat.Before(since) {
+		t.Error("external instance heartbeat not recorded")
+	}
+
+	if err := mgr.DeregisterExternalInstance(inst.ID); err != nil {
+		t.Fatalf("DeregisterExternalInstance() = %v", err)
+	}
+	if err := mgr.DeregisterExternalInstance(inst.ID); err == nil {
+		t.Error("deregistered an unknown instance")
+	}
+}
+
 // TestUpdateBuilderHeartbeatConcurrent tests concurrent heartbeat updates.
 func TestUpdateBuilderHeartbeatConcurrent(_ *testing.T) {
 	cfg := &config.ServerConfig{
@@ -690,10 +719,13 @@ func TestBuildProvisionRequest(t *testing.T) {
 		ServerCallbackURL: "http://srv:8080", CloudInstanceTTL: 30,
 		CloudGCPKeyFile: "/gcp.json",
 	}}
-	pr, err := m.buildProvisionRequest(&BuildRequest{Arch: "amd64"})
+	pr, err := m.buildProvisionRequest(&BuildRequest{Arch: "amd64", Owner: "alice"})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if pr.Labels[iac.LabelOwner] != "alice" {
+		t.Errorf("labels = %v, want the owner", pr.Labels)
+	}
 	if pr.Provider != "gcp" || pr.SSH.KeyPath != "/k" || pr.ServerCallback != "http://srv:8080" {
 		t.Errorf("unexpected provision request: %+v", pr)
 	}
@@ -703,6 +735,172 @@ func TestBuildProvisionRequest(t *testing.T) {
 	if pr.Credentials == nil || pr.Credentials.GCPKeyFile != "/gcp.json" {
 		t.Errorf("credentials not mapped: %+v", pr.Credentials)
 	}
+
+	// Hetzner: conf/env token and spec defaults, request spec wins.
+	m = &Manager{config: &config.ServerConfig{
+		CloudProvider: "hetzner", CloudSSHKeyPath: "/k", ServerCallbackURL: "http://srv:8080",
+		CloudHetznerToken: "hc-token", CloudHetznerLocation: "nbg1", CloudHetznerServerType: "cpx31",
+	}}
+	pr, err = m.buildProvisionRequest(&BuildRequest{Arch: "amd64", MachineSpec: map[string]string{"server_type": "cpx51"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pr.Spec["location"] != "nbg1" || pr.Spec["server_type"] != "cpx51" || pr.Credentials.HetznerToken != "hc-token" {
+		t.Errorf("hetzner provision request: spec %v, credentials %+v", pr.Spec, pr.Credentials)
+	}
+}
+
+func TestFetchInstanceJobSuggestedConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"metadata": map[string]interface{}{
+				"signed": true,
+				"suggested_config": map[string]interface{}{
+					"package_keywords": map[string][]string{"=dev-lang/foo-2.0": {"~amd64"}},
+				},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(&config.ServerConfig{})
+	defer mgr.Shutdown()
+
+	snap, err := mgr.fetchInstanceJob(srv.URL + "/api/v1/jobs/r1")
+	if err != nil {
+		t.Fatalf("fetchInstanceJob() error = %v", err)
+	}
+	if !snap.Signed || !snap.Terminal {
+		t.Errorf("snapshot = %+v, want signed and terminal", snap)
+	}
+	if snap.SuggestedConfig == nil || snap.SuggestedConfig.PackageKeywords["=dev-lang/foo-2.0"][0] != "~amd64" {
+		t.Fatalf("SuggestedConfig = %+v, want the builder's keyword change", snap.SuggestedConfig)
+	}
+
+	jobID, err := mgr.SubmitBuild(&BuildRequest{PackageName: "dev-lang/foo", Arch: "amd64"})
+	if err != nil {
+		t.Fatalf("SubmitBuild() error = %v", err)
+	}
+	mgr.setSuggestedConfig(jobID, snap.SuggestedConfig)
+	if status, _ := mgr.GetStatus(jobID); status.SuggestedConfig != snap.SuggestedConfig {
+		t.Error("suggested config not recorded on the job")
+	}
+}
+
+func TestFetchInstanceJobScript(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "failed",
+			"script": "#!/bin/bash\nexport USE=\"-X \"\n",
+		})
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(&config.ServerConfig{})
+	defer mgr.Shutdown()
+
+	snap, err := mgr.fetchInstanceJob(srv.URL + "/api/v1/jobs/r1")
+	if err != nil {
+		t.Fatalf("fetchInstanceJob() error = %v", err)
+	}
+	jobID, err := mgr.SubmitBuild(&BuildRequest{PackageName: "dev-lang/foo", Arch: "amd64"})
+	if err != nil {
+		t.Fatalf("SubmitBuild() error = %v", err)
+	}
+	mgr.setBuildScript(jobID, snap.Script)
+	if status, _ := mgr.GetStatus(jobID); status.Script != "#!/bin/bash\nexport USE=\"-X \"\n" {
+		t.Errorf("Script = %q, want the builder's", status.Script)
+	}
+}
+
+// TestSubmitToIncompatibleBuilder verifies the server refuses to forward a job
+// to a builder whose API version is below MinBuilderAPIVersion, and that
+// forwarded requests carry the server's APIVersion.
+func TestSubmitToIncompatibleBuilder(t *testing.T) {
+	var mu sync.Mutex
+	builderAPI := 0
+	probes := 0
+	var forwarded []LocalBuildRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/api/v1/status":
+			probes++
+			_ = json.NewEncoder(w).Encode(map[string]int{"api_version": builderAPI})
+		case "/api/v1/build":
+			var req LocalBuildRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			forwarded = append(forwarded, req)
+			_ = json.NewEncoder(w).Encode(map[string]string{"job_id": "r1", "status": "queued"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 1})
+	defer mgr.Shutdown()
+	req := &BuildRequest{PackageName: "app-misc/jq"}
+
+	err := mgr.submitToBuilderAt("job-1", "", srv.URL, req)
+	if !errors.Is(err, ErrIncompatibleBuilder) {
+		t.Fatalf("submitToBuilderAt() to a pre-negotiation builder = %v, want ErrIncompatibleBuilder", err)
+	}
+	if _, err := mgr.postBuildToBuilder(srv.URL, req); !errors.Is(err, ErrIncompatibleBuilder) {
+		t.Errorf("postBuildToBuilder() = %v, want ErrIncompatibleBuilder", err)
+	}
+	mu.Lock()
+	if len(forwarded) != 0 {
+		t.Errorf("job forwarded to an incompatible builder: %+v", forwarded)
+	}
+	if probes != 1 {
+		t.Errorf("status probed %d times, want once: the version is cached", probes)
+	}
+	builderAPI = APIVersion
+	mu.Unlock()
+
+	// The upgraded builder's next heartbeat refreshes the cached version.
+	mgr.RecordBuilderAPIVersion(srv.URL, APIVersion)
+	for range 2 {
+		if _, err := mgr.postBuildToBuilder(srv.URL, req); err != nil {
+			t.Fatalf("postBuildToBuilder() to a compatible builder: %v", err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(forwarded) != 2 || forwarded[0].APIVersion != APIVersion {
+		t.Errorf("forwarded = %+v, want two requests with api_version %d", forwarded, APIVersion)
+	}
+	if probes != 1 {
+		t.Errorf("status probed %d times after the heartbeat, want no new probes", probes)
+	}
+}
+
+func TestCompatibleBuilders(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 1})
+	defer mgr.Shutdown()
+
+	if err := mgr.UpdateBuilderHeartbeat(&HeartbeatRequest{
+		BuilderID: "old", Status: "online", Endpoint: "http://old:9090",
+	}); err != nil {
+		t.Fatalf("UpdateBuilderHeartbeat() error = %v", err)
+	}
+	mgr.RecordBuilderAPIVersion("new:9090", APIVersion)
+
+	got, err := mgr.compatibleBuilders([]string{"old:9090", "new:9090", "unknown:9090"})
+	if err != nil {
+		t.Fatalf("compatibleBuilders() error = %v", err)
+	}
+	if want := []string{"new:9090", "unknown:9090"}; !slices.Equal(got, want) {
+		t.Errorf("compatibleBuilders() = %v, want %v", got, want)
+	}
+
+	if _, err := mgr.compatibleBuilders([]string{"old:9090"}); !errors.Is(err, ErrIncompatibleBuilder) {
+		t.Errorf("compatibleBuilders() with only incompatible builders = %v, want ErrIncompatibleBuilder", err)
+	}
 }
 
 // TestConcurrentDuplicateSubmissionsClaimedOnce is the regression test for the
@@ -717,6 +915,10 @@ func TestConcurrentDuplicateSubmissionsClaimedOnce(t *testing.T) {
 	// counts submissions; GET /api/v1/jobs/<id> reports it completed.
 	var counter int
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/status" {
+			_ = json.NewEncoder(w).Encode(map[string]int{"api_version": APIVersion})
+			return
+		}
 		if r.Method == http.MethodPost && r.URL.Path == "/api/v1/build" {
 			mu.Lock()
 			counter++
@@ -842,3 +1044,120 @@ func TestSubmitBuildFullQueueLeavesNoOrphan(t *testing.T) {
 		t.Errorf("full-queue submission left an orphan job: before=%d after=%d", before, after)
 	}
 }
+
+func TestFindBuildsByPackage(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 1})
+	defer mgr.Shutdown()
+
+	now := time.Now()
+	mgr.LoadJobs(map[string]*BuildStatus{
+		"old":   {JobID: "old", Status: "failed", PackageName: "dev-lang/python", Version: "3.11", Arch: "amd64", CreatedAt: now.Add(-2 * time.Hour)},
+		"new":   {JobID: "new", Status: "success", PackageName: "dev-lang/python", Version: "3.11", Arch: "amd64", CreatedAt: now.Add(-time.Hour)},
+		"arm":   {JobID: "arm", Status: "building", PackageName: "dev-lang/python", Version: "3.11", Arch: "arm64", CreatedAt: now},
+		"other": {JobID: "other", Status: "success", PackageName: "app-misc/jq", CreatedAt: now},
+	})
+
+	ids := func(jobs []*BuildStatus) []string {
+		var out []string
+		for _, j := range jobs {
+			out = append(out, j.JobID)
+		}
+		return out
+	}
+	tests := []struct {
+		atom, version, arch string
+		want                []string
+	}{
+		{"dev-lang/python", "", "", []string{"arm", "new", "old"}},
+		{"dev-lang/python", "3.11", "amd64", []string{"new", "old"}},
+		{"python", "", "arm64", []string{"arm"}},
+		{"dev-lang/python", "3.12", "", nil},
+		{"dev-python/python", "", "", nil},
+	}
+	for _, tt := range tests {
+		got := ids(mgr.FindBuildsByPackage(tt.atom, tt.version, tt.arch))
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("FindBuildsByPackage(%q, %q, %q) = %v, want %v", tt.atom, tt.version, tt.arch, got, tt.want)
+		}
+	}
+}
+
+func TestListBuilds(t *testing.T) {
+	now := time.Now()
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/jobs" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"id": "remote", "status": "failed", "start_time": now.Add(-30 * time.Minute),
+				"request": map[string]string{"package_name": "dev-lang/python", "version": "3.12", "arch": "arm64"}},
+			// Also known locally: listed once.
+			{"id": "new", "status": "failed", "start_time": now,
+				"request": map[string]string{"package_name": "dev-lang/python"}},
+		})
+	}))
+	defer remote.Close()
+
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 1, RemoteBuilders: []string{remote.URL}})
+	defer mgr.Shutdown()
+	mgr.LoadJobs(map[string]*BuildStatus{
+		"old":   {JobID: "old", Status: "failed", PackageName: "dev-lang/python", Arch: "amd64", CreatedAt: now.Add(-2 * time.Hour)},
+		"new":   {JobID: "new", Status: "success", PackageName: "dev-lang/python", Arch: "amd64", CreatedAt: now.Add(-time.Hour)},
+		"other": {JobID: "other", Status: "success", PackageName: "app-misc/jq", Arch: "amd64", CreatedAt: now},
+	})
+
+	tests := []struct {
+		filter BuildFilter
+		want   []string
+		total  int
+	}{
+		{BuildFilter{}, []string{"other", "remote", "new", "old"}, 4},
+		{BuildFilter{Limit: 2}, []string{"other", "remote"}, 4},
+		{BuildFilter{Offset: 2, Limit: 2}, []string{"new", "old"}, 4},
+		{BuildFilter{Offset: 10}, nil, 4},
+		{BuildFilter{Statuses: []string{"failed"}}, []string{"remote", "old"}, 2},
+		{BuildFilter{Package: "PYTHON", Limit: 1}, []string{"remote"}, 3},
+		{BuildFilter{Arch: "arm64"}, []string{"remote"}, 1},
+		{BuildFilter{Statuses: []string{"success", "queued"}, Package: "jq"}, []string{"other"}, 1},
+	}
+	for _, tt := range tests {
+		page := mgr.ListBuilds(tt.filter)
+		var got []string
+		for _, b := range page.Builds {
+			got = append(got, b.JobID)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") || page.Total != tt.total {
+			t.Errorf("ListBuilds(%+v) = %v (total %d), want %v (total %d)", tt.filter, got, page.Total, tt.want, tt.total)
+		}
+	}
+}
+
+// TestSubmitForwardsRequestID verifies the server passes the submitting
+// request's ID on to the builder it forwards the build to.
+func TestSubmitForwardsRequestID(t *testing.T) {
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/status":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"api_version": APIVersion})
+		case "/api/v1/build":
+			got <- r.Header.Get(requestid.Header)
+			_ = json.NewEncoder(w).Encode(map[string]string{"job_id": "remote-1", "status": "queued"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+	mgr.LoadJobs(map[string]*BuildStatus{"j1": {JobID: "j1", Status: "queued", PackageName: "app-misc/jq"}})
+
+	if err := mgr.submitToBuilderAt("j1", "", srv.URL, &BuildRequest{PackageName: "app-misc/jq", RequestID: "req-42"}); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-got; id != "req-42" {
+		t.Errorf("builder got %s %q, want req-42", requestid.Header, id)
+	}
+}
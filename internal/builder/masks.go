@@ -0,0 +1,115 @@
+// Package builder provides detection of masked-package build failures.
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxMaskReports bounds how many masked packages a failure message lists;
+// the full set stays in the job metadata and log.
+const maxMaskReports = 5
+
+var (
+	// One entry of emerge's "masked packages" list, e.g.
+	//   - dev-lang/foo-2.0::gentoo (masked by: ~amd64 keyword)
+	//   - sys-kernel/linux-firmware-20240312::gentoo (masked by: linux-fw-redistributable license(s))
+	maskedEntryPattern = regexp.MustCompile(`(?m)^- ([^\s:]+)(?:::\S+)? \(masked by: (.*)\)\s*$`)
+
+	// The version suffix of a cpv ("-2.0", "-1.0_rc1-r2", "-9999").
+	cpvVersionPattern = regexp.MustCompile(`-[0-9]+(\.[0-9]+)*[a-z]?((_alpha|_beta|_pre|_rc|_p)[0-9]*)*(-r[0-9]+)?$`)
+)
+
+// MaskedPackage is a package emerge refused to build because it is masked,
+// with the mask reasons as emerge printed them.
+type MaskedPackage struct {
+	CPV     string   `json:"cpv"`
+	Reasons []string `json:"reasons"`
+}
+
+// parseMaskedPackages extracts the masked packages emerge listed in a build
+// log. Emerge lists every masked version; only the first (newest) one per
+// package is kept, since that is the version it wanted.
+func parseMaskedPackages(buildLog string) []MaskedPackage {
+	seen := map[string]bool{}
+	var masked []MaskedPackage
+	for _, m := range maskedEntryPattern.FindAllStringSubmatch(buildLog, -1) {
+		cp := cpvVersionPattern.ReplaceAllString(m[1], "")
+		if seen[cp] {
+			continue
+		}
+		seen[cp] = true
+		var reasons []string
+		for _, r := range strings.Split(m[2], ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				reasons = append(reasons, r)
+			}
+		}
+		masked = append(masked, MaskedPackage{CPV: m[1], Reasons: reasons})
+	}
+	return masked
+}
+
+// missingLicenses returns the sorted, de-duplicated licenses that kept the
+// masked packages from building, or nil if none was license-masked.
+func missingLicenses(masked []MaskedPackage) []string {
+	seen := map[string]bool{}
+	var licenses []string
+	for _, mp := range masked {
+		for _, r := range mp.Reasons {
+			names, ok := strings.CutSuffix(r, " license(s)")
+			if !ok {
+				continue
+			}
+			for _, l := range strings.Fields(names) {
+				if !seen[l] {
+					seen[l] = true
+					licenses = append(licenses, l)
+				}
+			}
+		}
+	}
+	sort.Strings(licenses)
+	return licenses
+}
+
+// maskHint turns one emerge mask reason into guidance the user can act on.
+func maskHint(cpv, reason string) string {
+	if kw, ok := strings.CutSuffix(reason, " keyword"); ok {
+		switch {
+		case kw == "**" || kw == "missing":
+			return fmt.Sprintf("%s has no keyword for this arch (live or unkeyworded ebuild); add ** to package.accept_keywords", cpv)
+		case strings.HasPrefix(kw, "-"):
+			return fmt.Sprintf("%s is keyworded %s (known broken on this arch); pick another version", cpv, kw)
+		default:
+			return fmt.Sprintf("%s is masked by keyword; add %s to package.accept_keywords", cpv, kw)
+		}
+	}
+	if names, ok := strings.CutSuffix(reason, " license(s)"); ok {
+		return fmt.Sprintf("%s requires license(s) not accepted: %s; grant them with accept_license on the request or ACCEPT_LICENSE on the builder", cpv, names)
+	}
+	if reason == "package.mask" {
+		return fmt.Sprintf("%s is masked by package.mask; add =%s to package.unmask", cpv, cpv)
+	}
+	if strings.HasPrefix(reason, "EAPI ") {
+		return fmt.Sprintf("%s uses unsupported %s; update portage on the builder", cpv, reason)
+	}
+	return fmt.Sprintf("%s is masked by %s", cpv, reason)
+}
+
+// maskReport summarises masked packages as a single actionable error message.
+func maskReport(masked []MaskedPackage) string {
+	var hints []string
+	for i, mp := range masked {
+		if i == maxMaskReports {
+			hints = append(hints, fmt.Sprintf("and %d more masked package(s)", len(masked)-maxMaskReports))
+			break
+		}
+		for _, r := range mp.Reasons {
+			hints = append(hints, maskHint(mp.CPV, r))
+		}
+	}
+	return strings.Join(hints, "; ")
+}
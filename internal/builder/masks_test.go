@@ -0,0 +1,61 @@
+package builder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const maskedLog = `!!! All ebuilds that could satisfy "dev-lang/foo" have been masked.
+!!! One of the following masked packages is required to complete your request:
+- dev-lang/foo-2.0::gentoo (masked by: ~amd64 keyword)
+- dev-lang/foo-1.9-r1::gentoo (masked by: ~amd64 keyword)
+- dev-lang/foo-9999::gentoo (masked by: ** keyword)
+- sys-kernel/linux-firmware-20240312::gentoo (masked by: linux-fw-redistributable license(s))
+- app-misc/bar-1.0_rc1::gentoo (masked by: package.mask, ~amd64 keyword, no-source-code license(s))
+`
+
+func TestParseMaskedPackages(t *testing.T) {
+	want := []MaskedPackage{
+		{CPV: "dev-lang/foo-2.0", Reasons: []string{"~amd64 keyword"}},
+		{CPV: "sys-kernel/linux-firmware-20240312", Reasons: []string{"linux-fw-redistributable license(s)"}},
+		{CPV: "app-misc/bar-1.0_rc1", Reasons: []string{"package.mask", "~amd64 keyword", "no-source-code license(s)"}},
+	}
+	if got := parseMaskedPackages(maskedLog); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMaskedPackages() = %+v, want %+v", got, want)
+	}
+	if got := parseMaskedPackages("emerge: there are no ebuilds to satisfy \"x/y\"\n"); got != nil {
+		t.Errorf("parseMaskedPackages() on an unrelated failure = %v, want nil", got)
+	}
+}
+
+func TestMissingLicenses(t *testing.T) {
+	want := []string{"linux-fw-redistributable", "no-source-code"}
+	if got := missingLicenses(parseMaskedPackages(maskedLog)); !reflect.DeepEqual(got, want) {
+		t.Errorf("missingLicenses() = %v, want %v", got, want)
+	}
+	if got := missingLicenses([]MaskedPackage{{CPV: "a/b-1", Reasons: []string{"package.mask"}}}); got != nil {
+		t.Errorf("missingLicenses() on a non-license mask = %v, want nil", got)
+	}
+}
+
+func TestMaskReport(t *testing.T) {
+	report := maskReport(parseMaskedPackages(maskedLog))
+	for _, want := range []string{
+		"dev-lang/foo-2.0 is masked by keyword; add ~amd64 to package.accept_keywords",
+		"sys-kernel/linux-firmware-20240312 requires license(s) not accepted: linux-fw-redistributable",
+		"app-misc/bar-1.0_rc1 is masked by package.mask; add =app-misc/bar-1.0_rc1 to package.unmask",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("maskReport() = %q, missing %q", report, want)
+		}
+	}
+
+	var many []MaskedPackage
+	for _, p := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		many = append(many, MaskedPackage{CPV: "cat/" + p + "-1", Reasons: []string{"~amd64 keyword"}})
+	}
+	if report := maskReport(many); !strings.HasSuffix(report, "and 2 more masked package(s)") {
+		t.Errorf("maskReport() should cap the list, got %q", report)
+	}
+}
@@ -0,0 +1,164 @@
+// Package builder provides multi-architecture build requests.
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxMultiArchTargets bounds how many architectures one request may fan out to.
+const maxMultiArchTargets = 8
+
+// ErrMultiArchNotFound is returned for an unknown multi-arch request ID.
+var ErrMultiArchNotFound = errors.New("multi-arch build not found")
+
+// MultiArchStatus is one logical build of a package for several
+// architectures: a per-arch job for each, correlated by a shared group ID.
+type MultiArchStatus struct {
+	ID          string         `json:"id"`
+	Status      string         `json:"status"` // queued, building, success, partial, failed
+	PackageName string         `json:"package_name"`
+	Version     string         `json:"version"`
+	Jobs        []*BuildStatus `json:"jobs"` // one per arch, sorted by arch
+	// Artifacts maps each arch to the binpkg web paths its job produced.
+	Artifacts map[string][]string `json:"artifacts,omitempty"`
+}
+
+// SubmitMultiArchBuild fans req out into one job per architecture under a new
+// group ID. Each job is an ordinary build with req.Arch set, so it is routed
+// and tracked like any other. It returns the group ID and the job ID per arch;
+// if queueing fails part-way, the jobs already queued are returned with the
+// error and stay part of the group.
+func (m *Manager) SubmitMultiArchBuild(req *BuildRequest, arches []string) (string, map[string]string, error) {
+	targets, err := multiArchTargets(arches)
+	if err != nil {
+		return "", nil, err
+	}
+	// Reject a bad request before queueing any arch.
+	if err := validateBuildRequest(req); err != nil {
+		return "", nil, err
+	}
+	if err := m.checkCallbackTarget(req.CallbackURL); err != nil {
+		return "", nil, err
+	}
+	if err := m.checkBinhostWritable(); err != nil {
+		return "", nil, err
+	}
+
+	// The arch jobs share one bundle: strip denied FEATURES once up front and
+	// let each job log what was removed.
+	if !req.AllowDeniedFeatures {
+		req.strippedFeatures = m.featuresPolicy().Apply(req)
+	}
+
+	groupID := uuid.New().String()
+	jobs := make(map[string]string, len(targets))
+	for _, arch := range targets {
+		archReq := *req
+		archReq.Arch = arch
+		archReq.GroupID = groupID
+		jobID, err := m.SubmitBuild(&archReq)
+		if err != nil {
+			return groupID, jobs, fmt.Errorf("queued %d of %d architectures, %s failed: %w", len(jobs), len(targets), arch, err)
+		}
+		jobs[arch] = jobID
+	}
+	return groupID, jobs, nil
+}
+
+// multiArchTargets validates and de-duplicates the requested architectures.
+// Each must be a known Gentoo arch: keywords such as "~amd64" or "**" are
+// not architectures a job can be routed to.
+func multiArchTargets(arches []string) ([]string, error) {
+	seen := map[string]bool{}
+	var targets []string
+	for _, arch := range arches {
+		if arch == "" || seen[arch] {
+			continue
+		}
+		if !knownArches[arch] {
+			return nil, fmt.Errorf("%w: unknown arch %q", ErrInvalidBuildRequest, arch)
+		}
+		seen[arch] = true
+		targets = append(targets, arch)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%w: at least one arch is required", ErrInvalidBuildRequest)
+	}
+	if len(targets) > maxMultiArchTargets {
+		return nil, fmt.Errorf("%w: too many architectures: %d (max %d)", ErrInvalidBuildRequest, len(targets), maxMultiArchTargets)
+	}
+	return targets, nil
+}
+
+// GetMultiArchBuild returns the combined status of a multi-arch request.
+func (m *Manager) GetMultiArchBuild(groupID string) (*MultiArchStatus, error) {
+	m.jobsMu.RLock()
+	now := time.Now()
+	var jobs []*BuildStatus
+	for _, job := range m.jobs {
+		if job.GroupID == groupID {
+			jobs = append(jobs, statusView(job, now))
+		}
+	}
+	m.jobsMu.RUnlock()
+
+	if groupID == "" || len(jobs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrMultiArchNotFound, groupID)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Arch < jobs[j].Arch })
+
+	status := &MultiArchStatus{
+		ID:          groupID,
+		Status:      multiArchState(jobs),
+		PackageName: jobs[0].PackageName,
+		Version:     jobs[0].Version,
+		Jobs:        jobs,
+	}
+	for _, job := range jobs {
+		artifacts := job.Artifacts
+		if len(artifacts) == 0 && job.ArtifactURL != "" {
+			artifacts = []string{job.ArtifactURL}
+		}
+		if len(artifacts) > 0 {
+			if status.Artifacts == nil {
+				status.Artifacts = make(map[string][]string)
+			}
+			status.Artifacts[job.Arch] = artifacts
+		}
+	}
+	return status, nil
+}
+
+// multiArchState summarises per-arch job states: queued until any job starts,
+// building until every job is terminal, then success when every arch produced
+// a package, failed when none did, and partial otherwise.
+func multiArchState(jobs []*BuildStatus) string {
+	queued, succeeded, running := 0, 0, 0
+	for _, job := range jobs {
+		switch {
+		case job.Status == "queued":
+			queued++
+		case job.Status == "success" || job.Status == "completed":
+			succeeded++
+		case !terminalStatus(job.Status):
+			running++
+		}
+	}
+	switch {
+	case queued == len(jobs):
+		return "queued"
+	case queued+running > 0:
+		return "building"
+	case succeeded == len(jobs):
+		return "success"
+	case succeeded == 0:
+		return "failed"
+	default:
+		return "partial"
+	}
+}
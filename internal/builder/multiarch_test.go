@@ -0,0 +1,121 @@
+package builder
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestSubmitMultiArchBuild(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 1})
+	defer mgr.Shutdown()
+
+	groupID, jobs, err := mgr.SubmitMultiArchBuild(&BuildRequest{PackageName: "dev-lang/python", Version: "3.11"},
+		[]string{"amd64", "arm64", "amd64"})
+	if err != nil {
+		t.Fatalf("SubmitMultiArchBuild() error = %v", err)
+	}
+	if len(jobs) != 2 || jobs["amd64"] == "" || jobs["arm64"] == "" {
+		t.Fatalf("jobs = %v, want one per distinct arch", jobs)
+	}
+	for arch, jobID := range jobs {
+		st, err := mgr.GetStatus(jobID)
+		if err != nil {
+			t.Fatalf("GetStatus(%s): %v", jobID, err)
+		}
+		if st.Arch != arch || st.GroupID != groupID {
+			t.Errorf("job %s: arch %q group %q, want %q %q", jobID, st.Arch, st.GroupID, arch, groupID)
+		}
+	}
+
+	status, err := mgr.GetMultiArchBuild(groupID)
+	if err != nil {
+		t.Fatalf("GetMultiArchBuild() error = %v", err)
+	}
+	if len(status.Jobs) != 2 || status.Jobs[0].Arch != "amd64" || status.Jobs[1].Arch != "arm64" {
+		t.Errorf("GetMultiArchBuild() jobs = %+v, want amd64 and arm64", status.Jobs)
+	}
+}
+
+func TestSubmitMultiArchBuildRejectsBadInput(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 1})
+	defer mgr.Shutdown()
+
+	for _, tc := range []struct {
+		req    *BuildRequest
+		arches []string
+	}{
+		{&BuildRequest{PackageName: "dev-lang/python"}, nil},
+		{&BuildRequest{PackageName: "dev-lang/python"}, []string{"amd64", "arm64;reboot"}},
+		{&BuildRequest{PackageName: "dev-lang/python"}, []string{"**"}},
+		{&BuildRequest{PackageName: "dev-lang/python"}, []string{"amd64", "~amd64"}},
+		{&BuildRequest{PackageName: "dev-lang/python"}, []string{"-arm64"}},
+		{&BuildRequest{PackageName: "dev-lang/python"}, []string{"amd64-linux"}},
+		{&BuildRequest{PackageName: "dev-lang/python", UseFlags: []string{"$(id)"}}, []string{"amd64", "arm64"}},
+	} {
+		if groupID, _, err := mgr.SubmitMultiArchBuild(tc.req, tc.arches); err == nil || groupID != "" {
+			t.Errorf("SubmitMultiArchBuild(%+v, %v) = %q, %v; want rejection", tc.req, tc.arches, groupID, err)
+		}
+	}
+	if builds := mgr.ListAllBuilds(); len(builds) != 0 {
+		t.Errorf("rejected requests queued %d job(s)", len(builds))
+	}
+	if _, _, err := mgr.SubmitMultiArchBuild(&BuildRequest{PackageName: "dev-lang/python"}, []string{"~amd64"}); !errors.Is(err, ErrInvalidBuildRequest) {
+		t.Errorf("SubmitMultiArchBuild(~amd64) error = %v, want ErrInvalidBuildRequest", err)
+	}
+	if _, err := mgr.GetMultiArchBuild("missing"); !errors.Is(err, ErrMultiArchNotFound) {
+		t.Errorf("GetMultiArchBuild(missing) error = %v, want ErrMultiArchNotFound", err)
+	}
+}
+
+func TestGetMultiArchBuildArtifacts(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 1})
+	defer mgr.Shutdown()
+	mgr.LoadJobs(map[string]*BuildStatus{
+		"a": {JobID: "a", GroupID: "g", Status: "success", PackageName: "app-misc/jq", Arch: "amd64",
+			ArtifactURL: "/binpkgs/amd64/app-misc/jq-1.7.gpkg.tar"},
+		"b": {JobID: "b", GroupID: "g", Status: "failed", PackageName: "app-misc/jq", Arch: "arm64"},
+		"c": {JobID: "c", Status: "success", PackageName: "app-misc/jq", Arch: "amd64"},
+	})
+
+	status, err := mgr.GetMultiArchBuild("g")
+	if err != nil {
+		t.Fatalf("GetMultiArchBuild() error = %v", err)
+	}
+	if status.Status != "partial" || len(status.Jobs) != 2 {
+		t.Errorf("status = %q with %d jobs, want partial with 2", status.Status, len(status.Jobs))
+	}
+	want := map[string][]string{"amd64": {"/binpkgs/amd64/app-misc/jq-1.7.gpkg.tar"}}
+	if !reflect.DeepEqual(status.Artifacts, want) {
+		t.Errorf("artifacts = %v, want %v", status.Artifacts, want)
+	}
+}
+
+func TestMultiArchState(t *testing.T) {
+	states := func(ss ...string) []*BuildStatus {
+		var jobs []*BuildStatus
+		for _, s := range ss {
+			jobs = append(jobs, &BuildStatus{Status: s})
+		}
+		return jobs
+	}
+	tests := []struct {
+		jobs []*BuildStatus
+		want string
+	}{
+		{states("queued", "queued"), "queued"},
+		{states("queued", "building"), "building"},
+		{states("success", "provisioning"), "building"},
+		{states("success", "completed"), "success"},
+		{states("failed", "failed"), "failed"},
+		{states("success", "failed"), "partial"},
+		{states("success", "success_no_artifact"), "partial"},
+	}
+	for _, tt := range tests {
+		if got := multiArchState(tt.jobs); got != tt.want {
+			t.Errorf("multiArchState(%v) = %q, want %q", tt.jobs, got, tt.want)
+		}
+	}
+}
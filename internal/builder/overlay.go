@@ -0,0 +1,121 @@
+// Package builder provides builds from git ebuild overlays.
+package builder
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// overlayRepoDir is where the build container clones overlays.
+const overlayRepoDir = "/var/db/repos"
+
+var (
+	// An overlay URL: https:// or git:// with a host and a plain path, so it
+	// is safe in repos.conf and on a git command line.
+	overlayURLPattern = regexp.MustCompile(`^(https|git)://[A-Za-z0-9.-]+(:[0-9]+)?(/[A-Za-z0-9._~%+-]+)+/?$`)
+
+	// A git branch or tag name, without a leading dash (an option to git).
+	overlayRefPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._/-]*$`)
+)
+
+// validateOverlay checks a package spec's overlay URL and ref.
+func validateOverlay(pkg PackageSpec) error {
+	if pkg.OverlayURL == "" {
+		if pkg.OverlayRef != "" {
+			return fmt.Errorf("overlay_ref %q given without overlay_url", pkg.OverlayRef)
+		}
+		return nil
+	}
+	if !overlayURLPattern.MatchString(pkg.OverlayURL) {
+		return fmt.Errorf("invalid overlay_url %q (want an https:// or git:// URL)", pkg.OverlayURL)
+	}
+	if ref := pkg.OverlayRef; ref != "" && (!overlayRefPattern.MatchString(ref) || strings.Contains(ref, "..")) {
+		return fmt.Errorf("invalid overlay_ref %q", ref)
+	}
+	if isPackageSet(pkg.Atom) {
+		return fmt.Errorf("overlay_url cannot be used with the package set %s", pkg.Atom)
+	}
+	_, err := overlayRepoName(pkg)
+	return err
+}
+
+// overlayRepoName is the repository name a package's overlay is configured
+// under: the atom's ::repo if it names one, otherwise the last element of
+// the overlay URL without ".git". It should match the overlay's
+// profiles/repo_name, or Portage will not find the package in it.
+func overlayRepoName(pkg PackageSpec) (string, error) {
+	name := ""
+	if i := strings.Index(pkg.Atom, "::"); i >= 0 {
+		name, _, _ = strings.Cut(pkg.Atom[i+2:], "[")
+	} else if u, err := url.Parse(pkg.OverlayURL); err == nil {
+		name = strings.TrimSuffix(path.Base(u.Path), ".git")
+	}
+	if err := validateRepoName(name); err != nil {
+		return "", fmt.Errorf("overlay %s: %w", pkg.OverlayURL, err)
+	}
+	return name, nil
+}
+
+// overlayRepos returns the git repositories for the overlays of pkgs, one
+// per repository name. Two packages naming the same repository must agree
+// on its URL and ref.
+func overlayRepos(pkgs []PackageSpec) ([]RepoConfig, error) {
+	var repos []RepoConfig
+	seen := map[string]RepoConfig{}
+	for _, pkg := range pkgs {
+		if pkg.OverlayURL == "" {
+			continue
+		}
+		name, err := overlayRepoName(pkg)
+		if err != nil {
+			return nil, err
+		}
+		repo := RepoConfig{
+			Name:     name,
+			Location: path.Join(overlayRepoDir, name),
+			SyncType: "git",
+			SyncURI:  pkg.OverlayURL,
+			Ref:      pkg.OverlayRef,
+		}
+		if prev, ok := seen[name]; ok {
+			if prev != repo {
+				return nil, fmt.Errorf("overlay %s is given as both %s and %s", name, prev.SyncURI, repo.SyncURI)
+			}
+			continue
+		}
+		seen[name] = repo
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// withOverlayRepos returns the bundle with the overlays of pkgs added to a
+// copy of its repositories, replacing any of the same name, and the names
+// of those overlays. Without overlays the bundle is returned as is.
+func withOverlayRepos(bundle *ConfigBundle, pkgs []PackageSpec) (*ConfigBundle, []string, error) {
+	repos, err := overlayRepos(pkgs)
+	if err != nil || len(repos) == 0 {
+		return bundle, nil, err
+	}
+	cfg := PortageConfig{}
+	if bundle.Config != nil {
+		cfg = *bundle.Config
+	}
+	cfg.Repos = mergeRepos(cfg.Repos, repos)
+	out := *bundle
+	out.Config = &cfg
+	names := make([]string, len(repos))
+	for i, repo := range repos {
+		names[i] = repo.Name
+	}
+	return &out, names, nil
+}
+
+// overlaySyncCommand clones or updates just the overlay name, leaving the
+// image's other repositories as they are.
+func overlaySyncCommand(name string) []string {
+	return []string{"emerge", "--sync", name}
+}
@@ -0,0 +1,109 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateOverlay(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		pkg     PackageSpec
+		wantErr bool
+	}{
+		{"no overlay", PackageSpec{Atom: "app-misc/jq"}, false},
+		{"https", PackageSpec{Atom: "app-misc/foo", OverlayURL: "https://github.com/example/my-overlay.git"}, false},
+		{"git with ref", PackageSpec{Atom: "app-misc/foo::mine", OverlayURL: "git://git.example.org/overlays/mine", OverlayRef: "release/1.0"}, false},
+		{"ref without url", PackageSpec{Atom: "app-misc/foo", OverlayRef: "main"}, true},
+		{"ssh url", PackageSpec{Atom: "app-misc/foo", OverlayURL: "ssh://git@example.org/overlay"}, true},
+		{"shell in url", PackageSpec{Atom: "app-misc/foo", OverlayURL: "https://example.org/o;rm -rf /"}, true},
+		{"option ref", PackageSpec{Atom: "app-misc/foo", OverlayURL: "https://example.org/overlay", OverlayRef: "--upload-pack=x"}, true},
+		{"dotted ref", PackageSpec{Atom: "app-misc/foo", OverlayURL: "https://example.org/overlay", OverlayRef: "a..b"}, true},
+		{"bad repo name", PackageSpec{Atom: "app-misc/foo", OverlayURL: "https://example.org/my.overlay"}, true},
+		{"package set", PackageSpec{Atom: "@world", OverlayURL: "https://example.org/overlay"}, true},
+	}
+	for _, tt := range tests {
+		if err := ValidatePackageSpec(tt.pkg); (err != nil) != tt.wantErr {
+			t.Errorf("%s: ValidatePackageSpec() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestOverlayRepos(t *testing.T) {
+	t.Parallel()
+
+	pkgs := []PackageSpec{
+		{Atom: "app-misc/jq"},
+		{Atom: "app-misc/foo", OverlayURL: "https://github.com/example/my-overlay.git", OverlayRef: "v2"},
+		{Atom: "app-misc/bar::my-overlay", OverlayURL: "https://github.com/example/my-overlay.git", OverlayRef: "v2"},
+		{Atom: "dev-util/baz::other", OverlayURL: "https://example.org/whatever"},
+	}
+	repos, err := overlayRepos(pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RepoConfig{
+		{Name: "my-overlay", Location: "/var/db/repos/my-overlay", SyncType: "git", SyncURI: "https://github.com/example/my-overlay.git", Ref: "v2"},
+		{Name: "other", Location: "/var/db/repos/other", SyncType: "git", SyncURI: "https://example.org/whatever"},
+	}
+	if !reflect.DeepEqual(repos, want) {
+		t.Errorf("overlayRepos() = %+v, want %+v", repos, want)
+	}
+
+	conflict := append(pkgs, PackageSpec{Atom: "app-misc/qux::other", OverlayURL: "https://example.org/elsewhere"})
+	if _, err := overlayRepos(conflict); err == nil {
+		t.Error("one repository name with two URLs was accepted")
+	}
+}
+
+func TestWithOverlayRepos(t *testing.T) {
+	t.Parallel()
+
+	bundle := &ConfigBundle{
+		Config: &PortageConfig{Repos: []RepoConfig{{Name: "gentoo", Location: "/var/db/repos/gentoo"}}},
+		Packages: &BuildPackageSpec{Packages: []PackageSpec{
+			{Atom: "app-misc/foo::mine", OverlayURL: "https://example.org/mine.git", OverlayRef: "main"},
+		}},
+	}
+	built, names, err := withOverlayRepos(bundle, bundle.Packages.Packages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"mine"}) {
+		t.Errorf("overlay names = %v", names)
+	}
+	if len(built.Config.Repos) != 2 || len(bundle.Config.Repos) != 1 {
+		t.Errorf("repos = %+v, request repos = %+v; want the overlay added to a copy", built.Config.Repos, bundle.Config.Repos)
+	}
+	if got := overlaySyncCommand("mine"); !reflect.DeepEqual(got, []string{"emerge", "--sync", "mine"}) {
+		t.Errorf("overlaySyncCommand() = %v", got)
+	}
+
+	portageDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(portageDir, "repos.conf"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewConfigTransfer(t.TempDir()).writeReposConf(portageDir, built.Config.Repos); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(portageDir, "repos.conf", "mine.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"[mine]", "location = /var/db/repos/mine", "sync-type = git",
+		"sync-uri = https://example.org/mine.git", "sync-git-clone-extra-opts = --branch main"} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("repos.conf entry %q lacks %q", data, line)
+		}
+	}
+
+	plain := &ConfigBundle{Packages: &BuildPackageSpec{Packages: []PackageSpec{{Atom: "app-misc/jq"}}}}
+	if got, names, err := withOverlayRepos(plain, plain.Packages.Packages); got != plain || names != nil || err != nil {
+		t.Error("a bundle without overlays should be built as is")
+	}
+}
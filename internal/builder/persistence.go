@@ -11,6 +11,24 @@ import (
 	"time"
 )
 
+// JobStorage persists build jobs across builder restarts. JobStore keeps them
+// in one JSON file; SQLiteJobStore keeps a row per job.
+type JobStorage interface {
+	// Load returns the persisted jobs to restore into memory.
+	Load() (map[string]*BuildJob, error)
+	// Save persists every job in jobs.
+	Save(jobs map[string]*BuildJob) error
+	// SaveJob persists one job update; allJobs is the full job map for
+	// stores that can only be saved whole.
+	SaveJob(id string, job *BuildJob, allJobs map[string]*BuildJob) error
+}
+
+// jobPruner is a JobStorage written one job at a time, whose retention is
+// applied in the store rather than by re-saving a cleaned job map.
+type jobPruner interface {
+	DeleteFinishedBefore(cutoff time.Time) (int, error)
+}
+
 // JobStore provides persistent storage for build jobs.
 type JobStore struct {
 	dataDir  string
@@ -113,6 +131,12 @@ func (s *JobStore) SaveJob(_ string, _ *BuildJob, allJobs map[string]*BuildJob)
 
 // CleanOldJobs removes jobs older than the specified duration.
 func (s *JobStore) CleanOldJobs(jobs map[string]*BuildJob, maxAge time.Duration) (map[string]*BuildJob, int) {
+	return cleanOldJobs(jobs, maxAge)
+}
+
+// cleanOldJobs returns jobs without the finished ones that ended more than
+// maxAge ago, and how many were dropped.
+func cleanOldJobs(jobs map[string]*BuildJob, maxAge time.Duration) (map[string]*BuildJob, int) {
 	cutoff := time.Now().Add(-maxAge)
 	cleaned := make(map[string]*BuildJob)
 	removedCount := 0
@@ -136,7 +160,7 @@ func (s *JobStore) CleanOldJobs(jobs map[string]*BuildJob, maxAge time.Duration)
 
 // JobPersister handles periodic persistence of jobs.
 type JobPersister struct {
-	store       *JobStore
+	store       JobStorage
 	getJobsFunc func() map[string]*BuildJob
 	interval    time.Duration
 	maxAge      time.Duration
@@ -145,7 +169,7 @@ type JobPersister struct {
 }
 
 // NewJobPersister creates a new job persister.
-func NewJobPersister(store *JobStore, getJobsFunc func() map[string]*BuildJob, interval, maxAge time.Duration) *JobPersister {
+func NewJobPersister(store JobStorage, getJobsFunc func() map[string]*BuildJob, interval, maxAge time.Duration) *JobPersister {
 	return &JobPersister{
 		store:       store,
 		getJobsFunc: getJobsFunc,
@@ -166,11 +190,25 @@ func (p *JobPersister) Start() {
 		for {
 			select {
 			case <-ticker.C:
+				// Incremental stores already hold every job; just apply the
+				// retention period there.
+				if pruner, ok := p.store.(jobPruner); ok {
+					if p.maxAge > 0 {
+						removed, err := pruner.DeleteFinishedBefore(time.Now().Add(-p.maxAge))
+						if err != nil {
+							log.Printf("Failed to clean old jobs: %v", err)
+						} else if removed > 0 {
+							log.Printf("Cleaned %d old jobs from persistence", removed)
+						}
+					}
+					continue
+				}
+
 				jobs := p.getJobsFunc()
 
 				// Clean old jobs if maxAge is set
 				if p.maxAge > 0 {
-					cleaned, removed := p.store.CleanOldJobs(jobs, p.maxAge)
+					cleaned, removed := cleanOldJobs(jobs, p.maxAge)
 					if removed > 0 {
 						log.Printf("Cleaned %d old jobs from persistence", removed)
 						jobs = cleaned
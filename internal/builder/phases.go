@@ -0,0 +1,135 @@
+// Package builder provides the separate fetch and compile build phases.
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Failure categories reported in a failed job's metadata; they are named
+// after the build phase that failed.
+const (
+	FailureFetch   = "fetch"
+	FailureCompile = "compile"
+)
+
+// ErrFetchFailed marks a build that failed while downloading sources. These
+// failures are usually transient (mirror or network trouble), so unlike
+// compile failures they are eligible for infrastructure retries.
+var ErrFetchFailed = errors.New("source fetch failed")
+
+// ErrCompileFailed marks a build that fetched its sources but failed to build.
+var ErrCompileFailed = errors.New("compile failed")
+
+// FailureCategory classifies a build error as FailureFetch or FailureCompile.
+// It returns "" when the phase is unknown (the fetch phase did not run
+// separately, or the build failed outside emerge).
+func FailureCategory(err error) string {
+	switch {
+	case errors.Is(err, ErrFetchFailed):
+		return FailureFetch
+	case errors.Is(err, ErrCompileFailed):
+		return FailureCompile
+	default:
+		return ""
+	}
+}
+
+// RetryableFailure reports whether a failure category is infrastructure
+// trouble worth retrying rather than a problem with the package itself.
+func RetryableFailure(category string) bool {
+	switch category {
+	case FailureFetch, FailureSpotInterruption, FailureBuilderShutdown, FailureInsufficientDisk:
+		return true
+	}
+	return false
+}
+
+// fetchOnlyCommand turns an emerge build command into the matching
+// `emerge --fetchonly` invocation, which downloads and verifies distfiles
+// without building anything.
+func fetchOnlyCommand(emergeCmd []string) []string {
+	cmd := make([]string, 0, len(emergeCmd)+1)
+	cmd = append(cmd, emergeCmd[0], "--fetchonly")
+	for _, arg := range emergeCmd[1:] {
+		if arg == "--buildpkg" {
+			continue
+		}
+		cmd = append(cmd, arg)
+	}
+	return cmd
+}
+
+// runPhases runs emergeCmd through run. With separate fetch enabled, a
+// fetch-only pass runs first, so that mirror failures are reported as
+// ErrFetchFailed and the compile pass can work from local distfiles (e.g.
+// with FEATURES=network-sandbox). Each phase's wall time is recorded in the
+// job metadata.
+func (o BuildOptions) runPhases(job *BuildJob, emergeCmd []string, run func(cmd []string) error) error {
+	if !o.SeparateFetch {
+		return run(emergeCmd)
+	}
+
+	start := time.Now()
+	err := run(fetchOnlyCommand(emergeCmd))
+	job.recordPhaseDuration(FailureFetch, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+
+	start = time.Now()
+	err = run(emergeCmd)
+	job.recordPhaseDuration(FailureCompile, time.Since(start))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCompileFailed, err)
+	}
+	return nil
+}
+
+// recordPhaseDuration adds d to the job's "<phase>_seconds" metadata, summed
+// over every package the job builds.
+func (j *BuildJob) recordPhaseDuration(phase string, d time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.Metadata == nil {
+		j.Metadata = map[string]interface{}{}
+	}
+	key := phase + "_seconds"
+	total, _ := j.Metadata[key].(float64)
+	j.Metadata[key] = total + d.Seconds()
+}
+
+// The container build script reports its phases on marker lines: a failed
+// fetch prints fetchFailedMarker; a finished phase prints
+// "[phase] <name> took <N>s".
+const fetchFailedMarker = "[phase] fetch failed"
+
+var (
+	fetchFailedPattern = regexp.MustCompile(`(?m)^\[phase\] fetch failed$`)
+	phaseTimingPattern = regexp.MustCompile(`(?m)^\[phase\] (fetch|compile) took (\d+)s$`)
+)
+
+// recordScriptPhases records the phase timings a container build script
+// printed and attributes a script failure to its phase.
+func recordScriptPhases(job *BuildJob, output string, err error) error {
+	fetched := false
+	for _, m := range phaseTimingPattern.FindAllStringSubmatch(output, -1) {
+		secs, _ := strconv.Atoi(m[2])
+		job.recordPhaseDuration(m[1], time.Duration(secs)*time.Second)
+		fetched = fetched || m[1] == FailureFetch
+	}
+	if err == nil {
+		return nil
+	}
+	if fetchFailedPattern.MatchString(output) {
+		return fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	if fetched {
+		// The fetch phase finished, so the compile phase failed.
+		return fmt.Errorf("%w: %w", ErrCompileFailed, err)
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package builder
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestFetchOnlyCommand(t *testing.T) {
+	got := fetchOnlyCommand([]string{"emerge", "--ask=n", "--buildpkg", "--usepkg=n", "app-misc/jq"})
+	want := []string{"emerge", "--fetchonly", "--ask=n", "--usepkg=n", "app-misc/jq"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fetchOnlyCommand() = %v, want %v", got, want)
+	}
+}
+
+func TestRunPhases(t *testing.T) {
+	emerge := []string{"emerge", "--buildpkg", "app-misc/jq"}
+	failing := func(fail string) func([]string) error {
+		return func(cmd []string) error {
+			fetch := cmd[1] == "--fetchonly"
+			if (fail == "fetch" && fetch) || (fail == "compile" && !fetch) {
+				return errors.New("exit status 1")
+			}
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name     string
+		opts     BuildOptions
+		fail     string
+		category string
+		runs     int
+	}{
+		{"combined success", BuildOptions{}, "", "", 1},
+		{"combined failure is uncategorized", BuildOptions{}, "compile", "", 1},
+		{"separate success", BuildOptions{SeparateFetch: true}, "", "", 2},
+		{"fetch failure", BuildOptions{SeparateFetch: true}, "fetch", FailureFetch, 1},
+		{"compile failure", BuildOptions{SeparateFetch: true}, "compile", FailureCompile, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &BuildJob{}
+			runs := 0
+			run := failing(tt.fail)
+			err := tt.opts.runPhases(job, emerge, func(cmd []string) error {
+				runs++
+				return run(cmd)
+			})
+			if (err != nil) != (tt.fail != "") {
+				t.Fatalf("runPhases() error = %v", err)
+			}
+			if got := FailureCategory(err); got != tt.category {
+				t.Errorf("FailureCategory() = %q, want %q", got, tt.category)
+			}
+			if runs != tt.runs {
+				t.Errorf("runs = %d, want %d", runs, tt.runs)
+			}
+			_, timed := job.Metadata["fetch_seconds"]
+			if timed != tt.opts.SeparateFetch {
+				t.Errorf("fetch_seconds recorded = %v, want %v", timed, tt.opts.SeparateFetch)
+			}
+		})
+	}
+
+	if !RetryableFailure(FailureFetch) || RetryableFailure(FailureCompile) {
+		t.Error("only fetch failures should be retryable")
+	}
+}
+
+func TestRecordScriptPhases(t *testing.T) {
+	buildErr := errors.New("exit status 1")
+
+	job := &BuildJob{}
+	err := recordScriptPhases(job, "Starting\n"+fetchFailedMarker+"\n", buildErr)
+	if !errors.Is(err, ErrFetchFailed) {
+		t.Errorf("fetch marker: error = %v, want ErrFetchFailed", err)
+	}
+
+	job = &BuildJob{}
+	err = recordScriptPhases(job, "[phase] fetch took 12s\nemerge failed\n", buildErr)
+	if !errors.Is(err, ErrCompileFailed) {
+		t.Errorf("after fetch: error = %v, want ErrCompileFailed", err)
+	}
+	if got := job.Metadata["fetch_seconds"]; got != 12.0 {
+		t.Errorf("fetch_seconds = %v, want 12", got)
+	}
+
+	job = &BuildJob{}
+	if err := recordScriptPhases(job, "[phase] fetch took 3s\n[phase] compile took 40s\n", nil); err != nil {
+		t.Errorf("success: error = %v", err)
+	}
+	if got := job.Metadata["compile_seconds"]; got != 40.0 {
+		t.Errorf("compile_seconds = %v, want 40", got)
+	}
+
+	// Without phase markers the failure stays uncategorized.
+	if err := recordScriptPhases(&BuildJob{}, "emerge failed\n", buildErr); FailureCategory(err) != "" {
+		t.Errorf("no markers: category = %q, want none", FailureCategory(err))
+	}
+}
+
+func TestGenerateBuildScriptSeparateFetch(t *testing.T) {
+	lb := &LocalBuilder{cfg: &config.BuilderConfig{SeparateFetch: true}}
+	script := lb.generateBuildScript("app-misc/jq", "", "", "", jobControl{})
+	fetch := strings.Index(script, "emerge --fetchonly")
+	build := strings.Index(script, "if ! emerge --ask=n")
+	if fetch < 0 || build < 0 || fetch > build {
+		t.Error("the fetch phase must run before the build")
+	}
+	for _, want := range []string{fetchFailedMarker, "[phase] fetch took", "[phase] compile took"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q", want)
+		}
+	}
+
+	lb = &LocalBuilder{cfg: &config.BuilderConfig{}}
+	if strings.Contains(lb.generateBuildScript("app-misc/jq", "", "", "", jobControl{}), "--fetchonly") {
+		t.Error("fetch phase should be off by default")
+	}
+}
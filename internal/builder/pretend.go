@@ -0,0 +1,42 @@
+// Package builder provides pretend builds, which resolve a request with
+// emerge --pretend instead of building it.
+package builder
+
+import "fmt"
+
+// errPretended ends a pretend build that resolved: emerge showed what it
+// would merge, and nothing was built.
+var errPretended = fmt.Errorf("%w: pretend run, emerge resolved the build without merging anything", errNoArtifact)
+
+// pretending reports whether the job only previews its build.
+func (j *BuildJob) pretending() bool {
+	return j.Request != nil && j.Request.Pretend
+}
+
+// pretendResult is what a pretend build returns after running emerge: the
+// emerge error, or errPretended.
+func pretendResult(err error) error {
+	if err != nil {
+		return err
+	}
+	return errPretended
+}
+
+// pretendCommand turns a bundle's emerge command into the one previewing it:
+// --pretend --tree in place of the options that build, write config or
+// follow the build output, and binary packages offered where the builder has
+// them, so the output shows the resolved graph with what comes from binpkgs
+// and the download sizes (--verbose).
+func pretendCommand(emergeCmd []string) []string {
+	cmd := []string{emergeCmd[0], "--pretend", "--tree"}
+	for _, arg := range emergeCmd[1:] {
+		switch arg {
+		case "--buildpkg", "--autounmask-write", "--autounmask-continue", "--quiet-build=n":
+			continue
+		case "--usepkg=n":
+			arg = "--usepkg"
+		}
+		cmd = append(cmd, arg)
+	}
+	return cmd
+}
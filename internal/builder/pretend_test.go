@@ -0,0 +1,53 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPretendCommand(t *testing.T) {
+	t.Parallel()
+
+	be := NewBuildExecutor(t.TempDir(), t.TempDir())
+	bundle := &ConfigBundle{Packages: &BuildPackageSpec{Packages: []PackageSpec{{Atom: "dev-lang/python", Version: "3.12.4", UseFlags: []string{"ssl"}}}}}
+	got := pretendCommand(be.bundleEmergeCommand(bundle))
+
+	for _, want := range []string{"--pretend", "--tree", "--verbose", "--usepkg", "--autounmask", "--use=ssl", "=dev-lang/python-3.12.4"} {
+		if !slices.Contains(got, want) {
+			t.Errorf("pretend command lacks %s: %v", want, got)
+		}
+	}
+	for _, unwanted := range []string{"--buildpkg", "--usepkg=n", "--autounmask-write", "--autounmask-continue", "--quiet-build=n"} {
+		if slices.Contains(got, unwanted) {
+			t.Errorf("pretend command keeps %s: %v", unwanted, got)
+		}
+	}
+}
+
+func TestPretendBuildProducesNoArtifact(t *testing.T) {
+	t.Parallel()
+
+	be := NewBuildExecutor(t.TempDir(), t.TempDir())
+	job := &BuildJob{ID: "pretend-1", Request: &LocalBuildRequest{PackageName: "app-misc/jq", Pretend: true}}
+	bundle := &ConfigBundle{
+		Config:   &PortageConfig{},
+		Packages: &BuildPackageSpec{Packages: []PackageSpec{{Atom: "app-misc/jq"}}},
+		Metadata: BundleMetadata{TargetArch: "amd64"},
+	}
+
+	// emerge is not installed here, so the preview fails as emerge would,
+	// and must not go on to collect artifacts.
+	err := be.ExecuteBuild(context.Background(), bundle, job)
+	if err == nil || errors.Is(err, errNoArtifact) {
+		t.Fatalf("ExecuteBuild() error = %v, want the emerge failure", err)
+	}
+	if !strings.Contains(job.Log, "--pretend") || job.ArtifactURL != "" {
+		t.Errorf("log %q, artifact %q, err %v", job.Log, job.ArtifactURL, err)
+	}
+	if !errors.Is(pretendResult(nil), errPretended) || !errors.Is(errPretended, errNoArtifact) {
+		t.Error("a resolved pretend build does not end as success_no_artifact")
+	}
+}
@@ -0,0 +1,202 @@
+// Package builder provides build provenance documents for artifacts.
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slchris/portage-engine/internal/binpkg"
+)
+
+const (
+	// provenanceFileExt is the extension of the provenance document written
+	// next to an artifact.
+	provenanceFileExt = ".provenance.json"
+	// ProvenanceSchema identifies the format of the provenance documents.
+	ProvenanceSchema = "portage-engine/provenance/v1"
+	// imageDigestTimeout bounds the image inspection for a provenance.
+	imageDigestTimeout = 30 * time.Second
+)
+
+// mergeListPattern matches a package in emerge's merge list, e.g.
+//
+//	[ebuild  N     ] dev-libs/oniguruma-6.9.9::gentoo  USE="-static-libs" ...
+//	[binary   R    ] app-misc/jq-1.7.1-1::gentoo
+var mergeListPattern = regexp.MustCompile(`^\[(?:ebuild|binary)[^\]]*\]\s+(\S+)`)
+
+// Provenance is the machine-readable record of what went into an artifact.
+type Provenance struct {
+	Schema string `json:"schema"`
+	// Artifact is the artifact's path relative to the artifact dir.
+	Artifact string `json:"artifact"`
+	// CPV is the resolved atom with version, e.g. app-misc/jq-1.7.1.
+	CPV        string `json:"cpv"`
+	Repository string `json:"repository,omitempty"`
+	// USE is the USE flags the package was built with, from the binary
+	// package's own metadata.
+	USE []string `json:"use"`
+	// RequestedUSE is the USE flags the build request asked for.
+	RequestedUSE      []string `json:"requested_use,omitempty"`
+	JobID             string   `json:"job_id"`
+	BuilderInstanceID string   `json:"builder_instance_id"`
+	// ContainerImage and ContainerImageDigest are set for container builds.
+	ContainerImage       string `json:"container_image,omitempty"`
+	ContainerImageDigest string `json:"container_image_digest,omitempty"`
+	// TreeSyncedAt and TreeRevision describe the portage tree the build used.
+	TreeSyncedAt string `json:"tree_synced_at,omitempty"`
+	TreeRevision string `json:"tree_revision,omitempty"`
+	// Dependencies is the rest of the set emerge resolved and merged for the
+	// build, as CPVs.
+	Dependencies  []string  `json:"dependencies"`
+	SHA256        string    `json:"sha256"`
+	SHA512        string    `json:"sha512"`
+	BuildStarted  time.Time `json:"build_started"`
+	BuildFinished time.Time `json:"build_finished"`
+}
+
+// parseMergeList returns the CPVs of emerge's merge list in a build log, in
+// order and without duplicates or repository suffixes.
+func parseMergeList(buildLog string) []string {
+	var cpvs []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(buildLog, "\n") {
+		m := mergeListPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		cpv, _, _ := strings.Cut(m[1], "::")
+		if !seen[cpv] {
+			seen[cpv] = true
+			cpvs = append(cpvs, cpv)
+		}
+	}
+	return cpvs
+}
+
+// requestedUseFlags returns the USE flags a job's request set for the
+// package cpv: the flags of its bundle package spec, or else the request's
+// own flags, which apply to the whole emerge.
+func requestedUseFlags(req *LocalBuildRequest, cpv string) []string {
+	if b := req.ConfigBundle; b != nil && b.Packages != nil {
+		for _, spec := range b.Packages.Packages {
+			if atomCP(spec.Atom) == atomCP(cpv) {
+				return spec.UseFlags
+			}
+		}
+	}
+	flags := strings.Fields(buildUseFlagsString(req.UseFlags))
+	sort.Strings(flags)
+	return flags
+}
+
+// buildProvenance assembles the provenance of the artifact rel (relative to
+// the artifact dir) of job. mergeList is the job's resolved set and
+// imageDigest the build image's ID, if known.
+func (lb *LocalBuilder) buildProvenance(job *BuildJob, rel string, mergeList []string, imageDigest string) (*Provenance, error) {
+	path := filepath.Join(lb.artifactDir, rel)
+	digests, err := readArtifactDigests(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provenance{
+		Schema:            ProvenanceSchema,
+		Artifact:          filepath.ToSlash(rel),
+		CPV:               artifactCPV(rel),
+		USE:               []string{},
+		JobID:             job.ID,
+		BuilderInstanceID: lb.InstanceID(),
+		Dependencies:      []string{},
+		SHA256:            digests.SHA256,
+		SHA512:            digests.SHA512,
+		BuildStarted:      job.StartTime,
+		BuildFinished:     time.Now(),
+	}
+	if meta := binpkg.ReadPackageMetadata(path); meta != nil {
+		if meta["CATEGORY"] != "" && meta["PF"] != "" {
+			p.CPV = meta["CATEGORY"] + "/" + meta["PF"]
+		}
+		p.Repository = meta["REPOSITORY"]
+		if use := strings.Fields(meta["USE"]); len(use) > 0 {
+			p.USE = use
+		}
+	}
+	p.RequestedUSE = requestedUseFlags(job.Request, p.CPV)
+	if lb.useDocker {
+		p.ContainerImage = lb.jobImage(job)
+		p.ContainerImageDigest = imageDigest
+	}
+	if last := lb.TreeLastSync(); !last.IsZero() {
+		p.TreeSyncedAt = last.UTC().Format(time.RFC3339)
+	}
+	p.TreeRevision = lb.TreeRevision()
+	for _, cpv := range mergeList {
+		if cpv != p.CPV {
+			p.Dependencies = append(p.Dependencies, cpv)
+		}
+	}
+	return p, nil
+}
+
+// writeArtifactProvenance writes the provenance document of each artifact
+// (relative to the artifact dir) next to it, after its checksum files. A
+// failure is logged but does not fail the build.
+func (lb *LocalBuilder) writeArtifactProvenance(job *BuildJob, rels []string) {
+	mergeList := parseMergeList(job.logSnapshot())
+	imageDigest := ""
+	if lb.useDocker && lb.containerRuntime != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), imageDigestTimeout)
+		digest, err := lb.containerRuntime.ImageDigest(ctx, lb.jobImage(job))
+		cancel()
+		if err != nil {
+			log.Printf("Warning: provenance of job %s has no image digest: %v", job.ref(), err)
+		}
+		imageDigest = digest
+	}
+
+	for _, rel := range rels {
+		if err := lb.writeProvenanceFile(job, rel, mergeList, imageDigest); err != nil {
+			log.Printf("Warning: failed to write provenance for %s: %v", rel, err)
+			job.appendLog(fmt.Sprintf("Warning: failed to write provenance for %s: %v\n", rel, err))
+		}
+	}
+}
+
+// writeProvenanceFile writes the provenance of one artifact.
+func (lb *LocalBuilder) writeProvenanceFile(job *BuildJob, rel string, mergeList []string, imageDigest string) error {
+	p, err := lb.buildProvenance(job, rel, mergeList, imageDigest)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(lb.artifactDir, rel) + provenanceFileExt
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil { // #nosec G306 -- provenance is public.
+		return fmt.Errorf("failed to write provenance file: %w", err)
+	}
+	return nil
+}
+
+// readProvenance returns the provenance written for the artifact at path, or
+// nil if there is none.
+func readProvenance(path string) *Provenance {
+	data, err := os.ReadFile(path + provenanceFileExt) // #nosec G304 -- a provenance file in our own artifact dir.
+	if err != nil {
+		return nil
+	}
+	var p Provenance
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil
+	}
+	return &p
+}
@@ -0,0 +1,135 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeTestGpkg writes a minimal gpkg at path whose metadata holds meta.
+func writeTestGpkg(t *testing.T, path string, meta map[string]string) {
+	t.Helper()
+	var inner bytes.Buffer
+	tw := tar.NewWriter(&inner)
+	for key, value := range meta {
+		if err := tw.WriteHeader(&tar.Header{Name: "metadata/" + key, Mode: 0644, Size: int64(len(value)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var outer bytes.Buffer
+	tw = tar.NewWriter(&outer)
+	if err := tw.WriteHeader(&tar.Header{Name: "jq-1.7.1-1/metadata.tar", Mode: 0644, Size: int64(inner.Len()), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(inner.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, outer.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseMergeList(t *testing.T) {
+	buildLog := `Calculating dependencies... done!
+[ebuild  N     ] dev-libs/oniguruma-6.9.9::gentoo  USE="-static-libs" 500 KiB
+[ebuild  N     ] app-misc/jq-1.7.1::gentoo  USE="oniguruma -test" 1,234 KiB
+   [binary   R    ] sys-libs/zlib-1.3.1-1::gentoo
+>>> Emerging (1 of 2) dev-libs/oniguruma-6.9.9::gentoo
+[ebuild  N     ] dev-libs/oniguruma-6.9.9::gentoo
+`
+	want := []string{"dev-libs/oniguruma-6.9.9", "app-misc/jq-1.7.1", "sys-libs/zlib-1.3.1-1"}
+	if got := parseMergeList(buildLog); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMergeList() = %v, want %v", got, want)
+	}
+}
+
+func TestWriteArtifactProvenance(t *testing.T) {
+	artifactDir := t.TempDir()
+	rel := "app-misc/jq/jq-1.7.1-1.gpkg.tar"
+	writeTestGpkg(t, filepath.Join(artifactDir, rel), map[string]string{
+		"CATEGORY": "app-misc", "PF": "jq-1.7.1", "USE": "amd64 oniguruma", "repository": "gentoo",
+	})
+	if _, err := writeChecksumFiles(filepath.Join(artifactDir, rel)); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now().Add(-time.Minute)
+	lb := &LocalBuilder{instanceID: "builder-1", artifactDir: artifactDir}
+	job := &BuildJob{
+		ID:        "j1",
+		Status:    "success",
+		StartTime: started,
+		Request:   &LocalBuildRequest{PackageName: "app-misc/jq", UseFlags: map[string]string{"oniguruma": "true", "test": "false"}},
+		Log: "[ebuild  N     ] dev-libs/oniguruma-6.9.9::gentoo\n" +
+			"[ebuild  N     ] app-misc/jq-1.7.1::gentoo  USE=\"oniguruma -test\"\n",
+	}
+
+	lb.writeArtifactProvenance(job, []string{rel})
+
+	p := readProvenance(filepath.Join(artifactDir, rel))
+	if p == nil {
+		t.Fatal("no provenance written")
+	}
+	if p.Schema != ProvenanceSchema || p.Artifact != rel || p.CPV != "app-misc/jq-1.7.1" || p.Repository != "gentoo" {
+		t.Errorf("provenance = %+v", p)
+	}
+	if !reflect.DeepEqual(p.USE, []string{"amd64", "oniguruma"}) || !reflect.DeepEqual(p.RequestedUSE, []string{"-test", "oniguruma"}) {
+		t.Errorf("USE = %v, requested %v", p.USE, p.RequestedUSE)
+	}
+	if !reflect.DeepEqual(p.Dependencies, []string{"dev-libs/oniguruma-6.9.9"}) {
+		t.Errorf("Dependencies = %v", p.Dependencies)
+	}
+	digests, err := hashArtifact(filepath.Join(artifactDir, rel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SHA256 != digests.SHA256 || p.SHA512 != digests.SHA512 {
+		t.Errorf("checksums = %s, %s", p.SHA256, p.SHA512)
+	}
+	if p.JobID != "j1" || p.BuilderInstanceID != "builder-1" || !p.BuildStarted.Equal(started) || p.BuildFinished.Before(started) {
+		t.Errorf("job fields = %q, %q, %s, %s", p.JobID, p.BuilderInstanceID, p.BuildStarted, p.BuildFinished)
+	}
+	if p.ContainerImage != "" || p.ContainerImageDigest != "" {
+		t.Errorf("native build has an image: %q %q", p.ContainerImage, p.ContainerImageDigest)
+	}
+
+	job.ArtifactURL = filepath.Join(artifactDir, rel)
+	lb.jobs = map[string]*BuildJob{"j1": job}
+	info, err := lb.GetArtifactInfo("j1")
+	if err != nil {
+		t.Fatalf("GetArtifactInfo: %v", err)
+	}
+	if info.Provenance == nil || info.Provenance.CPV != p.CPV {
+		t.Errorf("GetArtifactInfo().Provenance = %+v", info.Provenance)
+	}
+}
+
+func TestRequestedUseFlagsBundle(t *testing.T) {
+	req := &LocalBuildRequest{ConfigBundle: &ConfigBundle{Packages: &BuildPackageSpec{Packages: []PackageSpec{
+		{Atom: "dev-lang/python:3.12", UseFlags: []string{"ssl"}},
+		{Atom: ">=app-misc/jq-1.7", UseFlags: []string{"oniguruma"}},
+	}}}}
+	if got := requestedUseFlags(req, "app-misc/jq-1.7.1"); !reflect.DeepEqual(got, []string{"oniguruma"}) {
+		t.Errorf("requestedUseFlags() = %v", got)
+	}
+	if got := requestedUseFlags(req, "dev-libs/oniguruma-6.9.9"); len(got) != 0 {
+		t.Errorf("requestedUseFlags() of a dependency = %v", got)
+	}
+}
@@ -0,0 +1,158 @@
+// Package builder provides redaction of secrets in build logs.
+package builder
+
+import (
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// redactedText replaces every secret masked in a build log.
+const redactedText = "***"
+
+// minRedactedSecretLen is the shortest secret value masked verbatim; shorter
+// values ("1", "yes") would mask unrelated text all over the log.
+const minRedactedSecretLen = 4
+
+// secretEnvNamePattern matches the names of environment variables whose
+// values are masked wherever they appear in a build log.
+var secretEnvNamePattern = regexp.MustCompile(`(?i)token|secret|passw(or)?d|api_?key|access_?key|private_?key|credential`)
+
+// logRedactor masks secrets in build log text: matches of the configured
+// patterns and known secret values. A nil logRedactor masks nothing.
+type logRedactor struct {
+	patterns []*regexp.Regexp
+	secrets  []string
+}
+
+// newLogRedactor compiles patterns, skipping (and logging) invalid ones, and
+// masks secrets verbatim in addition.
+func newLogRedactor(patterns, secrets []string) *logRedactor {
+	r := &logRedactor{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			log.Printf("Ignoring invalid LOG_REDACT_PATTERNS entry %q: %v", p, err)
+			continue
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r.withSecrets(secrets)
+}
+
+// withSecrets returns a copy of r that also masks secrets.
+func (r *logRedactor) withSecrets(secrets []string) *logRedactor {
+	out := &logRedactor{}
+	if r != nil {
+		out.patterns = r.patterns
+		out.secrets = append(out.secrets, r.secrets...)
+	}
+	for _, s := range secrets {
+		if len(s) >= minRedactedSecretLen {
+			out.secrets = append(out.secrets, s)
+		}
+	}
+	// Longest first, so a secret that contains another is masked whole.
+	sort.Slice(out.secrets, func(i, j int) bool { return len(out.secrets[i]) > len(out.secrets[j]) })
+	return out
+}
+
+// redact returns s with every secret replaced by "***".
+func (r *logRedactor) redact(s string) string {
+	if r == nil || s == "" {
+		return s
+	}
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redactedText)
+	}
+	for _, re := range r.patterns {
+		s = redactPattern(re, s)
+	}
+	return s
+}
+
+// redactPattern masks the matches of re in s: the first capture group of
+// each match when re has groups, otherwise the whole match.
+func redactPattern(re *regexp.Regexp, s string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(s, redactedText)
+	}
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[2], m[3]
+		if start < 0 || s[start:end] == redactedText {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(redactedText)
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// secretEnvValues returns the values of the variables in env whose names
+// look secret (see secretEnvNamePattern).
+func secretEnvValues(env map[string]string) []string {
+	var values []string
+	for name, value := range env {
+		if secretEnvNamePattern.MatchString(name) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// builderRedactor is the redactor shared by every job: the configured
+// patterns plus the builder's own tokens and the secret-looking variables of
+// its environment, which native builds inherit.
+func builderRedactor(cfg *config.BuilderConfig) *logRedactor {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			env[name] = value
+		}
+	}
+	secrets := secretEnvValues(env)
+	var patterns []string
+	if cfg != nil {
+		patterns = cfg.LogRedactPatterns
+		secrets = append(secrets, cfg.AuthToken, cfg.AdminToken, cfg.ServerAPIKey, cfg.MetricsPassword)
+	}
+	return newLogRedactor(patterns, secrets)
+}
+
+// jobRedactor extends the builder's redactor with the secret-looking
+// variables a build request passes to the build.
+func (lb *LocalBuilder) jobRedactor(req *LocalBuildRequest) *logRedactor {
+	secrets := secretEnvValues(req.Environment)
+	for _, spec := range req.PackageSpecs {
+		secrets = append(secrets, secretEnvValues(spec.Environment)...)
+	}
+	if b := req.ConfigBundle; b != nil {
+		if b.Config != nil {
+			secrets = append(secrets, secretEnvValues(b.Config.Environment)...)
+			secrets = append(secrets, secretEnvValues(b.Config.MakeConf)...)
+			for _, content := range b.Config.EnvFiles {
+				if vars, err := parseEnvFile(content); err == nil {
+					secrets = append(secrets, secretEnvValues(vars)...)
+				}
+			}
+		}
+		if b.Packages != nil {
+			for _, spec := range b.Packages.Packages {
+				secrets = append(secrets, secretEnvValues(spec.Environment)...)
+			}
+		}
+	}
+	return lb.redactor.withSecrets(secrets)
+}
@@ -2,10 +2,17 @@
 package builder
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 )
 
+// ErrBuilderIDConflict is returned by Register when a live builder already
+// holds the ID at a different endpoint, e.g. two builder processes on one
+// host both defaulting to the hostname.
+var ErrBuilderIDConflict = errors.New("builder ID already registered by another endpoint")
+
 // BuilderInfo represents information about a registered builder.
 // nolint:revive // BuilderInfo is intentionally named for clarity
 type BuilderInfo struct {
@@ -23,6 +30,17 @@ type BuilderInfo struct {
 	TotalBuilds   int       `json:"total_builds"`   // lifetime total
 	SuccessBuilds int       `json:"success_builds"` // lifetime successes
 	FailedBuilds  int       `json:"failed_builds"`  // lifetime failures
+	Version       string    `json:"version,omitempty"`
+	APIVersion    int       `json:"api_version,omitempty"`
+	// Incompatible marks a builder whose APIVersion is below
+	// MinBuilderAPIVersion; the server does not route jobs to it.
+	Incompatible bool `json:"incompatible,omitempty"`
+	// TreeLastSync and TreeRevision are the portage tree state from the
+	// builder's last heartbeat. TreeStale is set by the server when listing
+	// builders whose tree is older than TREE_STALE_AFTER.
+	TreeLastSync time.Time `json:"tree_last_sync,omitzero"`
+	TreeRevision string    `json:"tree_revision,omitempty"`
+	TreeStale    bool      `json:"tree_stale,omitempty"`
 }
 
 // Registry manages registered builders and their status.
@@ -32,6 +50,8 @@ type Registry struct {
 	heartbeatTimeout time.Duration
 	cleanupInterval  time.Duration
 	stopCleanup      chan struct{}
+	// watchers receive the registry's changes, guarded by mu.
+	watchers builderWatchers
 }
 
 // NewRegistry creates a new builder registry.
@@ -49,12 +69,21 @@ func NewRegistry(heartbeatTimeout, cleanupInterval time.Duration) *Registry {
 	return r
 }
 
-// Register registers or updates a builder.
-func (r *Registry) Register(info *BuilderInfo) {
+// Register registers or updates a builder. A builder may move to a new
+// endpoint only once its previous registration has gone stale; while the old
+// endpoint is still heartbeating, a second claimant of the same ID is
+// rejected with ErrBuilderIDConflict rather than silently taking it over.
+func (r *Registry) Register(info *BuilderInfo) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	existing, exists := r.builders[info.ID]
+	if exists && existing.Endpoint != info.Endpoint && info.Endpoint != "" && existing.Endpoint != "" &&
+		time.Since(existing.LastHeartbeat) <= r.heartbeatTimeout {
+		return fmt.Errorf("%w: %q is held by %s (set a unique INSTANCE_ID for %s)",
+			ErrBuilderIDConflict, info.ID, existing.Endpoint, info.Endpoint)
+	}
+	info.Incompatible = !builderAPICompatible(info.APIVersion)
 	if exists {
 		// Update existing builder
 		existing.Endpoint = info.Endpoint
@@ -66,6 +95,15 @@ func (r *Registry) Register(info *BuilderInfo) {
 		existing.CPUUsage = info.CPUUsage
 		existing.MemoryUsage = info.MemoryUsage
 		existing.DiskUsage = info.DiskUsage
+		if info.Version != "" {
+			existing.Version = info.Version
+		}
+		existing.APIVersion = info.APIVersion
+		existing.Incompatible = info.Incompatible
+		if !info.TreeLastSync.IsZero() {
+			existing.TreeLastSync = info.TreeLastSync
+			existing.TreeRevision = info.TreeRevision
+		}
 		if info.TotalBuilds > 0 {
 			existing.TotalBuilds = info.TotalBuilds
 		}
@@ -75,6 +113,7 @@ func (r *Registry) Register(info *BuilderInfo) {
 		if info.FailedBuilds > 0 {
 			existing.FailedBuilds = info.FailedBuilds
 		}
+		r.publishUpdateLocked(existing)
 	} else {
 		// Register new builder
 		info.LastHeartbeat = time.Now()
@@ -82,14 +121,41 @@ func (r *Registry) Register(info *BuilderInfo) {
 			info.Enabled = true // Default to enabled
 		}
 		r.builders[info.ID] = info
+		r.publishUpdateLocked(info)
 	}
+	return nil
 }
 
 // Unregister removes a builder from the registry.
 func (r *Registry) Unregister(builderID string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if _, ok := r.builders[builderID]; !ok {
+		return
+	}
 	delete(r.builders, builderID)
+	r.watchers.publish(BuilderEvent{Type: BuilderEventRemove, ID: builderID})
+}
+
+// Restore adds builders saved before a restart, marked offline until they
+// heartbeat again, so a builder disabled or deregistered stays that way.
+// Builders already registered are left alone.
+func (r *Registry) Restore(builders []*BuilderInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, b := range builders {
+		if b == nil || b.ID == "" {
+			continue
+		}
+		if _, exists := r.builders[b.ID]; exists {
+			continue
+		}
+		builderCopy := *b
+		builderCopy.Status = "offline"
+		builderCopy.CurrentLoad = 0
+		r.builders[b.ID] = &builderCopy
+		r.publishUpdateLocked(&builderCopy)
+	}
 }
 
 // Get retrieves a builder by ID.
@@ -130,6 +196,7 @@ func (r *Registry) UpdateStatus(builderID, status string) bool {
 	}
 	builder.Status = status
 	builder.LastHeartbeat = time.Now()
+	r.publishUpdateLocked(builder)
 	return true
 }
 
@@ -144,6 +211,7 @@ func (r *Registry) UpdateLoad(builderID string, load int) bool {
 	}
 	builder.CurrentLoad = load
 	builder.LastHeartbeat = time.Now()
+	r.publishUpdateLocked(builder)
 	return true
 }
 
@@ -160,6 +228,7 @@ func (r *Registry) UpdateMetrics(builderID string, cpu, memory, disk float64) bo
 	builder.MemoryUsage = memory
 	builder.DiskUsage = disk
 	builder.LastHeartbeat = time.Now()
+	r.publishUpdateLocked(builder)
 	return true
 }
 
@@ -178,6 +247,7 @@ func (r *Registry) IncrementBuilds(builderID string, success bool) bool {
 	} else {
 		builder.FailedBuilds++
 	}
+	r.publishUpdateLocked(builder)
 	return true
 }
 
@@ -191,6 +261,7 @@ func (r *Registry) Enable(builderID string, enabled bool) bool {
 		return false
 	}
 	builder.Enabled = enabled
+	r.publishUpdateLocked(builder)
 	return true
 }
 
@@ -263,8 +334,9 @@ func (r *Registry) checkStaleBuilders() {
 
 	now := time.Now()
 	for _, builder := range r.builders {
-		if now.Sub(builder.LastHeartbeat) > r.heartbeatTimeout {
+		if now.Sub(builder.LastHeartbeat) > r.heartbeatTimeout && builder.Status != "offline" {
 			builder.Status = "offline"
+			r.publishUpdateLocked(builder)
 		}
 	}
 }
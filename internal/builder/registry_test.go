@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -62,7 +63,7 @@ func TestRegister(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r.Register(tt.info)
+			_ = r.Register(tt.info)
 
 			builder, exists := r.Get(tt.info.ID)
 			if !exists {
@@ -98,7 +99,12 @@ func TestRegisterUpdate(t *testing.T) {
 		CurrentLoad:  2,
 		TotalBuilds:  10,
 	}
-	r.Register(info)
+	if err := r.Register(info); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	// The old endpoint has stopped heartbeating, so the builder may move.
+	info.LastHeartbeat = time.Now().Add(-time.Minute)
 
 	// Update the same builder
 	updatedInfo := &BuilderInfo{
@@ -111,7 +117,9 @@ func TestRegisterUpdate(t *testing.T) {
 		CPUUsage:     80.0,
 		TotalBuilds:  15,
 	}
-	r.Register(updatedInfo)
+	if err := r.Register(updatedInfo); err != nil {
+		t.Fatalf("Register() after the old endpoint went stale: %v", err)
+	}
 
 	builder, exists := r.Get("builder-1")
 	if !exists {
@@ -134,6 +142,47 @@ func TestRegisterUpdate(t *testing.T) {
 	}
 }
 
+func TestRegisterIDConflict(t *testing.T) {
+	r := NewRegistry(30*time.Second, 10*time.Second)
+	defer r.Close()
+
+	first := &BuilderInfo{ID: "host1", Endpoint: "http://host1:9090", Status: "online"}
+	if err := r.Register(first); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	// A second live builder claiming the same ID is rejected...
+	err := r.Register(&BuilderInfo{ID: "host1", Endpoint: "http://host1:9091", Status: "online"})
+	if !errors.Is(err, ErrBuilderIDConflict) {
+		t.Fatalf("Register() error = %v, want ErrBuilderIDConflict", err)
+	}
+	if b, _ := r.Get("host1"); b.Endpoint != "http://host1:9090" {
+		t.Errorf("conflicting registration took over the ID: endpoint = %s", b.Endpoint)
+	}
+
+	// ...while the rightful owner's heartbeats keep working.
+	if err := r.Register(&BuilderInfo{ID: "host1", Endpoint: "http://host1:9090", Status: "busy"}); err != nil {
+		t.Errorf("Register() from the owning endpoint: %v", err)
+	}
+}
+
+func TestRegisterMarksIncompatibleAPI(t *testing.T) {
+	r := NewRegistry(30*time.Second, 10*time.Second)
+	defer r.Close()
+
+	// A builder that predates API negotiation reports no API version.
+	_ = r.Register(&BuilderInfo{ID: "old", Endpoint: "http://old:9090", Status: "online"})
+	if b, _ := r.Get("old"); !b.Incompatible {
+		t.Error("builder without an API version should be marked incompatible")
+	}
+
+	// Upgrading it clears the mark on its next heartbeat.
+	_ = r.Register(&BuilderInfo{ID: "old", Endpoint: "http://old:9090", Status: "online", APIVersion: APIVersion})
+	if b, _ := r.Get("old"); b.Incompatible || b.APIVersion != APIVersion {
+		t.Errorf("upgraded builder = %+v, want compatible with api_version %d", b, APIVersion)
+	}
+}
+
 func TestUnregister(t *testing.T) {
 	r := NewRegistry(30*time.Second, 10*time.Second)
 	defer r.Close()
@@ -142,7 +191,7 @@ func TestUnregister(t *testing.T) {
 		ID:       "builder-1",
 		Endpoint: "http://localhost:9090",
 	}
-	r.Register(info)
+	_ = r.Register(info)
 
 	// Verify registered
 	if _, exists := r.Get("builder-1"); !exists {
@@ -173,7 +222,7 @@ func TestGet(t *testing.T) {
 		Endpoint:     "http://localhost:9090",
 		Architecture: "amd64",
 	}
-	r.Register(info)
+	_ = r.Register(info)
 
 	builder, exists := r.Get("builder-1")
 	if !exists {
@@ -200,7 +249,7 @@ func TestList(t *testing.T) {
 			ID:       "builder-" + string(rune('0'+i)),
 			Endpoint: "http://localhost:909" + string(rune('0'+i)),
 		}
-		r.Register(info)
+		_ = r.Register(info)
 	}
 
 	builders = r.List()
@@ -217,7 +266,7 @@ func TestUpdateStatus(t *testing.T) {
 		ID:     "builder-1",
 		Status: "online",
 	}
-	r.Register(info)
+	_ = r.Register(info)
 
 	// Update status
 	if !r.UpdateStatus("builder-1", "busy") {
@@ -243,7 +292,7 @@ func TestUpdateLoad(t *testing.T) {
 		ID:          "builder-1",
 		CurrentLoad: 2,
 	}
-	r.Register(info)
+	_ = r.Register(info)
 
 	// Update load
 	if !r.UpdateLoad("builder-1", 5) {
@@ -268,7 +317,7 @@ func TestUpdateMetrics(t *testing.T) {
 	info := &BuilderInfo{
 		ID: "builder-1",
 	}
-	r.Register(info)
+	_ = r.Register(info)
 
 	// Update metrics
 	if !r.UpdateMetrics("builder-1", 75.5, 80.2, 60.8) {
@@ -302,7 +351,7 @@ func TestIncrementBuilds(t *testing.T) {
 		SuccessBuilds: 8,
 		FailedBuilds:  2,
 	}
-	r.Register(info)
+	_ = r.Register(info)
 
 	// Increment success
 	if !r.IncrementBuilds("builder-1", true) {
@@ -342,7 +391,7 @@ func TestEnable(t *testing.T) {
 		ID:      "builder-1",
 		Enabled: true,
 	}
-	r.Register(info)
+	_ = r.Register(info)
 
 	// Disable
 	if !r.Enable("builder-1", false) {
@@ -407,7 +456,7 @@ func TestGetStats(t *testing.T) {
 	}
 
 	for _, b := range builders {
-		r.Register(b)
+		_ = r.Register(b)
 	}
 
 	stats := r.GetStats()
@@ -453,7 +502,7 @@ func TestCleanupStaleBuilders(t *testing.T) {
 		ID:     "builder-1",
 		Status: "online",
 	}
-	r.Register(info)
+	_ = r.Register(info)
 
 	// Verify online
 	builder, _ := r.Get("builder-1")
@@ -484,7 +533,7 @@ func TestConcurrentAccess(_ *testing.T) {
 				Endpoint: "http://localhost:9090",
 				Status:   "online",
 			}
-			r.Register(info)
+			_ = r.Register(info)
 		}
 		done <- true
 	}()
@@ -521,7 +570,7 @@ func TestClose(t *testing.T) {
 		ID:     "builder-1",
 		Status: "online",
 	}
-	r.Register(info)
+	_ = r.Register(info)
 
 	// Close should not panic
 	r.Close()
@@ -535,3 +584,110 @@ func TestClose(t *testing.T) {
 		t.Errorf("ID = %s, want builder-1", builder.ID)
 	}
 }
+
+func TestRegistryWatch(t *testing.T) {
+	r := NewRegistry(time.Hour, time.Hour)
+	defer r.Close()
+	_ = r.Register(&BuilderInfo{ID: "builder-1", Status: "online"})
+
+	snapshot, events, stop := r.Watch()
+	if len(snapshot) != 1 || snapshot[0].ID != "builder-1" {
+		t.Fatalf("snapshot = %+v, want builder-1", snapshot)
+	}
+
+	next := func() BuilderEvent {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		default:
+			t.Fatal("no event published")
+			return BuilderEvent{}
+		}
+	}
+
+	_ = r.Register(&BuilderInfo{ID: "builder-2", Status: "online"})
+	if ev := next(); ev.Type != BuilderEventUpdate || ev.Builder.ID != "builder-2" {
+		t.Errorf("event = %+v, want an update of builder-2", ev)
+	}
+
+	_ = r.UpdateLoad("builder-1", 3)
+	if ev := next(); ev.Builder.ID != "builder-1" || ev.Builder.CurrentLoad != 3 {
+		t.Errorf("event = %+v, want builder-1 with load 3", ev)
+	}
+
+	// Only the transition to offline is published, not every stale check.
+	r.mu.Lock()
+	r.builders["builder-1"].LastHeartbeat = time.Now().Add(-2 * time.Hour)
+	r.mu.Unlock()
+	r.checkStaleBuilders()
+	r.checkStaleBuilders()
+	if ev := next(); ev.Builder.ID != "builder-1" || ev.Builder.Status != "offline" {
+		t.Errorf("event = %+v, want builder-1 offline", ev)
+	}
+	if len(events) != 0 {
+		t.Errorf("%d more events queued, want none", len(events))
+	}
+
+	r.Unregister("builder-2")
+	r.Unregister("builder-2")
+	if ev := next(); ev.Type != BuilderEventRemove || ev.ID != "builder-2" {
+		t.Errorf("event = %+v, want a remove of builder-2", ev)
+	}
+	if len(events) != 0 {
+		t.Errorf("%d more events queued, want none", len(events))
+	}
+
+	stop()
+	if _, ok := <-events; ok {
+		t.Error("events still open after stop")
+	}
+	stop()
+}
+
+func TestRegistryWatchDropsLaggingWatcher(t *testing.T) {
+	r := NewRegistry(time.Hour, time.Hour)
+	defer r.Close()
+	_, events, stop := r.Watch()
+	defer stop()
+
+	for i := 0; i <= builderWatchBuffer; i++ {
+		_ = r.Register(&BuilderInfo{ID: "builder-1", Status: "online"})
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n != builderWatchBuffer {
+		t.Errorf("received %d events before the watcher was dropped, want %d", n, builderWatchBuffer)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	r := NewRegistry(30*time.Second, 10*time.Second)
+	defer r.Close()
+	_ = r.Register(&BuilderInfo{ID: "builder-1", Endpoint: "http://new:9090", Status: "online"})
+
+	r.Restore([]*BuilderInfo{
+		{ID: "builder-1", Endpoint: "http://old:9090", Status: "online", Enabled: true},
+		{ID: "builder-2", Endpoint: "http://b2:9090", Status: "busy", CurrentLoad: 2, Enabled: false},
+		nil,
+	})
+
+	if b, _ := r.Get("builder-1"); b.Endpoint != "http://new:9090" {
+		t.Errorf("builder-1 endpoint = %s, want the live registration kept", b.Endpoint)
+	}
+	b, ok := r.Get("builder-2")
+	if !ok {
+		t.Fatal("builder-2 not restored")
+	}
+	if b.Status != "offline" || b.CurrentLoad != 0 || b.Enabled {
+		t.Errorf("builder-2 = %+v, want offline, idle and still disabled", b)
+	}
+
+	// Its next heartbeat brings it back online, still disabled.
+	_ = r.Register(&BuilderInfo{ID: "builder-2", Endpoint: "http://b2:9090", Status: "online"})
+	if b, _ := r.Get("builder-2"); b.Status != "online" || b.Enabled {
+		t.Errorf("builder-2 = %+v after heartbeat, want online and disabled", b)
+	}
+}
@@ -0,0 +1,81 @@
+// Package builder provides live change notifications of the builder registry.
+package builder
+
+// Types of BuilderEvent.
+const (
+	BuilderEventSnapshot = "snapshot"
+	BuilderEventUpdate   = "update"
+	BuilderEventRemove   = "remove"
+)
+
+// builderWatchBuffer is how many events a watcher may fall behind before it
+// is dropped; a dropped watcher resyncs from a new snapshot.
+const builderWatchBuffer = 64
+
+// BuilderEvent is a change to the registry: the state of every builder for
+// a snapshot, a builder's new state for an update, or the ID of a builder
+// that was unregistered for a remove. Builder and Builders are copies
+// shared by every watcher and must not be modified.
+type BuilderEvent struct {
+	Type     string         `json:"type"`
+	Builder  *BuilderInfo   `json:"builder,omitempty"`
+	Builders []*BuilderInfo `json:"builders,omitempty"`
+	ID       string         `json:"id,omitempty"`
+}
+
+// builderWatchers are the channels registry changes are published to.
+type builderWatchers map[chan BuilderEvent]struct{}
+
+// publish hands ev to every watcher without blocking the registry. A
+// watcher whose buffer is full is dropped.
+func (ws builderWatchers) publish(ev BuilderEvent) {
+	for ch := range ws {
+		select {
+		case ch <- ev:
+		default:
+			ws.remove(ch)
+		}
+	}
+}
+
+// remove drops and closes ch if it is still watching.
+func (ws builderWatchers) remove(ch chan BuilderEvent) {
+	if _, ok := ws[ch]; ok {
+		delete(ws, ch)
+		close(ch)
+	}
+}
+
+// Watch returns the registered builders and a channel of the changes made
+// after them: an update whenever a builder heartbeats or its state changes,
+// including going offline for missed heartbeats, and a remove when one is
+// unregistered. The channel is closed by stop, or when the watcher falls
+// too far behind, after which it should Watch again. Call stop when done.
+func (r *Registry) Watch() (snapshot []*BuilderInfo, events <-chan BuilderEvent, stop func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.watchers == nil {
+		r.watchers = make(builderWatchers)
+	}
+	ch := make(chan BuilderEvent, builderWatchBuffer)
+	r.watchers[ch] = struct{}{}
+	for _, b := range r.builders {
+		builderCopy := *b
+		snapshot = append(snapshot, &builderCopy)
+	}
+	stop = func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.watchers.remove(ch)
+	}
+	return snapshot, ch, stop
+}
+
+// publishUpdateLocked publishes the state of b. The caller holds r.mu.
+func (r *Registry) publishUpdateLocked(b *BuilderInfo) {
+	if len(r.watchers) == 0 {
+		return
+	}
+	builderCopy := *b
+	r.watchers.publish(BuilderEvent{Type: BuilderEventUpdate, Builder: &builderCopy})
+}
@@ -0,0 +1,197 @@
+// Package builder provides extraction of emerge dependency resolution errors.
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Categories of ResolutionError.
+const (
+	ResolutionMasked       = "masked"
+	ResolutionRequiredUse  = "required_use"
+	ResolutionBlocker      = "blocker"
+	ResolutionSlotConflict = "slot_conflict"
+	ResolutionNoEbuilds    = "unsatisfiable"
+)
+
+var (
+	// `!!! All ebuilds that could satisfy "dev-lang/foo" have been masked.`
+	allMaskedPattern = regexp.MustCompile(`^!!! All ebuilds that could satisfy "([^"]+)" have been masked\.`)
+
+	// `!!! The ebuild selected to satisfy "media-libs/mesa" has unmet requirements.`
+	// followed by `- media-libs/mesa-23.1.0::gentoo USE="..."`.
+	unmetRequirementsPattern = regexp.MustCompile(`^!!! The ebuild selected to satisfy "([^"]+)" has unmet requirements\.`)
+	unmetEntryPattern        = regexp.MustCompile(`^- ([^\s:]+)(?:::\S+)? USE=`)
+
+	// An unresolved blocker in the merge list, e.g.
+	//   [blocks B      ] sys-apps/foo ("sys-apps/foo" is soft blocking sys-apps/bar-1.0)
+	// Lower-case "b" blockers are resolved by emerge itself and not reported.
+	blockerPattern = regexp.MustCompile(`^\[blocks B\s*\] (\S+) \((.*)\)\s*$`)
+
+	// A slot conflict lists each conflicting slot unindented ("dev-libs/openssl:0")
+	// and then the packages pulled into it, indented:
+	//   (dev-libs/openssl-3.0.9:0/3::gentoo, ebuild scheduled for merge) pulled in by
+	slotAtomPattern     = regexp.MustCompile(`^([a-z0-9][\w+.-]*/[\w+.-]+:\S+)$`)
+	slotInstancePattern = regexp.MustCompile(`^\s+\(([^\s:]+):\S*, [^)]+\) pulled in by`)
+
+	// `emerge: there are no ebuilds to satisfy "x/y".` and its USE-dependency
+	// variant, `emerge: there are no ebuilds built with USE flags to satisfy "x/y[foo]".`
+	noEbuildsPattern = regexp.MustCompile(`^emerge: there are no ebuilds (built with USE flags )?to satisfy "([^"]+)"`)
+)
+
+// ResolutionError is one reason emerge could not resolve a build's
+// dependencies, extracted from the build log.
+type ResolutionError struct {
+	// Category is one of the Resolution* constants.
+	Category string `json:"category"`
+	// Atom is the package or slot the error is about.
+	Atom string `json:"atom"`
+	// Reason says what went wrong, in a sentence.
+	Reason string `json:"reason"`
+}
+
+// parseResolutionErrors scans a build log for emerge's dependency resolution
+// failures: fully masked packages, unsatisfied REQUIRED_USE constraints,
+// unresolved blockers, slot conflicts and atoms no ebuild satisfies. Each
+// error is reported once, in log order. Returns nil if there were none.
+func parseResolutionErrors(buildLog string) []ResolutionError {
+	var errs []ResolutionError
+	seen := map[ResolutionError]bool{}
+	add := func(e ResolutionError) {
+		if !seen[e] {
+			seen[e] = true
+			errs = append(errs, e)
+		}
+	}
+
+	masks := map[string][]string{}
+	for _, mp := range parseMaskedPackages(buildLog) {
+		masks[cpvVersionPattern.ReplaceAllString(mp.CPV, "")] = mp.Reasons
+	}
+
+	lines := strings.Split(buildLog, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \r")
+		if m := allMaskedPattern.FindStringSubmatch(line); m != nil {
+			reason := "every ebuild that could satisfy it is masked"
+			if r := masks[atomCP(m[1])]; len(r) > 0 {
+				reason += " (" + strings.Join(r, ", ") + ")"
+			}
+			add(ResolutionError{Category: ResolutionMasked, Atom: m[1], Reason: reason})
+			continue
+		}
+		if m := unmetRequirementsPattern.FindStringSubmatch(line); m != nil {
+			var atom string
+			var constraints []string
+			i, atom, constraints = requiredUseConstraints(lines, i+1, m[1])
+			for _, c := range constraints {
+				add(ResolutionError{Category: ResolutionRequiredUse, Atom: atom, Reason: "REQUIRED_USE constraint not satisfied: " + c})
+			}
+			continue
+		}
+		if m := blockerPattern.FindStringSubmatch(line); m != nil {
+			add(ResolutionError{Category: ResolutionBlocker, Atom: m[1], Reason: m[2]})
+			continue
+		}
+		if strings.Contains(line, "resulting in a slot conflict:") {
+			var conflicts []ResolutionError
+			i, conflicts = slotConflicts(lines, i+1)
+			for _, c := range conflicts {
+				add(c)
+			}
+			continue
+		}
+		if m := noEbuildsPattern.FindStringSubmatch(line); m != nil {
+			reason := "no ebuild satisfies it"
+			if m[1] != "" {
+				reason = "no ebuild is built with the required USE flags"
+			}
+			add(ResolutionError{Category: ResolutionNoEbuilds, Atom: m[2], Reason: reason})
+		}
+	}
+	return errs
+}
+
+// atomCP strips an atom down to "category/name": operators, version, slot
+// and USE dependencies.
+func atomCP(atom string) string {
+	atom = strings.TrimLeft(atom, "<>=~!")
+	if i := strings.IndexAny(atom, ":["); i >= 0 {
+		atom = atom[:i]
+	}
+	return cpvVersionPattern.ReplaceAllString(strings.TrimSuffix(atom, "*"), "")
+}
+
+// requiredUseConstraints reads the block after an "unmet requirements"
+// header from lines[start:] and returns the cpv emerge selected (or
+// fallback), its unsatisfied REQUIRED_USE constraints, and the index of the
+// last line it consumed.
+func requiredUseConstraints(lines []string, start int, fallback string) (int, string, []string) {
+	atom := fallback
+	var constraints []string
+	inList := false
+	for i := start; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if m := unmetEntryPattern.FindStringSubmatch(line); m != nil && !inList {
+			atom = m[1]
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "The following REQUIRED_USE flag constraints are unsatisfied:"):
+			inList = true
+		case inList && line == "":
+			return i, atom, constraints
+		case inList:
+			constraints = append(constraints, line)
+		case strings.HasPrefix(line, "!!!") || strings.HasPrefix(line, "emerge:"):
+			// The next error started before any constraint list.
+			return i - 1, atom, constraints
+		}
+	}
+	return len(lines) - 1, atom, constraints
+}
+
+// slotConflicts reads a slot conflict block from lines[start:] and returns
+// one error per conflicting slot, along with the index of the last line it
+// consumed.
+func slotConflicts(lines []string, start int) (int, []ResolutionError) {
+	var conflicts []ResolutionError
+	slot := ""
+	var instances []string
+	flush := func() {
+		if slot != "" && len(instances) > 0 {
+			conflicts = append(conflicts, ResolutionError{
+				Category: ResolutionSlotConflict,
+				Atom:     slot,
+				Reason:   fmt.Sprintf("packages that cannot be installed together were pulled into one slot: %s", strings.Join(instances, ", ")),
+			})
+		}
+		slot, instances = "", nil
+	}
+
+	i := start
+	for ; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "!!!") && slot == "" {
+			continue
+		}
+		if m := slotAtomPattern.FindStringSubmatch(line); m != nil {
+			flush()
+			slot = m[1]
+			continue
+		}
+		if m := slotInstancePattern.FindStringSubmatch(line); m != nil && slot != "" {
+			instances = append(instances, m[1])
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// The "pulled in by" details of the current instance.
+			continue
+		}
+		break
+	}
+	flush()
+	return i - 1, conflicts
+}
@@ -0,0 +1,105 @@
+package builder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+const resolutionLog = `Calculating dependencies... done!
+
+!!! The ebuild selected to satisfy "media-libs/mesa[vulkan]" has unmet requirements.
+- media-libs/mesa-23.1.0::gentoo USE="vulkan -llvm -zstd" ABI_X86="(64)" VIDEO_CARDS="radeonsi"
+
+  The following REQUIRED_USE flag constraints are unsatisfied:
+    vulkan? ( llvm )
+    video_cards_radeonsi? ( llvm )
+
+  The above constraints are a subset of the following complete expression:
+    vulkan? ( llvm ) video_cards_radeonsi? ( llvm )
+
+[ebuild  N     ] sys-apps/bar-1.0::gentoo
+[blocks B      ] sys-apps/foo ("sys-apps/foo" is soft blocking sys-apps/bar-1.0)
+[blocks b      ] app-misc/old ("app-misc/old" is soft blocking app-misc/new-2)
+
+ * Error: The above package list contains packages which cannot be
+ * installed at the same time on the same system.
+
+!!! Multiple package instances within a single package slot have been pulled
+!!! into the dependency graph, resulting in a slot conflict:
+
+dev-libs/openssl:0
+
+  (dev-libs/openssl-3.0.9:0/3::gentoo, ebuild scheduled for merge) pulled in by
+    >=dev-libs/openssl-3 required by (net-misc/curl-8.1.2:0/0::gentoo, ebuild scheduled for merge)
+
+  (dev-libs/openssl-1.1.1u:0/1.1::gentoo, installed) pulled in by
+    =dev-libs/openssl-1.1* required by (dev-lang/ruby-2.7.8:2.7/2.7::gentoo, installed)
+
+It may be possible to solve this problem by using package.mask to
+prevent one of those packages from being selected.
+
+!!! All ebuilds that could satisfy "dev-lang/foo" have been masked.
+!!! One of the following masked packages is required to complete your request:
+- dev-lang/foo-2.0::gentoo (masked by: ~amd64 keyword)
+
+emerge: there are no ebuilds to satisfy "app-misc/nope".
+emerge: there are no ebuilds built with USE flags to satisfy "dev-libs/libxml2[python]".
+emerge: there are no ebuilds to satisfy "app-misc/nope".
+`
+
+func TestParseResolutionErrors(t *testing.T) {
+	want := []ResolutionError{
+		{Category: ResolutionRequiredUse, Atom: "media-libs/mesa-23.1.0", Reason: "REQUIRED_USE constraint not satisfied: vulkan? ( llvm )"},
+		{Category: ResolutionRequiredUse, Atom: "media-libs/mesa-23.1.0", Reason: "REQUIRED_USE constraint not satisfied: video_cards_radeonsi? ( llvm )"},
+		{Category: ResolutionBlocker, Atom: "sys-apps/foo", Reason: `"sys-apps/foo" is soft blocking sys-apps/bar-1.0`},
+		{Category: ResolutionSlotConflict, Atom: "dev-libs/openssl:0", Reason: "packages that cannot be installed together were pulled into one slot: dev-libs/openssl-3.0.9, dev-libs/openssl-1.1.1u"},
+		{Category: ResolutionMasked, Atom: "dev-lang/foo", Reason: "every ebuild that could satisfy it is masked (~amd64 keyword)"},
+		{Category: ResolutionNoEbuilds, Atom: "app-misc/nope", Reason: "no ebuild satisfies it"},
+		{Category: ResolutionNoEbuilds, Atom: "dev-libs/libxml2[python]", Reason: "no ebuild is built with the required USE flags"},
+	}
+	got := parseResolutionErrors(resolutionLog)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseResolutionErrors() =\n%+v\nwant\n%+v", got, want)
+	}
+	if got := parseResolutionErrors(">>> Compiling source in /var/tmp/portage/app-misc/jq-1.7\nmake: *** [all] Error 2\n"); got != nil {
+		t.Errorf("parseResolutionErrors() on a compile failure = %v, want nil", got)
+	}
+}
+
+func TestResolutionErrorsReachServerJob(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "failed",
+			"metadata": map[string]interface{}{
+				"resolution_errors": parseResolutionErrors(resolutionLog),
+			},
+		})
+	}))
+	defer srv.Close()
+
+	mgr := NewManager(&config.ServerConfig{})
+	defer mgr.Shutdown()
+
+	snap, err := mgr.fetchInstanceJob(srv.URL + "/api/v1/jobs/r1")
+	if err != nil {
+		t.Fatalf("fetchInstanceJob() error = %v", err)
+	}
+	if len(snap.ResolutionErrors) != 7 || snap.ResolutionErrors[2].Category != ResolutionBlocker {
+		t.Fatalf("ResolutionErrors = %+v, want the builder's parsed errors", snap.ResolutionErrors)
+	}
+
+	jobID, err := mgr.SubmitBuild(&BuildRequest{PackageName: "media-libs/mesa", Arch: "amd64"})
+	if err != nil {
+		t.Fatalf("SubmitBuild() error = %v", err)
+	}
+	mgr.setResolutionErrors(jobID, snap.ResolutionErrors)
+	if status, _ := mgr.GetStatus(jobID); !reflect.DeepEqual(status.ResolutionErrors, snap.ResolutionErrors) {
+		t.Errorf("resolution errors not recorded on the job: %+v", status.ResolutionErrors)
+	}
+}
@@ -0,0 +1,227 @@
+// Package builder provides the cache of successful build results.
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// resultCache maps the cache key of a successful build (see resultCacheKey)
+// to the job that produced it.
+type resultCache struct {
+	mu   sync.Mutex
+	jobs map[string]string
+}
+
+// get returns the job cached under key.
+func (c *resultCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.jobs[key]
+	return id, ok
+}
+
+// add caches jobID under key, replacing an older result.
+func (c *resultCache) add(key, jobID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.jobs == nil {
+		c.jobs = map[string]string{}
+	}
+	c.jobs[key] = jobID
+}
+
+// remove drops key if it still names jobID.
+func (c *resultCache) remove(key, jobID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.jobs[key] == jobID {
+		delete(c.jobs, key)
+	}
+}
+
+// resultCacheEnabled reports whether successful builds are reused for
+// identical later requests.
+func (lb *LocalBuilder) resultCacheEnabled() bool {
+	return lb.cfg != nil && lb.cfg.BuildResultCache
+}
+
+// treeSnapshot identifies the state of the portage tree: the ::gentoo
+// commit, or else the snapshot time stamped into the tree. It is "" when
+// neither is known, and nothing is cached.
+func (lb *LocalBuilder) treeSnapshot() string {
+	if rev := lb.TreeRevision(); rev != "" {
+		return "git:" + rev
+	}
+	data, err := os.ReadFile(filepath.Join(lb.reposPath(), treeTimestampFile))
+	if ts := strings.TrimSpace(string(data)); err == nil && ts != "" {
+		return "timestamp:" + ts
+	}
+	return ""
+}
+
+// resultCacheKey is the content address of req's result on this builder:
+// the hash of its build target with USE flags in sorted order, the arch, the
+// build image and the portage tree snapshot. It is "" for a request that
+// cannot be cached: with an unknown tree, or from an overlay, whose branch
+// may have moved since.
+func (lb *LocalBuilder) resultCacheKey(req *LocalBuildRequest) string {
+	tree := lb.treeSnapshot()
+	if tree == "" || hasOverlay(req) {
+		return ""
+	}
+	image := ""
+	if lb.useDocker {
+		image = lb.dockerImage
+	}
+	data, _ := json.Marshal(struct {
+		Target string `json:"target"`
+		Image  string `json:"image,omitempty"`
+		Tree   string `json:"tree"`
+	}{buildTargetKey(sortedUseRequest(req), lb.architecture), image, tree})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hasOverlay reports whether any package of req comes from an overlay.
+func hasOverlay(req *LocalBuildRequest) bool {
+	specs := req.PackageSpecs
+	if req.ConfigBundle != nil && req.ConfigBundle.Packages != nil {
+		specs = append(slices.Clip(specs), req.ConfigBundle.Packages.Packages...)
+	}
+	return slices.ContainsFunc(specs, func(pkg PackageSpec) bool { return pkg.OverlayURL != "" })
+}
+
+// sortedUseRequest returns req with every package spec's USE flags sorted,
+// copying what it changes, so the order they were given in does not matter.
+func sortedUseRequest(req *LocalBuildRequest) *LocalBuildRequest {
+	sortSpecs := func(specs []PackageSpec) []PackageSpec {
+		if len(specs) == 0 {
+			return specs
+		}
+		out := slices.Clone(specs)
+		for i := range out {
+			out[i].UseFlags = slices.Sorted(slices.Values(out[i].UseFlags))
+		}
+		return out
+	}
+	r := *req
+	r.PackageSpecs = sortSpecs(r.PackageSpecs)
+	if r.ConfigBundle != nil && r.ConfigBundle.Packages != nil {
+		bundle := *r.ConfigBundle
+		bundle.Packages = &BuildPackageSpec{Packages: sortSpecs(bundle.Packages.Packages)}
+		r.ConfigBundle = &bundle
+	}
+	return &r
+}
+
+// cachedResult returns the successful job cached under key whose artifacts
+// are all still in the artifact dir. A result whose artifacts are gone, for
+// instance pruned by the retention policy, is dropped from the cache.
+func (lb *LocalBuilder) cachedResult(key string) (*BuildJob, bool) {
+	id, ok := lb.resultCache.get(key)
+	if !ok {
+		store := lb.sqliteJobs()
+		if store == nil {
+			return nil, false
+		}
+		var err error
+		if id, ok, err = store.CachedJob(key); err != nil {
+			log.Printf("Failed to look up cached build: %v", err)
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	job, ok := lb.findJob(id)
+	if !ok {
+		lb.resultCache.remove(key, id)
+		return nil, false
+	}
+	job = job.Clone()
+	if job.Status != "success" || len(job.Artifacts) == 0 || !lb.artifactsPresent(job.Artifacts) {
+		lb.resultCache.remove(key, id)
+		return nil, false
+	}
+	lb.resultCache.add(key, id)
+	return job, true
+}
+
+// artifactsPresent reports whether every artifact in rels exists.
+func (lb *LocalBuilder) artifactsPresent(rels []string) bool {
+	for _, rel := range rels {
+		if !filepath.IsLocal(rel) {
+			return false
+		}
+		if _, err := os.Stat(filepath.Join(lb.artifactDir, rel)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// submitCachedBuild records a job for req that succeeded at once with the
+// artifacts of the cached build from: it is "success" with metadata
+// cache_hit and cached_from, and never queued.
+func (lb *LocalBuilder) submitCachedBuild(req *LocalBuildRequest, from *BuildJob) string {
+	now := time.Now()
+	job := &BuildJob{
+		ID:          uuid.New().String(),
+		Request:     req,
+		Status:      "success",
+		StartTime:   now,
+		EndTime:     now,
+		ArtifactURL: from.ArtifactURL,
+		Artifacts:   from.Artifacts,
+		Metadata:    map[string]interface{}{"cache_hit": true, "cached_from": from.ID},
+		RequestID:   req.RequestID,
+		redactor:    lb.jobRedactor(req),
+	}
+	job.appendLog(fmt.Sprintf("[cache] identical build %s already succeeded against this tree; reusing its artifacts\n", from.ID))
+	log.Printf("Job %s reuses the cached result of job %s", job.ref(), from.ID)
+
+	lb.jobsMutex.Lock()
+	lb.jobs[job.ID] = job
+	lb.jobsMutex.Unlock()
+	lb.retireJob(job)
+	return job.ID
+}
+
+// recordCacheKey stores in job the cache key of the tree it was just built
+// against. Callers hold treeMu, so no sync has changed the tree since.
+func (lb *LocalBuilder) recordCacheKey(job *BuildJob) {
+	if !lb.resultCacheEnabled() {
+		return
+	}
+	if key := lb.resultCacheKey(job.Request); key != "" {
+		job.setMetadata("cache_key", key)
+	}
+}
+
+// cacheResult caches a job that just succeeded under the key recorded by
+// recordCacheKey. Callers hold job.mu.
+func (lb *LocalBuilder) cacheResult(job *BuildJob) {
+	if key, ok := job.Metadata["cache_key"].(string); ok && job.Status == "success" && len(job.Artifacts) > 0 {
+		lb.resultCache.add(key, job.ID)
+	}
+}
+
+// loadResultCache caches the successful jobs loaded at startup.
+func (lb *LocalBuilder) loadResultCache(jobs map[string]*BuildJob) {
+	for _, job := range jobs {
+		job.mu.Lock()
+		lb.cacheResult(job)
+		job.mu.Unlock()
+	}
+}
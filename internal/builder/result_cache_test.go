@@ -0,0 +1,176 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// newCacheTestBuilder returns a builder with the result cache on and a tree
+// snapshot stamped stamp.
+func newCacheTestBuilder(t *testing.T, stamp string) *LocalBuilder {
+	t.Helper()
+	repos := t.TempDir()
+	lb := &LocalBuilder{
+		architecture: "amd64",
+		artifactDir:  t.TempDir(),
+		jobs:         map[string]*BuildJob{},
+		jobQueue:     newJobQueue(10),
+		cfg:          &config.BuilderConfig{BuildResultCache: true, PortageReposPath: repos},
+	}
+	setTreeStamp(t, lb, stamp)
+	return lb
+}
+
+func setTreeStamp(t *testing.T, lb *LocalBuilder, stamp string) {
+	t.Helper()
+	path := filepath.Join(lb.reposPath(), treeTimestampFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(stamp+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func cacheTestRequest(useFlags ...string) *LocalBuildRequest {
+	return &LocalBuildRequest{
+		PackageName:  "app-misc/jq",
+		PackageSpecs: []PackageSpec{{Atom: "app-misc/jq", UseFlags: useFlags}},
+	}
+}
+
+// succeed records a finished build of req that produced rel.
+func succeed(t *testing.T, lb *LocalBuilder, id string, req *LocalBuildRequest, rel string) {
+	t.Helper()
+	writeTestArtifact(t, lb.artifactDir, rel)
+	job := &BuildJob{ID: id, Request: req, Status: "building", Artifacts: []string{rel}}
+	lb.recordCacheKey(job)
+	job.Status = "success"
+	job.EndTime = time.Now()
+	lb.cacheResult(job)
+	lb.jobs[id] = job
+}
+
+func TestSubmitBuildCacheHit(t *testing.T) {
+	t.Parallel()
+
+	lb := newCacheTestBuilder(t, "Mon, 12 Oct 2026 00:45:01 +0000")
+	succeed(t, lb, "prior", cacheTestRequest("oniguruma", "-static"), "app-misc/jq-1.7-1.gpkg.tar")
+
+	id, err := lb.SubmitBuild(cacheTestRequest("-static", "oniguruma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := lb.GetJobStatus(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "success" || job.Metadata["cache_hit"] != true || job.Metadata["cached_from"] != "prior" {
+		t.Errorf("job = %s %v, want a cache hit on job prior", job.Status, job.Metadata)
+	}
+	if !slices.Equal(job.Artifacts, []string{"app-misc/jq-1.7-1.gpkg.tar"}) {
+		t.Errorf("artifacts = %v", job.Artifacts)
+	}
+	if lb.jobQueue.len() != 0 {
+		t.Error("a cache hit was queued")
+	}
+}
+
+func TestSubmitBuildCacheMiss(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, lb *LocalBuilder) *LocalBuildRequest
+	}{
+		{"force rebuild", func(_ *testing.T, _ *LocalBuilder) *LocalBuildRequest {
+			req := cacheTestRequest()
+			req.ForceRebuild = true
+			return req
+		}},
+		{"other USE flags", func(_ *testing.T, _ *LocalBuilder) *LocalBuildRequest {
+			return cacheTestRequest("static")
+		}},
+		{"tree synced since", func(t *testing.T, lb *LocalBuilder) *LocalBuildRequest {
+			setTreeStamp(t, lb, "Tue, 13 Oct 2026 00:45:01 +0000")
+			return cacheTestRequest()
+		}},
+		{"artifact pruned", func(t *testing.T, lb *LocalBuilder) *LocalBuildRequest {
+			if err := os.Remove(filepath.Join(lb.artifactDir, "app-misc/jq-1.7-1.gpkg.tar")); err != nil {
+				t.Fatal(err)
+			}
+			return cacheTestRequest()
+		}},
+		{"cache off", func(_ *testing.T, lb *LocalBuilder) *LocalBuildRequest {
+			lb.cfg.BuildResultCache = false
+			return cacheTestRequest()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			lb := newCacheTestBuilder(t, "Mon, 12 Oct 2026 00:45:01 +0000")
+			succeed(t, lb, "prior", cacheTestRequest(), "app-misc/jq-1.7-1.gpkg.tar")
+
+			id, err := lb.SubmitBuild(tt.setup(t, lb))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job, _ := lb.GetJobStatus(id); job.Status != "queued" || job.Metadata["cache_hit"] != nil {
+				t.Errorf("job = %s %v, want it queued", job.Status, job.Metadata)
+			}
+		})
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	t.Parallel()
+
+	lb := newCacheTestBuilder(t, "Mon, 12 Oct 2026 00:45:01 +0000")
+	req := cacheTestRequest("b", "a")
+	if lb.resultCacheKey(req) != lb.resultCacheKey(cacheTestRequest("a", "b")) {
+		t.Error("USE flag order changed the key")
+	}
+	if !slices.Equal(req.PackageSpecs[0].UseFlags, []string{"b", "a"}) {
+		t.Errorf("request USE flags were reordered: %v", req.PackageSpecs[0].UseFlags)
+	}
+	arm := &LocalBuilder{architecture: "arm64", cfg: lb.cfg}
+	if arm.resultCacheKey(req) == lb.resultCacheKey(req) {
+		t.Error("arch is not part of the key")
+	}
+
+	overlay := cacheTestRequest()
+	overlay.PackageSpecs[0].OverlayURL = "https://example.org/overlay.git"
+	if key := lb.resultCacheKey(overlay); key != "" {
+		t.Errorf("overlay build key = %q, want none", key)
+	}
+	unknown := &LocalBuilder{cfg: &config.BuilderConfig{PortageReposPath: t.TempDir()}}
+	if key := unknown.resultCacheKey(req); key != "" {
+		t.Errorf("key without a tree snapshot = %q, want none", key)
+	}
+}
+
+func TestCachedResultSQLite(t *testing.T) {
+	t.Parallel()
+
+	lb := newCacheTestBuilder(t, "Mon, 12 Oct 2026 00:45:01 +0000")
+	store := newTestSQLiteStore(t)
+	lb.jobStore = store
+	succeed(t, lb, "prior", cacheTestRequest(), "app-misc/jq-1.7-1.gpkg.tar")
+	if err := store.SaveJob("prior", lb.jobs["prior"].Clone(), nil); err != nil {
+		t.Fatal(err)
+	}
+	// What a restart leaves: the job only in the store, the cache empty.
+	lb.jobs = map[string]*BuildJob{}
+	lb.resultCache = resultCache{}
+
+	from, ok := lb.cachedResult(lb.resultCacheKey(cacheTestRequest()))
+	if !ok || from.ID != "prior" {
+		t.Fatalf("cachedResult() = %v, %v; want job prior from the store", from, ok)
+	}
+}
@@ -0,0 +1,276 @@
+// Package builder provides the retention policy for finished build jobs.
+package builder
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/slchris/portage-engine/internal/binpkg"
+	"github.com/slchris/portage-engine/internal/gpg"
+)
+
+// artifactSidecarExts are the files written next to each artifact, removed
+// along with it: its signature, checksums and provenance, and its
+// compressed forms with their own signatures and checksums.
+var artifactSidecarExts = func() []string {
+	exts := []string{gpg.SignatureFileExt, sha256FileExt, sha512FileExt, provenanceFileExt}
+	for _, ext := range compressedExts {
+		exts = append(exts, ext, ext+gpg.SignatureFileExt, ext+sha256FileExt, ext+sha512FileExt)
+	}
+	return exts
+}()
+
+// PruneResult reports what one retention sweep removed.
+type PruneResult struct {
+	// Jobs is the number of job records removed.
+	Jobs int `json:"jobs"`
+	// Artifacts is the number of artifacts deleted from the artifact dir.
+	Artifacts int `json:"artifacts"`
+	// FreedBytes is the size of the deleted artifacts.
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+// retentionEnabled reports whether any part of the retention policy is set.
+func (lb *LocalBuilder) retentionEnabled() bool {
+	return lb.cfg != nil && (lb.cfg.JobRetention > 0 || lb.cfg.JobRetentionMaxJobs > 0 || lb.cfg.UploadedArtifactRetention > 0)
+}
+
+// pruneLoop applies the retention policy now and then every interval until
+// the builder shuts down.
+func (lb *LocalBuilder) pruneLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if res := lb.PruneJobs(); res.Jobs > 0 || res.Artifacts > 0 {
+			log.Printf("Retention: pruned %d jobs and %d artifacts (%d bytes)", res.Jobs, res.Artifacts, res.FreedBytes)
+		}
+		select {
+		case <-lb.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PruneJobs applies the retention policy once: it removes the finished jobs
+// past JobRetention or beyond JobRetentionMaxJobs (with their artifacts if
+// PruneArtifacts is set), and the local copies of uploaded artifacts past
+// UploadedArtifactRetention. Queued and building jobs are never touched.
+func (lb *LocalBuilder) PruneJobs() PruneResult {
+	var res PruneResult
+	if lb.cfg == nil {
+		return res
+	}
+	now := time.Now()
+
+	var cutoff time.Time
+	if lb.cfg.JobRetention > 0 {
+		cutoff = now.Add(-lb.cfg.JobRetention)
+	}
+	expired := lb.pruneExpiredJobs(cutoff, lb.cfg.JobRetentionMaxJobs)
+	res.Jobs = len(expired)
+	if lb.cfg.PruneArtifacts {
+		lb.deleteJobArtifacts(expired, &res)
+	}
+
+	if lb.cfg.UploadedArtifactRetention > 0 {
+		uploaded := lb.uploadedJobsEndedBefore(now.Add(-lb.cfg.UploadedArtifactRetention))
+		lb.deleteJobArtifacts(uploaded, &res)
+		for _, job := range uploaded {
+			lb.markLocalArtifactsPruned(job)
+		}
+	}
+
+	if res.Artifacts > 0 {
+		if err := binpkg.GenerateBinhostIndex(lb.artifactDir); err != nil {
+			log.Printf("Warning: failed to update Packages index after pruning: %v", err)
+		}
+	}
+	if (res.Jobs > 0 || res.Artifacts > 0) && lb.sqliteJobs() == nil && lb.persister != nil {
+		if err := lb.persister.SaveNow(); err != nil {
+			log.Printf("Failed to save jobs after pruning: %v", err)
+		}
+	}
+	return res
+}
+
+// pruneExpiredJobs removes and returns the finished jobs that ended before
+// cutoff (unless zero) or are not among the keep most recently ended.
+func (lb *LocalBuilder) pruneExpiredJobs(cutoff time.Time, keep int) []*BuildJob {
+	if store := lb.sqliteJobs(); store != nil {
+		jobs, err := store.PruneFinished(cutoff, keep)
+		if err != nil {
+			log.Printf("Failed to prune jobs: %v", err)
+		}
+		return jobs
+	}
+
+	lb.jobsMutex.Lock()
+	defer lb.jobsMutex.Unlock()
+	finished := make([]*BuildJob, 0, len(lb.jobs))
+	for _, job := range lb.jobs {
+		if job := job.Clone(); !job.isActive() {
+			finished = append(finished, job)
+		}
+	}
+	expired := selectExpiredJobs(finished, cutoff, keep)
+	for _, job := range expired {
+		delete(lb.jobs, job.ID)
+	}
+	return expired
+}
+
+// selectExpiredJobs returns the jobs of finished that ended before cutoff
+// (unless zero) or are not among the keep (if > 0) most recently ended.
+func selectExpiredJobs(finished []*BuildJob, cutoff time.Time, keep int) []*BuildJob {
+	sort.Slice(finished, func(i, j int) bool {
+		if !finished[i].EndTime.Equal(finished[j].EndTime) {
+			return finished[i].EndTime.After(finished[j].EndTime)
+		}
+		return finished[i].ID < finished[j].ID
+	})
+	var expired []*BuildJob
+	for i, job := range finished {
+		tooOld := !cutoff.IsZero() && !job.EndTime.IsZero() && job.EndTime.Before(cutoff)
+		if tooOld || (keep > 0 && i >= keep) {
+			expired = append(expired, job)
+		}
+	}
+	return expired
+}
+
+// isActive reports whether a worker may still change the job.
+func (j *BuildJob) isActive() bool {
+	return j.Status == "queued" || j.Status == "building"
+}
+
+// uploadedJobsEndedBefore returns the finished jobs that ended before cutoff
+// whose artifacts were uploaded and still have local copies.
+func (lb *LocalBuilder) uploadedJobsEndedBefore(cutoff time.Time) []*BuildJob {
+	if store := lb.sqliteJobs(); store != nil {
+		jobs, err := store.UploadedJobsEndedBefore(cutoff)
+		if err != nil {
+			log.Printf("Failed to list uploaded jobs: %v", err)
+		}
+		return jobs
+	}
+
+	lb.jobsMutex.RLock()
+	defer lb.jobsMutex.RUnlock()
+	var jobs []*BuildJob
+	for _, job := range lb.jobs {
+		job := job.Clone()
+		if job.isActive() || job.EndTime.IsZero() || !job.EndTime.Before(cutoff) {
+			continue
+		}
+		if job.Metadata["uploaded"] == true && job.Metadata["local_artifacts_pruned"] == nil {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// markLocalArtifactsPruned records in the stored job that its local
+// artifact copies are gone, so later sweeps skip it.
+func (lb *LocalBuilder) markLocalArtifactsPruned(job *BuildJob) {
+	lb.jobsMutex.RLock()
+	live, ok := lb.jobs[job.ID]
+	lb.jobsMutex.RUnlock()
+	if ok {
+		live.setMetadata("local_artifacts_pruned", true)
+		return
+	}
+	if store := lb.sqliteJobs(); store != nil {
+		job.setMetadata("local_artifacts_pruned", true)
+		if err := store.SaveJob(job.ID, job, nil); err != nil {
+			log.Printf("Failed to save job %s: %v", job.ref(), err)
+		}
+	}
+}
+
+// deleteJobArtifacts deletes the artifacts of jobs, and the files next to
+// them, unless a job outside jobs still lists the artifact. The jobs'
+// references to their blobs are dropped, and a blob is only deleted, and
+// its size counted as freed, once no other job references it.
+func (lb *LocalBuilder) deleteJobArtifacts(jobs []*BuildJob, res *PruneResult) {
+	if len(jobs) == 0 {
+		return
+	}
+	batch := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		batch[job.ID] = true
+	}
+	inUse := lb.liveArtifacts(batch)
+	deleted := map[string]bool{}
+	for _, job := range jobs {
+		for _, rel := range job.Artifacts {
+			if deleted[rel] || inUse[rel] || !filepath.IsLocal(rel) || lb.storedArtifactReferenced(rel, batch) {
+				continue
+			}
+			deleted[rel] = true
+			path := filepath.Join(lb.artifactDir, rel)
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				log.Printf("Failed to delete artifact %s: %v", rel, err)
+				continue
+			}
+			for _, ext := range artifactSidecarExts {
+				_ = os.Remove(path + ext)
+			}
+			lb.unindexArtifact(rel)
+			res.Artifacts++
+			if artifactBlob(job, rel) == "" {
+				res.FreedBytes += info.Size()
+			}
+		}
+	}
+	if lb.blobs != nil {
+		res.FreedBytes += lb.blobs.release(batch)
+	}
+}
+
+// liveArtifacts returns the artifacts listed by the in-memory jobs not in
+// exclude.
+func (lb *LocalBuilder) liveArtifacts(exclude map[string]bool) map[string]bool {
+	lb.jobsMutex.RLock()
+	defer lb.jobsMutex.RUnlock()
+	inUse := map[string]bool{}
+	for id, job := range lb.jobs {
+		if exclude[id] {
+			continue
+		}
+		job.mu.Lock()
+		for _, rel := range job.Artifacts {
+			inUse[rel] = true
+		}
+		job.mu.Unlock()
+	}
+	return inUse
+}
+
+// storedArtifactReferenced reports whether a job in the SQLite store and not
+// in exclude lists the artifact rel.
+func (lb *LocalBuilder) storedArtifactReferenced(rel string, exclude map[string]bool) bool {
+	store := lb.sqliteJobs()
+	if store == nil {
+		return false
+	}
+	ids, err := store.ArtifactReferences(rel)
+	if err != nil {
+		// Keep the file rather than risk deleting one still in use.
+		log.Printf("Failed to check references to %s: %v", rel, err)
+		return true
+	}
+	for _, id := range ids {
+		if !exclude[id] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/internal/gpg"
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// writeTestArtifact creates the artifact rel with its signature in dir.
+func writeTestArtifact(t *testing.T, dir, rel string) string {
+	t.Helper()
+	path := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path, path + gpg.SignatureFileExt} {
+		if err := os.WriteFile(p, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func artifactExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestPruneJobs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	oldPkg := writeTestArtifact(t, dir, "app-misc/jq-1.7-1.gpkg.tar")
+	sharedPkg := writeTestArtifact(t, dir, "dev-lang/python-3.12-1.gpkg.tar")
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	lb := &LocalBuilder{
+		artifactDir: dir,
+		cfg:         &config.BuilderConfig{JobRetention: 24 * time.Hour, PruneArtifacts: true},
+		jobs: map[string]*BuildJob{
+			"queued":   {ID: "queued", Status: "queued", StartTime: old},
+			"building": {ID: "building", Status: "building", StartTime: old},
+			"old":      {ID: "old", Status: "success", EndTime: old, Artifacts: []string{"app-misc/jq-1.7-1.gpkg.tar"}},
+			"old-dup":  {ID: "old-dup", Status: "success", EndTime: old, Artifacts: []string{"dev-lang/python-3.12-1.gpkg.tar"}},
+			"recent":   {ID: "recent", Status: "success", EndTime: now, Artifacts: []string{"dev-lang/python-3.12-1.gpkg.tar"}},
+			"failed":   {ID: "failed", Status: "failed", EndTime: old.Add(-time.Hour)},
+		},
+	}
+
+	res := lb.PruneJobs()
+	if res.Jobs != 3 || res.Artifacts != 1 || res.FreedBytes != 4 {
+		t.Errorf("PruneJobs() = %+v, want 3 jobs and 1 artifact of 4 bytes", res)
+	}
+	var kept []string
+	for id := range lb.jobs {
+		kept = append(kept, id)
+	}
+	sort.Strings(kept)
+	if want := []string{"building", "queued", "recent"}; !slices.Equal(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if artifactExists(oldPkg) || artifactExists(oldPkg+gpg.SignatureFileExt) {
+		t.Error("the pruned job's artifact or signature is still there")
+	}
+	if !artifactExists(sharedPkg) {
+		t.Error("an artifact a kept job lists was deleted")
+	}
+}
+
+func TestPruneJobsMaxCount(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	dir := t.TempDir()
+	pkg := writeTestArtifact(t, dir, "app-misc/jq-1.7-1.gpkg.tar")
+	lb := &LocalBuilder{
+		artifactDir: dir,
+		cfg:         &config.BuilderConfig{JobRetentionMaxJobs: 2},
+		jobs: map[string]*BuildJob{
+			"a":       {ID: "a", Status: "success", EndTime: now.Add(-3 * time.Hour), Artifacts: []string{"app-misc/jq-1.7-1.gpkg.tar"}},
+			"b":       {ID: "b", Status: "failed", EndTime: now.Add(-2 * time.Hour)},
+			"c":       {ID: "c", Status: "cancelled", EndTime: now.Add(-time.Hour)},
+			"running": {ID: "running", Status: "building", StartTime: now.Add(-4 * time.Hour)},
+		},
+	}
+
+	if res := lb.PruneJobs(); res.Jobs != 1 || res.Artifacts != 0 {
+		t.Errorf("PruneJobs() = %+v, want 1 job and no artifacts", res)
+	}
+	if _, ok := lb.jobs["a"]; ok || len(lb.jobs) != 3 {
+		t.Errorf("jobs = %v, want the oldest finished job pruned", lb.jobs)
+	}
+	if !artifactExists(pkg) {
+		t.Error("artifact deleted without PRUNE_ARTIFACTS")
+	}
+}
+
+func TestPruneUploadedArtifacts(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	pkg := writeTestArtifact(t, dir, "app-misc/jq-1.7-1.gpkg.tar")
+	lb := &LocalBuilder{
+		artifactDir: dir,
+		cfg:         &config.BuilderConfig{UploadedArtifactRetention: time.Hour},
+		jobs: map[string]*BuildJob{
+			"up": {ID: "up", Status: "success", EndTime: time.Now().Add(-2 * time.Hour),
+				Artifacts: []string{"app-misc/jq-1.7-1.gpkg.tar"}, Metadata: map[string]interface{}{"uploaded": true}},
+		},
+	}
+
+	if res := lb.PruneJobs(); res.Jobs != 0 || res.Artifacts != 1 {
+		t.Errorf("PruneJobs() = %+v, want only the local copy removed", res)
+	}
+	if artifactExists(pkg) {
+		t.Error("the uploaded artifact's local copy is still there")
+	}
+	if lb.jobs["up"].Metadata["local_artifacts_pruned"] != true {
+		t.Errorf("metadata = %v", lb.jobs["up"].Metadata)
+	}
+	if res := lb.PruneJobs(); res.Artifacts != 0 {
+		t.Errorf("second sweep = %+v, want nothing left to prune", res)
+	}
+}
+
+func TestPruneJobsSQLite(t *testing.T) {
+	t.Parallel()
+
+	store := newTestSQLiteStore(t)
+	dir := t.TempDir()
+	oldPkg := writeTestArtifact(t, dir, "app-misc/jq-1.7-1.gpkg.tar")
+	upPkg := writeTestArtifact(t, dir, "dev-lang/python-3.12-1.gpkg.tar")
+	now := time.Now()
+	if err := store.Save(map[string]*BuildJob{
+		"queued": {ID: "queued", Status: "queued", StartTime: now.Add(-72 * time.Hour)},
+		"old":    {ID: "old", Status: "success", EndTime: now.Add(-48 * time.Hour), Artifacts: []string{"app-misc/jq-1.7-1.gpkg.tar"}},
+		"a":      {ID: "a", Status: "failed", EndTime: now.Add(-3 * time.Hour)},
+		"b": {ID: "b", Status: "success", EndTime: now.Add(-2 * time.Hour),
+			Artifacts: []string{"dev-lang/python-3.12-1.gpkg.tar"}, Metadata: map[string]interface{}{"uploaded": true}},
+		"c": {ID: "c", Status: "success", EndTime: now.Add(-time.Hour)},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	lb := &LocalBuilder{
+		artifactDir: dir,
+		jobStore:    store,
+		jobs:        map[string]*BuildJob{},
+		cfg: &config.BuilderConfig{JobRetention: 24 * time.Hour, JobRetentionMaxJobs: 2,
+			PruneArtifacts: true, UploadedArtifactRetention: time.Hour},
+	}
+
+	if res := lb.PruneJobs(); res.Jobs != 2 || res.Artifacts != 2 {
+		t.Errorf("PruneJobs() = %+v, want 2 jobs and 2 artifacts", res)
+	}
+	jobs, _, err := store.ListJobs(JobQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := jobIDs(jobs); !slices.Equal(got, []string{"queued", "b", "c"}) {
+		t.Errorf("stored jobs = %v, want c, b and the queued job", got)
+	}
+	if artifactExists(oldPkg) || artifactExists(upPkg) {
+		t.Error("pruned artifacts are still there")
+	}
+	if b, _, _ := store.Job("b"); b.Metadata["local_artifacts_pruned"] != true {
+		t.Errorf("job b metadata = %v", b.Metadata)
+	}
+}
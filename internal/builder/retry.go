@@ -0,0 +1,68 @@
+// Package builder provides retries of builds that failed transiently.
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// maxBuildRetryBackoff caps the wait between two attempts of a build.
+const maxBuildRetryBackoff = 10 * time.Minute
+
+// retryableBuildFailure returns the first of patterns found, ignoring case,
+// in a failed attempt's output or error, or "" when none is.
+func retryableBuildFailure(output string, err error, patterns []string) string {
+	text := strings.ToLower(output + "\n" + err.Error())
+	for _, p := range patterns {
+		if p != "" && strings.Contains(text, strings.ToLower(p)) {
+			return p
+		}
+	}
+	return ""
+}
+
+// buildWithRetries runs build for job, retrying it up to MaxBuildRetries times
+// when it fails with output matching a BuildRetryPatterns entry. Each retry
+// waits BuildRetryBackoff, doubled per attempt. Cancelled and timed-out builds
+// are not retried; nor are failures matching no pattern. With retries
+// enabled every attempt starts a delimited section of the job log, and
+// job.Metadata["attempts"] records how many ran.
+func (lb *LocalBuilder) buildWithRetries(ctx context.Context, job *BuildJob, build func(context.Context, *BuildJob) error) error {
+	if lb.cfg == nil || lb.cfg.MaxBuildRetries <= 0 {
+		return build(ctx, job)
+	}
+	attempts := lb.cfg.MaxBuildRetries + 1
+	backoff := lb.cfg.BuildRetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		job.appendLog(fmt.Sprintf("\n===== Build attempt %d of %d =====\n", attempt, attempts))
+		start := len(job.logSnapshot())
+		err := build(ctx, job)
+		job.setMetadata("attempts", attempt)
+		if err == nil || attempt == attempts || ctx.Err() != nil {
+			return err
+		}
+		var timedOut *buildTimeoutError
+		if errors.As(err, &timedOut) {
+			return err
+		}
+		pattern := retryableBuildFailure(job.logSnapshot()[start:], err, lb.cfg.BuildRetryPatterns)
+		if pattern == "" {
+			return err
+		}
+
+		log.Printf("Job %s attempt %d failed transiently (%q); retrying in %s", job.ref(), attempt, pattern, backoff)
+		job.appendLog(fmt.Sprintf("\n===== Attempt %d failed with a transient error (%q); retrying in %s =====\n",
+			attempt, pattern, formatTimeout(backoff)))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff = min(backoff*2, maxBuildRetryBackoff)
+	}
+}
@@ -0,0 +1,105 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func retryBuilder(retries int) *LocalBuilder {
+	return &LocalBuilder{cfg: &config.BuilderConfig{
+		MaxBuildRetries:    retries,
+		BuildRetryPatterns: []string{"Connection timed out", "failed to fetch"},
+		BuildRetryBackoff:  time.Millisecond,
+	}}
+}
+
+// flakyBuild fails with output for the first failures attempts, then succeeds.
+func flakyBuild(failures int, output string, calls *int) func(context.Context, *BuildJob) error {
+	return func(_ context.Context, job *BuildJob) error {
+		*calls++
+		if *calls <= failures {
+			job.appendLog(output)
+			return errors.New("exit status 1")
+		}
+		job.appendLog("built\n")
+		return nil
+	}
+}
+
+func TestBuildRetriesTransientFailure(t *testing.T) {
+	job := &BuildJob{ID: "j1"}
+	calls := 0
+	err := retryBuilder(3).buildWithRetries(context.Background(), job,
+		flakyBuild(2, "wget: connection timed out\n", &calls))
+	if err != nil {
+		t.Fatalf("build failed after retries: %v", err)
+	}
+	if calls != 3 || job.Metadata["attempts"] != 3 {
+		t.Errorf("calls %d, attempts %v; want 3", calls, job.Metadata["attempts"])
+	}
+	for _, want := range []string{"===== Build attempt 1 of 4 =====", "===== Build attempt 3 of 4 =====", "retrying in"} {
+		if !strings.Contains(job.Log, want) {
+			t.Errorf("log missing %q:\n%s", want, job.Log)
+		}
+	}
+}
+
+func TestBuildRetriesGiveUp(t *testing.T) {
+	job := &BuildJob{ID: "j1"}
+	calls := 0
+	err := retryBuilder(2).buildWithRetries(context.Background(), job,
+		flakyBuild(10, "!!! failed to fetch foo.tar.gz\n", &calls))
+	if err == nil || calls != 3 || job.Metadata["attempts"] != 3 {
+		t.Errorf("err %v, calls %d, attempts %v; want a failure after 3 attempts", err, calls, job.Metadata["attempts"])
+	}
+}
+
+func TestBuildRetriesCompileErrorFailsAtOnce(t *testing.T) {
+	job := &BuildJob{ID: "j1"}
+	calls := 0
+	err := retryBuilder(3).buildWithRetries(context.Background(), job,
+		flakyBuild(10, "error: 'foo' undeclared (first use in this function)\n", &calls))
+	if err == nil || calls != 1 || job.Metadata["attempts"] != 1 {
+		t.Errorf("err %v, calls %d, attempts %v; want one failed attempt", err, calls, job.Metadata["attempts"])
+	}
+}
+
+func TestBuildRetriesDisabled(t *testing.T) {
+	job := &BuildJob{ID: "j1"}
+	calls := 0
+	err := retryBuilder(0).buildWithRetries(context.Background(), job,
+		flakyBuild(1, "connection timed out\n", &calls))
+	if err == nil || calls != 1 {
+		t.Errorf("err %v, calls %d; want one failed attempt", err, calls)
+	}
+	if strings.Contains(job.Log, "Build attempt") || job.Metadata != nil {
+		t.Errorf("retries disabled but attempts recorded: %q %v", job.Log, job.Metadata)
+	}
+}
+
+func TestBuildRetriesStopOnCancel(t *testing.T) {
+	lb := retryBuilder(3)
+	lb.cfg.BuildRetryBackoff = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &BuildJob{ID: "j1"}
+	calls := 0
+	build := flakyBuild(10, "connection timed out\n", &calls)
+
+	done := make(chan error, 1)
+	go func() { done <- lb.buildWithRetries(ctx, job, build) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil || calls != 1 {
+			t.Errorf("err %v, calls %d; want the first failure", err, calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("backoff did not end on cancellation")
+	}
+}
@@ -0,0 +1,148 @@
+// Package builder provides remote builder selection strategies.
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// builderLoad is a builder's load as reported by its status endpoint.
+type builderLoad struct {
+	addr      string
+	reachable bool
+	workers   int
+	building  int
+	ratio     float64 // current_load / capacity
+}
+
+// full reports whether every worker of the builder is busy.
+func (l builderLoad) full() bool {
+	return l.building >= l.workers
+}
+
+// schedulingStrategy returns the configured builder selection strategy.
+func (m *Manager) schedulingStrategy() string {
+	if s := m.strategy.Load(); s != nil {
+		if *s != "" {
+			return *s
+		}
+		return config.SchedulingRoundRobin
+	}
+	if m.config != nil && m.config.SchedulingStrategy != "" {
+		return m.config.SchedulingStrategy
+	}
+	return config.SchedulingRoundRobin
+}
+
+// builderOrder returns the builders to try for a job, best first, according
+// to the scheduling strategy: "first" always starts at the first builder,
+// "round-robin" rotates the starting builder per job, and "least-loaded"
+// ranks the builders with free workers by load. It fails when every builder
+// is reachable but none has a free worker.
+func (m *Manager) builderOrder(builders []string) ([]string, error) {
+	switch m.schedulingStrategy() {
+	case config.SchedulingFirst:
+		return builders, nil
+	case config.SchedulingLeastLoaded:
+		return m.leastLoadedOrder(builders)
+	default:
+		start := int(m.rrNext.Add(1)-1) % len(builders)
+		return append(builders[start:len(builders):len(builders)], builders[:start]...), nil
+	}
+}
+
+// leastLoadedOrder ranks builders by current_load / capacity, skipping those
+// that are unreachable or have no free worker. If no builder answers, the
+// configured order is used, as with "first".
+func (m *Manager) leastLoadedOrder(builders []string) ([]string, error) {
+	loads := m.probeBuilderLoads(builders)
+
+	var candidates []builderLoad
+	unreachable, busy := 0, 0
+	for _, l := range loads {
+		switch {
+		case !l.reachable:
+			unreachable++
+		case l.full():
+			busy++
+		default:
+			candidates = append(candidates, l)
+		}
+	}
+	if unreachable == len(loads) {
+		log.Printf("Warning: no remote builder answered its status probe; falling back to the first builder")
+		return builders, nil
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no remote builder can accept the job: %d at capacity, %d unreachable", busy, unreachable)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].ratio < candidates[j].ratio })
+	order := make([]string, len(candidates))
+	for i, l := range candidates {
+		order[i] = l.addr
+	}
+	return order, nil
+}
+
+// probeBuilderLoads queries every builder's status endpoint concurrently,
+// returning one entry per builder in the given order.
+func (m *Manager) probeBuilderLoads(builders []string) []builderLoad {
+	loads := make([]builderLoad, len(builders))
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	var wg sync.WaitGroup
+	for i, addr := range builders {
+		loads[i].addr = addr
+		wg.Add(1)
+		go func(l *builderLoad) {
+			defer wg.Done()
+			resp, err := m.builderGet(client, normalizeBuilderURL(l.addr)+"/api/v1/status")
+			if err != nil {
+				return
+			}
+			defer func() { _ = resp.Body.Close() }()
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+			var status struct {
+				InstanceID   string    `json:"instance_id"`
+				APIVersion   int       `json:"api_version"`
+				Workers      int       `json:"workers"`
+				Building     int       `json:"building"`
+				Capacity     int       `json:"capacity"`
+				CurrentLoad  int       `json:"current_load"`
+				TreeLastSync time.Time `json:"tree_last_sync"`
+				TreeRevision string    `json:"tree_revision"`
+				Architecture string    `json:"architecture"`
+				Emulated     []string  `json:"emulated_architectures"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+				return
+			}
+			m.recordBuilderID(l.addr, status.InstanceID)
+			m.RecordBuilderTree(l.addr, status.TreeLastSync, status.TreeRevision)
+			m.RecordBuilderAPIVersion(l.addr, status.APIVersion)
+			m.recordBuilderArch(l.addr, status.Architecture, status.Emulated...)
+
+			l.reachable = true
+			l.workers = status.Workers
+			l.building = status.Building
+			capacity := status.Capacity
+			if capacity <= 0 {
+				capacity = status.Workers
+			}
+			if capacity > 0 {
+				l.ratio = float64(status.CurrentLoad) / float64(capacity)
+			}
+		}(&loads[i])
+	}
+	wg.Wait()
+	return loads
+}
@@ -0,0 +1,93 @@
+package builder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// loadServer fakes a builder status endpoint with building of workers busy.
+func loadServer(t *testing.T, id string, workers, building int) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"instance_id": id, "workers": workers, "capacity": workers,
+			"building": building, "current_load": building,
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestBuilderOrderFirstAndRoundRobin(t *testing.T) {
+	builders := []string{"b0:9090", "b1:9090", "b2:9090"}
+
+	m := &Manager{config: &config.ServerConfig{SchedulingStrategy: config.SchedulingFirst}}
+	for i := 0; i < 2; i++ {
+		if order, err := m.builderOrder(builders); err != nil || !slices.Equal(order, builders) {
+			t.Errorf("first: got %v, %v", order, err)
+		}
+	}
+
+	m = &Manager{config: &config.ServerConfig{}}
+	var starts []string
+	for i := 0; i < 4; i++ {
+		order, err := m.builderOrder(builders)
+		if err != nil || len(order) != 3 {
+			t.Fatalf("round-robin: got %v, %v", order, err)
+		}
+		starts = append(starts, order[0])
+	}
+	if want := []string{"b0:9090", "b1:9090", "b2:9090", "b0:9090"}; !slices.Equal(starts, want) {
+		t.Errorf("round-robin starts = %v, want %v", starts, want)
+	}
+	if !slices.Equal(builders, []string{"b0:9090", "b1:9090", "b2:9090"}) {
+		t.Errorf("builderOrder modified the configured list: %v", builders)
+	}
+}
+
+func TestManagerReloadConfig(t *testing.T) {
+	m := &Manager{config: &config.ServerConfig{SchedulingStrategy: config.SchedulingFirst, RemoteBuilders: []string{"b0:9090"}}}
+	m.ReloadConfig(&config.ServerConfig{SchedulingStrategy: config.SchedulingLeastLoaded, RemoteBuilders: []string{"b1:9090", "http://b1:9090"}})
+	if got := m.schedulingStrategy(); got != config.SchedulingLeastLoaded {
+		t.Errorf("strategy = %s, want %s", got, config.SchedulingLeastLoaded)
+	}
+	if got := m.RemoteBuilders(); !slices.Equal(got, []string{"b1:9090"}) {
+		t.Errorf("remote builders = %v, want [b1:9090]", got)
+	}
+	m.ReloadConfig(&config.ServerConfig{})
+	if got := m.schedulingStrategy(); got != config.SchedulingRoundRobin {
+		t.Errorf("strategy = %s, want the default %s", got, config.SchedulingRoundRobin)
+	}
+}
+
+func TestBuilderOrderLeastLoaded(t *testing.T) {
+	busy := loadServer(t, "busy", 2, 2)
+	half := loadServer(t, "half", 4, 2)
+	idle := loadServer(t, "idle", 2, 0)
+	down := "127.0.0.1:1"
+
+	m := &Manager{config: &config.ServerConfig{SchedulingStrategy: config.SchedulingLeastLoaded}}
+	order, err := m.builderOrder([]string{busy, half, down, idle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{idle, half}; !slices.Equal(order, want) {
+		t.Errorf("order = %v, want %v (busy and unreachable skipped)", order, want)
+	}
+
+	// Every builder busy: the job cannot be placed.
+	if _, err := m.builderOrder([]string{busy, down}); err == nil || !strings.Contains(err.Error(), "at capacity") {
+		t.Errorf("all busy: got %v", err)
+	}
+
+	// No builder answers: fall back to the configured order.
+	if order, err := m.builderOrder([]string{down, "127.0.0.1:2"}); err != nil || order[0] != down {
+		t.Errorf("all unreachable: got %v, %v", order, err)
+	}
+}
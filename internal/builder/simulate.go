@@ -0,0 +1,200 @@
+// Package builder provides the simulation mode, in which builds are faked
+// without containers or emerge.
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// simulatedVersion is the version of simulated packages whose request names
+// none.
+const simulatedVersion = "1.0.0"
+
+// simulating reports whether the builder fakes its builds (SIMULATION_MODE).
+func (lb *LocalBuilder) simulating() bool {
+	return lb.cfg != nil && lb.cfg.SimulationMode
+}
+
+// simulationRoll maps a job ID to a number in [0, 1). A job's simulated
+// outcome is thus the same on every attempt, while across jobs the share
+// failing follows SIMULATION_FAILURE_RATE.
+func simulationRoll(jobID string) float64 {
+	sum := sha256.Sum256([]byte(jobID))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
+
+// simulatedCPV returns the category/package and version a simulated build of
+// req produces: the requested version, the one in a versioned atom, or
+// simulatedVersion.
+func simulatedCPV(req *LocalBuildRequest) (cp, version string) {
+	target := req.PackageName
+	if target == "" && req.ConfigBundle != nil && req.ConfigBundle.Packages != nil && len(req.ConfigBundle.Packages.Packages) > 0 {
+		target = req.ConfigBundle.Packages.Packages[0].Atom
+	}
+	cp = atomCP(target)
+	if req.Version != "" {
+		return cp, req.Version
+	}
+	bare := strings.TrimLeft(target, "<>=~!")
+	if i := strings.IndexAny(bare, ":["); i >= 0 {
+		bare = bare[:i]
+	}
+	bare = strings.TrimSuffix(bare, "*")
+	if version = strings.TrimPrefix(bare, cp+"-"); version != bare && version != "" {
+		return cp, version
+	}
+	return cp, simulatedVersion
+}
+
+// simulatedLog returns the lines of the fake emerge run building cpv, the
+// same for every build of it; failed ends it in a compile error.
+func simulatedLog(cpv string, failed bool) []string {
+	_, pf := splitCategory(cpv)
+	lines := []string{
+		"[simulation] SIMULATION_MODE is on: nothing is compiled",
+		"Calculating dependencies... done!",
+		"[ebuild  N     ] " + cpv + "::gentoo",
+		">>> Emerging (1 of 1) " + cpv + "::gentoo",
+		" * " + pf + ".tar.gz BLAKE2B SHA512 size ;-) ...  [ ok ]",
+		">>> Unpacking source...",
+		">>> Source unpacked in /var/tmp/portage/" + cpv + "/work",
+		">>> Configuring source in /var/tmp/portage/" + cpv + "/work/" + pf + " ...",
+		">>> Compiling source in /var/tmp/portage/" + cpv + "/work/" + pf + " ...",
+	}
+	if failed {
+		return append(lines,
+			"make: *** [Makefile:42: all] Error 1 (simulated)",
+			" * ERROR: "+cpv+"::gentoo failed (compile phase):",
+			" *   emake failed",
+		)
+	}
+	return append(lines,
+		">>> Source compiled.",
+		">>> Test phase [not enabled]: "+cpv,
+		">>> Install "+cpv+" into /var/tmp/portage/"+cpv+"/image",
+		">>> Completed installing "+cpv+" into /var/tmp/portage/"+cpv+"/image/",
+		">>> Done building "+cpv+" as a binary package",
+		">>> Recording "+atomCP(cpv)+" in \"world\" favorites file...",
+	)
+}
+
+// executeSimulatedBuild fakes the build of job: it writes the fake log over
+// SIMULATION_DELAY and then fails, with probability SIMULATION_FAILURE_RATE,
+// as a compile error, or collects a synthetic binary package as the build's
+// artifact.
+func (lb *LocalBuilder) executeSimulatedBuild(ctx context.Context, job *BuildJob) error {
+	cp, version := simulatedCPV(job.Request)
+	cpv := cp + "-" + version
+	failed := simulationRoll(job.ID) < lb.cfg.SimulationFailureRate
+	job.setMetadata("simulated", true)
+
+	started := time.Now()
+	lines := simulatedLog(cpv, failed)
+	step := lb.cfg.SimulationDelay / time.Duration(len(lines))
+	for _, line := range lines {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(step):
+		}
+		job.appendLog(line + "\n")
+	}
+	job.recordPhaseDuration("compile", time.Since(started))
+	if failed {
+		return fmt.Errorf("%w: simulated failure of %s", ErrCompileFailed, cpv)
+	}
+
+	jobWorkDir, err := lb.prepareJobWorkDir(job.ID)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(jobWorkDir) }()
+	outputDir := filepath.Join(jobWorkDir, "output")
+	if err := writeSimulatedPackage(outputDir, cp, version); err != nil {
+		return fmt.Errorf("failed to write the simulated package: %w", err)
+	}
+	return lb.collectAndUploadArtifact(ctx, job, outputDir)
+}
+
+// writeSimulatedPackage writes a gpkg of cp at version under pkgDir, in the
+// binpkg-multi-instance layout, whose metadata carries enough for the
+// Packages index and whose image holds a single note.
+func writeSimulatedPackage(pkgDir, cp, version string) error {
+	category, pn := splitCategory(cp)
+	pf := pn + "-" + version
+	metadataTar, err := tarFiles(map[string]string{
+		"metadata/CATEGORY":    category,
+		"metadata/PF":          pf,
+		"metadata/SLOT":        "0",
+		"metadata/BUILD_ID":    "1",
+		"metadata/DESCRIPTION": "Simulated build of " + cp,
+	})
+	if err != nil {
+		return err
+	}
+	imageTar, err := tarFiles(map[string]string{
+		"image/usr/share/doc/" + pf + "/SIMULATED": "Produced by a simulated build (SIMULATION_MODE); installs nothing of " + cp + ".\n",
+	})
+	if err != nil {
+		return err
+	}
+	gpkg, err := tarMembers([]tarMember{
+		{pf + "-1/gpkg-1", []byte("gpkg-1\n")},
+		{pf + "-1/metadata.tar", metadataTar},
+		{pf + "-1/image.tar", imageTar},
+	})
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(pkgDir, category, pn, pf+"-1.gpkg.tar")
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	return os.WriteFile(path, gpkg, 0644)
+}
+
+// tarMember is a file of a tar archive built in memory.
+type tarMember struct {
+	name string
+	data []byte
+}
+
+// tarMembers returns the tar archive of members, in order.
+func tarMembers(members []tarMember) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, m := range members {
+		hdr := &tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.data)), Typeflag: tar.TypeReg, ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(m.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tarFiles returns the tar archive of files by name, in name order.
+func tarFiles(files map[string]string) ([]byte, error) {
+	members := make([]tarMember, 0, len(files))
+	for name, content := range files {
+		members = append(members, tarMember{name, []byte(content)})
+	}
+	slices.SortFunc(members, func(a, b tarMember) int { return strings.Compare(a.name, b.name) })
+	return tarMembers(members)
+}
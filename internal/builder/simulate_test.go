@@ -0,0 +1,133 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/internal/binpkg"
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// simulationBuilder returns a simulating builder with one worker whose
+// builds fail at failureRate.
+func simulationBuilder(t *testing.T, failureRate float64) *LocalBuilder {
+	t.Helper()
+	lb := NewLocalBuilder(1, nil, &config.BuilderConfig{
+		WorkDir:               t.TempDir(),
+		ArtifactDir:           t.TempDir(),
+		SimulationMode:        true,
+		SimulationDelay:       50 * time.Millisecond,
+		SimulationFailureRate: failureRate,
+	})
+	t.Cleanup(lb.Shutdown)
+	return lb
+}
+
+// awaitFinished waits for the job to leave the queued and building states.
+func awaitFinished(t *testing.T, lb *LocalBuilder, jobID string) *BuildJob {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := lb.GetJobStatus(jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status, _ := job.snapshot(); status != "queued" && status != "building" {
+			return job.Clone()
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", jobID)
+	return nil
+}
+
+func TestSimulatedCPV(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		req         LocalBuildRequest
+		cp, version string
+	}{
+		{LocalBuildRequest{PackageName: "app-misc/jq"}, "app-misc/jq", simulatedVersion},
+		{LocalBuildRequest{PackageName: "app-misc/jq", Version: "1.7.1"}, "app-misc/jq", "1.7.1"},
+		{LocalBuildRequest{PackageName: "=dev-lang/python-3.12.4:3.12"}, "dev-lang/python", "3.12.4"},
+		{LocalBuildRequest{PackageName: "=app-misc/jq-1.7*"}, "app-misc/jq", "1.7"},
+	} {
+		if cp, version := simulatedCPV(&tt.req); cp != tt.cp || version != tt.version {
+			t.Errorf("simulatedCPV(%+v) = %s, %s; want %s, %s", tt.req, cp, version, tt.cp, tt.version)
+		}
+	}
+}
+
+func TestSimulatedBuildSucceeds(t *testing.T) {
+	lb := simulationBuilder(t, 0)
+	if status := lb.GetStatus(); status["simulation_mode"] != true {
+		t.Errorf("status lacks simulation_mode: %v", status)
+	}
+
+	jobID, err := lb.SubmitBuild(&LocalBuildRequest{PackageName: "app-misc/jq", Version: "1.7.1", Arch: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := awaitFinished(t, lb, jobID)
+	if job.Status != "success" || job.Metadata["simulated"] != true {
+		t.Fatalf("status %s, metadata %v, error %s", job.Status, job.Metadata, job.Error)
+	}
+	if want := strings.Join(simulatedLog("app-misc/jq-1.7.1", false), "\n") + "\n"; job.Log != want {
+		t.Errorf("log = %q, want the fixed fake log", job.Log)
+	}
+
+	if _, err := lb.GetArtifactPath(jobID); err != nil {
+		t.Fatal(err)
+	}
+	if rel, _ := filepath.Rel(lb.artifactDir, job.ArtifactURL); rel != "app-misc/jq/jq-1.7.1-1.gpkg.tar" {
+		t.Errorf("artifact = %s", rel)
+	}
+	meta := binpkg.ReadPackageMetadata(job.ArtifactURL)
+	if meta["CATEGORY"] != "app-misc" || meta["PF"] != "jq-1.7.1" {
+		t.Errorf("artifact metadata = %v", meta)
+	}
+	if index, err := os.ReadFile(filepath.Join(lb.artifactDir, "Packages")); err != nil || !strings.Contains(string(index), "CPV: app-misc/jq-1.7.1") {
+		t.Errorf("Packages index = %s (%v)", index, err)
+	}
+}
+
+func TestSimulatedBuildFails(t *testing.T) {
+	lb := simulationBuilder(t, 1)
+
+	jobID, err := lb.SubmitBuild(&LocalBuildRequest{PackageName: "app-misc/jq", Arch: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := awaitFinished(t, lb, jobID)
+	if job.Status != "failed" || job.Metadata["failure_category"] != FailureCompile {
+		t.Fatalf("status %s, metadata %v", job.Status, job.Metadata)
+	}
+	if !strings.Contains(job.Error, "simulated failure of app-misc/jq-1.0.0") || !strings.Contains(job.Log, "failed (compile phase)") {
+		t.Errorf("error %q, log %q", job.Error, job.Log)
+	}
+	if _, err := lb.GetArtifactPath(jobID); err == nil {
+		t.Error("a failed simulated build has an artifact")
+	}
+}
+
+func TestSimulationRoll(t *testing.T) {
+	t.Parallel()
+
+	if simulationRoll("job-1") != simulationRoll("job-1") {
+		t.Error("the roll of a job differs between calls")
+	}
+	failed := 0
+	for i := range 1000 {
+		if simulationRoll(fmt.Sprintf("job-%d", i)) < 0.25 {
+			failed++
+		}
+	}
+	if failed < 150 || failed > 350 {
+		t.Errorf("%d of 1000 jobs failed at a failure rate of 0.25", failed)
+	}
+}
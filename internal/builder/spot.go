@@ -0,0 +1,198 @@
+// Package builder provides EC2 spot interruption handling.
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// ec2MetadataURL is the EC2 instance metadata service.
+	ec2MetadataURL = "http://169.254.169.254"
+	// spotCheckInterval is how often the instance metadata is checked for an
+	// interruption notice. AWS gives two minutes' notice, most of which is
+	// left for the server to notice the failed jobs.
+	spotCheckInterval = 5 * time.Second
+	// spotMetadataTimeout bounds one request to the instance metadata.
+	spotMetadataTimeout = 2 * time.Second
+	// maxSpotReschedules is how many times the server moves a build whose
+	// spot instance was reclaimed to a fresh instance before failing it.
+	maxSpotReschedules = 2
+)
+
+// FailureSpotInterruption is the failure category of jobs failed because
+// their spot instance is being reclaimed. It is retryable: the build itself
+// did nothing wrong.
+const FailureSpotInterruption = "spot_interruption"
+
+// ErrSpotInterruption is returned by SubmitBuild once the builder's spot
+// instance has been given its interruption notice.
+var ErrSpotInterruption = errors.New("spot interruption")
+
+// spotInterruption is an EC2 spot interruption notice, as served at
+// /latest/meta-data/spot/instance-action.
+type spotInterruption struct {
+	Action string    `json:"action"` // terminate, stop or hibernate
+	Time   time.Time `json:"time"`
+}
+
+// reason is the error of the jobs the interruption fails.
+func (s *spotInterruption) reason() string {
+	return fmt.Sprintf("%s: the spot instance is to %s at %s; the build must run elsewhere",
+		ErrSpotInterruption, s.Action, s.Time.Format(time.RFC3339))
+}
+
+// fetchSpotInterruption asks the instance metadata service at base for a
+// spot interruption notice, returning nil when there is none. It uses an
+// IMDSv2 session token and falls back to IMDSv1 when none can be had.
+func fetchSpotInterruption(client *http.Client, base string) (*spotInterruption, error) {
+	token := ""
+	tokenReq, err := http.NewRequest(http.MethodPut, base+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	tokenReq.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	if resp, err := client.Do(tokenReq); err == nil {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			token = strings.TrimSpace(string(body))
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, base+"/latest/meta-data/spot/instance-action", nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("instance metadata returned status %d", resp.StatusCode)
+	}
+	var notice spotInterruption
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&notice); err != nil {
+		return nil, fmt.Errorf("invalid spot interruption notice: %w", err)
+	}
+	return &notice, nil
+}
+
+// spotInterruptionLoop checks the instance metadata at base every interval
+// until an interruption notice arrives or the builder stops.
+func (lb *LocalBuilder) spotInterruptionLoop(base string, interval time.Duration) {
+	client := &http.Client{Timeout: spotMetadataTimeout}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failing := false
+	for {
+		select {
+		case <-lb.stop:
+			return
+		case <-ticker.C:
+		}
+		notice, err := fetchSpotInterruption(client, base)
+		if err != nil {
+			// Log when the metadata service becomes unreachable, not on
+			// every check.
+			if !failing {
+				log.Printf("Spot interruption check failed: %v", err)
+			}
+			failing = true
+			continue
+		}
+		failing = false
+		if notice != nil {
+			lb.handleSpotInterruption(notice)
+			return
+		}
+	}
+}
+
+// handleSpotInterruption fails every queued and running job with the
+// notice's reason and makes SubmitBuild refuse new builds, so the server
+// rebuilds them on another builder before the instance goes away.
+func (lb *LocalBuilder) handleSpotInterruption(notice *spotInterruption) {
+	lb.spotNotice.Store(notice)
+	reason := notice.reason()
+	log.Printf("Spot interruption notice (%s at %s); failing in-flight jobs", notice.Action, notice.Time.Format(time.RFC3339))
+
+	lb.jobsMutex.RLock()
+	jobs := make([]*BuildJob, 0, len(lb.jobs))
+	for _, job := range lb.jobs {
+		jobs = append(jobs, job)
+	}
+	lb.jobsMutex.RUnlock()
+
+	for _, job := range jobs {
+		job.mu.Lock()
+		switch job.Status {
+		case "queued":
+			markSpotInterrupted(job, reason)
+			job.EndTime = time.Now()
+			job.logSubs.closeAll()
+			job.mu.Unlock()
+			if lb.jobQueue != nil {
+				lb.jobQueue.remove(job.ID)
+			}
+			lb.retireJob(job)
+		case "building":
+			// The worker fails the job once the build process has exited.
+			job.interruption = reason
+			job.interruptCategory = FailureSpotInterruption
+			cancel := job.cancel
+			job.mu.Unlock()
+			job.appendLog("[spot] " + reason + "\n")
+			if cancel != nil {
+				cancel()
+			}
+		default:
+			job.mu.Unlock()
+		}
+	}
+}
+
+// markSpotInterrupted fails job with reason as a retryable spot
+// interruption. Callers hold job.mu.
+func markSpotInterrupted(job *BuildJob, reason string) {
+	markInterrupted(job, FailureSpotInterruption, reason)
+}
+
+// markInterrupted fails job with reason as a retryable failure of category,
+// one the builder caused rather than the build. Callers hold job.mu.
+func markInterrupted(job *BuildJob, category, reason string) {
+	job.Status = "failed"
+	job.Error = reason
+	if job.Metadata == nil {
+		job.Metadata = map[string]interface{}{}
+	}
+	job.Metadata["failure_category"] = category
+	job.Metadata["retryable"] = true
+}
+
+// spotInterrupted returns the error SubmitBuild refuses new builds with once
+// an interruption notice has arrived, or nil.
+func (lb *LocalBuilder) spotInterrupted() error {
+	notice := lb.spotNotice.Load()
+	if notice == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: the builder's spot instance is to %s at %s; submit the build elsewhere",
+		ErrSpotInterruption, notice.Action, notice.Time.Format(time.RFC3339))
+}
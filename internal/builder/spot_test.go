@@ -0,0 +1,138 @@
+package builder
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/internal/iac"
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// fakeIMDS serves an IMDSv2 token and, once notice is set, a spot
+// interruption notice that requires it.
+func fakeIMDS(t *testing.T, notice *string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			_, _ = w.Write([]byte("imds-token"))
+		case r.URL.Path == "/latest/meta-data/spot/instance-action":
+			if r.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if *notice == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(*notice))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchSpotInterruption(t *testing.T) {
+	notice := ""
+	srv := fakeIMDS(t, &notice)
+
+	got, err := fetchSpotInterruption(srv.Client(), srv.URL)
+	if err != nil || got != nil {
+		t.Fatalf("without a notice: got %+v, %v; want nil, nil", got, err)
+	}
+
+	notice = `{"action": "terminate", "time": "2024-05-01T12:00:00Z"}`
+	got, err = fetchSpotInterruption(srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatalf("fetchSpotInterruption: %v", err)
+	}
+	if got == nil || got.Action != "terminate" || !got.Time.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("notice = %+v", got)
+	}
+
+	notice = "not json"
+	if _, err := fetchSpotInterruption(srv.Client(), srv.URL); err == nil {
+		t.Error("invalid notice accepted")
+	}
+}
+
+func TestHandleSpotInterruption(t *testing.T) {
+	cancelled := false
+	queued := &BuildJob{ID: "q", Status: "queued", Request: &LocalBuildRequest{PackageName: "app-misc/jq"}}
+	building := &BuildJob{ID: "b", Status: "building", cancel: func() { cancelled = true }}
+	done := &BuildJob{ID: "d", Status: "success"}
+	lb := &LocalBuilder{
+		jobs:     map[string]*BuildJob{"q": queued, "b": building, "d": done},
+		jobQueue: newJobQueue(2),
+	}
+	if err := lb.jobQueue.push(queued, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	lb.handleSpotInterruption(&spotInterruption{Action: "terminate", Time: time.Now().Add(2 * time.Minute)})
+
+	if queued.Status != "failed" || !strings.HasPrefix(queued.Error, "spot interruption") {
+		t.Errorf("queued job: status %q, error %q; want a spot interruption failure", queued.Status, queued.Error)
+	}
+	if queued.Metadata["failure_category"] != FailureSpotInterruption || queued.Metadata["retryable"] != true {
+		t.Errorf("queued job metadata = %v", queued.Metadata)
+	}
+	if n := lb.jobQueue.len(); n != 0 {
+		t.Errorf("interrupted job still queued (%d queued)", n)
+	}
+	// The worker fails a running job once its build process has exited.
+	if !cancelled || building.interruption == "" || building.Status != "building" {
+		t.Errorf("running job: cancelled %v, interruption %q, status %q", cancelled, building.interruption, building.Status)
+	}
+	if done.Status != "success" {
+		t.Errorf("finished job status = %q, want it untouched", done.Status)
+	}
+
+	if _, err := lb.SubmitBuild(&LocalBuildRequest{PackageName: "app-misc/jq"}); !errors.Is(err, ErrSpotInterruption) {
+		t.Errorf("SubmitBuild after the notice = %v, want ErrSpotInterruption", err)
+	}
+}
+
+func TestSpotInterruptionRetryable(t *testing.T) {
+	if !RetryableFailure(FailureSpotInterruption) {
+		t.Error("spot interruptions should be retryable")
+	}
+}
+
+func TestRescheduleSpotInterrupted(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0})
+	defer mgr.Shutdown()
+
+	jobID, err := mgr.SubmitBuild(&BuildRequest{PackageName: "app-misc/hello", Arch: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := &iac.Instance{ID: "aws-1"}
+	if mgr.rescheduleSpotInterrupted(jobID, instance) {
+		t.Error("rescheduled a build that did not fail on a spot interruption")
+	}
+
+	for i := 1; i <= maxSpotReschedules; i++ {
+		mgr.setFailureCategory(jobID, FailureSpotInterruption)
+		if !mgr.rescheduleSpotInterrupted(jobID, instance) {
+			t.Fatalf("interruption %d was not rescheduled", i)
+		}
+	}
+	mgr.setFailureCategory(jobID, FailureSpotInterruption)
+	if mgr.rescheduleSpotInterrupted(jobID, instance) {
+		t.Errorf("rescheduled more than %d times", maxSpotReschedules)
+	}
+	status, err := mgr.GetStatus(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.SpotReschedules != maxSpotReschedules || !status.Retryable {
+		t.Errorf("spot_reschedules = %d, retryable = %v", status.SpotReschedules, status.Retryable)
+	}
+}
@@ -0,0 +1,356 @@
+// Package builder provides SQLite-backed build job persistence.
+package builder
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// Pure-Go SQLite driver, so static (CGO_ENABLED=0) builds keep working.
+	_ "modernc.org/sqlite"
+)
+
+// jobStoreSQLite selects the SQLite job store (JOB_STORE=sqlite).
+const jobStoreSQLite = "sqlite"
+
+// sqliteJobSchema keeps one row per job. The job itself is stored as JSON in
+// data; status and start_time are copied out so lookups and listings can use
+// their indexes without decoding every row.
+const sqliteJobSchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id         TEXT PRIMARY KEY,
+	status     TEXT NOT NULL,
+	start_time INTEGER NOT NULL,
+	end_time   INTEGER NOT NULL DEFAULT 0,
+	data       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS jobs_status ON jobs(status);
+CREATE INDEX IF NOT EXISTS jobs_start_time ON jobs(start_time);
+`
+
+// JobQuery selects a page of jobs in Order (newest first by default). An
+// empty Status matches every job and a Limit of 0 returns all of them.
+type JobQuery struct {
+	Status string
+	Order  ListOrder
+	Offset int
+	Limit  int
+}
+
+// SQLiteJobStore persists build jobs as rows of a SQLite database. Unlike the
+// JSON JobStore it is written one job at a time, and finished jobs are read
+// back on demand instead of being held in memory.
+type SQLiteJobStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteJobStore opens (creating if needed) jobs.db in dataDir.
+func NewSQLiteJobStore(dataDir string) (*SQLiteJobStore, error) {
+	if dataDir == "" {
+		dataDir = "/var/lib/portage-engine"
+	}
+	if err := os.MkdirAll(dataDir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create data directory %s: %w", dataDir, err)
+	}
+
+	dsn := "file:" + filepath.Join(dataDir, "jobs.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job database: %w", err)
+	}
+	// One connection serialises writers; SQLite allows only one at a time.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteJobSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialise job database: %w", err)
+	}
+	return &SQLiteJobStore{db: db}, nil
+}
+
+// Close closes the database.
+func (s *SQLiteJobStore) Close() error {
+	return s.db.Close()
+}
+
+// Load returns the jobs that were still queued or building. Finished jobs stay
+// in the database and are read through Job and ListJobs.
+func (s *SQLiteJobStore) Load() (map[string]*BuildJob, error) {
+	rows, err := s.db.Query(`SELECT data FROM jobs WHERE status IN ('queued', 'building')`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load jobs: %w", err)
+	}
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]*BuildJob, len(jobs))
+	for _, job := range jobs {
+		out[job.ID] = job
+	}
+	return out, nil
+}
+
+// Save writes every job in jobs in a single transaction. Rows for jobs not in
+// the map are left alone.
+func (s *SQLiteJobStore) Save(jobs map[string]*BuildJob) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	for _, job := range jobs {
+		if err := upsertJob(tx, job); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit jobs: %w", err)
+	}
+	return nil
+}
+
+// SaveJob writes a single job. The full job map is not needed.
+func (s *SQLiteJobStore) SaveJob(_ string, job *BuildJob, _ map[string]*BuildJob) error {
+	return upsertJob(s.db, job)
+}
+
+// DeleteJob removes the stored job id, if any.
+func (s *SQLiteJobStore) DeleteJob(id string) error {
+	if _, err := s.db.Exec(`DELETE FROM jobs WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete job %s: %w", id, err)
+	}
+	return nil
+}
+
+// Job returns the stored job id, or false when there is none.
+func (s *SQLiteJobStore) Job(id string) (*BuildJob, bool, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM jobs WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read job %s: %w", id, err)
+	}
+	job, err := decodeJob(data)
+	if err != nil {
+		return nil, false, err
+	}
+	return job, true, nil
+}
+
+// ListJobs returns the page of jobs q selects and the number of jobs matching
+// q before paging.
+func (s *SQLiteJobStore) ListJobs(q JobQuery) ([]*BuildJob, int, error) {
+	where, args := "", []interface{}{}
+	if q.Status != "" {
+		where, args = " WHERE status = ?", append(args, q.Status)
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM jobs`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count jobs: %w", err)
+	}
+
+	query := `SELECT data FROM jobs` + where + q.Order.sqlOrderBy()
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1 // SQLite: no limit
+	}
+	query += ` LIMIT ? OFFSET ?`
+	args = append(args, limit, max(q.Offset, 0))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return jobs, total, nil
+}
+
+// StatusCounts returns how many stored jobs are in each status.
+func (s *SQLiteJobStore) StatusCounts() (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT status, COUNT(*) FROM jobs GROUP BY status`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count jobs: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("failed to count jobs: %w", err)
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+// DeleteFinishedBefore removes finished jobs that ended before cutoff, the
+// same jobs JobStore.CleanOldJobs drops, and returns how many were removed.
+func (s *SQLiteJobStore) DeleteFinishedBefore(cutoff time.Time) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM jobs WHERE status NOT IN ('queued', 'building') AND end_time > 0 AND end_time < ?`,
+		cutoff.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to clean old jobs: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// finishedJobsSQL selects the jobs no worker will touch again.
+const finishedJobsSQL = `status NOT IN ('queued', 'building')`
+
+// PruneFinished removes the finished jobs that ended before cutoff (unless
+// zero) or are not among the keep most recently ended (when keep > 0), and
+// returns them.
+func (s *SQLiteJobStore) PruneFinished(cutoff time.Time, keep int) ([]*BuildJob, error) {
+	var conds []string
+	var args []interface{}
+	if !cutoff.IsZero() {
+		conds = append(conds, `(end_time > 0 AND end_time < ?)`)
+		args = append(args, cutoff.UnixNano())
+	}
+	if keep > 0 {
+		conds = append(conds, `id NOT IN (SELECT id FROM jobs WHERE `+finishedJobsSQL+` ORDER BY end_time DESC, id LIMIT ?)`)
+		args = append(args, keep)
+	}
+	if len(conds) == 0 {
+		return nil, nil
+	}
+	where := ` WHERE ` + finishedJobsSQL + ` AND (` + strings.Join(conds, ` OR `) + `)`
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	rows, err := tx.Query(`SELECT data FROM jobs`+where, args...)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("failed to select expired jobs: %w", err)
+	}
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM jobs`+where, args...); err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("failed to prune jobs: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit pruned jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// UploadedJobsEndedBefore returns the finished jobs that ended before cutoff
+// whose artifacts were uploaded to remote storage and still have local
+// copies.
+func (s *SQLiteJobStore) UploadedJobsEndedBefore(cutoff time.Time) ([]*BuildJob, error) {
+	rows, err := s.db.Query(`SELECT data FROM jobs WHERE `+finishedJobsSQL+` AND end_time > 0 AND end_time < ?
+		AND json_extract(data, '$.metadata.uploaded') = 1
+		AND json_extract(data, '$.metadata.local_artifacts_pruned') IS NULL`, cutoff.UnixNano())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uploaded jobs: %w", err)
+	}
+	return scanJobs(rows)
+}
+
+// CachedJob returns the ID of the newest successful job stored with the
+// result cache key, or false when there is none.
+func (s *SQLiteJobStore) CachedJob(key string) (string, bool, error) {
+	var id string
+	err := s.db.QueryRow(`SELECT id FROM jobs WHERE status = 'success'
+		AND json_extract(data, '$.metadata.cache_key') = ? ORDER BY end_time DESC LIMIT 1`, key).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to look up cached job: %w", err)
+	}
+	return id, true, nil
+}
+
+// ArtifactReferences returns the IDs of the stored jobs listing the
+// artifact rel.
+func (s *SQLiteJobStore) ArtifactReferences(rel string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT jobs.id FROM jobs, json_each(jobs.data, '$.artifacts') WHERE json_each.value = ?`, rel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up artifact references: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to read job row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// sqlExecer is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// upsertJob inserts or replaces the row for job.
+func upsertJob(db sqlExecer, job *BuildJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job %s: %w", job.ID, err)
+	}
+	var endTime int64
+	if !job.EndTime.IsZero() {
+		endTime = job.EndTime.UnixNano()
+	}
+	_, err = db.Exec(`INSERT INTO jobs (id, status, start_time, end_time, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET status = excluded.status, start_time = excluded.start_time,
+			end_time = excluded.end_time, data = excluded.data`,
+		job.ID, job.Status, job.StartTime.UnixNano(), endTime, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to save job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+// scanJobs decodes the data column of every row and closes rows.
+func scanJobs(rows *sql.Rows) ([]*BuildJob, error) {
+	defer func() { _ = rows.Close() }()
+	jobs := []*BuildJob{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read job row: %w", err)
+		}
+		job, err := decodeJob(data)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// decodeJob parses a job's JSON row data.
+func decodeJob(data string) (*BuildJob, error) {
+	var job BuildJob
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return nil, fmt.Errorf("failed to parse stored job: %w", err)
+	}
+	return &job, nil
+}
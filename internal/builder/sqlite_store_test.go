@@ -0,0 +1,212 @@
+package builder
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/internal/gpg"
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// newTestSQLiteStore opens a store in a temp dir, closed with the test.
+func newTestSQLiteStore(t *testing.T) *SQLiteJobStore {
+	t.Helper()
+	store, err := NewSQLiteJobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewSQLiteJobStore() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestSQLiteJobStore_SaveJobAndLoad(t *testing.T) {
+	t.Parallel()
+	store := newTestSQLiteStore(t)
+
+	now := time.Now().Truncate(time.Second)
+	jobs := []*BuildJob{
+		{ID: "queued", Status: "queued", StartTime: now},
+		{ID: "building", Status: "building", StartTime: now},
+		{ID: "done", Status: "success", StartTime: now, EndTime: now, ArtifactURL: "/a.gpkg.tar",
+			Metadata: map[string]interface{}{"ccache_hits": 3}},
+	}
+	for _, job := range jobs {
+		if err := store.SaveJob(job.ID, job, nil); err != nil {
+			t.Fatalf("SaveJob(%s) error = %v", job.ID, err)
+		}
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(loaded) != 2 || loaded["queued"] == nil || loaded["building"] == nil {
+		t.Errorf("Load() = %v, want only the unfinished jobs", loaded)
+	}
+
+	done, ok, err := store.Job("done")
+	if err != nil || !ok {
+		t.Fatalf("Job(done) = %v, %v", ok, err)
+	}
+	if done.ArtifactURL != "/a.gpkg.tar" || !done.EndTime.Equal(now) || done.Metadata["ccache_hits"] != float64(3) {
+		t.Errorf("Job(done) = %+v", done)
+	}
+	if _, ok, err := store.Job("missing"); ok || err != nil {
+		t.Errorf("Job(missing) = %v, %v; want not found", ok, err)
+	}
+
+	// A later transition replaces the row.
+	jobs[0].Status = "failed"
+	if err := store.SaveJob("queued", jobs[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	counts, err := store.StatusCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["failed"] != 1 || counts["queued"] != 0 || counts["success"] != 1 {
+		t.Errorf("StatusCounts() = %v", counts)
+	}
+}
+
+func TestSQLiteJobStore_ListJobs(t *testing.T) {
+	t.Parallel()
+	store := newTestSQLiteStore(t)
+
+	base := time.Now()
+	all := map[string]*BuildJob{}
+	for i := 0; i < 5; i++ {
+		status := "success"
+		if i%2 == 1 {
+			status = "failed"
+		}
+		id := fmt.Sprintf("job-%d", i)
+		all[id] = &BuildJob{ID: id, Status: status, StartTime: base.Add(time.Duration(i) * time.Minute)}
+	}
+	if err := store.Save(all); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	page, total, err := store.ListJobs(JobQuery{Limit: 2, Offset: 1})
+	if err != nil {
+		t.Fatalf("ListJobs() error = %v", err)
+	}
+	if total != 5 || len(page) != 2 || page[0].ID != "job-3" || page[1].ID != "job-2" {
+		t.Errorf("page = %v (total %d), want job-3, job-2 of 5", jobIDs(page), total)
+	}
+
+	failed, total, err := store.ListJobs(JobQuery{Status: "failed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(failed) != 2 || failed[0].ID != "job-3" {
+		t.Errorf("failed = %v (total %d)", jobIDs(failed), total)
+	}
+}
+
+func TestSQLiteJobStore_DeleteFinishedBefore(t *testing.T) {
+	t.Parallel()
+	store := newTestSQLiteStore(t)
+
+	now := time.Now()
+	jobs := map[string]*BuildJob{
+		"old":     {ID: "old", Status: "success", EndTime: now.Add(-48 * time.Hour)},
+		"recent":  {ID: "recent", Status: "failed", EndTime: now.Add(-time.Hour)},
+		"running": {ID: "running", Status: "building"},
+		"no-end":  {ID: "no-end", Status: "success"},
+	}
+	if err := store.Save(jobs); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := store.DeleteFinishedBefore(now.Add(-24 * time.Hour))
+	if err != nil || removed != 1 {
+		t.Fatalf("DeleteFinishedBefore() = %d, %v; want 1", removed, err)
+	}
+	if _, ok, _ := store.Job("old"); ok {
+		t.Error("old job was kept")
+	}
+	for _, id := range []string{"recent", "running", "no-end"} {
+		if _, ok, _ := store.Job(id); !ok {
+			t.Errorf("%s was removed", id)
+		}
+	}
+}
+
+func TestLocalBuilderSQLiteJobs(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.BuilderConfig{
+		PersistenceEnabled: true,
+		JobStore:           "sqlite",
+		DataDir:            dir,
+		WorkDir:            t.TempDir(),
+		ArtifactDir:        t.TempDir(),
+	}
+	// No workers: jobs stay queued, and finished ones are stored directly.
+	lb := newLocalBuilderWithConfig(0, gpg.NewSigner(t.TempDir(), "", false), cfg)
+	defer lb.Shutdown()
+	store := lb.sqliteJobs()
+	if store == nil {
+		t.Fatal("JOB_STORE=sqlite did not select the SQLite store")
+	}
+
+	jobID, err := lb.SubmitBuild(&LocalBuildRequest{PackageName: "app-misc/jq", Version: "1.7"})
+	if err != nil {
+		t.Fatalf("SubmitBuild() error = %v", err)
+	}
+	if row, ok, _ := store.Job(jobID); !ok || row.Status != "queued" {
+		t.Errorf("queued job not written to the store: %v %+v", ok, row)
+	}
+
+	// A finished job that only lives in the database is still served.
+	finished := &BuildJob{ID: "finished", Status: "success", StartTime: time.Now().Add(-time.Hour), EndTime: time.Now()}
+	if err := store.SaveJob(finished.ID, finished, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := lb.GetJobStatus("finished")
+	if err != nil || got.Status != "success" {
+		t.Errorf("GetJobStatus(finished) = %+v, %v", got, err)
+	}
+
+	jobs, total, err := lb.ListJobsPage(JobQuery{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(jobs) != 1 || jobs[0].ID != jobID {
+		t.Errorf("ListJobsPage = %v (total %d), want the queued job of 2", jobIDs(jobs), total)
+	}
+
+	status := lb.GetStatus()
+	if status["total"] != 2 || status["completed"] != 1 || status["queued"] != 1 {
+		t.Errorf("status counts: total=%v completed=%v queued=%v", status["total"], status["completed"], status["queued"])
+	}
+}
+
+func TestListJobsPageInMemory(t *testing.T) {
+	now := time.Now()
+	lb := &LocalBuilder{jobs: map[string]*BuildJob{
+		"a": {ID: "a", Status: "success", StartTime: now.Add(-2 * time.Minute)},
+		"b": {ID: "b", Status: "failed", StartTime: now.Add(-time.Minute)},
+		"c": {ID: "c", Status: "success", StartTime: now},
+	}}
+
+	page, total, err := lb.ListJobsPage(JobQuery{Offset: 1, Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(page) != 2 || page[0].ID != "b" || page[1].ID != "a" {
+		t.Errorf("page = %v (total %d)", jobIDs(page), total)
+	}
+	page, total, _ = lb.ListJobsPage(JobQuery{Status: "success", Offset: 10})
+	if total != 2 || len(page) != 0 {
+		t.Errorf("past the end: page = %v (total %d)", jobIDs(page), total)
+	}
+}
+
+func jobIDs(jobs []*BuildJob) []string {
+	ids := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+	}
+	return ids
+}
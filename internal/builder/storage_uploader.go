@@ -13,8 +13,9 @@ type StorageUploader struct {
 	enabled bool
 }
 
-// NewStorageUploader creates a new storage uploader.
-func NewStorageUploader(storageType, _ /* localDir */, s3Bucket, s3Region, s3Prefix, httpBase string) (*StorageUploader, error) {
+// NewStorageUploader creates a new storage uploader. s3Opts tunes the S3
+// backend (endpoint, multipart threshold and concurrency).
+func NewStorageUploader(storageType, _ /* localDir */, s3Bucket, s3Region, s3Prefix, httpBase string, s3Opts storage.S3Options) (*StorageUploader, error) {
 	if storageType == "" || storageType == "local" {
 		// Local storage - no upload needed
 		return &StorageUploader{
@@ -30,7 +31,7 @@ func NewStorageUploader(storageType, _ /* localDir */, s3Bucket, s3Region, s3Pre
 		if s3Bucket == "" {
 			return nil, fmt.Errorf("S3 bucket not configured")
 		}
-		st, err = storage.NewS3Storage(s3Bucket, s3Region, s3Prefix)
+		st, err = storage.NewS3Storage(s3Bucket, s3Region, s3Prefix, s3Opts)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create S3 storage: %w", err)
 		}
@@ -72,6 +73,44 @@ func (u *StorageUploader) Upload(localPath, remotePath string) error {
 	return nil
 }
 
+// BlobPath returns the remote path an artifact with the SHA-256 digest is
+// stored under, sharded like the local blob store.
+func BlobPath(digest string) string {
+	return "sha256/" + digest[:2] + "/" + digest
+}
+
+// UploadBlob uploads an artifact to the remote path of its SHA-256 digest,
+// skipping the upload when storage already holds that content. ext is the
+// extension of the compressed form localPath is, e.g. ".zst", or empty for
+// the artifact as built; the digest is always that of the artifact as
+// built. It returns the remote path and whether it uploaded.
+func (u *StorageUploader) UploadBlob(localPath, digest, ext string) (string, bool, error) {
+	if !validDigest(digest) {
+		return "", false, fmt.Errorf("invalid digest %q", digest)
+	}
+	remotePath := BlobPath(digest) + ext
+	if !u.enabled {
+		log.Printf("Storage upload disabled, keeping local file: %s", localPath)
+		return remotePath, false, nil
+	}
+	if u.storage == nil {
+		return "", false, fmt.Errorf("storage not initialized")
+	}
+
+	exists, err := u.storage.Exists(remotePath)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to check %s: %w", remotePath, err)
+	}
+	if exists {
+		log.Printf("Skipping upload of %s: %s is already stored", localPath, remotePath)
+		return remotePath, false, nil
+	}
+	if err := u.Upload(localPath, remotePath); err != nil {
+		return "", false, err
+	}
+	return remotePath, true, nil
+}
+
 // GetURL returns the URL for an artifact.
 func (u *StorageUploader) GetURL(remotePath string) (string, error) {
 	if !u.enabled || u.storage == nil {
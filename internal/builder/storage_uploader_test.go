@@ -4,9 +4,14 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/slchris/portage-engine/internal/storage"
 )
 
 func TestNewStorageUploader(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
 	tests := []struct {
 		name          string
 		storageType   string
@@ -35,12 +40,12 @@ func TestNewStorageUploader(t *testing.T) {
 			expectEnabled: false,
 		},
 		{
-			name:        "s3 with bucket",
-			storageType: "s3",
-			s3Bucket:    "test-bucket",
-			s3Region:    "us-east-1",
-			// S3 backend is not implemented: construction fails fast.
-			expectError: true,
+			name:          "s3 with bucket",
+			storageType:   "s3",
+			s3Bucket:      "test-bucket",
+			s3Region:      "us-east-1",
+			expectError:   false,
+			expectEnabled: true,
 		},
 		{
 			name:          "http without base url",
@@ -71,6 +76,7 @@ func TestNewStorageUploader(t *testing.T) {
 				tt.s3Region,
 				"",
 				tt.httpBase,
+				storage.S3Options{},
 			)
 
 			if tt.expectError {
@@ -93,7 +99,7 @@ func TestNewStorageUploader(t *testing.T) {
 }
 
 func TestStorageUploaderUploadDisabled(t *testing.T) {
-	uploader, err := NewStorageUploader("local", "/tmp/local", "", "", "", "")
+	uploader, err := NewStorageUploader("local", "/tmp/local", "", "", "", "", storage.S3Options{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -125,8 +131,9 @@ func TestStorageUploaderGetURL(t *testing.T) {
 			remotePath:  "/path/to/file.txt",
 			expectError: false,
 		},
-		// (http/s3 backends are not implemented; their constructors error, so
-		// there is no uploader to call GetURL on — covered by TestNewStorageUploader.)
+		// (the http backend is not implemented; its constructor errors, so
+		// there is no uploader to call GetURL on — covered by TestNewStorageUploader.
+		// The S3 backend's URLs are covered by the storage package.)
 	}
 
 	for _, tt := range tests {
@@ -138,6 +145,7 @@ func TestStorageUploaderGetURL(t *testing.T) {
 				"",
 				"",
 				tt.httpBase,
+				storage.S3Options{},
 			)
 			if err != nil {
 				t.Fatalf("unexpected error creating uploader: %v", err)
@@ -172,7 +180,7 @@ func TestStorageUploaderIsEnabled(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			uploader, err := NewStorageUploader(tt.storageType, "", "", "", "", "")
+			uploader, err := NewStorageUploader(tt.storageType, "", "", "", "", "", storage.S3Options{})
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -0,0 +1,153 @@
+// Package builder provides per-target build locks on a builder.
+package builder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// buildTarget is what makes two builds identical: the same package,
+// version, architecture and configuration produce the same binary packages
+// in the same place.
+type buildTarget struct {
+	PackageName   string            `json:"package_name"`
+	Version       string            `json:"version"`
+	Arch          string            `json:"arch"`
+	UseFlags      map[string]string `json:"use_flags,omitempty"`
+	Environment   map[string]string `json:"environment,omitempty"`
+	ConfigBundle  *ConfigBundle     `json:"config_bundle,omitempty"`
+	PackageSpecs  []PackageSpec     `json:"package_specs,omitempty"`
+	AcceptLicense string            `json:"accept_license,omitempty"`
+}
+
+// buildTargetKey is the hash of req's target on a builder of arch. The
+// request's timeout and API version do not change what is built and are
+// left out.
+func buildTargetKey(req *LocalBuildRequest, arch string) string {
+	target := buildTarget{
+		PackageName:   req.PackageName,
+		Version:       req.Version,
+		Arch:          arch,
+		UseFlags:      req.UseFlags,
+		Environment:   req.Environment,
+		ConfigBundle:  req.ConfigBundle,
+		PackageSpecs:  req.PackageSpecs,
+		AcceptLicense: req.AcceptLicense,
+	}
+	if req.Arch != "" {
+		target.Arch = req.Arch
+	}
+	// Maps are encoded with sorted keys, so equal targets hash equally.
+	data, _ := json.Marshal(target)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// targetLocks lets one build per target run at a time.
+type targetLocks struct {
+	mu   sync.Mutex
+	held map[string]*targetHold
+}
+
+// targetHold is a target being built by owner. done is closed when the build
+// ends, after err is set to its outcome.
+type targetHold struct {
+	owner *BuildJob
+	done  chan struct{}
+	err   error
+}
+
+// acquire takes the lock of key for job, waiting while another job holds it.
+// It returns the hold of the last job it waited for, if any, so the caller
+// can reuse that build's result. It fails if ctx ends while waiting.
+func (l *targetLocks) acquire(ctx context.Context, key string, job *BuildJob) (*targetHold, error) {
+	var waited *targetHold
+	for {
+		l.mu.Lock()
+		h := l.held[key]
+		if h == nil {
+			if l.held == nil {
+				l.held = make(map[string]*targetHold)
+			}
+			l.held[key] = &targetHold{owner: job, done: make(chan struct{})}
+			l.mu.Unlock()
+			return waited, nil
+		}
+		l.mu.Unlock()
+
+		log.Printf("Job %s waits for job %s building the same target", job.ref(), h.owner.ID)
+		job.appendLog(fmt.Sprintf("[lock] waiting for job %s, which is building the same target\n", h.owner.ID))
+		select {
+		case <-h.done:
+			waited = h
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// release ends the hold of key with the build's outcome and wakes the jobs
+// waiting for it.
+func (l *targetLocks) release(key string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if h := l.held[key]; h != nil {
+		h.err = err
+		delete(l.held, key)
+		close(h.done)
+	}
+}
+
+// reuseIdenticalBuilds reports whether a job that waited for an identical
+// build takes over that build's artifacts instead of building again.
+func (lb *LocalBuilder) reuseIdenticalBuilds() bool {
+	return lb.cfg == nil || lb.cfg.ReuseIdenticalBuilds
+}
+
+// buildExclusive runs job's build while holding the lock of its target, so
+// identical builds on this builder run one after another instead of racing
+// on the same binary package output. A job that waited for an identical
+// build which succeeded with artifacts takes them over instead, unless
+// REUSE_IDENTICAL_BUILDS is off.
+func (lb *LocalBuilder) buildExclusive(ctx context.Context, job *BuildJob, build func(context.Context, *BuildJob) error) error {
+	key := buildTargetKey(job.Request, lb.architecture)
+	prior, err := lb.targetLocks.acquire(ctx, key, job)
+	if err != nil {
+		return err
+	}
+	if prior != nil && prior.err == nil && lb.reuseIdenticalBuilds() && adoptArtifacts(job, prior.owner) {
+		lb.targetLocks.release(key, nil)
+		return nil
+	}
+
+	err = build(ctx, job)
+	lb.targetLocks.release(key, err)
+	return err
+}
+
+// adoptArtifacts gives job the artifacts of the identical build from, which
+// finished while job waited for it. It reports false when from produced
+// none.
+func adoptArtifacts(job, from *BuildJob) bool {
+	_, artifactURL := from.snapshot()
+	artifacts := from.artifactsSnapshot()
+	if artifactURL == "" && len(artifacts) == 0 {
+		return false
+	}
+
+	job.appendLog(fmt.Sprintf("[lock] identical build %s succeeded; reusing its artifacts\n", from.ID))
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.ArtifactURL = artifactURL
+	job.Artifacts = artifacts
+	if job.Metadata == nil {
+		job.Metadata = map[string]interface{}{}
+	}
+	job.Metadata["reused_from"] = from.ID
+	return true
+}
@@ -0,0 +1,148 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestBuildTargetKey(t *testing.T) {
+	req := &LocalBuildRequest{PackageName: "app-misc/jq", Version: "1.7", UseFlags: map[string]string{"oniguruma": "true", "static": "false"}}
+	same := &LocalBuildRequest{PackageName: "app-misc/jq", Version: "1.7", UseFlags: map[string]string{"static": "false", "oniguruma": "true"}, BuildTimeout: "6h"}
+	other := &LocalBuildRequest{PackageName: "app-misc/jq", Version: "1.7", UseFlags: map[string]string{"oniguruma": "false"}}
+
+	if buildTargetKey(req, "amd64") != buildTargetKey(same, "amd64") {
+		t.Error("identical targets hash differently")
+	}
+	if buildTargetKey(req, "amd64") == buildTargetKey(other, "amd64") {
+		t.Error("different USE flags hash equally")
+	}
+	if buildTargetKey(req, "amd64") == buildTargetKey(req, "arm64") {
+		t.Error("different architectures hash equally")
+	}
+}
+
+// concurrencyProbe is a build that records how many builds ran at once.
+type concurrencyProbe struct {
+	active, peak, calls atomic.Int32
+}
+
+func (p *concurrencyProbe) build(_ context.Context, job *BuildJob) error {
+	p.calls.Add(1)
+	n := p.active.Add(1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	job.setArtifacts([]string{"app-misc/jq-1.7.gpkg.tar"})
+	job.setArtifactURL("/artifacts/app-misc/jq-1.7.gpkg.tar")
+	p.active.Add(-1)
+	return nil
+}
+
+// runIdentical builds n identical jobs concurrently through buildExclusive.
+func runIdentical(t *testing.T, lb *LocalBuilder, probe *concurrencyProbe, n int) []*BuildJob {
+	t.Helper()
+	jobs := make([]*BuildJob, n)
+	var wg sync.WaitGroup
+	for i := range jobs {
+		jobs[i] = &BuildJob{ID: string(rune('a' + i)), Status: "building", Request: &LocalBuildRequest{PackageName: "app-misc/jq", Version: "1.7"}}
+		wg.Add(1)
+		go func(job *BuildJob) {
+			defer wg.Done()
+			if err := lb.buildExclusive(context.Background(), job, probe.build); err != nil {
+				t.Errorf("job %s: %v", job.ID, err)
+			}
+		}(jobs[i])
+	}
+	wg.Wait()
+	return jobs
+}
+
+func TestBuildExclusiveSerialisesIdenticalBuilds(t *testing.T) {
+	probe := &concurrencyProbe{}
+	jobs := runIdentical(t, &LocalBuilder{architecture: "amd64"}, probe, 4)
+
+	if peak := probe.peak.Load(); peak != 1 {
+		t.Errorf("%d identical builds ran at once, want 1", peak)
+	}
+	if calls := probe.calls.Load(); calls != 1 {
+		t.Errorf("built %d times, want once with the rest reusing the artifacts", calls)
+	}
+	for _, job := range jobs {
+		if _, url := job.snapshot(); url == "" || len(job.artifactsSnapshot()) != 1 {
+			t.Errorf("job %s has no artifacts", job.ID)
+		}
+	}
+}
+
+func TestBuildExclusiveWithoutReuse(t *testing.T) {
+	probe := &concurrencyProbe{}
+	lb := &LocalBuilder{architecture: "amd64", cfg: &config.BuilderConfig{ReuseIdenticalBuilds: false}}
+	runIdentical(t, lb, probe, 3)
+
+	if peak := probe.peak.Load(); peak != 1 {
+		t.Errorf("%d identical builds ran at once, want 1", peak)
+	}
+	if calls := probe.calls.Load(); calls != 3 {
+		t.Errorf("built %d times, want 3", calls)
+	}
+}
+
+func TestBuildExclusiveDifferentTargetsRunConcurrently(t *testing.T) {
+	lb := &LocalBuilder{architecture: "amd64"}
+	inside := make(chan struct{}, 2)
+	both := make(chan struct{})
+	build := func(_ context.Context, _ *BuildJob) error {
+		inside <- struct{}{}
+		select {
+		case <-both:
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("the other build never started")
+		}
+	}
+
+	errs := make(chan error, 2)
+	for _, pkg := range []string{"app-misc/jq", "app-misc/tmux"} {
+		job := &BuildJob{ID: pkg, Request: &LocalBuildRequest{PackageName: pkg}}
+		go func() { errs <- lb.buildExclusive(context.Background(), job, build) }()
+	}
+	<-inside
+	<-inside
+	close(both)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestBuildExclusiveCancelledWhileWaiting(t *testing.T) {
+	lb := &LocalBuilder{architecture: "amd64"}
+	req := &LocalBuildRequest{PackageName: "app-misc/jq"}
+	key := buildTargetKey(req, lb.architecture)
+	if _, err := lb.targetLocks.acquire(context.Background(), key, &BuildJob{ID: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	defer lb.targetLocks.release(key, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	built := false
+	err := lb.buildExclusive(ctx, &BuildJob{ID: "second", Request: req}, func(context.Context, *BuildJob) error {
+		built = true
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || built {
+		t.Errorf("waiting job: err %v, built %v; want the wait to end with the context", err, built)
+	}
+}
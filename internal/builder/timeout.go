@@ -0,0 +1,82 @@
+// Package builder provides per-build timeouts.
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultBuildTimeout bounds a build when neither the request nor the builder
+// configuration sets a timeout.
+const defaultBuildTimeout = 2 * time.Hour
+
+// maxBuildTimeout caps the timeout a request may ask for.
+const maxBuildTimeout = 72 * time.Hour
+
+// buildTimeoutError reports a build killed for running past its timeout.
+type buildTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *buildTimeoutError) Error() string {
+	return "build exceeded timeout of " + formatTimeout(e.timeout)
+}
+
+// formatTimeout renders d without zero trailing units ("6h", "1h30m", "90m"
+// as "1h30m"), matching how users write build_timeout.
+func formatTimeout(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// parseBuildTimeout parses a request's build_timeout. Empty means unset.
+func parseBuildTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 || d > maxBuildTimeout {
+		return 0, fmt.Errorf("invalid build_timeout %q: want a duration such as \"6h\", at most %s", s, formatTimeout(maxBuildTimeout))
+	}
+	return d, nil
+}
+
+// buildTimeout returns how long a request may build: its own BuildTimeout,
+// else the builder's DefaultBuildTimeout, else defaultBuildTimeout.
+func (lb *LocalBuilder) buildTimeout(req *LocalBuildRequest) time.Duration {
+	if req != nil {
+		if d, err := parseBuildTimeout(req.BuildTimeout); err == nil && d > 0 {
+			return d
+		}
+	}
+	if lb.cfg != nil && lb.cfg.DefaultBuildTimeout > 0 {
+		return lb.cfg.DefaultBuildTimeout
+	}
+	return defaultBuildTimeout
+}
+
+// buildContext returns the context bounding job's build and its timeout. It
+// derives from the job's context, so cancelling the job stops the build.
+func (lb *LocalBuilder) buildContext(ctx context.Context, job *BuildJob) (context.Context, context.CancelFunc, time.Duration) {
+	timeout := lb.buildTimeout(job.Request)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, timeout
+}
+
+// timeoutError replaces a build error caused by ctx's deadline with an
+// explicit buildTimeoutError, instead of a bare "signal: killed".
+func timeoutError(ctx context.Context, timeout time.Duration, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &buildTimeoutError{timeout: timeout}
+	}
+	return err
+}
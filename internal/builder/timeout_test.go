@@ -0,0 +1,96 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestBuildTimeoutResolution(t *testing.T) {
+	lb := &LocalBuilder{}
+	if got := lb.buildTimeout(&LocalBuildRequest{}); got != defaultBuildTimeout {
+		t.Errorf("no config: got %s, want %s", got, defaultBuildTimeout)
+	}
+
+	lb.cfg = &config.BuilderConfig{DefaultBuildTimeout: 4 * time.Hour}
+	if got := lb.buildTimeout(&LocalBuildRequest{}); got != 4*time.Hour {
+		t.Errorf("config default: got %s, want 4h", got)
+	}
+	if got := lb.buildTimeout(&LocalBuildRequest{BuildTimeout: "6h"}); got != 6*time.Hour {
+		t.Errorf("request timeout: got %s, want 6h", got)
+	}
+}
+
+func TestParseBuildTimeout(t *testing.T) {
+	if d, err := parseBuildTimeout(""); err != nil || d != 0 {
+		t.Errorf("empty: got %s, %v", d, err)
+	}
+	if d, err := parseBuildTimeout("90m"); err != nil || d != 90*time.Minute {
+		t.Errorf("90m: got %s, %v", d, err)
+	}
+	for _, bad := range []string{"6", "forever", "-1h", "0s", "100h"} {
+		if _, err := parseBuildTimeout(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestBuildTimeoutErrorMessage(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		6 * time.Hour:     "build exceeded timeout of 6h",
+		90 * time.Minute:  "build exceeded timeout of 1h30m",
+		45 * time.Minute:  "build exceeded timeout of 45m",
+		100 * time.Second: "build exceeded timeout of 1m40s",
+	} {
+		if got := (&buildTimeoutError{timeout: d}).Error(); got != want {
+			t.Errorf("%s: got %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestTimeoutErrorOnlyReplacesDeadline(t *testing.T) {
+	killed := errors.New("signal: killed")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	err := timeoutError(ctx, 6*time.Hour, killed)
+	var timedOut *buildTimeoutError
+	if !errors.As(err, &timedOut) || err.Error() != "build exceeded timeout of 6h" {
+		t.Errorf("expired deadline: got %v", err)
+	}
+	if timeoutError(ctx, 6*time.Hour, nil) != nil {
+		t.Error("a build that succeeded must not be reported as timed out")
+	}
+
+	live, cancelLive := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelLive()
+	if got := timeoutError(live, time.Hour, killed); got != killed {
+		t.Errorf("live deadline: got %v, want the original error", got)
+	}
+}
+
+func TestSubmitBuildEchoesBuildTimeout(t *testing.T) {
+	lb := NewLocalBuilder(1, nil, nil)
+
+	jobID, err := lb.SubmitBuild(&LocalBuildRequest{PackageName: "dev-lang/python", BuildTimeout: "6h"})
+	if err != nil {
+		t.Fatalf("SubmitBuild: %v", err)
+	}
+	job, err := lb.GetJobStatus(jobID)
+	if err != nil {
+		t.Fatalf("GetJobStatus: %v", err)
+	}
+	if got := job.Metadata["build_timeout"]; got != "6h" {
+		t.Errorf("build_timeout metadata = %v, want 6h", got)
+	}
+
+	_, err = lb.SubmitBuild(&LocalBuildRequest{PackageName: "dev-lang/python", BuildTimeout: "soon"})
+	if err == nil || !strings.Contains(err.Error(), "build_timeout") {
+		t.Errorf("invalid build_timeout: got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Package builder provides portage tree freshness tracking for remote builders.
+package builder
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// builderTree is the portage tree state a builder last reported.
+type builderTree struct {
+	lastSync time.Time
+	revision string
+}
+
+// RecordBuilderTree remembers the portage tree state a builder reported for
+// addr, from its heartbeat or status endpoint, and logs when the tree first
+// goes stale. A zero lastSync (unknown) is ignored.
+func (m *Manager) RecordBuilderTree(addr string, lastSync time.Time, revision string) {
+	if addr == "" || lastSync.IsZero() {
+		return
+	}
+	key := config.CanonicalBuilderURL(addr)
+	stale := m.TreeStale(lastSync)
+
+	m.builderIDsMu.Lock()
+	defer m.builderIDsMu.Unlock()
+	if m.builderTrees == nil {
+		m.builderTrees = make(map[string]builderTree)
+		m.staleTrees = make(map[string]bool)
+	}
+	m.builderTrees[key] = builderTree{lastSync: lastSync, revision: revision}
+	if stale && !m.staleTrees[key] {
+		log.Printf("WARNING: remote builder %s has a stale portage tree, last synced %s ago (TREE_STALE_AFTER %s)",
+			addr, formatTimeout(time.Since(lastSync).Truncate(time.Minute)), formatTimeout(m.treeStaleAfter()))
+	}
+	m.staleTrees[key] = stale
+}
+
+// builderTreeSync returns when the builder at addr last reported syncing its
+// tree, or zero when it has not reported one.
+func (m *Manager) builderTreeSync(addr string) time.Time {
+	m.builderIDsMu.RLock()
+	defer m.builderIDsMu.RUnlock()
+	return m.builderTrees[config.CanonicalBuilderURL(addr)].lastSync
+}
+
+// treeStaleAfter is the tree age at which a builder is flagged stale, or 0
+// when staleness is not checked.
+func (m *Manager) treeStaleAfter() time.Duration {
+	if m.config == nil {
+		return 0
+	}
+	return m.config.TreeStaleAfter
+}
+
+// treeRefuseAfter is the tree age at which a builder gets no jobs, or 0 when
+// no builder is refused.
+func (m *Manager) treeRefuseAfter() time.Duration {
+	if m.config == nil {
+		return 0
+	}
+	return m.config.TreeRefuseAfter
+}
+
+// TreeStale reports whether a tree last synced at lastSync is older than
+// TREE_STALE_AFTER. An unknown sync time is not stale.
+func (m *Manager) TreeStale(lastSync time.Time) bool {
+	limit := m.treeStaleAfter()
+	return limit > 0 && !lastSync.IsZero() && time.Since(lastSync) > limit
+}
+
+// treeFreshOrder adjusts a scheduling order for tree freshness: builders whose
+// tree is older than TREE_REFUSE_AFTER are dropped, and stale ones move after
+// the rest, keeping the strategy's order within each group. Builders that
+// never reported a sync time count as fresh. It fails when every builder was
+// dropped.
+func (m *Manager) treeFreshOrder(order []string) ([]string, error) {
+	refuseAfter := m.treeRefuseAfter()
+	fresh := make([]string, 0, len(order))
+	var stale []string
+	refused := 0
+	for _, addr := range order {
+		last := m.builderTreeSync(addr)
+		switch {
+		case refuseAfter > 0 && !last.IsZero() && time.Since(last) > refuseAfter:
+			refused++
+		case m.TreeStale(last):
+			stale = append(stale, addr)
+		default:
+			fresh = append(fresh, addr)
+		}
+	}
+	if refused > 0 && refused == len(order) {
+		return nil, fmt.Errorf("no remote builder can accept the job: all %d have a portage tree older than TREE_REFUSE_AFTER (%s)",
+			refused, formatTimeout(refuseAfter))
+	}
+	return append(fresh, stale...), nil
+}
@@ -0,0 +1,62 @@
+package builder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+func TestTreeFreshOrder(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0, TreeStaleAfter: 24 * time.Hour, TreeRefuseAfter: 7 * 24 * time.Hour})
+	defer mgr.Shutdown()
+
+	now := time.Now()
+	mgr.RecordBuilderTree("http://stale:9090", now.Add(-48*time.Hour), "")
+	mgr.RecordBuilderTree("http://fresh:9090", now.Add(-time.Hour), "abc")
+	mgr.RecordBuilderTree("http://ancient:9090", now.Add(-30*24*time.Hour), "")
+
+	// Stale builders go last, refused ones are dropped, unknown ones count as
+	// fresh, and the strategy's order is kept otherwise.
+	order, err := mgr.treeFreshOrder([]string{"stale:9090", "ancient:9090", "unknown:9090", "fresh:9090"})
+	if err != nil {
+		t.Fatalf("treeFreshOrder: %v", err)
+	}
+	want := []string{"unknown:9090", "fresh:9090", "stale:9090"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+
+	if _, err := mgr.treeFreshOrder([]string{"ancient:9090"}); err == nil || !strings.Contains(err.Error(), "TREE_REFUSE_AFTER") {
+		t.Errorf("only refused builders: err = %v", err)
+	}
+}
+
+func TestTreeFreshOrderFromHeartbeat(t *testing.T) {
+	mgr := NewManager(&config.ServerConfig{MaxWorkers: 0, TreeStaleAfter: time.Hour})
+	defer mgr.Shutdown()
+
+	err := mgr.UpdateBuilderHeartbeat(&HeartbeatRequest{
+		BuilderID:    "b1",
+		Status:       "online",
+		Endpoint:     "http://b1:9090",
+		TreeLastSync: time.Now().Add(-2 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mgr.TreeStale(mgr.builderTreeSync("b1:9090")) {
+		t.Error("heartbeat tree sync time not recorded")
+	}
+	order, _ := mgr.treeFreshOrder([]string{"b1:9090", "b2:9090"})
+	if !reflect.DeepEqual(order, []string{"b2:9090", "b1:9090"}) {
+		t.Errorf("order = %v, want the stale builder last", order)
+	}
+
+	// Without TREE_STALE_AFTER nothing is flagged.
+	if (&Manager{}).TreeStale(time.Now().Add(-1000 * time.Hour)) {
+		t.Error("TreeStale with no threshold")
+	}
+}
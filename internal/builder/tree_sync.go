@@ -0,0 +1,266 @@
+// Package builder provides on-demand and automatic portage tree syncs.
+package builder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// treeSyncTimeout bounds a single sync of the portage tree.
+const treeSyncTimeout = 30 * time.Minute
+
+// treeSyncCheckInterval is how often the background sync loop checks the
+// tree's age, so a tree synced by a build or on demand is not synced again
+// until TREE_SYNC_INTERVAL after that sync.
+const treeSyncCheckInterval = time.Minute
+
+// ErrTreeSyncRunning is returned by TrySyncTree while another sync holds the tree.
+var ErrTreeSyncRunning = errors.New("a portage tree sync is already running")
+
+// treeTimestampFile is the file a synced ::gentoo repository stamps with the
+// time its snapshot was generated, relative to PortageReposPath.
+const treeTimestampFile = "gentoo/metadata/timestamp.chk"
+
+// TreeSyncResult reports the outcome of one tree sync.
+type TreeSyncResult struct {
+	Success  bool      `json:"success"`
+	Command  string    `json:"command"`
+	Output   string    `json:"output"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration_seconds"`
+}
+
+// TrySyncTree syncs the portage tree unless a sync is already running, in
+// which case it returns ErrTreeSyncRunning instead of waiting.
+func (lb *LocalBuilder) TrySyncTree(ctx context.Context) (*TreeSyncResult, error) {
+	if !lb.treeSyncMu.TryLock() {
+		return nil, ErrTreeSyncRunning
+	}
+	defer lb.treeSyncMu.Unlock()
+	return lb.syncTreeLocked(ctx), nil
+}
+
+// syncTreeLocked runs the package manager's sync command and records the
+// sync time on success. Callers hold treeSyncMu. The sync waits for running
+// builds to finish with the tree, and builds starting meanwhile wait for it.
+func (lb *LocalBuilder) syncTreeLocked(ctx context.Context) *TreeSyncResult {
+	lb.treeMu.Lock()
+	defer lb.treeMu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, treeSyncTimeout)
+	defer cancel()
+
+	argv := lb.pkgMgr.UpdateCommand()
+	result := &TreeSyncResult{Command: strings.Join(argv, " "), Started: time.Now()}
+	run := lb.treeSyncRunner
+	if run == nil {
+		run = lb.runTreeSync
+	}
+	out, err := run(ctx, argv)
+	result.Duration = time.Since(result.Started).Seconds()
+	result.Output = string(out)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("sync exceeded timeout of %s", formatTimeout(treeSyncTimeout))
+		}
+		result.Error = err.Error()
+		log.Printf("Portage tree sync failed: %v", err)
+		return result
+	}
+
+	result.Success = true
+	lb.treeSyncedAt.Store(time.Now().UnixNano())
+	log.Printf("Portage tree synced in %.0fs", result.Duration)
+	return result
+}
+
+// runTreeSync executes argv against the tree the builds use: inside a
+// throwaway container with the repos mounted read-write in Docker mode (the
+// build containers only get a read-only view), directly on the host otherwise.
+func (lb *LocalBuilder) runTreeSync(ctx context.Context, argv []string) ([]byte, error) {
+	if lb.useDocker {
+		args := []string{"--rm", "-v", lb.reposPath() + ":/var/db/repos"}
+		if lb.cfg != nil {
+			args = lb.addEnvironmentVars(args, nil)
+		}
+		args = append(args, lb.dockerImage)
+		return lb.containerRuntime.Run(ctx, append(args, argv...))
+	}
+	return exec.CommandContext(ctx, argv[0], argv[1:]...).CombinedOutput()
+}
+
+// reposPath is the host directory holding the portage repositories.
+func (lb *LocalBuilder) reposPath() string {
+	if lb.cfg != nil && lb.cfg.PortageReposPath != "" {
+		return lb.cfg.PortageReposPath
+	}
+	return "/var/db/repos"
+}
+
+// TreeLastSync returns when the portage tree was last synced: the last
+// successful sync by this builder, or else the time stamped into the tree
+// (falling back to that file's mtime). It is zero when neither is known.
+func (lb *LocalBuilder) TreeLastSync() time.Time {
+	if ns := lb.treeSyncedAt.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	path := filepath.Join(lb.reposPath(), treeTimestampFile)
+	if data, err := os.ReadFile(path); err == nil {
+		if t, err := time.Parse(time.RFC1123Z, strings.TrimSpace(string(data))); err == nil {
+			return t
+		}
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// TreeRevision returns the commit the ::gentoo repository is checked out at,
+// or "" when it is not synced with git.
+func (lb *LocalBuilder) TreeRevision() string {
+	return gitHeadRevision(filepath.Join(lb.reposPath(), "gentoo", ".git"))
+}
+
+// gitHeadRevision resolves HEAD of the git directory gitDir without running
+// git: a detached HEAD holds the commit itself, otherwise the branch it names
+// is read from its ref file or from packed-refs.
+func gitHeadRevision(gitDir string) string {
+	data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+	head := strings.TrimSpace(string(data))
+	ref, ok := strings.CutPrefix(head, "ref: ")
+	if !ok {
+		return head
+	}
+	if data, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref))); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	packed, err := os.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(packed), "\n") {
+		if rev, name, ok := strings.Cut(strings.TrimSpace(line), " "); ok && name == ref {
+			return rev
+		}
+	}
+	return ""
+}
+
+// treeSyncMaxAge is the tree age that triggers a sync before a build, or 0
+// when automatic syncs are off, as they are for simulated builds.
+func (lb *LocalBuilder) treeSyncMaxAge() time.Duration {
+	if lb.cfg == nil || lb.simulating() {
+		return 0
+	}
+	return lb.cfg.TreeSyncMaxAge
+}
+
+// treeStale reports whether the tree is older than maxAge. A tree whose sync
+// time is unknown counts as stale.
+func (lb *LocalBuilder) treeStale(maxAge time.Duration) (bool, time.Time) {
+	last := lb.TreeLastSync()
+	return last.IsZero() || time.Since(last) > maxAge, last
+}
+
+// ensureFreshTree syncs the tree before job builds when it is older than
+// TREE_SYNC_MAX_AGE. Workers that find a sync in progress wait for it and then
+// re-check, so a burst of builds triggers one sync. A failed sync is logged to
+// the job and the build goes ahead on the existing tree.
+func (lb *LocalBuilder) ensureFreshTree(job *BuildJob) {
+	maxAge := lb.treeSyncMaxAge()
+	if maxAge <= 0 {
+		return
+	}
+	if stale, _ := lb.treeStale(maxAge); !stale {
+		return
+	}
+
+	lb.treeSyncMu.Lock()
+	defer lb.treeSyncMu.Unlock()
+	stale, last := lb.treeStale(maxAge)
+	if !stale {
+		return
+	}
+	if last.IsZero() {
+		job.appendLog("[sync] portage tree sync time unknown; syncing before the build\n")
+	} else {
+		job.appendLog(fmt.Sprintf("[sync] portage tree last synced %s ago (max %s); syncing before the build\n",
+			formatTimeout(time.Since(last).Truncate(time.Minute)), formatTimeout(maxAge)))
+	}
+	result := lb.syncTreeLocked(context.Background())
+	if !result.Success {
+		job.appendLog("[sync] tree sync failed, building on the existing tree: " + result.Error + "\n")
+		return
+	}
+	job.appendLog(fmt.Sprintf("[sync] portage tree synced in %.0fs\n", result.Duration))
+}
+
+// treeSyncLoop keeps the shared tree fresh in the background: the tree is
+// synced once it is older than interval, so at most once per interval,
+// however many builds run. A failed sync is retried after another interval.
+func (lb *LocalBuilder) treeSyncLoop(interval time.Duration) {
+	var lastAttempt time.Time
+	ticker := time.NewTicker(min(interval, treeSyncCheckInterval))
+	defer ticker.Stop()
+	for {
+		lastAttempt = lb.syncTreeIfDue(interval, lastAttempt)
+		select {
+		case <-lb.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncTreeIfDue syncs the tree when it is older than interval and the last
+// attempt (lastAttempt) was at least interval ago, skipping it while another
+// sync runs. It returns the time of the latest attempt.
+func (lb *LocalBuilder) syncTreeIfDue(interval time.Duration, lastAttempt time.Time) time.Time {
+	if stale, _ := lb.treeStale(interval); !stale || time.Since(lastAttempt) < interval {
+		return lastAttempt
+	}
+	if _, err := lb.TrySyncTree(context.Background()); err != nil {
+		// Another sync runs right now; its result is checked on the next tick.
+		return lastAttempt
+	}
+	return time.Now()
+}
+
+// treeSyncInterval is the background sync interval, or 0 when the tree is
+// not synced in the background or the builder only simulates builds.
+func (lb *LocalBuilder) treeSyncInterval() time.Duration {
+	if lb.cfg == nil || lb.simulating() {
+		return 0
+	}
+	return lb.cfg.TreeSyncInterval
+}
+
+// treeStatus returns the tree sync fields reported in GetStatus.
+func (lb *LocalBuilder) treeStatus() map[string]interface{} {
+	status := map[string]interface{}{}
+	if last := lb.TreeLastSync(); !last.IsZero() {
+		status["tree_last_sync"] = last.UTC().Format(time.RFC3339)
+		status["tree_age_seconds"] = int64(time.Since(last).Seconds())
+	}
+	if rev := lb.TreeRevision(); rev != "" {
+		status["tree_revision"] = rev
+	}
+	if maxAge := lb.treeSyncMaxAge(); maxAge > 0 {
+		status["tree_sync_max_age_seconds"] = int64(maxAge.Seconds())
+	}
+	if interval := lb.treeSyncInterval(); interval > 0 {
+		status["tree_sync_interval_seconds"] = int64(interval.Seconds())
+	}
+	return status
+}
@@ -0,0 +1,235 @@
+package builder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// newTreeSyncBuilder returns a builder whose tree lives in a temp dir and
+// whose sync command is replaced by run.
+func newTreeSyncBuilder(t *testing.T, maxAge time.Duration, run func(context.Context, []string) ([]byte, error)) *LocalBuilder {
+	t.Helper()
+	return &LocalBuilder{
+		cfg:            &config.BuilderConfig{PortageReposPath: t.TempDir(), TreeSyncMaxAge: maxAge},
+		pkgMgr:         &GentooPackageManager{},
+		treeSyncRunner: run,
+	}
+}
+
+func TestTreeLastSyncFromTimestamp(t *testing.T) {
+	lb := newTreeSyncBuilder(t, 0, nil)
+	if got := lb.TreeLastSync(); !got.IsZero() {
+		t.Fatalf("no tree: got %v, want zero", got)
+	}
+	if _, ok := lb.GetStatus()["tree_last_sync"]; ok {
+		t.Error("status reports tree_last_sync for an unknown sync time")
+	}
+
+	path := filepath.Join(lb.cfg.PortageReposPath, treeTimestampFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("Mon, 13 Oct 2025 00:45:01 +0000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2025, 10, 13, 0, 45, 1, 0, time.UTC)
+	if got := lb.TreeLastSync(); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := lb.GetStatus()["tree_last_sync"]; got != "2025-10-13T00:45:01Z" {
+		t.Errorf("status tree_last_sync = %v", got)
+	}
+}
+
+func TestTrySyncTree(t *testing.T) {
+	var ran []string
+	lb := newTreeSyncBuilder(t, 0, func(_ context.Context, argv []string) ([]byte, error) {
+		ran = argv
+		return []byte("Syncing repository 'gentoo'\n"), nil
+	})
+
+	result, err := lb.TrySyncTree(context.Background())
+	if err != nil {
+		t.Fatalf("TrySyncTree: %v", err)
+	}
+	if !result.Success || result.Command != "emerge --sync" || !strings.Contains(result.Output, "gentoo") {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if strings.Join(ran, " ") != "emerge --sync" {
+		t.Errorf("ran %q", ran)
+	}
+	if time.Since(lb.TreeLastSync()) > time.Minute {
+		t.Errorf("sync time not recorded: %v", lb.TreeLastSync())
+	}
+
+	lb.treeSyncMu.Lock()
+	_, err = lb.TrySyncTree(context.Background())
+	lb.treeSyncMu.Unlock()
+	if !errors.Is(err, ErrTreeSyncRunning) {
+		t.Errorf("concurrent sync: err = %v, want ErrTreeSyncRunning", err)
+	}
+}
+
+func TestTrySyncTreeFailureKeepsSyncTime(t *testing.T) {
+	lb := newTreeSyncBuilder(t, 0, func(context.Context, []string) ([]byte, error) {
+		return []byte("rsync error"), errors.New("exit status 1")
+	})
+	result, err := lb.TrySyncTree(context.Background())
+	if err != nil {
+		t.Fatalf("TrySyncTree: %v", err)
+	}
+	if result.Success || result.Error != "exit status 1" || result.Output != "rsync error" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if !lb.TreeLastSync().IsZero() {
+		t.Error("failed sync recorded a sync time")
+	}
+}
+
+func TestEnsureFreshTree(t *testing.T) {
+	syncs := 0
+	run := func(context.Context, []string) ([]byte, error) {
+		syncs++
+		return nil, nil
+	}
+
+	// Disabled: never syncs.
+	lb := newTreeSyncBuilder(t, 0, run)
+	lb.ensureFreshTree(&BuildJob{})
+	if syncs != 0 {
+		t.Fatalf("disabled auto-sync ran %d syncs", syncs)
+	}
+
+	// Unknown tree age syncs once; the now-fresh tree is left alone.
+	lb = newTreeSyncBuilder(t, time.Hour, run)
+	job := &BuildJob{}
+	lb.ensureFreshTree(job)
+	lb.ensureFreshTree(job)
+	if syncs != 1 {
+		t.Errorf("ran %d syncs, want 1", syncs)
+	}
+	if !strings.Contains(job.Log, "[sync] portage tree synced") {
+		t.Errorf("job log missing sync note: %q", job.Log)
+	}
+
+	// A stale tree syncs again.
+	lb.treeSyncedAt.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+	lb.ensureFreshTree(job)
+	if syncs != 2 {
+		t.Errorf("stale tree: ran %d syncs, want 2", syncs)
+	}
+}
+
+func TestSyncTreeIfDue(t *testing.T) {
+	syncs := 0
+	fail := false
+	lb := newTreeSyncBuilder(t, 0, func(context.Context, []string) ([]byte, error) {
+		syncs++
+		if fail {
+			return nil, errors.New("network unreachable")
+		}
+		return nil, nil
+	})
+
+	// An unknown tree age syncs; the fresh tree is then left alone.
+	last := lb.syncTreeIfDue(time.Hour, time.Time{})
+	last = lb.syncTreeIfDue(time.Hour, last)
+	if syncs != 1 {
+		t.Fatalf("ran %d syncs, want 1", syncs)
+	}
+
+	// Once the tree is older than the interval it syncs again, but a failed
+	// attempt is not retried before another interval has passed.
+	fail = true
+	lb.treeSyncedAt.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+	last = lb.syncTreeIfDue(time.Hour, time.Now().Add(-2*time.Hour))
+	lb.syncTreeIfDue(time.Hour, last)
+	if syncs != 2 {
+		t.Errorf("ran %d syncs, want 2", syncs)
+	}
+}
+
+func TestTreeSyncWaitsForBuilds(t *testing.T) {
+	synced := make(chan struct{})
+	lb := newTreeSyncBuilder(t, 0, func(context.Context, []string) ([]byte, error) {
+		close(synced)
+		return nil, nil
+	})
+
+	// A build holds the tree; the sync must not start until it lets go.
+	lb.treeMu.RLock()
+	go func() { _, _ = lb.TrySyncTree(context.Background()) }()
+	select {
+	case <-synced:
+		t.Fatal("tree synced while a build was using it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	lb.treeMu.RUnlock()
+	select {
+	case <-synced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tree not synced after the build released it")
+	}
+}
+
+func TestTreeStatusSyncInterval(t *testing.T) {
+	lb := newTreeSyncBuilder(t, 0, nil)
+	lb.cfg.TreeSyncInterval = 6 * time.Hour
+	if got := lb.treeStatus()["tree_sync_interval_seconds"]; got != int64(6*3600) {
+		t.Errorf("tree_sync_interval_seconds = %v", got)
+	}
+}
+
+func TestEnsureFreshTreeFailureContinues(t *testing.T) {
+	lb := newTreeSyncBuilder(t, time.Hour, func(context.Context, []string) ([]byte, error) {
+		return nil, errors.New("network unreachable")
+	})
+	job := &BuildJob{}
+	lb.ensureFreshTree(job)
+	if !strings.Contains(job.Log, "building on the existing tree: network unreachable") {
+		t.Errorf("job log = %q", job.Log)
+	}
+}
+
+func TestTreeRevision(t *testing.T) {
+	lb := newTreeSyncBuilder(t, 0, nil)
+	if rev := lb.TreeRevision(); rev != "" {
+		t.Fatalf("rsync tree: got revision %q", rev)
+	}
+
+	gitDir := filepath.Join(lb.cfg.PortageReposPath, "gentoo", ".git")
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(gitDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("HEAD", "ref: refs/heads/stable\n")
+	write("packed-refs", "# pack-refs with: peeled fully-peeled sorted\naaaa111 refs/heads/master\nbbbb222 refs/heads/stable\n")
+	if rev := lb.TreeRevision(); rev != "bbbb222" {
+		t.Errorf("packed ref: got %q, want bbbb222", rev)
+	}
+	write("refs/heads/stable", "cccc333\n")
+	if rev := lb.TreeRevision(); rev != "cccc333" {
+		t.Errorf("loose ref: got %q, want cccc333", rev)
+	}
+	write("HEAD", "dddd444\n")
+	if rev := lb.TreeRevision(); rev != "dddd444" {
+		t.Errorf("detached HEAD: got %q, want dddd444", rev)
+	}
+	if got := lb.GetStatus()["tree_revision"]; got != "dddd444" {
+		t.Errorf("status tree_revision = %v", got)
+	}
+}
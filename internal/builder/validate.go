@@ -2,8 +2,13 @@
 package builder
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // The build endpoint accepts a ConfigBundle from clients. Every field of a
@@ -12,10 +17,15 @@ import (
 // reject shell metacharacters ($ ` ; & | > < ( ) newline etc.) and anything
 // that could be interpreted as an emerge option (a leading dash).
 var (
-	// A Gentoo atom: category/package, optionally with a :slot suffix.
-	// Examples: dev-lang/python, dev-lang/python:3.11, sys-devel/gcc
+	// A plain Gentoo atom: category/package, optionally with a :slot suffix.
+	// Examples: dev-lang/python, dev-lang/python:3.11, sys-devel/gcc. Build
+	// targets follow the full grammar of ValidateAtom; this stricter form is
+	// for atoms pasted into a shell command.
 	atomPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9+._-]*/[a-zA-Z0-9][a-zA-Z0-9+._-]*(:[a-zA-Z0-9][a-zA-Z0-9+._/-]*)?$`)
 
+	// A package set, e.g. @world, @system, @preserved-rebuild.
+	setPattern = regexp.MustCompile(`^@[a-zA-Z0-9][a-zA-Z0-9+._-]*$`)
+
 	// A package version: digits, dots, letters, and the usual suffixes.
 	// Examples: 3.11, 13.2.0, 1.0.0_rc1, 2.38-r1
 	versionPattern = regexp.MustCompile(`^[0-9][a-zA-Z0-9._-]*$`)
@@ -31,17 +41,114 @@ var (
 
 	// An environment value: printable, no shell metacharacters or newlines.
 	envValuePattern = regexp.MustCompile(`^[a-zA-Z0-9 ,.:=@%+/_-]*$`)
+
+	// An ACCEPT_LICENSE value: license names and @groups, optionally negated,
+	// plus the "*" wildcard. Examples: "-* @FREE", "* -@EULA".
+	licensePattern = regexp.MustCompile(`^[a-zA-Z0-9 @*+._-]*$`)
+
+	// One MAKEOPTS option: a make job count or load limit, e.g. -j8, -l4.5.
+	makeOptPattern = regexp.MustCompile(`^(-[jl]|--jobs=|--load-average=)[0-9]+(\.[0-9]+)?$`)
+
+	// One emerge option running packages in parallel, e.g. --jobs=2.
+	emergeJobOptPattern = regexp.MustCompile(`^--(jobs|load-average)=[0-9]+(\.[0-9]+)?$`)
+
+	// A variable reference in a make.conf value: ${CFLAGS} or $CFLAGS.
+	makeConfVarRefPattern = regexp.MustCompile(`\$(\{[a-zA-Z_][a-zA-Z0-9_]*\}|[a-zA-Z_][a-zA-Z0-9_]*)`)
 )
 
-// validatePackageSpec rejects a package specification whose fields contain
+// knownArches are the Gentoo architectures a bundle may target.
+var knownArches = map[string]bool{
+	"alpha": true, "amd64": true, "arm": true, "arm64": true, "hppa": true,
+	"loong": true, "m68k": true, "mips": true, "ppc": true, "ppc64": true,
+	"riscv": true, "s390": true, "sparc": true, "x86": true,
+}
+
+// ErrInvalidBuildRequest is returned by SubmitBuild for a request that fails
+// validation, so HTTP handlers can answer 400 before anything is queued.
+var ErrInvalidBuildRequest = errors.New("invalid build request")
+
+// validEnvValue reports whether val is an acceptable value for the environment
+// variable key. ACCEPT_LICENSE needs "*", which no other variable may carry.
+func validEnvValue(key, val string) bool {
+	if key == "ACCEPT_LICENSE" {
+		return licensePattern.MatchString(val)
+	}
+	return envValuePattern.MatchString(val)
+}
+
+// isPackageSet reports whether a build target is a set (@world, @system, ...)
+// rather than a single package atom.
+func isPackageSet(target string) bool {
+	return strings.HasPrefix(target, "@")
+}
+
+// isVirtual reports whether a build target is a virtual package, which
+// builds no code of its own and resolves to one of several providers.
+func isVirtual(target string) bool {
+	return strings.HasPrefix(target, "virtual/")
+}
+
+// validateTarget checks a build target: a package atom or a package set. Sets
+// expand to many packages and cannot be version-pinned.
+func validateTarget(target, version string) error {
+	if isPackageSet(target) {
+		if !setPattern.MatchString(target) {
+			return fmt.Errorf("invalid package set %q", target)
+		}
+		if version != "" {
+			return fmt.Errorf("package set %s cannot be version-pinned", target)
+		}
+		return nil
+	}
+	if err := ValidateAtom(target); err != nil {
+		return err
+	}
+	if version != "" && atomHasVersion(target) {
+		return fmt.Errorf("package atom %q already carries a version; leave version empty", target)
+	}
+	if version != "" && !versionPattern.MatchString(version) {
+		return fmt.Errorf("invalid package version %q", version)
+	}
+	return nil
+}
+
+// emergeTarget returns the argument passed to emerge for a validated target:
+// sets and unpinned atoms as-is, pinned atoms as "=category/package-version",
+// keeping any :slot, ::repo and [use] suffix after the version.
+func emergeTarget(target, version string) string {
+	if version == "" || isPackageSet(target) {
+		return target
+	}
+	cp, suffix := target, ""
+	if i := strings.IndexAny(target, ":["); i >= 0 {
+		cp, suffix = target[:i], target[i:]
+	}
+	return fmt.Sprintf("=%s-%s%s", cp, version, suffix)
+}
+
+// artifactCPV derives "category/package-version" from an artifact path
+// relative to PKGDIR, for both layouts: "cat/pn/pn-ver-1.gpkg.tar"
+// (binpkg-multi-instance, trailing build id) and "cat/pn-ver.tbz2".
+func artifactCPV(rel string) string {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	name := strings.TrimSuffix(strings.TrimSuffix(parts[len(parts)-1], ".gpkg.tar"), ".tbz2")
+	if len(parts) >= 3 {
+		if i := strings.LastIndex(name, "-"); i > 0 {
+			if _, err := strconv.Atoi(name[i+1:]); err == nil {
+				name = name[:i]
+			}
+		}
+	}
+	return parts[0] + "/" + name
+}
+
+// ValidatePackageSpec rejects a package specification whose atom does not
+// follow Portage's atom grammar (see ValidateAtom) or whose fields contain
 // anything outside the strict allowlists above. It is the single choke point
 // that untrusted build requests must pass before any command is constructed.
-func validatePackageSpec(pkg PackageSpec) error {
-	if !atomPattern.MatchString(pkg.Atom) {
-		return fmt.Errorf("invalid package atom %q", pkg.Atom)
-	}
-	if pkg.Version != "" && !versionPattern.MatchString(pkg.Version) {
-		return fmt.Errorf("invalid package version %q", pkg.Version)
+func ValidatePackageSpec(pkg PackageSpec) error {
+	if err := validateTarget(pkg.Atom, pkg.Version); err != nil {
+		return err
 	}
 	for _, u := range pkg.UseFlags {
 		if !useFlagPattern.MatchString(u) {
@@ -57,11 +164,26 @@ func validatePackageSpec(pkg PackageSpec) error {
 		if !envKeyPattern.MatchString(key) {
 			return fmt.Errorf("invalid environment variable name %q", key)
 		}
-		if !envValuePattern.MatchString(val) {
+		if !validEnvValue(key, val) {
 			return fmt.Errorf("invalid value for environment variable %q", key)
 		}
 	}
-	return nil
+	if pkg.MakeOpts != "" && !allFieldsMatch(makeOptPattern, pkg.MakeOpts) {
+		return fmt.Errorf("invalid makeopts %q for %s", pkg.MakeOpts, pkg.Atom)
+	}
+	return validateOverlay(pkg)
+}
+
+// allFieldsMatch reports whether s has at least one whitespace-separated
+// field and every one matches p.
+func allFieldsMatch(p *regexp.Regexp, s string) bool {
+	fields := strings.Fields(s)
+	for _, f := range fields {
+		if !p.MatchString(f) {
+			return false
+		}
+	}
+	return len(fields) > 0
 }
 
 // validateBundleEnvironment validates the global environment map of a bundle.
@@ -70,7 +192,7 @@ func validateBundleEnvironment(env map[string]string) error {
 		if !envKeyPattern.MatchString(key) {
 			return fmt.Errorf("invalid environment variable name %q", key)
 		}
-		if !envValuePattern.MatchString(val) {
+		if !validEnvValue(key, val) {
 			return fmt.Errorf("invalid value for environment variable %q", key)
 		}
 	}
@@ -86,14 +208,14 @@ func validateLocalBuildRequest(req *LocalBuildRequest) error {
 	if req == nil {
 		return fmt.Errorf("nil build request")
 	}
-
-	// The package name must be a valid atom (this also rejects a leading dash,
-	// preventing emerge option injection on the native argv path).
-	if !atomPattern.MatchString(req.PackageName) {
-		return fmt.Errorf("invalid package name %q", req.PackageName)
+	if err := checkRequestAPIVersion(req.APIVersion); err != nil {
+		return err
 	}
-	if req.Version != "" && !versionPattern.MatchString(req.Version) {
-		return fmt.Errorf("invalid package version %q", req.Version)
+
+	// The package name must be a valid atom or set (this also rejects a
+	// leading dash, preventing emerge option injection on the native argv path).
+	if err := validateTarget(req.PackageName, req.Version); err != nil {
+		return err
 	}
 	if req.Arch != "" && !keywordPattern.MatchString(req.Arch) {
 		return fmt.Errorf("invalid arch %q", req.Arch)
@@ -108,38 +230,134 @@ func validateLocalBuildRequest(req *LocalBuildRequest) error {
 	if err := validateBundleEnvironment(req.Environment); err != nil {
 		return err
 	}
+	if !licensePattern.MatchString(req.AcceptLicense) {
+		return fmt.Errorf("invalid accept_license %q", req.AcceptLicense)
+	}
+	if _, err := parseBuildTimeout(req.BuildTimeout); err != nil {
+		return err
+	}
 
 	// If a config bundle is attached, it is validated on its own path too, but
 	// validate it here as well so a legacy caller cannot smuggle bad specs.
 	if req.ConfigBundle != nil {
-		return validateBundle(req.ConfigBundle)
+		return ValidateBundle(req.ConfigBundle)
 	}
 	for _, spec := range req.PackageSpecs {
-		if err := validatePackageSpec(spec); err != nil {
+		if err := ValidatePackageSpec(spec); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// validateBundle validates every package spec and the global environment of a
-// bundle. Callers must invoke this before executing any build.
-func validateBundle(bundle *ConfigBundle) error {
+// ValidateBundle validates an untrusted config bundle: its metadata, the
+// global environment, the make.conf settings, the repository names and every
+// package spec. Callers must invoke this before executing any build.
+func ValidateBundle(bundle *ConfigBundle) error {
 	if bundle == nil {
 		return fmt.Errorf("nil config bundle")
 	}
+	if err := validateBundleMetadata(bundle.Metadata); err != nil {
+		return err
+	}
 	if bundle.Config != nil {
 		if err := validateBundleEnvironment(bundle.Config.Environment); err != nil {
 			return err
 		}
+		if err := validateMakeConf(bundle.Config.MakeConf); err != nil {
+			return err
+		}
+		for _, repo := range bundle.Config.Repos {
+			if err := validateRepoName(repo.Name); err != nil {
+				return err
+			}
+		}
+		if err := validatePackageEnv(bundle.Config.PackageEnv, bundle.Config.EnvFiles); err != nil {
+			return err
+		}
 	}
 	if bundle.Packages == nil || len(bundle.Packages.Packages) == 0 {
 		return fmt.Errorf("config bundle contains no packages")
 	}
 	for _, pkg := range bundle.Packages.Packages {
-		if err := validatePackageSpec(pkg); err != nil {
+		if err := ValidatePackageSpec(pkg); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// validateBundleMetadata checks a bundle's metadata: the target arch is
+// required and must be a known Gentoo arch; the other fields are optional
+// but must be well-formed.
+func validateBundleMetadata(md BundleMetadata) error {
+	if md.TargetArch == "" {
+		return fmt.Errorf("config bundle metadata: target_arch is required")
+	}
+	if !knownArches[md.TargetArch] {
+		return fmt.Errorf("config bundle metadata: unknown target_arch %q", md.TargetArch)
+	}
+	if md.UserID != "" && !envValuePattern.MatchString(md.UserID) {
+		return fmt.Errorf("config bundle metadata: invalid user_id %q", md.UserID)
+	}
+	if md.Profile != "" && !envValuePattern.MatchString(md.Profile) {
+		return fmt.Errorf("config bundle metadata: invalid profile %q", md.Profile)
+	}
+	if md.CreatedAt != "" {
+		if _, err := time.Parse(time.RFC3339, md.CreatedAt); err != nil {
+			return fmt.Errorf("config bundle metadata: created_at %q is not an RFC 3339 time", md.CreatedAt)
+		}
+	}
+	return nil
+}
+
+// validatePackageEnv checks package.env entries and env files: each entry's
+// atom must be valid and every file it names must be in the bundle, and env
+// files may only assign variables, under the same value rules as make.conf,
+// since Portage sources them with bash.
+func validatePackageEnv(packageEnv, envFiles map[string]string) error {
+	for atom, names := range packageEnv {
+		if err := ValidateAtom(atom); err != nil {
+			return fmt.Errorf("invalid package.env entry: %w", err)
+		}
+		fields := strings.Fields(names)
+		if len(fields) == 0 {
+			return fmt.Errorf("package.env entry for %s names no env file", atom)
+		}
+		for _, name := range fields {
+			if _, ok := envFiles[name]; !ok {
+				return fmt.Errorf("package.env entry for %s refers to missing env file %q", atom, name)
+			}
+		}
+	}
+	for name, content := range envFiles {
+		if err := validatePortageFileName(name); err != nil {
+			return fmt.Errorf("invalid env file name: %w", err)
+		}
+		vars, err := parseEnvFile(content)
+		if err != nil {
+			return fmt.Errorf("invalid env file %s: %w", name, err)
+		}
+		if err := validateMakeConf(vars); err != nil {
+			return fmt.Errorf("invalid env file %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validateMakeConf checks make.conf settings, which renderMakeConf writes as
+// KEY="value" lines: a key must be a variable name, and a value must not be
+// able to end its quoted string or line, or run a command. Variable
+// references such as ${CFLAGS} remain allowed.
+func validateMakeConf(makeConf map[string]string) error {
+	for key, val := range makeConf {
+		if !envKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid make.conf variable name %q", key)
+		}
+		if strings.ContainsAny(val, "\"`\\\n\r\x00") ||
+			strings.Contains(makeConfVarRefPattern.ReplaceAllString(val, ""), "$") {
+			return fmt.Errorf("invalid value for make.conf variable %q", key)
+		}
+	}
+	return nil
+}
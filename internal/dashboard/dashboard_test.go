@@ -5,13 +5,30 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/slchris/portage-engine/internal/auth"
+	"github.com/slchris/portage-engine/internal/requestid"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
+// newTestDashboard creates a dashboard, failing the test if New does.
+func newTestDashboard(t *testing.T, cfg *config.DashboardConfig) *Dashboard {
+	t.Helper()
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return d
+}
+
 // TestNew tests creating a new dashboard.
 func TestNew(t *testing.T) {
 	cfg := &config.DashboardConfig{
@@ -20,7 +37,7 @@ func TestNew(t *testing.T) {
 		AllowAnonymous: true,
 	}
 
-	dashboard := New(cfg)
+	dashboard := newTestDashboard(t, cfg)
 	if dashboard == nil {
 		t.Fatal("New returned nil")
 	}
@@ -46,7 +63,7 @@ func TestRouter(t *testing.T) {
 		AllowAnonymous: true,
 	}
 
-	dashboard := New(cfg)
+	dashboard := newTestDashboard(t, cfg)
 	router := dashboard.Router()
 
 	if router == nil {
@@ -62,7 +79,7 @@ func TestHandleIndex(t *testing.T) {
 		AllowAnonymous: true,
 	}
 
-	dashboard := New(cfg)
+	dashboard := newTestDashboard(t, cfg)
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	w := httptest.NewRecorder()
@@ -83,7 +100,7 @@ func TestHandleIndexNotFound(t *testing.T) {
 		AllowAnonymous: true,
 	}
 
-	dashboard := New(cfg)
+	dashboard := newTestDashboard(t, cfg)
 
 	req := httptest.NewRequest(http.MethodGet, "/invalid-path", nil)
 	w := httptest.NewRecorder()
@@ -108,7 +125,7 @@ func TestHandleLogin(t *testing.T) {
 		TokenTTLMinutes: 60,
 	}
 
-	dashboard := New(cfg)
+	dashboard := newTestDashboard(t, cfg)
 
 	body, err := json.Marshal(map[string]string{"username": "testuser", "password": "testpass"})
 	if err != nil {
@@ -130,7 +147,7 @@ func TestHandleLogin(t *testing.T) {
 		t.Fatalf("failed to decode response: %v", err)
 	}
 	// The issued token must verify against the configured secret.
-	if err := verifyToken(cfg.JWTSecret, out["token"], time.Now()); err != nil {
+	if _, err := auth.VerifyToken(cfg.JWTSecret, out["token"], time.Now()); err != nil {
 		t.Errorf("issued token does not verify: %v", err)
 	}
 }
@@ -143,7 +160,7 @@ func TestHandleLoginRejectsBadCredentials(t *testing.T) {
 		AdminUser:     "testuser",
 		AdminPassword: "testpass",
 	}
-	dashboard := New(cfg)
+	dashboard := newTestDashboard(t, cfg)
 
 	body, _ := json.Marshal(map[string]string{"username": "testuser", "password": "wrong"})
 	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
@@ -164,7 +181,7 @@ func TestHandleLoginMethodNotAllowed(t *testing.T) {
 		AllowAnonymous: false,
 	}
 
-	dashboard := New(cfg)
+	dashboard := newTestDashboard(t, cfg)
 
 	req := httptest.NewRequest(http.MethodDelete, "/login", nil)
 	w := httptest.NewRecorder()
@@ -186,7 +203,7 @@ func TestHandleStatus(t *testing.T) {
 	}))
 	defer backend.Close()
 
-	d := New(&config.DashboardConfig{ServerURL: backend.URL, AllowAnonymous: true})
+	d := newTestDashboard(t, &config.DashboardConfig{ServerURL: backend.URL, AllowAnonymous: true})
 	w := httptest.NewRecorder()
 	d.handleStatus(w, httptest.NewRequest(http.MethodGet, "/api/status", nil))
 	if w.Result().StatusCode != http.StatusOK {
@@ -194,7 +211,7 @@ func TestHandleStatus(t *testing.T) {
 	}
 
 	// Backend down: honest 502, NOT fabricated 200 data.
-	d2 := New(&config.DashboardConfig{ServerURL: "http://127.0.0.1:1", AllowAnonymous: true})
+	d2 := newTestDashboard(t, &config.DashboardConfig{ServerURL: "http://127.0.0.1:1", AllowAnonymous: true})
 	w2 := httptest.NewRecorder()
 	d2.handleStatus(w2, httptest.NewRequest(http.MethodGet, "/api/status", nil))
 	if w2.Result().StatusCode != http.StatusBadGateway {
@@ -205,7 +222,7 @@ func TestHandleStatus(t *testing.T) {
 // TestHandleBuilds tests the builds API endpoint.
 func TestHandleBuilds(t *testing.T) {
 	// Backend down must be an honest 502, not fabricated sample builds.
-	d := New(&config.DashboardConfig{ServerURL: "http://127.0.0.1:1", AllowAnonymous: true})
+	d := newTestDashboard(t, &config.DashboardConfig{ServerURL: "http://127.0.0.1:1", AllowAnonymous: true})
 	w := httptest.NewRecorder()
 	d.handleBuilds(w, httptest.NewRequest(http.MethodGet, "/api/builds", nil))
 	if w.Result().StatusCode != http.StatusBadGateway {
@@ -229,7 +246,7 @@ func TestHandleKeyEndpointsProxyRealKey(t *testing.T) {
 	}))
 	defer backend.Close()
 
-	d := New(&config.DashboardConfig{ServerURL: backend.URL, AllowAnonymous: true})
+	d := newTestDashboard(t, &config.DashboardConfig{ServerURL: backend.URL, AllowAnonymous: true})
 
 	w := httptest.NewRecorder()
 	d.handlePublicKeyAPI(w, httptest.NewRequest(http.MethodGet, "/api/keys/public", nil))
@@ -242,7 +259,7 @@ func TestHandleKeyEndpointsProxyRealKey(t *testing.T) {
 	}
 
 	// Backend down → 502, not a fake key.
-	d2 := New(&config.DashboardConfig{ServerURL: "http://127.0.0.1:1", AllowAnonymous: true})
+	d2 := newTestDashboard(t, &config.DashboardConfig{ServerURL: "http://127.0.0.1:1", AllowAnonymous: true})
 	w2 := httptest.NewRecorder()
 	d2.handleKeyInfoAPI(w2, httptest.NewRequest(http.MethodGet, "/api/keys/info", nil))
 	if w2.Result().StatusCode != http.StatusBadGateway {
@@ -263,7 +280,7 @@ func TestHandleInstances(t *testing.T) {
 	}))
 	defer backend.Close()
 
-	d := New(&config.DashboardConfig{ServerURL: backend.URL, AllowAnonymous: true})
+	d := newTestDashboard(t, &config.DashboardConfig{ServerURL: backend.URL, AllowAnonymous: true})
 	w := httptest.NewRecorder()
 	d.handleInstances(w, httptest.NewRequest(http.MethodGet, "/api/instances", nil))
 	if w.Result().StatusCode != http.StatusOK {
@@ -273,7 +290,7 @@ func TestHandleInstances(t *testing.T) {
 		t.Errorf("expected proxied instance data, got: %s", w.Body.String())
 	}
 
-	d2 := New(&config.DashboardConfig{ServerURL: "http://127.0.0.1:1", AllowAnonymous: true})
+	d2 := newTestDashboard(t, &config.DashboardConfig{ServerURL: "http://127.0.0.1:1", AllowAnonymous: true})
 	w2 := httptest.NewRecorder()
 	d2.handleInstances(w2, httptest.NewRequest(http.MethodGet, "/api/instances", nil))
 	if w2.Result().StatusCode != http.StatusBadGateway {
@@ -291,7 +308,7 @@ func TestAuthMiddleware(t *testing.T) {
 		JWTSecret:      "test-secret-that-is-at-least-32-chars-long",
 	}
 
-	dashboard := New(cfg)
+	dashboard := newTestDashboard(t, cfg)
 	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
 	handler := dashboard.authMiddleware(ok)
 
@@ -313,7 +330,7 @@ func TestAuthMiddleware(t *testing.T) {
 	}
 
 	// A validly signed token → 200.
-	token, err := signToken(cfg.JWTSecret, "admin", time.Now(), time.Hour)
+	token, err := auth.SignToken(cfg.JWTSecret, "admin", auth.RoleViewer, time.Now(), time.Hour)
 	if err != nil {
 		t.Fatalf("failed to sign token: %v", err)
 	}
@@ -336,6 +353,176 @@ func TestAuthMiddleware(t *testing.T) {
 	}
 }
 
+// TestAuthMiddlewareAnonymousReadOnly verifies ALLOW_ANONYMOUS covers reads
+// only: state-changing requests still need a token.
+func TestAuthMiddlewareAnonymousReadOnly(t *testing.T) {
+	cfg := &config.DashboardConfig{
+		AuthEnabled:    true,
+		AllowAnonymous: true,
+		JWTSecret:      "test-secret-that-is-at-least-32-chars-long",
+	}
+	handler := newTestDashboard(t, cfg).authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/builds", nil))
+	if got := w.Result().StatusCode; got != http.StatusOK {
+		t.Errorf("anonymous read: expected 200, got %d", got)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/builds/submit", nil))
+	if got := w.Result().StatusCode; got != http.StatusUnauthorized {
+		t.Errorf("anonymous submit: expected 401, got %d", got)
+	}
+
+	token, err := auth.SignToken(cfg.JWTSecret, "alice", auth.RoleSubmitter, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/builds/submit", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Result().StatusCode; got != http.StatusOK {
+		t.Errorf("submit with a session: expected 200, got %d", got)
+	}
+}
+
+// TestRouterRoles verifies per-route roles: viewers read, submitters submit,
+// and only admins reach key and cloud management. A valid session with too
+// weak a role gets 403, not 401.
+func TestRouterRoles(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	cfg := &config.DashboardConfig{
+		ServerURL:   backend.URL,
+		AuthEnabled: true,
+		JWTSecret:   "test-secret-that-is-at-least-32-chars-long",
+	}
+	router := newTestDashboard(t, cfg).Router()
+
+	do := func(method, path, role string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		token, err := auth.SignToken(cfg.JWTSecret, role+"-user", role, time.Now(), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	tests := []struct {
+		method, path, role string
+		want               int
+	}{
+		{http.MethodGet, "/api/settings/cloud", auth.RoleViewer, http.StatusOK},
+		{http.MethodPut, "/api/settings/cloud", auth.RoleSubmitter, http.StatusForbidden},
+		{http.MethodPut, "/api/settings/cloud", auth.RoleAdmin, http.StatusOK},
+		{http.MethodPost, "/api/builds/submit", auth.RoleViewer, http.StatusForbidden},
+		{http.MethodPost, "/api/builds/submit", auth.RoleSubmitter, http.StatusOK},
+		{http.MethodPost, "/api/gpg/generate", auth.RoleSubmitter, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := do(tt.method, tt.path, tt.role); got != tt.want {
+			t.Errorf("%s %s as %s: got %d, want %d", tt.method, tt.path, tt.role, got, tt.want)
+		}
+	}
+}
+
+// TestNewFailsOnUnreadableUsersFile verifies that a USERS_FILE which cannot
+// be loaded fails startup instead of dropping its operators.
+func TestNewFailsOnUnreadableUsersFile(t *testing.T) {
+	cfg := &config.DashboardConfig{
+		ServerURL: "http://localhost:8080",
+		UsersFile: filepath.Join(t.TempDir(), "missing"),
+	}
+	if d, err := New(cfg); err == nil || d != nil {
+		t.Errorf("New() = %v, %v, want an error for a missing USERS_FILE", d, err)
+	}
+
+	malformed := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(malformed, []byte("operator-without-hash\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.UsersFile = malformed
+	if _, err := New(cfg); err == nil {
+		t.Error("New() accepted a malformed USERS_FILE")
+	}
+}
+
+// TestHandleLoginUserStore verifies logins against bcrypt hashes, both as
+// ADMIN_PASSWORD and in USERS_FILE, and that the proxy forwards the session
+// token to the server.
+func TestHandleLoginUserStore(t *testing.T) {
+	adminHash, err := bcrypt.GenerateFromPassword([]byte("adminpass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opHash, err := bcrypt.GenerateFromPassword([]byte("oppass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usersFile := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(usersFile, []byte("operator:"+string(opHash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var forwarded string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer backend.Close()
+
+	cfg := &config.DashboardConfig{
+		ServerURL:   backend.URL,
+		AuthEnabled: true,
+		JWTSecret:   "test-secret-that-is-at-least-32-chars-long",
+		AdminUser:   "admin",
+		// A bcrypt hash, as the config should carry it.
+		AdminPassword: string(adminHash),
+		UsersFile:     usersFile,
+	}
+	dashboard := newTestDashboard(t, cfg)
+
+	login := func(user, pass string) (int, string) {
+		body, _ := json.Marshal(map[string]string{"username": user, "password": pass})
+		w := httptest.NewRecorder()
+		dashboard.handleLoginRoute(w, httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body)))
+		var out map[string]string
+		_ = json.NewDecoder(w.Result().Body).Decode(&out)
+		return w.Result().StatusCode, out["token"]
+	}
+	if code, _ := login("admin", "adminpass"); code != http.StatusOK {
+		t.Errorf("admin login: %d", code)
+	}
+	if code, _ := login("admin", string(adminHash)); code != http.StatusUnauthorized {
+		t.Errorf("logging in with the hash itself: %d", code)
+	}
+	code, token := login("operator", "oppass")
+	if code != http.StatusOK {
+		t.Fatalf("users file login: %d", code)
+	}
+	claims, err := auth.VerifyToken(cfg.JWTSecret, token, time.Now())
+	if err != nil || claims.Subject != "operator" || claims.Role != auth.RoleViewer {
+		t.Fatalf("issued token: %+v, %v", claims, err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/builds/submit", strings.NewReader("{}"))
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+	w := httptest.NewRecorder()
+	dashboard.handleBuildSubmitProxy(w, req)
+	if w.Result().StatusCode != http.StatusAccepted || forwarded != "Bearer "+token {
+		t.Errorf("proxy: status %d, forwarded Authorization %q", w.Result().StatusCode, forwarded)
+	}
+}
+
 // TestHandleArtifactInfo tests the artifact info endpoint.
 func TestHandleArtifactInfo(t *testing.T) {
 	cfg := &config.DashboardConfig{
@@ -344,7 +531,7 @@ func TestHandleArtifactInfo(t *testing.T) {
 		AllowAnonymous: true,
 	}
 
-	dashboard := New(cfg)
+	dashboard := newTestDashboard(t, cfg)
 
 	// Test method not allowed
 	req := httptest.NewRequest(http.MethodPost, "/api/artifacts/info/test-job-id", nil)
@@ -377,7 +564,7 @@ func TestHandleArtifactDownload(t *testing.T) {
 		AllowAnonymous: true,
 	}
 
-	dashboard := New(cfg)
+	dashboard := newTestDashboard(t, cfg)
 
 	// Test method not allowed
 	req := httptest.NewRequest(http.MethodPost, "/api/artifacts/download/test-job-id", nil)
@@ -402,11 +589,59 @@ func TestHandleArtifactDownload(t *testing.T) {
 	}
 }
 
+// TestArtifactDownloadOutlivesAPITimeout verifies a download still streams
+// after the API timeout has passed, while API calls stay bounded by it.
+func TestArtifactDownloadOutlivesAPITimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/artifacts/download/job-1" {
+			time.Sleep(200 * time.Millisecond)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write([]byte("first-half "))
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("second-half"))
+	}))
+	defer backend.Close()
+
+	dashboard := newTestDashboard(t, &config.DashboardConfig{ServerURL: backend.URL, APITimeout: 50 * time.Millisecond})
+
+	w := httptest.NewRecorder()
+	dashboard.handleArtifactDownload(w, httptest.NewRequest(http.MethodGet, "/api/artifacts/download/job-1", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "first-half second-half" {
+		t.Errorf("download = %d %q, want the whole body", w.Code, w.Body.String())
+	}
+
+	if _, err := dashboard.serverGet(backend.URL + "/api/v1/cluster/status"); err == nil {
+		t.Error("API call outlived API_TIMEOUT")
+	}
+}
+
+func TestNewServerClients(t *testing.T) {
+	api, download := newServerClients(&config.DashboardConfig{})
+	if api.Timeout != defaultAPITimeout || download.Timeout != 0 {
+		t.Errorf("timeouts: api %s, download %s", api.Timeout, download.Timeout)
+	}
+	transport := download.Transport.(*http.Transport)
+	if transport.MaxConnsPerHost != defaultMaxServerConns || transport.ResponseHeaderTimeout != defaultAPITimeout {
+		t.Errorf("download transport: max conns %d, header timeout %s", transport.MaxConnsPerHost, transport.ResponseHeaderTimeout)
+	}
+	if api.Transport == download.Transport {
+		t.Error("API and download clients share a connection pool")
+	}
+
+	api, download = newServerClients(&config.DashboardConfig{APITimeout: 3 * time.Second, DownloadTimeout: time.Hour, MaxServerConns: 4})
+	if api.Timeout != 3*time.Second || download.Timeout != time.Hour || api.Transport.(*http.Transport).MaxConnsPerHost != 4 {
+		t.Errorf("configured clients: api %s, download %s", api.Timeout, download.Timeout)
+	}
+}
+
 // TestHandleBuildsPage verifies the /builds page renders (was a 500 due to a
 // missing "builds" template).
 func TestHandleBuildsPage(t *testing.T) {
 	cfg := &config.DashboardConfig{ServerURL: "http://localhost:8080", AuthEnabled: false, AllowAnonymous: true}
-	dashboard := New(cfg)
+	dashboard := newTestDashboard(t, cfg)
 
 	req := httptest.NewRequest(http.MethodGet, "/builds", nil)
 	w := httptest.NewRecorder()
@@ -419,3 +654,90 @@ func TestHandleBuildsPage(t *testing.T) {
 		t.Errorf("expected builds page content, got: %s", w.Body.String()[:min(200, w.Body.Len())])
 	}
 }
+
+// TestHandleBuildsRevalidates verifies the builds list is revalidated with
+// the server instead of re-transferred, and that the browser gets a 304.
+func TestHandleBuildsRevalidates(t *testing.T) {
+	body := []byte(`{"builds":[{"job_id":"job-1","status":"building"}],"total":1,"offset":0,"limit":50}` + "\n")
+	var full int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"v1"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	defer backend.Close()
+
+	d := newTestDashboard(t, &config.DashboardConfig{ServerURL: backend.URL, AllowAnonymous: true})
+	w := httptest.NewRecorder()
+	d.handleBuilds(w, httptest.NewRequest(http.MethodGet, "/api/builds", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != string(body) || etag == "" {
+		t.Fatalf("first request: %d etag %q body %q", w.Code, etag, w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/builds", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	d.handleBuilds(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("unchanged builds: got %d, want 304", w.Code)
+	}
+	if full != 1 {
+		t.Errorf("server sent the full list %d times, want once", full)
+	}
+}
+
+// TestHandleBuildsForwardsFilters verifies the builds list passes its paging
+// and filter parameters on to the server.
+func TestHandleBuildsForwardsFilters(t *testing.T) {
+	var got url.Values
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"builds":[],"total":0,"offset":50,"limit":50}`))
+	}))
+	defer backend.Close()
+
+	d := newTestDashboard(t, &config.DashboardConfig{ServerURL: backend.URL, AllowAnonymous: true})
+	w := httptest.NewRecorder()
+	d.handleBuilds(w, httptest.NewRequest(http.MethodGet, "/api/builds?offset=50&status=failed,expired&package=jq&arch=arm64&limit=999", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	want := url.Values{"limit": {"200"}, "offset": {"50"}, "status": {"failed,expired"}, "package": {"jq"}, "arch": {"arm64"}}
+	if got.Encode() != want.Encode() {
+		t.Errorf("forwarded %q, want %q", got.Encode(), want.Encode())
+	}
+}
+
+// TestProxyForwardsRequestID verifies proxied requests carry the browser's
+// X-Request-ID to the server, or a fresh one when it sent none.
+func TestProxyForwardsRequestID(t *testing.T) {
+	var got string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(requestid.Header)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	d := newTestDashboard(t, &config.DashboardConfig{ServerURL: backend.URL, AllowAnonymous: true})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/settings/cloud/test", nil)
+	req.Header.Set(requestid.Header, "req-7")
+	w := httptest.NewRecorder()
+	d.handleCloudSettingsTestProxy(w, req)
+	if got != "req-7" || w.Header().Get(requestid.Header) != "req-7" {
+		t.Errorf("forwarded %q, answered %q; want the browser's req-7", got, w.Header().Get(requestid.Header))
+	}
+
+	w = httptest.NewRecorder()
+	d.handleCloudSettingsTestProxy(w, httptest.NewRequest(http.MethodPost, "/api/settings/cloud/test", nil))
+	if got == "" || got == "req-7" || w.Header().Get(requestid.Header) != got {
+		t.Errorf("forwarded %q, answered %q; want a fresh ID on both", got, w.Header().Get(requestid.Header))
+	}
+}
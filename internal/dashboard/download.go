@@ -0,0 +1,61 @@
+// Package dashboard provides relaying of artifact downloads from the server.
+package dashboard
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// defaultDownloadIdleTimeout abandons a download when the server sends nothing
+// for this long and DOWNLOAD_IDLE_TIMEOUT is unset.
+const defaultDownloadIdleTimeout = time.Minute
+
+// downloadIdleTimeout is how long a download may go without data from the
+// server before it is abandoned.
+func (d *Dashboard) downloadIdleTimeout() time.Duration {
+	if d.config.DownloadIdleTimeout > 0 {
+		return d.config.DownloadIdleTimeout
+	}
+	return defaultDownloadIdleTimeout
+}
+
+// relayDownload copies body from the server to w, flushing after every chunk
+// so the browser sees the download progress. The response outlives the
+// dashboard's write timeout. When the server sends nothing for idle, stop is
+// called, which must cancel the request body is read from, and an error is
+// returned; so is a failed read or write.
+func relayDownload(w http.ResponseWriter, body io.Reader, idle time.Duration, stop func()) error {
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	var stalled atomic.Bool
+	watchdog := time.AfterFunc(idle, func() {
+		stalled.Store(true)
+		stop()
+	})
+	defer watchdog.Stop()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			watchdog.Reset(idle)
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			_ = rc.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if stalled.Load() {
+				return fmt.Errorf("server sent no data for %s", idle)
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package dashboard
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slchris/portage-engine/pkg/config"
+)
+
+// serveDownloads runs the dashboard's artifact download handler behind a real
+// HTTP server with a short write timeout, like cmd/dashboard does.
+func serveDownloads(t *testing.T, d *Dashboard) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(d.handleArtifactDownload))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestArtifactDownloadSlowLargeBody(t *testing.T) {
+	chunk := bytes.Repeat([]byte("x"), 256*1024)
+	const chunks = 8
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		for i := 0; i < chunks; i++ {
+			_, _ = w.Write(chunk)
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	d := newTestDashboard(t, &config.DashboardConfig{ServerURL: backend.URL, APITimeout: 50 * time.Millisecond, DownloadIdleTimeout: time.Second})
+	srv := serveDownloads(t, d)
+
+	resp, err := http.Get(srv.URL + "/api/artifacts/download/job-1")
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("download cut off after %d bytes: %v", len(body), err)
+	}
+	if len(body) != chunks*len(chunk) {
+		t.Errorf("downloaded %d bytes, want %d", len(body), chunks*len(chunk))
+	}
+}
+
+func TestArtifactDownloadStalledBodyFails(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	d := newTestDashboard(t, &config.DashboardConfig{ServerURL: backend.URL, DownloadIdleTimeout: 100 * time.Millisecond})
+	srv := serveDownloads(t, d)
+
+	resp, err := http.Get(srv.URL + "/api/artifacts/download/job-1")
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if body, err := io.ReadAll(resp.Body); err == nil {
+		t.Errorf("stalled download ended cleanly with %q, want an error", body)
+	}
+}
+
+func TestArtifactDownloadRange(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "jq-1.7.gpkg.tar", time.Unix(0, 0), strings.NewReader("0123456789"))
+	}))
+	defer backend.Close()
+
+	d := newTestDashboard(t, &config.DashboardConfig{ServerURL: backend.URL})
+	srv := serveDownloads(t, d)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/artifacts/download/job-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=2-4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "234" {
+		t.Errorf("download = %d %q, want 206 \"234\"", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes 2-4/10" {
+		t.Errorf("Content-Range = %q", got)
+	}
+
+	req.Header.Set("Range", "bytes=20-")
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	defer func() { _ = resp2.Body.Close() }()
+	if resp2.StatusCode != http.StatusRequestedRangeNotSatisfiable || resp2.Header.Get("Content-Range") != "bytes */10" {
+		t.Errorf("unsatisfiable range = %d %q", resp2.StatusCode, resp2.Header.Get("Content-Range"))
+	}
+}
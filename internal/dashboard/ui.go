@@ -337,6 +337,8 @@ pre.log-view {
 .log-filters { display: flex; gap: 6px; margin-bottom: 10px; flex-wrap: wrap; }
 .log-filters .btn { padding: 4px 12px; font: var(--callout-emphasized); }
 .log-filters .btn.active { background: var(--keyColor); color: hsla(0, 0%, 100%, .95); }
+.pager { display: flex; gap: 8px; align-items: center; justify-content: flex-end; padding: 10px 12px 0; font: var(--callout); color: var(--systemSecondary); }
+.pager[hidden] { display: none; }
 
 /* ---- settings sub-navigation ---- */
 .settings-layout { display: flex; gap: 28px; align-items: flex-start; }
@@ -412,10 +414,12 @@ var I18N = {
     'ov.total': '构建总数', 'ov.rate': '成功率',
     'ov.empty': '还没有构建任务。用 portage-client build 提交第一个吧。',
 
-    'builds.h1': '构建任务', 'builds.count': '共 %d 个任务', 'builds.empty': '还没有构建任务。',
+    'builds.h1': '构建任务', 'builds.count': '共 %d 个任务', 'builds.empty': '还没有构建任务。', 'builds.emptyFilter': '没有该状态的构建任务。',
+    'builds.multiarch': '多架构', 'builds.prev': '上一页', 'builds.next': '下一页', 'builds.page': '第 %s-%s 条,共 %d 条',
 
     'detail.h1': '构建详情', 'detail.logs': '查看日志', 'detail.error': '错误信息',
     'detail.livelog': '实时日志', 'detail.duration': '耗时',
+    'detail.typical': '典型构建耗时', 'detail.typical.hint': '次构建,成功率 ',
     'detail.delete': '删除任务', 'detail.delete.confirm': '删除这条任务记录?',
     'detail.delete.fail': '删除失败:',
     'builds.cleanup': '清理失败任务', 'builds.cleanup.confirm': '移除所有失败的任务记录?',
@@ -425,7 +429,7 @@ var I18N = {
     'filter.collect': '回收', 'filter.verify': '验证', 'filter.release': '释放',
     'detail.status': '状态', 'detail.arch': '架构', 'detail.created': '创建',
     'detail.updated': '更新', 'detail.instance': '实例', 'detail.artifact': '产物',
-    'detail.unknown': '(未知)',
+    'detail.unknown': '(未知)', 'detail.multiarch': '多架构请求',
 
     'logs.h1': '构建日志', 'logs.back': '返回详情', 'logs.none': '(暂无日志)',
     'logs.fail': '日志加载失败:', 'logs.loading': '加载中…',
@@ -434,14 +438,21 @@ var I18N = {
     'mon.builders': 'Builder', 'mon.instances': '云实例',
     'mon.noBuilders': '没有已注册的 builder。静态 builder 需配置 SERVER_URL 后自动注册;云构建的临时实例不在此列。',
     'mon.noInstances': '当前没有运行中的云实例。',
-    'mon.archLabel': '架构 ', 'mon.loadLabel': '负载 ',
+    'mon.archLabel': '架构 ', 'mon.loadLabel': '负载 ', 'mon.versionLabel': '版本 ',
+    'mon.versionSkew': '与服务器版本不兼容', 'mon.apiIncompatible': 'API 版本过旧,不分配任务', 'mon.disabled': '已停用,不分配新任务', 'mon.serverVersion': '服务器 ', 'mon.dashVersion': '控制台 ',
+    'mon.treeLabel': 'Portage 树 ', 'mon.treeAgo': '前', 'mon.treeStale': 'Portage 树已过期',
     'mon.shell': '终端',
     'set.sec.upload': '产物上传',
     'set.upload.desc': '配置后,新构建的二进制包(连同 Packages 索引与签名公钥)会推送到内网镜像站的制品接口,安装验证也会改用镜像站 URL。',
     'set.upload.url': '镜像站地址', 'set.upload.url.hint': '留空则不上传,包仅由本服务的 /binpkgs 提供',
     'set.upload.dir': '制品目录', 'set.upload.dir.hint': '文件位于 /local/<目录>/… 下,该 URL 即为内网 binhost',
     'set.upload.user': '用户名', 'set.upload.pass': '密码',
-    'detail.artifact.deps': '个依赖包',
+    'detail.artifact.deps': '个依赖包', 'detail.checksums': '校验和', 'detail.provenance': '构建溯源',
+    'detail.resolution': '依赖解析错误',
+    'detail.script': '查看构建脚本', 'detail.script.hide': '隐藏构建脚本', 'detail.script.title': '构建脚本',
+    'detail.resolution.masked': '已屏蔽', 'detail.resolution.required_use': 'REQUIRED_USE',
+    'detail.resolution.blocker': '阻塞', 'detail.resolution.slot_conflict': 'Slot 冲突',
+    'detail.resolution.unsatisfiable': '无法满足',
     'title.shell': '终端 — Portage Engine',
     'shell.back': '返回', 'shell.title': '实例终端',
     'shell.connected': '已连接', 'shell.closed': '已断开', 'shell.error': '连接错误',
@@ -479,7 +490,7 @@ var I18N = {
     'set.gpg.generate': '生成密钥并启用签名',
     'set.gpg.working': '正在生成密钥…', 'set.gpg.done': '签名已启用,密钥 ', 'set.gpg.fail': '失败:',
     'set.gpg.hint': '在服务端创建签名密钥(已配置则直接采用)并启用签名。客户端从 /api/v1/gpg/public-key 获取公钥;portage-client configure 会自动配置 verify-signature。',
-    'set.gpg.pubkey': '公钥', 'set.gpg.download': '下载公钥',
+    'set.gpg.pubkey': '公钥', 'set.gpg.download': '下载公钥', 'set.gpg.download_bin': '下载二进制公钥 (.gpg)',
     'set.conn': '连接', 'set.placement': '节点调度', 'set.resources': '资源',
     'set.pveuser': '用户名(Token 的替代方式)',
     'set.pveuser.hint': 'user@realm 格式;两者都填时优先使用 Token',
@@ -507,6 +518,9 @@ var I18N = {
     'set.gcp': 'Google Cloud',
     'set.gcp.project': '项目', 'set.gcp.region': '区域', 'set.gcp.zone': '可用区',
     'set.gcp.keyfile': '服务账号密钥文件(服务端路径)',
+    'set.gcp.image': '预构建启动镜像', 'set.aws.ami': '预构建 AMI',
+    'set.aws.spot': '使用 Spot 实例(更便宜;实例被回收时构建会重新调度)', 'set.aws.spotprice': 'Spot 最高价格(美元/小时)',
+    'set.prebaked': '预构建镜像已包含 Docker、构建容器镜像和 portage 树(跳过重复安装与同步)',
     'set.aws': 'AWS',
     'set.aws.ak': 'Access Key ID', 'set.aws.sk': 'Secret Access Key',
     'set.pve': 'Proxmox VE',
@@ -556,7 +570,7 @@ var I18N = {
 
     'st.queued': '排队中', 'st.claimed': '已认领', 'st.provisioning': '开机中',
     'st.forwarding': '分发中', 'st.deploying': '部署中', 'st.building': '构建中', 'st.verifying': '验证中', 'st.success': '成功',
-    'st.completed': '完成', 'st.failed': '失败', 'st.online': '在线',
+    'st.completed': '完成', 'st.success_no_artifact': '成功(无产物)', 'st.failed': '失败', 'st.expired': '已过期', 'st.cancelled': '已取消', 'st.online': '在线',
     'st.offline': '离线', 'st.running': '运行中', 'st.destroy_failed': '销毁失败'
   }
 };
@@ -625,7 +639,7 @@ function fmtTime(s) {
 var STATUS_COLORS = {
   queued: 'gray', claimed: 'orange', provisioning: 'orange', forwarding: 'orange',
   deploying: 'orange', verifying: 'blue',
-  building: 'blue', success: 'green', completed: 'green', failed: 'red',
+  building: 'blue', success: 'green', completed: 'green', success_no_artifact: 'orange', failed: 'red', expired: 'red', cancelled: 'gray',
   online: 'green', offline: 'red', running: 'green', destroy_failed: 'red'
 };
 function statusBadge(s) {
@@ -900,6 +914,7 @@ const buildsContent = `
     <button class="btn" id="refresh" data-i18n="common.refresh">Refresh</button>
   </div>
 </div>
+<div class="log-filters" id="status-filters"></div>
 <div class="card">
   <div class="table-scroll"><table class="list" aria-label="Builds">
     <thead><tr>
@@ -911,19 +926,76 @@ const buildsContent = `
     <tbody id="rows"></tbody>
   </table></div>
   <div id="empty"></div>
+  <div class="pager" id="pager" hidden>
+    <span id="page-info"></span>
+    <button class="btn" id="prev" data-i18n="builds.prev">Previous</button>
+    <button class="btn" id="next" data-i18n="builds.next">Next</button>
+  </div>
 </div>`
 
 const buildsJS = `
+// Status filter buttons; each covers the statuses listed for it.
+var STATUS_FILTERS = [
+  { key: 'all', en: 'All' },
+  { key: 'queued', en: 'queued', statuses: ['queued'] },
+  { key: 'building', en: 'building', statuses: ['claimed', 'provisioning', 'forwarding', 'deploying', 'building', 'verifying'] },
+  { key: 'success', en: 'success', statuses: ['success', 'completed', 'success_no_artifact'] },
+  { key: 'failed', en: 'failed', statuses: ['failed', 'expired'] },
+  { key: 'cancelled', en: 'cancelled', statuses: ['cancelled'] }
+];
+var statusFilter = 'all';
+// Builds per page; the server filters and pages, reporting the total.
+var PAGE_SIZE = 50;
+var offset = 0;
+function renderStatusFilters() {
+  var box = document.getElementById('status-filters');
+  clear(box);
+  STATUS_FILTERS.forEach(function (f) {
+    var label = f.key === 'all' ? t('filter.all', f.en) : t('st.' + f.key, f.en);
+    var b = el('button', 'btn' + (statusFilter === f.key ? ' active' : ''), label);
+    b.addEventListener('click', function () { statusFilter = f.key; offset = 0; renderStatusFilters(); load(); });
+    box.appendChild(b);
+  });
+}
+function statusQuery() {
+  var f = STATUS_FILTERS.filter(function (x) { return x.key === statusFilter; })[0];
+  return f && f.statuses ? '&status=' + encodeURIComponent(f.statuses.join(',')) : '';
+}
+function renderPager(total) {
+  var pager = document.getElementById('pager');
+  pager.hidden = total <= PAGE_SIZE;
+  document.getElementById('page-info').textContent = t('builds.page', '%s-%s of %d')
+    .replace('%s', Math.min(offset + 1, total)).replace('%s', Math.min(offset + PAGE_SIZE, total)).replace('%d', total);
+  document.getElementById('prev').disabled = offset === 0;
+  document.getElementById('next').disabled = offset + PAGE_SIZE >= total;
+}
 async function load() {
   try {
-    var builds = await api('/api/builds');
-    if (!Array.isArray(builds)) builds = builds.builds || [];
-    document.getElementById('count').textContent = t('builds.count', '%d jobs total').replace('%d', builds.length);
+    var page = await api('/api/builds?limit=' + PAGE_SIZE + '&offset=' + offset + statusQuery());
+    var builds = page.builds || [];
+    var total = page.total || 0;
+    // The list shrank under the current page (e.g. after a cleanup).
+    if (!builds.length && offset > 0 && total > 0) { offset = Math.max(0, Math.floor((total - 1) / PAGE_SIZE) * PAGE_SIZE); return load(); }
+    document.getElementById('count').textContent = t('builds.count', '%d jobs total').replace('%d', total);
+    renderPager(total);
     var tb = document.getElementById('rows');
     var emptyBox = document.getElementById('empty');
     clear(tb); clear(emptyBox);
-    if (!builds.length) { emptyBox.appendChild(el('div', 'empty', t('builds.empty', 'No builds yet.'))); return; }
+    if (!builds.length) {
+      if (statusFilter === 'all') emptyBox.appendChild(el('div', 'empty', t('builds.empty', 'No builds yet.')));
+      else emptyBox.appendChild(el('div', 'empty', t('builds.emptyFilter', 'No builds with this status.')));
+      return;
+    }
+    // Keep the per-arch jobs of a multi-arch request together, at the
+    // position of the request's first listed job.
+    var groups = {};
+    builds.forEach(function (b) { if (b.group_id) (groups[b.group_id] = groups[b.group_id] || []).push(b); });
+    var ordered = [];
     builds.forEach(function (b) {
+      if (!b.group_id) { ordered.push(b); return; }
+      if (groups[b.group_id]) { ordered = ordered.concat(groups[b.group_id]); delete groups[b.group_id]; }
+    });
+    ordered.forEach(function (b) {
       var tr = el('tr');
       var pkg = el('td');
       var a = el('a', null, b.package_name || t('detail.unknown', '(unknown)'));
@@ -931,7 +1003,13 @@ async function load() {
       pkg.appendChild(a);
       tr.appendChild(pkg);
       tr.appendChild(el('td', 'sec', b.version || '-'));
-      tr.appendChild(el('td', 'sec', b.arch || '-'));
+      var archTd = el('td', 'sec', b.arch || '-');
+      if (b.group_id) {
+        var tag = el('span', 'mono', ' · ' + t('builds.multiarch', 'multi-arch'));
+        tag.title = b.group_id;
+        archTd.appendChild(tag);
+      }
+      tr.appendChild(archTd);
       var st = el('td'); st.appendChild(statusBadge(b.status)); tr.appendChild(st);
       var idTd = el('td', 'mono sec', (b.job_id || '').slice(0, 8));
       idTd.title = b.job_id || '';
@@ -942,7 +1020,7 @@ async function load() {
     });
   } catch (e) { showError('empty', e); }
 }
-function onLangChange() { load(); }
+function onLangChange() { renderStatusFilters(); load(); }
 document.getElementById('cleanup-failed').addEventListener('click', async function () {
   if (!confirm(t('builds.cleanup.confirm', 'Remove all failed job records?'))) return;
   try {
@@ -951,6 +1029,9 @@ document.getElementById('cleanup-failed').addEventListener('click', async functi
   } catch (e) { alert(t('detail.delete.fail', 'Delete failed: ') + e.message); }
 });
 document.getElementById('refresh').addEventListener('click', load);
+document.getElementById('prev').addEventListener('click', function () { offset = Math.max(0, offset - PAGE_SIZE); load(); });
+document.getElementById('next').addEventListener('click', function () { offset += PAGE_SIZE; load(); });
+renderStatusFilters();
 load();
 setInterval(load, 15000);
 `
@@ -964,6 +1045,7 @@ const buildDetailContent = `
   <div><h1 id="title" data-i18n="detail.h1">Build Details</h1><p class="sub mono" id="jid"></p></div>
   <div class="actions">
     <a class="btn" id="logs-link" href="#" data-i18n="detail.logs">View Logs</a>
+    <button class="btn" id="script-toggle" style="display:none" data-i18n="detail.script">View Build Script</button>
     <button class="btn" id="delete-job" style="display:none" data-i18n="detail.delete">Delete Job</button>
     <button class="btn" id="refresh" data-i18n="common.refresh">Refresh</button>
   </div>
@@ -972,10 +1054,18 @@ const buildDetailContent = `
   <div class="pipeline" id="pipeline" aria-label="Build pipeline"></div>
 </div></div>
 <div class="stat-grid" id="meta"></div>
+<div class="card" id="resolution-card" style="display:none">
+  <h3 class="card-title" data-i18n="detail.resolution">Dependency Resolution Errors</h3>
+  <div class="card-pad"><ul class="resolution-list" id="resolution-list"></ul></div>
+</div>
 <div class="card" id="err-card" style="display:none">
   <h3 class="card-title" data-i18n="detail.error">Error</h3>
   <div class="card-pad"><pre class="log-view" id="err-text"></pre></div>
 </div>
+<div class="card" id="script-card" style="display:none">
+  <h3 class="card-title" data-i18n="detail.script.title">Build Script</h3>
+  <div class="card-pad"><pre class="log-view" id="script-text"></pre></div>
+</div>
 <div class="card">
   <h3 class="card-title" data-i18n="detail.livelog">Live Log</h3>
   <div class="card-pad">
@@ -996,7 +1086,7 @@ function fmtDuration(ms) {
   return (h ? h + 'h ' : '') + (h || m ? m + 'm ' : '') + sec + 's';
 }
 function durationTile(b) {
-  var terminal = b.status === 'failed' || b.status === 'completed' || b.status === 'success';
+  var terminal = b.status === 'failed' || b.status === 'expired' || b.status === 'cancelled' || b.status === 'completed' || b.status === 'success' || b.status === 'success_no_artifact';
   var end = terminal ? new Date(b.updated_at) : new Date();
   var tle = el('div', 'stat-tile');
   tle.appendChild(el('h4', null, t('detail.duration', 'Duration')));
@@ -1011,7 +1101,7 @@ setInterval(function () {
   var n = document.getElementById('duration-num');
   if (!n || !lastDetail) return;
   var b = lastDetail;
-  var terminal = b.status === 'failed' || b.status === 'completed' || b.status === 'success';
+  var terminal = b.status === 'failed' || b.status === 'expired' || b.status === 'cancelled' || b.status === 'completed' || b.status === 'success' || b.status === 'success_no_artifact';
   if (!terminal) n.textContent = fmtDuration(new Date() - new Date(b.created_at));
 }, 1000);
 function metaTile(labelKey, labelEN, node, wrap) {
@@ -1026,7 +1116,9 @@ function metaTile(labelKey, labelEN, node, wrap) {
 function basename(p) { var i = (p || '').lastIndexOf('/'); return i >= 0 ? p.slice(i + 1) : p; }
 (function () {
   var st = document.createElement('style');
-  st.textContent = '.artifact-extra{margin-top:4px;font-size:11px;opacity:.85}.artifact-extra a{color:var(--keyColor)}.artifact-extra-note{margin-top:4px;font-size:11px;color:var(--systemSecondary)}';
+  st.textContent = '.artifact-extra{margin-top:4px;font-size:11px;opacity:.85}.artifact-extra a{color:var(--keyColor)}.artifact-extra-note{margin-top:4px;font-size:11px;color:var(--systemSecondary)}' +
+    '.resolution-list{margin:0;padding-left:18px}.resolution-list li{margin:4px 0}.resolution-cat{display:inline-block;min-width:96px;font-size:11px;color:var(--systemSecondary)}.resolution-atom{font-family:monospace;margin-right:6px}' +
+    '.checksum-row{margin:2px 0;font-size:11px}.checksum-alg{display:inline-block;min-width:64px;color:var(--systemSecondary)}.checksum-sum{font-family:monospace;word-break:break-all}';
   document.head.appendChild(st);
 })();
 async function load() {
@@ -1037,10 +1129,12 @@ async function load() {
     clear(g);
     g.appendChild(metaTile('detail.status', 'Status', statusBadge(b.status)));
     g.appendChild(metaTile('detail.arch', 'Arch', b.arch || '-'));
+    if (b.group_id) g.appendChild(metaTile('detail.multiarch', 'Multi-arch Request', b.group_id, true));
     g.appendChild(metaTile('detail.created', 'Created', fmtTime(b.created_at)));
     g.appendChild(metaTile('detail.updated', 'Updated', fmtTime(b.updated_at)));
     lastDetail = b;
     g.appendChild(durationTile(b));
+    if (b.package_name) loadTypicalTime(g, b);
     if (b.instance_id) g.appendChild(metaTile('detail.instance', 'Instance', b.instance_id, true));
     if (b.artifact_url) {
       var wrap = el('div');
@@ -1065,14 +1159,91 @@ async function load() {
     } else if (b.artifact_path) {
       g.appendChild(metaTile('detail.artifact', 'Artifact', basename(b.artifact_path), true));
     }
+    if (b.status === 'success' || b.status === 'completed') loadChecksums(g);
     var delBtn = document.getElementById('delete-job');
-    var terminal = b.status === 'failed' || b.status === 'completed' || b.status === 'success';
+    var terminal = b.status === 'failed' || b.status === 'expired' || b.status === 'cancelled' || b.status === 'completed' || b.status === 'success' || b.status === 'success_no_artifact';
     delBtn.style.display = terminal ? '' : 'none';
+    renderResolutionErrors(b.resolution_errors || []);
     var errCard = document.getElementById('err-card');
     if (b.error) { errCard.style.display = ''; document.getElementById('err-text').textContent = b.error; }
     else errCard.style.display = 'none';
+    renderScript(b.script || '');
   } catch (e) { showError('meta', e); }
 }
+// renderScript offers the script the builder ran, once it reported one; the
+// toggle shows and hides it.
+var scriptShown = false;
+function renderScript(script) {
+  var btn = document.getElementById('script-toggle');
+  btn.style.display = script ? '' : 'none';
+  btn.textContent = scriptShown ? t('detail.script.hide', 'Hide Build Script') : t('detail.script', 'View Build Script');
+  document.getElementById('script-text').textContent = script;
+  document.getElementById('script-card').style.display = script && scriptShown ? '' : 'none';
+}
+document.getElementById('script-toggle').addEventListener('click', function () {
+  scriptShown = !scriptShown;
+  renderScript(lastDetail ? lastDetail.script || '' : '');
+});
+// loadTypicalTime adds how long the package usually takes to build on the
+// job's arch, from the server's build statistics, once any build succeeded.
+var packageStats = null;
+function fmtApprox(s) {
+  if (s < 60) return Math.round(s) + 's';
+  var m = Math.round(s / 60);
+  return m < 60 ? m + 'm' : Math.floor(m / 60) + 'h ' + (m % 60) + 'm';
+}
+async function loadTypicalTime(g, b) {
+  if (!packageStats) {
+    try { packageStats = await api('/api/packages/stats?atom=' + encodeURIComponent(b.package_name) + '&arch=' + encodeURIComponent(b.arch || '')); }
+    catch (e) { return; }
+  }
+  var s = packageStats;
+  if (!s.succeeded) return;
+  var tle = metaTile('detail.typical', 'Typical build time', '~' + fmtApprox(s.median_seconds) + ' (p95 ' + fmtApprox(s.p95_seconds) + ')');
+  tle.title = s.builds + t('detail.typical.hint', ' builds, success rate ') + Math.round(s.success_rate) + '%';
+  g.appendChild(tle);
+}
+var artifactChecksums = null;
+async function loadChecksums(g) {
+  if (!artifactChecksums) {
+    try { artifactChecksums = await api('/api/artifacts/info/' + encodeURIComponent(jobID)); }
+    catch (e) { return; }
+  }
+  var c = artifactChecksums;
+  if (c.provenance) {
+    var pa = el('a', null, basename(c.file_name) + '.provenance.json');
+    pa.href = URL.createObjectURL(new Blob([JSON.stringify(c.provenance, null, 2)], { type: 'application/json' }));
+    pa.setAttribute('download', basename(c.file_name) + '.provenance.json');
+    g.appendChild(metaTile('detail.provenance', 'Provenance', pa, true));
+  }
+  if (!c.sha256 && !c.sha512) return;
+  var wrap = el('div');
+  [['SHA-256', c.sha256], ['SHA-512', c.sha512]].forEach(function (p) {
+    if (!p[1]) return;
+    var row = el('div', 'checksum-row');
+    row.appendChild(el('span', 'checksum-alg', p[0]));
+    row.appendChild(el('span', 'checksum-sum', p[1]));
+    wrap.appendChild(row);
+  });
+  g.appendChild(metaTile('detail.checksums', 'Checksums', wrap, true));
+}
+var RESOLUTION_LABELS = {
+  masked: 'Masked', required_use: 'REQUIRED_USE', blocker: 'Blocker',
+  slot_conflict: 'Slot conflict', unsatisfiable: 'Unsatisfiable'
+};
+function renderResolutionErrors(errs) {
+  var card = document.getElementById('resolution-card');
+  var list = document.getElementById('resolution-list');
+  clear(list);
+  card.style.display = errs.length ? '' : 'none';
+  errs.forEach(function (e) {
+    var li = el('li');
+    li.appendChild(el('span', 'resolution-cat', t('detail.resolution.' + e.category, RESOLUTION_LABELS[e.category] || e.category)));
+    li.appendChild(el('span', 'resolution-atom', e.atom));
+    li.appendChild(el('span', null, e.reason));
+    list.appendChild(li);
+  });
+}
 function onLangChange() { load(); renderLogs(); }
 
 var STAGES = [
@@ -1097,7 +1268,7 @@ var activeFilter = 'all';
 var lastLogText = '';
 
 function stageState(idx, reachedIdx, status, failedIdx, cleanupDone) {
-  var terminal = status === 'completed' || status === 'success';
+  var terminal = status === 'completed' || status === 'success' || status === 'success_no_artifact';
   if (terminal) return 'done';
   if (status === 'failed') {
     if (failedIdx >= 0) {
@@ -1122,7 +1293,7 @@ function renderPipeline(logText, status, failedStage) {
   // Status is authoritative when it maps further than the (possibly truncated)
   // log markers.
   if (STATUS_STAGE[status] !== undefined && STATUS_STAGE[status] > reached) reached = STATUS_STAGE[status];
-  if (status === 'completed' || status === 'success') reached = STAGES.length - 1;
+  if (status === 'completed' || status === 'success' || status === 'success_no_artifact') reached = STAGES.length - 1;
   var failedIdx = -1;
   if (failedStage) {
     for (var j = 0; j < STAGES.length; j++) if (STAGES[j].key === failedStage) failedIdx = j;
@@ -1208,17 +1379,46 @@ const logsJS = `
 var jobID = document.getElementById('head').getAttribute('data-job-id');
 document.getElementById('jid').textContent = jobID;
 document.getElementById('back-link').href = '/build/' + encodeURIComponent(jobID);
-async function load() {
-  var pre = document.getElementById('log');
+var pre = document.getElementById('log');
+var logText = '';
+var pollTimer = null;
+function show() {
   pre.removeAttribute('data-i18n');
+  var atBottom = pre.scrollHeight - pre.scrollTop - pre.clientHeight < 40;
+  pre.textContent = logText || t('logs.none', '(no logs yet)');
+  if (atBottom) pre.scrollTop = pre.scrollHeight;
+}
+async function load() {
   try {
     var r = await api('/api/builds/logs?job_id=' + encodeURIComponent(jobID));
-    pre.textContent = r.logs || t('logs.none', '(no logs yet)');
-  } catch (e) { pre.textContent = t('logs.fail', 'Failed to load logs: ') + e.message; }
+    logText = r.logs || '';
+    show();
+  } catch (e) {
+    pre.removeAttribute('data-i18n');
+    pre.textContent = t('logs.fail', 'Failed to load logs: ') + e.message;
+  }
+}
+function startPolling() {
+  if (pollTimer) return;
+  load();
+  pollTimer = setInterval(load, 5000);
+}
+// Follow the log over Server-Sent Events, appending as the build writes it.
+// Without EventSource, or when the stream cannot be opened, poll instead.
+function startStream() {
+  if (!window.EventSource) { startPolling(); return; }
+  var es = new EventSource('/api/builds/logs/stream?job_id=' + encodeURIComponent(jobID));
+  var opened = false;
+  es.addEventListener('snapshot', function (ev) { opened = true; logText = JSON.parse(ev.data); show(); });
+  es.addEventListener('log', function (ev) { logText += JSON.parse(ev.data); show(); });
+  es.addEventListener('done', function () { es.close(); load(); });
+  es.onerror = function () {
+    // Once a snapshot arrived, EventSource reconnects and resyncs by itself.
+    if (!opened) { es.close(); startPolling(); }
+  };
 }
 document.getElementById('refresh').addEventListener('click', load);
-load();
-setInterval(load, 5000);
+startStream();
 `
 
 // ---------------------------------------------------------------------------
@@ -1227,7 +1427,7 @@ setInterval(load, 5000);
 
 const monitorContent = `
 <div class="page-head">
-  <div><h1 data-i18n="mon.h1">Build Nodes</h1><p class="sub" data-i18n="mon.sub">Static builders and cloud instances</p></div>
+  <div><h1 data-i18n="mon.h1">Build Nodes</h1><p class="sub" data-i18n="mon.sub">Static builders and cloud instances</p><p class="sub mono" id="versions"></p></div>
   <div class="actions"><button class="btn" id="refresh" data-i18n="common.refresh">Refresh</button></div>
 </div>
 <h2 class="section-title" data-i18n="mon.builders">Builders</h2>
@@ -1247,28 +1447,130 @@ const monitorContent = `
 </div>`
 
 const monitorJS = `
-async function load() {
+var dashVersion = null;
+function showVersions(server) {
+  var parts = [];
+  if (server) parts.push(t('mon.serverVersion', 'server ') + server.version + ' (' + server.commit + ')');
+  if (dashVersion) parts.push(t('mon.dashVersion', 'dashboard ') + dashVersion.version + ' (' + dashVersion.commit + ')');
+  document.getElementById('versions').textContent = parts.join(' · ');
+}
+function fmtAge(sec) {
+  var d = Math.floor(sec / 86400), h = Math.floor((sec % 86400) / 3600), m = Math.floor((sec % 3600) / 60);
+  if (d) return d + 'd ' + h + 'h';
+  if (h) return h + 'h ' + m + 'm';
+  return m + 'm';
+}
+// builders holds the state of each builder card by ID. Polling replaces it;
+// updates pushed over the WebSocket merge into it and redraw one card.
+var builders = {};
+function builderCard(b) {
+  var c = el('article', 'builder-card');
+  c.appendChild(el('h3', null, b.id || '-'));
+  c.appendChild(el('p', 'ep mono', b.endpoint || ''));
+  c.appendChild(statusBadge(b.status));
+  var meta = el('div', 'meta');
+  meta.appendChild(el('span', null, t('mon.archLabel', 'arch ') + (b.architecture || '-')));
+  meta.appendChild(el('span', null, t('mon.loadLabel', 'load ') + (b.current_load || 0) + '/' + (b.capacity || 0)));
+  meta.appendChild(el('span', 'mono', t('mon.versionLabel', 'version ') + (b.version || '-')));
+  if (b.tree_last_sync) {
+    var tree = t('mon.treeLabel', 'tree ') + fmtAge(b.tree_age_seconds || 0) + t('mon.treeAgo', ' ago');
+    if (b.tree_revision) tree += ' @' + b.tree_revision.slice(0, 10);
+    var ts = el('span', 'mono', tree);
+    ts.title = b.tree_last_sync;
+    meta.appendChild(ts);
+  }
+  c.appendChild(meta);
+  if (b.tree_stale) {
+    var stale = el('span', 'status orange');
+    stale.appendChild(el('span', 'dot'));
+    stale.appendChild(el('span', null, t('mon.treeStale', 'portage tree is stale')));
+    c.appendChild(stale);
+  }
+  if (b.version_skew) {
+    var skew = el('span', 'status orange');
+    skew.appendChild(el('span', 'dot'));
+    skew.appendChild(el('span', null, t('mon.versionSkew', 'incompatible with server version')));
+    c.appendChild(skew);
+  }
+  if (b.incompatible) {
+    var api = el('span', 'status red');
+    api.appendChild(el('span', 'dot'));
+    api.appendChild(el('span', null, t('mon.apiIncompatible', 'API too old, no jobs routed') + ' (v' + (b.api_version || 0) + ')'));
+    c.appendChild(api);
+  }
+  if (b.enabled === false && b.status !== 'offline' && b.status !== 'error') {
+    var off = el('span', 'status orange');
+    off.appendChild(el('span', 'dot'));
+    off.appendChild(el('span', null, t('mon.disabled', 'disabled, no new jobs')));
+    c.appendChild(off);
+  }
+  c.dataset.id = b.id || '';
+  return c;
+}
+function renderBuilders() {
+  var grid = document.getElementById('builders');
+  var emptyBox = document.getElementById('builders-empty');
+  clear(grid); clear(emptyBox);
+  var ids = Object.keys(builders).sort();
+  if (!ids.length) {
+    emptyBox.appendChild(el('div', 'empty', t('mon.noBuilders', 'No registered builders. Static builders register automatically once SERVER_URL is set; ephemeral cloud instances are not listed here.')));
+  }
+  ids.forEach(function (id) { grid.appendChild(builderCard(builders[id])); });
+}
+// applyBuilderEvent merges a pushed registry change, redrawing only the card
+// of the builder it concerns.
+function applyBuilderEvent(ev) {
+  if (ev.type === 'snapshot') {
+    (ev.builders || []).forEach(function (b) { builders[b.id] = Object.assign(builders[b.id] || {}, b); });
+    renderBuilders();
+    return;
+  }
+  var id = ev.type === 'remove' ? ev.id : (ev.builder && ev.builder.id);
+  if (!id) return;
+  var grid = document.getElementById('builders');
+  var card = Array.prototype.find.call(grid.children, function (c) { return c.dataset.id === id; });
+  if (ev.type === 'remove') {
+    delete builders[id];
+    if (card) grid.removeChild(card);
+    if (!Object.keys(builders).length) renderBuilders();
+    return;
+  }
+  var b = Object.assign(builders[id] || {}, ev.builder);
+  if (b.tree_last_sync) b.tree_age_seconds = Math.max(0, (Date.now() - Date.parse(b.tree_last_sync)) / 1000);
+  builders[id] = b;
+  if (card) grid.replaceChild(builderCard(b), card);
+  else renderBuilders();
+}
+async function loadBuilders() {
   try {
     var data = await api('/api/builders/status');
-    var builders = (data && data.builders) || [];
-    var grid = document.getElementById('builders');
-    var emptyBox = document.getElementById('builders-empty');
-    clear(grid); clear(emptyBox);
-    if (!builders.length) {
-      emptyBox.appendChild(el('div', 'empty', t('mon.noBuilders', 'No registered builders. Static builders register automatically once SERVER_URL is set; ephemeral cloud instances are not listed here.')));
-    }
-    builders.forEach(function (b) {
-      var c = el('article', 'builder-card');
-      c.appendChild(el('h3', null, b.id || '-'));
-      c.appendChild(el('p', 'ep mono', b.endpoint || ''));
-      c.appendChild(statusBadge(b.status));
-      var meta = el('div', 'meta');
-      meta.appendChild(el('span', null, t('mon.archLabel', 'arch ') + (b.architecture || '-')));
-      meta.appendChild(el('span', null, t('mon.loadLabel', 'load ') + (b.current_load || 0) + '/' + (b.capacity || 0)));
-      c.appendChild(meta);
-      grid.appendChild(c);
-    });
+    showVersions(data && data.server_version);
+    builders = {};
+    ((data && data.builders) || []).forEach(function (b) { builders[b.id] = b; });
+    renderBuilders();
   } catch (e) { showError('builders-empty', e); }
+}
+// While the WebSocket is up the server pushes builder changes; when it
+// drops, the page polls until a reconnect succeeds.
+var buildersPoll = null, wsRetry = 1000;
+function startBuildersPolling() {
+  if (!buildersPoll) buildersPoll = setInterval(loadBuilders, 15000);
+}
+function connectBuilders() {
+  if (!window.WebSocket) { startBuildersPolling(); return; }
+  var ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/api/builders/ws');
+  ws.onmessage = function (m) {
+    if (buildersPoll) { clearInterval(buildersPoll); buildersPoll = null; }
+    wsRetry = 1000;
+    try { applyBuilderEvent(JSON.parse(m.data)); } catch (e) {}
+  };
+  ws.onclose = function () {
+    startBuildersPolling();
+    setTimeout(connectBuilders, wsRetry);
+    wsRetry = Math.min(wsRetry * 2, 60000);
+  };
+}
+async function loadInstances() {
   try {
     var r = await api('/api/instances');
     var list = Array.isArray(r) ? r : (r.instances || []);
@@ -1292,10 +1594,16 @@ async function load() {
     });
   } catch (e) { showError('instances-empty', e); }
 }
+async function load() {
+  if (!dashVersion) { try { dashVersion = await api('/api/v1/version'); } catch (e) {} }
+  await loadBuilders();
+  await loadInstances();
+}
 function onLangChange() { load(); }
 document.getElementById('refresh').addEventListener('click', load);
 load();
-setInterval(load, 15000);
+connectBuilders();
+setInterval(loadInstances, 15000);
 `
 
 // ---------------------------------------------------------------------------
@@ -1333,6 +1641,7 @@ const settingsContent = `
         <option value="pve">Proxmox VE</option>
         <option value="gcp">Google Cloud</option>
         <option value="aws">AWS (beta)</option>
+        <option value="hetzner">Hetzner Cloud (beta)</option>
       </select>
       <p class="hint" data-i18n="set.provider.hint">Used when no static builders are configured; more backends can be added over time</p>
     </div>
@@ -1352,6 +1661,10 @@ const settingsContent = `
       <input type="checkbox" id="verify_install" checked>
       <label for="verify_install" data-i18n="set.verify">Verify each binpkg installs from the binhost before marking the build successful (recommended)</label>
     </div>
+    <div class="field check">
+      <input type="checkbox" id="prebaked_image">
+      <label for="prebaked_image" data-i18n="set.prebaked">Prebuilt GCP/AWS image already has Docker, the build container image and a portage tree (skip reinstall and resync)</label>
+    </div>
   </div></div>
 
   <div class="card"><h3 class="card-title" data-i18n="set.testbuild">Test Build</h3><div class="card-pad">
@@ -1485,6 +1798,10 @@ const settingsContent = `
       <label for="gcp_key_file" data-i18n="set.gcp.keyfile">Service account key file (path on server)</label>
       <input type="text" id="gcp_key_file" placeholder="/var/lib/portage-engine/gcp-key.json">
     </div>
+    <div class="field">
+      <label for="gcp_image" data-i18n="set.gcp.image">Prebuilt boot image</label>
+      <input type="text" id="gcp_image" placeholder="projects/my-project/global/images/portage-builder">
+    </div>
   </div></div>
 </section>
 
@@ -1507,6 +1824,18 @@ const settingsContent = `
       <input type="password" id="aws_secret_key" autocomplete="off">
       <p class="hint" id="aws-secret-hint"></p>
     </div>
+    <div class="field">
+      <label for="aws_ami" data-i18n="set.aws.ami">Prebuilt AMI</label>
+      <input type="text" id="aws_ami" placeholder="ami-0123456789abcdef0">
+    </div>
+    <div class="field check">
+      <input type="checkbox" id="aws_spot">
+      <label for="aws_spot" data-i18n="set.aws.spot">Use spot instances (cheaper; a reclaimed instance's build is rescheduled)</label>
+    </div>
+    <div class="field">
+      <label for="aws_max_spot_price" data-i18n="set.aws.spotprice">Max spot price (USD/hour)</label>
+      <input type="text" id="aws_max_spot_price" placeholder="0.05">
+    </div>
   </div></div>
 </section>
 
@@ -1671,6 +2000,7 @@ const settingsContent = `
     <pre class="log-view" id="gpg-pubkey" style="max-height:260px">-</pre>
     <div class="form-actions">
       <a class="btn" href="/api/keys/download" data-i18n="set.gpg.download">Download Public Key</a>
+      <a class="btn" href="/api/keys/download?format=gpg" data-i18n="set.gpg.download_bin">Download Binary (.gpg)</a>
     </div>
   </div></div>
 </section>
@@ -1748,15 +2078,20 @@ function collect() {
     instance_ttl_minutes: parseInt(val('ttl') || '0', 10) || 0,
     skip_verify_install: !checked('verify_install'),
     docker_image: val('docker_image'),
+    prebaked_image: checked('prebaked_image'),
     remote_builders: csv('remote_builders'),
     gcp_project: val('gcp_project'),
     gcp_region: val('gcp_region'),
     gcp_zone: val('gcp_zone'),
     gcp_key_file: val('gcp_key_file'),
+    gcp_image: val('gcp_image'),
     aws_region: val('aws_region'),
     aws_zone: val('aws_zone'),
     aws_access_key: val('aws_access_key'),
     aws_secret_key: val('aws_secret_key'),
+    aws_ami: val('aws_ami'),
+    aws_spot: checked('aws_spot'),
+    aws_max_spot_price: val('aws_max_spot_price'),
     pve_endpoint: val('pve_endpoint'),
     pve_node: node,
     pve_nodes: csv('pve_nodes'),
@@ -1799,14 +2134,19 @@ function fill(s) {
   setVal('ttl', s.instance_ttl_minutes || 0);
   document.getElementById('verify_install').checked = !s.skip_verify_install;
   setVal('docker_image', s.docker_image);
+  document.getElementById('prebaked_image').checked = !!s.prebaked_image;
   setVal('remote_builders', (s.remote_builders || []).join(','));
   setVal('gcp_project', s.gcp_project);
   setVal('gcp_region', s.gcp_region);
   setVal('gcp_zone', s.gcp_zone);
   setVal('gcp_key_file', s.gcp_key_file);
+  setVal('gcp_image', s.gcp_image);
   setVal('aws_region', s.aws_region);
   setVal('aws_zone', s.aws_zone);
   setVal('aws_access_key', s.aws_access_key);
+  setVal('aws_ami', s.aws_ami);
+  document.getElementById('aws_spot').checked = !!s.aws_spot;
+  setVal('aws_max_spot_price', s.aws_max_spot_price);
   var awsHint = document.getElementById('aws-secret-hint');
   awsHint.textContent = s.has_aws_secret_key ? t('set.secret.saved', 'Saved; leave empty to keep') : t('set.secret.unset', 'Not set yet');
   document.getElementById('aws_secret_key').placeholder = s.has_aws_secret_key ? t('set.secret.ph', 'Saved — leave empty to keep') : '';
@@ -1952,7 +2292,7 @@ document.getElementById('test-build').addEventListener('click', async function (
         line.className = '';
         line.appendChild(badge);
         if (b.error) line.appendChild(el('span', 'sec', ' ' + b.error.slice(0, 160)));
-        if (b.status === 'failed' || b.status === 'completed' || b.status === 'success') clearInterval(testPoll);
+        if (b.status === 'failed' || b.status === 'cancelled' || b.status === 'completed' || b.status === 'success' || b.status === 'success_no_artifact') clearInterval(testPoll);
       } catch (e) { /* keep polling */ }
     }, 5000);
   } catch (ex) { noteAt(tmsg, t('set.testbuild.fail', 'Test build failed: ') + ex.message, false); }
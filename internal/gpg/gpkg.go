@@ -0,0 +1,393 @@
+// Package gpg provides signing of gpkg binary packages with the signatures
+// embedded the way Portage's binpkg-signing writes them.
+package gpg
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// gpkgManifest is the gpkg member listing the size and digests of every
+// other member, clearsigned when the package is signed.
+const gpkgManifest = "Manifest"
+
+// gpkgMember is a member of a gpkg: its header, its base name and, for a
+// regular file, where it was extracted to.
+type gpkgMember struct {
+	hdr  *tar.Header
+	name string
+	path string
+}
+
+// gpkgSignedMember reports whether the member name is one the GPKG format
+// gives a detached signature: the metadata and image tarballs.
+func gpkgSignedMember(name string) bool {
+	return (strings.HasPrefix(name, "metadata.tar") || strings.HasPrefix(name, "image.tar")) &&
+		!strings.HasSuffix(name, ".sig")
+}
+
+// extractGpkg writes the regular members of the gpkg at path into dir under
+// their base names and returns every member in archive order. Two regular
+// members with the same base name are an error.
+func extractGpkg(path, dir string) ([]gpkgMember, error) {
+	f, err := os.Open(path) // #nosec G304 -- a package the caller chose to sign or verify.
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var members []gpkgMember
+	seen := map[string]bool{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("not a gpkg: %w", err)
+		}
+		name := filepath.Base(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg {
+			members = append(members, gpkgMember{hdr: hdr, name: name})
+			continue
+		}
+		if name == "." || name == ".." {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("gpkg has more than one member %s", name)
+		}
+		seen[name] = true
+		out, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(out, tr) // #nosec G110 -- bounded by the package's own size.
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, gpkgMember{hdr: hdr, name: name, path: filepath.Join(dir, name)})
+	}
+}
+
+// SignGPKG signs the gpkg at pkgPath in place with signatures embedded per the
+// GPKG format, so emerge verifies it with binpkg-request-signature and no
+// detached signature is needed: each metadata and image member gets an
+// armored detached signature member X.sig next to it, and the Manifest is
+// rewritten with the size and digests of every member and clearsigned.
+// Signatures and a Manifest the package already carried are replaced.
+func (s *Signer) SignGPKG(pkgPath string) error {
+	if !s.enabled {
+		log.Printf("GPG signing disabled, skipping signature for %s", pkgPath)
+		return nil
+	}
+	if s.keyID == "" {
+		return fmt.Errorf("GPG key ID not configured")
+	}
+
+	dir, err := os.MkdirTemp("", "gpkg-sign-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	memberDir := filepath.Join(dir, "members")
+	if err := os.Mkdir(memberDir, 0700); err != nil {
+		return err
+	}
+	members, err := extractGpkg(pkgPath, memberDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", pkgPath, err)
+	}
+
+	log.Printf("Signing gpkg: %s with key %s", pkgPath, s.keyID)
+
+	var signed []gpkgMember
+	var last *tar.Header
+	sigs := 0
+	for _, m := range members {
+		if m.path != "" && (strings.HasSuffix(m.name, ".sig") || m.name == gpkgManifest) {
+			continue
+		}
+		signed = append(signed, m)
+		last = m.hdr
+		if m.path == "" || !gpkgSignedMember(m.name) {
+			continue
+		}
+		sig := m.path + ".sig"
+		if err := s.signFile("--detach-sign", m.path, sig); err != nil {
+			return fmt.Errorf("failed to sign %s: %w", m.name, err)
+		}
+		signed = append(signed, gpkgMember{hdr: memberHeader(m.hdr, m.hdr.Name+".sig"), name: m.name + ".sig", path: sig})
+		sigs++
+	}
+	if sigs == 0 {
+		return fmt.Errorf("%s has no metadata or image member to sign", pkgPath)
+	}
+
+	manifest, err := renderGpkgManifest(signed)
+	if err != nil {
+		return err
+	}
+	unsigned := filepath.Join(dir, "Manifest.unsigned")
+	if err := os.WriteFile(unsigned, manifest, 0600); err != nil {
+		return err
+	}
+	manifestPath := filepath.Join(dir, gpkgManifest)
+	if err := s.signFile("--clearsign", unsigned, manifestPath); err != nil {
+		return fmt.Errorf("failed to sign the Manifest: %w", err)
+	}
+	name := path.Join(path.Dir(last.Name), gpkgManifest)
+	signed = append(signed, gpkgMember{hdr: memberHeader(last, name), name: gpkgManifest, path: manifestPath})
+
+	if err := writeGpkg(pkgPath, signed); err != nil {
+		return fmt.Errorf("failed to rewrite %s: %w", pkgPath, err)
+	}
+	log.Printf("gpkg signed successfully: %s", pkgPath)
+	return nil
+}
+
+// memberHeader returns the header of a new regular member name, owned like
+// the member of template.
+func memberHeader(template *tar.Header, name string) *tar.Header {
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     template.Mode,
+		Uid:      template.Uid,
+		Gid:      template.Gid,
+		Uname:    template.Uname,
+		Gname:    template.Gname,
+		ModTime:  template.ModTime,
+	}
+}
+
+// writeGpkg replaces the package at path with an archive of members,
+// through a temp file so path is never half-written.
+func writeGpkg(path string, members []gpkgMember) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".gpkg-sign-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer func() { _ = os.Remove(tmpName) }()
+
+	tw := tar.NewWriter(tmp)
+	for _, m := range members {
+		if err := writeGpkgMember(tw, m); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
+// writeGpkgMember adds the member m to tw.
+func writeGpkgMember(tw *tar.Writer, m gpkgMember) error {
+	if m.path == "" {
+		return tw.WriteHeader(m.hdr)
+	}
+	f, err := os.Open(m.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := *m.hdr
+	hdr.Size = info.Size()
+	if err := tw.WriteHeader(&hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// gpkgDigests are the digests a gpkg Manifest lists for each member, by
+// name, as Portage's MANIFEST2_HASH_DEFAULTS.
+var gpkgDigests = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"BLAKE2B", func() hash.Hash { h, _ := blake2b.New512(nil); return h }},
+	{"SHA512", sha512.New},
+}
+
+// renderGpkgManifest renders the Manifest of the regular members:
+// "DATA <name> <size> BLAKE2B <hex> SHA512 <hex>" lines in archive order.
+func renderGpkgManifest(members []gpkgMember) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, m := range members {
+		if m.path == "" {
+			continue
+		}
+		size, digests, err := digestGpkgMember(m.path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "DATA %s %d", m.name, size)
+		for i, d := range gpkgDigests {
+			fmt.Fprintf(&buf, " %s %s", d.name, digests[i])
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// digestGpkgMember returns the size of the file at p and its gpkgDigests,
+// hex-encoded.
+func digestGpkgMember(p string) (int64, []string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = f.Close() }()
+	hashes := make([]hash.Hash, len(gpkgDigests))
+	writers := make([]io.Writer, len(gpkgDigests))
+	for i, d := range gpkgDigests {
+		hashes[i] = d.new()
+		writers[i] = hashes[i]
+	}
+	size, err := io.Copy(io.MultiWriter(writers...), f)
+	if err != nil {
+		return 0, nil, err
+	}
+	digests := make([]string, len(hashes))
+	for i, h := range hashes {
+		digests[i] = hex.EncodeToString(h.Sum(nil))
+	}
+	return size, digests, nil
+}
+
+// checkGpkgManifest checks that the Manifest text lists every regular member
+// but itself, each with its size and digests.
+func checkGpkgManifest(manifest []byte, members []gpkgMember) error {
+	listed := map[string]bool{}
+	for _, line := range strings.Split(string(manifest), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "DATA" {
+			continue
+		}
+		if len(fields) < 3 || len(fields)%2 == 0 {
+			return fmt.Errorf("malformed line %q", line)
+		}
+		listed[fields[1]] = false
+		for _, m := range members {
+			if m.path == "" || m.name != fields[1] {
+				continue
+			}
+			size, digests, err := digestGpkgMember(m.path)
+			if err != nil {
+				return err
+			}
+			if strconv.FormatInt(size, 10) != fields[2] {
+				return fmt.Errorf("%s is %d bytes, the Manifest says %s", m.name, size, fields[2])
+			}
+			for i := 3; i < len(fields); i += 2 {
+				for j, d := range gpkgDigests {
+					if d.name == fields[i] && !strings.EqualFold(digests[j], fields[i+1]) {
+						return fmt.Errorf("%s does not match its %s digest", m.name, d.name)
+					}
+				}
+			}
+			listed[fields[1]] = true
+		}
+		if !listed[fields[1]] {
+			return fmt.Errorf("%s is in the Manifest but not in the package", fields[1])
+		}
+	}
+	for _, m := range members {
+		if m.path != "" && m.name != gpkgManifest && !listed[m.name] {
+			return fmt.Errorf("%s is not in the Manifest", m.name)
+		}
+	}
+	return nil
+}
+
+// clearsignedText returns the signed text of a clearsigned message, with
+// its dash-escaping undone, or ok false when data is not exactly one
+// clearsigned message.
+func clearsignedText(data []byte) (text []byte, ok bool) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if len(lines) == 0 || lines[0] != "-----BEGIN PGP SIGNED MESSAGE-----" {
+		return nil, false
+	}
+	i := 1
+	for i < len(lines) && lines[i] != "" { // armor headers, e.g. "Hash: SHA512"
+		i++
+	}
+	var body []string
+	for i++; i < len(lines); i++ {
+		if lines[i] == "-----BEGIN PGP SIGNATURE-----" {
+			break
+		}
+		body = append(body, strings.TrimPrefix(lines[i], "- "))
+	}
+	for ; i < len(lines); i++ {
+		if lines[i] == "-----END PGP SIGNATURE-----" {
+			break
+		}
+	}
+	if i >= len(lines) || strings.TrimSpace(strings.Join(lines[i+1:], "\n")) != "" {
+		return nil, false
+	}
+	return []byte(strings.Join(body, "\n") + "\n"), true
+}
+
+// verifyGpkgManifest checks the Manifest member at manifestPath against the
+// members, and its signature when it is clearsigned. It returns nil when
+// both are good.
+func (s *Signer) verifyGpkgManifest(manifestPath string, members []gpkgMember) *VerifyResult {
+	data, err := os.ReadFile(manifestPath) // #nosec G304 -- extracted into our own temp dir.
+	if err != nil {
+		return &VerifyResult{Error: err.Error()}
+	}
+	if bytes.HasPrefix(data, []byte("-----BEGIN PGP SIGNED MESSAGE-----")) {
+		r := s.verifyClearsigned(manifestPath)
+		if !r.Valid {
+			r.Error = gpkgManifest + ": " + r.Error
+			return r
+		}
+		text, ok := clearsignedText(data)
+		if !ok {
+			return &VerifyResult{Error: gpkgManifest + ": not a single clearsigned message"}
+		}
+		data = text
+	}
+	if err := checkGpkgManifest(data, members); err != nil {
+		return &VerifyResult{Error: gpkgManifest + ": " + err.Error()}
+	}
+	return nil
+}
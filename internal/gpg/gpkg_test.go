@@ -0,0 +1,160 @@
+package gpg
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestGpkg writes a gpkg of the members, name and content pairs, in
+// order under the jq-1.7.1-1/ directory.
+func writeTestGpkg(t *testing.T, path string, members ...string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(f)
+	for i := 0; i < len(members); i += 2 {
+		data := []byte(members[i+1])
+		if err := tw.WriteHeader(&tar.Header{Name: "jq-1.7.1-1/" + members[i], Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+}
+
+// readTestGpkg returns the member names and contents of the gpkg at path.
+func readTestGpkg(t *testing.T, path string) ([]string, map[string]string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	var names []string
+	contents := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		names = append(names, hdr.Name)
+		contents[filepath.Base(hdr.Name)] = string(data)
+	}
+}
+
+func TestSignGPKG(t *testing.T) {
+	signer := newTestSigner(t, "builder@example.com")
+	pkg := filepath.Join(t.TempDir(), "jq-1.7.1-1.gpkg.tar")
+	writeTestGpkg(t, pkg,
+		"gpkg-1", "",
+		"metadata.tar.zst", "metadata",
+		"image.tar.zst", "image",
+		"Manifest", "DATA gpkg-1 0\n",
+	)
+
+	if err := signer.SignGPKG(pkg); err != nil {
+		t.Fatalf("SignGPKG() = %v", err)
+	}
+	names, contents := readTestGpkg(t, pkg)
+	want := []string{
+		"jq-1.7.1-1/gpkg-1",
+		"jq-1.7.1-1/metadata.tar.zst", "jq-1.7.1-1/metadata.tar.zst.sig",
+		"jq-1.7.1-1/image.tar.zst", "jq-1.7.1-1/image.tar.zst.sig",
+		"jq-1.7.1-1/Manifest",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("members = %v, want %v", names, want)
+	}
+	if contents["image.tar.zst"] != "image" {
+		t.Errorf("image.tar.zst = %q, want it unchanged", contents["image.tar.zst"])
+	}
+	manifest, ok := clearsignedText([]byte(contents["Manifest"]))
+	if !ok {
+		t.Fatalf("Manifest is not clearsigned:\n%s", contents["Manifest"])
+	}
+	for _, line := range []string{"DATA gpkg-1 0 BLAKE2B ", "DATA image.tar.zst 5 BLAKE2B ", "DATA image.tar.zst.sig "} {
+		if !strings.Contains(string(manifest), line) {
+			t.Errorf("Manifest lacks %q:\n%s", line, manifest)
+		}
+	}
+	if r := signer.VerifyGpkg(pkg); !r.Valid {
+		t.Fatalf("VerifyGpkg() = %+v, want valid", r)
+	}
+
+	// Signing again replaces the signatures rather than adding to them.
+	if err := signer.SignGPKG(pkg); err != nil {
+		t.Fatalf("SignGPKG() of a signed gpkg = %v", err)
+	}
+	if names, _ := readTestGpkg(t, pkg); !reflect.DeepEqual(names, want) {
+		t.Errorf("members after signing again = %v", names)
+	}
+
+	// A member the Manifest covers but no signature does is still checked.
+	tampered := filepath.Join(t.TempDir(), "tampered.gpkg.tar")
+	_, contents = readTestGpkg(t, pkg)
+	writeTestGpkg(t, tampered,
+		"gpkg-1", "changed",
+		"metadata.tar.zst", contents["metadata.tar.zst"],
+		"metadata.tar.zst.sig", contents["metadata.tar.zst.sig"],
+		"image.tar.zst", contents["image.tar.zst"],
+		"image.tar.zst.sig", contents["image.tar.zst.sig"],
+		"Manifest", contents["Manifest"],
+	)
+	if r := signer.VerifyGpkg(tampered); r.Valid || !strings.Contains(r.Error, "gpkg-1") {
+		t.Errorf("tampered gpkg-1: %+v, want a Manifest mismatch", r)
+	}
+
+	// A Manifest signed by another key is refused.
+	other := newTestSigner(t, "other@example.com")
+	if err := other.SignGPKG(pkg); err != nil {
+		t.Fatal(err)
+	}
+	if r := signer.VerifyGpkg(pkg); r.Valid {
+		t.Errorf("gpkg signed by another key: %+v, want rejected", r)
+	}
+
+	notGpkg := filepath.Join(t.TempDir(), "jq-1.7.1-1.tbz2")
+	if err := os.WriteFile(notGpkg, []byte("bzip2 data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.SignGPKG(notGpkg); err == nil {
+		t.Error("SignGPKG() of a tbz2 succeeded")
+	}
+}
+
+func TestClearsignedText(t *testing.T) {
+	t.Parallel()
+
+	msg := "-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA512\n\nDATA gpkg-1 0\n- -dashed\n-----BEGIN PGP SIGNATURE-----\n\nabc\n-----END PGP SIGNATURE-----\n"
+	text, ok := clearsignedText([]byte(msg))
+	if !ok || string(text) != "DATA gpkg-1 0\n-dashed\n" {
+		t.Errorf("clearsignedText = %q, %v", text, ok)
+	}
+	for _, bad := range []string{
+		"DATA gpkg-1 0\n",
+		"DATA extra\n" + msg,
+		msg + "DATA extra\n",
+		strings.TrimSuffix(msg, "-----END PGP SIGNATURE-----\n"),
+	} {
+		if _, ok := clearsignedText([]byte(bad)); ok {
+			t.Errorf("clearsignedText(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Package gpg provides conversion between armored and binary OpenPGP keys.
+package gpg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Key export formats: ASCII-armored (.asc) and binary OpenPGP packets (.gpg).
+const (
+	FormatArmored = "asc"
+	FormatBinary  = "gpg"
+)
+
+// publicKeyBlock is the armor type of an exported public key.
+const publicKeyBlock = "PGP PUBLIC KEY BLOCK"
+
+// ErrNotOpenPGP is returned for data that is neither an armored block nor
+// binary OpenPGP packets (e.g. an HTML error page saved as a key).
+var ErrNotOpenPGP = errors.New("not an OpenPGP key")
+
+// ParseKeyFormat normalizes a user-supplied format name. Empty means armored.
+func ParseKeyFormat(format string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "asc", "armor", "armored":
+		return FormatArmored, nil
+	case "gpg", "bin", "binary":
+		return FormatBinary, nil
+	default:
+		return "", fmt.Errorf("unknown key format %q (want asc or gpg)", format)
+	}
+}
+
+// IsArmored reports whether data is an ASCII-armored OpenPGP block.
+func IsArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("-----BEGIN PGP "))
+}
+
+// isBinaryOpenPGP reports whether data starts with an OpenPGP packet header,
+// whose first octet always has the high bit set (RFC 4880 section 4.2).
+func isBinaryOpenPGP(data []byte) bool {
+	return len(data) > 0 && data[0]&0x80 != 0
+}
+
+// KeyFormat detects the format of key data.
+func KeyFormat(data []byte) (string, error) {
+	switch {
+	case IsArmored(data):
+		return FormatArmored, nil
+	case isBinaryOpenPGP(data):
+		return FormatBinary, nil
+	default:
+		return "", ErrNotOpenPGP
+	}
+}
+
+// ConvertKey returns key data in the requested format, converting between
+// armored and binary as needed.
+func ConvertKey(data []byte, format string) ([]byte, error) {
+	have, err := KeyFormat(data)
+	if err != nil {
+		return nil, err
+	}
+	if have == format {
+		return data, nil
+	}
+	if format == FormatBinary {
+		return Dearmor(data)
+	}
+	return Armor(data, publicKeyBlock), nil
+}
+
+// Armor encodes binary OpenPGP data as an ASCII-armored block of blockType
+// (e.g. "PGP PUBLIC KEY BLOCK"), with the CRC-24 checksum line.
+func Armor(data []byte, blockType string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "-----BEGIN %s-----\n\n", blockType)
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 64 {
+		b.WriteString(enc[:64] + "\n")
+		enc = enc[64:]
+	}
+	if enc != "" {
+		b.WriteString(enc + "\n")
+	}
+	sum := crc24(data)
+	fmt.Fprintf(&b, "=%s\n", base64.StdEncoding.EncodeToString([]byte{byte(sum >> 16), byte(sum >> 8), byte(sum)}))
+	fmt.Fprintf(&b, "-----END %s-----\n", blockType)
+	return b.Bytes()
+}
+
+// Dearmor decodes the first ASCII-armored block in data to binary, verifying
+// its checksum when present.
+func Dearmor(data []byte) ([]byte, error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	// Skip to the BEGIN line, then past the armor headers ("Version: ...")
+	// which end at the first blank line.
+	inBlock, inBody := false, false
+	var body strings.Builder
+	checksum := ""
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case !inBlock:
+			inBlock = strings.HasPrefix(line, "-----BEGIN PGP ")
+		case strings.HasPrefix(line, "-----END PGP "):
+			return decodeArmorBody(body.String(), checksum)
+		case !inBody:
+			// Headers are "Key: value"; a line without one is the body
+			// (some encoders omit the blank separator).
+			if line == "" {
+				inBody = true
+			} else if !strings.Contains(line, ": ") {
+				inBody = true
+				body.WriteString(line)
+			}
+		case strings.HasPrefix(line, "="):
+			checksum = line[1:]
+		default:
+			body.WriteString(line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: unterminated armor block", ErrNotOpenPGP)
+}
+
+// decodeArmorBody base64-decodes an armor body and checks its CRC-24.
+func decodeArmorBody(body, checksum string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid armor body: %w", err)
+	}
+	if checksum != "" {
+		want, err := base64.StdEncoding.DecodeString(checksum)
+		if err != nil || len(want) != 3 {
+			return nil, fmt.Errorf("invalid armor checksum %q", checksum)
+		}
+		sum := crc24(data)
+		if want[0] != byte(sum>>16) || want[1] != byte(sum>>8) || want[2] != byte(sum) {
+			return nil, errors.New("armor checksum mismatch")
+		}
+	}
+	return data, nil
+}
+
+// crc24 is the OpenPGP armor checksum (RFC 4880 section 6.1).
+func crc24(data []byte) uint32 {
+	const (
+		crc24Init = 0xB704CE
+		crc24Poly = 0x1864CFB
+	)
+	crc := uint32(crc24Init)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for range 8 {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+	return crc & 0xFFFFFF
+}
@@ -0,0 +1,79 @@
+package gpg
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseKeyFormat(t *testing.T) {
+	tests := map[string]string{"": FormatArmored, "asc": FormatArmored, "ARMOR": FormatArmored, "gpg": FormatBinary, "binary": FormatBinary}
+	for in, want := range tests {
+		if got, err := ParseKeyFormat(in); err != nil || got != want {
+			t.Errorf("ParseKeyFormat(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseKeyFormat("pem"); err == nil {
+		t.Error("ParseKeyFormat(pem) should fail")
+	}
+}
+
+func TestArmorRoundTrip(t *testing.T) {
+	// A public-key packet header followed by enough bytes to wrap lines.
+	binary := append([]byte{0x99, 0x01, 0x0d}, bytes.Repeat([]byte{0x42, 0x07}, 100)...)
+
+	armored := Armor(binary, publicKeyBlock)
+	if !IsArmored(armored) {
+		t.Fatalf("Armor() output not detected as armored:\n%s", armored)
+	}
+	if !strings.HasPrefix(string(armored), "-----BEGIN PGP PUBLIC KEY BLOCK-----\n") ||
+		!strings.HasSuffix(string(armored), "-----END PGP PUBLIC KEY BLOCK-----\n") {
+		t.Errorf("unexpected armor framing:\n%s", armored)
+	}
+
+	decoded, err := Dearmor(armored)
+	if err != nil {
+		t.Fatalf("Dearmor() error = %v", err)
+	}
+	if !bytes.Equal(decoded, binary) {
+		t.Errorf("round trip mismatch: got %x, want %x", decoded, binary)
+	}
+
+	// gpg inserts armor headers before the blank separator line.
+	withHeader := strings.Replace(string(armored), "-----\n\n", "-----\nComment: test\n\n", 1)
+	if decoded, err := Dearmor([]byte(withHeader)); err != nil || !bytes.Equal(decoded, binary) {
+		t.Errorf("Dearmor() with armor header = %x, %v", decoded, err)
+	}
+}
+
+func TestDearmorChecksumMismatch(t *testing.T) {
+	armored := string(Armor([]byte{0x99, 0x00, 0x01}, publicKeyBlock))
+	lines := strings.Split(armored, "\n")
+	for i, l := range lines {
+		if strings.HasPrefix(l, "=") {
+			lines[i] = "=AAAA"
+		}
+	}
+	if _, err := Dearmor([]byte(strings.Join(lines, "\n"))); err == nil {
+		t.Error("Dearmor() should reject a bad checksum")
+	}
+}
+
+func TestConvertKey(t *testing.T) {
+	binary := []byte{0x99, 0x00, 0x02, 0x04, 0x01}
+	armored := Armor(binary, publicKeyBlock)
+
+	if got, err := ConvertKey(armored, FormatBinary); err != nil || !bytes.Equal(got, binary) {
+		t.Errorf("ConvertKey(armored, gpg) = %x, %v", got, err)
+	}
+	if got, err := ConvertKey(binary, FormatArmored); err != nil || !bytes.Equal(got, armored) {
+		t.Errorf("ConvertKey(binary, asc) = %q, %v", got, err)
+	}
+	if got, err := ConvertKey(armored, FormatArmored); err != nil || !bytes.Equal(got, armored) {
+		t.Errorf("ConvertKey(armored, asc) should pass the key through, got %q, %v", got, err)
+	}
+	if _, err := ConvertKey([]byte("<html>"), FormatArmored); !errors.Is(err, ErrNotOpenPGP) {
+		t.Errorf("ConvertKey(html) error = %v, want ErrNotOpenPGP", err)
+	}
+}
@@ -0,0 +1,253 @@
+// Package gpg provides signing key rotation: a persisted history of the keys
+// the server has signed with, each new key certified by the one it replaced.
+package gpg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyRecord is one signing key in the history.
+type KeyRecord struct {
+	// KeyID is the key's fingerprint.
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"` // armored
+	// ValidFrom and ValidUntil bound the time the key was the signing key;
+	// ValidUntil is unset for the current key.
+	ValidFrom  time.Time  `json:"valid_from"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+	Current    bool       `json:"current"`
+	// PreviousKeyID is the fingerprint of the key this one replaced.
+	PreviousKeyID string `json:"previous_key_id,omitempty"`
+	// Succession is the succession certificate: the previous key's armored
+	// detached signature over PublicKey, which in turn carries the previous
+	// key's certification of the new key's user ID. Whoever trusts the
+	// previous key can check it before trusting this one.
+	Succession string `json:"succession,omitempty"`
+}
+
+// KeyHistory is the signing key history, oldest key first, persisted as JSON.
+type KeyHistory struct {
+	mu   sync.Mutex
+	path string
+	keys []KeyRecord
+	// rotateMu serialises rotations, which run gpg without holding mu.
+	rotateMu sync.Mutex
+}
+
+// LoadKeyHistory loads the key history at path. A missing file is an empty
+// history.
+func LoadKeyHistory(path string) (*KeyHistory, error) {
+	h := &KeyHistory{path: path}
+	data, err := os.ReadFile(path) // #nosec G304 -- path comes from the server config.
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key history: %w", err)
+	}
+	if err := json.Unmarshal(data, &h.keys); err != nil {
+		return nil, fmt.Errorf("invalid key history %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// Keys returns a copy of the history, oldest key first.
+func (h *KeyHistory) Keys() []KeyRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]KeyRecord(nil), h.keys...)
+}
+
+// Key returns the record of the key with the given fingerprint or key ID.
+func (h *KeyHistory) Key(keyID string) (KeyRecord, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range h.keys {
+		if keyIDMatches(k.KeyID, keyID) {
+			return k, true
+		}
+	}
+	return KeyRecord{}, false
+}
+
+// Current returns the current signing key's record, if the history has one.
+func (h *KeyHistory) Current() (KeyRecord, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.currentLocked()
+}
+
+func (h *KeyHistory) currentLocked() (KeyRecord, bool) {
+	for _, k := range h.keys {
+		if k.Current {
+			return k, true
+		}
+	}
+	return KeyRecord{}, false
+}
+
+// save writes the history atomically. The caller holds h.mu.
+func (h *KeyHistory) save() error {
+	data, err := json.MarshalIndent(h.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0750); err != nil {
+		return fmt.Errorf("failed to create key history dir: %w", err)
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write key history: %w", err)
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// keyIDMatches reports whether keyID (a fingerprint, or a long or short key
+// ID, which are its suffixes) names the key with the fingerprint fpr.
+func keyIDMatches(fpr, keyID string) bool {
+	keyID = strings.ToUpper(strings.TrimPrefix(strings.ReplaceAll(keyID, " ", ""), "0x"))
+	return keyID != "" && strings.HasSuffix(strings.ToUpper(fpr), keyID)
+}
+
+// Adopt reconciles the signer with the history. An empty history starts
+// with the signer's key. Otherwise the history's current key, the result of
+// earlier rotations, replaces the signer's configured one; it is an error
+// when that key is no longer in the keyring.
+func (h *KeyHistory) Adopt(s *Signer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if current, ok := h.currentLocked(); ok {
+		if keyIDMatches(current.KeyID, s.keyID) {
+			return nil
+		}
+		if !s.keyExists(current.KeyID) {
+			return fmt.Errorf("current key %s from the key history is not in the keyring", current.KeyID)
+		}
+		s.SetKeyID(current.KeyID)
+		return nil
+	}
+
+	fpr, err := s.fingerprint(s.keyID)
+	if err != nil {
+		return err
+	}
+	pub, err := s.GetPublicKey()
+	if err != nil {
+		return err
+	}
+	h.keys = append(h.keys, KeyRecord{KeyID: fpr, PublicKey: pub, ValidFrom: time.Now().UTC(), Current: true})
+	return h.save()
+}
+
+// RotateKey generates a new signing key, certifies it with the signer's key
+// and records it in the history as the current key, archiving the signer's.
+// It returns a signer for the new key; the receiver keeps signing with the
+// old one. name and email default to the signer's own.
+func (s *Signer) RotateKey(h *KeyHistory, name, email string) (*Signer, KeyRecord, error) {
+	if !s.enabled || s.keyID == "" {
+		return nil, KeyRecord{}, fmt.Errorf("GPG signing is not enabled")
+	}
+	h.rotateMu.Lock()
+	defer h.rotateMu.Unlock()
+	if err := h.Adopt(s); err != nil {
+		return nil, KeyRecord{}, err
+	}
+	oldFpr, err := s.fingerprint(s.keyID)
+	if err != nil {
+		return nil, KeyRecord{}, err
+	}
+
+	next := NewSigner("", s.keyPath, true, WithGnupgHome(s.gnupgHome), WithAutoCreate(s.keyName, s.keyEmail))
+	if name != "" {
+		next.keyName = name
+	}
+	if email != "" {
+		next.keyEmail = email
+	}
+	keyID, err := next.generateKey()
+	if err != nil {
+		return nil, KeyRecord{}, fmt.Errorf("failed to generate GPG key: %w", err)
+	}
+	next.keyID = keyID
+	newFpr, err := next.fingerprint(keyID)
+	if err != nil {
+		return nil, KeyRecord{}, err
+	}
+
+	if _, err := s.runGPG(nil, "--batch", "--yes", "--local-user", oldFpr, "--quick-sign-key", newFpr); err != nil {
+		return nil, KeyRecord{}, fmt.Errorf("failed to certify the new key: %w", err)
+	}
+	pub, err := next.GetPublicKey()
+	if err != nil {
+		return nil, KeyRecord{}, err
+	}
+	succession, err := s.runGPG([]byte(pub), "--batch", "--yes", "--local-user", oldFpr, "--armor", "--detach-sign")
+	if err != nil {
+		return nil, KeyRecord{}, fmt.Errorf("failed to sign the succession certificate: %w", err)
+	}
+
+	now := time.Now().UTC()
+	record := KeyRecord{
+		KeyID:         newFpr,
+		PublicKey:     pub,
+		ValidFrom:     now,
+		Current:       true,
+		PreviousKeyID: oldFpr,
+		Succession:    string(succession),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := range h.keys {
+		if h.keys[i].Current {
+			h.keys[i].Current = false
+			h.keys[i].ValidUntil = &now
+		}
+	}
+	h.keys = append(h.keys, record)
+	if err := h.save(); err != nil {
+		return nil, KeyRecord{}, err
+	}
+	return next, record, nil
+}
+
+// fingerprint returns the fingerprint of the primary key keyID names.
+func (s *Signer) fingerprint(keyID string) (string, error) {
+	out, err := s.runGPG(nil, "--batch", "--with-colons", "--fingerprint", keyID)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up key %s: %w", keyID, err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Split(line, ":"); len(fields) > 9 && fields[0] == "fpr" {
+			return fields[9], nil
+		}
+	}
+	return "", fmt.Errorf("no fingerprint for key %s", keyID)
+}
+
+// runGPG runs gpg with the signer's keyring and home, feeding it stdin, and
+// returns its stdout.
+func (s *Signer) runGPG(stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("gpg", append(s.buildBaseArgs(), args...)...)
+	if s.gnupgHome != "" {
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+s.gnupgHome)
+	}
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
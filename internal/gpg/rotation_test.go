@@ -0,0 +1,106 @@
+package gpg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotateKey(t *testing.T) {
+	old := newTestSigner(t, "server@example.com")
+	historyPath := filepath.Join(t.TempDir(), "key-history.json")
+	h, err := LoadKeyHistory(historyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Adopt(old); err != nil {
+		t.Fatal(err)
+	}
+	if cur, ok := h.Current(); !ok || cur.KeyID != old.KeyID() || cur.PublicKey == "" {
+		t.Fatalf("history after Adopt: %+v", h.Keys())
+	}
+
+	next, record, err := old.RotateKey(h, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keyIDMatches(record.KeyID, next.KeyID()) || record.PreviousKeyID != old.KeyID() || !record.Current {
+		t.Errorf("rotation record = %+v", record)
+	}
+	keys := h.Keys()
+	if len(keys) != 2 || keys[0].Current || keys[0].ValidUntil == nil || keys[1].ValidUntil != nil {
+		t.Fatalf("history after rotation: %+v", keys)
+	}
+
+	// The succession certificate is the old key's signature over the new
+	// public key.
+	dir := t.TempDir()
+	pub := filepath.Join(dir, "new.asc")
+	if err := os.WriteFile(pub, []byte(record.PublicKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pub+SignatureFileExt, []byte(record.Succession), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if r := old.VerifyDetached(pub, pub+SignatureFileExt); !r.Valid {
+		t.Errorf("succession certificate: %+v", r)
+	}
+	// The new key itself carries the old key's certification.
+	sigs, err := old.runGPG(nil, "--batch", "--with-colons", "--check-sigs", record.KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(sigs), old.KeyID()[len(old.KeyID())-16:]) {
+		t.Errorf("new key is not certified by the old one:\n%s", sigs)
+	}
+
+	// The new key signs.
+	pkg := signedTestPackage(t, next)
+	if r := next.VerifyDetached(pkg, pkg+SignatureFileExt); !r.Valid {
+		t.Errorf("package signed with the new key: %+v", r)
+	}
+
+	// After a restart the configured (old) key gives way to the history's.
+	reloaded, err := LoadKeyHistory(historyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewSigner(old.KeyID(), "", true, WithGnupgHome(old.gnupgHome))
+	if err := reloaded.Adopt(restarted); err != nil {
+		t.Fatal(err)
+	}
+	if !keyIDMatches(record.KeyID, restarted.KeyID()) {
+		t.Errorf("restarted signer uses %s, want %s", restarted.KeyID(), record.KeyID)
+	}
+	if k, ok := reloaded.Key(old.KeyID()[len(old.KeyID())-16:]); !ok || k.Current {
+		t.Errorf("archived key lookup by long key ID: %+v, %v", k, ok)
+	}
+}
+
+func TestRotateKeyDisabled(t *testing.T) {
+	t.Parallel()
+
+	h, err := LoadKeyHistory(filepath.Join(t.TempDir(), "key-history.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewSigner("ABCD", "", false).RotateKey(h, "", ""); err == nil {
+		t.Error("rotating a disabled signer should fail")
+	}
+	if len(h.Keys()) != 0 {
+		t.Errorf("history = %+v, want empty", h.Keys())
+	}
+}
+
+func TestLoadKeyHistoryInvalid(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "key-history.json")
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeyHistory(path); err == nil {
+		t.Error("expected an error for a corrupt history")
+	}
+}
@@ -8,10 +8,16 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+// SignatureFileExt is the extension of the detached signature SignPackage
+// writes next to a package.
+const SignatureFileExt = ".sig"
+
 // Signer handles GPG signing of packages.
 type Signer struct {
 	keyID      string
@@ -72,8 +78,78 @@ func (s *Signer) KeyID() string {
 	return s.keyID
 }
 
-// SetKeyID sets the GPG key ID.
+// KeyExpiry returns when the signing key expires, or the zero time if it
+// never does. That is the primary key's expiry, or earlier when every
+// signing-capable (sub)key that is not revoked expires before it.
+func (s *Signer) KeyExpiry() (time.Time, error) {
+	if s.keyID == "" {
+		return time.Time{}, fmt.Errorf("GPG key ID not configured")
+	}
+	args := s.buildBaseArgs()
+	args = append(args, "--batch", "--with-colons", "--fixed-list-mode", "--list-keys", s.keyID)
+	cmd := exec.Command("gpg", args...)
+	if s.gnupgHome != "" {
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+s.gnupgHome)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to list key %s: %w", s.keyID, err)
+	}
+	return parseKeyExpiry(string(out))
+}
+
+// parseKeyExpiry computes KeyExpiry from `gpg --with-colons --list-keys`
+// output for one key.
+func parseKeyExpiry(listing string) (time.Time, error) {
+	var primary, signing time.Time
+	found, canSign, signingForever := false, false, false
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 12 || (fields[0] != "pub" && fields[0] != "sub") {
+			continue
+		}
+		if fields[0] == "pub" {
+			if found {
+				break // only the first key
+			}
+			found = true
+		}
+		var expires time.Time
+		if fields[6] != "" {
+			secs, err := strconv.ParseInt(fields[6], 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid key expiry %q", fields[6])
+			}
+			expires = time.Unix(secs, 0).UTC()
+		}
+		if fields[0] == "pub" {
+			primary = expires
+		}
+		if !strings.Contains(fields[11], "s") || fields[1] == "r" {
+			continue
+		}
+		canSign = true
+		if expires.IsZero() {
+			signingForever = true
+		} else if expires.After(signing) {
+			signing = expires
+		}
+	}
+	if !found {
+		return time.Time{}, fmt.Errorf("key not found")
+	}
+	if !canSign || signingForever || (!primary.IsZero() && primary.Before(signing)) {
+		return primary, nil
+	}
+	return signing, nil
+}
+
+// SetKeyID sets the GPG key ID, dropping the cached public key of the
+// previous one.
 func (s *Signer) SetKeyID(keyID string) {
+	s.pubKeyMu.Lock()
+	s.publicKey = ""
+	s.pubKeyMu.Unlock()
 	s.keyID = keyID
 }
 
@@ -155,7 +231,7 @@ Expire-Date: 0
 `, name, email)
 
 	args := s.buildBaseArgs()
-	args = append(args, "--batch", "--gen-key")
+	args = append(args, "--batch", "--status-fd", "1", "--gen-key")
 
 	cmd := exec.Command("gpg", args...)
 	cmd.Stdin = strings.NewReader(batchConfig)
@@ -163,13 +239,22 @@ Expire-Date: 0
 		cmd.Env = append(os.Environ(), "GNUPGHOME="+s.gnupgHome)
 	}
 
-	var stderr bytes.Buffer
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
 		return "", fmt.Errorf("gpg --gen-key failed: %w, stderr: %s", err, stderr.String())
 	}
 
+	// KEY_CREATED names the new key even when the keyring already has other
+	// keys for the same email (e.g. after a rotation).
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 4 && fields[1] == "KEY_CREATED" {
+			return fields[3], nil
+		}
+	}
+
 	// Get the key ID of the newly generated key
 	return s.getLatestKeyID(email)
 }
@@ -255,14 +340,29 @@ func (s *Signer) GetPublicKey() (string, error) {
 	return s.publicKey, nil
 }
 
+// GetPublicKeyFormat returns the public key in the given format: FormatArmored
+// (.asc) or FormatBinary (.gpg, as used by keyrings and trusted.gpg.d).
+func (s *Signer) GetPublicKeyFormat(format string) ([]byte, error) {
+	key, err := s.GetPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return ConvertKey([]byte(key), format)
+}
+
 // ExportPublicKey exports the public key to a file.
 func (s *Signer) ExportPublicKey(path string) error {
-	key, err := s.GetPublicKey()
+	return s.ExportPublicKeyFormat(path, FormatArmored)
+}
+
+// ExportPublicKeyFormat exports the public key to a file in the given format.
+func (s *Signer) ExportPublicKeyFormat(path, format string) error {
+	key, err := s.GetPublicKeyFormat(format)
 	if err != nil {
 		return err
 	}
 
-	if err := os.WriteFile(path, []byte(key), 0600); err != nil {
+	if err := os.WriteFile(path, key, 0600); err != nil {
 		return fmt.Errorf("failed to write public key: %w", err)
 	}
 
@@ -344,19 +444,30 @@ func (s *Signer) SignPackage(packagePath string) error {
 		return fmt.Errorf("GPG key ID not configured")
 	}
 
-	signaturePath := packagePath + ".sig"
+	signaturePath := packagePath + SignatureFileExt
 
 	log.Printf("Signing package: %s with key %s", packagePath, s.keyID)
 
+	if err := s.signFile("--detach-sign", packagePath, signaturePath); err != nil {
+		return fmt.Errorf("failed to sign package: %w", err)
+	}
+
+	log.Printf("Package signed successfully: %s", signaturePath)
+	return nil
+}
+
+// signFile writes an armored signature of input made with mode
+// (--detach-sign or --clearsign) by the signer's key to output.
+func (s *Signer) signFile(mode, input, output string) error {
 	args := s.buildBaseArgs()
 	args = append(args,
-		"--detach-sign",
+		mode,
 		"--armor",
 		"--batch",
 		"--yes",
 		"--local-user", s.keyID,
-		"--output", signaturePath,
-		packagePath,
+		"--output", output,
+		input,
 	)
 
 	cmd := exec.Command("gpg", args...)
@@ -368,16 +479,14 @@ func (s *Signer) SignPackage(packagePath string) error {
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to sign package: %w, stderr: %s", err, stderr.String())
+		return fmt.Errorf("%w, stderr: %s", err, stderr.String())
 	}
-
-	log.Printf("Package signed successfully: %s", signaturePath)
 	return nil
 }
 
 // VerifyPackage verifies a package signature.
 func (s *Signer) VerifyPackage(packagePath string) error {
-	signaturePath := packagePath + ".sig"
+	signaturePath := packagePath + SignatureFileExt
 
 	if _, err := os.Stat(signaturePath); os.IsNotExist(err) {
 		return fmt.Errorf("signature file not found: %s", signaturePath)
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 // TestNewSigner tests creating a new GPG signer.
@@ -385,3 +386,64 @@ func TestGetPublicKeyConcurrent(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestParseKeyExpiry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		listing string
+		want    int64
+	}{
+		{"never", "pub:u:255:22:AAAA:1700000000:::u:::scSC::::::23::0:\n", 0},
+		{"primary", "pub:u:255:22:AAAA:1700000000:1800000000::u:::scSC::::::23::0:\n", 1800000000},
+		{"signing subkey expires first",
+			"pub:u:255:22:AAAA:1700000000:1900000000::u:::cC::::::23::0:\n" +
+				"sub:u:255:22:BBBB:1700000000:1800000000:::::s::::::23:\n", 1800000000},
+		{"revoked subkey ignored",
+			"pub:u:255:22:AAAA:1700000000:::u:::scSC::::::23::0:\n" +
+				"sub:r:255:22:BBBB:1700000000:1800000000:::::s::::::23:\n", 0},
+		{"only the first key",
+			"pub:u:255:22:AAAA:1700000000:::u:::scSC::::::23::0:\n" +
+				"pub:u:255:22:CCCC:1700000000:1800000000::u:::scSC::::::23::0:\n", 0},
+	}
+	for _, tt := range tests {
+		got, err := parseKeyExpiry(tt.listing)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if (tt.want == 0) != got.IsZero() || (tt.want != 0 && got.Unix() != tt.want) {
+			t.Errorf("%s: expiry = %v, want %d", tt.name, got, tt.want)
+		}
+	}
+	if _, err := parseKeyExpiry(""); err == nil {
+		t.Error("expected an error for an empty listing")
+	}
+}
+
+func TestKeyExpiry(t *testing.T) {
+	signer := newTestSigner(t, "expiry@example.com")
+	expires, err := signer.KeyExpiry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expires.IsZero() {
+		t.Errorf("expiry = %v, want never", expires)
+	}
+
+	if _, err := signer.runGPG(nil, "--batch", "--pinentry-mode", "loopback", "--passphrase", "", "--quick-set-expire", signer.KeyID(), "10d"); err != nil {
+		t.Fatal(err)
+	}
+	expires, err = signer.KeyExpiry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if days := time.Until(expires).Hours() / 24; days < 9 || days > 10 {
+		t.Errorf("key expires in %.1f days, want 10", days)
+	}
+
+	if _, err := NewSigner("", "", true).KeyExpiry(); err == nil {
+		t.Error("expected an error without a key ID")
+	}
+}
@@ -0,0 +1,212 @@
+// Package gpg provides verification of detached and gpkg-embedded package
+// signatures against the signer's key.
+package gpg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VerifyResult is the outcome of a signature verification.
+type VerifyResult struct {
+	Valid bool `json:"valid"`
+	// KeyID is the fingerprint of the key that made the signature, when gpg
+	// could tell.
+	KeyID string `json:"key_id,omitempty"`
+	// SignedAt is the signature's creation time, in RFC 3339.
+	SignedAt string `json:"signed_at,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// verifyStatus is what a `gpg --status-fd` run reported about a signature.
+type verifyStatus struct {
+	fingerprints []string // signing (sub)key and primary key fingerprints
+	keyID        string   // long key ID, when the key is unknown
+	signedAt     time.Time
+	problem      string // why the signature is not good, if it is not
+}
+
+// parseVerifyStatus parses the status lines `gpg --status-fd` writes while
+// verifying one signature.
+func parseVerifyStatus(status []byte) verifyStatus {
+	var vs verifyStatus
+	good := false
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimPrefix(scanner.Text(), "[GNUPG:] "))
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "GOODSIG":
+			good = true
+			if len(fields) > 1 {
+				vs.keyID = fields[1]
+			}
+		case "VALIDSIG":
+			// VALIDSIG <fpr> <date> <timestamp> <expire> <version> <reserved>
+			// <pubkey-algo> <hash-algo> <class> <primary-fpr>
+			if len(fields) > 1 {
+				vs.fingerprints = append(vs.fingerprints, fields[1])
+			}
+			if len(fields) > 3 {
+				vs.signedAt = parseStatusTime(fields[3])
+			}
+			if len(fields) > 10 {
+				vs.fingerprints = append(vs.fingerprints, fields[10])
+			}
+		case "BADSIG":
+			vs.problem = "bad signature: the package does not match it"
+		case "EXPSIG":
+			vs.problem = "the signature has expired"
+		case "EXPKEYSIG":
+			vs.problem = "the signing key has expired"
+		case "REVKEYSIG":
+			vs.problem = "the signing key has been revoked"
+		case "NO_PUBKEY":
+			if len(fields) > 1 {
+				vs.keyID = fields[1]
+			}
+			vs.problem = "signed by a key the server does not have: " + vs.keyID
+		case "ERRSIG":
+			if vs.problem == "" {
+				vs.problem = "the signature could not be checked"
+			}
+		case "NODATA":
+			vs.problem = "no signature found"
+		}
+	}
+	if !good && vs.problem == "" {
+		vs.problem = "no valid signature"
+	}
+	return vs
+}
+
+// parseStatusTime parses a status line timestamp: seconds since the epoch,
+// or ISO 8601 basic format (20240102T150405).
+func parseStatusTime(s string) time.Time {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC()
+	}
+	if t, err := time.Parse("20060102T150405", s); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+// isSignerKey reports whether fingerprint is the signer's key.
+func (s *Signer) isSignerKey(fingerprint string) bool {
+	return keyIDMatches(fingerprint, s.keyID)
+}
+
+// VerifyDetached verifies the detached signature at signaturePath of the
+// file at dataPath. It is valid only when it is a good signature by the
+// signer's own key; a good signature by another key in the keyring is not.
+func (s *Signer) VerifyDetached(dataPath, signaturePath string) *VerifyResult {
+	return s.verify(signaturePath, dataPath)
+}
+
+// verifyClearsigned verifies the clearsigned file at path by
+// VerifyDetached's rules.
+func (s *Signer) verifyClearsigned(path string) *VerifyResult {
+	return s.verify(path)
+}
+
+// verify runs gpg --verify on files, a detached signature and its data or
+// a clearsigned file, and checks the signature is the signer's.
+func (s *Signer) verify(files ...string) *VerifyResult {
+	if s.keyID == "" {
+		return &VerifyResult{Error: "no signing key configured"}
+	}
+
+	args := s.buildBaseArgs()
+	args = append(args, "--batch", "--status-fd", "1", "--verify")
+	args = append(args, files...)
+	cmd := exec.Command("gpg", args...)
+	if s.gnupgHome != "" {
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+s.gnupgHome)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	vs := parseVerifyStatus(stdout.Bytes())
+	result := &VerifyResult{KeyID: vs.keyID}
+	if len(vs.fingerprints) > 0 {
+		result.KeyID = vs.fingerprints[0]
+	}
+	if !vs.signedAt.IsZero() {
+		result.SignedAt = vs.signedAt.Format(time.RFC3339)
+	}
+	switch {
+	case vs.problem != "":
+		result.Error = vs.problem
+	case runErr != nil:
+		result.Error = fmt.Sprintf("gpg --verify failed: %v: %s", runErr, strings.TrimSpace(stderr.String()))
+	default:
+		for _, fpr := range vs.fingerprints {
+			if s.isSignerKey(fpr) {
+				result.Valid = true
+			}
+		}
+		if !result.Valid {
+			result.Error = fmt.Sprintf("signed by %s, not the server's key %s", result.KeyID, s.keyID)
+		}
+	}
+	return result
+}
+
+// VerifyGpkg verifies the signatures embedded in a gpkg, as SignGPKG and
+// Portage's binpkg-signing write them: each signed member X next to its
+// X.sig, and the Manifest. It is valid when the package has at least one
+// signed member, every signature is valid by VerifyDetached's rules and,
+// when the package has a Manifest, it lists every member with its size and
+// digests and, if clearsigned, is signed by the signer's key too.
+func (s *Signer) VerifyGpkg(path string) *VerifyResult {
+	dir, err := os.MkdirTemp("", "gpkg-verify-*")
+	if err != nil {
+		return &VerifyResult{Error: err.Error()}
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	members, err := extractGpkg(path, dir)
+	if err != nil {
+		return &VerifyResult{Error: err.Error()}
+	}
+	files := map[string]string{}
+	for _, m := range members {
+		if m.path != "" {
+			files[m.name] = m.path
+		}
+	}
+
+	var result *VerifyResult
+	for _, m := range members {
+		data, ok := files[strings.TrimSuffix(m.name, ".sig")]
+		if m.path == "" || !strings.HasSuffix(m.name, ".sig") || !ok {
+			continue
+		}
+		r := s.VerifyDetached(data, m.path)
+		if !r.Valid {
+			r.Error = fmt.Sprintf("%s: %s", strings.TrimSuffix(m.name, ".sig"), r.Error)
+			return r
+		}
+		result = r
+	}
+	if result == nil {
+		return &VerifyResult{Error: "the package carries no embedded signatures"}
+	}
+	if manifest, ok := files[gpkgManifest]; ok {
+		if r := s.verifyGpkgManifest(manifest, members); r != nil {
+			return r
+		}
+	}
+	return result
+}
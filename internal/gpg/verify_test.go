@@ -0,0 +1,201 @@
+package gpg
+
+import (
+	"archive/tar"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestSigner returns an enabled signer with a fresh ed25519 key in its
+// own GNUPGHOME, skipping the test when gpg is not installed.
+func newTestSigner(t *testing.T, email string) *Signer {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	// Not t.TempDir(): gpg-agent's socket path must stay short.
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+		_ = os.RemoveAll(home)
+	})
+	if err := os.Chmod(home, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) string {
+		cmd := exec.Command("gpg", append([]string{"--batch", "--homedir", home}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("gpg %v: %v\n%s", args, err, out)
+		}
+		return string(out)
+	}
+	run("--passphrase", "", "--quick-gen-key", "Test <"+email+">", "ed25519", "sign", "never")
+	fpr := ""
+	for _, line := range strings.Split(run("--with-colons", "--list-keys", email), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" && fpr == "" {
+			fpr = fields[9]
+		}
+	}
+	if fpr == "" {
+		t.Fatal("no fingerprint for the generated key")
+	}
+	return NewSigner(fpr, "", true, WithGnupgHome(home))
+}
+
+// signedTestPackage writes a package and its detached signature by signer.
+func signedTestPackage(t *testing.T, signer *Signer) string {
+	t.Helper()
+	pkg := filepath.Join(t.TempDir(), "jq-1.7.1-1.gpkg.tar")
+	if err := os.WriteFile(pkg, []byte("package contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.SignPackage(pkg); err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+func TestVerifyDetached(t *testing.T) {
+	signer := newTestSigner(t, "server@example.com")
+	pkg := signedTestPackage(t, signer)
+
+	r := signer.VerifyDetached(pkg, pkg+SignatureFileExt)
+	if !r.Valid || r.Error != "" {
+		t.Fatalf("VerifyDetached = %+v, want valid", r)
+	}
+	if !strings.EqualFold(r.KeyID, signer.KeyID()) {
+		t.Errorf("KeyID = %q, want %q", r.KeyID, signer.KeyID())
+	}
+	if r.SignedAt == "" {
+		t.Error("SignedAt not set")
+	}
+
+	// A long key ID identifies the same key.
+	long := NewSigner(signer.KeyID()[len(signer.KeyID())-16:], "", true, WithGnupgHome(signer.gnupgHome))
+	if r := long.VerifyDetached(pkg, pkg+SignatureFileExt); !r.Valid {
+		t.Errorf("long key ID: %+v, want valid", r)
+	}
+
+	if err := os.WriteFile(pkg, []byte("tampered contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if r := signer.VerifyDetached(pkg, pkg+SignatureFileExt); r.Valid || !strings.Contains(r.Error, "bad signature") {
+		t.Errorf("tampered package: %+v, want a bad signature", r)
+	}
+}
+
+func TestVerifyDetachedWrongKey(t *testing.T) {
+	server := newTestSigner(t, "server@example.com")
+	other := newTestSigner(t, "other@example.com")
+	pkg := signedTestPackage(t, other)
+
+	// The server does not know the other key at all.
+	if r := server.VerifyDetached(pkg, pkg+SignatureFileExt); r.Valid || !strings.Contains(r.Error, "does not have") {
+		t.Errorf("unknown key: %+v, want rejected", r)
+	}
+
+	// A good signature by another key in the server's keyring is no good
+	// either.
+	withOther := NewSigner(server.KeyID(), "", true, WithGnupgHome(other.gnupgHome))
+	if r := withOther.VerifyDetached(pkg, pkg+SignatureFileExt); r.Valid || !strings.Contains(r.Error, "not the server's key") {
+		t.Errorf("other key: %+v, want rejected", r)
+	}
+
+	if r := NewSigner("", "", true).VerifyDetached(pkg, pkg+SignatureFileExt); r.Valid || r.Error == "" {
+		t.Errorf("no key: %+v, want an error", r)
+	}
+}
+
+func TestVerifyGpkg(t *testing.T) {
+	signer := newTestSigner(t, "server@example.com")
+	dir := t.TempDir()
+
+	// Sign a gpkg member and pack it with its signature, as binpkg-signing
+	// does.
+	member := filepath.Join(dir, "metadata.tar.xz")
+	if err := os.WriteFile(member, []byte("metadata"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.SignPackage(member); err != nil {
+		t.Fatal(err)
+	}
+	writeGpkg := func(name string, members map[string]string) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw := tar.NewWriter(f)
+		for name, src := range members {
+			data, err := os.ReadFile(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tw.WriteHeader(&tar.Header{Name: "jq-1.7.1-1/" + name, Mode: 0644, Size: int64(len(data))}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+		return path
+	}
+
+	signed := writeGpkg("signed.gpkg.tar", map[string]string{
+		"metadata.tar.xz":     member,
+		"metadata.tar.xz.sig": member + SignatureFileExt,
+	})
+	if r := signer.VerifyGpkg(signed); !r.Valid {
+		t.Errorf("signed gpkg: %+v, want valid", r)
+	}
+
+	unsigned := writeGpkg("unsigned.gpkg.tar", map[string]string{"metadata.tar.xz": member})
+	if r := signer.VerifyGpkg(unsigned); r.Valid || !strings.Contains(r.Error, "no embedded signatures") {
+		t.Errorf("unsigned gpkg: %+v, want no signatures", r)
+	}
+}
+
+func TestParseVerifyStatus(t *testing.T) {
+	t.Parallel()
+
+	good := parseVerifyStatus([]byte(`[GNUPG:] NEWSIG
+[GNUPG:] GOODSIG 0123456789ABCDEF Test <t@example.com>
+[GNUPG:] VALIDSIG AAAA0123456789ABCDEF 2024-01-02 1704207845 0 4 0 22 10 00 BBBB0123456789ABCDEF
+`))
+	if good.problem != "" || len(good.fingerprints) != 2 || good.fingerprints[1] != "BBBB0123456789ABCDEF" {
+		t.Errorf("good signature: %+v", good)
+	}
+	if got := good.signedAt.Format("2006-01-02T15:04:05Z"); got != "2024-01-02T15:04:05Z" {
+		t.Errorf("signedAt = %s", got)
+	}
+
+	for status, want := range map[string]string{
+		"[GNUPG:] BADSIG 0123456789ABCDEF Test":    "bad signature",
+		"[GNUPG:] EXPKEYSIG 0123456789ABCDEF Test": "expired",
+		"[GNUPG:] REVKEYSIG 0123456789ABCDEF Test": "revoked",
+		"[GNUPG:] NO_PUBKEY 0123456789ABCDEF":      "0123456789ABCDEF",
+		"[GNUPG:] ERRSIG 0123456789ABCDEF 22 10":   "could not be checked",
+		"[GNUPG:] NODATA 1":                        "no signature",
+		"":                                         "no valid signature",
+	} {
+		if vs := parseVerifyStatus([]byte(status)); !strings.Contains(vs.problem, want) {
+			t.Errorf("%q: problem %q, want %q", status, vs.problem, want)
+		}
+	}
+
+	if got := parseStatusTime("20240102T150405"); got.Format("2006-01-02 15:04:05") != "2024-01-02 15:04:05" {
+		t.Errorf("ISO timestamp parsed as %v", got)
+	}
+}
@@ -0,0 +1,130 @@
+// Package httpcache provides ETag-based conditional requests for the JSON
+// status and listing endpoints the dashboard polls. The ETag is a hash of the
+// response body, so an unchanged answer costs a 304 Not Modified instead of
+// the full body.
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ETag returns the strong entity tag of body, derived from its content.
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// NotModified reports whether r's If-None-Match header matches etag.
+func NotModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Write serves body with its ETag, or 304 Not Modified without a body when
+// r already holds that version. Clients are told to revalidate every time.
+func Write(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	write(w, r, contentType, body, ETag(body))
+}
+
+func write(w http.ResponseWriter, r *http.Request, contentType string, body []byte, etag string) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if NotModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(body)
+}
+
+// WriteJSON is Write for v encoded as JSON.
+func WriteJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	WriteJSONVersion(w, r, v, nil)
+}
+
+// WriteJSONVersion is WriteJSON with the ETag derived from version instead of
+// v, for responses that carry a field changing on every call (such as a
+// generation timestamp) that must not defeat revalidation. A nil version
+// derives it from v.
+func WriteJSONVersion(w http.ResponseWriter, r *http.Request, v, version interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	etag := ETag(body)
+	if version != nil {
+		tagged, err := json.Marshal(version)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		etag = ETag(tagged)
+	}
+	write(w, r, "application/json", body, etag)
+}
+
+// Cache keeps the last successful response body of each URL a client polls,
+// so it can revalidate it with If-None-Match and skip the transfer when the
+// server answers 304.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	etag string
+	body []byte
+}
+
+// Do sends req with client, revalidating the cached copy of its URL, and
+// returns the response status and body. A 304 is returned as 200 with the
+// cached body.
+func (c *Cache) Do(client *http.Client, req *http.Request) (int, []byte, error) {
+	key := req.URL.String()
+	c.mu.Lock()
+	cached, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotModified && ok {
+		return http.StatusOK, cached.body, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if etag := resp.Header.Get("ETag"); resp.StatusCode == http.StatusOK && etag != "" {
+		c.mu.Lock()
+		if c.entries == nil {
+			c.entries = make(map[string]entry)
+		}
+		c.entries[key] = entry{etag: etag, body: body}
+		c.mu.Unlock()
+	}
+	return resp.StatusCode, body, nil
+}
@@ -0,0 +1,76 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWriteNotModified(t *testing.T) {
+	body := []byte(`{"builds":3}` + "\n")
+	w := httptest.NewRecorder()
+	Write(w, httptest.NewRequest(http.MethodGet, "/", nil), "application/json", body)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Body.String() != string(body) {
+		t.Fatalf("first response: %d etag %q body %q", w.Code, etag, w.Body.String())
+	}
+
+	for _, header := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("If-None-Match", header)
+		w = httptest.NewRecorder()
+		Write(w, req, "application/json", body)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: got %d with %d bytes, want an empty 304", header, w.Code, w.Body.Len())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	w = httptest.NewRecorder()
+	Write(w, req, "application/json", body)
+	if w.Code != http.StatusOK {
+		t.Errorf("stale tag: got %d, want 200", w.Code)
+	}
+}
+
+func TestWriteJSONVersion(t *testing.T) {
+	tag := func(v, version interface{}) string {
+		w := httptest.NewRecorder()
+		WriteJSONVersion(w, httptest.NewRequest(http.MethodGet, "/", nil), v, version)
+		return w.Header().Get("ETag")
+	}
+	if tag(map[string]int{"at": 1}, "v1") != tag(map[string]int{"at": 2}, "v1") {
+		t.Error("ETag changed with the body although the version did not")
+	}
+	if tag(map[string]int{"at": 1}, nil) == tag(map[string]int{"at": 2}, nil) {
+		t.Error("ETag did not follow the body without a version")
+	}
+}
+
+func TestCacheRevalidates(t *testing.T) {
+	var full, notModified atomic.Int32
+	body := []byte(`["job-1"]` + "\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == ETag(body) {
+			notModified.Add(1)
+		} else {
+			full.Add(1)
+		}
+		Write(w, r, "application/json", body)
+	}))
+	defer srv.Close()
+
+	var cache Cache
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/list", nil)
+		code, got, err := cache.Do(srv.Client(), req)
+		if err != nil || code != http.StatusOK || string(got) != string(body) {
+			t.Fatalf("request %d: %d %q %v", i, code, got, err)
+		}
+	}
+	if full.Load() != 1 || notModified.Load() != 2 {
+		t.Errorf("server sent %d full bodies and %d 304s, want 1 and 2", full.Load(), notModified.Load())
+	}
+}
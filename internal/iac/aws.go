@@ -0,0 +1,86 @@
+// Package iac provides AWS instance specifications, including spot instances.
+package iac
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// AWSInstanceSpec defines the specification for an AWS builder instance.
+type AWSInstanceSpec struct {
+	InstanceType string `json:"instance_type"`
+	AMI          string `json:"ami"` // Prebuilt AMI; empty resolves the latest Ubuntu 22.04
+	// Spot requests a spot instance instead of an on-demand one. AWS can
+	// reclaim it at two minutes' notice; the deployed builder watches for
+	// that notice and fails its jobs so they can be rebuilt elsewhere.
+	Spot bool `json:"spot"`
+	// MaxSpotPrice caps the spot price in USD/hour, e.g. "0.05". Empty pays
+	// up to the on-demand price.
+	MaxSpotPrice string `json:"max_spot_price"`
+}
+
+// AWSInstanceSpecFromMap creates an AWSInstanceSpec from a machine spec map,
+// defaulting the instance type for arch.
+func AWSInstanceSpecFromMap(m map[string]string, arch string) *AWSInstanceSpec {
+	spec := &AWSInstanceSpec{
+		InstanceType: getOrDefault(m, "instance_type", awsInstanceTypeForArch(arch)),
+		AMI:          m["ami"],
+		MaxSpotPrice: m["max_spot_price"],
+	}
+	if v := m["spot"]; v != "" {
+		spec.Spot = v == "true" || v == "1" || v == "yes"
+	}
+	return spec
+}
+
+// ValidateAWSSpec validates an AWS instance specification.
+func ValidateAWSSpec(spec *AWSInstanceSpec) error {
+	if spec.InstanceType == "" {
+		return fmt.Errorf("instance_type is required")
+	}
+	if spec.MaxSpotPrice != "" {
+		if !spec.Spot {
+			return fmt.Errorf("max_spot_price is set but spot is not enabled")
+		}
+		if price, err := strconv.ParseFloat(spec.MaxSpotPrice, 64); err != nil || price <= 0 {
+			return fmt.Errorf("invalid max_spot_price %q: want a USD/hour price such as 0.05", spec.MaxSpotPrice)
+		}
+	}
+	return nil
+}
+
+// maxSpotPrice returns the spot price cap in USD/hour, or 0 when there is
+// none.
+func (s *AWSInstanceSpec) maxSpotPrice() float64 {
+	if !s.Spot {
+		return 0
+	}
+	price, err := strconv.ParseFloat(s.MaxSpotPrice, 64)
+	if err != nil || price <= 0 {
+		return 0
+	}
+	return price
+}
+
+// marketOptionsBlock returns the aws_instance block requesting a one-time
+// spot instance, which is terminated rather than stopped when reclaimed, or
+// "" for an on-demand instance.
+func (s *AWSInstanceSpec) marketOptionsBlock() string {
+	if !s.Spot {
+		return ""
+	}
+	maxPrice := ""
+	if s.MaxSpotPrice != "" {
+		maxPrice = fmt.Sprintf("\n      max_price                      = %q", s.MaxSpotPrice)
+	}
+	return fmt.Sprintf(`
+  instance_market_options {
+    market_type = "spot"
+
+    spot_options {%s
+      spot_instance_type             = "one-time"
+      instance_interruption_behavior = "terminate"
+    }
+  }
+`, maxPrice)
+}
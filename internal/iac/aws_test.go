@@ -0,0 +1,148 @@
+package iac
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAWSInstanceSpecFromMap(t *testing.T) {
+	t.Parallel()
+
+	spec := AWSInstanceSpecFromMap(map[string]string{"spot": "yes", "max_spot_price": "0.05", "ami": "ami-1"}, "arm64")
+	if spec.InstanceType != "t4g.large" || spec.AMI != "ami-1" || !spec.Spot || spec.MaxSpotPrice != "0.05" {
+		t.Errorf("spec = %+v", spec)
+	}
+	if spec := AWSInstanceSpecFromMap(nil, "amd64"); spec.Spot || spec.InstanceType != "t3.large" {
+		t.Errorf("default spec = %+v, want on-demand t3.large", spec)
+	}
+}
+
+func TestValidateAWSSpec(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		spec    AWSInstanceSpec
+		wantErr string
+	}{
+		{"on-demand", AWSInstanceSpec{InstanceType: "t3.large"}, ""},
+		{"spot", AWSInstanceSpec{InstanceType: "t3.large", Spot: true}, ""},
+		{"spot with cap", AWSInstanceSpec{InstanceType: "t3.large", Spot: true, MaxSpotPrice: "0.05"}, ""},
+		{"cap without spot", AWSInstanceSpec{InstanceType: "t3.large", MaxSpotPrice: "0.05"}, "spot is not enabled"},
+		{"invalid cap", AWSInstanceSpec{InstanceType: "t3.large", Spot: true, MaxSpotPrice: "cheap"}, "invalid max_spot_price"},
+		{"no type", AWSInstanceSpec{}, "instance_type is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAWSSpec(&tt.spec)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("ValidateAWSSpec() = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("ValidateAWSSpec() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateAWSConfigSpot(t *testing.T) {
+	t.Parallel()
+	m := NewManager()
+
+	spot := m.generateAWSConfig(&ProvisionRequest{
+		Provider: "aws",
+		Arch:     "amd64",
+		Spec:     map[string]string{"spot": "true", "max_spot_price": "0.04"},
+	}, "", "us-east-1", "")
+	for _, want := range []string{
+		"instance_market_options {",
+		`market_type = "spot"`,
+		`max_price                      = "0.04"`,
+		`spot_instance_type             = "one-time"`,
+		`instance_interruption_behavior = "terminate"`,
+	} {
+		if !strings.Contains(spot, want) {
+			t.Errorf("spot config missing %q", want)
+		}
+	}
+
+	uncapped := m.generateAWSConfig(&ProvisionRequest{Provider: "aws", Spec: map[string]string{"spot": "true"}}, "", "us-east-1", "")
+	if !strings.Contains(uncapped, "instance_market_options") || strings.Contains(uncapped, "max_price") {
+		t.Error("spot without a price cap should request spot without max_price")
+	}
+
+	onDemand := m.generateAWSConfig(&ProvisionRequest{Provider: "aws"}, "", "us-east-1", "")
+	if strings.Contains(onDemand, "instance_market_options") {
+		t.Error("on-demand config requests a spot instance")
+	}
+}
+
+func TestGenerateTerraformConfigInvalidSpotPrice(t *testing.T) {
+	t.Parallel()
+	m := NewManager()
+
+	_, err := m.generateTerraformConfig(&ProvisionRequest{
+		Provider: "aws",
+		Spec:     map[string]string{"region": "us-east-1", "spot": "true", "max_spot_price": "-1"},
+	}, "")
+	if err == nil || !strings.Contains(err.Error(), "max_spot_price") {
+		t.Errorf("generateTerraformConfig() = %v, want the max_spot_price error", err)
+	}
+}
+
+func TestDeploymentScriptSpotCheck(t *testing.T) {
+	t.Parallel()
+	m := NewManager()
+
+	spot := m.generateDeploymentScript(&ProvisionRequest{Provider: "aws", Spec: map[string]string{"spot": "true"}}, "aws-1")
+	if !strings.Contains(spot, "SPOT_INTERRUPTION_CHECK=true") {
+		t.Error("spot instance builder does not watch for interruptions")
+	}
+	onDemand := m.generateDeploymentScript(&ProvisionRequest{Provider: "aws"}, "aws-2")
+	if strings.Contains(onDemand, "SPOT_INTERRUPTION_CHECK") {
+		t.Error("on-demand builder watches for spot interruptions")
+	}
+}
+
+func TestGenerateConfigUserData(t *testing.T) {
+	t.Parallel()
+	m := NewManager()
+	unescaped := regexp.MustCompile(`(^|[^$])\$\{|(^|[^%])%\{`)
+
+	req := &ProvisionRequest{Provider: "aws", Arch: "amd64", BuilderPort: 9090, ServerCallback: "http://server:8080"}
+	for name, tf := range map[string]string{
+		"aws":    m.generateAWSConfig(req, "aws-1", "us-east-1", ""),
+		"aliyun": m.generateAliyunConfig(&ProvisionRequest{Provider: "aliyun", Arch: "amd64", ServerCallback: "http://server:8080"}, "aliyun-1", "cn-hangzhou", ""),
+	} {
+		if !strings.Contains(tf, "user_data = <<-CLOUDINIT\n#!/bin/bash\n") {
+			t.Errorf("%s config has no bootstrap user data", name)
+		}
+		if !strings.Contains(tf, "INSTANCE_ID_VAL='"+name+"-1'") || !strings.Contains(tf, "http://server:8080") {
+			t.Errorf("%s user data does not register the instance with the server", name)
+		}
+		if unescaped.MatchString(tf) || !strings.Contains(tf, "INSTANCE_ID=$${INSTANCE_ID_VAL}") {
+			t.Errorf("%s user data is not escaped for the heredoc", name)
+		}
+	}
+
+	// Files only SSH can push, and spec "bootstrap" = "ssh", leave the
+	// deployment to deployBuilder.
+	for _, req := range []*ProvisionRequest{
+		{Provider: "aws", Spec: map[string]string{"bootstrap": "ssh"}},
+		{Provider: "aws", BuilderBinaryPath: "/usr/bin/portage-builder"},
+		{Provider: "aws", GPGSecretKey: []byte("key")},
+		{Provider: "aws", DockerDownloadMirror: "https://mirror.example.com/docker-ce"},
+		{Provider: "aws", BuildMode: "native-gentoo"},
+	} {
+		if tf := m.generateAWSConfig(req, "aws-2", "us-east-1", ""); strings.Contains(tf, "user_data") {
+			t.Errorf("user data for %+v", req)
+		}
+	}
+	if m.selfBootstrapScript(&ProvisionRequest{Provider: "aws", MakeConfExtra: strings.Repeat("#", awsUserDataLimit)}, "aws-3") != "" {
+		t.Error("user data over the EC2 limit")
+	}
+	if m.selfBootstrapScript(&ProvisionRequest{Provider: "hetzner"}, "hetzner-1") != "" {
+		t.Error("user data for a provider deploying over SSH")
+	}
+}
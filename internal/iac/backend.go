@@ -0,0 +1,130 @@
+// Package iac provides the remote Terraform state backends instances can
+// keep their state in.
+package iac
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backendFile is the workspace file Provision writes a remote backend to.
+const backendFile = "backend.tf"
+
+// StateBackend configures remote, locked Terraform state: GCS for GCP, S3
+// with a DynamoDB lock table for AWS, OSS with a TableStore lock table for
+// Aliyun. A provider's backend is used once its bucket is set; the state of
+// other providers' instances stays in their local workspace.
+type StateBackend struct {
+	// Prefix starts the state path of every instance, followed by its ID.
+	Prefix string
+	// GCSBucket holds GCP state; GCS locks it natively.
+	GCSBucket string
+	// S3Bucket holds AWS state in S3Region (the instance's region when
+	// unset), locked in S3DynamoDBTable.
+	S3Bucket        string
+	S3Region        string
+	S3DynamoDBTable string
+	// OSSBucket holds Aliyun state in OSSRegion (the instance's region when
+	// unset), locked in OSSTablestoreTable at OSSTablestoreEndpoint.
+	OSSBucket             string
+	OSSRegion             string
+	OSSTablestoreEndpoint string
+	OSSTablestoreTable    string
+	// LockTimeout is how long terraform waits for a lock held by another
+	// process; 0 fails at once.
+	LockTimeout time.Duration
+}
+
+// WithStateBackend keeps instance state in the remote backends b configures.
+func WithStateBackend(b StateBackend) ManagerOption {
+	return func(m *Manager) {
+		m.stateBackend = b
+	}
+}
+
+// backendType returns the Terraform backend type provider's state is kept
+// in, or "" for the local workspace.
+func (b *StateBackend) backendType(provider string) string {
+	switch {
+	case provider == "gcp" && b.GCSBucket != "":
+		return "gcs"
+	case provider == "aws" && b.S3Bucket != "":
+		return "s3"
+	case provider == "aliyun" && b.OSSBucket != "":
+		return "oss"
+	}
+	return ""
+}
+
+// statePath returns where in its bucket the state of instanceID is kept.
+func (b *StateBackend) statePath(instanceID string) string {
+	return path.Join(b.Prefix, instanceID)
+}
+
+// backendConfig renders the terraform block keeping the state of instanceID,
+// created in region by provider, in its remote backend, or "" when the
+// provider has none configured.
+func (b *StateBackend) backendConfig(provider, instanceID, region string) string {
+	// Settings are rendered HCL; unset ones are left out.
+	str := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return strconv.Quote(s)
+	}
+	backend := b.backendType(provider)
+	var settings [][2]string
+	switch backend {
+	case "gcs":
+		settings = [][2]string{{"bucket", str(b.GCSBucket)}, {"prefix", str(b.statePath(instanceID))}}
+	case "s3":
+		if b.S3Region != "" {
+			region = b.S3Region
+		}
+		settings = [][2]string{
+			{"bucket", str(b.S3Bucket)},
+			{"key", str(b.statePath(instanceID) + "/terraform.tfstate")},
+			{"region", str(region)},
+			{"dynamodb_table", str(b.S3DynamoDBTable)},
+			{"encrypt", "true"},
+		}
+	case "oss":
+		if b.OSSRegion != "" {
+			region = b.OSSRegion
+		}
+		settings = [][2]string{
+			{"bucket", str(b.OSSBucket)},
+			{"prefix", str(b.statePath(instanceID))},
+			{"key", str("terraform.tfstate")},
+			{"region", str(region)},
+			{"tablestore_endpoint", str(b.OSSTablestoreEndpoint)},
+			{"tablestore_table", str(b.OSSTablestoreTable)},
+			{"encrypt", "true"},
+		}
+	default:
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Generated by Portage Engine IaC\n\nterraform {\n  backend %q {\n", backend)
+	for _, s := range settings {
+		if s[1] != "" {
+			fmt.Fprintf(&sb, "    %-19s = %s\n", s[0], s[1])
+		}
+	}
+	sb.WriteString("  }\n}\n")
+	return sb.String()
+}
+
+// lockArgs returns the arguments making a state-changing terraform command
+// wait LockTimeout for a lock held elsewhere on the remote state of an
+// instance, or none for local state.
+func (b *StateBackend) lockArgs(backend string) []string {
+	if backend == "" || b.LockTimeout <= 0 {
+		return nil
+	}
+	return []string{"-lock-timeout=" + b.LockTimeout.String()}
+}
@@ -0,0 +1,93 @@
+package iac
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackendConfig(t *testing.T) {
+	t.Parallel()
+
+	b := &StateBackend{
+		Prefix:                "portage-engine",
+		GCSBucket:             "gcs-state",
+		S3Bucket:              "s3-state",
+		S3DynamoDBTable:       "tf-locks",
+		OSSBucket:             "oss-state",
+		OSSRegion:             "cn-shanghai",
+		OSSTablestoreEndpoint: "https://locks.cn-shanghai.ots.aliyuncs.com",
+		OSSTablestoreTable:    "tf_locks",
+	}
+	for _, tt := range []struct {
+		provider, region string
+		want             []string
+	}{
+		{"gcp", "us-central1", []string{`backend "gcs"`, `bucket              = "gcs-state"`, `prefix              = "portage-engine/inst-1"`}},
+		{"aws", "eu-west-1", []string{`backend "s3"`, `key                 = "portage-engine/inst-1/terraform.tfstate"`, `region              = "eu-west-1"`, `dynamodb_table      = "tf-locks"`, `encrypt             = true`}},
+		{"aliyun", "cn-hangzhou", []string{`backend "oss"`, `region              = "cn-shanghai"`, `tablestore_table    = "tf_locks"`, `prefix              = "portage-engine/inst-1"`}},
+	} {
+		got := b.backendConfig(tt.provider, "inst-1", tt.region)
+		for _, want := range tt.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s backend lacks %s:\n%s", tt.provider, want, got)
+			}
+		}
+	}
+	for _, provider := range []string{"hetzner", "pve"} {
+		if got := b.backendConfig(provider, "inst-1", ""); got != "" {
+			t.Errorf("%s backend = %q, want none", provider, got)
+		}
+	}
+	if got := (&StateBackend{GCSBucket: "gcs-state"}).backendType("aws"); got != "" {
+		t.Errorf("backendType(aws) without an S3 bucket = %q", got)
+	}
+}
+
+func TestGCPConfigRemoteState(t *testing.T) {
+	t.Parallel()
+
+	req := &ProvisionRequest{Provider: "gcp", Arch: "amd64", BuilderPort: 9090}
+	local := NewManager()
+	if tf := local.generateGCPConfig(req, "us-central1", ""); !strings.Contains(tf, `backend "local"`) {
+		t.Error("GCP config without a remote backend lacks the local one")
+	}
+	remote := NewManager(WithStateBackend(StateBackend{GCSBucket: "gcs-state"}))
+	if tf := remote.generateGCPConfig(req, "us-central1", ""); strings.Contains(tf, "backend") {
+		t.Error("GCP config with a remote backend declares another")
+	}
+}
+
+func TestProvisionRemoteState(t *testing.T) {
+	calls := planTerraform(t, false)
+	m := NewManager(WithStateBackend(StateBackend{Prefix: "pe", S3Bucket: "s3-state", S3DynamoDBTable: "tf-locks", LockTimeout: 2 * time.Minute}))
+	m.workspaceDir = t.TempDir()
+
+	inst, err := m.Provision(&ProvisionRequest{Provider: "aws", Arch: "amd64", Spec: map[string]string{"bootstrap": "ssh", "region": "eu-west-1"}})
+	if err != nil {
+		t.Fatalf("Provision() = %v", err)
+	}
+	if inst.StateBackend != "s3" {
+		t.Errorf("StateBackend = %q, want s3", inst.StateBackend)
+	}
+	backend, err := os.ReadFile(filepath.Join(inst.TerraformDir, backendFile))
+	if err != nil || !strings.Contains(string(backend), `key                 = "pe/`+inst.ID+`/terraform.tfstate"`) {
+		t.Errorf("backend.tf = %s (%v)", backend, err)
+	}
+	if err := m.Terminate(inst.ID); err != nil {
+		t.Fatalf("Terminate() = %v", err)
+	}
+
+	got, _ := os.ReadFile(calls)
+	want := strings.Join([]string{
+		"init -no-color",
+		"plan -json -input=false -no-color -out=plan.tfplan -lock-timeout=2m0s",
+		"apply -no-color -input=false -lock-timeout=2m0s plan.tfplan",
+	}, "\n")
+	if !strings.Contains(string(got), want) ||
+		!strings.HasSuffix(string(got), "init -no-color -input=false\ndestroy -no-color -auto-approve -lock-timeout=2m0s\n") {
+		t.Errorf("terraform calls = %s", got)
+	}
+}
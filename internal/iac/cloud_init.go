@@ -13,8 +13,11 @@ type CloudInitConfig struct {
 	DockerRegistry  string `json:"docker_registry"`
 	PullLatestImage bool   `json:"pull_latest_image"`
 
-	// Portage configuration
+	// Portage configuration. With Prebaked (a builder image that already
+	// carries Docker, the stage3 image and a portage tree), the tree sync is
+	// skipped when a tree is already present.
 	PortageTreeSync bool   `json:"portage_tree_sync"`
+	Prebaked        bool   `json:"prebaked"`
 	PortageMirror   string `json:"portage_mirror"`
 	// Mirror acceleration (all optional). AptMirror rewrites the guest's apt
 	// sources; DockerDownloadMirror feeds DOWNLOAD_URL of the vendored
@@ -56,6 +59,12 @@ type CloudInitConfig struct {
 	// the builder's BUILD_FEATURES env (default "-userpriv -usersandbox" for
 	// Docker builds; empty for a native Gentoo VM).
 	BuildFeatures string
+	// SpotInstance makes the builder watch for the EC2 spot interruption
+	// notice and fail its jobs before the instance is reclaimed.
+	SpotInstance bool
+	// CacheVolumeDevice, when set, is a device path (shell glob allowed) of
+	// an attached volume mounted over DataDir to hold the build cache.
+	CacheVolumeDevice string
 }
 
 // DefaultCloudInitConfig returns the default cloud initialization configuration.
@@ -81,6 +90,17 @@ func DefaultCloudInitConfig() *CloudInitConfig {
 	}
 }
 
+// instanceIDAssignment returns the shell value for INSTANCE_ID_VAL: the
+// provisioner-assigned instance ID, single-quoted, so the builder registers
+// under the same ID the IaC manager tracks it by; or the unquoted $(hostname)
+// command substitution when none was assigned.
+func instanceIDAssignment(config *CloudInitConfig) string {
+	if config.InstanceID != "" {
+		return shellSingleQuote(config.InstanceID)
+	}
+	return "$(hostname)"
+}
+
 // shellSingleQuote wraps s in single quotes for safe use as a shell word,
 // escaping any embedded single quotes.
 func shellSingleQuote(s string) string {
@@ -94,6 +114,16 @@ func heredocEscape(s string) string {
 	return r.Replace(s)
 }
 
+// terraformHeredocEscape escapes a script for a Terraform (unquoted) heredoc:
+// Terraform interprets ${ and %{ as interpolation/template sequences, so they
+// must be escaped to $${ and %%{ respectively. Terraform has no shell-style
+// quoted-heredoc (<<-'DELIM') syntax, so we must escape rather than rely on
+// quoting.
+func terraformHeredocEscape(script string) string {
+	escaped := strings.ReplaceAll(script, "${", "$${")
+	return strings.ReplaceAll(escaped, "%{", "%%{")
+}
+
 // marchForArch returns a safe, portable -march baseline for the given arch.
 // It never returns "native": a binhost farm must produce packages that run on
 // any consumer of that arch, not just the exact CPU that happened to build them.
@@ -277,6 +307,24 @@ systemctl restart docker
 `, config.DockerRegistryMirror, insecureLine)
 	}
 
+	if config.CacheVolumeDevice != "" {
+		fmt.Fprintf(&sb, `# Mount the build cache volume
+CACHE_DEV="$(ls %s 2>/dev/null | head -n1)"
+if [ -n "$CACHE_DEV" ]; then
+    mkdir -p %s
+    if ! mountpoint -q %s; then
+        blkid "$CACHE_DEV" >/dev/null 2>&1 || mkfs.ext4 -q "$CACHE_DEV"
+        mount "$CACHE_DEV" %s
+        grep -q "^$CACHE_DEV " /etc/fstab || echo "$CACHE_DEV %s ext4 defaults,nofail 0 2" >> /etc/fstab
+    fi
+    log "Build cache volume $CACHE_DEV mounted at %s"
+else
+    log "WARNING: build cache volume not found; using the root disk"
+fi
+
+`, config.CacheVolumeDevice, config.DataDir, config.DataDir, config.DataDir, config.DataDir, config.DataDir)
+	}
+
 	// Create directories
 	fmt.Fprintf(&sb, `# Create directories
 log "Creating directories..."
@@ -313,8 +361,17 @@ setup_swap
 		dockerImage = config.DockerRegistry + "/" + config.DockerImage
 	}
 
+	// Without PullLatestImage an image already present locally (baked into a
+	// prebuilt builder image) is reused as-is.
+	pullGuard := ""
+	if !config.PullLatestImage {
+		pullGuard = fmt.Sprintf(`if docker image inspect %s >/dev/null 2>&1; then
+    log "Docker image %s already present; skipping pull"
+else
+`, dockerImage, dockerImage)
+	}
 	fmt.Fprintf(&sb, `# Pull Gentoo Docker image
-log "Pulling Docker image: %s"
+%slog "Pulling Docker image: %s"
 for i in 1 2 3; do
     docker pull %s && break
     [ $i -eq 3 ] && error_exit "Failed to pull Docker image after 3 attempts"
@@ -322,8 +379,8 @@ for i in 1 2 3; do
     sleep 10
 done
 log "Docker image pulled successfully"
-
-`, dockerImage, dockerImage)
+%s
+`, pullGuard, dockerImage, dockerImage, closeGuard(pullGuard))
 
 	// Setup Portage configuration.
 	//
@@ -397,6 +454,14 @@ log "Container /etc/portage prepared"
 	// emerge-webrsync (honors GENTOO_MIRRORS, so an internal mirror makes this
 	// a LAN download), falling back to plain emerge --sync.
 	if config.PortageTreeSync {
+		treeGuard := ""
+		if config.Prebaked {
+			treeGuard = fmt.Sprintf(`if [ -e %s/repos/gentoo/metadata/timestamp.chk ]; then
+    log "Portage tree already present in image; skipping sync"
+else
+`, config.DataDir)
+		}
+		sb.WriteString(treeGuard)
 		if config.PortageSyncMethod == "rsync" && config.PortageSyncURI != "" {
 			fmt.Fprintf(&sb, `# Sync Portage tree from custom sync-uri
 log "Syncing Portage tree from custom sync-uri..."
@@ -433,6 +498,7 @@ log "Portage tree sync complete"
 
 `, config.DataDir, shellSingleQuote(config.PortageMirror), dockerImage)
 		}
+		sb.WriteString(closeGuard(treeGuard))
 	}
 
 	// Download builder binary if URL provided
@@ -458,12 +524,7 @@ log "Builder binary downloaded"
 	// generated make.conf in DataDir, so the build container mounts real content
 	// instead of empty host dirs (/var/db/repos, /etc/portage don't exist on the
 	// Ubuntu/CentOS host).
-	// INSTANCE_ID_VAL is a shell assignment: single-quote a supplied value, or
-	// use the unquoted $(hostname) command substitution when unset.
-	instanceIDAssign := "$(hostname)"
-	if config.InstanceID != "" {
-		instanceIDAssign = shellSingleQuote(config.InstanceID)
-	}
+	instanceIDAssign := instanceIDAssignment(config)
 
 	// The remaining values are embedded inside an unquoted heredoc, so they must
 	// be escaped so $, `, and \ land literally (not shell-expanded).
@@ -478,6 +539,9 @@ log "Builder binary downloaded"
 	if config.BuildFeatures != "" {
 		gpgLines += fmt.Sprintf("BUILD_FEATURES=%s\n", heredocEscape(config.BuildFeatures))
 	}
+	if config.SpotInstance {
+		gpgLines += "SPOT_INTERRUPTION_CHECK=true\n"
+	}
 
 	if config.GPGKeyID != "" {
 		sb.WriteString(`# Import binhost signing key (pushed by the deploy step)
@@ -684,3 +748,12 @@ func makeConfExtraBlock(config *CloudInitConfig) string {
 	}
 	return "\n# --- operator-supplied build configuration (dashboard) ---\n" + heredocEscape(config.MakeConfExtra) + "\n"
 }
+
+// closeGuard returns the "fi" terminating an optional shell if-guard opened by
+// the caller, or "" when no guard was emitted.
+func closeGuard(guard string) string {
+	if guard == "" {
+		return ""
+	}
+	return "fi\n"
+}
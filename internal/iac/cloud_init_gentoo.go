@@ -102,7 +102,7 @@ log "Signing store ready"
 	}
 	fmt.Fprintf(&sb, `
 log "Writing builder configuration (native mode)..."
-INSTANCE_ID_VAL=$(hostname)
+INSTANCE_ID_VAL=%s
 cat > /etc/portage-engine/builder.conf <<BUILDERCONF
 BUILDER_PORT=%d
 INSTANCE_ID=${INSTANCE_ID_VAL}
@@ -119,7 +119,7 @@ PORTAGE_CONF_PATH=/etc/portage
 MAKE_CONF_PATH=/etc/portage/make.conf
 BUILDERCONF
 
-`, config.BuilderPort, heredocEscape(arch), heredocEscape(config.ServerCallbackURL), tokenLine)
+`, instanceIDAssignment(config), config.BuilderPort, heredocEscape(arch), heredocEscape(config.ServerCallbackURL), tokenLine)
 
 	// systemd unit (no docker dependency).
 	sb.WriteString(`log "Installing systemd service..."
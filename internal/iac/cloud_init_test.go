@@ -157,6 +157,36 @@ func TestGenerateCloudInitScript_NoPortageSync(t *testing.T) {
 	}
 }
 
+func TestGenerateCloudInitScript_Prebaked(t *testing.T) {
+	t.Parallel()
+
+	config := DefaultCloudInitConfig()
+	config.PullLatestImage = false
+	config.Prebaked = true
+
+	script := GenerateCloudInitScript(config)
+
+	for _, want := range []string{
+		"if docker image inspect gentoo/stage3:latest",
+		"already present; skipping pull",
+		"if [ -e /var/lib/portage-engine/repos/gentoo/metadata/timestamp.chk ]",
+		"Portage tree already present in image; skipping sync",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("prebaked script missing %q", want)
+		}
+	}
+	if strings.Count(script, "\nfi\n") < 2 {
+		t.Error("prebaked guards are not closed")
+	}
+
+	// The default (stock image) always pulls and syncs.
+	def := GenerateCloudInitScript(DefaultCloudInitConfig())
+	if strings.Contains(def, "skipping pull") || strings.Contains(def, "skipping sync") {
+		t.Error("default script should not guard the pull or tree sync")
+	}
+}
+
 func TestGenerateCloudInitScript_NoBuilderBinary(t *testing.T) {
 	t.Parallel()
 
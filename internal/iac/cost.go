@@ -0,0 +1,111 @@
+package iac
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Approximate on-demand USD/hour list prices (us-east-1 / us-central1) for the
+// instance types the generators default to and their common neighbours. They
+// are deliberately coarse: the estimate exists to enforce a budget cap, not to
+// reconcile a bill. Operators price other types with WithHourlyPrices.
+var (
+	awsHourlyPrices = map[string]float64{
+		"t3.medium":   0.0416,
+		"t3.large":    0.0832,
+		"t3.xlarge":   0.1664,
+		"t3.2xlarge":  0.3328,
+		"t4g.medium":  0.0336,
+		"t4g.large":   0.0672,
+		"t4g.xlarge":  0.1344,
+		"c5.xlarge":   0.17,
+		"c5.2xlarge":  0.34,
+		"c5.4xlarge":  0.68,
+		"c6g.xlarge":  0.136,
+		"c6g.2xlarge": 0.272,
+		"m5.xlarge":   0.192,
+		"m5.2xlarge":  0.384,
+	}
+	gcpHourlyPrices = map[string]float64{
+		"n1-standard-1":  0.0475,
+		"n1-standard-2":  0.095,
+		"n1-standard-4":  0.19,
+		"n1-standard-8":  0.38,
+		"n1-standard-16": 0.76,
+		"n1-highmem-2":   0.1184,
+		"n1-highmem-4":   0.2368,
+		"n1-highmem-8":   0.4736,
+		"n1-highcpu-2":   0.0709,
+		"n1-highcpu-4":   0.1418,
+		"n1-highcpu-8":   0.2836,
+		"e2-micro":       0.0084,
+		"e2-small":       0.0168,
+		"e2-medium":      0.0335,
+		"e2-standard-2":  0.067,
+		"e2-standard-4":  0.134,
+		"e2-standard-8":  0.268,
+		"c2-standard-4":  0.2088,
+		"c2-standard-8":  0.4176,
+		"c2-standard-16": 0.8352,
+	}
+	// Hetzner bills in EUR; these are the list prices converted at roughly
+	// 1.1 USD/EUR.
+	hetznerHourlyPrices = map[string]float64{
+		"cx22":  0.0066,
+		"cx32":  0.0121,
+		"cx42":  0.0291,
+		"cx52":  0.0582,
+		"cpx21": 0.0149,
+		"cpx31": 0.0281,
+		"cpx41": 0.0516,
+		"cpx51": 0.1076,
+		"cax21": 0.0121,
+		"cax31": 0.0242,
+		"cax41": 0.0483,
+		"ccx23": 0.0527,
+		"ccx33": 0.1043,
+	}
+)
+
+// ErrUnpricedInstance reports an instance type with no known hourly price.
+var ErrUnpricedInstance = errors.New("no hourly price for instance type")
+
+// EstimateHourlyCost returns the estimated USD/hour cost of the instance a
+// provision request would create. prices, keyed by instance type, comes from
+// operator config and overrides the built-in price table; the request spec
+// cannot set a price, since it is supplied by whoever submits the build. An
+// AWS spot instance's max_spot_price caps the cost, as AWS enforces it.
+// Instance types priced nowhere return ErrUnpricedInstance rather than a
+// guess, so a large unknown type cannot pass for a cheap one. Aliyun has no
+// built-in prices, so its instances need an operator price; only PVE (own
+// hardware) costs nothing.
+func EstimateHourlyCost(req *ProvisionRequest, prices map[string]float64) (float64, error) {
+	var instanceType string
+	var table map[string]float64
+	switch req.Provider {
+	case "aws":
+		// A spot instance never costs more than its price cap.
+		if price := AWSInstanceSpecFromMap(req.Spec, req.Arch).maxSpotPrice(); price > 0 {
+			return price, nil
+		}
+		instanceType, table = getOrDefault(req.Spec, "instance_type", awsInstanceTypeForArch(req.Arch)), awsHourlyPrices
+	case "gcp":
+		instanceType, table = getOrDefault(req.Spec, "machine_type", DefaultGCPInstanceSpec().MachineType), gcpHourlyPrices
+	case "hetzner":
+		instanceType, table = getOrDefault(req.Spec, "server_type", hetznerServerTypeForArch(req.Arch)), hetznerHourlyPrices
+	case "aliyun":
+		instanceType = aliyunInstanceType
+	case "pve":
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown provider %q", ErrUnpricedInstance, req.Provider)
+	}
+
+	if price, ok := prices[instanceType]; ok {
+		return price, nil
+	}
+	if price, ok := table[instanceType]; ok {
+		return price, nil
+	}
+	return 0, fmt.Errorf("%w %s %q", ErrUnpricedInstance, req.Provider, instanceType)
+}
@@ -0,0 +1,71 @@
+package iac
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEstimateHourlyCost(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		req  *ProvisionRequest
+		want float64
+	}{
+		{"aws amd64 default", &ProvisionRequest{Provider: "aws", Arch: "amd64"}, awsHourlyPrices["t3.large"]},
+		{"aws arm64 default", &ProvisionRequest{Provider: "aws", Arch: "arm64"}, awsHourlyPrices["t4g.large"]},
+		{"aws explicit type", &ProvisionRequest{Provider: "aws", Spec: map[string]string{"instance_type": "c5.2xlarge"}}, 0.34},
+		{"gcp default", &ProvisionRequest{Provider: "gcp"}, gcpHourlyPrices["n1-standard-4"]},
+		{"gcp explicit type", &ProvisionRequest{Provider: "gcp", Spec: map[string]string{"machine_type": "e2-standard-8"}}, 0.268},
+		{"hetzner amd64 default", &ProvisionRequest{Provider: "hetzner", Arch: "amd64"}, hetznerHourlyPrices["cpx41"]},
+		{"hetzner arm64 default", &ProvisionRequest{Provider: "hetzner", Arch: "arm64"}, hetznerHourlyPrices["cax41"]},
+		{"hetzner explicit type", &ProvisionRequest{Provider: "hetzner", Spec: map[string]string{"server_type": "ccx33"}}, 0.1043},
+		{"pve is free", &ProvisionRequest{Provider: "pve"}, 0},
+		{"aws spot capped", &ProvisionRequest{Provider: "aws", Spec: map[string]string{"spot": "true", "max_spot_price": "0.03"}}, 0.03},
+		{"aws spot uncapped", &ProvisionRequest{Provider: "aws", Spec: map[string]string{"spot": "true"}}, awsHourlyPrices["t3.large"]},
+		{"spec cannot set the price", &ProvisionRequest{Provider: "aws", Spec: map[string]string{"hourly_cost": "0"}}, awsHourlyPrices["t3.large"]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := EstimateHourlyCost(tt.req, nil); err != nil || got != tt.want {
+				t.Errorf("EstimateHourlyCost() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateHourlyCostOperatorPrices(t *testing.T) {
+	t.Parallel()
+
+	prices := map[string]float64{"c5.2xlarge": 0.3, "n2-standard-8": 0.389, "ecs.c6.large": 0.085}
+	for _, tt := range []struct {
+		req  *ProvisionRequest
+		want float64
+	}{
+		{&ProvisionRequest{Provider: "aws", Spec: map[string]string{"instance_type": "c5.2xlarge"}}, 0.3},
+		{&ProvisionRequest{Provider: "gcp", Spec: map[string]string{"machine_type": "n2-standard-8"}}, 0.389},
+		{&ProvisionRequest{Provider: "gcp"}, gcpHourlyPrices["n1-standard-4"]},
+		{&ProvisionRequest{Provider: "aliyun"}, 0.085},
+	} {
+		if got, err := EstimateHourlyCost(tt.req, prices); err != nil || got != tt.want {
+			t.Errorf("EstimateHourlyCost(%v) = %v, %v, want %v", tt.req.Spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestEstimateHourlyCostUnknownType(t *testing.T) {
+	t.Parallel()
+
+	for _, req := range []*ProvisionRequest{
+		{Provider: "aws", Spec: map[string]string{"instance_type": "p5.48xlarge"}},
+		{Provider: "gcp", Spec: map[string]string{"machine_type": "a3-highgpu-8g"}},
+		{Provider: "hetzner", Spec: map[string]string{"server_type": "ccx63"}},
+		{Provider: "aliyun"},
+		{Provider: "azure"},
+	} {
+		if _, err := EstimateHourlyCost(req, nil); !errors.Is(err, ErrUnpricedInstance) {
+			t.Errorf("EstimateHourlyCost(%s %v) error = %v, want ErrUnpricedInstance", req.Provider, req.Spec, err)
+		}
+	}
+}
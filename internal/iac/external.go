@@ -0,0 +1,82 @@
+// Package iac provides external instances: builders the server did not
+// provision, such as physical build boxes, tracked alongside cloud ones.
+package iac
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ExternalProvider is the provider of instances registered with
+// RegisterExternalInstance.
+const ExternalProvider = "external"
+
+// RegisterExternalInstance starts tracking the builder at endpoint (e.g.
+// http://buildbox:9090) as a running instance, without Terraform. It is
+// listed, heartbeated, stale-checked and acquired for builds like a
+// provisioned instance, but costs nothing, does not count towards the
+// instance cap, and is never reaped; Terminate only deregisters it.
+func (m *Manager) RegisterExternalInstance(endpoint, arch string) (*Instance, error) {
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid builder endpoint %q: want http(s)://host[:port]", endpoint)
+	}
+	endpoint = u.String()
+
+	now := time.Now()
+	instance := &Instance{
+		ID:              fmt.Sprintf("%s-%d", ExternalProvider, now.UnixNano()),
+		Provider:        ExternalProvider,
+		Status:          "running",
+		Arch:            arch,
+		IPAddress:       u.Hostname(),
+		BuilderEndpoint: endpoint,
+		LastHeartbeat:   now,
+		CreatedAt:       now,
+		LastActivity:    now,
+	}
+
+	m.mu.Lock()
+	for _, inst := range m.instances {
+		if inst.Provider == ExternalProvider && endpointKey(inst.BuilderEndpoint) == endpointKey(endpoint) {
+			m.mu.Unlock()
+			return nil, fmt.Errorf("builder %s is already registered as instance %s", endpoint, inst.ID)
+		}
+	}
+	m.instances[instance.ID] = instance
+	m.mu.Unlock()
+	m.persistInstances()
+
+	fmt.Printf("Registered external instance %s at %s\n", instance.ID, endpoint)
+	return instance, nil
+}
+
+// UpdateExternalHeartbeat records a heartbeat for the external instance
+// whose builder listens on endpoint. External builders register under their
+// own ID rather than the instance's, so heartbeats are matched by endpoint.
+func (m *Manager) UpdateExternalHeartbeat(endpoint string) error {
+	key := endpointKey(endpoint)
+	m.mu.RLock()
+	id := ""
+	for _, inst := range m.instances {
+		if inst.Provider == ExternalProvider && endpointKey(inst.BuilderEndpoint) == key {
+			id = inst.ID
+			break
+		}
+	}
+	m.mu.RUnlock()
+	if id == "" {
+		return fmt.Errorf("no external instance at %s", endpoint)
+	}
+	return m.UpdateHeartbeat(id)
+}
+
+// endpointKey reduces a builder endpoint to host:port, so "http://box:9090/"
+// and "box:9090" compare equal.
+func endpointKey(endpoint string) string {
+	endpoint = strings.TrimPrefix(endpoint, "http://")
+	endpoint = strings.TrimPrefix(endpoint, "https://")
+	return strings.TrimRight(endpoint, "/")
+}
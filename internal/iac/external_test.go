@@ -0,0 +1,95 @@
+package iac
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegisterExternalInstance(t *testing.T) {
+	t.Parallel()
+	m := NewManager()
+
+	inst, err := m.RegisterExternalInstance("http://buildbox:9090/", "arm64")
+	if err != nil {
+		t.Fatalf("RegisterExternalInstance() = %v", err)
+	}
+	if inst.Provider != ExternalProvider || inst.Status != "running" || inst.BuilderEndpoint != "http://buildbox:9090" ||
+		inst.IPAddress != "buildbox" || inst.Arch != "arm64" || inst.TTL != 0 {
+		t.Errorf("instance = %+v", inst)
+	}
+	if list := m.ListInstances(); len(list) != 1 || list[0].ID != inst.ID {
+		t.Errorf("ListInstances() = %v", list)
+	}
+	if _, err := m.RegisterExternalInstance("http://buildbox:9090", "arm64"); err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Errorf("duplicate registration = %v", err)
+	}
+	for _, bad := range []string{"", "buildbox:9090", "ftp://buildbox", "http://"} {
+		if _, err := m.RegisterExternalInstance(bad, "amd64"); err == nil {
+			t.Errorf("RegisterExternalInstance(%q) accepted", bad)
+		}
+	}
+	if count, _ := m.Spend(); count != 0 {
+		t.Errorf("Spend() counts %d external instance(s)", count)
+	}
+}
+
+func TestExternalInstanceLifecycle(t *testing.T) {
+	// No terraform on PATH: anything but deregistering would fail.
+	t.Setenv("PATH", t.TempDir())
+	m := NewManager(WithIdleTimeout(time.Minute), WithStateFile(filepath.Join(t.TempDir(), "instances.json")))
+
+	inst, err := m.RegisterExternalInstance("http://buildbox:9090", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst.LastHeartbeat = time.Now().Add(-time.Hour)
+	if stale := m.CheckStaleInstances(time.Minute); len(stale) != 1 {
+		t.Errorf("CheckStaleInstances() = %v, want the silent external instance", stale)
+	}
+	if due := m.reapCandidates(time.Now()); len(due) != 0 {
+		t.Errorf("reapCandidates() = %v, want none", due)
+	}
+	if err := m.UpdateExternalHeartbeat("buildbox:9090"); err != nil {
+		t.Fatalf("UpdateExternalHeartbeat() = %v", err)
+	}
+	if time.Since(inst.LastHeartbeat) > time.Minute {
+		t.Error("heartbeat by endpoint was not recorded")
+	}
+	if err := m.UpdateExternalHeartbeat("otherbox:9090"); err == nil {
+		t.Error("heartbeat matched an unknown endpoint")
+	}
+
+	if got := m.AcquireIdleInstance("gcp", "amd64"); got == nil || got.ID != inst.ID {
+		t.Errorf("AcquireIdleInstance() = %v, want the external instance", got)
+	}
+	if got := m.AcquireIdleInstance("gcp", "amd64"); got != nil {
+		t.Errorf("AcquireIdleInstance() = %v, want nil (external instance busy)", got)
+	}
+
+	if err := m.Terminate(inst.ID); err != nil {
+		t.Fatalf("Terminate() = %v", err)
+	}
+	if len(m.ListInstances()) != 0 {
+		t.Error("terminated external instance is still tracked")
+	}
+	if restored := NewManager(WithStateFile(m.stateFile)); len(restored.ListInstances()) != 0 {
+		t.Error("deregistration was not persisted")
+	}
+}
+
+func TestAcquireIdleInstancePrefersExternal(t *testing.T) {
+	t.Parallel()
+	m := NewManager()
+	m.instances["gcp-1"] = &Instance{ID: "gcp-1", Provider: "gcp", Status: "running", Arch: "amd64"}
+	m.instances["external-1"] = &Instance{ID: "external-1", Provider: ExternalProvider, Status: "running", Arch: "amd64"}
+	m.instances["external-arm"] = &Instance{ID: "external-arm", Provider: ExternalProvider, Status: "running", Arch: "arm64"}
+
+	for _, want := range []string{"external-1", "gcp-1", ""} {
+		got := m.AcquireIdleInstance("gcp", "amd64")
+		if (got == nil && want != "") || (got != nil && got.ID != want) {
+			t.Errorf("AcquireIdleInstance() = %v, want %q", got, want)
+		}
+	}
+}
@@ -22,10 +22,24 @@ type GCPInstanceSpec struct {
 	DiskType     string   `json:"disk_type"`
 	ImageProject string   `json:"image_project"`
 	ImageFamily  string   `json:"image_family"`
+	Image        string   `json:"image"` // Prebuilt image; overrides ImageProject/ImageFamily
 	Network      string   `json:"network"`
 	Subnetwork   string   `json:"subnetwork"`
 	Preemptible  bool     `json:"preemptible"`
 	Tags         []string `json:"tags"`
+	// Labels are added to the instance's labels, reduced to the characters
+	// GCP allows.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// BootImage returns the boot disk image: the prebuilt Image when set (e.g. a
+// Packer-baked "projects/p/global/images/portage-builder-v3"), otherwise the
+// ImageProject/ImageFamily pair.
+func (s *GCPInstanceSpec) BootImage() string {
+	if s.Image != "" {
+		return s.Image
+	}
+	return s.ImageProject + "/" + s.ImageFamily
 }
 
 // GCPConfig holds GCP-specific configuration for IaC.
@@ -42,6 +56,9 @@ type GCPConfig struct {
 	BuilderPort       int      `json:"builder_port"`
 	ServerCallbackURL string   `json:"server_callback_url"`
 	InstanceTTL       int      `json:"instance_ttl"` // TTL in minutes, 0 means no auto-termination
+	// RemoteState leaves the local backend out of the generated main.tf,
+	// for a remote one configured in another file of the workspace.
+	RemoteState bool `json:"remote_state"`
 }
 
 // DefaultGCPInstanceSpec returns the default GCP instance specification.
@@ -162,6 +179,19 @@ func NewGCPProvisioner(config *GCPConfig) (*GCPProvisioner, error) {
 	}, nil
 }
 
+// localBackend returns the terraform block's local backend, or "" when the
+// state is kept remotely.
+func (p *GCPProvisioner) localBackend() string {
+	if p.config.RemoteState {
+		return ""
+	}
+	return `
+  backend "local" {
+    path = "terraform.tfstate"
+  }
+`
+}
+
 // GenerateMainTF generates the main.tf file for GCP.
 func (p *GCPProvisioner) GenerateMainTF(spec *GCPInstanceSpec, instanceName string) string {
 	if spec == nil {
@@ -232,11 +262,7 @@ terraform {
       version = "~> 5.0"
     }
   }
-
-  backend "local" {
-    path = "terraform.tfstate"
-  }
-}
+%s}
 
 provider "google" {
   project = "%s"
@@ -251,7 +277,7 @@ resource "google_compute_instance" "portage_builder" {
 
   boot_disk {
     initialize_params {
-      image = "%s/%s"
+      image = "%s"
       size  = %d
       type  = "%s"
     }
@@ -261,9 +287,7 @@ resource "google_compute_instance" "portage_builder" {
   tags = %s
 %s
   labels = {
-    purpose = "portage-builder"
-    managed = "terraform"
-  }
+%s  }
 
   metadata_startup_script = <<-EOF
     #!/bin/bash
@@ -293,20 +317,21 @@ output "machine_type" {
 `,
 		instanceName,
 		time.Now().Format(time.RFC3339),
+		p.localBackend(),
 		spec.Project,
 		spec.Region,
 		spec.Zone,
 		instanceName,
 		spec.MachineType,
 		spec.Zone,
-		spec.ImageProject,
-		spec.ImageFamily,
+		spec.BootImage(),
 		spec.DiskSizeGB,
 		spec.DiskType,
 		networkBlock,
 		preemptibleBlock,
 		tagsStr,
 		sshKeyBlock,
+		labelEntries(mergeLabels(spec.Labels, "purpose", "portage-builder", "managed", "terraform"), "    ", true),
 	)
 }
 
@@ -543,6 +568,7 @@ func GCPInstanceSpecFromMap(m map[string]string) *GCPInstanceSpec {
 	setStringField("disk_type", &spec.DiskType)
 	setStringField("image_project", &spec.ImageProject)
 	setStringField("image_family", &spec.ImageFamily)
+	setStringField("image", &spec.Image)
 	setStringField("network", &spec.Network)
 	setStringField("subnetwork", &spec.Subnetwork)
 	setBoolField("preemptible", &spec.Preemptible)
@@ -729,13 +755,7 @@ func (p *GCPProvisioner) GenerateMainTFWithCloudInit(spec *GCPInstanceSpec, inst
 	}
 	initConfig.InstanceID = instanceName
 
-	startupScript := GenerateCloudInitScript(initConfig)
-	// Escape the script for a Terraform (unquoted) heredoc: Terraform interprets
-	// ${ and %{ as interpolation/template sequences, so they must be escaped to
-	// $${ and %%{ respectively. Terraform has no shell-style quoted-heredoc
-	// (<<-'DELIM') syntax, so we must escape rather than rely on quoting.
-	escapedScript := strings.ReplaceAll(startupScript, "${", "$${")
-	escapedScript = strings.ReplaceAll(escapedScript, "%{", "%%{")
+	escapedScript := terraformHeredocEscape(GenerateCloudInitScript(initConfig))
 
 	return fmt.Sprintf(`# Generated by Portage Engine IaC
 # Instance: %s
@@ -751,11 +771,7 @@ terraform {
       version = "~> 5.0"
     }
   }
-
-  backend "local" {
-    path = "terraform.tfstate"
-  }
-}
+%s}
 
 provider "google" {
   project = "%s"
@@ -770,7 +786,7 @@ resource "google_compute_instance" "portage_builder" {
 
   boot_disk {
     initialize_params {
-      image = "%s/%s"
+      image = "%s"
       size  = %d
       type  = "%s"
     }
@@ -780,9 +796,7 @@ resource "google_compute_instance" "portage_builder" {
   tags = %s
 %s
   labels = {
-    purpose = "portage-builder"
-    managed = "terraform"
-  }
+%s  }
 
   metadata_startup_script = <<-CLOUDINIT
 %s
@@ -811,20 +825,21 @@ output "machine_type" {
 `,
 		instanceName,
 		time.Now().Format(time.RFC3339),
+		p.localBackend(),
 		spec.Project,
 		spec.Region,
 		spec.Zone,
 		instanceName,
 		spec.MachineType,
 		spec.Zone,
-		spec.ImageProject,
-		spec.ImageFamily,
+		spec.BootImage(),
 		spec.DiskSizeGB,
 		spec.DiskType,
 		networkBlock,
 		preemptibleBlock,
 		tagsStr,
 		sshKeyBlock,
+		labelEntries(mergeLabels(spec.Labels, "purpose", "portage-builder", "managed", "terraform"), "    ", true),
 		escapedScript,
 	)
 }
@@ -276,6 +276,35 @@ func TestGCPProvisioner_GenerateMainTF_DefaultSpec(t *testing.T) {
 	}
 }
 
+func TestGCPProvisioner_GenerateMainTF_PrebuiltImage(t *testing.T) {
+	t.Parallel()
+
+	provisioner, err := NewGCPProvisioner(&GCPConfig{
+		Project:     "test-project",
+		StateDir:    t.TempDir(),
+		BuilderPort: 9090,
+	})
+	if err != nil {
+		t.Fatalf("NewGCPProvisioner failed: %v", err)
+	}
+
+	spec := GCPInstanceSpecFromMap(map[string]string{
+		"image": "projects/test-project/global/images/portage-builder-v3",
+	})
+	tf := provisioner.GenerateMainTF(spec, "prebuilt-instance")
+	if !strings.Contains(tf, `image = "projects/test-project/global/images/portage-builder-v3"`) {
+		t.Error("prebuilt image not used for the boot disk")
+	}
+	if strings.Contains(tf, "ubuntu-os-cloud") {
+		t.Error("prebuilt image should replace the stock image family")
+	}
+
+	stock := provisioner.GenerateMainTF(nil, "stock-instance")
+	if !strings.Contains(stock, `image = "ubuntu-os-cloud/ubuntu-2204-lts"`) {
+		t.Error("default spec should boot the stock Ubuntu image family")
+	}
+}
+
 func TestGCPProvisioner_GenerateFirewallTF(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,208 @@
+// Package iac provides Hetzner Cloud instance specifications and Terraform
+// generation.
+package iac
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hetznerCacheDevice matches the block device of the attached build cache
+// volume; Hetzner exposes volumes as /dev/disk/by-id/scsi-0HC_Volume_<id>.
+const hetznerCacheDevice = "/dev/disk/by-id/scsi-0HC_Volume_*"
+
+// hetznerNetworkZones maps Hetzner locations to the network zone their
+// private networks live in.
+var hetznerNetworkZones = map[string]string{
+	"fsn1": "eu-central",
+	"nbg1": "eu-central",
+	"hel1": "eu-central",
+	"ash":  "us-east",
+	"hil":  "us-west",
+	"sin":  "ap-southeast",
+}
+
+// HetznerInstanceSpec defines the specification for a Hetzner Cloud builder
+// server.
+type HetznerInstanceSpec struct {
+	ServerType string `json:"server_type"` // e.g. cpx41 (x86) or cax41 (arm64)
+	Image      string `json:"image"`       // Image name or snapshot ID
+	Location   string `json:"location"`    // fsn1, nbg1, hel1, ash, hil or sin
+	// VolumeSize is the size in GB of the volume attached for the build
+	// cache (distfiles, binpkgs, portage tree); 0 builds on the root disk.
+	VolumeSize int `json:"volume_size"`
+}
+
+// HetznerInstanceSpecFromMap creates a HetznerInstanceSpec from a machine spec
+// map, defaulting the server type for arch.
+func HetznerInstanceSpecFromMap(m map[string]string, arch string) *HetznerInstanceSpec {
+	spec := &HetznerInstanceSpec{
+		ServerType: getOrDefault(m, "server_type", hetznerServerTypeForArch(arch)),
+		Image:      getOrDefault(m, "image", "ubuntu-22.04"),
+		Location:   getOrDefault(m, "location", "fsn1"),
+		VolumeSize: 100,
+	}
+	if v, ok := m["volume_size"]; ok {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			size = -1 // rejected by ValidateHetznerSpec
+		}
+		spec.VolumeSize = size
+	}
+	return spec
+}
+
+// ValidateHetznerSpec validates a Hetzner instance specification for arch.
+func ValidateHetznerSpec(spec *HetznerInstanceSpec, arch string) error {
+	if spec.ServerType == "" {
+		return fmt.Errorf("server_type is required")
+	}
+	if _, ok := hetznerNetworkZones[spec.Location]; !ok {
+		return fmt.Errorf("invalid location: %s", spec.Location)
+	}
+	if spec.VolumeSize != 0 && (spec.VolumeSize < 10 || spec.VolumeSize > 10240) {
+		return fmt.Errorf("invalid volume_size %d: want 0 or 10-10240 GB", spec.VolumeSize)
+	}
+	// Ampere (cax) servers are the only arm64 ones.
+	arm := strings.HasPrefix(spec.ServerType, "cax")
+	if arch == "arm64" && !arm {
+		return fmt.Errorf("server_type %s is not an arm64 (cax) server", spec.ServerType)
+	}
+	if arch != "arm64" && arm {
+		return fmt.Errorf("server_type %s is an arm64 server, but arch is %s", spec.ServerType, arch)
+	}
+	return nil
+}
+
+// hetznerServerTypeForArch returns the default server type for arch: 8 vCPUs
+// and 16 GB of memory either way.
+func hetznerServerTypeForArch(arch string) string {
+	if arch == "arm64" {
+		return "cax41"
+	}
+	return "cpx41"
+}
+
+// generateHetznerConfig generates Hetzner Cloud Terraform config. The API
+// token comes from HCLOUD_TOKEN (see prepareEnvironment). The server joins
+// a private network so private_ip is populated like on the other providers.
+func (m *Manager) generateHetznerConfig(req *ProvisionRequest) string {
+	spec := HetznerInstanceSpecFromMap(req.Spec, req.Arch)
+	suffix := time.Now().UnixNano()
+
+	// SSH key injection, so deployBuilder can SSH in.
+	sshKeyResource := ""
+	sshKeysLine := ""
+	if req.SSH != nil && req.SSH.KeyPath != "" {
+		sshKeyResource = fmt.Sprintf(`
+resource "hcloud_ssh_key" "portage" {
+  name       = "portage-builder-%d"
+  public_key = file(%q)
+}
+`, suffix, req.SSH.KeyPath+".pub")
+		sshKeysLine = "  ssh_keys     = [hcloud_ssh_key.portage.id]\n"
+	}
+
+	volume := ""
+	if spec.VolumeSize > 0 {
+		volume = fmt.Sprintf(`
+# Build cache volume, mounted over the builder's data directory by the
+# deployment script.
+resource "hcloud_volume" "cache" {
+  name     = "portage-cache-%d"
+  size     = %d
+  location = %q
+  format   = "ext4"
+}
+
+resource "hcloud_volume_attachment" "cache" {
+  volume_id = hcloud_volume.cache.id
+  server_id = hcloud_server.portage_builder.id
+  automount = false
+}
+`, suffix, spec.VolumeSize, spec.Location)
+	}
+
+	return fmt.Sprintf(`
+terraform {
+  required_providers {
+    hcloud = {
+      source  = "hetznercloud/hcloud"
+      version = "~> 1.45"
+    }
+  }
+}
+
+provider "hcloud" {}
+%s
+resource "hcloud_network" "portage" {
+  name     = "portage-network-%d"
+  ip_range = "10.0.0.0/16"
+}
+
+resource "hcloud_network_subnet" "portage" {
+  network_id   = hcloud_network.portage.id
+  type         = "cloud"
+  network_zone = %q
+  ip_range     = "10.0.1.0/24"
+}
+
+resource "hcloud_server" "portage_builder" {
+  name         = "portage-builder-%s-%d"
+  server_type  = %q
+  image        = %q
+  location     = %q
+  firewall_ids = [hcloud_firewall.portage.id]
+%s
+  network {
+    network_id = hcloud_network.portage.id
+  }
+
+  labels = {
+%s  }
+
+  depends_on = [hcloud_network_subnet.portage]
+}
+%s
+output "ip_address" {
+  value = hcloud_server.portage_builder.ipv4_address
+}
+
+output "private_ip" {
+  value = one(hcloud_server.portage_builder.network[*].ip)
+}
+`, sshKeyResource, suffix, hetznerNetworkZones[spec.Location], req.Arch, suffix,
+		spec.ServerType, spec.Image, spec.Location, sshKeysLine,
+		labelEntries(mergeLabels(req.Labels, "purpose", "portage-build", "arch", req.Arch), "    ", true), volume)
+}
+
+// generateHetznerFirewall generates the Hetzner Cloud firewall: SSH from
+// anywhere, as deployBuilder needs it, and the builder port from allowedIPs.
+func (m *Manager) generateHetznerFirewall(req *ProvisionRequest, allowedIPs []string) string {
+	quoted := make([]string, len(allowedIPs))
+	for i, cidr := range allowedIPs {
+		quoted[i] = fmt.Sprintf("%q", cidr)
+	}
+
+	return fmt.Sprintf(`
+resource "hcloud_firewall" "portage" {
+  name = "portage-builder-fw-%d"
+
+  rule {
+    direction  = "in"
+    protocol   = "tcp"
+    port       = "22"
+    source_ips = ["0.0.0.0/0", "::/0"]
+  }
+
+  rule {
+    direction  = "in"
+    protocol   = "tcp"
+    port       = "%d"
+    source_ips = [%s]
+  }
+}
+`, time.Now().UnixNano(), req.BuilderPort, strings.Join(quoted, ", "))
+}
@@ -0,0 +1,130 @@
+package iac
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHetznerInstanceSpecFromMap(t *testing.T) {
+	t.Parallel()
+
+	spec := HetznerInstanceSpecFromMap(map[string]string{"location": "hel1", "volume_size": "200", "image": "12345"}, "arm64")
+	if spec.ServerType != "cax41" || spec.Location != "hel1" || spec.VolumeSize != 200 || spec.Image != "12345" {
+		t.Errorf("spec = %+v", spec)
+	}
+	spec = HetznerInstanceSpecFromMap(nil, "amd64")
+	if spec.ServerType != "cpx41" || spec.Location != "fsn1" || spec.VolumeSize != 100 || spec.Image != "ubuntu-22.04" {
+		t.Errorf("default spec = %+v", spec)
+	}
+}
+
+func TestValidateHetznerSpec(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		spec    map[string]string
+		arch    string
+		wantErr string
+	}{
+		{"default amd64", nil, "amd64", ""},
+		{"default arm64", nil, "arm64", ""},
+		{"no volume", map[string]string{"volume_size": "0"}, "amd64", ""},
+		{"bad location", map[string]string{"location": "mars1"}, "amd64", "invalid location"},
+		{"bad volume", map[string]string{"volume_size": "lots"}, "amd64", "invalid volume_size"},
+		{"tiny volume", map[string]string{"volume_size": "5"}, "amd64", "invalid volume_size"},
+		{"x86 type on arm64", map[string]string{"server_type": "cpx41"}, "arm64", "not an arm64"},
+		{"arm64 type on amd64", map[string]string{"server_type": "cax41"}, "amd64", "is an arm64 server"},
+		{"no type", map[string]string{"server_type": ""}, "amd64", "server_type is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateHetznerSpec(HetznerInstanceSpecFromMap(tt.spec, tt.arch), tt.arch)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("ValidateHetznerSpec() = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("ValidateHetznerSpec() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateHetznerConfig(t *testing.T) {
+	t.Parallel()
+	m := NewManager()
+
+	req := &ProvisionRequest{
+		Provider:        "hetzner",
+		Arch:            "amd64",
+		Spec:            map[string]string{"location": "ash", "server_type": "cpx51"},
+		SSH:             &SSHConfig{KeyPath: "/keys/id_ed25519"},
+		BuilderPort:     9090,
+		AllowedIPRanges: []string{"10.0.0.0/8", "192.168.0.0/16"},
+	}
+	tf, err := m.generateTerraformConfig(req, "")
+	if err != nil {
+		t.Fatalf("generateTerraformConfig() = %v", err)
+	}
+	for _, want := range []string{
+		`source  = "hetznercloud/hcloud"`,
+		`server_type  = "cpx51"`,
+		`location     = "ash"`,
+		`network_zone = "us-east"`,
+		`public_key = file("/keys/id_ed25519.pub")`,
+		"ssh_keys     = [hcloud_ssh_key.portage.id]",
+		"firewall_ids = [hcloud_firewall.portage.id]",
+		`resource "hcloud_volume" "cache"`,
+		"size     = 100",
+		`resource "hcloud_volume_attachment" "cache"`,
+		`output "ip_address"`,
+		`output "private_ip"`,
+	} {
+		if !strings.Contains(tf, want) {
+			t.Errorf("config missing %q", want)
+		}
+	}
+
+	fw := m.generateFirewallConfig(req)
+	for _, want := range []string{
+		`resource "hcloud_firewall" "portage"`,
+		`port       = "22"`,
+		`port       = "9090"`,
+		`source_ips = ["10.0.0.0/8", "192.168.0.0/16"]`,
+	} {
+		if !strings.Contains(fw, want) {
+			t.Errorf("firewall missing %q", want)
+		}
+	}
+
+	noVolume, err := m.generateTerraformConfig(&ProvisionRequest{Provider: "hetzner", Arch: "amd64", Spec: map[string]string{"volume_size": "0"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(noVolume, "hcloud_volume") {
+		t.Error("volume_size 0 still creates a volume")
+	}
+
+	if _, err := m.generateTerraformConfig(&ProvisionRequest{Provider: "hetzner", Arch: "arm64", Spec: map[string]string{"server_type": "cpx41"}}, ""); err == nil {
+		t.Error("accepted an x86 server type for arm64")
+	}
+}
+
+func TestGenerateDeploymentScriptHetznerVolume(t *testing.T) {
+	t.Parallel()
+	m := NewManager()
+
+	script := m.generateDeploymentScript(&ProvisionRequest{Provider: "hetzner", Arch: "amd64"}, "hetzner-1")
+	if !strings.Contains(script, `CACHE_DEV="$(ls /dev/disk/by-id/scsi-0HC_Volume_* 2>/dev/null | head -n1)"`) ||
+		!strings.Contains(script, `mount "$CACHE_DEV" /var/lib/portage-engine`) {
+		t.Error("deployment script does not mount the build cache volume")
+	}
+	if strings.Index(script, "# Mount the build cache volume") > strings.Index(script, "# Create directories") {
+		t.Error("the volume is mounted after the data directory is populated")
+	}
+
+	script = m.generateDeploymentScript(&ProvisionRequest{Provider: "hetzner", Arch: "amd64", Spec: map[string]string{"volume_size": "0"}}, "hetzner-2")
+	if strings.Contains(script, "CACHE_DEV") {
+		t.Error("deployment script mounts a volume that was not created")
+	}
+}
@@ -0,0 +1,99 @@
+// Package iac provides the labels provisioned instances are tagged with for
+// cost allocation and orphan cleanup.
+package iac
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Instance label keys. The caller sets LabelOwner and LabelJobID in
+// ProvisionRequest.Labels; Provision stamps the others on every instance, so
+// an external cleanup process can find instances the server lost track of by
+// their creation time and TTL.
+const (
+	LabelOwner      = "owner"
+	LabelJobID      = "job-id"
+	LabelManagedBy  = "managed-by"
+	LabelInstanceID = "instance-id"
+	// LabelCreatedAt is the creation time in Unix seconds and LabelTTL the
+	// idle TTL in seconds (0 for none): GCP label values allow neither ':'
+	// nor uppercase, ruling out RFC 3339 times and Go durations.
+	LabelCreatedAt = "created-at"
+	LabelTTL       = "ttl"
+)
+
+// managedByValue is the LabelManagedBy value of instances Provision creates.
+const managedByValue = "portage-engine"
+
+// instanceLabels returns req's labels with the labels Provision stamps on
+// instanceID, created at now with the given TTL. The stamps take precedence.
+func instanceLabels(req *ProvisionRequest, instanceID string, ttl time.Duration, now time.Time) map[string]string {
+	labels := make(map[string]string, len(req.Labels)+4)
+	for k, v := range req.Labels {
+		labels[k] = v
+	}
+	labels[LabelManagedBy] = managedByValue
+	labels[LabelInstanceID] = instanceID
+	labels[LabelCreatedAt] = strconv.FormatInt(now.Unix(), 10)
+	labels[LabelTTL] = strconv.FormatInt(int64(ttl/time.Second), 10)
+	return labels
+}
+
+// mergeLabels returns labels with the fixed key and value pairs a generator
+// always sets, which take precedence.
+func mergeLabels(labels map[string]string, fixed ...string) map[string]string {
+	merged := make(map[string]string, len(labels)+len(fixed)/2)
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for i := 0; i+1 < len(fixed); i += 2 {
+		merged[fixed[i]] = fixed[i+1]
+	}
+	return merged
+}
+
+// sanitizeLabel returns s as a GCP or Hetzner label key or value: lowercase
+// letters, digits, '_' and '-', starting and ending with a letter or digit,
+// at most 63 characters. Other characters become '_'.
+func sanitizeLabel(s string) string {
+	b := []byte(strings.ToLower(s))
+	for i, c := range b {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			b[i] = '_'
+		}
+	}
+	if len(b) > 63 {
+		b = b[:63]
+	}
+	return strings.Trim(string(b), "_-")
+}
+
+// labelEntries renders labels as the entries of a Terraform map, one per
+// line with the given indent, in key order, escaped so no value interpolates.
+// With sanitize, keys and values are first reduced to what GCP and Hetzner
+// labels allow.
+func labelEntries(labels map[string]string, indent string, sanitize bool) string {
+	rendered := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if sanitize {
+			k, v = sanitizeLabel(k), sanitizeLabel(v)
+		}
+		if k != "" {
+			rendered[k] = v
+		}
+	}
+	keys := make([]string, 0, len(rendered))
+	for k := range rendered {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "%s%s = %s\n", indent, terraformHeredocEscape(strconv.Quote(k)), terraformHeredocEscape(strconv.Quote(rendered[k])))
+	}
+	return sb.String()
+}
@@ -0,0 +1,90 @@
+package iac
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstanceLabels(t *testing.T) {
+	t.Parallel()
+
+	req := &ProvisionRequest{Labels: map[string]string{LabelOwner: "alice", LabelManagedBy: "someone-else"}}
+	labels := instanceLabels(req, "aws-1", time.Hour, time.Unix(1760000000, 0))
+	want := map[string]string{
+		LabelOwner:      "alice",
+		LabelManagedBy:  "portage-engine",
+		LabelInstanceID: "aws-1",
+		LabelCreatedAt:  "1760000000",
+		LabelTTL:        "3600",
+	}
+	for k, v := range want {
+		if labels[k] != v {
+			t.Errorf("%s = %q, want %q", k, labels[k], v)
+		}
+	}
+	if req.Labels[LabelManagedBy] != "someone-else" {
+		t.Error("instanceLabels modified the request's labels")
+	}
+}
+
+func TestSanitizeLabel(t *testing.T) {
+	t.Parallel()
+
+	for in, want := range map[string]string{
+		"job-id":                "job-id",
+		"Alice@Example.com":     "alice_example_com",
+		"_x-":                   "x",
+		strings.Repeat("a", 70): strings.Repeat("a", 63),
+	} {
+		if got := sanitizeLabel(in); got != want {
+			t.Errorf("sanitizeLabel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestGeneratedConfigLabels(t *testing.T) {
+	t.Parallel()
+	m := NewManager()
+
+	labels := instanceLabels(&ProvisionRequest{Labels: map[string]string{
+		LabelOwner: "Alice@Example.com",
+		LabelJobID: "job-1",
+		"team":     "${var.x}",
+	}}, "inst-1", time.Hour, time.Unix(1760000000, 0))
+	req := func(provider string) *ProvisionRequest {
+		return &ProvisionRequest{Provider: provider, Arch: "amd64", BuilderPort: 9090, Labels: labels, Spec: map[string]string{"bootstrap": "ssh"}}
+	}
+	gcpTF, err := m.generateTerraformConfig(req("gcp"), "inst-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hetznerTF, err := m.generateTerraformConfig(req("hetzner"), "inst-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	common := []string{
+		`"managed-by" = "portage-engine"`,
+		`"instance-id" = "inst-1"`,
+		`"job-id" = "job-1"`,
+		`"created-at" = "1760000000"`,
+		`"ttl" = "3600"`,
+	}
+	for _, tt := range []struct {
+		name string
+		tf   string
+		want []string
+	}{
+		{"gcp", gcpTF, append(common, `"owner" = "alice_example_com"`, `"purpose" = "portage-builder"`, `"team" = "var_x"`)},
+		{"hetzner", hetznerTF, append(common, `"owner" = "alice_example_com"`, `"arch" = "amd64"`)},
+		{"aws", m.generateAWSConfig(req("aws"), "inst-1", "us-east-1", ""), append(common, "default_tags {", `"owner" = "Alice@Example.com"`, `"team" = "$${var.x}"`)},
+		{"aliyun", m.generateAliyunConfig(req("aliyun"), "inst-1", "cn-hangzhou", ""), append(common, `"owner" = "Alice@Example.com"`, `"Purpose" = "PortageBuild"`)},
+	} {
+		for _, want := range tt.want {
+			if !strings.Contains(tt.tf, want) {
+				t.Errorf("%s config lacks %s", tt.name, want)
+			}
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -30,6 +31,9 @@ type CloudCredentials struct {
 	AWSAccessKey string
 	AWSSecretKey string
 
+	// Hetzner Cloud
+	HetznerToken string
+
 	// PVE (Proxmox VE)
 	PVETokenID     string
 	PVETokenSecret string
@@ -62,6 +66,9 @@ const (
 	// sshDeployTimeout bounds the bootstrap script run: docker install plus a
 	// full portage tree sync legitimately takes tens of minutes.
 	sshDeployTimeout = 40 * time.Minute
+	// cloudInitReadyTimeout bounds the wait for a builder bootstrapping itself
+	// from user data, which runs the same script.
+	cloudInitReadyTimeout = sshDeployTimeout
 )
 
 // ProvisionRequest represents an infrastructure provisioning request.
@@ -77,6 +84,16 @@ type ProvisionRequest struct {
 	BinpkgHost      string            `json:"binpkg_host"`
 	AllowedIPRanges []string          `json:"allowed_ip_ranges"`
 	TTL             time.Duration     `json:"ttl"` // Instance TTL, 0 uses default
+	// Labels tag the instance as GCP labels, or AWS, Aliyun or Hetzner tags,
+	// for cost allocation: LabelOwner and LabelJobID, say. Provision adds
+	// the labels it stamps on every instance (see instanceLabels).
+	Labels map[string]string `json:"labels,omitempty"`
+	// DryRun stops after `terraform plan`: Provision returns an untracked
+	// "planned" instance carrying the plan summary and creates nothing.
+	DryRun bool `json:"dry_run"`
+	// MaxLifetime caps how long the instance lives regardless of activity;
+	// 0 uses the manager's default (see WithMaxLifetime).
+	MaxLifetime time.Duration `json:"max_lifetime"`
 
 	// How the builder binary reaches the instance. BuilderBinaryPath is a local
 	// (linux, arch-matching) binary scp'd over during deployBuilder;
@@ -95,6 +112,10 @@ type ProvisionRequest struct {
 	PortageSyncURI       string `json:"portage_sync_uri"`
 	PortageSyncMethod    string `json:"portage_sync_method"`
 	DockerImage          string `json:"docker_image"`
+	// PrebakedImage marks the instance image (spec "ami"/"image"/"image_id")
+	// as a prebuilt builder image with Docker, the stage3 image and a portage
+	// tree already in place, so bootstrap reuses them instead of refetching.
+	PrebakedImage bool `json:"prebaked_image"`
 	// MakeConfExtra is appended to the generated make.conf on build instances.
 	MakeConfExtra string `json:"make_conf_extra"`
 	// BuildFeatures is appended to the build container's make.conf FEATURES.
@@ -141,6 +162,14 @@ type Instance struct {
 	TTL             time.Duration     `json:"ttl"`           // Time to live, 0 means no auto-termination
 	LastActivity    time.Time         `json:"last_activity"` // Last time the instance had activity
 	ActiveTasks     int               `json:"active_tasks"`  // Number of active tasks on this instance
+	HourlyCost      float64           `json:"hourly_cost"`   // Estimated USD/hour (see EstimateHourlyCost)
+	MaxLifetime     time.Duration     `json:"max_lifetime"`  // Lifetime since CreatedAt, 0 means unlimited
+	// Plan is the terraform plan the instance was created from, or would be
+	// for a dry run.
+	Plan *PlanSummary `json:"plan,omitempty"`
+	// StateBackend is the remote backend type ("gcs", "s3", "oss") the
+	// instance's state is kept in, or "" for its local workspace.
+	StateBackend string `json:"state_backend,omitempty"`
 	// destroyEnv is the credential environment used to provision the instance;
 	// Terminate reuses it so `terraform destroy` authenticates the same way as
 	// apply did. Not serialized (contains secrets).
@@ -158,7 +187,28 @@ type Manager struct {
 	// stateFile, when set, persists the instance map across restarts so live
 	// VMs are never orphaned by a server restart.
 	stateFile string
-}
+	// Budget cap enforced by Provision; 0 disables the respective limit.
+	maxInstances   int
+	maxHourlySpend float64
+	// Operator price overrides by instance type (see EstimateHourlyCost).
+	hourlyPrices map[string]float64
+	// Readiness gate applied to freshly deployed builders (see
+	// WithBuilderReadiness).
+	readyDelay    time.Duration
+	readyTimeout  time.Duration
+	readyInterval time.Duration
+	// Reaper settings (see reaper.go) and its termination counts by reason.
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+	activeJobs  ActiveJobsProbe
+	reaped      map[string]int
+	// stateBackend keeps instance state remote and locked (see backend.go).
+	stateBackend StateBackend
+}
+
+// ErrBudgetExceeded is returned by Provision when a new instance would exceed
+// the configured instance-count or hourly-spend cap.
+var ErrBudgetExceeded = errors.New("cloud budget cap exceeded")
 
 // persistedInstance is the on-disk form of an Instance, including the fields
 // the in-memory JSON representation hides (terraform dir and the credential
@@ -221,6 +271,9 @@ func (m *Manager) loadInstances() {
 		inst.TerraformDir = list[i].TerraformDirP
 		inst.destroyEnv = list[i].DestroyEnvP
 		inst.ActiveTasks = 0 // whatever was in-flight died with the old process
+		if inst.Status == "terminating" {
+			inst.Status = "destroy_failed" // the old process died mid-destroy
+		}
 		m.instances[inst.ID] = &inst
 	}
 	n := len(m.instances)
@@ -258,6 +311,12 @@ func (m *Manager) reconcileLoadedInstances() {
 		if healthy {
 			fmt.Printf("Adopted warm instance %s (%s)\n", inst.ID, inst.IPAddress)
 			m.UpdateInstanceActivity(inst.ID)
+			_ = m.UpdateHeartbeat(inst.ID)
+			continue
+		}
+		if inst.Provider == ExternalProvider {
+			// Not ours to destroy; its heartbeats bring it back.
+			fmt.Printf("Restored external instance %s is unreachable\n", inst.ID)
 			continue
 		}
 		fmt.Printf("Restored instance %s is unreachable; scheduling destroy\n", inst.ID)
@@ -283,6 +342,30 @@ func WithCleanupInterval(interval time.Duration) ManagerOption {
 	}
 }
 
+// WithMaxInstances caps the number of tracked instances (provisioning,
+// running, or awaiting destroy). 0 means unlimited.
+func WithMaxInstances(n int) ManagerOption {
+	return func(m *Manager) {
+		m.maxInstances = n
+	}
+}
+
+// WithMaxHourlySpend caps the summed estimated USD/hour of tracked instances.
+// 0 means unlimited.
+func WithMaxHourlySpend(usd float64) ManagerOption {
+	return func(m *Manager) {
+		m.maxHourlySpend = usd
+	}
+}
+
+// WithHourlyPrices sets USD/hour prices by instance type, overriding the
+// built-in price table and pricing types it lacks.
+func WithHourlyPrices(prices map[string]float64) ManagerOption {
+	return func(m *Manager) {
+		m.hourlyPrices = prices
+	}
+}
+
 // NewManager creates a new IaC manager.
 func NewManager(opts ...ManagerOption) *Manager {
 	workspaceDir := filepath.Join(os.TempDir(), "portage-terraform")
@@ -290,10 +373,13 @@ func NewManager(opts ...ManagerOption) *Manager {
 
 	m := &Manager{
 		instances:       make(map[string]*Instance),
+		reaped:          make(map[string]int),
 		workspaceDir:    workspaceDir,
 		defaultTTL:      60 * time.Minute, // Default 1 hour
 		stopChan:        make(chan struct{}),
 		cleanupInterval: 5 * time.Minute,
+		readyTimeout:    defaultBuilderReadyTimeout,
+		readyInterval:   defaultBuilderReadyInterval,
 	}
 
 	for _, opt := range opts {
@@ -333,44 +419,29 @@ func (m *Manager) cleanupRoutine() {
 	}
 }
 
-// cleanupExpiredInstances terminates instances that have exceeded their TTL without activity.
+// cleanupExpiredInstances retries failed destroys and terminates instances
+// that are idle past their TTL, have stopped heartbeating, or have outlived
+// their maximum lifetime.
 func (m *Manager) cleanupExpiredInstances() {
 	m.mu.RLock()
-	var expiredIDs []string
-	now := time.Now()
-
+	var retryIDs []string
 	for id, inst := range m.instances {
 		// Always retry instances whose destroy previously failed — they are
 		// billing with no owner.
 		if inst.Status == "destroy_failed" {
-			expiredIDs = append(expiredIDs, id)
-			continue
-		}
-
-		// Skip if TTL is 0 (no auto-termination)
-		if inst.TTL == 0 {
-			continue
-		}
-
-		// Skip if instance has active tasks
-		if inst.ActiveTasks > 0 {
-			continue
-		}
-
-		// Check if instance has exceeded TTL since last activity
-		if now.Sub(inst.LastActivity) > inst.TTL {
-			expiredIDs = append(expiredIDs, id)
+			retryIDs = append(retryIDs, id)
 		}
 	}
 	m.mu.RUnlock()
 
-	// Terminate expired instances
-	for _, id := range expiredIDs {
-		fmt.Printf("Auto-terminating expired instance: %s\n", id)
+	for _, id := range retryIDs {
+		fmt.Printf("Retrying destroy of instance: %s\n", id)
 		if err := m.Terminate(id); err != nil {
-			fmt.Printf("Failed to terminate expired instance %s: %v\n", id, err)
+			fmt.Printf("Failed to terminate instance %s: %v\n", id, err)
 		}
 	}
+
+	m.reapInstances()
 }
 
 // UpdateInstanceActivity updates the last activity time for an instance.
@@ -398,24 +469,37 @@ func (m *Manager) SetInstanceActiveTasks(instanceID string, count int) {
 
 // AcquireIdleInstance atomically claims a running, idle instance of the given
 // provider (and arch, when non-empty) for a new build, so warm instances are
-// reused instead of provisioning a fresh VM per build. Returns nil when none
-// is available. The caller must release it with SetInstanceActiveTasks(id, 0)
-// when done; idle instances are reclaimed by the TTL cleanup.
+// reused instead of provisioning a fresh VM per build. Idle external
+// instances are preferred over any provider's, as they cost nothing. Returns
+// nil when none is available. The caller must release it with
+// SetInstanceActiveTasks(id, 0) when done; idle instances are reclaimed by
+// the TTL cleanup.
 func (m *Manager) AcquireIdleInstance(provider, arch string) *Instance {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	var match *Instance
 	for _, inst := range m.instances {
-		if inst.Status != "running" || inst.ActiveTasks != 0 || inst.Provider != provider {
+		if inst.Status != "running" || inst.ActiveTasks != 0 {
+			continue
+		}
+		if inst.Provider != provider && inst.Provider != ExternalProvider {
 			continue
 		}
 		if arch != "" && inst.Arch != "" && inst.Arch != arch {
 			continue
 		}
-		inst.ActiveTasks = 1
-		inst.LastActivity = time.Now()
-		return inst
+		if match == nil || inst.Provider == ExternalProvider {
+			match = inst
+		}
+		if inst.Provider == ExternalProvider {
+			break
+		}
 	}
-	return nil
+	if match != nil {
+		match.ActiveTasks = 1
+		match.LastActivity = time.Now()
+	}
+	return match
 }
 
 // GetExpiredInstances returns a list of instances that have exceeded their TTL.
@@ -445,13 +529,15 @@ func (m *Manager) GetExpiredInstances() []*Instance {
 // provision. GCP and PVE are validated against live environments. AWS generates
 // complete, valid Terraform (dynamic Ubuntu AMI, injected SSH key, arch-aware
 // instance type, security group) but has NOT been validated against a live AWS
-// account — treat it as beta. Aliyun remains a non-functional stub and is
+// account — treat it as beta, as is Hetzner Cloud (server, private network,
+// build cache volume and firewall). Aliyun remains a non-functional stub and is
 // intentionally excluded so provisioning returns a clear error instead of
 // creating an unusable instance.
 var supportedProviders = map[string]bool{
-	"gcp": true,
-	"pve": true,
-	"aws": true,
+	"gcp":     true,
+	"pve":     true,
+	"aws":     true,
+	"hetzner": true,
 }
 
 // Provision provisions a new instance using Terraform.
@@ -477,8 +563,15 @@ func (m *Manager) Provision(req *ProvisionRequest) (*Instance, error) {
 		}
 	}
 
+	// Determine TTL up front: it is stamped on the instance.
+	ttl := req.TTL
+	if ttl == 0 {
+		ttl = m.defaultTTL
+	}
+	req.Labels = instanceLabels(req, instanceID, ttl, time.Now())
+
 	// Generate Terraform configuration with credentials
-	tfConfig, err := m.generateTerraformConfig(req)
+	tfConfig, err := m.generateTerraformConfig(req, instanceID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate terraform config: %w", err)
 	}
@@ -494,19 +587,35 @@ func (m *Manager) Provision(req *ProvisionRequest) (*Instance, error) {
 		return nil, fmt.Errorf("failed to write firewall config: %w", err)
 	}
 
+	// A remote backend keeps the state, under a lock, outside the workspace.
+	backend := m.stateBackend.backendType(req.Provider)
+	if backend != "" {
+		region := getOrDefault(req.Spec, "region", "us-central1")
+		backendConfig := m.stateBackend.backendConfig(req.Provider, instanceID, region)
+		if err := os.WriteFile(filepath.Join(terraformDir, backendFile), []byte(backendConfig), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write terraform backend config: %w", err)
+		}
+	}
+	lockArgs := m.stateBackend.lockArgs(backend)
+
 	// Set environment variables for cloud credentials
 	env := m.prepareEnvironment(req)
 
-	// Determine TTL up front.
-	ttl := req.TTL
-	if ttl == 0 {
-		ttl = m.defaultTTL
+	maxLifetime := req.MaxLifetime
+	if maxLifetime == 0 {
+		maxLifetime = m.maxLifetime
+	}
+
+	if req.DryRun {
+		return m.dryRun(req, instanceID, terraformDir, env, lockArgs)
 	}
 
 	// Record the instance BEFORE apply completes, so that if apply partially
 	// creates resources (VPC/subnet/instance) and then errors, cleanup can still
 	// find the terraform dir and destroy it. destroyEnv carries the credentials
-	// so a later destroy authenticates the same way apply did.
+	// so a later destroy authenticates the same way apply did. The budget check
+	// happens under the same lock so concurrent provisions cannot overshoot it.
+	hourlyCost, costErr := EstimateHourlyCost(req, m.hourlyPrices)
 	now := time.Now()
 	instance := &Instance{
 		ID:            instanceID,
@@ -520,9 +629,18 @@ func (m *Manager) Provision(req *ProvisionRequest) (*Instance, error) {
 		CreatedAt:     now,
 		TTL:           ttl,
 		LastActivity:  now,
+		HourlyCost:    hourlyCost,
+		MaxLifetime:   maxLifetime,
+		StateBackend:  backend,
 		destroyEnv:    env,
 	}
 	m.mu.Lock()
+	if err := m.checkBudgetLocked(instance.HourlyCost, costErr); err != nil {
+		m.mu.Unlock()
+		_ = os.RemoveAll(terraformDir)
+		sinkf(req.LogSink, "[provision] rejected: %v", err)
+		return nil, err
+	}
 	m.instances[instanceID] = instance
 	m.mu.Unlock()
 	m.persistInstances()
@@ -539,11 +657,28 @@ func (m *Manager) Provision(req *ProvisionRequest) (*Instance, error) {
 		return nil, fmt.Errorf("terraform init failed: %w", errInit)
 	}
 
-	// Run Terraform apply with a bounded timeout. On any error after this point,
-	// roll back (destroy) so partially-created resources do not leak.
+	// Plan first: credential and quota errors surface here, before anything
+	// billable exists.
+	sinkf(req.LogSink, "[provision] running terraform plan…")
+	planCtx, cancelPlan := context.WithTimeout(context.Background(), terraformPlanTimeout)
+	plan, errPlan := m.runTerraformPlan(planCtx, terraformDir, env, req.LogSink, lockArgs...)
+	cancelPlan()
+	if errPlan != nil {
+		m.rollback(instance)
+		return nil, errPlan
+	}
+	sinkf(req.LogSink, "[provision] plan: %s", plan)
+	m.mu.Lock()
+	instance.Plan = plan
+	m.mu.Unlock()
+
+	// Apply the saved plan with a bounded timeout, so exactly the planned
+	// changes are made. On any error after this point, roll back (destroy) so
+	// partially-created resources do not leak.
 	sinkf(req.LogSink, "[provision] running terraform apply (creating the build VM)…")
 	applyCtx, cancelApply := context.WithTimeout(context.Background(), terraformApplyTimeout)
-	errApply := m.runTerraformCommand(applyCtx, terraformDir, env, req.LogSink, "apply", "-auto-approve")
+	applyArgs := append(append([]string{"apply", "-input=false"}, lockArgs...), planFile)
+	errApply := m.runTerraformCommand(applyCtx, terraformDir, env, req.LogSink, applyArgs...)
 	cancelApply()
 	if errApply != nil {
 		sinkf(req.LogSink, "[provision] apply failed — rolling back")
@@ -590,21 +725,86 @@ func (m *Manager) Provision(req *ProvisionRequest) (*Instance, error) {
 
 	sinkf(req.LogSink, "[provision] instance is up at %s", ipAddress)
 
-	// Deploy builder software via SSH.
-	if req.SSH.KeyPath != "" {
-		if err := m.deployBuilder(instance, req); err != nil {
-			// Deployment failed on a live, billed VM — destroy it rather than
-			// leaving an orphan running.
-			m.setInstanceStatus(instance, "deployment_failed")
-			m.rollback(instance)
-			return nil, fmt.Errorf("builder deployment failed: %w", err)
+	// An instance given its bootstrap script as user data deploys the builder
+	// itself; otherwise deploy it via SSH. Either way, only hand the instance
+	// out once its builder answers: until then it stays "starting", so neither
+	// the first build nor the warm pool can race the service startup.
+	var errDeploy error
+	switch {
+	case m.selfBootstrapScript(req, instanceID) != "":
+		m.setInstanceStatus(instance, "starting")
+		sinkf(req.LogSink, "[deploy] the instance bootstraps the builder via cloud-init (docker install + portage tree sync — this takes several minutes)…")
+		errDeploy = m.awaitBuilder(instance, cloudInitReadyTimeout, req.LogSink)
+		if errDeploy != nil && req.SSH.KeyPath != "" {
+			sinkf(req.LogSink, "[deploy] cloud-init bootstrap failed (%v) — deploying via SSH", errDeploy)
+			errDeploy = m.deployAndAwaitBuilder(instance, req)
 		}
+	case req.SSH.KeyPath != "":
+		errDeploy = m.deployAndAwaitBuilder(instance, req)
+	}
+	if errDeploy != nil {
+		// Deployment failed on a live, billed VM — destroy it rather than
+		// leaving an orphan running.
+		m.setInstanceStatus(instance, "deployment_failed")
+		m.rollback(instance)
+		return nil, fmt.Errorf("builder deployment failed: %w", errDeploy)
 	}
 
 	m.setInstanceStatus(instance, "running")
 	return instance, nil
 }
 
+// deployAndAwaitBuilder deploys the builder to instance via SSH and waits
+// for it to answer.
+func (m *Manager) deployAndAwaitBuilder(instance *Instance, req *ProvisionRequest) error {
+	if err := m.deployBuilder(instance, req); err != nil {
+		return err
+	}
+	m.setInstanceStatus(instance, "starting")
+	return m.waitForBuilderReady(instance, req.LogSink)
+}
+
+// Spend returns the number of tracked instances and their summed estimated
+// USD/hour cost. Every provisioned instance counts, including ones still
+// provisioning or awaiting a destroy retry, since all of them may be billing.
+// External instances do not.
+func (m *Manager) Spend() (instances int, hourly float64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.spendLocked()
+}
+
+// spendLocked is Spend for callers holding m.mu.
+func (m *Manager) spendLocked() (instances int, hourly float64) {
+	for _, inst := range m.instances {
+		if inst.Provider == ExternalProvider {
+			continue
+		}
+		instances++
+		hourly += inst.HourlyCost
+	}
+	return instances, hourly
+}
+
+// checkBudgetLocked reports whether one more instance costing hourlyCost fits
+// under the configured caps. costErr is the EstimateHourlyCost error: an
+// instance without a price cannot be held to a spend cap, so it is rejected
+// under one. Callers must hold m.mu.
+func (m *Manager) checkBudgetLocked(hourlyCost float64, costErr error) error {
+	count, hourly := m.spendLocked()
+	if m.maxInstances > 0 && count+1 > m.maxInstances {
+		return fmt.Errorf("%w: %d instance(s) already running, limit is %d", ErrBudgetExceeded, count, m.maxInstances)
+	}
+	if m.maxHourlySpend > 0 && costErr != nil {
+		return fmt.Errorf("%w: %w, set CLOUD_HOURLY_PRICES to provision it under a spend limit", ErrBudgetExceeded, costErr)
+	}
+	if m.maxHourlySpend > 0 && hourly+hourlyCost > m.maxHourlySpend {
+		return fmt.Errorf("%w: estimated spend would reach $%.2f/h (current $%.2f/h + $%.2f/h), limit is $%.2f/h",
+			ErrBudgetExceeded, hourly+hourlyCost, hourly, hourlyCost, m.maxHourlySpend)
+	}
+	return nil
+}
+
 // setInstanceStatus updates an instance's Status under the manager lock, so it
 // does not race the cleanup goroutine's status reads.
 func (m *Manager) setInstanceStatus(instance *Instance, status string) {
@@ -639,12 +839,26 @@ func (m *Manager) destroyInstance(instance *Instance) error {
 	m.mu.RLock()
 	env := instance.destroyEnv
 	dir := instance.TerraformDir
+	backend := instance.StateBackend
 	m.mu.RUnlock()
 
+	// With remote state, init first: the workspace may have lost its
+	// .terraform directory, or the state have moved on under another
+	// process, since the instance was provisioned.
+	if backend != "" {
+		initCtx, cancelInit := context.WithTimeout(context.Background(), terraformInitTimeout)
+		err := m.runTerraformCommand(initCtx, dir, env, nil, "init", "-input=false")
+		cancelInit()
+		if err != nil {
+			return fmt.Errorf("terraform init failed: %w", err)
+		}
+	}
+
 	// Bounded timeout so a hung destroy cannot block the cleanup routine forever.
 	ctx, cancel := context.WithTimeout(context.Background(), terraformDestroyTimeout)
 	defer cancel()
-	if err := m.runTerraformCommand(ctx, dir, env, nil, "destroy", "-auto-approve"); err != nil {
+	args := append([]string{"destroy", "-auto-approve"}, m.stateBackend.lockArgs(backend)...)
+	if err := m.runTerraformCommand(ctx, dir, env, nil, args...); err != nil {
 		return fmt.Errorf("terraform destroy failed: %w", err)
 	}
 	return nil
@@ -663,6 +877,16 @@ func (m *Manager) Terminate(instanceID string) error {
 		return fmt.Errorf("instance not found: %s", instanceID)
 	}
 
+	// The server did not create an external instance, so it only forgets it.
+	if instance.Provider == ExternalProvider {
+		m.mu.Lock()
+		delete(m.instances, instanceID)
+		m.mu.Unlock()
+		m.persistInstances()
+		fmt.Printf("Deregistered external instance %s\n", instanceID)
+		return nil
+	}
+
 	if err := m.destroyInstance(instance); err != nil {
 		m.mu.Lock()
 		instance.Status = "destroy_failed"
@@ -676,6 +900,7 @@ func (m *Manager) Terminate(instanceID string) error {
 	m.mu.Lock()
 	delete(m.instances, instanceID)
 	m.mu.Unlock()
+	m.persistInstances()
 
 	return nil
 }
@@ -714,7 +939,9 @@ func getOrDefault(m map[string]string, key, defaultValue string) string {
 	return defaultValue
 }
 
-// UpdateHeartbeat updates the last heartbeat time for an instance.
+// UpdateHeartbeat updates the last heartbeat time for an instance. An
+// instance being destroyed keeps its status, so a last heartbeat from its
+// builder cannot return it to the warm pool.
 func (m *Manager) UpdateHeartbeat(instanceID string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -725,7 +952,9 @@ func (m *Manager) UpdateHeartbeat(instanceID string) error {
 	}
 
 	instance.LastHeartbeat = time.Now()
-	instance.Status = "running"
+	if instance.Status != "terminating" && instance.Status != "destroy_failed" {
+		instance.Status = "running"
+	}
 	return nil
 }
 
@@ -748,8 +977,10 @@ func (m *Manager) CheckStaleInstances(timeout time.Duration) []*Instance {
 
 // runTerraformCommand executes a terraform command with the given arguments.
 func (m *Manager) runTerraformCommand(ctx context.Context, dir string, env []string, sink func(string), args ...string) error {
-	// -no-color keeps ANSI escapes out of the streamed job logs.
-	args = append(args, "-no-color")
+	// -no-color keeps ANSI escapes out of the streamed job logs. It goes
+	// right after the subcommand so it precedes positional arguments such
+	// as a saved plan file.
+	args = append([]string{args[0], "-no-color"}, args[1:]...)
 	cmd := exec.CommandContext(ctx, "terraform", args...)
 	cmd.Dir = dir
 	cmd.Env = append(os.Environ(), env...)
@@ -844,6 +1075,10 @@ func (m *Manager) prepareEnvironment(req *ProvisionRequest) []string {
 			env = append(env, "AWS_ACCESS_KEY_ID="+req.Credentials.AWSAccessKey)
 			env = append(env, "AWS_SECRET_ACCESS_KEY="+req.Credentials.AWSSecretKey)
 		}
+	case "hetzner":
+		if req.Credentials.HetznerToken != "" {
+			env = append(env, "HCLOUD_TOKEN="+req.Credentials.HetznerToken)
+		}
 	case "pve":
 		if req.Credentials.PVETokenID != "" {
 			env = append(env, "PM_API_TOKEN_ID="+req.Credentials.PVETokenID)
@@ -872,7 +1107,7 @@ func (m *Manager) deployBuilder(instance *Instance, req *ProvisionRequest) error
 	sinkf(req.LogSink, "[deploy] SSH is up")
 
 	// Create deployment script
-	script := m.generateDeploymentScript(req)
+	script := m.generateDeploymentScript(req, instance.ID)
 	scriptPath := filepath.Join(instance.TerraformDir, "deploy.sh")
 	if err := os.WriteFile(scriptPath, []byte(script), 0600); err != nil {
 		return fmt.Errorf("failed to write deployment script: %w", err)
@@ -935,7 +1170,13 @@ func (m *Manager) deployBuilder(instance *Instance, req *ProvisionRequest) error
 		bootstrapMsg = "[deploy] configuring native Gentoo build node (make.conf + signing + builder)…"
 	}
 	sinkf(req.LogSink, "%s", bootstrapMsg)
-	if err := m.sshExecuteStream(instance, req.SSH, "chmod +x /tmp/deploy.sh && /tmp/deploy.sh", req.LogSink); err != nil {
+	command := "chmod +x /tmp/deploy.sh && /tmp/deploy.sh"
+	if m.selfBootstrapScript(req, instance.ID) != "" {
+		// Falling back after the user-data bootstrap failed: let it finish
+		// first rather than run the script twice at once.
+		command = "{ cloud-init status --wait >/dev/null 2>&1 || true; } && " + command
+	}
+	if err := m.sshExecuteStream(instance, req.SSH, command, req.LogSink); err != nil {
 		return fmt.Errorf("failed to execute deployment script: %w", err)
 	}
 	sinkf(req.LogSink, "[deploy] builder deployed")
@@ -1114,14 +1355,15 @@ func (m *Manager) sshCopyFile(instance *Instance, cfg *SSHConfig, localPath, rem
 }
 
 // generateDeploymentScript generates a shell script to deploy the builder onto
-// a provisioned instance.
-func (m *Manager) generateDeploymentScript(req *ProvisionRequest) string {
+// a provisioned instance. The builder is configured to register under
+// instanceID, so jobs, builders and cloud instances share one ID.
+func (m *Manager) generateDeploymentScript(req *ProvisionRequest, instanceID string) string {
 	arch := req.Arch
 	if arch == "" {
 		arch = "amd64"
 	}
 	if req.BuildMode == "native-gentoo" {
-		return m.generateGentooNativeScript(req, arch)
+		return m.generateGentooNativeScript(req, arch, instanceID)
 	}
 	portageMirror := "https://distfiles.gentoo.org"
 	if req.GentooMirror != "" {
@@ -1133,8 +1375,9 @@ func (m *Manager) generateDeploymentScript(req *ProvisionRequest) string {
 	}
 	config := &CloudInitConfig{
 		DockerImage:          dockerImage,
-		PullLatestImage:      true,
+		PullLatestImage:      !req.PrebakedImage,
 		PortageTreeSync:      true,
+		Prebaked:             req.PrebakedImage,
 		PortageMirror:        portageMirror,
 		AptMirror:            req.AptMirror,
 		DockerDownloadMirror: req.DockerDownloadMirror,
@@ -1155,6 +1398,11 @@ func (m *Manager) generateDeploymentScript(req *ProvisionRequest) string {
 		EnableFirewall:       true,
 		GPGKeyID:             req.GPGKeyID,
 		BuildFeatures:        req.BuildFeatures,
+		InstanceID:           instanceID,
+		SpotInstance:         req.Provider == "aws" && AWSInstanceSpecFromMap(req.Spec, arch).Spot,
+	}
+	if req.Provider == "hetzner" && HetznerInstanceSpecFromMap(req.Spec, arch).VolumeSize > 0 {
+		config.CacheVolumeDevice = hetznerCacheDevice
 	}
 
 	return GenerateCloudInitScript(config)
@@ -1162,7 +1410,7 @@ func (m *Manager) generateDeploymentScript(req *ProvisionRequest) string {
 
 // generateGentooNativeScript builds the deployment script for a native Gentoo
 // VM (no Docker; in-emerge signing).
-func (m *Manager) generateGentooNativeScript(req *ProvisionRequest, arch string) string {
+func (m *Manager) generateGentooNativeScript(req *ProvisionRequest, arch, instanceID string) string {
 	config := &CloudInitConfig{
 		Architecture:      arch,
 		AptMirror:         req.AptMirror,
@@ -1178,26 +1426,80 @@ func (m *Manager) generateGentooNativeScript(req *ProvisionRequest, arch string)
 		DataDir:           "/var/lib/portage-engine",
 		WorkDir:           "/var/tmp/portage-builds",
 		ArtifactDir:       "/var/tmp/portage-artifacts",
+		InstanceID:        instanceID,
 	}
 	return GenerateGentooNativeScript(config)
 }
 
+// User-data size limits: EC2 takes 16 KB of raw user data, ECS 32 KB once
+// base64-encoded.
+const (
+	awsUserDataLimit    = 16 << 10
+	aliyunUserDataLimit = (32 << 10) / 4 * 3
+)
+
+// selfBootstrapScript returns the bootstrap script an AWS or Aliyun instance
+// runs from its user data, so the builder installs and starts itself the way
+// a GCP instance's startup script does, or "" when it is deployed over SSH
+// instead: on other providers, for a native Gentoo build, with spec
+// "bootstrap" set to "ssh", when files must be pushed to the instance (a
+// local builder binary, the signing key, or the vendored docker install
+// script a download mirror needs), or when the script exceeds the provider's
+// user-data limit.
+func (m *Manager) selfBootstrapScript(req *ProvisionRequest, instanceID string) string {
+	limit := 0
+	switch req.Provider {
+	case "aws":
+		limit = awsUserDataLimit
+	case "aliyun":
+		limit = aliyunUserDataLimit
+	default:
+		return ""
+	}
+	if req.BuildMode == "native-gentoo" || getOrDefault(req.Spec, "bootstrap", "") == "ssh" ||
+		req.BuilderBinaryPath != "" || len(req.GPGSecretKey) > 0 || req.DockerDownloadMirror != "" {
+		return ""
+	}
+	script := m.generateDeploymentScript(req, instanceID)
+	if len(script) > limit {
+		return ""
+	}
+	return script
+}
+
+// userDataBlock renders the user_data argument of an aws_instance or
+// alicloud_instance carrying the bootstrap script, or "" without one.
+func userDataBlock(script string) string {
+	if script == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n  user_data = <<-CLOUDINIT\n%s\n  CLOUDINIT\n", terraformHeredocEscape(script))
+}
+
 // generateTerraformConfig generates Terraform configuration based on provider.
 // An error (rather than an empty config) is returned on misconfiguration:
 // writing an empty main.tf would let init/apply "succeed" and only fail later
 // at the ip_address output, hiding the real cause.
-func (m *Manager) generateTerraformConfig(req *ProvisionRequest) (string, error) {
+func (m *Manager) generateTerraformConfig(req *ProvisionRequest, instanceID string) (string, error) {
 	region := getOrDefault(req.Spec, "region", "us-central1")
 	zone := getOrDefault(req.Spec, "zone", "")
 
 	var config string
 	switch req.Provider {
 	case "aliyun":
-		config = m.generateAliyunConfig(req, region, zone)
+		config = m.generateAliyunConfig(req, instanceID, region, zone)
 	case "gcp":
 		config = m.generateGCPConfig(req, region, zone)
 	case "aws":
-		config = m.generateAWSConfig(req, region, zone)
+		if err := ValidateAWSSpec(AWSInstanceSpecFromMap(req.Spec, req.Arch)); err != nil {
+			return "", fmt.Errorf("invalid AWS spec: %w", err)
+		}
+		config = m.generateAWSConfig(req, instanceID, region, zone)
+	case "hetzner":
+		if err := ValidateHetznerSpec(HetznerInstanceSpecFromMap(req.Spec, req.Arch), req.Arch); err != nil {
+			return "", fmt.Errorf("invalid Hetzner spec: %w", err)
+		}
+		config = m.generateHetznerConfig(req)
 	case "pve":
 		return m.generatePVEConfig(req)
 	default:
@@ -1223,6 +1525,8 @@ func (m *Manager) generateFirewallConfig(req *ProvisionRequest) string {
 		return m.generateGCPFirewall(req, allowedIPs)
 	case "aws":
 		return m.generateAWSFirewall(req, allowedIPs)
+	case "hetzner":
+		return m.generateHetznerFirewall(req, allowedIPs)
 	case "pve":
 		return "" // PVE uses Proxmox's built-in firewall, configured via API
 	default:
@@ -1230,11 +1534,17 @@ func (m *Manager) generateFirewallConfig(req *ProvisionRequest) string {
 	}
 }
 
-// generateAliyunConfig generates Aliyun-specific Terraform config.
-func (m *Manager) generateAliyunConfig(req *ProvisionRequest, region, zone string) string {
+// aliyunInstanceType is the ECS instance type generateAliyunConfig provisions.
+const aliyunInstanceType = "ecs.c6.large"
+
+// generateAliyunConfig generates Aliyun-specific Terraform config, with the
+// bootstrap script of instanceID as user data when it bootstraps itself.
+func (m *Manager) generateAliyunConfig(req *ProvisionRequest, instanceID, region, zone string) string {
 	if zone == "" {
 		zone = region + "-a"
 	}
+	// spec "image_id" selects a prebuilt custom image instead of stock Ubuntu.
+	imageID := getOrDefault(req.Spec, "image_id", "ubuntu_20_04_x64_20G_alibase_20210420.vhd")
 
 	return fmt.Sprintf(`
 terraform {
@@ -1263,19 +1573,17 @@ resource "alicloud_vswitch" "portage" {
 
 resource "alicloud_instance" "portage_builder" {
   instance_name   = "portage-builder-%s"
-  instance_type   = "ecs.c6.large"
-  image_id        = "ubuntu_20_04_x64_20G_alibase_20210420.vhd"
+  instance_type   = "%s"
+  image_id        = "%s"
   vswitch_id      = alicloud_vswitch.portage.id
   security_groups = [alicloud_security_group.portage.id]
 
   internet_max_bandwidth_out = 100
   system_disk_category       = "cloud_efficiency"
   system_disk_size          = 50
-
+%s
   tags = {
-    Purpose = "PortageBuild"
-    Arch    = "%s"
-  }
+%s  }
 }
 
 output "ip_address" {
@@ -1285,7 +1593,7 @@ output "ip_address" {
 output "private_ip" {
   value = alicloud_instance.portage_builder.private_ip
 }
-`, region, zone, req.Arch, req.Arch)
+`, region, zone, req.Arch, aliyunInstanceType, imageID, userDataBlock(m.selfBootstrapScript(req, instanceID)), labelEntries(mergeLabels(req.Labels, "Purpose", "PortageBuild", "Arch", req.Arch), "    ", false))
 }
 
 // generateAliyunFirewall generates Aliyun security group rules.
@@ -1353,6 +1661,7 @@ func (m *Manager) generateGCPConfig(req *ProvisionRequest, region, zone string)
 		gcpConfig.SSHKeyPath = req.SSH.KeyPath
 		gcpConfig.SSHUser = req.SSH.User
 	}
+	gcpConfig.RemoteState = m.stateBackend.backendType("gcp") != ""
 
 	provisioner, err := NewGCPProvisioner(gcpConfig)
 	if err != nil {
@@ -1360,6 +1669,7 @@ func (m *Manager) generateGCPConfig(req *ProvisionRequest, region, zone string)
 		return m.generateBasicGCPConfig(req, region, zone)
 	}
 
+	spec.Labels = req.Labels
 	instanceName := fmt.Sprintf("portage-builder-%s-%d", req.Arch, time.Now().Unix())
 	return provisioner.GenerateMainTF(spec, instanceName)
 }
@@ -1402,6 +1712,9 @@ resource "google_compute_instance" "portage_builder" {
 
   tags = ["portage-builder", "allow-builder-%d"]
 
+  labels = {
+%s  }
+
   metadata = {
     ssh-keys = "root:${file("~/.ssh/id_rsa.pub")}"
   }
@@ -1414,7 +1727,8 @@ output "ip_address" {
 output "private_ip" {
   value = google_compute_instance.portage_builder.network_interface[0].network_ip
 }
-`, project, region, req.Arch, zone, req.BuilderPort)
+`, project, region, req.Arch, zone, req.BuilderPort,
+		labelEntries(mergeLabels(req.Labels, "purpose", "portage-builder"), "    ", true))
 }
 
 // generateGCPFirewall generates GCP firewall rules.
@@ -1509,15 +1823,17 @@ func awsAMINameArch(arch string) string {
 // up everything the builder deploy needs — a region-agnostic Ubuntu AMI looked
 // up via a data source (not a hardcoded, region-specific AMI), an injected SSH
 // key pair so deployBuilder can connect, an arch-appropriate instance type, and
-// the security group / networking. It has NOT been validated against a live AWS
-// account, so real provisioning may still surface AMI/cloud-init/timing details
-// that only a real run reveals.
-func (m *Manager) generateAWSConfig(req *ProvisionRequest, region, zone string) string {
+// the security group / networking. The bootstrap script of instanceID goes in
+// as user data when the instance bootstraps itself (see selfBootstrapScript),
+// leaving deployBuilder as the fallback. It has NOT been validated against a
+// live AWS account, so real provisioning may still surface AMI/cloud-init/timing
+// details that only a real run reveals.
+func (m *Manager) generateAWSConfig(req *ProvisionRequest, instanceID, region, zone string) string {
 	if zone == "" {
 		zone = region + "a"
 	}
 
-	instanceType := getOrDefault(req.Spec, "instance_type", awsInstanceTypeForArch(req.Arch))
+	spec := AWSInstanceSpecFromMap(req.Spec, req.Arch)
 	amiArch := awsAMIArchFilter(req.Arch)
 	amiNameArch := awsAMINameArch(req.Arch)
 
@@ -1537,20 +1853,10 @@ resource "aws_key_pair" "portage" {
 		keyNameLine = "  key_name               = aws_key_pair.portage.key_name\n"
 	}
 
-	return fmt.Sprintf(`
-terraform {
-  required_providers {
-    aws = {
-      source  = "hashicorp/aws"
-      version = "~> 5.0"
-    }
-  }
-}
-
-provider "aws" {
-  region = "%s"
-}
-
+	// A prebuilt AMI (spec "ami", e.g. baked with Packer) skips the Ubuntu
+	// lookup entirely; otherwise resolve the latest Ubuntu 22.04 for the arch.
+	amiRef := "data.aws_ami.ubuntu.id"
+	amiDataSource := fmt.Sprintf(`
 # Latest Ubuntu 22.04 AMI for the target arch, resolved at apply time so the
 # config is not tied to a single region's hardcoded AMI ID.
 data "aws_ami" "ubuntu" {
@@ -1570,7 +1876,27 @@ data "aws_ami" "ubuntu" {
     values = ["%s"]
   }
 }
+`, amiNameArch, amiArch)
+	if spec.AMI != "" {
+		amiRef = fmt.Sprintf("%q", spec.AMI)
+		amiDataSource = ""
+	}
 
+	return fmt.Sprintf(`
+terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 5.0"
+    }
+  }
+}
+
+provider "aws" {
+  region = "%s"
+%s}
+
+%s
 resource "aws_vpc" "portage" {
   cidr_block           = "10.0.0.0/16"
   enable_dns_hostnames = true
@@ -1618,11 +1944,11 @@ resource "aws_route_table_association" "portage" {
 }
 %s
 resource "aws_instance" "portage_builder" {
-  ami                    = data.aws_ami.ubuntu.id
+  ami                    = %s
   instance_type          = "%s"
   subnet_id              = aws_subnet.portage.id
   vpc_security_group_ids = [aws_security_group.portage.id]
-%s
+%s%s%s
   root_block_device {
     volume_size = 50
     volume_type = "gp3"
@@ -1642,7 +1968,16 @@ output "ip_address" {
 output "private_ip" {
   value = aws_instance.portage_builder.private_ip
 }
-`, region, amiNameArch, amiArch, zone, keyPairResource, instanceType, keyNameLine, req.Arch, req.Arch)
+`, region, awsDefaultTags(req.Labels), amiDataSource, zone, keyPairResource, amiRef, spec.InstanceType, keyNameLine, spec.marketOptionsBlock(), userDataBlock(m.selfBootstrapScript(req, instanceID)), req.Arch, req.Arch)
+}
+
+// awsDefaultTags renders the default_tags block tagging every AWS resource
+// of the instance with labels, or "" without any.
+func awsDefaultTags(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return "\n  default_tags {\n    tags = {\n" + labelEntries(labels, "      ", false) + "    }\n  }\n"
 }
 
 // generateAWSFirewall generates AWS security group rules.
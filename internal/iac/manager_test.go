@@ -1,6 +1,7 @@
 package iac
 
 import (
+	"errors"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -280,7 +281,7 @@ func TestGenerateTerraformConfig(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			config, err := manager.generateTerraformConfig(tt.req)
+			config, err := manager.generateTerraformConfig(tt.req, "")
 			if !tt.wantEmpty {
 				if err != nil {
 					t.Fatalf("generateTerraformConfig() error: %v", err)
@@ -305,7 +306,7 @@ func TestGenerateDeploymentScript(t *testing.T) {
 		BuilderToken:   "secret-token",
 		Arch:           "amd64",
 		BinpkgHost:     "http://localhost:8080/binpkgs",
-	})
+	}, "")
 
 	if len(script) == 0 {
 		t.Fatal("generateDeploymentScript() returned empty script")
@@ -343,6 +344,121 @@ func TestGenerateDeploymentScript(t *testing.T) {
 	}
 }
 
+func TestGenerateDeploymentScriptPrebaked(t *testing.T) {
+	manager := NewManager()
+
+	script := manager.generateDeploymentScript(&ProvisionRequest{
+		BuilderPort:   9090,
+		Arch:          "amd64",
+		PrebakedImage: true,
+	}, "")
+	if !strings.Contains(script, "already present; skipping pull") {
+		t.Error("prebaked deploy should reuse the baked-in Docker image")
+	}
+	if !strings.Contains(script, "Portage tree already present in image; skipping sync") {
+		t.Error("prebaked deploy should reuse the baked-in portage tree")
+	}
+}
+
+func TestGenerateDeploymentScriptInstanceID(t *testing.T) {
+	manager := NewManager()
+
+	for _, mode := range []string{"", "native-gentoo"} {
+		t.Run("mode="+mode, func(t *testing.T) {
+			script := manager.generateDeploymentScript(&ProvisionRequest{
+				BuilderPort: 9090,
+				Arch:        "amd64",
+				BuildMode:   mode,
+			}, "pve-1700000000")
+			// The builder must register under the IaC instance ID, not its hostname.
+			if !strings.Contains(script, "INSTANCE_ID_VAL='pve-1700000000'") {
+				t.Error("deployment script does not assign the IaC instance ID")
+			}
+			if strings.Contains(script, "INSTANCE_ID_VAL=$(hostname)") {
+				t.Error("deployment script falls back to the hostname despite an assigned ID")
+			}
+
+			// Without an assigned ID the hostname is still used.
+			script = manager.generateDeploymentScript(&ProvisionRequest{
+				BuilderPort: 9090,
+				Arch:        "amd64",
+				BuildMode:   mode,
+			}, "")
+			if !strings.Contains(script, "INSTANCE_ID_VAL=$(hostname)") {
+				t.Error("deployment script without an ID should use the hostname")
+			}
+		})
+	}
+}
+
+func TestProvisionBudgetCap(t *testing.T) {
+	t.Run("instance limit", func(t *testing.T) {
+		m := NewManager(WithMaxInstances(1))
+		m.instances["existing"] = &Instance{ID: "existing", Provider: "pve", Status: "running"}
+
+		_, err := m.Provision(&ProvisionRequest{Provider: "aws", Arch: "amd64"})
+		if !errors.Is(err, ErrBudgetExceeded) {
+			t.Fatalf("Provision() error = %v, want ErrBudgetExceeded", err)
+		}
+		if len(m.instances) != 1 {
+			t.Errorf("rejected provision should not be tracked, have %d instances", len(m.instances))
+		}
+	})
+
+	t.Run("hourly spend limit", func(t *testing.T) {
+		m := NewManager(WithMaxHourlySpend(1.0))
+		m.instances["existing"] = &Instance{ID: "existing", Provider: "aws", Status: "running", HourlyCost: 0.95}
+
+		_, err := m.Provision(&ProvisionRequest{Provider: "aws", Arch: "amd64"})
+		if !errors.Is(err, ErrBudgetExceeded) {
+			t.Fatalf("Provision() error = %v, want ErrBudgetExceeded", err)
+		}
+		if count, hourly := m.Spend(); count != 1 || hourly != 0.95 {
+			t.Errorf("Spend() = (%d, %v), want (1, 0.95)", count, hourly)
+		}
+	})
+
+	t.Run("free instances fit a spend limit", func(t *testing.T) {
+		m := NewManager(WithMaxHourlySpend(1.0))
+		m.instances["existing"] = &Instance{ID: "existing", Provider: "aws", HourlyCost: 0.9}
+
+		m.mu.Lock()
+		err := m.checkBudgetLocked(EstimateHourlyCost(&ProvisionRequest{Provider: "pve"}, nil))
+		m.mu.Unlock()
+		if err != nil {
+			t.Errorf("PVE instance should fit the spend cap: %v", err)
+		}
+	})
+
+	t.Run("unpriced instance under a spend limit", func(t *testing.T) {
+		req := &ProvisionRequest{Provider: "aws", Spec: map[string]string{"instance_type": "p5.48xlarge"}}
+
+		m := NewManager(WithMaxHourlySpend(100))
+		m.mu.Lock()
+		err := m.checkBudgetLocked(EstimateHourlyCost(req, nil))
+		m.mu.Unlock()
+		if !errors.Is(err, ErrBudgetExceeded) || !errors.Is(err, ErrUnpricedInstance) {
+			t.Errorf("checkBudgetLocked() error = %v, want ErrBudgetExceeded and ErrUnpricedInstance", err)
+		}
+
+		aliyun := &ProvisionRequest{Provider: "aliyun", Arch: "amd64"}
+		m.mu.Lock()
+		err = m.checkBudgetLocked(EstimateHourlyCost(aliyun, nil))
+		m.mu.Unlock()
+		if !errors.Is(err, ErrUnpricedInstance) {
+			t.Errorf("aliyun checkBudgetLocked() error = %v, want ErrUnpricedInstance", err)
+		}
+
+		m = NewManager(WithMaxHourlySpend(100), WithHourlyPrices(map[string]float64{"p5.48xlarge": 98.32}))
+		m.mu.Lock()
+		err = m.checkBudgetLocked(EstimateHourlyCost(req, m.hourlyPrices))
+		m.mu.Unlock()
+		if err != nil {
+			t.Errorf("operator-priced instance should fit the spend cap: %v", err)
+		}
+	})
+}
+
 func TestPrepareEnvironment(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -389,6 +505,18 @@ func TestPrepareEnvironment(t *testing.T) {
 				"AWS_SECRET_ACCESS_KEY=test-secret",
 			},
 		},
+		{
+			name: "hetzner credentials",
+			req: &ProvisionRequest{
+				Provider: "hetzner",
+				Credentials: &CloudCredentials{
+					HetznerToken: "test-token",
+				},
+			},
+			wantEnv: []string{
+				"HCLOUD_TOKEN=test-token",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -440,7 +568,7 @@ func TestProvisionWorkflow(t *testing.T) {
 	}
 
 	// Test config generation
-	config, err := manager.generateTerraformConfig(req)
+	config, err := manager.generateTerraformConfig(req, "")
 	if err != nil {
 		t.Fatalf("generateTerraformConfig() error: %v", err)
 	}
@@ -813,7 +941,7 @@ func TestGenerateAWSConfig(t *testing.T) {
 		SSH:      &SSHConfig{KeyPath: "/home/build/.ssh/id_ed25519", User: "ubuntu"},
 		Spec:     map[string]string{"region": "eu-west-1"},
 	}
-	main := m.generateAWSConfig(req, "eu-west-1", "")
+	main := m.generateAWSConfig(req, "", "eu-west-1", "")
 	fw := m.generateAWSFirewall(req, []string{"10.0.0.0/8"})
 
 	// Must NOT contain the old hardcoded, region-specific AMI.
@@ -836,11 +964,24 @@ func TestGenerateAWSConfig(t *testing.T) {
 	}
 
 	// amd64 default instance type.
-	amd := m.generateAWSConfig(&ProvisionRequest{Provider: "aws", Arch: "amd64"}, "us-east-1", "")
+	amd := m.generateAWSConfig(&ProvisionRequest{Provider: "aws", Arch: "amd64"}, "", "us-east-1", "")
 	if !strings.Contains(amd, `"t3.large"`) {
 		t.Error("amd64 should default to t3.large")
 	}
 
+	// A prebuilt AMI replaces the Ubuntu lookup.
+	baked := m.generateAWSConfig(&ProvisionRequest{
+		Provider: "aws",
+		Arch:     "amd64",
+		Spec:     map[string]string{"ami": "ami-0123456789abcdef0"},
+	}, "", "us-east-1", "")
+	if !strings.Contains(baked, `ami                    = "ami-0123456789abcdef0"`) {
+		t.Error("prebuilt AMI not used by the instance")
+	}
+	if strings.Contains(baked, `data "aws_ami" "ubuntu"`) {
+		t.Error("prebuilt AMI should skip the Ubuntu AMI lookup")
+	}
+
 	// Full `terraform init`/`validate` downloads the AWS provider (slow, network),
 	// so it is opt-in via PORTAGE_TF_VALIDATE=1 rather than run on every `go test`.
 	if os.Getenv("PORTAGE_TF_VALIDATE") != "1" {
@@ -0,0 +1,186 @@
+// Package iac provides terraform plan runs and their parsed summaries.
+package iac
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// planFile is the saved plan Provision applies, so the apply does
+	// exactly what the plan showed.
+	planFile = "plan.tfplan"
+	// terraformPlanTimeout bounds a plan, which mostly waits on provider
+	// API calls.
+	terraformPlanTimeout = 10 * time.Minute
+)
+
+// PlanSummary is the parsed outcome of a terraform plan.
+type PlanSummary struct {
+	Add     int             `json:"add"`
+	Change  int             `json:"change"`
+	Destroy int             `json:"destroy"`
+	Changes []PlannedChange `json:"changes"`
+	// Warnings are the plan's warning diagnostics.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// PlannedChange is one resource change in a plan.
+type PlannedChange struct {
+	Address string `json:"address"` // e.g. aws_instance.portage_builder
+	Action  string `json:"action"`  // create, update, delete, replace, ...
+}
+
+// String returns the plan's one-line summary, as terraform prints it.
+func (p *PlanSummary) String() string {
+	return fmt.Sprintf("%d to add, %d to change, %d to destroy", p.Add, p.Change, p.Destroy)
+}
+
+// planMessage is one line of `terraform plan -json` output. Only the message
+// types the summary needs are decoded.
+type planMessage struct {
+	Message string `json:"@message"`
+	Type    string `json:"type"`
+	Change  *struct {
+		Resource struct {
+			Addr string `json:"addr"`
+		} `json:"resource"`
+		Action string `json:"action"`
+	} `json:"change"`
+	Changes *struct {
+		Add    int `json:"add"`
+		Change int `json:"change"`
+		Remove int `json:"remove"`
+	} `json:"changes"`
+	Diagnostic *struct {
+		Severity string `json:"severity"`
+		Summary  string `json:"summary"`
+		Detail   string `json:"detail"`
+	} `json:"diagnostic"`
+}
+
+// parsePlanOutput parses `terraform plan -json` output into a summary and
+// the plan's error diagnostics, forwarding each human-readable message to
+// sink. Lines that are not JSON messages are forwarded as they are.
+func parsePlanOutput(r io.Reader, sink func(string)) (*PlanSummary, []string) {
+	summary := &PlanSummary{}
+	var errs []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var msg planMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			sinkf(sink, "[terraform] %s", scanner.Text())
+			continue
+		}
+		if msg.Message != "" {
+			sinkf(sink, "[terraform] %s", msg.Message)
+		}
+		switch msg.Type {
+		case "planned_change":
+			if msg.Change != nil {
+				summary.Changes = append(summary.Changes, PlannedChange{Address: msg.Change.Resource.Addr, Action: msg.Change.Action})
+			}
+		case "change_summary":
+			if msg.Changes != nil {
+				summary.Add = msg.Changes.Add
+				summary.Change = msg.Changes.Change
+				summary.Destroy = msg.Changes.Remove
+			}
+		case "diagnostic":
+			if msg.Diagnostic == nil {
+				continue
+			}
+			text := msg.Diagnostic.Summary
+			if msg.Diagnostic.Detail != "" {
+				text += ": " + msg.Diagnostic.Detail
+			}
+			if msg.Diagnostic.Severity == "error" {
+				errs = append(errs, text)
+			} else {
+				summary.Warnings = append(summary.Warnings, text)
+			}
+		}
+	}
+	return summary, errs
+}
+
+// runTerraformPlan runs `terraform plan` in dir with the extra arguments,
+// saving the plan to planFile, and returns its parsed summary. A failed
+// plan's error carries terraform's error diagnostics, e.g. invalid
+// credentials or an exhausted quota.
+func (m *Manager) runTerraformPlan(ctx context.Context, dir string, env []string, sink func(string), extra ...string) (*PlanSummary, error) {
+	args := append([]string{"plan", "-json", "-input=false", "-no-color", "-out=" + planFile}, extra...)
+	cmd := exec.CommandContext(ctx, "terraform", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("terraform plan: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("terraform plan: %w", err)
+	}
+	summary, errs := parsePlanOutput(stdout, sink)
+	if err := cmd.Wait(); err != nil {
+		detail := strings.Join(errs, "; ")
+		if detail == "" {
+			// With -json, terraform reports errors as diagnostics; stderr
+			// only carries failures to start at all.
+			detail = strings.TrimSpace(stderr.String())
+			if len(detail) > 4096 {
+				detail = detail[len(detail)-4096:]
+			}
+		}
+		return nil, fmt.Errorf("terraform plan failed: %w: %s", err, detail)
+	}
+	return summary, nil
+}
+
+// dryRun plans a provision without applying it. Nothing is created, so the
+// returned "planned" instance is not tracked, reserves no budget and its
+// workspace is removed.
+func (m *Manager) dryRun(req *ProvisionRequest, instanceID, terraformDir string, env, lockArgs []string) (*Instance, error) {
+	defer func() { _ = os.RemoveAll(terraformDir) }()
+
+	sinkf(req.LogSink, "[provision] dry run %s (provider %s)", instanceID, req.Provider)
+	sinkf(req.LogSink, "[provision] running terraform init…")
+	initCtx, cancelInit := context.WithTimeout(context.Background(), terraformInitTimeout)
+	errInit := m.runTerraformCommand(initCtx, terraformDir, env, req.LogSink, "init")
+	cancelInit()
+	if errInit != nil {
+		return nil, fmt.Errorf("terraform init failed: %w", errInit)
+	}
+
+	sinkf(req.LogSink, "[provision] running terraform plan…")
+	planCtx, cancelPlan := context.WithTimeout(context.Background(), terraformPlanTimeout)
+	plan, err := m.runTerraformPlan(planCtx, terraformDir, env, req.LogSink, lockArgs...)
+	cancelPlan()
+	if err != nil {
+		return nil, err
+	}
+	sinkf(req.LogSink, "[provision] plan: %s", plan)
+
+	hourlyCost, _ := EstimateHourlyCost(req, m.hourlyPrices)
+	return &Instance{
+		ID:         instanceID,
+		Provider:   req.Provider,
+		Status:     "planned",
+		Arch:       req.Arch,
+		Metadata:   req.Spec,
+		CreatedAt:  time.Now(),
+		HourlyCost: hourlyCost,
+		Plan:       plan,
+	}, nil
+}
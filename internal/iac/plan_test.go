@@ -0,0 +1,144 @@
+package iac
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const samplePlanOutput = `{"@level":"info","@message":"Terraform 1.7.5","type":"version"}
+{"@level":"info","@message":"aws_instance.portage_builder: Plan to create","type":"planned_change","change":{"resource":{"addr":"aws_instance.portage_builder"},"action":"create"}}
+{"@level":"info","@message":"aws_security_group.portage: Plan to replace","type":"planned_change","change":{"resource":{"addr":"aws_security_group.portage"},"action":"replace"}}
+{"@level":"warn","@message":"Warning: Deprecated attribute","type":"diagnostic","diagnostic":{"severity":"warning","summary":"Deprecated attribute","detail":"use vpc_security_group_ids"}}
+{"@level":"info","@message":"Plan: 2 to add, 0 to change, 1 to destroy.","type":"change_summary","changes":{"add":2,"change":0,"remove":1,"operation":"plan"}}
+not json
+`
+
+func TestParsePlanOutput(t *testing.T) {
+	t.Parallel()
+
+	var logged []string
+	summary, errs := parsePlanOutput(strings.NewReader(samplePlanOutput), func(s string) { logged = append(logged, s) })
+	if len(errs) != 0 {
+		t.Errorf("errs = %v, want none", errs)
+	}
+	if summary.Add != 2 || summary.Change != 0 || summary.Destroy != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if got := summary.String(); got != "2 to add, 0 to change, 1 to destroy" {
+		t.Errorf("String() = %q", got)
+	}
+	want := []PlannedChange{{"aws_instance.portage_builder", "create"}, {"aws_security_group.portage", "replace"}}
+	if len(summary.Changes) != len(want) {
+		t.Fatalf("Changes = %v, want %v", summary.Changes, want)
+	}
+	for i := range want {
+		if summary.Changes[i] != want[i] {
+			t.Errorf("Changes[%d] = %v, want %v", i, summary.Changes[i], want[i])
+		}
+	}
+	if len(summary.Warnings) != 1 || summary.Warnings[0] != "Deprecated attribute: use vpc_security_group_ids" {
+		t.Errorf("Warnings = %v", summary.Warnings)
+	}
+	if len(logged) != 6 || logged[5] != "[terraform] not json" {
+		t.Errorf("logged = %q", logged)
+	}
+
+	_, errs = parsePlanOutput(strings.NewReader(`{"type":"diagnostic","diagnostic":{"severity":"error","summary":"No valid credential sources found"}}`), nil)
+	if len(errs) != 1 || errs[0] != "No valid credential sources found" {
+		t.Errorf("errs = %v", errs)
+	}
+}
+
+// planTerraform puts a terraform on PATH that prints samplePlanOutput for
+// plan, or fails it with an error diagnostic when failPlan is set, and
+// records every invocation's arguments in the returned file.
+func planTerraform(t *testing.T, failPlan bool) string {
+	t.Helper()
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	if err := os.WriteFile(filepath.Join(dir, "plan.out"), []byte(samplePlanOutput), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	plan := `cat "` + filepath.Join(dir, "plan.out") + `"`
+	if failPlan {
+		plan = `echo '{"type":"diagnostic","diagnostic":{"severity":"error","summary":"Error: quota exceeded"}}'; exit 1`
+	}
+	script := "#!/bin/sh\necho \"$@\" >> \"" + calls + "\"\n" +
+		"case \"$1\" in\nplan) " + plan + " ;;\nesac\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "terraform"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+func TestProvisionDryRun(t *testing.T) {
+	calls := planTerraform(t, false)
+	m := NewManager()
+	m.workspaceDir = t.TempDir()
+
+	inst, err := m.Provision(&ProvisionRequest{Provider: "aws", Arch: "amd64", DryRun: true})
+	if err != nil {
+		t.Fatalf("Provision() = %v", err)
+	}
+	if inst.Status != "planned" || inst.Plan == nil || inst.Plan.Add != 2 || len(inst.Plan.Changes) != 2 {
+		t.Errorf("instance = %+v, plan %+v", inst, inst.Plan)
+	}
+	if len(m.ListInstances()) != 0 {
+		t.Error("a dry run is tracked as an instance")
+	}
+	if entries, _ := os.ReadDir(m.workspaceDir); len(entries) != 0 {
+		t.Error("a dry run leaves its workspace behind")
+	}
+	got, _ := os.ReadFile(calls)
+	if strings.Contains(string(got), "apply") {
+		t.Errorf("a dry run applied: %s", got)
+	}
+}
+
+func TestProvisionAppliesSavedPlan(t *testing.T) {
+	calls := planTerraform(t, false)
+	m := NewManager()
+	m.workspaceDir = t.TempDir()
+
+	// Deploying over SSH, which without a key is skipped, rather than waiting
+	// for a builder bootstrapping itself.
+	inst, err := m.Provision(&ProvisionRequest{Provider: "aws", Arch: "amd64", Spec: map[string]string{"bootstrap": "ssh"}})
+	if err != nil {
+		t.Fatalf("Provision() = %v", err)
+	}
+	if inst.Plan == nil || inst.Plan.Add != 2 {
+		t.Errorf("plan = %+v", inst.Plan)
+	}
+	tf, _ := os.ReadFile(filepath.Join(inst.TerraformDir, "main.tf"))
+	for _, label := range []string{`"managed-by" = "portage-engine"`, `"instance-id" = "` + inst.ID + `"`, `"ttl" = "3600"`, `"created-at" = "`} {
+		if !strings.Contains(string(tf), label) {
+			t.Errorf("main.tf lacks the label %s", label)
+		}
+	}
+	got, _ := os.ReadFile(calls)
+	if !strings.Contains(string(got), "plan -json -input=false -no-color -out=plan.tfplan\n") ||
+		!strings.Contains(string(got), "apply -no-color -input=false plan.tfplan\n") {
+		t.Errorf("terraform calls = %s", got)
+	}
+}
+
+func TestProvisionPlanFailure(t *testing.T) {
+	calls := planTerraform(t, true)
+	m := NewManager()
+	m.workspaceDir = t.TempDir()
+
+	_, err := m.Provision(&ProvisionRequest{Provider: "aws", Arch: "amd64"})
+	if err == nil || !strings.Contains(err.Error(), "quota exceeded") {
+		t.Fatalf("Provision() = %v, want the plan's error diagnostic", err)
+	}
+	if len(m.ListInstances()) != 0 {
+		t.Error("a failed plan leaves a tracked instance")
+	}
+	got, _ := os.ReadFile(calls)
+	if strings.Contains(string(got), "apply") {
+		t.Errorf("applied after a failed plan: %s", got)
+	}
+}
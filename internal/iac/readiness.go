@@ -0,0 +1,73 @@
+package iac
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Builder readiness defaults. The builder service is (re)started at the very
+// end of the deploy script and binds its port a moment later, so a freshly
+// provisioned instance is only handed out once its /health answers.
+const (
+	defaultBuilderReadyTimeout  = 2 * time.Minute
+	defaultBuilderReadyInterval = 5 * time.Second
+	builderProbeTimeout         = 5 * time.Second
+)
+
+// WithBuilderReadiness configures the readiness gate Provision applies after
+// deploying a builder: wait delay before the first /health probe, then retry
+// until timeout. A zero timeout keeps the default.
+func WithBuilderReadiness(delay, timeout time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.readyDelay = delay
+		if timeout > 0 {
+			m.readyTimeout = timeout
+		}
+	}
+}
+
+// waitForBuilderReady polls the instance builder's /health until it answers
+// 200 or the readiness timeout elapses.
+func (m *Manager) waitForBuilderReady(instance *Instance, sink func(string)) error {
+	return m.awaitBuilder(instance, m.readyTimeout, sink)
+}
+
+// awaitBuilder is waitForBuilderReady with the given timeout.
+func (m *Manager) awaitBuilder(instance *Instance, timeout time.Duration, sink func(string)) error {
+	if instance.BuilderEndpoint == "" {
+		return fmt.Errorf("instance %s has no builder endpoint", instance.ID)
+	}
+	url := strings.TrimRight(instance.BuilderEndpoint, "/") + "/health"
+	client := &http.Client{Timeout: builderProbeTimeout}
+
+	if m.readyDelay > 0 {
+		sinkf(sink, "[deploy] waiting %s for the builder service to start…", m.readyDelay)
+		time.Sleep(m.readyDelay)
+	}
+	deadline := time.Now().Add(timeout)
+	lastErr := ""
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Get(url)
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				if attempt > 0 {
+					sinkf(sink, "[deploy] builder is up")
+				}
+				return nil
+			}
+			lastErr = fmt.Sprintf("health returned %d", resp.StatusCode)
+		} else {
+			lastErr = err.Error()
+		}
+		if attempt == 0 {
+			sinkf(sink, "[deploy] waiting for the builder service to come up…")
+		}
+		if time.Now().Add(m.readyInterval).After(deadline) {
+			return fmt.Errorf("builder at %s not ready after %s: %s", instance.BuilderEndpoint, timeout, lastErr)
+		}
+		time.Sleep(m.readyInterval)
+	}
+}
@@ -0,0 +1,73 @@
+package iac
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitForBuilderReady(t *testing.T) {
+	// The builder answers 503 twice while starting, then 200.
+	var probes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			t.Errorf("probe path = %s, want /health", r.URL.Path)
+		}
+		if probes.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	m := NewManager(WithBuilderReadiness(10*time.Millisecond, time.Second))
+	m.readyInterval = 10 * time.Millisecond
+
+	var lines []string
+	inst := &Instance{ID: "pve-1", BuilderEndpoint: srv.URL}
+	if err := m.waitForBuilderReady(inst, func(l string) { lines = append(lines, l) }); err != nil {
+		t.Fatalf("waitForBuilderReady() error = %v", err)
+	}
+	if got := probes.Load(); got != 3 {
+		t.Errorf("probes = %d, want 3", got)
+	}
+	if len(lines) == 0 || lines[len(lines)-1] != "[deploy] builder is up" {
+		t.Errorf("log lines = %q, want a final builder-is-up line", lines)
+	}
+}
+
+func TestWaitForBuilderReadyTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	m := NewManager(WithBuilderReadiness(0, 50*time.Millisecond))
+	m.readyInterval = 10 * time.Millisecond
+
+	err := m.waitForBuilderReady(&Instance{ID: "pve-1", BuilderEndpoint: srv.URL}, nil)
+	if err == nil {
+		t.Fatal("waitForBuilderReady() should fail when the builder never becomes healthy")
+	}
+	if !strings.Contains(err.Error(), "health returned 503") {
+		t.Errorf("error = %v, want the last probe result", err)
+	}
+
+	if err := m.waitForBuilderReady(&Instance{ID: "pve-2"}, nil); err == nil {
+		t.Error("waitForBuilderReady() should fail without a builder endpoint")
+	}
+}
+
+func TestWithBuilderReadinessKeepsDefaultTimeout(t *testing.T) {
+	m := NewManager(WithBuilderReadiness(3*time.Second, 0))
+	if m.readyDelay != 3*time.Second {
+		t.Errorf("readyDelay = %s, want 3s", m.readyDelay)
+	}
+	if m.readyTimeout != defaultBuilderReadyTimeout {
+		t.Errorf("readyTimeout = %s, want default %s", m.readyTimeout, defaultBuilderReadyTimeout)
+	}
+}
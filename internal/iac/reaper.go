@@ -0,0 +1,150 @@
+// Package iac provides the reaper that auto-terminates idle, silent and
+// over-age cloud instances.
+package iac
+
+import (
+	"fmt"
+	"time"
+)
+
+// Reasons an instance is auto-terminated, as counted by ReapedCounts.
+const (
+	// ReapIdle: no build ran on the instance for its TTL.
+	ReapIdle = "idle"
+	// ReapStaleHeartbeat: the instance's builder stopped heartbeating for
+	// longer than the idle timeout.
+	ReapStaleHeartbeat = "stale_heartbeat"
+	// ReapMaxLifetime: the instance outlived its maximum lifetime.
+	ReapMaxLifetime = "max_lifetime"
+)
+
+// ActiveJobsProbe asks the builder on an instance how many jobs it has
+// queued or building. The reaper only terminates an instance once its probe
+// reports none; an error means the builder cannot be reached, so there is no
+// build left to protect.
+type ActiveJobsProbe func(inst *Instance) (int, error)
+
+// WithIdleTimeout terminates running instances whose builder has not
+// heartbeated for d. 0 disables it.
+func WithIdleTimeout(d time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.idleTimeout = d
+	}
+}
+
+// WithMaxLifetime sets the default maximum lifetime of an instance since it
+// was provisioned, regardless of activity. 0 means unlimited.
+func WithMaxLifetime(d time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.maxLifetime = d
+	}
+}
+
+// WithActiveJobsProbe sets the probe the reaper checks before terminating an
+// instance (see ActiveJobsProbe). Without one only the server-side
+// ActiveTasks count protects running builds.
+func WithActiveJobsProbe(probe ActiveJobsProbe) ManagerOption {
+	return func(m *Manager) {
+		m.activeJobs = probe
+	}
+}
+
+// reapCandidate is an instance due for auto-termination.
+type reapCandidate struct {
+	id     string
+	reason string // one of the Reap* constants
+	detail string // human-readable explanation for the log
+}
+
+// reapCandidates returns the instances due for auto-termination. An instance
+// past its maximum lifetime is reported as such even when it is also idle or
+// silent. Instances with server-side builds are never due, and neither are
+// instances still provisioning, except through their idle TTL. External
+// instances are never due: the server did not create them.
+func (m *Manager) reapCandidates(now time.Time) []reapCandidate {
+	stale := make(map[string]bool)
+	if m.idleTimeout > 0 {
+		for _, inst := range m.CheckStaleInstances(m.idleTimeout) {
+			stale[inst.ID] = true
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var due []reapCandidate
+	for id, inst := range m.instances {
+		if inst.ActiveTasks > 0 || inst.Status == "destroy_failed" || inst.Status == "terminating" ||
+			inst.Provider == ExternalProvider {
+			continue
+		}
+		provisioning := inst.Status == "provisioning"
+		switch {
+		case !provisioning && inst.MaxLifetime > 0 && now.Sub(inst.CreatedAt) > inst.MaxLifetime:
+			due = append(due, reapCandidate{id, ReapMaxLifetime, fmt.Sprintf("up for %s (max lifetime %s)",
+				now.Sub(inst.CreatedAt).Round(time.Second), inst.MaxLifetime)})
+		case !provisioning && stale[id]:
+			due = append(due, reapCandidate{id, ReapStaleHeartbeat, fmt.Sprintf("no heartbeat for %s (idle timeout %s)",
+				now.Sub(inst.LastHeartbeat).Round(time.Second), m.idleTimeout)})
+		case inst.TTL > 0 && now.Sub(inst.LastActivity) > inst.TTL:
+			due = append(due, reapCandidate{id, ReapIdle, fmt.Sprintf("idle for %s (TTL %s)",
+				now.Sub(inst.LastActivity).Round(time.Second), inst.TTL)})
+		}
+	}
+	return due
+}
+
+// reapInstances terminates the instances reapCandidates reports, once their
+// builders confirm they have no active jobs, and counts each termination by
+// reason.
+func (m *Manager) reapInstances() {
+	for _, c := range m.reapCandidates(time.Now()) {
+		inst, err := m.GetInstance(c.id)
+		if err != nil {
+			continue
+		}
+		if m.activeJobs != nil {
+			if n, err := m.activeJobs(inst); err == nil && n > 0 {
+				fmt.Printf("Not auto-terminating instance %s (%s): builder reports %d active job(s)\n", c.id, c.detail, n)
+				continue
+			}
+		}
+		if !m.claimForReap(c.id) {
+			continue // a build acquired it in the meantime
+		}
+
+		fmt.Printf("Auto-terminating instance %s: %s\n", c.id, c.detail)
+		if err := m.Terminate(c.id); err != nil {
+			fmt.Printf("Failed to auto-terminate instance %s: %v\n", c.id, err)
+			continue
+		}
+		m.mu.Lock()
+		m.reaped[c.reason]++
+		m.mu.Unlock()
+	}
+}
+
+// claimForReap marks an idle instance as terminating so AcquireIdleInstance
+// cannot hand it to a build while it is being destroyed. It reports false
+// when the instance is gone or busy again.
+func (m *Manager) claimForReap(instanceID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inst, ok := m.instances[instanceID]
+	if !ok || inst.ActiveTasks > 0 {
+		return false
+	}
+	inst.Status = "terminating"
+	return true
+}
+
+// ReapedCounts returns how many instances were auto-terminated since the
+// manager started, by reason (see the Reap* constants).
+func (m *Manager) ReapedCounts() map[string]int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	counts := make(map[string]int, len(m.reaped))
+	for reason, n := range m.reaped {
+		counts[reason] = n
+	}
+	return counts
+}
@@ -0,0 +1,122 @@
+package iac
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeTerraform puts a terraform on PATH whose every command succeeds, so
+// Terminate can complete without a cloud.
+func fakeTerraform(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "terraform"), []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestReapCandidates(t *testing.T) {
+	m := NewManager(WithIdleTimeout(10 * time.Minute))
+	now := time.Now()
+	add := func(id string, inst Instance) {
+		inst.ID = id
+		if inst.Status == "" {
+			inst.Status = "running"
+		}
+		if inst.LastHeartbeat.IsZero() {
+			inst.LastHeartbeat = now
+		}
+		if inst.LastActivity.IsZero() {
+			inst.LastActivity = now
+		}
+		if inst.CreatedAt.IsZero() {
+			inst.CreatedAt = now
+		}
+		m.instances[id] = &inst
+	}
+	add("fresh", Instance{TTL: time.Hour, MaxLifetime: time.Hour})
+	add("idle", Instance{TTL: time.Hour, LastActivity: now.Add(-2 * time.Hour)})
+	add("silent", Instance{LastHeartbeat: now.Add(-time.Hour)})
+	add("old", Instance{MaxLifetime: time.Hour, CreatedAt: now.Add(-2 * time.Hour), LastHeartbeat: now.Add(-time.Hour)})
+	add("busy", Instance{ActiveTasks: 1, MaxLifetime: time.Hour, CreatedAt: now.Add(-2 * time.Hour), LastHeartbeat: now.Add(-time.Hour)})
+	add("provisioning", Instance{Status: "provisioning", MaxLifetime: time.Hour, CreatedAt: now.Add(-2 * time.Hour), LastHeartbeat: now.Add(-time.Hour)})
+	add("destroy-failed", Instance{Status: "destroy_failed", TTL: time.Hour, LastActivity: now.Add(-2 * time.Hour)})
+
+	got := map[string]string{}
+	for _, c := range m.reapCandidates(now) {
+		got[c.id] = c.reason
+	}
+	want := map[string]string{"idle": ReapIdle, "silent": ReapStaleHeartbeat, "old": ReapMaxLifetime}
+	if len(got) != len(want) {
+		t.Errorf("candidates = %v, want %v", got, want)
+	}
+	for id, reason := range want {
+		if got[id] != reason {
+			t.Errorf("%s: reason %q, want %q", id, got[id], reason)
+		}
+	}
+}
+
+func TestReapInstances(t *testing.T) {
+	fakeTerraform(t)
+	activeJobs := map[string]int{"building": 2}
+	m := NewManager(
+		WithIdleTimeout(10*time.Minute),
+		WithActiveJobsProbe(func(inst *Instance) (int, error) {
+			if inst.ID == "unreachable" {
+				return 0, errors.New("connection refused")
+			}
+			return activeJobs[inst.ID], nil
+		}),
+	)
+	stale := time.Now().Add(-time.Hour)
+	for _, id := range []string{"building", "idle", "unreachable"} {
+		m.instances[id] = &Instance{
+			ID:            id,
+			Status:        "running",
+			TerraformDir:  t.TempDir(),
+			LastHeartbeat: stale,
+			LastActivity:  time.Now(),
+			CreatedAt:     stale,
+		}
+	}
+
+	m.cleanupExpiredInstances()
+
+	if _, ok := m.instances["building"]; !ok {
+		t.Error("terminated an instance whose builder reports active jobs")
+	}
+	if m.instances["building"].Status != "running" {
+		t.Errorf("busy instance status = %q, want running", m.instances["building"].Status)
+	}
+	for _, id := range []string{"idle", "unreachable"} {
+		if _, ok := m.instances[id]; ok {
+			t.Errorf("silent instance %s was not terminated", id)
+		}
+	}
+	if got := m.ReapedCounts(); got[ReapStaleHeartbeat] != 2 || len(got) != 1 {
+		t.Errorf("ReapedCounts() = %v, want 2 stale_heartbeat", got)
+	}
+}
+
+func TestReapInstancesSkipsAcquired(t *testing.T) {
+	m := NewManager()
+	m.instances["warm"] = &Instance{ID: "warm", Status: "running", ActiveTasks: 1}
+	if m.claimForReap("warm") {
+		t.Error("claimed an instance a build acquired")
+	}
+	m.instances["warm"].ActiveTasks = 0
+	if !m.claimForReap("warm") {
+		t.Fatal("did not claim an idle instance")
+	}
+	if inst := m.AcquireIdleInstance("", ""); inst != nil {
+		t.Error("AcquireIdleInstance handed out an instance being terminated")
+	}
+	if err := m.UpdateHeartbeat("warm"); err != nil || m.instances["warm"].Status != "terminating" {
+		t.Errorf("heartbeat revived a terminating instance: status %q, err %v", m.instances["warm"].Status, err)
+	}
+}
@@ -0,0 +1,304 @@
+// Package notification provides Slack and Discord build messages.
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultLogTailLines is how many trailing log lines a chat message
+	// shows when the config does not say.
+	defaultLogTailLines = 15
+	// Size limits of the log tail: Slack cuts attachment text off at a few
+	// thousand characters, Discord rejects embed descriptions over 4096.
+	slackMaxLogChars   = 3000
+	discordMaxLogChars = 3800
+	// discordMaxFieldChars is Discord's limit for an embed field value.
+	discordMaxFieldChars = 1024
+)
+
+// chatField is one name/value pair shown in a Slack attachment or Discord
+// embed.
+type chatField struct {
+	name  string
+	value string
+	short bool
+}
+
+// chatMessage is the content shared by the Slack and Discord renderings of a
+// build notification.
+type chatMessage struct {
+	title     string
+	buildURL  string // dashboard build page, "" without a dashboard URL
+	logURL    string // dashboard log page, "" without a dashboard URL
+	fields    []chatField
+	logTail   string
+	logLines  int
+	truncated bool // logTail is not the whole log
+}
+
+// chatMessage assembles the chat content for a notification, with a log
+// tail of at most maxLogChars characters.
+func (n *Notifier) chatMessage(notification *BuildNotification, maxLogChars int) chatMessage {
+	pkg := notification.PackageName
+	if notification.Version != "" {
+		pkg += "-" + notification.Version
+	}
+	msg := chatMessage{
+		title: fmt.Sprintf("Build %s: %s", strings.ToUpper(notification.Status), pkg),
+		fields: []chatField{
+			{name: "Package", value: notification.PackageName, short: true},
+			{name: "Version", value: valueOr(notification.Version, "latest"), short: true},
+			{name: "Duration", value: valueOr(notification.Duration, "-"), short: true},
+			{name: "Job ID", value: notification.JobID, short: true},
+		},
+	}
+	if notification.RequestID != "" {
+		msg.fields = append(msg.fields, chatField{name: "Request ID", value: notification.RequestID, short: true})
+	}
+	if notification.ArtifactURL != "" {
+		msg.fields = append(msg.fields, chatField{name: "Artifact", value: notification.ArtifactURL})
+	}
+	if notification.Error != "" {
+		msg.fields = append(msg.fields, chatField{name: "Error", value: notification.Error})
+	}
+	if dashboard := strings.TrimRight(n.config.DashboardURL, "/"); dashboard != "" && notification.JobID != "" {
+		id := url.PathEscape(notification.JobID)
+		msg.buildURL = dashboard + "/build/" + id
+		msg.logURL = dashboard + "/logs/" + id
+	}
+	msg.logTail, msg.logLines, msg.truncated = logTail(notification.BuildLog, n.logTailLines(), maxLogChars)
+	return msg
+}
+
+// logTailLines is the number of log lines chat messages include.
+func (n *Notifier) logTailLines() int {
+	if n.config.LogTailLines == 0 {
+		return defaultLogTailLines
+	}
+	return n.config.LogTailLines
+}
+
+// logTail returns the last lines of buildLog, cut further to fit maxChars,
+// with the number of lines kept and whether anything was left out. Code
+// fences in the log are broken up so they cannot end the message's own.
+func logTail(buildLog string, lines, maxChars int) (string, int, bool) {
+	buildLog = strings.TrimRight(buildLog, "\n")
+	if buildLog == "" || lines <= 0 {
+		return "", 0, false
+	}
+	all := strings.Split(buildLog, "\n")
+	truncated := len(all) > lines
+	if truncated {
+		all = all[len(all)-lines:]
+	}
+	for len(all) > 1 && len(strings.Join(all, "\n")) > maxChars {
+		all = all[1:]
+		truncated = true
+	}
+	tail := strings.Join(all, "\n")
+	if len(tail) > maxChars {
+		tail = strings.ToValidUTF8(tail[len(tail)-maxChars:], "")
+		truncated = true
+	}
+	return strings.ReplaceAll(tail, "```", "``\u200b`"), len(all), truncated
+}
+
+// valueOr returns v, or fallback when v is empty.
+func valueOr(v, fallback string) string {
+	if v == "" {
+		return fallback
+	}
+	return v
+}
+
+// statusColors returns the Slack and Discord colors for a build status:
+// green for success, red for failure, grey for anything else (cancelled,
+// expired).
+func statusColors(status string) (string, int) {
+	switch {
+	case strings.HasPrefix(status, "success"):
+		return "#2eb886", 0x2eb886
+	case status == "failed":
+		return "#e01e5a", 0xe01e5a
+	}
+	return "#9e9e9e", 0x9e9e9e
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type slackAttachment struct {
+	Color     string       `json:"color"`
+	Title     string       `json:"title"`
+	TitleLink string       `json:"title_link,omitempty"`
+	Text      string       `json:"text,omitempty"`
+	Fields    []slackField `json:"fields"`
+	MrkdwnIn  []string     `json:"mrkdwn_in,omitempty"`
+	Footer    string       `json:"footer"`
+	Ts        int64        `json:"ts"`
+}
+
+type slackPayload struct {
+	Username    string            `json:"username,omitempty"`
+	IconEmoji   string            `json:"icon_emoji,omitempty"`
+	Channel     string            `json:"channel,omitempty"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+// slackEscape escapes the characters Slack treats as markup.
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackMessage renders a notification as a Slack message: one attachment
+// colored by status, linking to the dashboard build page, with the log tail.
+func (n *Notifier) slackMessage(notification *BuildNotification) slackPayload {
+	cfg := n.config.Slack
+	msg := n.chatMessage(notification, slackMaxLogChars)
+	color, _ := statusColors(notification.Status)
+
+	att := slackAttachment{
+		Color:     color,
+		Title:     msg.title,
+		TitleLink: msg.buildURL,
+		Footer:    "Portage Engine",
+		Ts:        notification.EndTime.Unix(),
+	}
+	for _, f := range msg.fields {
+		att.Fields = append(att.Fields, slackField{Title: f.name, Value: f.value, Short: f.short})
+	}
+	if msg.logTail != "" {
+		att.Text = fmt.Sprintf("*Last %d log lines:*\n```%s```", msg.logLines, slackEscape.Replace(msg.logTail))
+		if msg.truncated && msg.logURL != "" {
+			att.Text += fmt.Sprintf("\n<%s|View full log>", msg.logURL)
+		}
+		att.MrkdwnIn = []string{"text"}
+	} else if msg.logURL != "" {
+		att.Text = fmt.Sprintf("<%s|View full log>", msg.logURL)
+	}
+	return slackPayload{
+		Username:    cfg.Username,
+		IconEmoji:   cfg.IconEmoji,
+		Channel:     cfg.Channel,
+		Attachments: []slackAttachment{att},
+	}
+}
+
+// sendSlack sends Slack notification.
+func (n *Notifier) sendSlack(notification *BuildNotification) error {
+	jsonPayload, err := json.Marshal(n.slackMessage(notification))
+	if err != nil {
+		return fmt.Errorf("failed to marshal slack payload: %w", err)
+	}
+
+	resp, err := n.client.Post(n.config.Slack.WebhookURL, "application/json", bytes.NewReader(jsonPayload))
+	if err != nil {
+		return fmt.Errorf("failed to send slack notification: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("slack returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	log.Printf("Slack notification sent")
+	return nil
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	URL         string         `json:"url,omitempty"`
+	Color       int            `json:"color"`
+	Description string         `json:"description,omitempty"`
+	Fields      []discordField `json:"fields"`
+	Footer      struct {
+		Text string `json:"text"`
+	} `json:"footer"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+type discordPayload struct {
+	Username  string         `json:"username,omitempty"`
+	AvatarURL string         `json:"avatar_url,omitempty"`
+	Embeds    []discordEmbed `json:"embeds"`
+}
+
+// discordMessage renders a notification as a Discord message: one embed
+// colored by status, linking to the dashboard build page, with the log tail.
+func (n *Notifier) discordMessage(notification *BuildNotification) discordPayload {
+	cfg := n.config.Discord
+	msg := n.chatMessage(notification, discordMaxLogChars)
+	_, color := statusColors(notification.Status)
+
+	embed := discordEmbed{
+		Title: msg.title,
+		URL:   msg.buildURL,
+		Color: color,
+	}
+	embed.Footer.Text = "Portage Engine"
+	if !notification.EndTime.IsZero() {
+		embed.Timestamp = notification.EndTime.UTC().Format(time.RFC3339)
+	}
+	for _, f := range msg.fields {
+		value := f.value
+		if len(value) > discordMaxFieldChars {
+			value = strings.ToValidUTF8(value[:discordMaxFieldChars-3], "") + "..."
+		}
+		embed.Fields = append(embed.Fields, discordField{Name: f.name, Value: value, Inline: f.short})
+	}
+	if msg.logTail != "" {
+		embed.Description = fmt.Sprintf("**Last %d log lines:**\n```\n%s\n```", msg.logLines, msg.logTail)
+		if msg.truncated && msg.logURL != "" {
+			embed.Description += fmt.Sprintf("\n[View full log](%s)", msg.logURL)
+		}
+	} else if msg.logURL != "" {
+		embed.Description = fmt.Sprintf("[View full log](%s)", msg.logURL)
+	}
+	return discordPayload{
+		Username:  cfg.Username,
+		AvatarURL: cfg.AvatarURL,
+		Embeds:    []discordEmbed{embed},
+	}
+}
+
+// sendDiscord sends Discord notification.
+func (n *Notifier) sendDiscord(notification *BuildNotification) error {
+	jsonPayload, err := json.Marshal(n.discordMessage(notification))
+	if err != nil {
+		return fmt.Errorf("failed to marshal discord payload: %w", err)
+	}
+
+	resp, err := n.client.Post(n.config.Discord.WebhookURL, "application/json", bytes.NewReader(jsonPayload))
+	if err != nil {
+		// The webhook URL embeds its token; report the transport error
+		// without it.
+		return fmt.Errorf("failed to send discord notification: %w", redactToken(err, n.config.Discord.WebhookURL))
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// Discord answers 204 No Content, or 200 when asked to wait.
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("discord returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	log.Printf("Discord notification sent")
+	return nil
+}
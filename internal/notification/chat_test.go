@@ -0,0 +1,158 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLog(lines int) string {
+	var b strings.Builder
+	for i := 1; i <= lines; i++ {
+		fmt.Fprintf(&b, ">>> line %d\n", i)
+	}
+	return b.String()
+}
+
+// TestSlackMessage tests the Slack attachment contents.
+func TestSlackMessage(t *testing.T) {
+	notifier := NewNotifier(&Config{
+		Slack:        &SlackConfig{Enabled: true},
+		DashboardURL: "http://dash.example.com/",
+		LogTailLines: 5,
+	})
+	msg := notifier.slackMessage(&BuildNotification{
+		JobID:       "job-1",
+		PackageName: "dev-lang/go",
+		Version:     "1.21.0",
+		Status:      "failed",
+		Duration:    "3m0s",
+		Error:       "compile failed",
+		BuildLog:    testLog(20) + "error: <a> & <b>\n",
+		EndTime:     time.Now(),
+	})
+
+	att := msg.Attachments[0]
+	if att.Color != "#e01e5a" {
+		t.Errorf("color = %q, want red", att.Color)
+	}
+	if att.Title != "Build FAILED: dev-lang/go-1.21.0" || att.TitleLink != "http://dash.example.com/build/job-1" {
+		t.Errorf("title = %q, link = %q", att.Title, att.TitleLink)
+	}
+	fields := map[string]string{}
+	for _, f := range att.Fields {
+		fields[f.Title] = f.Value
+	}
+	if fields["Package"] != "dev-lang/go" || fields["Version"] != "1.21.0" || fields["Duration"] != "3m0s" || fields["Error"] != "compile failed" {
+		t.Errorf("fields = %v", fields)
+	}
+	if strings.Contains(att.Text, "line 16\n") || !strings.Contains(att.Text, "line 17") {
+		t.Errorf("text does not hold the last 5 lines: %q", att.Text)
+	}
+	if !strings.Contains(att.Text, "error: &lt;a&gt; &amp; &lt;b&gt;") {
+		t.Errorf("log not escaped: %q", att.Text)
+	}
+	if !strings.Contains(att.Text, "<http://dash.example.com/logs/job-1|View full log>") {
+		t.Errorf("text has no full log link: %q", att.Text)
+	}
+}
+
+// TestSendDiscord tests Discord notification.
+func TestSendDiscord(t *testing.T) {
+	var received discordPayload
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(&Config{
+		Discord: &DiscordConfig{
+			Enabled:    true,
+			WebhookURL: server.URL,
+			Username:   "Portage Bot",
+		},
+		DashboardURL: "http://dash.example.com",
+	})
+	err := notifier.sendDiscord(&BuildNotification{
+		JobID:       "job-2",
+		PackageName: "dev-lang/go",
+		Version:     "1.21.0",
+		Status:      "success",
+		Duration:    "3m0s",
+		BuildLog:    testLog(3),
+		EndTime:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("sendDiscord failed: %v", err)
+	}
+
+	if received.Username != "Portage Bot" || len(received.Embeds) != 1 {
+		t.Fatalf("payload = %+v", received)
+	}
+	embed := received.Embeds[0]
+	if embed.Color != 0x2eb886 || embed.URL != "http://dash.example.com/build/job-2" {
+		t.Errorf("color = %#x, url = %q", embed.Color, embed.URL)
+	}
+	if embed.Timestamp != "2024-01-02T03:04:05Z" {
+		t.Errorf("timestamp = %q", embed.Timestamp)
+	}
+	if !strings.Contains(embed.Description, ">>> line 1\n>>> line 2\n>>> line 3") {
+		t.Errorf("description = %q, want the whole short log", embed.Description)
+	}
+	if strings.Contains(embed.Description, "View full log") {
+		t.Errorf("description links the full log of an untruncated log: %q", embed.Description)
+	}
+	if len(embed.Fields) != 4 {
+		t.Errorf("fields = %+v, want package, version, duration and job ID", embed.Fields)
+	}
+}
+
+// TestSendDiscordError tests Discord error responses.
+func TestSendDiscordError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"message":"Invalid Form Body"}`))
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(&Config{Discord: &DiscordConfig{Enabled: true, WebhookURL: server.URL}})
+	err := notifier.sendDiscord(&BuildNotification{JobID: "job-3", Status: "failed"})
+	if err == nil || !strings.Contains(err.Error(), "discord returned status 400") {
+		t.Errorf("sendDiscord = %v, want the 400", err)
+	}
+}
+
+// TestLogTail tests cutting build logs for chat messages.
+func TestLogTail(t *testing.T) {
+	tests := []struct {
+		name          string
+		log           string
+		lines, chars  int
+		want          string
+		wantTruncated bool
+	}{
+		{"empty", "", 5, 100, "", false},
+		{"short", "a\nb\n", 5, 100, "a\nb", false},
+		{"lines", "a\nb\nc\nd\n", 2, 100, "c\nd", true},
+		{"disabled", "a\nb\n", -1, 100, "", false},
+		{"chars", "aaaa\nbbbb\ncccc", 5, 8, "cccc", true},
+		{"long line", "0123456789", 5, 4, "6789", true},
+		{"fence", "```", 5, 100, "``\u200b`", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, truncated := logTail(tt.log, tt.lines, tt.chars)
+			if got != tt.want || truncated != tt.wantTruncated {
+				t.Errorf("logTail = %q, %v; want %q, %v", got, truncated, tt.want, tt.wantTruncated)
+			}
+		})
+	}
+}
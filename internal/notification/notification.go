@@ -3,6 +3,9 @@ package notification
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -26,6 +29,9 @@ type BuildNotification struct {
 	BuildLog    string    `json:"build_log,omitempty"`
 	Error       string    `json:"error,omitempty"`
 	ArtifactURL string    `json:"artifact_url,omitempty"`
+	// RequestID correlates the build with the request that submitted it
+	// and with the log lines of every service it went through.
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // Config represents notification configuration.
@@ -34,7 +40,14 @@ type Config struct {
 	Webhook  *WebhookConfig  `json:"webhook,omitempty"`
 	IRC      *IRCConfig      `json:"irc,omitempty"`
 	Slack    *SlackConfig    `json:"slack,omitempty"`
+	Discord  *DiscordConfig  `json:"discord,omitempty"`
 	Telegram *TelegramConfig `json:"telegram,omitempty"`
+	// DashboardURL is the dashboard's base URL; Slack and Discord messages
+	// link to the build's page and full log there.
+	DashboardURL string `json:"dashboard_url,omitempty"`
+	// LogTailLines is how many trailing build log lines Slack and Discord
+	// messages include (0 = default 15, negative = none).
+	LogTailLines int `json:"log_tail_lines,omitempty"`
 }
 
 // EmailConfig represents email notification configuration.
@@ -49,15 +62,35 @@ type EmailConfig struct {
 	Subject  string   `json:"subject"`
 }
 
-// WebhookConfig represents webhook notification configuration.
+// WebhookConfig represents webhook notification configuration. With a
+// Secret, each request carries an X-Signature: sha256=<hex> header, the
+// HMAC-SHA256 of the body keyed with it, so the receiver can verify the
+// notification came from us.
 type WebhookConfig struct {
 	Enabled bool              `json:"enabled"`
 	URL     string            `json:"url"`
 	Method  string            `json:"method"` // POST, PUT
+	Secret  string            `json:"secret,omitempty"`
 	Headers map[string]string `json:"headers,omitempty"`
 	Timeout int               `json:"timeout"` // seconds
+	// MaxRetries is how often a request failing with a 5xx status or a
+	// transport error is retried (0 = default 3, negative = never). A 4xx
+	// status is not retried.
+	MaxRetries int `json:"max_retries,omitempty"`
+	// RetryBackoff is the delay before the first retry in seconds (default
+	// 1), doubled for each later one.
+	RetryBackoff int `json:"retry_backoff,omitempty"`
 }
 
+// Webhook retry defaults, used when the config leaves them unset.
+const (
+	defaultWebhookRetries = 3
+	defaultWebhookBackoff = time.Second
+)
+
+// webhookSignatureHeader carries the HMAC-SHA256 signature of a webhook body.
+const webhookSignatureHeader = "X-Signature"
+
 // IRCConfig represents IRC notification configuration.
 type IRCConfig struct {
 	Enabled  bool     `json:"enabled"`
@@ -77,6 +110,14 @@ type SlackConfig struct {
 	IconEmoji  string `json:"icon_emoji,omitempty"`
 }
 
+// DiscordConfig represents Discord notification configuration.
+type DiscordConfig struct {
+	Enabled    bool   `json:"enabled"`
+	WebhookURL string `json:"webhook_url"`
+	Username   string `json:"username,omitempty"`
+	AvatarURL  string `json:"avatar_url,omitempty"`
+}
+
 // TelegramConfig represents Telegram notification configuration.
 type TelegramConfig struct {
 	Enabled  bool   `json:"enabled"`
@@ -88,6 +129,7 @@ type TelegramConfig struct {
 type Notifier struct {
 	config *Config
 	client *http.Client
+	sleep  func(time.Duration) // waits between webhook retries
 }
 
 // NewNotifier creates a new notification handler.
@@ -97,6 +139,7 @@ func NewNotifier(config *Config) *Notifier {
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		sleep: time.Sleep,
 	}
 }
 
@@ -133,6 +176,10 @@ func (n *Notifier) Notify(notification *BuildNotification) error {
 		errors = append(errors, fmt.Sprintf("slack: %v", err))
 	}
 
+	if err := n.notifyDiscord(notification); err != nil {
+		errors = append(errors, fmt.Sprintf("discord: %v", err))
+	}
+
 	if err := n.notifyTelegram(notification); err != nil {
 		errors = append(errors, fmt.Sprintf("telegram: %v", err))
 	}
@@ -175,6 +222,14 @@ func (n *Notifier) notifySlack(notification *BuildNotification) error {
 	return n.sendSlack(notification)
 }
 
+// notifyDiscord sends Discord notification if enabled.
+func (n *Notifier) notifyDiscord(notification *BuildNotification) error {
+	if n.config.Discord == nil || !n.config.Discord.Enabled {
+		return nil
+	}
+	return n.sendDiscord(notification)
+}
+
 // notifyTelegram sends Telegram notification if enabled.
 func (n *Notifier) notifyTelegram(notification *BuildNotification) error {
 	if n.config.Telegram == nil || !n.config.Telegram.Enabled {
@@ -239,6 +294,9 @@ func (n *Notifier) formatEmailBody(notification *BuildNotification) string {
 
 	fmt.Fprintf(&buf, "Build Status: %s\n", strings.ToUpper(notification.Status))
 	fmt.Fprintf(&buf, "Job ID: %s\n", notification.JobID)
+	if notification.RequestID != "" {
+		fmt.Fprintf(&buf, "Request ID: %s\n", notification.RequestID)
+	}
 	fmt.Fprintf(&buf, "Package: %s-%s\n", notification.PackageName, notification.Version)
 	fmt.Fprintf(&buf, "Duration: %s\n", notification.Duration)
 	fmt.Fprintf(&buf, "Started: %s\n", notification.StartTime.Format(time.RFC3339))
@@ -259,7 +317,8 @@ func (n *Notifier) formatEmailBody(notification *BuildNotification) string {
 	return buf.String()
 }
 
-// sendWebhook sends webhook notification.
+// sendWebhook sends webhook notification, retrying with exponential backoff
+// while the receiver answers 5xx or cannot be reached.
 func (n *Notifier) sendWebhook(notification *BuildNotification) error {
 	cfg := n.config.Webhook
 
@@ -268,6 +327,36 @@ func (n *Notifier) sendWebhook(notification *BuildNotification) error {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
+	retries := cfg.MaxRetries
+	if retries == 0 {
+		retries = defaultWebhookRetries
+	}
+	backoff := time.Duration(cfg.RetryBackoff) * time.Second
+	if backoff <= 0 {
+		backoff = defaultWebhookBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		retryable, err := n.postWebhook(cfg, payload)
+		if err == nil {
+			log.Printf("Webhook notification sent to %s", cfg.URL)
+			return nil
+		}
+		if !retryable || attempt >= retries {
+			if attempt > 0 {
+				return fmt.Errorf("%w (after %d attempts)", err, attempt+1)
+			}
+			return err
+		}
+		delay := backoff << attempt
+		log.Printf("Webhook notification to %s failed (%v); retrying in %s", cfg.URL, err, delay)
+		n.sleep(delay)
+	}
+}
+
+// postWebhook makes one webhook request with the signed payload. It reports
+// whether a failure is worth retrying: a transport error or a 5xx status.
+func (n *Notifier) postWebhook(cfg *WebhookConfig, payload []byte) (bool, error) {
 	method := cfg.Method
 	if method == "" {
 		method = "POST"
@@ -275,13 +364,16 @@ func (n *Notifier) sendWebhook(notification *BuildNotification) error {
 
 	req, err := http.NewRequest(method, cfg.URL, bytes.NewReader(payload))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return false, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	for key, value := range cfg.Headers {
 		req.Header.Set(key, value)
 	}
+	if cfg.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, signWebhookPayload(cfg.Secret, payload))
+	}
 
 	timeout := time.Duration(cfg.Timeout) * time.Second
 	if timeout == 0 {
@@ -291,17 +383,23 @@ func (n *Notifier) sendWebhook(notification *BuildNotification) error {
 	client := &http.Client{Timeout: timeout}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send webhook: %w", err)
+		return true, fmt.Errorf("failed to send webhook: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, string(body))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode >= 500, fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, string(body))
 	}
+	return false, nil
+}
 
-	log.Printf("Webhook notification sent to %s", cfg.URL)
-	return nil
+// signWebhookPayload returns the X-Signature value for payload: "sha256="
+// followed by the hex HMAC-SHA256 of the payload keyed with secret.
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
 }
 
 // sendIRC sends IRC notification.
@@ -324,77 +422,6 @@ func (n *Notifier) sendIRC(notification *BuildNotification) {
 	// Actual IRC implementation would go here
 }
 
-// sendSlack sends Slack notification.
-func (n *Notifier) sendSlack(notification *BuildNotification) error {
-	cfg := n.config.Slack
-
-	color := "good"
-	if notification.Status == "failed" {
-		color = "danger"
-	}
-
-	payload := map[string]interface{}{
-		"username":   cfg.Username,
-		"icon_emoji": cfg.IconEmoji,
-		"channel":    cfg.Channel,
-		"attachments": []map[string]interface{}{
-			{
-				"color": color,
-				"title": fmt.Sprintf("Build %s: %s-%s", strings.ToUpper(notification.Status), notification.PackageName, notification.Version),
-				"fields": []map[string]interface{}{
-					{
-						"title": "Job ID",
-						"value": notification.JobID,
-						"short": true,
-					},
-					{
-						"title": "Duration",
-						"value": notification.Duration,
-						"short": true,
-					},
-					{
-						"title": "Package",
-						"value": fmt.Sprintf("%s-%s", notification.PackageName, notification.Version),
-						"short": false,
-					},
-				},
-				"footer": "Portage Engine",
-				"ts":     notification.EndTime.Unix(),
-			},
-		},
-	}
-
-	if notification.ArtifactURL != "" {
-		payload["attachments"].([]map[string]interface{})[0]["fields"] = append(
-			payload["attachments"].([]map[string]interface{})[0]["fields"].([]map[string]interface{}),
-			map[string]interface{}{
-				"title": "Artifact",
-				"value": notification.ArtifactURL,
-				"short": false,
-			},
-		)
-	}
-
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal slack payload: %w", err)
-	}
-
-	resp, err := n.client.Post(cfg.WebhookURL, "application/json", bytes.NewReader(jsonPayload))
-	if err != nil {
-		return fmt.Errorf("failed to send slack notification: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("slack returned status %d: %s", resp.StatusCode, string(body))
-	}
-
-	log.Printf("Slack notification sent")
-	return nil
-}
-
 // sendTelegram sends Telegram notification.
 func (n *Notifier) sendTelegram(notification *BuildNotification) error {
 	cfg := n.config.Telegram
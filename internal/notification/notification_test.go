@@ -1,6 +1,9 @@
 package notification
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -155,6 +158,79 @@ func TestSendWebhookWithHeaders(t *testing.T) {
 	}
 }
 
+// TestSendWebhookSignature tests the HMAC signature header, which custom
+// headers cannot override.
+func TestSendWebhookSignature(t *testing.T) {
+	var signature string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("X-Signature")
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(&Config{Webhook: &WebhookConfig{
+		Enabled: true,
+		URL:     server.URL,
+		Secret:  "s3cret",
+		Headers: map[string]string{"X-Signature": "forged"},
+	}})
+	if err := notifier.sendWebhook(&BuildNotification{JobID: "sig-test", Status: "success"}); err != nil {
+		t.Fatalf("sendWebhook failed: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("X-Signature = %q, want %q", signature, want)
+	}
+}
+
+// TestSendWebhookRetries tests that 5xx responses are retried with
+// exponential backoff and 4xx responses are not.
+func TestSendWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		wantErr  bool
+		wantHits int
+	}{
+		{"recovers after 5xx", []int{503, 502, 200}, 0, false, 3},
+		{"gives up after max retries", []int{500, 500, 500}, 2, true, 3},
+		{"4xx is not retried", []int{404, 200}, 0, true, 1},
+		{"retries disabled", []int{500, 200}, -1, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.statuses[hits])
+				hits++
+			}))
+			defer server.Close()
+
+			notifier := NewNotifier(&Config{Webhook: &WebhookConfig{Enabled: true, URL: server.URL, MaxRetries: tt.retries}})
+			var delays []time.Duration
+			notifier.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+			err := notifier.sendWebhook(&BuildNotification{JobID: "retry-test"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sendWebhook err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if hits != tt.wantHits {
+				t.Errorf("requests = %d, want %d", hits, tt.wantHits)
+			}
+			for i, d := range delays {
+				if want := time.Second << i; d != want {
+					t.Errorf("delay %d = %s, want %s", i, d, want)
+				}
+			}
+		})
+	}
+}
+
 // TestSendSlack tests Slack notification.
 func TestSendSlack(t *testing.T) {
 	var receivedPayload map[string]interface{}
@@ -0,0 +1,102 @@
+// Package ratelimit provides per-key token-bucket rate limiting, such as per
+// user or per client IP.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is a set of token buckets, one per key, each refilling at the same
+// rate up to the same burst. Buckets of keys that stay idle long enough to
+// refill completely are evicted, since they are no different from new ones,
+// so the limiter holds only the keys active within one refill period.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+	// now is the clock, replaceable in tests.
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter allowing perMinute requests per minute per key, with
+// bursts of up to burst requests (perMinute when burst is not positive). It
+// returns nil, which allows everything, when perMinute is not positive.
+func New(perMinute, burst int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &Limiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// refillPeriod is how long an empty bucket takes to fill up.
+func (l *Limiter) refillPeriod() time.Duration {
+	return time.Duration(l.burst / l.rate * float64(time.Second))
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it returns
+// false and how long until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep evicts the buckets idle for a full refill period, at most once per
+// period. The caller holds l.mu.
+func (l *Limiter) sweep(now time.Time) {
+	period := l.refillPeriod()
+	if now.Sub(l.lastSweep) < period {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= period {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of keys with a bucket.
+func (l *Limiter) Len() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for a limiter.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(perMinute, burst int) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	l := New(perMinute, burst)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestBurst(t *testing.T) {
+	t.Parallel()
+
+	l, _ := newTestLimiter(60, 5)
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.Allow("alice"); !ok {
+			t.Fatalf("request %d of the burst rejected", i+1)
+		}
+	}
+	ok, wait := l.Allow("alice")
+	if ok {
+		t.Fatal("request beyond the burst allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("retry after %v, want up to 1s at 60/min", wait)
+	}
+	// Other keys have their own bucket.
+	if ok, _ := l.Allow("bob"); !ok {
+		t.Error("another key was limited")
+	}
+}
+
+func TestRefill(t *testing.T) {
+	t.Parallel()
+
+	l, clock := newTestLimiter(6, 2) // a token every 10s
+	l.Allow("alice")
+	l.Allow("alice")
+	if ok, wait := l.Allow("alice"); ok || wait != 10*time.Second {
+		t.Fatalf("empty bucket: %v, retry after %v, want 10s", ok, wait)
+	}
+
+	clock.advance(5 * time.Second)
+	if ok, wait := l.Allow("alice"); ok || wait != 5*time.Second {
+		t.Errorf("half a token: %v, retry after %v, want 5s", ok, wait)
+	}
+	clock.advance(5 * time.Second)
+	if ok, _ := l.Allow("alice"); !ok {
+		t.Error("refilled token rejected")
+	}
+	if ok, _ := l.Allow("alice"); ok {
+		t.Error("only one token should have refilled")
+	}
+
+	// A long pause refills up to the burst, not beyond.
+	clock.advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("alice"); !ok {
+			t.Errorf("request %d after refill rejected", i+1)
+		}
+	}
+	if ok, _ := l.Allow("alice"); ok {
+		t.Error("burst exceeded after a long pause")
+	}
+}
+
+func TestEvictIdleBuckets(t *testing.T) {
+	t.Parallel()
+
+	l, clock := newTestLimiter(60, 10) // refills in 10s
+	for i := 0; i < 100; i++ {
+		l.Allow(fmt.Sprintf("client-%d", i))
+	}
+	if l.Len() != 100 {
+		t.Fatalf("Len = %d, want 100", l.Len())
+	}
+
+	clock.advance(5 * time.Second)
+	l.Allow("client-0")
+	clock.advance(6 * time.Second)
+	l.Allow("newcomer")
+	// Everyone idle since before the last refill period is gone; client-0
+	// was active within it.
+	if l.Len() != 2 {
+		t.Errorf("Len after sweep = %d, want 2", l.Len())
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	t.Parallel()
+
+	l := New(0, 10)
+	if l != nil {
+		t.Fatal("a zero rate should disable the limiter")
+	}
+	for i := 0; i < 1000; i++ {
+		if ok, _ := l.Allow("alice"); !ok {
+			t.Fatal("disabled limiter rejected a request")
+		}
+	}
+	if New(30, 0).burst != 30 {
+		t.Error("burst should default to the per-minute rate")
+	}
+}
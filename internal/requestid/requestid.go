@@ -0,0 +1,26 @@
+// Package requestid provides the request ID that correlates the log lines
+// of one build across the dashboard, the server and the builders.
+package requestid
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header carrying the request ID from service to service.
+const Header = "X-Request-ID"
+
+// New returns a fresh request ID.
+func New() string {
+	return uuid.New().String()
+}
+
+// FromRequest returns the request ID r carries, or a fresh one when it
+// carries none.
+func FromRequest(r *http.Request) string {
+	if id := r.Header.Get(Header); id != "" {
+		return id
+	}
+	return New()
+}
@@ -0,0 +1,20 @@
+package requestid
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(Header, "abc-123")
+	if got := FromRequest(r); got != "abc-123" {
+		t.Errorf("FromRequest() = %q, want the incoming ID", got)
+	}
+
+	r.Header.Del(Header)
+	a, b := FromRequest(r), FromRequest(r)
+	if a == "" || a == b {
+		t.Errorf("FromRequest() without an ID = %q, %q, want distinct fresh IDs", a, b)
+	}
+}
@@ -1,10 +1,25 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/slchris/portage-engine/internal/binpkg"
+	"github.com/slchris/portage-engine/internal/builder"
+	"github.com/slchris/portage-engine/internal/gpg"
 )
 
 // builderProxyClient is used for all server→builder proxy calls; it has a
@@ -24,6 +39,29 @@ func (s *Server) getFromBuilder(url string) (*http.Response, error) {
 	return builderProxyClient.Do(req)
 }
 
+// downloadHeaders are the request headers an artifact download forwards, so
+// the builder can answer a resumed download with only the missing bytes and
+// serve the compressed form to a client that accepts its encoding.
+var downloadHeaders = []string{"Range", "If-Range", "Accept-Encoding"}
+
+// downloadFromBuilder is getFromBuilder for an artifact download requested by
+// r: it forwards r's Range headers and ends when r's client disconnects.
+func (s *Server) downloadFromBuilder(r *http.Request, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.config.BuilderToken != "" {
+		req.Header.Set("X-API-Key", s.config.BuilderToken)
+	}
+	for _, h := range downloadHeaders {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+	return builderProxyClient.Do(req)
+}
+
 // handleArtifactInfo returns artifact metadata for a job from a builder.
 func (s *Server) handleArtifactInfo(w http.ResponseWriter, r *http.Request) {
 	s.metrics.IncHTTPRequests()
@@ -99,7 +137,10 @@ func (s *Server) handleArtifactDownload(w http.ResponseWriter, r *http.Request)
 
 	// Proxy request to builder
 	downloadURL := fmt.Sprintf("%s/api/v1/artifacts/download/%s", builderURL, jobID)
-	resp, err := s.getFromBuilder(downloadURL)
+	if r.URL.Query().Get("original") == "true" {
+		downloadURL += "?original=true"
+	}
+	resp, err := s.downloadFromBuilder(r, downloadURL)
 	if err != nil {
 		s.metrics.IncHTTPRequestErrors()
 		http.Error(w, fmt.Sprintf("Failed to contact builder: %v", err), http.StatusBadGateway)
@@ -107,23 +148,391 @@ func (s *Server) handleArtifactDownload(w http.ResponseWriter, r *http.Request)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
 		body, _ := io.ReadAll(resp.Body)
+		if cr := resp.Header.Get("Content-Range"); cr != "" {
+			w.Header().Set("Content-Range", cr)
+		}
 		http.Error(w, string(body), resp.StatusCode)
 		return
 	}
 
-	// Forward headers
+	// Forward headers (including Content-Range and Accept-Ranges) and the
+	// status, which is 206 for a range request.
 	for key, values := range resp.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
+	w.WriteHeader(resp.StatusCode)
 
 	// Stream the file
 	_, _ = io.Copy(w, resp.Body)
 }
 
+// handleEphemeralArtifact serves an ephemeral build's held artifact exactly
+// once, to a caller presenting the token from the job's download_url; the
+// server keeps no copy afterwards.
+func (s *Server) handleEphemeralArtifact(w http.ResponseWriter, r *http.Request) {
+	s.metrics.IncHTTPRequests()
+
+	if r.Method != http.MethodGet {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := r.URL.Query().Get("job_id")
+	if jobID == "" {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Missing job_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	f, filename, err := s.builder.TakeEphemeralArtifact(jobID, r.URL.Query().Get("token"))
+	if err != nil {
+		s.metrics.IncHTTPRequestErrors()
+		status := http.StatusInternalServerError
+		if errors.Is(err, builder.ErrEphemeralNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer func() { _ = f.Close() }()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	if info, err := f.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+	_, _ = io.Copy(w, f)
+}
+
+// verifyRequest is the JSON body of POST /api/v1/artifacts/verify.
+type verifyRequest struct {
+	JobID string `json:"job_id"`
+	// Path selects one of the job's artifacts, relative to the builder's
+	// artifact dir; the job's primary artifact by default.
+	Path string `json:"path,omitempty"`
+}
+
+// builderJobArtifacts is the part of a builder's job status the verification
+// needs.
+type builderJobArtifacts struct {
+	ArtifactURL string                 `json:"artifact_url"`
+	Artifacts   []string               `json:"artifacts"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+// handleArtifactVerify serves POST /api/v1/artifacts/verify: it checks an
+// artifact's signature against the server's signing key. The artifact is
+// either a job's, named by a JSON {"job_id", "path"} body, or uploaded as
+// multipart form fields "file" and, for a detached signature, "signature"
+// (without one, the gpkg's embedded signatures are checked). A checked
+// artifact is answered with a gpg.VerifyResult, valid or not.
+func (s *Server) handleArtifactVerify(w http.ResponseWriter, r *http.Request) {
+	s.metrics.IncHTTPRequests()
+
+	if r.Method != http.MethodPost {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.settingsMu.Lock()
+	signer := s.gpgSigner
+	s.settingsMu.Unlock()
+	if !signer.IsEnabled() {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "GPG signing is not enabled on this server", http.StatusServiceUnavailable)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "artifact-verify-*")
+	if err != nil {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var result *gpg.VerifyResult
+	var status int
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		result, status, err = s.verifyUploadedArtifact(r, signer, dir)
+	} else {
+		result, status, err = s.verifyJobArtifact(r, signer, dir)
+	}
+	if err != nil {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// verifyUploadedArtifact verifies the package uploaded in r, staging it in
+// dir. On error it also returns the HTTP status to answer with.
+func (s *Server) verifyUploadedArtifact(r *http.Request, signer *gpg.Signer, dir string) (*gpg.VerifyResult, int, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid upload: %w", err)
+	}
+	defer func() { _ = r.MultipartForm.RemoveAll() }()
+
+	pkgPath := filepath.Join(dir, "package")
+	if err := saveFormFile(r, "file", pkgPath); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if _, _, err := r.FormFile("signature"); errors.Is(err, http.ErrMissingFile) {
+		return signer.VerifyGpkg(pkgPath), http.StatusOK, nil
+	}
+	sigPath := pkgPath + gpg.SignatureFileExt
+	if err := saveFormFile(r, "signature", sigPath); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	return signer.VerifyDetached(pkgPath, sigPath), http.StatusOK, nil
+}
+
+// saveFormFile writes the uploaded form file field to path.
+func saveFormFile(r *http.Request, field, path string) error {
+	f, _, err := r.FormFile(field)
+	if err != nil {
+		return fmt.Errorf("form field %q: %w", field, err)
+	}
+	defer func() { _ = f.Close() }()
+	out, err := os.Create(path) // #nosec G304 -- a file in our own temp dir.
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, f)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// verifyJobArtifact verifies an artifact of the job named in r's JSON body,
+// downloading it and its signature from the job's builder into dir. On
+// error it also returns the HTTP status to answer with.
+func (s *Server) verifyJobArtifact(r *http.Request, signer *gpg.Signer, dir string) (*gpg.VerifyResult, int, error) {
+	var req verifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err)
+	}
+	if req.JobID == "" || strings.Contains(req.JobID, "/") {
+		return nil, http.StatusBadRequest, fmt.Errorf("job_id required")
+	}
+
+	builderURL, err := s.getBuilderURLForJob(req.JobID)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	resp, err := s.getFromBuilder(fmt.Sprintf("%s/api/v1/jobs/%s", builderURL, req.JobID))
+	if err != nil {
+		return nil, http.StatusBadGateway, fmt.Errorf("failed to contact builder: %w", err)
+	}
+	var job builderJobArtifacts
+	err = json.NewDecoder(resp.Body).Decode(&job)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, http.StatusNotFound, fmt.Errorf("job not found: %s", req.JobID)
+	}
+	if err != nil {
+		return nil, http.StatusBadGateway, fmt.Errorf("invalid job status from builder: %w", err)
+	}
+
+	rel := req.Path
+	if rel == "" {
+		rel = primaryArtifactRel(job)
+	}
+	known := false
+	for _, a := range job.Artifacts {
+		known = known || a == rel
+	}
+	if rel == "" || !known {
+		return nil, http.StatusNotFound, fmt.Errorf("job %s has no artifact %q", req.JobID, rel)
+	}
+
+	pkgPath := filepath.Join(dir, "package")
+	if err := s.downloadJobFile(builderURL, req.JobID, rel, pkgPath); err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	sig, detached := jobSignatures(job.Metadata)[rel]
+	if !detached {
+		// Signed in-emerge (binpkg-signing), or not at all.
+		return signer.VerifyGpkg(pkgPath), http.StatusOK, nil
+	}
+	sigPath := pkgPath + gpg.SignatureFileExt
+	if err := s.downloadJobFile(builderURL, req.JobID, sig, sigPath); err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	return signer.VerifyDetached(pkgPath, sigPath), http.StatusOK, nil
+}
+
+// primaryArtifactRel returns the artifact the job's artifact_url (an
+// absolute path on the builder) points at, or its only artifact.
+func primaryArtifactRel(job builderJobArtifacts) string {
+	for _, rel := range job.Artifacts {
+		if job.ArtifactURL == rel || strings.HasSuffix(job.ArtifactURL, "/"+rel) {
+			return rel
+		}
+	}
+	if len(job.Artifacts) == 1 {
+		return job.Artifacts[0]
+	}
+	return ""
+}
+
+// jobSignatures reads the "signatures" job metadata the builder records:
+// artifact path to detached signature path.
+func jobSignatures(metadata map[string]interface{}) map[string]string {
+	sigs := map[string]string{}
+	m, _ := metadata["signatures"].(map[string]interface{})
+	for k, v := range m {
+		if s, ok := v.(string); ok {
+			sigs[k] = s
+		}
+	}
+	return sigs
+}
+
+// downloadJobFile downloads the file rel of a job's artifacts from its
+// builder to path.
+func (s *Server) downloadJobFile(builderURL, jobID, rel, path string) error {
+	resp, err := s.getFromBuilder(fmt.Sprintf("%s/api/v1/artifacts/download/%s?path=%s", builderURL, jobID, url.QueryEscape(rel)))
+	if err != nil {
+		return fmt.Errorf("failed to contact builder: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("failed to download %s from builder: %s: %s", rel, resp.Status, strings.TrimSpace(string(body)))
+	}
+	out, err := os.Create(path) // #nosec G304 -- a file in our own temp dir.
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, resp.Body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// builderArtifact is an indexed artifact and the builder holding it.
+type builderArtifact struct {
+	binpkg.ArtifactRecord
+	Builder string `json:"builder"`
+}
+
+// handleArtifactSearch serves GET /api/v1/artifacts/search: it asks every
+// builder for the indexed artifacts matching the query (name, version,
+// arch, use, signed, limit) and answers them merged, newest first. Builders
+// that cannot be asked are listed under "unreachable".
+func (s *Server) handleArtifactSearch(w http.ResponseWriter, r *http.Request) {
+	s.metrics.IncHTTPRequests()
+
+	if r.Method != http.MethodGet {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := binpkg.ParseArtifactQuery(r.URL.Query())
+	if err != nil {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		mu          sync.Mutex
+		wg          sync.WaitGroup
+		artifacts   []builderArtifact
+		unreachable = []string{}
+	)
+	for _, builderURL := range s.artifactBuilders() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs, err := s.searchBuilderArtifacts(builderURL, q)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("Failed to search artifacts on builder %s: %v", builderURL, err)
+				unreachable = append(unreachable, builderURL)
+				return
+			}
+			for _, rec := range recs {
+				artifacts = append(artifacts, builderArtifact{ArtifactRecord: *rec, Builder: builderURL})
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.SliceStable(artifacts, func(i, j int) bool {
+		return artifacts[i].BuiltAt.After(artifacts[j].BuiltAt)
+	})
+	if q.Limit > 0 && len(artifacts) > q.Limit {
+		artifacts = artifacts[:q.Limit]
+	}
+	if artifacts == nil {
+		artifacts = []builderArtifact{}
+	}
+	sort.Strings(unreachable)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"artifacts":   artifacts,
+		"count":       len(artifacts),
+		"unreachable": unreachable,
+	})
+}
+
+// artifactBuilders returns the URLs of the registered and configured
+// builders, once each.
+func (s *Server) artifactBuilders() []string {
+	seen := map[string]bool{}
+	var urls []string
+	add := func(addr string) {
+		if addr == "" {
+			return
+		}
+		u := normalizeBuilderURL(addr)
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	for _, b := range s.builderRegistry.List() {
+		add(b.Endpoint)
+	}
+	for _, addr := range s.builder.RemoteBuilders() {
+		add(addr)
+	}
+	return urls
+}
+
+// searchBuilderArtifacts runs q against the artifact index of the builder
+// at builderURL.
+func (s *Server) searchBuilderArtifacts(builderURL string, q binpkg.ArtifactQuery) ([]*binpkg.ArtifactRecord, error) {
+	resp, err := s.getFromBuilder(builderURL + "/api/v1/artifacts/search?" + q.Values().Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var result struct {
+		Artifacts []*binpkg.ArtifactRecord `json:"artifacts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid search response: %w", err)
+	}
+	return result.Artifacts, nil
+}
+
 // getBuilderURLForJob determines the builder URL that has the job.
 // It checks registered builders and returns the URL of the one that has the job.
 func (s *Server) getBuilderURLForJob(jobID string) (string, error) {
@@ -187,26 +596,32 @@ func (s *Server) handleGPGPublicKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The signer is swapped when the key is rotated.
+	s.settingsMu.Lock()
+	signer := s.gpgSigner
+	s.settingsMu.Unlock()
+
 	// Check if GPG is enabled
-	if !s.gpgSigner.IsEnabled() {
+	if !signer.IsEnabled() {
 		http.Error(w, "GPG not enabled on server", http.StatusNotFound)
 		return
 	}
 
 	// Try to get public key from signer
-	publicKey, err := s.gpgSigner.GetPublicKey()
+	publicKey, err := signer.GetPublicKey()
 	if err != nil {
-		// Fall back to file if configured
+		// Fall back to file if configured (armored or binary; it is converted
+		// to the requested format either way).
 		if s.config.GPGPublicKeyPath != "" {
-			http.ServeFile(w, r, s.config.GPGPublicKeyPath) // nolint:gosec // Config-defined path
-			return
+			if key, readErr := os.ReadFile(s.config.GPGPublicKeyPath); readErr == nil { // nolint:gosec // Config-defined path
+				s.writePublicKey(w, r, key)
+				return
+			}
 		}
 		s.metrics.IncHTTPRequestErrors()
 		http.Error(w, fmt.Sprintf("Failed to get public key: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/pgp-keys")
-	w.Header().Set("Content-Disposition", "attachment; filename=portage-engine.asc")
-	_, _ = w.Write([]byte(publicKey))
+	s.writePublicKey(w, r, []byte(publicKey))
 }
@@ -2,14 +2,17 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/slchris/portage-engine/internal/binpkg"
 	"github.com/slchris/portage-engine/internal/builder"
+	"github.com/slchris/portage-engine/internal/httpcache"
+	"github.com/slchris/portage-engine/internal/requestid"
 )
 
 // handlePackageQuery handles package availability queries.
@@ -89,6 +92,37 @@ func (s *Server) handleBuildRequest(w http.ResponseWriter, r *http.Request) {
 		req.CloudProvider = provider
 	}
 
+	if license, ok := rawReq["accept_license"].(string); ok {
+		req.AcceptLicense = license
+	}
+
+	if timeout, ok := rawReq["build_timeout"].(string); ok {
+		req.BuildTimeout = timeout
+	}
+
+	if deadline, ok := rawReq["deadline"].(string); ok {
+		at, err := time.Parse(time.RFC3339, deadline)
+		if err != nil {
+			s.metrics.IncHTTPRequestErrors()
+			http.Error(w, "deadline must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		req.Deadline = &at
+	}
+	if wait, ok := rawReq["max_queue_wait"].(string); ok {
+		req.MaxQueueWait = wait
+	}
+
+	if ephemeral, ok := rawReq["ephemeral"].(bool); ok {
+		req.Ephemeral = ephemeral
+	}
+	if callback, ok := rawReq["callback_url"].(string); ok {
+		req.CallbackURL = callback
+	}
+	if force, ok := rawReq["force_rebuild"].(bool); ok {
+		req.ForceRebuild = force
+	}
+
 	if useFlags, ok := rawReq["use_flags"].([]interface{}); ok {
 		req.UseFlags = make([]string, len(useFlags))
 		for i, flag := range useFlags {
@@ -105,12 +139,16 @@ func (s *Server) handleBuildRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	req.AllowDeniedFeatures = s.adminEscalated(r)
+	req.RequestID = r.Header.Get(requestid.Header)
+	req.Owner = s.requestOwner(r)
+
 	// Submit build request
 	s.metrics.IncBuildsTotal()
 	jobID, err := s.builder.SubmitBuild(&req)
 	if err != nil {
 		s.metrics.IncHTTPRequestErrors()
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), submitErrorStatus(err, http.StatusInternalServerError))
 		return
 	}
 
@@ -153,6 +191,176 @@ func (s *Server) handleBuildStatus(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(status)
 }
 
+// multiArchBuildRequest is a build request for several architectures at once.
+type multiArchBuildRequest struct {
+	builder.BuildRequest
+	Arches []string `json:"arches"`
+}
+
+// handleMultiArchSubmit fans a build out to one job per architecture under a
+// single multi-arch request ID.
+func (s *Server) handleMultiArchSubmit(w http.ResponseWriter, r *http.Request) {
+	s.metrics.IncHTTPRequests()
+
+	if r.Method != http.MethodPost {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req multiArchBuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.PackageName) == "" {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "package_name is required", http.StatusBadRequest)
+		return
+	}
+
+	req.AllowDeniedFeatures = s.adminEscalated(r)
+	req.RequestID = r.Header.Get(requestid.Header)
+	req.Owner = s.requestOwner(r)
+
+	groupID, jobs, err := s.builder.SubmitMultiArchBuild(&req.BuildRequest, req.Arches)
+	for range jobs {
+		s.metrics.IncBuildsTotal()
+	}
+	if err != nil {
+		s.metrics.IncHTTPRequestErrors()
+		if groupID == "" {
+			http.Error(w, err.Error(), submitErrorStatus(err, http.StatusBadRequest))
+			return
+		}
+		http.Error(w, fmt.Sprintf("multi-arch request %s: %v", groupID, err), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     groupID,
+		"status": "queued",
+		"jobs":   jobs,
+	})
+}
+
+// handleMultiArchStatus returns every per-arch status and artifact of a
+// multi-arch request: GET /api/v1/builds/multiarch/{id}.
+func (s *Server) handleMultiArchStatus(w http.ResponseWriter, r *http.Request) {
+	s.metrics.IncHTTPRequests()
+
+	if r.Method != http.MethodGet {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groupID := strings.TrimPrefix(r.URL.Path, "/api/v1/builds/multiarch/")
+	if groupID == "" || strings.Contains(groupID, "/") {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Missing multi-arch request ID", http.StatusBadRequest)
+		return
+	}
+
+	status, err := s.builder.GetMultiArchBuild(groupID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// handleBuildStatusByPackage returns the most recent build of a package,
+// looked up by atom (plus optional version and arch) instead of job ID. With
+// all=true the response also lists every matching job, newest first.
+func (s *Server) handleBuildStatusByPackage(w http.ResponseWriter, r *http.Request) {
+	s.metrics.IncHTTPRequests()
+
+	if r.Method != http.MethodGet {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	atom := q.Get("atom")
+	if atom == "" {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Missing atom parameter", http.StatusBadRequest)
+		return
+	}
+
+	matches := s.builder.FindBuildsByPackage(atom, q.Get("version"), q.Get("arch"))
+	if len(matches) == 0 {
+		http.Error(w, fmt.Sprintf("no builds found for %s", atom), http.StatusNotFound)
+		return
+	}
+
+	response := map[string]interface{}{
+		"atom":   atom,
+		"latest": matches[0],
+		"count":  len(matches),
+	}
+	if all, _ := strconv.ParseBool(q.Get("all")); all {
+		response["jobs"] = matches
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// handlePackageStats returns the build time statistics of a package:
+// GET /api/v1/packages/{category}/{package}/stats, optionally limited with
+// ?arch=, ?machine_type= and a ?window= duration shorter than
+// BUILD_STATS_WINDOW. Every version of the package counts.
+func (s *Server) handlePackageStats(w http.ResponseWriter, r *http.Request) {
+	s.metrics.IncHTTPRequests()
+
+	atom, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/packages/"), "/stats")
+	if !ok {
+		s.metrics.IncHTTPRequestErrors()
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := builder.ValidateAtom(atom); err != nil || strings.ContainsAny(atom, "<>=~:[") {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, fmt.Sprintf("invalid package %q, want category/package", atom), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	var window time.Duration
+	if raw := q.Get("window"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			s.metrics.IncHTTPRequestErrors()
+			http.Error(w, fmt.Sprintf("invalid window %q", raw), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	stats := s.builder.PackageBuildStats(atom, q.Get("arch"), q.Get("machine_type"), window)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
+
+// bundleBuildRequest is a config-bundle build as submitted by clients: the
+// builder's request plus the server-side scheduling limits.
+type bundleBuildRequest struct {
+	builder.LocalBuildRequest
+	Deadline     *time.Time `json:"deadline,omitempty"`
+	MaxQueueWait string     `json:"max_queue_wait,omitempty"`
+}
+
 // handleSubmitBuildWithConfig handles build requests with configuration bundles.
 func (s *Server) handleSubmitBuildWithConfig(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -160,7 +368,7 @@ func (s *Server) handleSubmitBuildWithConfig(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var req builder.LocalBuildRequest
+	var req bundleBuildRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
@@ -180,10 +388,20 @@ func (s *Server) handleSubmitBuildWithConfig(w http.ResponseWriter, r *http.Requ
 	// Translate to a Manager BuildRequest carrying the full bundle, which is
 	// forwarded verbatim to a remote builder so the exact configuration is used.
 	buildReq := &builder.BuildRequest{
-		PackageName:  req.PackageName,
-		Version:      req.Version,
-		Arch:         req.Arch,
-		ConfigBundle: req.ConfigBundle,
+		PackageName:   req.PackageName,
+		Version:       req.Version,
+		Arch:          req.Arch,
+		ConfigBundle:  req.ConfigBundle,
+		AcceptLicense: req.AcceptLicense,
+		BuildTimeout:  req.BuildTimeout,
+		Deadline:      req.Deadline,
+		MaxQueueWait:  req.MaxQueueWait,
+		ForceRebuild:  req.ForceRebuild,
+		Pretend:       req.Pretend,
+
+		AllowDeniedFeatures: s.adminEscalated(r),
+		RequestID:           r.Header.Get(requestid.Header),
+		Owner:               s.requestOwner(r),
 	}
 	if buildReq.PackageName == "" && len(req.ConfigBundle.Packages.Packages) > 0 {
 		buildReq.PackageName = req.ConfigBundle.Packages.Packages[0].Atom
@@ -193,7 +411,7 @@ func (s *Server) handleSubmitBuildWithConfig(w http.ResponseWriter, r *http.Requ
 	jobID, err := s.builder.SubmitBuild(buildReq)
 	if err != nil {
 		s.metrics.IncHTTPRequestErrors()
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		http.Error(w, err.Error(), submitErrorStatus(err, http.StatusServiceUnavailable))
 		return
 	}
 
@@ -202,15 +420,19 @@ func (s *Server) handleSubmitBuildWithConfig(w http.ResponseWriter, r *http.Requ
 	_ = json.NewEncoder(w).Encode(builder.BuildResponse{JobID: jobID, Status: "queued"})
 }
 
-// handleBuildsList returns all build jobs.
+// handleBuildsList returns a page of build jobs as {builds, total, offset,
+// limit}. The status (comma-separated), package (substring) and arch query
+// parameters filter the list before paging; sort and order pick the order,
+// newest first by default.
 func (s *Server) handleBuildsList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	query := r.URL.Query()
 
 	// Get limit parameter (default 0 = all, max 200)
-	limitStr := r.URL.Query().Get("limit")
+	limitStr := query.Get("limit")
 	limit := 0
 	if limitStr != "" {
 		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 {
@@ -220,21 +442,31 @@ func (s *Server) handleBuildsList(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+	offset := 0
+	if parsed, err := strconv.Atoi(query.Get("offset")); err == nil && parsed > 0 {
+		offset = parsed
+	}
 
-	builds := s.builder.ListAllBuilds()
-
-	// Sort by created_at descending (newest first) for stable ordering
-	sort.Slice(builds, func(i, j int) bool {
-		return builds[i].CreatedAt.After(builds[j].CreatedAt)
-	})
+	order, err := builder.ParseListOrder(query.Get("sort"), query.Get("order"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Apply limit if specified
-	if limit > 0 && len(builds) > limit {
-		builds = builds[:limit]
+	filter := builder.BuildFilter{
+		Package: strings.TrimSpace(query.Get("package")),
+		Arch:    strings.TrimSpace(query.Get("arch")),
+		Order:   order,
+		Offset:  offset,
+		Limit:   limit,
+	}
+	for _, status := range strings.Split(query.Get("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			filter.Statuses = append(filter.Statuses, status)
+		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(builds)
+	httpcache.WriteJSON(w, r, s.builder.ListBuilds(filter))
 }
 
 // handleClusterStatus returns the cluster status.
@@ -245,8 +477,10 @@ func (s *Server) handleClusterStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	status := s.builder.GetClusterStatus()
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(status)
+	// last_updated changes on every call; the ETag covers the counts only.
+	unstamped := *status
+	unstamped.LastUpdated = time.Time{}
+	httpcache.WriteJSONVersion(w, r, status, unstamped)
 }
 
 // handleBuildLogs returns logs for a specific build job.
@@ -277,6 +511,28 @@ func (s *Server) handleBuildLogs(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(response)
 }
 
+// handleBuildLogStream streams a job's log as Server-Sent Events while it
+// builds (?job_id=), ending with a "done" event once the job finishes.
+func (s *Server) handleBuildLogStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := r.URL.Query().Get("job_id")
+	if jobID == "" {
+		http.Error(w, "Missing job_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	stream, err := s.builder.StreamBuildLog(jobID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	builder.ServeLogStream(w, r, stream)
+}
+
 // handleSchedulerStatus returns scheduler status with task assignments.
 func (s *Server) handleSchedulerStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -328,3 +584,72 @@ func (s *Server) handleInstancesList(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(s.builder.ListInstances())
 }
+
+// handleExternalInstance registers (POST {"endpoint", "arch"}) or
+// deregisters (DELETE ?id=) an external instance: a builder the server did
+// not provision, scheduled like a warm cloud instance.
+func (s *Server) handleExternalInstance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Endpoint string `json:"endpoint"`
+			Arch     string `json:"arch"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		inst, err := s.builder.RegisterExternalInstance(req.Endpoint, req.Arch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(inst)
+	case http.MethodDelete:
+		if err := s.builder.DeregisterExternalInstance(r.URL.Query().Get("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleInstancePlan dry-runs provisioning: it plans the instance a cloud
+// build of the posted request would create and returns it, plan summary
+// included, without creating any infrastructure.
+func (s *Server) handleInstancePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req builder.BuildRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	inst, err := s.builder.PlanInstance(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(inst)
+}
+
+// submitErrorStatus maps a build submission error to its HTTP status: an
+// unwritable binpkg store is 507 Insufficient Storage, so the client sees a
+// server-side storage problem rather than a generic failure, and a request
+// failing validation (e.g. a malformed atom) is 400 Bad Request.
+func submitErrorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, binpkg.ErrStoreUnwritable):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, builder.ErrInvalidBuildRequest):
+		return http.StatusBadRequest
+	}
+	return fallback
+}
@@ -10,7 +10,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/slchris/portage-engine/internal/builder"
+	"github.com/slchris/portage-engine/internal/httpcache"
+	"github.com/slchris/portage-engine/internal/version"
 )
 
 // handleBuilderRegister handles builder registration requests.
@@ -31,7 +34,13 @@ func (s *Server) handleBuilderRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Register the builder
-	s.builderRegistry.Register(&info)
+	s.checkBuilderVersion(info.ID, info.Version, info.APIVersion)
+	if err := s.builderRegistry.Register(&info); err != nil {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.builder.RecordBuilderAPIVersion(info.Endpoint, info.APIVersion)
 
 	response := map[string]interface{}{
 		"success": true,
@@ -41,6 +50,80 @@ func (s *Server) handleBuilderRegister(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(response)
 }
 
+// handleBuilderAdmin takes a registered builder out of service:
+// POST /api/v1/builders/{id}/disable stops new jobs being dispatched to it
+// while running ones finish, POST /api/v1/builders/{id}/enable undoes that,
+// and DELETE /api/v1/builders/{id} removes it from the registry. A removed
+// builder that is still running registers again with its next heartbeat.
+// Changes are saved so they survive a server restart.
+func (s *Server) handleBuilderAdmin(w http.ResponseWriter, r *http.Request) {
+	s.metrics.IncHTTPRequests()
+
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/builders/"), "/")
+	want := http.MethodPost
+	switch action {
+	case "disable", "enable":
+	case "":
+		want = http.MethodDelete
+	default:
+		s.metrics.IncHTTPRequestErrors()
+		http.NotFound(w, r)
+		return
+	}
+	if id == "" {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "Missing builder ID", http.StatusBadRequest)
+		return
+	}
+	if r.Method != want {
+		s.metrics.IncHTTPRequestErrors()
+		w.Header().Set("Allow", want)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var message string
+	switch action {
+	case "":
+		if _, ok := s.builderRegistry.Get(id); !ok {
+			s.metrics.IncHTTPRequestErrors()
+			http.Error(w, "Builder not found", http.StatusNotFound)
+			return
+		}
+		s.builderRegistry.Unregister(id)
+		s.builder.SetBuilderDisabled(id, false)
+		message = "Builder deregistered"
+	default:
+		enabled := action == "enable"
+		if !s.builderRegistry.Enable(id, enabled) {
+			s.metrics.IncHTTPRequestErrors()
+			http.Error(w, "Builder not found", http.StatusNotFound)
+			return
+		}
+		s.builder.SetBuilderDisabled(id, !enabled)
+		message = "Builder " + action + "d"
+	}
+	log.Printf("%s: %s", message, id)
+	s.saveBuilders()
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": message,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// saveBuilders saves the builder registry, when persistence is set up.
+func (s *Server) saveBuilders() {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.SaveBuilders(s.builderRegistry.List()); err != nil {
+		log.Printf("Warning: failed to save builders: %v", err)
+	}
+}
+
 // handleBuildersList returns the list of all registered builders.
 func (s *Server) handleBuildersList(w http.ResponseWriter, r *http.Request) {
 	s.metrics.IncHTTPRequests()
@@ -52,8 +135,10 @@ func (s *Server) handleBuildersList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	builders := s.builderRegistry.List()
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(builders)
+	for _, b := range builders {
+		b.TreeStale = s.builder.TreeStale(b.TreeLastSync)
+	}
+	httpcache.WriteJSON(w, r, builders)
 }
 
 // handleBuildersStatus returns aggregate status and statistics for all builders.
@@ -73,11 +158,104 @@ func (s *Server) handleBuildersStatus(w http.ResponseWriter, r *http.Request) {
 	stats := calculateBuilderStats(builders)
 
 	response := map[string]interface{}{
-		"stats":    stats,
-		"builders": builders,
+		"stats":          stats,
+		"builders":       builders,
+		"server_version": version.Get("server"),
+	}
+	httpcache.WriteJSON(w, r, response)
+}
+
+// Keepalive of the builders WebSocket: the server pings every
+// buildersWSPingInterval and drops a client that has not answered within
+// buildersWSPongWait; each write must finish within buildersWSWriteWait.
+var (
+	buildersWSPingInterval = 30 * time.Second
+	buildersWSPongWait     = 60 * time.Second
+	buildersWSWriteWait    = 10 * time.Second
+)
+
+var buildersUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// Like the shell endpoint, this is reached through the dashboard and
+	// protected by the API key.
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// handleBuildersWS pushes the registered builders over a WebSocket
+// (GET /api/v1/builders/ws): a snapshot first, then an update per heartbeat
+// or status change and a remove per unregistered builder, as JSON
+// builder.BuilderEvent messages. A client that falls behind is disconnected
+// and resyncs by reconnecting.
+func (s *Server) handleBuildersWS(w http.ResponseWriter, r *http.Request) {
+	s.metrics.IncHTTPRequests()
+
+	// Subscribe before upgrading so no change between the snapshot and
+	// the first event is lost.
+	snapshot, events, stop := s.builderRegistry.Watch()
+	defer stop()
+
+	conn, err := buildersUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	// The client sends nothing but pongs; reading processes them and
+	// notices a closed or dead connection.
+	gone := make(chan struct{})
+	_ = conn.SetReadDeadline(time.Now().Add(buildersWSPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(buildersWSPongWait))
+	})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(ev builder.BuilderEvent) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(buildersWSWriteWait))
+		return conn.WriteJSON(ev)
+	}
+	for _, b := range snapshot {
+		b.TreeStale = s.builder.TreeStale(b.TreeLastSync)
+	}
+	if err := send(builder.BuilderEvent{Type: builder.BuilderEventSnapshot, Builders: snapshot}); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(buildersWSPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too far behind; reconnect"),
+					time.Now().Add(buildersWSWriteWait))
+				return
+			}
+			if ev.Builder != nil {
+				// Events are shared between watchers: stamp a copy.
+				b := *ev.Builder
+				b.TreeStale = s.builder.TreeStale(b.TreeLastSync)
+				ev.Builder = &b
+			}
+			if err := send(ev); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(buildersWSWriteWait)); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(response)
 }
 
 // BuilderStatusInfo represents status information from a builder.
@@ -95,11 +273,20 @@ type BuilderStatusInfo struct {
 	TotalBuilds   int     `json:"total_builds"`
 	SuccessBuilds int     `json:"success_builds"`
 	FailedBuilds  int     `json:"failed_builds"`
+	Version       string  `json:"version,omitempty"`
+	VersionSkew   bool    `json:"version_skew,omitempty"` // incompatible with the server
+	APIVersion    int     `json:"api_version,omitempty"`
+	Incompatible  bool    `json:"incompatible,omitempty"` // API too old; jobs are not routed to it
+	// Portage tree state; TreeStale flags a tree older than TREE_STALE_AFTER.
+	TreeLastSync   time.Time `json:"tree_last_sync,omitzero"`
+	TreeAgeSeconds int64     `json:"tree_age_seconds,omitempty"`
+	TreeRevision   string    `json:"tree_revision,omitempty"`
+	TreeStale      bool      `json:"tree_stale,omitempty"`
 }
 
 // fetchAllBuilderStatus queries all configured remote builders for their status.
 func (s *Server) fetchAllBuilderStatus() []BuilderStatusInfo {
-	remoteBuilders := s.builder.CloudSettings().RemoteBuilders
+	remoteBuilders := s.builder.RemoteBuilders()
 	if len(remoteBuilders) == 0 {
 		return nil
 	}
@@ -174,6 +361,21 @@ func (s *Server) fetchAllBuilderStatus() []BuilderStatusInfo {
 				TotalBuilds:   getIntValue(status, "total_builds", 0),
 				SuccessBuilds: getIntValue(status, "success_builds", 0),
 				FailedBuilds:  getIntValue(status, "failed_builds", 0),
+				Version:       getStringValue(status, "version", ""),
+				APIVersion:    getIntValue(status, "api_version", 0),
+			}
+			if reg, ok := s.builderRegistry.Get(info.ID); ok && !reg.Enabled {
+				info.Enabled = false
+			}
+			info.VersionSkew = !version.Compatible(version.Version, info.Version)
+			info.Incompatible = info.APIVersion < builder.MinBuilderAPIVersion
+			s.builder.RecordBuilderAPIVersion(address, info.APIVersion)
+			if last, err := time.Parse(time.RFC3339, getStringValue(status, "tree_last_sync", "")); err == nil {
+				info.TreeLastSync = last
+				info.TreeAgeSeconds = int64(time.Since(last).Seconds())
+				info.TreeRevision = getStringValue(status, "tree_revision", "")
+				info.TreeStale = s.builder.TreeStale(last)
+				s.builder.RecordBuilderTree(address, last, info.TreeRevision)
 			}
 
 			mu.Lock()
@@ -249,13 +451,27 @@ func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 
 	// Update builder registry with heartbeat info
 	builderInfo := &builder.BuilderInfo{
-		ID:          req.BuilderID,
-		Endpoint:    req.Endpoint,
-		Status:      req.Status,
-		Capacity:    req.Capacity,
-		CurrentLoad: req.ActiveJobs,
+		ID:           req.BuilderID,
+		Endpoint:     req.Endpoint,
+		Status:       req.Status,
+		Capacity:     req.Capacity,
+		CurrentLoad:  req.ActiveJobs,
+		Version:      req.Version,
+		APIVersion:   req.APIVersion,
+		TreeLastSync: req.TreeLastSync,
+		TreeRevision: req.TreeRevision,
+	}
+	s.checkBuilderVersion(req.BuilderID, req.Version, req.APIVersion)
+	if err := s.builderRegistry.Register(builderInfo); err != nil {
+		s.metrics.IncHeartbeatsFailed()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(builder.HeartbeatResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
 	}
-	s.builderRegistry.Register(builderInfo)
 
 	// Update builder heartbeat in the scheduler
 	if err := s.builder.UpdateBuilderHeartbeat(&req); err != nil {
@@ -277,6 +493,24 @@ func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(response)
 }
 
+// checkBuilderVersion warns when a builder's release or API version is
+// incompatible with the server's. It runs before the registration is updated
+// and only logs when the builder first reports a version, so a skewed builder
+// does not log on every heartbeat.
+func (s *Server) checkBuilderVersion(builderID, builderVersion string, apiVersion int) {
+	if prev, ok := s.builderRegistry.Get(builderID); ok && prev.Version == builderVersion && prev.APIVersion == apiVersion {
+		return
+	}
+	if apiVersion < builder.MinBuilderAPIVersion {
+		log.Printf("WARNING: builder %s speaks API version %d, below the minimum %d; no jobs will be routed to it until it is upgraded",
+			builderID, apiVersion, builder.MinBuilderAPIVersion)
+	}
+	if builderVersion != "" && !version.Compatible(version.Version, builderVersion) {
+		log.Printf("WARNING: builder %s runs version %s, incompatible with server version %s; upgrade it to match",
+			builderID, builderVersion, version.Version)
+	}
+}
+
 // Helper functions for type conversion from map[string]interface{}
 
 // normalizeBuilderURL ensures the builder address has the correct URL format.
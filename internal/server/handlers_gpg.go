@@ -69,6 +69,7 @@ func (s *Server) enableGPG(name, email string) error {
 	if err := signer.Initialize(); err != nil {
 		return err
 	}
+	s.adoptKeyHistory(signer)
 	s.settingsMu.Lock()
 	s.gpgSigner = signer
 	s.settingsMu.Unlock()
@@ -100,8 +101,28 @@ func (s *Server) gpgKeyMaterial() (string, []byte, []byte) {
 	return signer.KeyID(), pub, sec
 }
 
-// handleGPGPubkey serves the armored public signing key so clients (and the
-// mirror) can trust the binhost.
+// writePublicKey serves a public key in the format the "format" query
+// parameter asks for: "asc" (armored, the default) or "gpg" (binary).
+func (s *Server) writePublicKey(w http.ResponseWriter, r *http.Request, key []byte) {
+	format, err := gpg.ParseKeyFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	out, err := gpg.ConvertKey(key, format)
+	if err != nil {
+		s.metrics.IncHTTPRequestErrors()
+		http.Error(w, "failed to export public key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pgp-keys")
+	w.Header().Set("Content-Disposition", `attachment; filename="portage-engine.`+format+`"`)
+	_, _ = w.Write(out)
+}
+
+// handleGPGPubkey serves the public signing key (armored, or binary with
+// ?format=gpg) so clients (and the mirror) can trust the binhost.
 func (s *Server) handleGPGPubkey(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -112,9 +133,7 @@ func (s *Server) handleGPGPubkey(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "GPG signing is not enabled", http.StatusNotFound)
 		return
 	}
-	w.Header().Set("Content-Type", "application/pgp-keys")
-	w.Header().Set("Content-Disposition", `attachment; filename="portage-engine.asc"`)
-	_, _ = w.Write(pub)
+	s.writePublicKey(w, r, pub)
 }
 
 // handleGPGStatus reports whether signing is enabled and with which key.
@@ -160,3 +179,124 @@ func (s *Server) handleGPGGenerate(w http.ResponseWriter, r *http.Request) {
 		"key_id":  s.gpgSigner.KeyID(),
 	})
 }
+
+// gpgKeyHistory returns the signing key history, loading it on first use.
+func (s *Server) gpgKeyHistory() (*gpg.KeyHistory, error) {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	if s.keyHistory != nil {
+		return s.keyHistory, nil
+	}
+	dataDir := s.config.DataDir
+	if dataDir == "" {
+		dataDir = "/var/lib/portage-engine/server"
+	}
+	h, err := gpg.LoadKeyHistory(filepath.Join(dataDir, "gpg-key-history.json"))
+	if err != nil {
+		return nil, err
+	}
+	s.keyHistory = h
+	return h, nil
+}
+
+// adoptKeyHistory switches a freshly initialized signer to the key the
+// history says is current, so a rotation survives restarts, or starts the
+// history with the signer's key.
+func (s *Server) adoptKeyHistory(signer *gpg.Signer) {
+	h, err := s.gpgKeyHistory()
+	if err == nil {
+		err = h.Adopt(signer)
+	}
+	if err != nil {
+		log.Printf("Warning: GPG key history: %v", err)
+	}
+}
+
+// handleKeysPublic serves the current signing key (armored, or binary with
+// ?format=gpg), or with ?key_id= any key in the history, so packages signed
+// before a rotation can still be checked.
+func (s *Server) handleKeysPublic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	keyID := r.URL.Query().Get("key_id")
+	if keyID == "" {
+		s.handleGPGPublicKey(w, r)
+		return
+	}
+	h, err := s.gpgKeyHistory()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key, ok := h.Key(keyID)
+	if !ok {
+		http.Error(w, "unknown key "+keyID, http.StatusNotFound)
+		return
+	}
+	s.writePublicKey(w, r, []byte(key.PublicKey))
+}
+
+// handleKeysHistory lists every signing key, oldest first, with its validity
+// window and succession certificate.
+func (s *Server) handleKeysHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h, err := s.gpgKeyHistory()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	keys := h.Keys()
+	if keys == nil {
+		keys = []gpg.KeyRecord{}
+	}
+	writeJSON(w, map[string]any{"keys": keys})
+}
+
+// handleKeysRotate replaces the signing key with a new one certified by the
+// outgoing key. It requires the admin key (X-Admin-Key) or an admin token.
+func (s *Server) handleKeysRotate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.adminEscalated(r) {
+		http.Error(w, "key rotation requires the admin key or role", http.StatusForbidden)
+		return
+	}
+	var req gpgRuntimeConfig
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	s.settingsMu.Lock()
+	signer := s.gpgSigner
+	s.settingsMu.Unlock()
+	if !signer.IsEnabled() {
+		http.Error(w, "GPG signing is not enabled", http.StatusConflict)
+		return
+	}
+	h, err := s.gpgKeyHistory()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	next, record, err := signer.RotateKey(h, req.Name, req.Email)
+	if err != nil {
+		http.Error(w, "key rotation failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.settingsMu.Lock()
+	s.gpgSigner = next
+	s.settingsMu.Unlock()
+	if s.config.GPGPublicKeyPath != "" {
+		if err := next.ExportPublicKey(s.config.GPGPublicKeyPath); err != nil {
+			log.Printf("Warning: failed to export public key: %v", err)
+		}
+	}
+
+	log.Printf("GPG signing key rotated: %s -> %s", record.PreviousKeyID, record.KeyID)
+	writeJSON(w, record)
+}
@@ -7,8 +7,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/slchris/portage-engine/internal/iac"
+	"github.com/slchris/portage-engine/internal/ratelimit"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
@@ -73,6 +75,36 @@ func (s *Server) loadCloudSettingsOverride() {
 	log.Printf("Applied dashboard-managed cloud settings from %s (overrides server.conf)", s.cloudSettingsPath())
 }
 
+// ReloadConfig applies the reloadable fields of cfg, the server config read
+// again on SIGHUP: the remote builder list, the scheduling strategy and the
+// rate limits. It rejects cfg, leaving the running config untouched, when it
+// fails CheckReload, and otherwise returns the changed fields it ignored
+// because they need a restart. While dashboard-managed cloud settings are
+// saved, their remote builder list stays in effect.
+func (s *Server) ReloadConfig(cfg *config.ServerConfig) ([]string, error) {
+	if err := cfg.CheckReload(); err != nil {
+		return nil, err
+	}
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	ignored := s.config.RestartRequired(cfg)
+	next := *cfg
+	if _, err := os.Stat(s.cloudSettingsPath()); err == nil {
+		next.RemoteBuilders = s.builder.CloudSettings().RemoteBuilders
+		if !slices.Equal(cfg.RemoteBuilders, next.RemoteBuilders) {
+			log.Printf("Config reload: keeping the remote builders of the dashboard-managed settings in %s", s.cloudSettingsPath())
+		}
+	}
+	s.builder.ReloadConfig(&next)
+
+	if limits := [2]int{cfg.RateLimitPerMinute, cfg.RateLimitBurst}; limits != s.rateLimits {
+		s.submitLimiter.Store(ratelimit.New(cfg.RateLimitPerMinute, cfg.RateLimitBurst))
+		s.rateLimits = limits
+	}
+	return ignored, nil
+}
+
 // handleCloudSettings serves GET (redacted view) and PUT (update + persist).
 func (s *Server) handleCloudSettings(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -93,7 +125,7 @@ func (s *Server) updateCloudSettings(w http.ResponseWriter, r *http.Request) {
 	}
 
 	switch in.Provider {
-	case "", "pve", "gcp", "aws":
+	case "", "pve", "gcp", "aws", "hetzner":
 	default:
 		http.Error(w, fmt.Sprintf("unsupported provider %q", in.Provider), http.StatusBadRequest)
 		return
@@ -117,3 +117,56 @@ func TestCloudSettingsRejectsBadProvider(t *testing.T) {
 		t.Errorf("expected 400 for unsupported provider, got %d", w.Code)
 	}
 }
+
+// TestReloadConfig: a reload applies the remote builders, scheduling strategy
+// and rate limits, reports the fields that need a restart, and a rejected
+// config leaves the running one in effect.
+func TestReloadConfig(t *testing.T) {
+	s := settingsTestServer(t)
+	next := *s.config
+	next.Port = 9000
+	next.RemoteBuilders = []string{"b1:9090", "b2:9090"}
+	next.SchedulingStrategy = config.SchedulingFirst
+	next.RateLimitPerMinute = 1
+	next.RateLimitBurst = 1
+
+	ignored, err := s.ReloadConfig(&next)
+	if err != nil {
+		t.Fatalf("ReloadConfig: %v", err)
+	}
+	if len(ignored) != 1 || ignored[0] != "Port" {
+		t.Errorf("ignored = %v, want [Port]", ignored)
+	}
+	if got := s.builder.RemoteBuilders(); len(got) != 2 || got[1] != "b2:9090" {
+		t.Errorf("remote builders = %v", got)
+	}
+	limiter := s.submitLimiter.Load()
+	if ok, _ := limiter.Allow("ip:a"); !ok {
+		t.Fatal("first submission refused")
+	}
+	if ok, _ := limiter.Allow("ip:a"); ok {
+		t.Error("the reloaded rate limit is not applied")
+	}
+
+	bad := next
+	bad.RemoteBuilders = []string{"b3:9090"}
+	bad.SchedulingStrategy = "fastest"
+	if _, err := s.ReloadConfig(&bad); err == nil {
+		t.Fatal("ReloadConfig accepted an unknown scheduling strategy")
+	}
+	if got := s.builder.RemoteBuilders(); len(got) != 2 || s.submitLimiter.Load() != limiter {
+		t.Errorf("the rejected config was applied: builders %v", got)
+	}
+
+	// Saved dashboard settings keep their remote builders over the file's.
+	if err := os.WriteFile(s.cloudSettingsPath(), []byte(`{"remote_builders":["b9:9090"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s.loadCloudSettingsOverride()
+	if _, err := s.ReloadConfig(&next); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.builder.RemoteBuilders(); len(got) != 1 || got[0] != "b9:9090" {
+		t.Errorf("remote builders = %v, want the dashboard-managed [b9:9090]", got)
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/slchris/portage-engine/internal/requestid"
 )
 
 // responseWriter wraps http.ResponseWriter to capture the status code and bytes written.
@@ -42,6 +42,12 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController, so
+// streaming handlers can flush through the logging middleware.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Hijack forwards to the underlying writer so WebSocket upgrades (web shell)
 // work through the logging middleware.
 func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
@@ -57,16 +63,13 @@ func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 // reused for request tracing across services (Server → Builder).
 func (s *Server) requestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestID := r.Header.Get("X-Request-ID")
-		if requestID == "" {
-			requestID = uuid.New().String()
-		}
+		requestID := requestid.FromRequest(r)
 
 		// Set on response for client correlation
-		w.Header().Set("X-Request-ID", requestID)
+		w.Header().Set(requestid.Header, requestID)
 
 		// Store in request context via header so downstream handlers can read it
-		r.Header.Set("X-Request-ID", requestID)
+		r.Header.Set(requestid.Header, requestID)
 
 		next.ServeHTTP(w, r)
 	})
@@ -90,7 +93,7 @@ func (s *Server) enhancedLoggingMiddleware(next http.Handler) http.Handler {
 
 		// Calculate duration
 		duration := time.Since(start)
-		requestID := r.Header.Get("X-Request-ID")
+		requestID := r.Header.Get(requestid.Header)
 
 		// Update goroutine metric
 		s.metrics.UpdateGoroutines(int64(runtime.NumGoroutine()))
@@ -6,26 +6,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/slchris/portage-engine/internal/auth"
 	"github.com/slchris/portage-engine/internal/binpkg"
 	"github.com/slchris/portage-engine/internal/builder"
 	"github.com/slchris/portage-engine/internal/gpg"
 	"github.com/slchris/portage-engine/internal/metrics"
+	"github.com/slchris/portage-engine/internal/ratelimit"
+	"github.com/slchris/portage-engine/internal/version"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
-// Version information (set via -ldflags at build time).
-var (
-	Version   = "dev"
-	Commit    = "unknown"
-	BuildTime = "unknown"
-)
-
 // Server represents the Portage Engine server.
 type Server struct {
 	config          *config.ServerConfig
@@ -34,11 +33,18 @@ type Server struct {
 	builderRegistry *builder.Registry
 	metrics         *metrics.Metrics
 	gpgSigner       *gpg.Signer
+	keyHistory      *gpg.KeyHistory // loaded on first use, under settingsMu
 	startTime       time.Time
 	store           *ServerStore
 	persister       *ServerPersister
 	binhostStop     chan struct{}
 	settingsMu      sync.Mutex // serializes settings updates + persistence
+	// submitLimiter rate-limits build submissions per user or client IP
+	// (nil when RATE_LIMIT_PER_MINUTE is 0); a config reload swaps it.
+	submitLimiter atomic.Pointer[ratelimit.Limiter]
+	// rateLimits are the per-minute rate and burst of submitLimiter, guarded
+	// by settingsMu.
+	rateLimits [2]int
 }
 
 // New creates a new Server instance.
@@ -67,7 +73,9 @@ func New(cfg *config.ServerConfig) *Server {
 		metrics:         metrics.New(metricsCfg),
 		gpgSigner:       signer,
 		startTime:       time.Now(),
+		rateLimits:      [2]int{cfg.RateLimitPerMinute, cfg.RateLimitBurst},
 	}
+	s.submitLimiter.Store(ratelimit.New(cfg.RateLimitPerMinute, cfg.RateLimitBurst))
 
 	// When a build's artifact lands in the binhost PKGDIR, refresh the
 	// Packages index right away so clients see the new package without
@@ -83,6 +91,12 @@ func New(cfg *config.ServerConfig) *Server {
 
 // Initialize initializes the server, including GPG key setup and state persistence.
 func (s *Server) Initialize() error {
+	// Fail fast on an unwritable PKGDIR: otherwise the server starts fine and
+	// every build fails later when its package cannot be stored.
+	if err := s.binpkgStore.CheckWritable(); err != nil {
+		return fmt.Errorf("binpkg store %s: %w", s.binpkgStore.BasePath(), err)
+	}
+
 	if s.gpgSigner.IsEnabled() {
 		log.Printf("Initializing GPG signer...")
 		if err := s.gpgSigner.Initialize(); err != nil {
@@ -96,6 +110,7 @@ func (s *Server) Initialize() error {
 			}
 		}
 
+		s.adoptKeyHistory(s.gpgSigner)
 		log.Printf("GPG signer initialized with key: %s", s.gpgSigner.KeyID())
 	}
 
@@ -132,13 +147,14 @@ func (s *Server) Initialize() error {
 // startBinhostRefresher periodically regenerates the binhost index so packages
 // added to PKGDIR out of band become visible to emerge without a restart.
 func (s *Server) startBinhostRefresher(interval time.Duration) {
-	s.binhostStop = make(chan struct{})
+	stop := make(chan struct{})
+	s.binhostStop = stop
 	go func() {
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 		for {
 			select {
-			case <-s.binhostStop:
+			case <-stop:
 				return
 			case <-ticker.C:
 				if _, err := s.binpkgStore.RegenerateIndex(s.binhostArch()); err != nil {
@@ -174,6 +190,21 @@ func (s *Server) initPersistence() error {
 		log.Printf("Loaded %d persisted jobs from disk", len(jobs))
 	}
 
+	// Load the builder registry, keeping builders disabled for maintenance
+	// disabled across the restart.
+	builders, err := store.LoadBuilders()
+	if err != nil {
+		log.Printf("Warning: failed to load persisted builders: %v", err)
+	} else if len(builders) > 0 {
+		s.builderRegistry.Restore(builders)
+		for _, b := range builders {
+			if !b.Enabled {
+				s.builder.SetBuilderDisabled(b.ID, true)
+			}
+		}
+		log.Printf("Loaded %d persisted builders from disk", len(builders))
+	}
+
 	// Start periodic persistence (save every 30s, clean jobs older than 7 days)
 	s.persister = NewServerPersister(
 		store,
@@ -203,6 +234,7 @@ func (s *Server) Shutdown() {
 		log.Println("Saving server state to disk...")
 		s.persister.Stop()
 	}
+	s.saveBuilders()
 
 	// Shutdown builder manager (closes work queue, stops IaC cleanup)
 	if s.builder != nil {
@@ -228,20 +260,28 @@ func (s *Server) Router() http.Handler {
 
 	// Package query endpoints
 	mux.HandleFunc("/api/v1/packages/query", s.handlePackageQuery)
-	mux.HandleFunc("/api/v1/packages/request-build", s.handleBuildRequest)
+	mux.HandleFunc("/api/v1/packages/request-build", s.requireRole(auth.RoleSubmitter, s.rateLimited(s.handleBuildRequest)))
 	mux.HandleFunc("/api/v1/packages/status", s.handleBuildStatus)
+	mux.HandleFunc("/api/v1/packages/", s.handlePackageStats)
 
 	// Build management endpoints
-	mux.HandleFunc("/api/v1/settings/cloud", s.handleCloudSettings)
-	mux.HandleFunc("/api/v1/settings/cloud/test", s.handleCloudSettingsTest)
+	mux.HandleFunc("/api/v1/settings/cloud", s.requireWriteRole(auth.RoleAdmin, s.handleCloudSettings))
+	mux.HandleFunc("/api/v1/settings/cloud/test", s.requireRole(auth.RoleAdmin, s.handleCloudSettingsTest))
 	mux.HandleFunc("/api/v1/instances", s.handleInstancesList)
-	mux.HandleFunc("/api/v1/instances/shell", s.handleInstanceShell)
-	mux.HandleFunc("/api/v1/builds/delete", s.handleBuildDelete)
-	mux.HandleFunc("/api/v1/builds/cleanup-failed", s.handleBuildsCleanupFailed)
+	mux.HandleFunc("/api/v1/instances/shell", s.requireRole(auth.RoleAdmin, s.handleInstanceShell))
+	mux.HandleFunc("/api/v1/instances/plan", s.requireRole(auth.RoleAdmin, s.rateLimited(s.handleInstancePlan)))
+	mux.HandleFunc("/api/v1/instances/external", s.requireRole(auth.RoleAdmin, s.handleExternalInstance))
+	mux.HandleFunc("/api/v1/builds/delete", s.requireRole(auth.RoleSubmitter, s.handleBuildDelete))
+	mux.HandleFunc("/api/v1/builds/cleanup-failed", s.requireRole(auth.RoleSubmitter, s.handleBuildsCleanupFailed))
 	mux.HandleFunc("/api/v1/builds/list", s.handleBuildsList)
-	mux.HandleFunc("/api/v1/builds/submit", s.handleSubmitBuildWithConfig)
+	mux.HandleFunc("/api/v1/builds/submit", s.requireRole(auth.RoleSubmitter, s.rateLimited(s.handleSubmitBuildWithConfig)))
 	mux.HandleFunc("/api/v1/builds/status", s.handleBuildStatus)
+	mux.HandleFunc("/api/v1/builds/status-by-package", s.handleBuildStatusByPackage)
+	mux.HandleFunc("/api/v1/builds/multiarch", s.requireRole(auth.RoleSubmitter, s.rateLimited(s.handleMultiArchSubmit)))
+	mux.HandleFunc("/api/v1/builds/multiarch/", s.handleMultiArchStatus)
 	mux.HandleFunc("/api/v1/builds/logs", s.handleBuildLogs)
+	mux.HandleFunc(builder.LogStreamPath, s.handleBuildLogStream)
+	mux.HandleFunc(builder.EphemeralDownloadPath, s.requireRole(auth.RoleViewer, s.handleEphemeralArtifact))
 	mux.HandleFunc("/api/v1/cluster/status", s.handleClusterStatus)
 	mux.HandleFunc("/api/v1/scheduler/status", s.handleSchedulerStatus)
 
@@ -249,10 +289,14 @@ func (s *Server) Router() http.Handler {
 	mux.HandleFunc("/api/v1/builders/register", s.handleBuilderRegister)
 	mux.HandleFunc("/api/v1/builders/list", s.handleBuildersList)
 	mux.HandleFunc("/api/v1/builders/status", s.handleBuildersStatus)
+	mux.HandleFunc("/api/v1/builders/ws", s.handleBuildersWS)
+	mux.HandleFunc("/api/v1/builders/", s.requireRole(auth.RoleAdmin, s.handleBuilderAdmin))
 
 	// Artifact download proxy endpoints
 	mux.HandleFunc("/api/v1/artifacts/download/", s.handleArtifactDownload)
 	mux.HandleFunc("/api/v1/artifacts/info/", s.handleArtifactInfo)
+	mux.HandleFunc("/api/v1/artifacts/verify", s.handleArtifactVerify)
+	mux.HandleFunc("/api/v1/artifacts/search", s.handleArtifactSearch)
 
 	// Binhost: serve the PKGDIR (including the Packages index) so a stock
 	// `emerge --getbinpkg` can consume this server. This is intentionally public
@@ -263,8 +307,11 @@ func (s *Server) Router() http.Handler {
 	// GPG endpoint
 	mux.HandleFunc("/api/v1/gpg/public-key", s.handleGPGPublicKey)
 	mux.HandleFunc("/api/v1/gpg/status", s.handleGPGStatus)
-	mux.HandleFunc("/api/v1/gpg/generate", s.handleGPGGenerate)
+	mux.HandleFunc("/api/v1/gpg/generate", s.requireRole(auth.RoleAdmin, s.handleGPGGenerate))
 	mux.HandleFunc("/api/v1/gpg/pubkey", s.handleGPGPubkey)
+	mux.HandleFunc("/api/v1/keys/public", s.handleKeysPublic)
+	mux.HandleFunc("/api/v1/keys/history", s.handleKeysHistory)
+	mux.HandleFunc("/api/v1/keys/rotate", s.requireRole(auth.RoleAdmin, s.handleKeysRotate))
 
 	// Heartbeat endpoint
 	mux.HandleFunc("/api/v1/heartbeat", s.handleHeartbeat)
@@ -277,6 +324,7 @@ func (s *Server) Router() http.Handler {
 
 	// Health / readiness / liveness probes (always public)
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/api/v1/version", version.Handler("server"))
 	mux.HandleFunc("/readyz", s.handleReadyz)
 	mux.HandleFunc("/livez", s.handleLivez)
 
@@ -309,7 +357,7 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 		// the Allow-Origin header at all — the browser will block the request.
 
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Admin-Key")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -333,13 +381,14 @@ func (s *Server) isOriginAllowed(origin string) bool {
 	return false
 }
 
-// apiKeyAuthMiddleware protects API endpoints with a shared API key.
-// Public endpoints (/health, /readyz, /livez, /metrics) are excluded.
-// If APIKey is empty in config, the middleware is a no-op (backward compatible).
+// apiKeyAuthMiddleware protects API endpoints with a shared API key, or a
+// valid operator token when JWT_SECRET is set. Public endpoints (/health, /readyz, /livez, /metrics, /api/v1/version) are excluded.
+// With neither API_KEY nor JWT_SECRET configured, the middleware is a no-op
+// (backward compatible); with only JWT_SECRET, every request needs a token.
 func (s *Server) apiKeyAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip auth if no API key is configured
-		if s.config.APIKey == "" {
+		// Skip auth if neither an API key nor a token secret is configured
+		if s.config.APIKey == "" && s.config.JWTSecret == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -348,7 +397,7 @@ func (s *Server) apiKeyAuthMiddleware(next http.Handler) http.Handler {
 		// public because emerge cannot present the API key; it is read-only.
 		path := r.URL.Path
 		if path == "/health" || path == "/readyz" || path == "/livez" || path == "/metrics" || path == "/metrics/prometheus" ||
-			strings.HasPrefix(path, "/binpkgs/") {
+			path == "/api/v1/version" || strings.HasPrefix(path, "/binpkgs/") {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -368,14 +417,11 @@ func (s *Server) apiKeyAuthMiddleware(next http.Handler) http.Handler {
 			}
 		}
 
-		// Constant-time comparison to avoid leaking the key via timing.
-		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(s.config.APIKey)) != 1 {
-			s.metrics.IncHTTPRequestErrors()
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			_ = json.NewEncoder(w).Encode(map[string]string{
-				"error": "unauthorized: invalid or missing API key",
-			})
+		// Constant-time comparison to avoid leaking the key via timing. Without
+		// an API key, only operator tokens get in.
+		keyOK := s.config.APIKey != "" && subtle.ConstantTimeCompare([]byte(apiKey), []byte(s.config.APIKey)) == 1
+		if !keyOK && !s.validToken(r) {
+			s.writeUnauthorized(w, "unauthorized: invalid or missing API key or token")
 			return
 		}
 
@@ -383,6 +429,118 @@ func (s *Server) apiKeyAuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// tokenClaims returns the claims of the operator token r carries, as the
+// dashboard issues them, if it verifies against JWT_SECRET.
+func (s *Server) tokenClaims(r *http.Request) (*auth.Claims, bool) {
+	if s.config.JWTSecret == "" {
+		return nil, false
+	}
+	claims, err := auth.VerifyToken(s.config.JWTSecret, auth.TokenFromRequest(r, ""), time.Now())
+	return claims, err == nil
+}
+
+// validToken reports whether r carries a valid operator token.
+func (s *Server) validToken(r *http.Request) bool {
+	_, ok := s.tokenClaims(r)
+	return ok
+}
+
+// requireRole guards a state-changing endpoint: once JWT_SECRET is set it
+// needs an operator token with role (see auth.RequireRole), or the API key,
+// which grants every role, even where the API key alone is not enforced.
+func (s *Server) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	guarded := auth.RequireRole(role)(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.JWTSecret == "" {
+			next(w, r)
+			return
+		}
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			key = auth.BearerToken(r.Header.Get("Authorization"))
+		}
+		if s.config.APIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.config.APIKey)) == 1 {
+			next(w, r)
+			return
+		}
+		if claims, ok := s.tokenClaims(r); ok {
+			r = r.WithContext(auth.WithClaims(r.Context(), claims))
+		}
+		guarded.ServeHTTP(w, r)
+	}
+}
+
+// rateLimited applies the submission rate limit to next, per authenticated
+// user or, for anonymous requests and the shared API key, per client IP.
+// Requests beyond it get 429 with a Retry-After header.
+func (s *Server) rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limiter := s.submitLimiter.Load()
+		if limiter == nil {
+			next(w, r)
+			return
+		}
+		key := "ip:" + stripPort(r.RemoteAddr)
+		if owner := s.requestOwner(r); owner != "" {
+			key = "user:" + owner
+		}
+		if ok, wait := limiter.Allow(key); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "rate limit exceeded; retry later"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireWriteRole is requireRole for the requests that change state only.
+func (s *Server) requireWriteRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	guarded := s.requireRole(role, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		guarded(w, r)
+	}
+}
+
+// writeUnauthorized writes a 401 JSON error.
+func (s *Server) writeUnauthorized(w http.ResponseWriter, msg string) {
+	s.metrics.IncHTTPRequestErrors()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// adminEscalated reports whether r carries the configured admin key in its
+// X-Admin-Key header, or an operator token with the admin role. Without an
+// ADMIN_API_KEY or JWT_SECRET no request is escalated.
+func (s *Server) adminEscalated(r *http.Request) bool {
+	if claims, ok := s.tokenClaims(r); ok && auth.HasRole(claims.Role, auth.RoleAdmin) {
+		return true
+	}
+	key := r.Header.Get("X-Admin-Key")
+	if s.config.AdminAPIKey == "" || key == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(s.config.AdminAPIKey)) == 1
+}
+
+// requestOwner returns the authenticated user making r, or "" for anonymous
+// requests and the shared API key.
+func (s *Server) requestOwner(r *http.Request) string {
+	if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+		return claims.Subject
+	}
+	if claims, ok := s.tokenClaims(r); ok {
+		return claims.Subject
+	}
+	return ""
+}
+
 // maxBodySizeMiddleware limits the size of incoming request bodies to prevent
 // abuse. POST/PUT/PATCH methods are limited; GET/DELETE/OPTIONS pass through.
 func (s *Server) maxBodySizeMiddleware(next http.Handler) http.Handler {
@@ -419,9 +577,9 @@ func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
 
 	response := map[string]interface{}{
 		"status":  overallStatus,
-		"version": Version,
-		"commit":  Commit,
-		"build":   BuildTime,
+		"version": version.Version,
+		"commit":  version.Commit,
+		"build":   version.BuildTime,
 		"checks": map[string]interface{}{
 			"storage": map[string]interface{}{
 				"ok":   storageOK,
@@ -489,7 +647,7 @@ func (s *Server) checkBuildersHealth() (online, total int) {
 	}
 	// Also count configured but unregistered remote builders
 	if total == 0 {
-		total = len(s.builder.CloudSettings().RemoteBuilders)
+		total = len(s.builder.RemoteBuilders())
 	}
 	return online, total
 }
@@ -3,12 +3,22 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
+	"github.com/slchris/portage-engine/internal/auth"
 	"github.com/slchris/portage-engine/internal/binpkg"
 	"github.com/slchris/portage-engine/internal/builder"
+	"github.com/slchris/portage-engine/internal/gpg"
+	"github.com/slchris/portage-engine/internal/iac"
 	"github.com/slchris/portage-engine/pkg/config"
 )
 
@@ -162,6 +172,48 @@ func TestHandleBuildRequest(t *testing.T) {
 	}
 }
 
+// TestUnwritableBinpkgStore tests that an unwritable PKGDIR fails startup and
+// is reported as a storage error on submission.
+func TestUnwritableBinpkgStore(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := New(&config.ServerConfig{BinpkgPath: filepath.Join(blocker, "binpkgs")})
+
+	if err := server.Initialize(); err == nil || !strings.Contains(err.Error(), "binpkg store") {
+		t.Errorf("Initialize() error = %v, want a binpkg store error", err)
+	}
+
+	body := []byte(`{"package_name": "app-editors/vim", "arch": "amd64"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/packages/request-build", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	server.handleBuildRequest(w, req)
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("status = %d, want 507", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "not writable") {
+		t.Errorf("body = %q, want a clear storage error", w.Body.String())
+	}
+}
+
+// TestHandleBuildRequestInvalidAtom verifies a malformed atom is rejected
+// with 400 and the reason, before the build is queued.
+func TestHandleBuildRequestInvalidAtom(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir()})
+
+	body, _ := json.Marshal(builder.BuildRequest{PackageName: ">=dev-lang/python", Arch: "amd64"})
+	w := httptest.NewRecorder()
+	server.handleBuildRequest(w, httptest.NewRequest(http.MethodPost, "/api/v1/packages/request-build", bytes.NewReader(body)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "needs a version") {
+		t.Errorf("body = %q, want the atom error", w.Body.String())
+	}
+}
+
 // TestHandleBuildStatus tests the build status endpoint.
 func TestHandleBuildStatus(t *testing.T) {
 	cfg := &config.ServerConfig{
@@ -224,6 +276,16 @@ func TestHandleHeartbeat(t *testing.T) {
 			},
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name:   "builder ID held by another live endpoint",
+			method: http.MethodPost,
+			body: builder.HeartbeatRequest{
+				BuilderID: "builder-1",
+				Status:    "healthy",
+				Endpoint:  "http://localhost:9091",
+			},
+			expectedStatus: http.StatusConflict,
+		},
 		{
 			name:           "method not allowed",
 			method:         http.MethodGet,
@@ -278,6 +340,61 @@ func TestHandleHeartbeat(t *testing.T) {
 }
 
 // TestHandleHeartbeatInvalidJSON tests heartbeat with invalid JSON.
+// TestHandleHeartbeatRecordsVersion verifies the builder's reported version
+// lands in the registry, where the monitor shows it.
+func TestHandleHeartbeatRecordsVersion(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: "/tmp/binpkgs", MaxWorkers: 2})
+
+	for _, v := range []string{"v9.9.0", ""} {
+		body, _ := json.Marshal(builder.HeartbeatRequest{
+			BuilderID: "builder-1",
+			Status:    "online",
+			Endpoint:  "http://localhost:9090",
+			Version:   v,
+		})
+		w := httptest.NewRecorder()
+		server.handleHeartbeat(w, httptest.NewRequest(http.MethodPost, "/api/v1/heartbeat", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("heartbeat status = %d, want 200", w.Code)
+		}
+	}
+
+	// A heartbeat without a version keeps the one already reported.
+	info, ok := server.builderRegistry.Get("builder-1")
+	if !ok || info.Version != "v9.9.0" {
+		t.Errorf("registered version = %+v, want v9.9.0", info)
+	}
+}
+
+// TestHandleHeartbeatTreeFreshness verifies a builder's reported tree sync
+// time reaches the builder list, flagged once it exceeds TREE_STALE_AFTER.
+func TestHandleHeartbeatTreeFreshness(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: "/tmp/binpkgs", MaxWorkers: 2, TreeStaleAfter: 24 * time.Hour})
+
+	body, _ := json.Marshal(builder.HeartbeatRequest{
+		BuilderID:    "builder-1",
+		Status:       "online",
+		Endpoint:     "http://localhost:9090",
+		TreeLastSync: time.Now().Add(-48 * time.Hour),
+		TreeRevision: "0123456789abcdef",
+	})
+	w := httptest.NewRecorder()
+	server.handleHeartbeat(w, httptest.NewRequest(http.MethodPost, "/api/v1/heartbeat", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("heartbeat status = %d, want 200", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleBuildersList(w, httptest.NewRequest(http.MethodGet, "/api/v1/builders", nil))
+	var builders []builder.BuilderInfo
+	if err := json.NewDecoder(w.Body).Decode(&builders); err != nil {
+		t.Fatal(err)
+	}
+	if len(builders) != 1 || !builders[0].TreeStale || builders[0].TreeRevision != "0123456789abcdef" {
+		t.Errorf("builders = %+v, want one stale builder at the reported revision", builders)
+	}
+}
+
 func TestHandleHeartbeatInvalidJSON(t *testing.T) {
 	cfg := &config.ServerConfig{
 		BinpkgPath: "/tmp/binpkgs",
@@ -745,6 +862,42 @@ func TestHandleGPGPublicKey(t *testing.T) {
 	}
 }
 
+// TestHandleGPGPublicKeyFormat tests exporting the configured key file as
+// armored or binary.
+func TestHandleGPGPublicKeyFormat(t *testing.T) {
+	binaryKey := []byte{0x99, 0x00, 0x02, 0x04, 0x01}
+	keyPath := filepath.Join(t.TempDir(), "signing.gpg")
+	if err := os.WriteFile(keyPath, binaryKey, 0644); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	server := New(&config.ServerConfig{
+		BinpkgPath:       "/tmp/binpkgs",
+		GPGEnabled:       true,
+		GPGPublicKeyPath: keyPath,
+	})
+
+	get := func(format string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/gpg/public-key?format="+format, nil)
+		w := httptest.NewRecorder()
+		server.handleGPGPublicKey(w, req)
+		return w
+	}
+
+	if w := get("gpg"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), binaryKey) {
+		t.Errorf("format=gpg: status %d, body %x; want 200, %x", w.Code, w.Body.Bytes(), binaryKey)
+	}
+	w := get("")
+	if w.Code != http.StatusOK || !gpg.IsArmored(w.Body.Bytes()) {
+		t.Errorf("default format: status %d, body %q; want armored key", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, "portage-engine.asc") {
+		t.Errorf("Content-Disposition = %q, want portage-engine.asc", got)
+	}
+	if w := get("pem"); w.Code != http.StatusBadRequest {
+		t.Errorf("format=pem: status %d, want 400", w.Code)
+	}
+}
+
 // TestHandleArtifactInfo tests the artifact info endpoint.
 func TestHandleArtifactInfo(t *testing.T) {
 	cfg := &config.ServerConfig{
@@ -809,6 +962,32 @@ func TestHandleArtifactDownload(t *testing.T) {
 	}
 }
 
+// TestHandleArtifactDownloadRange verifies a Range request reaches the
+// builder and its 206 response is relayed as is.
+func TestHandleArtifactDownloadRange(t *testing.T) {
+	builderSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "jq-1.7.gpkg.tar", time.Unix(0, 0), strings.NewReader("0123456789"))
+	}))
+	defer builderSrv.Close()
+
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), RemoteBuilders: []string{builderSrv.URL}})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/artifacts/download/job-1", nil)
+	req.Header.Set("Range", "bytes=6-")
+	w := httptest.NewRecorder()
+	server.handleArtifactDownload(w, req)
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "6789" {
+		t.Errorf("download = %d %q, want 206 \"6789\"", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 6-9/10" {
+		t.Errorf("Content-Range = %q", got)
+	}
+	if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Accept-Ranges = %q", got)
+	}
+}
+
 // TestGetBuilderURLForJob tests the getBuilderURLForJob helper.
 func TestGetBuilderURLForJob(t *testing.T) {
 	// Test with no builders and no remote builders configured
@@ -851,6 +1030,7 @@ func TestHandleSubmitBuildWithConfig(t *testing.T) {
 			Packages: &builder.BuildPackageSpec{
 				Packages: []builder.PackageSpec{{Atom: "dev-lang/python", Version: "3.11.0"}},
 			},
+			Metadata: builder.BundleMetadata{TargetArch: "amd64"},
 		},
 	}
 	body, err := json.Marshal(req)
@@ -895,6 +1075,58 @@ func TestHandleSubmitBuildWithConfig_RejectsEmptyBundle(t *testing.T) {
 	}
 }
 
+// TestHandleInstancePlan verifies the plan endpoint reports an
+// unconfigured cloud instead of planning.
+func TestHandleInstancePlan(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), MaxWorkers: 1})
+
+	w := httptest.NewRecorder()
+	server.handleInstancePlan(w, httptest.NewRequest(http.MethodGet, "/api/v1/instances/plan", nil))
+	if w.Result().StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET: expected 405, got %d", w.Result().StatusCode)
+	}
+
+	body, _ := json.Marshal(builder.BuildRequest{Arch: "amd64"})
+	w = httptest.NewRecorder()
+	server.handleInstancePlan(w, httptest.NewRequest(http.MethodPost, "/api/v1/instances/plan", bytes.NewReader(body)))
+	if w.Result().StatusCode != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "no cloud provider set") {
+		t.Errorf("no provider: got %d %q", w.Result().StatusCode, w.Body.String())
+	}
+}
+
+// TestHandleExternalInstance verifies registering and deregistering an
+// external builder.
+func TestHandleExternalInstance(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), MaxWorkers: 1})
+
+	w := httptest.NewRecorder()
+	server.handleExternalInstance(w, httptest.NewRequest(http.MethodPost, "/api/v1/instances/external",
+		strings.NewReader(`{"endpoint":"http://buildbox:9090","arch":"amd64"}`)))
+	if w.Result().StatusCode != http.StatusCreated {
+		t.Fatalf("register: expected 201, got %d %q", w.Result().StatusCode, w.Body.String())
+	}
+	var inst iac.Instance
+	if err := json.NewDecoder(w.Body).Decode(&inst); err != nil || inst.Provider != iac.ExternalProvider {
+		t.Fatalf("register: instance %+v, err %v", inst, err)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleExternalInstance(w, httptest.NewRequest(http.MethodPost, "/api/v1/instances/external",
+		strings.NewReader(`{"endpoint":"buildbox"}`)))
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("bad endpoint: expected 400, got %d", w.Result().StatusCode)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleExternalInstance(w, httptest.NewRequest(http.MethodDelete, "/api/v1/instances/external?id="+inst.ID, nil))
+	if w.Result().StatusCode != http.StatusNoContent {
+		t.Errorf("deregister: expected 204, got %d", w.Result().StatusCode)
+	}
+	if len(server.builder.ListInstances()) != 0 {
+		t.Error("deregistered instance is still listed")
+	}
+}
+
 // TestAPIKeyAuthMiddleware verifies the API-key auth layer via the real Router:
 // missing/wrong keys are rejected (constant-time compare), the correct key is
 // accepted, and public endpoints (/health, /binpkgs/) bypass auth.
@@ -936,6 +1168,185 @@ func TestAPIKeyAuthMiddleware(t *testing.T) {
 	if got := do(http.MethodGet, "/binpkgs/Packages", ""); got == http.StatusUnauthorized {
 		t.Errorf("/binpkgs/ should bypass auth, got 401")
 	}
+	// Version endpoint is public like /health.
+	if got := do(http.MethodGet, "/api/v1/version", ""); got != http.StatusOK {
+		t.Errorf("/api/v1/version should bypass auth, got %d", got)
+	}
+}
+
+// TestRequireRole verifies that with JWT_SECRET set, state-changing
+// endpoints need an operator token with the right role (or the API key):
+// 401 without a valid token, 403 with too weak a role. Reads stay open.
+func TestRequireRole(t *testing.T) {
+	cfg := &config.ServerConfig{
+		BinpkgPath: t.TempDir(),
+		MaxWorkers: 1,
+		JWTSecret:  "test-secret-that-is-at-least-32-chars-long",
+	}
+	router := New(cfg).Router()
+
+	do := func(method, path, authorization string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{}`))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Result().StatusCode
+	}
+
+	for _, path := range []string{"/api/v1/packages/request-build", "/api/v1/builds/submit", "/api/v1/builds/multiarch", "/api/v1/keys/rotate", "/api/v1/instances/plan"} {
+		if got := do(http.MethodPost, path, ""); got != http.StatusUnauthorized {
+			t.Errorf("%s without a token: expected 401, got %d", path, got)
+		}
+		if got := do(http.MethodPost, path, "Bearer forged"); got != http.StatusUnauthorized {
+			t.Errorf("%s with a bad token: expected 401, got %d", path, got)
+		}
+	}
+	tokens := map[string]string{}
+	for _, role := range []string{auth.RoleViewer, auth.RoleSubmitter, auth.RoleAdmin} {
+		token, err := auth.SignToken(cfg.JWTSecret, role+"-user", role, time.Now(), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[role] = "Bearer " + token
+	}
+	token := strings.TrimPrefix(tokens[auth.RoleAdmin], "Bearer ")
+
+	tests := []struct {
+		method, path, role string
+		forbidden          bool
+	}{
+		{http.MethodPost, "/api/v1/packages/request-build", auth.RoleViewer, true},
+		{http.MethodPost, "/api/v1/packages/request-build", auth.RoleSubmitter, false},
+		{http.MethodPost, "/api/v1/packages/request-build", auth.RoleAdmin, false},
+		{http.MethodDelete, "/api/v1/builds/delete?job_id=x", auth.RoleViewer, true},
+		{http.MethodPost, "/api/v1/keys/rotate", auth.RoleSubmitter, true},
+		{http.MethodPost, "/api/v1/keys/rotate", auth.RoleAdmin, false},
+		{http.MethodPut, "/api/v1/settings/cloud", auth.RoleSubmitter, true},
+		{http.MethodGet, "/api/v1/settings/cloud", auth.RoleViewer, false},
+		{http.MethodPost, "/api/v1/instances/plan", auth.RoleViewer, true},
+		{http.MethodPost, "/api/v1/instances/plan", auth.RoleSubmitter, true},
+		{http.MethodPost, "/api/v1/instances/plan", auth.RoleAdmin, false},
+	}
+	for _, tt := range tests {
+		got := do(tt.method, tt.path, tokens[tt.role])
+		if tt.forbidden && got != http.StatusForbidden {
+			t.Errorf("%s %s as %s: expected 403, got %d", tt.method, tt.path, tt.role, got)
+		}
+		if !tt.forbidden && (got == http.StatusUnauthorized || got == http.StatusForbidden) {
+			t.Errorf("%s %s as %s: unexpectedly %d", tt.method, tt.path, tt.role, got)
+		}
+	}
+	// With JWT_SECRET set, reads need a token too, even without an API key.
+	if got := do(http.MethodGet, "/api/v1/builds/list", ""); got != http.StatusUnauthorized {
+		t.Errorf("read without a token: expected 401, got %d", got)
+	}
+	if got := do(http.MethodGet, "/api/v1/builds/list", tokens[auth.RoleViewer]); got == http.StatusUnauthorized {
+		t.Error("read with a viewer token: unexpectedly 401")
+	}
+	if got := do(http.MethodGet, "/health", ""); got != http.StatusOK {
+		t.Errorf("health without a token: %d, want 200", got)
+	}
+
+	// With an API key enforced, a token stands in for it.
+	cfg.APIKey = "s3cr3t-key"
+	router = New(cfg).Router()
+	if got := do(http.MethodGet, "/api/v1/builds/list", "Bearer "+token); got == http.StatusUnauthorized {
+		t.Error("token in place of the API key: unexpectedly 401")
+	}
+	if got := do(http.MethodPost, "/api/v1/packages/request-build", "Bearer s3cr3t-key"); got == http.StatusUnauthorized {
+		t.Error("API key on a token-guarded endpoint: unexpectedly 401")
+	}
+}
+
+// TestSubmissionRateLimit verifies build submissions are limited per client
+// IP, or per user with a token, answering 429 with Retry-After.
+func TestSubmissionRateLimit(t *testing.T) {
+	cfg := &config.ServerConfig{
+		BinpkgPath:         t.TempDir(),
+		MaxWorkers:         1,
+		JWTSecret:          "test-secret-that-is-at-least-32-chars-long",
+		APIKey:             "s3cr3t-key",
+		RateLimitPerMinute: 1,
+		RateLimitBurst:     2,
+	}
+	router := New(cfg).Router()
+	token, err := auth.SignToken(cfg.JWTSecret, "alice", auth.RoleSubmitter, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submit := func(remote, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/packages/request-build", strings.NewReader(`{"version":"1.0"}`))
+		req.RemoteAddr = remote
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := submit("192.0.2.1:1234", "Bearer s3cr3t-key"); w.Code == http.StatusTooManyRequests {
+			t.Fatalf("submission %d within the burst limited", i+1)
+		}
+	}
+	w := submit("192.0.2.1:5678", "Bearer s3cr3t-key")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("submission beyond the burst: %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+
+	// Another client IP, and a user from the same IP, have their own budget.
+	if w := submit("192.0.2.2:1234", "Bearer s3cr3t-key"); w.Code == http.StatusTooManyRequests {
+		t.Error("another IP was limited")
+	}
+	if w := submit("192.0.2.1:1234", "Bearer "+token); w.Code == http.StatusTooManyRequests {
+		t.Error("a user was limited by the anonymous budget of their IP")
+	}
+	// Rejected requests do not reach the limiter.
+	for i := 0; i < 3; i++ {
+		if w := submit("192.0.2.3:1234", "Bearer forged"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("forged token: %d, want 401", w.Code)
+		}
+	}
+	if w := submit("192.0.2.3:1234", "Bearer s3cr3t-key"); w.Code == http.StatusTooManyRequests {
+		t.Error("unauthorized attempts used up the budget")
+	}
+}
+
+// TestEphemeralDownloadNeedsAuth verifies the one-time artifact download
+// takes a viewer token and the job's download token before it claims
+// anything.
+func TestEphemeralDownloadNeedsAuth(t *testing.T) {
+	cfg := &config.ServerConfig{
+		BinpkgPath: t.TempDir(),
+		MaxWorkers: 1,
+		JWTSecret:  "test-secret-that-is-at-least-32-chars-long",
+	}
+	router := New(cfg).Router()
+	viewer, err := auth.SignToken(cfg.JWTSecret, "viewer-user", auth.RoleViewer, time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(authorization string) int {
+		req := httptest.NewRequest(http.MethodGet, builder.EphemeralDownloadPath+"?job_id=job", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if got := get(""); got != http.StatusUnauthorized {
+		t.Errorf("anonymous download: %d, want 401", got)
+	}
+	if got := get("Bearer " + viewer); got != http.StatusNotFound {
+		t.Errorf("download without the job's token: %d, want 404", got)
+	}
 }
 
 // TestHandleBuildRequestRejectsEmptyPackage verifies empty package requests are
@@ -949,3 +1360,626 @@ func TestHandleBuildRequestRejectsEmptyPackage(t *testing.T) {
 		t.Errorf("empty package: expected 400, got %d", w.Result().StatusCode)
 	}
 }
+
+// TestHandleBuildStatusByPackage tests looking up builds by atom.
+func TestHandleBuildStatusByPackage(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), MaxWorkers: 1})
+	now := time.Now()
+	server.builder.LoadJobs(map[string]*builder.BuildStatus{
+		"old": {JobID: "old", Status: "failed", PackageName: "dev-lang/python", Version: "3.11", Arch: "amd64", CreatedAt: now.Add(-time.Hour)},
+		"new": {JobID: "new", Status: "success", PackageName: "dev-lang/python", Version: "3.11", Arch: "amd64", CreatedAt: now},
+	})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.handleBuildStatusByPackage(w, httptest.NewRequest(http.MethodGet, "/api/v1/builds/status-by-package?"+query, nil))
+		return w
+	}
+
+	w := get("atom=dev-lang/python&version=3.11&arch=amd64&all=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Latest builder.BuildStatus   `json:"latest"`
+		Count  int                   `json:"count"`
+		Jobs   []builder.BuildStatus `json:"jobs"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Latest.JobID != "new" || resp.Count != 2 || len(resp.Jobs) != 2 {
+		t.Errorf("response = %+v, want latest new with 2 jobs", resp)
+	}
+
+	if w := get("atom=dev-lang/python"); strings.Contains(w.Body.String(), `"jobs"`) {
+		t.Errorf("jobs listed without all=true: %s", w.Body.String())
+	}
+	if w := get("atom=dev-lang/ruby"); w.Code != http.StatusNotFound {
+		t.Errorf("no match: status = %d, want 404", w.Code)
+	}
+	if w := get("version=3.11"); w.Code != http.StatusBadRequest {
+		t.Errorf("missing atom: status = %d, want 400", w.Code)
+	}
+}
+
+func TestHandleBuildsListPagination(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), MaxWorkers: 1})
+	now := time.Now()
+	server.builder.LoadJobs(map[string]*builder.BuildStatus{
+		"a": {JobID: "a", Status: "failed", PackageName: "dev-lang/python", Arch: "amd64", CreatedAt: now.Add(-3 * time.Hour)},
+		"b": {JobID: "b", Status: "success", PackageName: "dev-lang/python", Arch: "arm64", CreatedAt: now.Add(-2 * time.Hour)},
+		"c": {JobID: "c", Status: "cancelled", PackageName: "app-misc/jq", Arch: "amd64", CreatedAt: now.Add(-time.Hour)},
+		"d": {JobID: "d", Status: "success", PackageName: "app-misc/jq", Arch: "amd64", CreatedAt: now},
+	})
+
+	list := func(query string) (ids []string, page builder.BuildPage) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.handleBuildsList(w, httptest.NewRequest(http.MethodGet, "/api/v1/builds/list?"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", query, w.Code, w.Body.String())
+		}
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatalf("%s: decode: %v", query, err)
+		}
+		for _, b := range page.Builds {
+			ids = append(ids, b.JobID)
+		}
+		return ids, page
+	}
+
+	tests := []struct {
+		query string
+		want  string
+		total int
+	}{
+		{"", "d,c,b,a", 4},
+		{"limit=2&offset=1", "c,b", 4},
+		{"status=success,failed", "d,b,a", 3},
+		{"package=python&arch=arm64", "b", 1},
+		{"package=jq&limit=1&offset=1", "c", 2},
+		{"sort=status", "c,a,d,b", 4},
+		{"sort=created_at&order=asc&limit=3", "a,b,c", 4},
+	}
+	for _, tt := range tests {
+		ids, page := list(tt.query)
+		if strings.Join(ids, ",") != tt.want || page.Total != tt.total {
+			t.Errorf("%q: builds %v total %d, want %s total %d", tt.query, ids, page.Total, tt.want, tt.total)
+		}
+	}
+	if _, page := list("limit=500&offset=-3"); page.Limit != 200 || page.Offset != 0 {
+		t.Errorf("limit/offset not clamped: %+v", page)
+	}
+	w := httptest.NewRecorder()
+	server.handleBuildsList(w, httptest.NewRequest(http.MethodGet, "/api/v1/builds/list?sort=arch", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown sort key: status = %d, want 400", w.Code)
+	}
+}
+
+// TestHandleMultiArchBuild tests submitting a multi-arch request and reading
+// back its per-arch jobs.
+func TestHandleMultiArchBuild(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), MaxWorkers: 1})
+
+	body := `{"package_name":"app-misc/jq","version":"1.7","arches":["amd64","arm64"]}`
+	w := httptest.NewRecorder()
+	server.handleMultiArchSubmit(w, httptest.NewRequest(http.MethodPost, "/api/v1/builds/multiarch", strings.NewReader(body)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("submit status = %d, want 202: %s", w.Code, w.Body.String())
+	}
+	var submitted struct {
+		ID   string            `json:"id"`
+		Jobs map[string]string `json:"jobs"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&submitted); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if submitted.ID == "" || len(submitted.Jobs) != 2 {
+		t.Fatalf("submit response = %+v, want an ID and 2 jobs", submitted)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleMultiArchStatus(w, httptest.NewRequest(http.MethodGet, "/api/v1/builds/multiarch/"+submitted.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body.String())
+	}
+	var status builder.MultiArchStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if status.ID != submitted.ID || len(status.Jobs) != 2 {
+		t.Errorf("multi-arch status = %+v", status)
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodPost, "/api/v1/builds/multiarch", `{"package_name":"app-misc/jq"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/builds/multiarch", `{"arches":["amd64"]}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/builds/multiarch", `{"package_name":"app-misc/jq","arches":["~amd64"]}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/builds/multiarch", `{"package_name":"app-misc/jq","arches":["**"]}`, http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/builds/multiarch/unknown", "", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/builds/multiarch/", "", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.method == http.MethodPost {
+			server.handleMultiArchSubmit(w, r)
+		} else {
+			server.handleMultiArchStatus(w, r)
+		}
+		if w.Code != tc.want {
+			t.Errorf("%s %s %s: status %d, want %d", tc.method, tc.path, tc.body, w.Code, tc.want)
+		}
+	}
+}
+
+func TestAdminEscalated(t *testing.T) {
+	tests := []struct {
+		name     string
+		adminKey string
+		header   string
+		want     bool
+	}{
+		{"no admin key configured", "", "anything", false},
+		{"missing header", "secret", "", false},
+		{"wrong key", "secret", "guess", false},
+		{"matching key", "secret", "secret", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), AdminAPIKey: tt.adminKey})
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/packages/request-build", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Admin-Key", tt.header)
+			}
+			if got := server.adminEscalated(req); got != tt.want {
+				t.Errorf("adminEscalated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleEphemeralArtifactNotHeld(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir()})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/builds/artifact?job_id=missing", nil)
+	w := httptest.NewRecorder()
+	server.handleEphemeralArtifact(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/builds/artifact", nil)
+	w = httptest.NewRecorder()
+	server.handleEphemeralArtifact(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status without job_id = %d, want 400", w.Code)
+	}
+}
+
+// TestClusterStatusETag verifies polling the cluster status revalidates to a
+// 304 while nothing changed, although last_updated moves on every call.
+func TestClusterStatusETag(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir()})
+	router := server.Router()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/cluster/status", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first request: status %d, ETag %q", w.Code, etag)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/cluster/status", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("unchanged status: got %d with %d bytes, want an empty 304", w.Code, w.Body.Len())
+	}
+}
+
+// newVerifyTestSigner returns an enabled signer with a fresh key in its own
+// GNUPGHOME, skipping the test when gpg is not installed.
+func newVerifyTestSigner(t *testing.T) *gpg.Signer {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	// Not t.TempDir(): gpg-agent's socket path must stay short.
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+		_ = os.RemoveAll(home)
+	})
+	gen := exec.Command("gpg", "--batch", "--homedir", home, "--passphrase", "",
+		"--quick-gen-key", "Server <server@example.com>", "ed25519", "sign", "never")
+	if out, err := gen.CombinedOutput(); err != nil {
+		t.Fatalf("gpg --quick-gen-key: %v\n%s", err, out)
+	}
+	out, err := exec.Command("gpg", "--batch", "--homedir", home, "--with-colons", "--list-keys").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" {
+			return gpg.NewSigner(fields[9], "", true, gpg.WithGnupgHome(home))
+		}
+	}
+	t.Fatal("no fingerprint for the generated key")
+	return nil
+}
+
+// TestHandleArtifactVerify verifies job artifacts and uploaded packages
+// against the server's key.
+func TestHandleArtifactVerify(t *testing.T) {
+	disabled := New(&config.ServerConfig{BinpkgPath: t.TempDir()})
+	w := httptest.NewRecorder()
+	disabled.handleArtifactVerify(w, httptest.NewRequest(http.MethodPost, "/api/v1/artifacts/verify", strings.NewReader(`{"job_id":"job-1"}`)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("signing disabled: status %d, want 503", w.Code)
+	}
+
+	signer := newVerifyTestSigner(t)
+	dir := t.TempDir()
+	pkg := filepath.Join(dir, "jq-1.7.1-1.gpkg.tar")
+	if err := os.WriteFile(pkg, []byte("package contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.SignPackage(pkg); err != nil {
+		t.Fatal(err)
+	}
+
+	served := map[string]string{
+		"app-misc/jq-1.7.1-1.gpkg.tar":     pkg,
+		"app-misc/jq-1.7.1-1.gpkg.tar.sig": pkg + gpg.SignatureFileExt,
+	}
+	builderSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/jobs/job-1":
+			writeJSON(w, map[string]any{
+				"id":           "job-1",
+				"artifact_url": "/var/lib/portage-engine/artifacts/app-misc/jq-1.7.1-1.gpkg.tar",
+				"artifacts":    []string{"app-misc/jq-1.7.1-1.gpkg.tar"},
+				"metadata": map[string]any{
+					"signed":     true,
+					"signatures": map[string]string{"app-misc/jq-1.7.1-1.gpkg.tar": "app-misc/jq-1.7.1-1.gpkg.tar.sig"},
+				},
+			})
+		case "/api/v1/artifacts/download/job-1":
+			if path, ok := served[r.URL.Query().Get("path")]; ok {
+				http.ServeFile(w, r, path)
+				return
+			}
+			http.NotFound(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer builderSrv.Close()
+
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), RemoteBuilders: []string{builderSrv.URL}})
+	server.gpgSigner = signer
+	verify := func(req *http.Request) (int, gpg.VerifyResult) {
+		w := httptest.NewRecorder()
+		server.handleArtifactVerify(w, req)
+		var result gpg.VerifyResult
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("decode %s: %v", w.Body.String(), err)
+			}
+		}
+		return w.Code, result
+	}
+	verifyJSON := func(body string) (int, gpg.VerifyResult) {
+		return verify(httptest.NewRequest(http.MethodPost, "/api/v1/artifacts/verify", strings.NewReader(body)))
+	}
+
+	if code, r := verifyJSON(`{"job_id":"job-1"}`); code != http.StatusOK || !r.Valid || r.KeyID == "" || r.SignedAt == "" {
+		t.Errorf("job artifact: %d %+v, want valid", code, r)
+	}
+	if code, _ := verifyJSON(`{"job_id":"job-1","path":"app-misc/other-1.0.gpkg.tar"}`); code != http.StatusNotFound {
+		t.Errorf("unknown artifact: status %d, want 404", code)
+	}
+	if code, _ := verifyJSON(`{}`); code != http.StatusBadRequest {
+		t.Errorf("no job_id: status %d, want 400", code)
+	}
+
+	upload := func(contents string) (int, gpg.VerifyResult) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", "jq-1.7.1-1.gpkg.tar")
+		_, _ = fw.Write([]byte(contents))
+		sig, err := os.ReadFile(pkg + gpg.SignatureFileExt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sw, _ := mw.CreateFormFile("signature", "jq-1.7.1-1.gpkg.tar.sig")
+		_, _ = sw.Write(sig)
+		_ = mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/artifacts/verify", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return verify(req)
+	}
+	if code, r := upload("package contents"); code != http.StatusOK || !r.Valid {
+		t.Errorf("upload: %d %+v, want valid", code, r)
+	}
+	if code, r := upload("tampered contents"); code != http.StatusOK || r.Valid || r.Error == "" {
+		t.Errorf("tampered upload: %d %+v, want invalid", code, r)
+	}
+}
+
+// TestHandleKeysRotate verifies rotation is admin-only, swaps the signing
+// key and records both keys in the history.
+func TestHandleKeysRotate(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), DataDir: t.TempDir(), AdminAPIKey: "admin-secret"})
+	rotate := func(adminKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/keys/rotate", nil)
+		if adminKey != "" {
+			req.Header.Set("X-Admin-Key", adminKey)
+		}
+		w := httptest.NewRecorder()
+		server.handleKeysRotate(w, req)
+		return w
+	}
+	if w := rotate(""); w.Code != http.StatusForbidden {
+		t.Errorf("without admin key: status %d, want 403", w.Code)
+	}
+	if w := rotate("admin-secret"); w.Code != http.StatusConflict {
+		t.Errorf("signing disabled: status %d, want 409", w.Code)
+	}
+
+	old := newVerifyTestSigner(t)
+	server.gpgSigner = old
+	server.adoptKeyHistory(old)
+
+	w := rotate("admin-secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("rotate: status %d: %s", w.Code, w.Body.String())
+	}
+	var record gpg.KeyRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.PreviousKeyID != old.KeyID() || record.Succession == "" {
+		t.Errorf("rotation record = %+v", record)
+	}
+	if !strings.HasSuffix(record.KeyID, server.gpgSigner.KeyID()) {
+		t.Errorf("signer uses %s after rotation, want %s", server.gpgSigner.KeyID(), record.KeyID)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleKeysHistory(w, httptest.NewRequest(http.MethodGet, "/api/v1/keys/history", nil))
+	var history struct {
+		Keys []gpg.KeyRecord `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Keys) != 2 || history.Keys[0].KeyID != old.KeyID() || history.Keys[0].ValidUntil == nil || !history.Keys[1].Current {
+		t.Errorf("history = %+v", history.Keys)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleKeysPublic(w, httptest.NewRequest(http.MethodGet, "/api/v1/keys/public", nil))
+	if w.Code != http.StatusOK || w.Body.String() != record.PublicKey {
+		t.Errorf("current public key: %d, want the rotated key", w.Code)
+	}
+	w = httptest.NewRecorder()
+	server.handleKeysPublic(w, httptest.NewRequest(http.MethodGet, "/api/v1/keys/public?key_id="+old.KeyID(), nil))
+	if w.Code != http.StatusOK || w.Body.String() != history.Keys[0].PublicKey {
+		t.Errorf("archived public key: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	server.handleKeysPublic(w, httptest.NewRequest(http.MethodGet, "/api/v1/keys/public?key_id=DEADBEEF", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown key: status %d, want 404", w.Code)
+	}
+}
+
+// TestHandleArtifactSearch verifies a search is answered from every
+// builder, merged newest first, with unreachable builders listed.
+func TestHandleArtifactSearch(t *testing.T) {
+	builderAt := func(jobID string, built time.Time) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v1/artifacts/search" || r.URL.Query().Get("name") != "dev-lang/python" || r.URL.Query().Get("use") != "ssl" {
+				http.Error(w, "unexpected query "+r.URL.String(), http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"artifacts": []map[string]interface{}{
+				{"path": "dev-lang/python/python-3.12.7-1.gpkg.tar", "name": "dev-lang/python", "job_id": jobID, "built_at": built},
+			}})
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	older := builderAt("old", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+	newer := builderAt("new", time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC))
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), RemoteBuilders: []string{older.URL, newer.URL, down.URL}})
+	w := httptest.NewRecorder()
+	server.handleArtifactSearch(w, httptest.NewRequest(http.MethodGet, "/api/v1/artifacts/search?name=dev-lang/python&use=ssl", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("search = %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Artifacts []struct {
+			JobID   string `json:"job_id"`
+			Builder string `json:"builder"`
+		} `json:"artifacts"`
+		Count       int      `json:"count"`
+		Unreachable []string `json:"unreachable"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Count != 2 || resp.Artifacts[0].JobID != "new" || resp.Artifacts[0].Builder != newer.URL || resp.Artifacts[1].JobID != "old" {
+		t.Errorf("artifacts = %+v, want the newer build first", resp.Artifacts)
+	}
+	if len(resp.Unreachable) != 1 || resp.Unreachable[0] != down.URL {
+		t.Errorf("unreachable = %v", resp.Unreachable)
+	}
+
+	w = httptest.NewRecorder()
+	server.handleArtifactSearch(w, httptest.NewRequest(http.MethodGet, "/api/v1/artifacts/search?limit=x", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid limit = %d, want 400", w.Code)
+	}
+}
+
+// TestHandleBuildersWS tests the builders WebSocket sends a snapshot and
+// then the registry changes.
+func TestHandleBuildersWS(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir()})
+	_ = server.builderRegistry.Register(&builder.BuilderInfo{ID: "builder-1", Status: "online"})
+
+	ts := httptest.NewServer(http.HandlerFunc(server.handleBuildersWS))
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var ev builder.BuilderEvent
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	if ev.Type != builder.BuilderEventSnapshot || len(ev.Builders) != 1 || ev.Builders[0].ID != "builder-1" {
+		t.Fatalf("first event = %+v, want a snapshot of builder-1", ev)
+	}
+
+	_ = server.builderRegistry.UpdateStatus("builder-1", "busy")
+	ev = builder.BuilderEvent{}
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	if ev.Type != builder.BuilderEventUpdate || ev.Builder == nil || ev.Builder.Status != "busy" {
+		t.Errorf("event = %+v, want builder-1 busy", ev)
+	}
+
+	server.builderRegistry.Unregister("builder-1")
+	ev = builder.BuilderEvent{}
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	if ev.Type != builder.BuilderEventRemove || ev.ID != "builder-1" {
+		t.Errorf("event = %+v, want a remove of builder-1", ev)
+	}
+}
+
+// TestHandleBuilderAdmin tests disabling, enabling and deregistering a
+// builder, and that the registry survives a restart.
+func TestHandleBuilderAdmin(t *testing.T) {
+	dataDir := t.TempDir()
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), DataDir: dataDir})
+	if err := server.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	router := server.Router()
+	do := func(method, path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+	_ = server.builderRegistry.Register(&builder.BuilderInfo{ID: "builder-1", Endpoint: "http://b1:9090", Status: "online"})
+	_ = server.builderRegistry.Register(&builder.BuilderInfo{ID: "builder-2", Endpoint: "http://b2:9090", Status: "online"})
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/api/v1/builders/missing/disable", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/builders/builder-1/disable", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/v1/builders/builder-1/reboot", http.StatusNotFound},
+		{http.MethodDelete, "/api/v1/builders/missing", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/builders/builder-1", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/v1/builders/builder-1/disable", http.StatusOK},
+		{http.MethodPost, "/api/v1/builders/builder-2/disable", http.StatusOK},
+		{http.MethodPost, "/api/v1/builders/builder-2/enable", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := do(tt.method, tt.path); got != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, got, tt.want)
+		}
+	}
+	if b, _ := server.builderRegistry.Get("builder-1"); b.Enabled {
+		t.Error("builder-1 still enabled after disable")
+	}
+	if b, _ := server.builderRegistry.Get("builder-2"); !b.Enabled {
+		t.Error("builder-2 still disabled after enable")
+	}
+
+	// A heartbeat does not re-enable a disabled builder.
+	_ = server.builderRegistry.Register(&builder.BuilderInfo{ID: "builder-1", Endpoint: "http://b1:9090", Status: "online"})
+	if b, _ := server.builderRegistry.Get("builder-1"); b.Enabled {
+		t.Error("builder-1 re-enabled by a heartbeat")
+	}
+
+	if got := do(http.MethodDelete, "/api/v1/builders/builder-2"); got != http.StatusOK {
+		t.Errorf("DELETE builder-2 = %d, want 200", got)
+	}
+	if _, ok := server.builderRegistry.Get("builder-2"); ok {
+		t.Error("builder-2 still registered after deregistration")
+	}
+	server.Shutdown()
+
+	restarted := New(&config.ServerConfig{BinpkgPath: t.TempDir(), DataDir: dataDir})
+	if err := restarted.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Shutdown()
+	b, ok := restarted.builderRegistry.Get("builder-1")
+	if !ok || b.Enabled || b.Status != "offline" {
+		t.Errorf("builder-1 after restart = %+v, want it offline and disabled", b)
+	}
+	if _, ok := restarted.builderRegistry.Get("builder-2"); ok {
+		t.Error("builder-2 back after restart")
+	}
+}
+
+// TestHandlePackageStats tests the package build statistics endpoint.
+func TestHandlePackageStats(t *testing.T) {
+	server := New(&config.ServerConfig{BinpkgPath: t.TempDir(), BuildStatsWindow: 24 * time.Hour})
+	router := server.Router()
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/v1/packages/app-misc/jq/stats", http.StatusOK},
+		{http.MethodGet, "/api/v1/packages/app-misc/jq/stats?arch=amd64&window=1h", http.StatusOK},
+		{http.MethodGet, "/api/v1/packages/app-misc/jq/stats?window=soon", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/packages/jq/stats", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/packages/app-misc/jq", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/packages/app-misc/jq/stats", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/packages/app-misc/jq/stats?window=1h", nil))
+	var stats builder.PackageBuildStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if stats.Package != "app-misc/jq" || stats.Builds != 0 || stats.WindowSeconds != 3600 {
+		t.Errorf("stats = %+v, want no builds of app-misc/jq over 1h", stats)
+	}
+}
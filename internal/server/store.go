@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/slchris/portage-engine/internal/builder"
+	"github.com/slchris/portage-engine/internal/version"
 )
 
 // ServerStore provides persistent storage for server-side build status.
@@ -18,9 +19,10 @@ import (
 //
 //nolint:revive // ServerStore/ServerPersister are the established names across the package.
 type ServerStore struct {
-	dataDir  string
-	filename string
-	mu       sync.RWMutex
+	dataDir      string
+	filename     string
+	buildersFile string
+	mu           sync.RWMutex
 }
 
 // persistedState represents the full server state saved to disk.
@@ -30,6 +32,13 @@ type persistedState struct {
 	Version   string                          `json:"version"`
 }
 
+// persistedBuilders represents the builder registry saved to disk.
+type persistedBuilders struct {
+	Builders  []*builder.BuilderInfo `json:"builders"`
+	UpdatedAt time.Time              `json:"updated_at"`
+	Version   string                 `json:"version"`
+}
+
 // NewServerStore creates a new server store at the given directory.
 // The directory is created if it doesn't exist.
 func NewServerStore(dataDir string) (*ServerStore, error) {
@@ -49,8 +58,9 @@ func NewServerStore(dataDir string) (*ServerStore, error) {
 	_ = os.Remove(testFile)
 
 	return &ServerStore{
-		dataDir:  dataDir,
-		filename: filepath.Join(dataDir, "server_jobs.json"),
+		dataDir:      dataDir,
+		filename:     filepath.Join(dataDir, "server_jobs.json"),
+		buildersFile: filepath.Join(dataDir, "builders.json"),
 	}, nil
 }
 
@@ -108,7 +118,7 @@ func (s *ServerStore) SaveJobs(jobs map[string]*builder.BuildStatus) error {
 	state := persistedState{
 		Jobs:      jobs,
 		UpdatedAt: time.Now(),
-		Version:   Version,
+		Version:   version.Version,
 	}
 
 	data, err := json.MarshalIndent(state, "", "  ")
@@ -130,6 +140,52 @@ func (s *ServerStore) SaveJobs(jobs map[string]*builder.BuildStatus) error {
 	return nil
 }
 
+// LoadBuilders loads the persisted builder registry from disk.
+// Returns nil if the file doesn't exist (first run).
+func (s *ServerStore) LoadBuilders() ([]*builder.BuilderInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := os.ReadFile(s.buildersFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read builders file: %w", err)
+	}
+	var state persistedBuilders
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse builders file: %w", err)
+	}
+	return state.Builders, nil
+}
+
+// SaveBuilders saves the builder registry to disk atomically.
+func (s *ServerStore) SaveBuilders(builders []*builder.BuilderInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := persistedBuilders{
+		Builders:  builders,
+		UpdatedAt: time.Now(),
+		Version:   version.Version,
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal builders: %w", err)
+	}
+
+	tempFile := s.buildersFile + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := os.Rename(tempFile, s.buildersFile); err != nil {
+		_ = os.Remove(tempFile)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
 // CleanOldJobs removes completed/failed jobs older than maxAge.
 // In-progress jobs (queued, building, provisioning, forwarding) are always kept.
 func (s *ServerStore) CleanOldJobs(jobs map[string]*builder.BuildStatus, maxAge time.Duration) (map[string]*builder.BuildStatus, int) {
@@ -147,3 +147,30 @@ func TestServerPersister(t *testing.T) {
 		t.Errorf("Expected dev-libs/openssl, got %s", loaded["test-job"].PackageName)
 	}
 }
+
+func TestServerStoreBuilders(t *testing.T) {
+	store, err := NewServerStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create server store: %v", err)
+	}
+
+	builders, err := store.LoadBuilders()
+	if err != nil || builders != nil {
+		t.Fatalf("LoadBuilders() on an empty store = %v, %v, want nothing", builders, err)
+	}
+
+	saved := []*builder.BuilderInfo{
+		{ID: "builder-1", Endpoint: "http://b1:9090", Enabled: true},
+		{ID: "builder-2", Endpoint: "http://b2:9090", Enabled: false},
+	}
+	if err := store.SaveBuilders(saved); err != nil {
+		t.Fatalf("SaveBuilders failed: %v", err)
+	}
+	builders, err = store.LoadBuilders()
+	if err != nil {
+		t.Fatalf("LoadBuilders failed: %v", err)
+	}
+	if len(builders) != 2 || builders[1].ID != "builder-2" || builders[1].Enabled {
+		t.Errorf("LoadBuilders() = %+v, want both builders with builder-2 disabled", builders)
+	}
+}
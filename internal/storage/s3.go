@@ -1,46 +1,566 @@
 package storage
 
-import "fmt"
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
 
-// S3Storage implements Storage interface for AWS S3. The backend is not yet
-// implemented; the fields are placeholders for a future implementation.
-type S3Storage struct{}
+const (
+	// DefaultS3MultipartThreshold is the artifact size from which uploads use
+	// S3 multipart upload instead of a single PUT.
+	DefaultS3MultipartThreshold int64 = 100 << 20
+	// DefaultS3UploadConcurrency is the number of parts uploaded in parallel.
+	DefaultS3UploadConcurrency = 4
 
-// NewS3Storage creates a new S3 storage backend.
-//
-// The S3 backend is not yet implemented, so the constructor fails immediately.
-// This surfaces a misconfiguration (STORAGE_TYPE=s3) at startup rather than
-// letting every artifact upload fail silently at runtime.
-func NewS3Storage(_, _, _ string) (*S3Storage, error) {
-	return nil, fmt.Errorf("S3 storage backend is not yet implemented (STORAGE_TYPE=s3); use STORAGE_TYPE=local")
+	// s3PartSize is the size of a multipart upload part; S3 wants at least
+	// 5 MiB for every part but the last, and at most 10000 parts.
+	s3PartSize int64 = 16 << 20
+	s3MaxParts int64 = 10000
+
+	// Bodies are streamed from disk rather than hashed up front; the
+	// connection's TLS protects them in transit.
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3TimeFormat      = "20060102T150405Z"
+)
+
+// S3Options tunes the S3 backend. Zero values select the defaults.
+type S3Options struct {
+	// Endpoint is the URL of an S3-compatible service (e.g. MinIO), addressed
+	// path-style. Empty uses AWS's regional endpoint for the bucket.
+	Endpoint string
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials; empty
+	// ones are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+	// AWS_SESSION_TOKEN.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// MultipartThreshold is the file size from which Upload switches to a
+	// multipart upload.
+	MultipartThreshold int64
+	// UploadConcurrency is the number of parts of a multipart upload sent at
+	// once.
+	UploadConcurrency int
+}
+
+// S3Storage implements Storage interface for AWS S3 and S3-compatible
+// services, speaking the REST API with Signature Version 4.
+type S3Storage struct {
+	bucket   string
+	region   string
+	prefix   string
+	endpoint *url.URL // nil for AWS
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	multipartThreshold int64
+	partSize           int64
+	concurrency        int
+
+	client *http.Client
+}
+
+// NewS3Storage creates a new S3 storage backend. It fails when the bucket or
+// the credentials are missing, so a STORAGE_TYPE=s3 misconfiguration is
+// caught at startup rather than on the first artifact upload.
+func NewS3Storage(bucket, region, prefix string, opts S3Options) (*S3Storage, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("S3 bucket not configured")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	s := &S3Storage{
+		bucket:             bucket,
+		region:             region,
+		prefix:             strings.Trim(prefix, "/"),
+		accessKey:          envFallback(opts.AccessKeyID, "AWS_ACCESS_KEY_ID"),
+		secretKey:          envFallback(opts.SecretAccessKey, "AWS_SECRET_ACCESS_KEY"),
+		sessionToken:       envFallback(opts.SessionToken, "AWS_SESSION_TOKEN"),
+		multipartThreshold: opts.MultipartThreshold,
+		partSize:           s3PartSize,
+		concurrency:        opts.UploadConcurrency,
+		client:             &http.Client{},
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("S3 credentials not configured: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if opts.Endpoint != "" {
+		u, err := url.Parse(strings.TrimRight(opts.Endpoint, "/"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid S3 endpoint %q", opts.Endpoint)
+		}
+		s.endpoint = u
+	}
+	if s.multipartThreshold <= 0 {
+		s.multipartThreshold = DefaultS3MultipartThreshold
+	}
+	if s.concurrency <= 0 {
+		s.concurrency = DefaultS3UploadConcurrency
+	}
+	return s, nil
+}
+
+// envFallback returns val, or the environment variable key when val is empty.
+func envFallback(val, key string) string {
+	if val != "" {
+		return val
+	}
+	return os.Getenv(key)
+}
+
+// Upload uploads a file to S3: with a single PUT, or as a multipart upload
+// once the file reaches the multipart threshold.
+func (s *S3Storage) Upload(localPath, remotePath string) error {
+	f, err := os.Open(localPath) // #nosec G304 -- an artifact handed to us by the builder.
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat source file: %w", err)
+	}
+
+	key := s.key(remotePath)
+	if info.Size() >= s.multipartThreshold {
+		return s.uploadMultipart(f, key, info.Size())
+	}
+
+	var body io.Reader
+	if info.Size() > 0 {
+		body = f
+	}
+	resp, err := s.do(context.Background(), http.MethodPut, key, nil, body, info.Size())
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return s3ResponseError("upload", key, resp)
+	}
+	return nil
+}
+
+// completedPart is one uploaded part, as listed when completing a multipart
+// upload.
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// uploadMultipart uploads f in parts, s.concurrency at a time. On any failure
+// the multipart upload is aborted, so S3 does not keep (and bill for) the
+// parts already stored.
+func (s *S3Storage) uploadMultipart(f *os.File, key string, size int64) error {
+	uploadID, err := s.createMultipartUpload(key)
+	if err != nil {
+		return err
+	}
+	parts, err := s.uploadParts(f, key, uploadID, size)
+	if err == nil {
+		err = s.completeMultipartUpload(key, uploadID, parts)
+	}
+	if err != nil {
+		if abortErr := s.abortMultipartUpload(key, uploadID); abortErr != nil {
+			return fmt.Errorf("%w (and aborting the multipart upload failed: %v)", err, abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *S3Storage) createMultipartUpload(key string) (string, error) {
+	resp, err := s.do(context.Background(), http.MethodPost, key, map[string]string{"uploads": ""}, nil, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload of %s: %w", key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", s3ResponseError("start multipart upload of", key, resp)
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("failed to start multipart upload of %s: no upload ID in response", key)
+	}
+	return result.UploadID, nil
+}
+
+// uploadParts uploads every part of f and returns them in order. The first
+// failure cancels the parts still in flight.
+func (s *S3Storage) uploadParts(f *os.File, key, uploadID string, size int64) ([]completedPart, error) {
+	partSize := s.partSize
+	if minSize := (size + s3MaxParts - 1) / s3MaxParts; minSize > partSize {
+		partSize = minSize
+	}
+	count := int((size + partSize - 1) / partSize)
+	parts := make([]completedPart, count)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	next := make(chan int)
+	for w := 0; w < min(s.concurrency, count); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				offset := int64(i) * partSize
+				n := min(partSize, size-offset)
+				etag, err := s.uploadPart(ctx, key, uploadID, i+1, io.NewSectionReader(f, offset, n), n)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+					continue
+				}
+				parts[i] = completedPart{PartNumber: i + 1, ETag: etag}
+			}
+		}()
+	}
+feed:
+	for i := 0; i < count; i++ {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return parts, nil
+}
+
+func (s *S3Storage) uploadPart(ctx context.Context, key, uploadID string, number int, body io.Reader, size int64) (string, error) {
+	query := map[string]string{"partNumber": fmt.Sprint(number), "uploadId": uploadID}
+	resp, err := s.do(ctx, http.MethodPut, key, query, body, size)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d of %s: %w", number, key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", s3ResponseError(fmt.Sprintf("upload part %d of", number), key, resp)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("failed to upload part %d of %s: no ETag in response", number, key)
+	}
+	return etag, nil
+}
+
+func (s *S3Storage) completeMultipartUpload(key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("failed to encode multipart completion: %w", err)
+	}
+	resp, err := s.do(context.Background(), http.MethodPost, key, map[string]string{"uploadId": uploadID}, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload of %s: %w", key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, _ := io.ReadAll(resp.Body)
+	// S3 may answer 200 and still report a failure in the body.
+	if resp.StatusCode != http.StatusOK || bytes.Contains(data, []byte("<Error>")) {
+		return fmt.Errorf("failed to complete multipart upload of %s: %s", key, s3ErrorMessage(resp.Status, data))
+	}
+	return nil
 }
 
-// Upload uploads a file to S3.
-func (s *S3Storage) Upload(_, _ string) error {
-	return fmt.Errorf("S3 storage not yet implemented")
+func (s *S3Storage) abortMultipartUpload(key, uploadID string) error {
+	resp, err := s.do(context.Background(), http.MethodDelete, key, map[string]string{"uploadId": uploadID}, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3ResponseError("abort multipart upload of", key, resp)
+	}
+	return nil
 }
 
 // Download downloads a file from S3.
-func (s *S3Storage) Download(_, _ string) error {
-	return fmt.Errorf("S3 storage not yet implemented")
+func (s *S3Storage) Download(remotePath, localPath string) error {
+	key := s.key(remotePath)
+	resp, err := s.do(context.Background(), http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return s3ResponseError("download", key, resp)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0750); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	dst, err := os.Create(localPath) // #nosec G304 -- caller-chosen destination.
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer func() { _ = dst.Close() }()
+	if _, err := io.Copy(dst, resp.Body); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return nil
 }
 
 // Delete removes a file from S3.
-func (s *S3Storage) Delete(_ string) error {
-	return fmt.Errorf("S3 storage not yet implemented")
+func (s *S3Storage) Delete(remotePath string) error {
+	key := s.key(remotePath)
+	resp, err := s.do(context.Background(), http.MethodDelete, key, nil, nil, 0)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3ResponseError("delete", key, resp)
+	}
+	return nil
 }
 
-// List lists files in S3.
-func (s *S3Storage) List(_ string) ([]string, error) {
-	return nil, fmt.Errorf("S3 storage not yet implemented")
+// List lists files in S3 under prefix, relative to the storage prefix.
+func (s *S3Storage) List(prefix string) ([]string, error) {
+	listPrefix := s.key(prefix)
+	if prefix == "" && s.prefix != "" {
+		listPrefix += "/"
+	}
+
+	var files []string
+	token := ""
+	for {
+		query := map[string]string{"list-type": "2", "prefix": listPrefix}
+		if token != "" {
+			query["continuation-token"] = token
+		}
+		resp, err := s.do(context.Background(), http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", listPrefix, err)
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = s3ResponseError("list", listPrefix, resp)
+		} else {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			files = append(files, strings.TrimPrefix(strings.TrimPrefix(c.Key, s.prefix), "/"))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
 }
 
-// GetURL returns the URL for a file.
-func (s *S3Storage) GetURL(_ string) (string, error) {
-	return "", fmt.Errorf("S3 storage not yet implemented")
+// GetURL returns the URL for a file: the object's URL on the endpoint,
+// whether it was stored by a single PUT or a multipart upload.
+func (s *S3Storage) GetURL(remotePath string) (string, error) {
+	return s.objectURL(s.key(remotePath)).String(), nil
 }
 
 // Exists checks if a file exists.
-func (s *S3Storage) Exists(_ string) (bool, error) {
-	return false, fmt.Errorf("S3 storage not yet implemented")
+func (s *S3Storage) Exists(remotePath string) (bool, error) {
+	key := s.key(remotePath)
+	resp, err := s.do(context.Background(), http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, s3ResponseError("stat", key, resp)
+}
+
+// key returns the object key for remotePath under the storage prefix.
+func (s *S3Storage) key(remotePath string) string {
+	return strings.TrimPrefix(path.Join(s.prefix, remotePath), "/")
+}
+
+// objectURL returns the URL of key, or of the bucket for "": virtual-hosted
+// on AWS, path-style on a custom endpoint.
+func (s *S3Storage) objectURL(key string) *url.URL {
+	var u url.URL
+	if s.endpoint != nil {
+		u = *s.endpoint
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + s.bucket
+		if key != "" {
+			u.Path += "/" + key
+		}
+	} else {
+		u = url.URL{Scheme: "https", Host: s.bucket + ".s3." + s.region + ".amazonaws.com", Path: "/" + key}
+	}
+	u.RawPath = s3Escape(u.Path, true)
+	return &u
+}
+
+// do sends a signed request for key (the bucket for "") with the given query
+// parameters and body of size bytes.
+func (s *S3Storage) do(ctx context.Context, method, key string, query map[string]string, body io.Reader, size int64) (*http.Response, error) {
+	u := s.objectURL(key)
+	u.RawQuery = s3CanonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.URL = u
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, time.Now())
+	return s.client.Do(req)
+}
+
+// sign adds AWS Signature Version 4 headers to req, signing the host and
+// every header already set on it. The payload hash is taken from an
+// x-amz-content-sha256 header when present, and is UNSIGNED-PAYLOAD
+// otherwise.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format(s3TimeFormat)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	}
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, true),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	signingKey := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes s as Signature Version 4 requires: everything but
+// the unreserved characters (and "/" when keepSlash is set).
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery encodes query parameters sorted by name, which is both a
+// valid query string and its Signature Version 4 canonical form.
+func s3CanonicalQuery(query map[string]string) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, s3Escape(name, false)+"="+s3Escape(query[name], false))
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3ResponseError reports a failed S3 request for key, with the error S3
+// returned in the body.
+func s3ResponseError(action, key string, resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return fmt.Errorf("failed to %s %s: %s", action, key, s3ErrorMessage(resp.Status, data))
+}
+
+// s3ErrorMessage formats an S3 error response body, falling back to the
+// HTTP status.
+func s3ErrorMessage(status string, body []byte) string {
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.Unmarshal(body, &e); err != nil || e.Code == "" {
+		return status
+	}
+	return fmt.Sprintf("%s: %s: %s", status, e.Code, e.Message)
 }
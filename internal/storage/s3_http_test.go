@@ -6,16 +6,34 @@ import (
 	"testing"
 )
 
-// TestNewS3Storage verifies the S3 constructor fails fast (backend not yet
-// implemented), so a STORAGE_TYPE=s3 misconfiguration is caught at startup.
+// TestNewS3Storage verifies the S3 constructor fails fast without
+// credentials, so a STORAGE_TYPE=s3 misconfiguration is caught at startup.
 func TestNewS3Storage(t *testing.T) {
-	storage, err := NewS3Storage("test-bucket", "us-east-1", "prefix")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	storage, err := NewS3Storage("test-bucket", "us-east-1", "prefix", S3Options{})
 	if err == nil {
-		t.Fatal("expected an error: S3 backend is not implemented")
+		t.Fatal("expected an error: no S3 credentials")
 	}
 	if storage != nil {
 		t.Error("expected nil storage on constructor failure")
 	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	s, err := NewS3Storage("test-bucket", "", "prefix", S3Options{})
+	if err != nil {
+		t.Fatalf("NewS3Storage: %v", err)
+	}
+	if s.multipartThreshold != DefaultS3MultipartThreshold || s.concurrency != DefaultS3UploadConcurrency {
+		t.Errorf("threshold, concurrency = %d, %d, want the defaults", s.multipartThreshold, s.concurrency)
+	}
+	if url, _ := s.GetURL("app-misc/jq-1.7.gpkg.tar"); url != "https://test-bucket.s3.us-east-1.amazonaws.com/prefix/app-misc/jq-1.7.gpkg.tar" {
+		t.Errorf("GetURL = %q", url)
+	}
+	if _, err := NewS3Storage("test-bucket", "", "", S3Options{Endpoint: "minio:9000"}); err == nil {
+		t.Error("expected an error for an endpoint without a scheme")
+	}
 }
 
 // TestNewHTTPStorage verifies the HTTP constructor fails fast (backend not yet
@@ -56,10 +74,7 @@ func TestStorageInterfaceCompliance(t *testing.T) {
 	}
 	testStorageInterface(t, local, "test.txt", testFile)
 
-	// S3 and HTTP backends are not implemented: their constructors error.
-	if _, err := NewS3Storage("bucket", "region", ""); err == nil {
-		t.Error("expected NewS3Storage to error (not implemented)")
-	}
+	// The HTTP backend is not implemented: its constructor errors.
 	if _, err := NewHTTPStorage("http://localhost:8080"); err == nil {
 		t.Error("expected NewHTTPStorage to error (not implemented)")
 	}
@@ -22,6 +22,7 @@ type Config struct {
 	S3Bucket string
 	S3Region string
 	S3Prefix string
+	S3       S3Options
 	HTTPBase string
 	Options  map[string]string
 }
@@ -32,7 +33,7 @@ func NewStorage(cfg *Config) (Storage, error) {
 	case "local":
 		return NewLocalStorage(cfg.LocalDir)
 	case "s3":
-		return NewS3Storage(cfg.S3Bucket, cfg.S3Region, cfg.S3Prefix)
+		return NewS3Storage(cfg.S3Bucket, cfg.S3Region, cfg.S3Prefix, cfg.S3)
 	case "http":
 		return NewHTTPStorage(cfg.HTTPBase)
 	default:
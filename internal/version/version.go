@@ -0,0 +1,79 @@
+// Package version reports the build version of the running service.
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strconv"
+)
+
+// Build information, injected at build time via -ldflags, e.g.
+//
+//	-X github.com/slchris/portage-engine/internal/version.Version=v1.2.0
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// Info is the build information a service reports on /api/v1/version.
+type Info struct {
+	Service   string `json:"service"`
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information of this binary for the named service.
+func Get(service string) Info {
+	return Info{
+		Service:   service,
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// Handler serves the build information for /api/v1/version.
+func Handler(service string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Get(service))
+	}
+}
+
+// releasePattern matches the release part of a `git describe` version such as
+// "v1.2.3", "1.2" or "v1.2.3-4-gabcdef-dirty".
+var releasePattern = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+// Compatible reports whether two services' versions can work together: they
+// must share major and minor release. Versions that are not releases ("dev",
+// a bare commit hash) or unknown are assumed compatible, since there is
+// nothing to compare.
+func Compatible(a, b string) bool {
+	am, an, aok := release(a)
+	bm, bn, bok := release(b)
+	if !aok || !bok {
+		return true
+	}
+	return am == bm && an == bn
+}
+
+// release extracts the major and minor numbers of a release version.
+func release(v string) (major, minor int, ok bool) {
+	m := releasePattern.FindStringSubmatch(v)
+	if m == nil {
+		return 0, 0, false
+	}
+	major, _ = strconv.Atoi(m[1])
+	minor, _ = strconv.Atoi(m[2])
+	return major, minor, true
+}
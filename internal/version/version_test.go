@@ -0,0 +1,49 @@
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompatible(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"v1.2.0", "v1.2.5", true},
+		{"v1.2.3-4-gabcdef-dirty", "1.2.0", true},
+		{"v1.2.0", "v1.3.0", false},
+		{"v1.2.0", "v2.2.0", false},
+		{"dev", "v1.2.0", true},
+		{"v1.2.0", "", true},
+		{"abc1234", "v0.1.0", true},
+	}
+	for _, tt := range tests {
+		if got := Compatible(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compatible(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	Handler("builder")(w, httptest.NewRequest(http.MethodGet, "/api/v1/version", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var info Info
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if info.Service != "builder" || info.Version != Version || info.Commit != Commit || info.BuildTime != BuildTime {
+		t.Errorf("Handler() = %+v", info)
+	}
+
+	w = httptest.NewRecorder()
+	Handler("builder")(w, httptest.NewRequest(http.MethodPost, "/api/v1/version", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", w.Code)
+	}
+}
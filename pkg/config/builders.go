@@ -0,0 +1,56 @@
+// Package config provides remote builder address handling.
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Strategies for choosing the static remote builder a job is sent to.
+const (
+	SchedulingFirst       = "first"        // always the first builder that accepts
+	SchedulingRoundRobin  = "round-robin"  // rotate the first builder tried per job
+	SchedulingLeastLoaded = "least-loaded" // lowest current_load/capacity with a free worker
+)
+
+// CanonicalBuilderURL returns the form of a builder address used to tell
+// whether two entries name the same builder: http:// is assumed when no
+// scheme is given, the scheme and host are lowercased, an explicit default
+// port and trailing slashes are dropped.
+func CanonicalBuilderURL(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return strings.TrimRight(addr, "/")
+	}
+	host := strings.ToLower(u.Host)
+	if (u.Scheme == "http" && strings.HasSuffix(host, ":80")) || (u.Scheme == "https" && strings.HasSuffix(host, ":443")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	return strings.ToLower(u.Scheme) + "://" + host + strings.TrimRight(u.Path, "/")
+}
+
+// DedupeBuilders drops empty entries and every entry naming the same builder
+// as an earlier one, keeping the first spelling. It returns the remaining
+// addresses and a description of each dropped duplicate.
+func DedupeBuilders(addrs []string) ([]string, []string) {
+	var kept, duplicates []string
+	first := make(map[string]string, len(addrs))
+	for _, addr := range addrs {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		key := CanonicalBuilderURL(addr)
+		if prev, ok := first[key]; ok {
+			duplicates = append(duplicates, fmt.Sprintf("%q (same builder as %q)", addr, prev))
+			continue
+		}
+		first[key] = addr
+		kept = append(kept, addr)
+	}
+	return kept, duplicates
+}
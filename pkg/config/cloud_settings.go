@@ -6,7 +6,7 @@ package config
 // overrides the static conf/env values at startup). The static config file
 // remains the source of initial defaults.
 type CloudSettings struct {
-	Provider string `json:"provider"` // gcp | aws | pve
+	Provider string `json:"provider"` // gcp | aws | hetzner | pve
 
 	// Static remote builders (dispatch targets). Empty = provision on demand.
 	RemoteBuilders []string `json:"remote_builders,omitempty"`
@@ -16,12 +16,21 @@ type CloudSettings struct {
 	GCPRegion  string `json:"gcp_region"`
 	GCPZone    string `json:"gcp_zone"`
 	GCPKeyFile string `json:"gcp_key_file"`
+	// GCPImage is a prebuilt boot image (e.g. baked with Packer); empty uses
+	// the stock Ubuntu image family.
+	GCPImage string `json:"gcp_image"`
 
 	// AWS
 	AWSRegion    string `json:"aws_region"`
 	AWSZone      string `json:"aws_zone"`
 	AWSAccessKey string `json:"aws_access_key"`
 	AWSSecretKey string `json:"aws_secret_key,omitempty"`
+	// AWSAMI is a prebuilt AMI ID; empty resolves the latest Ubuntu 22.04.
+	AWSAMI string `json:"aws_ami"`
+	// AWSSpot provisions spot instances, capped at AWSMaxSpotPrice USD/hour
+	// when set (empty pays up to the on-demand price).
+	AWSSpot         bool   `json:"aws_spot"`
+	AWSMaxSpotPrice string `json:"aws_max_spot_price"`
 
 	// PVE (Proxmox VE)
 	PVEEndpoint    string   `json:"pve_endpoint"`
@@ -90,6 +99,11 @@ type CloudSettings struct {
 	// (default gentoo/stage3:latest).
 	DockerImage string `json:"docker_image"`
 
+	// PrebakedImage declares that GCPImage/AWSAMI already carry Docker, the
+	// build container image and a portage tree, so instance bootstrap reuses
+	// them instead of reinstalling and resyncing on every provision.
+	PrebakedImage bool `json:"prebaked_image"`
+
 	// SkipVerifyInstall disables the post-build install verification stage
 	// (a pristine container installing the fresh binpkg from the binhost).
 	// Verification is ON by default; this is the explicit opt-out.
@@ -115,10 +129,14 @@ func CloudSettingsFromServerConfig(cfg *ServerConfig) *CloudSettings {
 		GCPRegion:          cfg.CloudGCPRegion,
 		GCPZone:            cfg.CloudGCPZone,
 		GCPKeyFile:         cfg.CloudGCPKeyFile,
+		GCPImage:           cfg.CloudGCPImage,
 		AWSRegion:          cfg.CloudAWSRegion,
 		AWSZone:            cfg.CloudAWSZone,
 		AWSAccessKey:       cfg.CloudAWSAccessKey,
 		AWSSecretKey:       cfg.CloudAWSSecretKey,
+		AWSAMI:             cfg.CloudAWSAMI,
+		AWSSpot:            cfg.CloudAWSSpot,
+		AWSMaxSpotPrice:    cfg.CloudAWSMaxSpotPrice,
 		PVEEndpoint:        cfg.CloudPVEEndpoint,
 		PVENode:            cfg.CloudPVENode,
 		PVENodes:           cfg.CloudPVENodes,
@@ -136,6 +154,7 @@ func CloudSettingsFromServerConfig(cfg *ServerConfig) *CloudSettings {
 		BuilderBinaryPath:  cfg.CloudBuilderBinaryPath,
 		BuilderBinaryURL:   cfg.CloudBuilderBinaryURL,
 		InstanceTTLMinutes: cfg.CloudInstanceTTL,
+		PrebakedImage:      cfg.CloudPrebakedImage,
 	}
 }
 
@@ -5,8 +5,10 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // insecureJWTSecrets is a list of well-known insecure JWT secrets that must
@@ -20,12 +22,61 @@ var insecureJWTSecrets = []string{
 	"",
 }
 
+// defaultFeaturesDenylist are the FEATURES a client may not set by default:
+// they disable build isolation or weaken QA enforcement.
+var defaultFeaturesDenylist = []string{"-sandbox", "-usersandbox", "-network-sandbox", "unprivileged", "-strict"}
+
+// defaultBuildRetryPatterns mark a failed build as transient (network or
+// mirror trouble, lock contention) when its output contains one of them.
+var defaultBuildRetryPatterns = []string{
+	"Connection timed out",
+	"Connection reset by peer",
+	"Temporary failure in name resolution",
+	"Could not resolve host",
+	"Couldn't download",
+	"Fetch failed",
+	"failed to fetch",
+	"Resource temporarily unavailable",
+}
+
+// defaultPackageMemoryGB is the memory, in GB, that packages known to need
+// far more than an average build are estimated to build with.
+var defaultPackageMemoryGB = map[string]float64{
+	"www-client/chromium":     16,
+	"dev-qt/qtwebengine":      12,
+	"app-office/libreoffice":  12,
+	"net-libs/webkit-gtk":     8,
+	"www-client/firefox":      8,
+	"mail-client/thunderbird": 8,
+	"dev-lang/rust":           8,
+	"llvm-core/llvm":          8,
+	"llvm-core/clang":         8,
+	"dev-lang/ghc":            8,
+}
+
+// defaultLogRedactPatterns match credentials commonly printed by builds:
+// passwords and tokens in URLs, assignments to secret-looking variables, auth
+// headers, and well-known token formats. A pattern with a capture group has
+// only its first group masked, so the surrounding context stays readable.
+// LOG_REDACT_PATTERNS is comma-separated, so no pattern may contain a comma.
+var defaultLogRedactPatterns = []string{
+	`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:([^/\s@]+)@`,
+	`\b[A-Z0-9_]*(?:TOKEN|SECRET|PASSW(?:OR)?D|API_?KEY|ACCESS_?KEY|CREDENTIALS?)[A-Z0-9_]*["']?\s*[=:]\s*["']?([^\s"'&]+)`,
+	`(?i)[?&](?:access_?)?(?:token|api_?key|secret|password|signature)=([^\s&"'#]+)`,
+	`(?i)authorization:\s*(?:bearer|basic|token)\s+(\S+)`,
+	`\bgh[pousr]_[A-Za-z0-9]{36}[A-Za-z0-9]*`,
+	`\bglpat-[A-Za-z0-9_-]{20}[A-Za-z0-9_-]*`,
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	`\bxox[abprs]-[A-Za-z0-9-]{10}[A-Za-z0-9-]*`,
+}
+
 // ServerConfig represents the server configuration.
 type ServerConfig struct {
 	Port                 int
 	BinpkgPath           string
 	MaxWorkers           int
 	BuildMode            string
+	SchedulingStrategy   string // Remote builder selection: first, round-robin (default), least-loaded
 	StorageType          string
 	StorageLocalDir      string
 	StorageS3Bucket      string
@@ -54,16 +105,45 @@ type ServerConfig struct {
 	CloudGCPDiskType     string
 	CloudGCPImageFamily  string
 	CloudGCPImageProject string
+	CloudGCPImage        string // Prebuilt boot image; overrides family/project
 	CloudGCPNetwork      string
 	CloudGCPSubnetwork   string
 	CloudGCPPreemptible  bool
 	CloudGCPStateDir     string
 	CloudGCPAllowedIPs   []string
 	CloudInstanceTTL     int // Instance TTL in minutes, 0 means no auto-termination
+	// CloudInstanceIdleTimeout terminates instances whose builder has not
+	// heartbeated for this many minutes; CloudInstanceMaxLifetime terminates
+	// instances this many minutes after provisioning. 0 disables each.
+	CloudInstanceIdleTimeout int
+	CloudInstanceMaxLifetime int
+
 	CloudAWSRegion       string
 	CloudAWSZone         string
 	CloudAWSAccessKey    string
 	CloudAWSSecretKey    string
+	CloudAWSAMI          string `env:"CLOUD_AWS_AMI"` // Prebuilt AMI; skips the Ubuntu AMI lookup
+	CloudAWSSpot         bool   // Provision spot instead of on-demand instances
+	CloudAWSMaxSpotPrice string // Spot price cap in USD/hour; empty pays up to on-demand
+	// CloudPrebakedImage marks CloudGCPImage/CloudAWSAMI as builder images
+	// that already carry Docker, the stage3 image and a portage tree.
+	CloudPrebakedImage bool
+	// Budget cap on provisioned cloud instances; 0 disables each limit.
+	CloudMaxInstances   int
+	CloudMaxHourlySpend float64 // Estimated USD/hour across all instances
+	// CloudHourlyPrices prices instance types in USD/hour, overriding the
+	// built-in list prices used to estimate spend.
+	CloudHourlyPrices map[string]float64
+	// Readiness gate for freshly deployed cloud builders, in seconds: wait
+	// CloudBuilderReadyDelay before probing /health, then retry for up to
+	// CloudBuilderReadyTimeout (0 uses the default).
+	CloudBuilderReadyDelay   int
+	CloudBuilderReadyTimeout int
+	// Hetzner Cloud; the token is conf/env-only. An empty server type uses
+	// cpx41 (amd64) or cax41 (arm64).
+	CloudHetznerToken      string
+	CloudHetznerLocation   string
+	CloudHetznerServerType string
 	// PVE (Proxmox VE) configuration
 	CloudPVEEndpoint    string   // PVE API endpoint (e.g., https://pve.example.com:8006)
 	CloudPVENode        string   // Default PVE node name
@@ -88,12 +168,43 @@ type ServerConfig struct {
 	// during deployment, or a URL the instance downloads from (path wins).
 	CloudBuilderBinaryPath string
 	CloudBuilderBinaryURL  string
-	RemoteBuilders         []string
+	// StateBackend keeps the Terraform state of cloud instances in a remote,
+	// locked backend (STATE_BACKEND_* keys).
+	StateBackend   StateBackendConfig
+	RemoteBuilders []string
+	// duplicateBuilders describes REMOTE_BUILDERS entries dropped at load
+	// because an earlier entry names the same builder.
+	duplicateBuilders []string
+	// Portage tree freshness of remote builders: a builder whose tree is
+	// older than TreeStaleAfter is flagged and tried last, and one older than
+	// TreeRefuseAfter gets no jobs (0 = never refuse).
+	TreeStaleAfter  time.Duration
+	TreeRefuseAfter time.Duration
 	// Security settings
 	APIKey              string   // API key for authenticating requests (empty = auth disabled)
 	BuilderToken        string   // Shared secret the server presents to remote builders (empty = no builder auth)
+	JWTSecret           string   // Dashboard's JWT signing secret; set to enforce token roles on state-changing endpoints
 	CORSAllowedOrigins  []string // Allowed CORS origins (empty = allow all for backward compatibility)
 	MaxRequestBodyBytes int64    // Maximum request body size in bytes (0 = default 10MB)
+	// Build submissions per minute allowed per user (per client IP when
+	// anonymous), with bursts of up to RateLimitBurst (0 = no limit).
+	RateLimitPerMinute int
+	RateLimitBurst     int
+	// FeaturesDenylist lists Portage FEATURES tokens stripped from every
+	// client-supplied build configuration (empty = no filtering).
+	FeaturesDenylist []string
+	// AdminAPIKey, presented as X-Admin-Key, lets a request bypass the
+	// FEATURES denylist (empty = no bypass possible).
+	AdminAPIKey string
+	// EphemeralArtifactTTL is how long, in minutes, an ephemeral build's
+	// artifact is held for its one-time download (0 = default 30).
+	EphemeralArtifactTTL int
+	// EphemeralCallbackHosts lists the hosts an ephemeral build's callback_url
+	// may name. Empty allows any host resolving to public addresses only.
+	EphemeralCallbackHosts []string
+	// BuildStatsWindow is how far back the per-package build time statistics
+	// reach; older builds are dropped from them.
+	BuildStatsWindow time.Duration
 	// Data persistence
 	DataDir         string // Directory for persisting server state (empty = /var/lib/portage-engine/server)
 	MetricsEnabled  bool
@@ -101,22 +212,72 @@ type ServerConfig struct {
 	MetricsPassword string
 }
 
+// StateBackendConfig configures remote Terraform state for cloud instances,
+// so that server processes sharing the infrastructure take turns under a
+// state lock and the state outlives the local workspace. A provider's
+// backend is used once its bucket is set; without one, and for providers
+// with none (Hetzner, PVE), the state stays local.
+type StateBackendConfig struct {
+	// Prefix starts the state path of every instance.
+	Prefix string
+	// GCSBucket holds the state of GCP instances; GCS locks it natively.
+	GCSBucket string
+	// S3Bucket in S3Region holds the state of AWS instances, locked in the
+	// DynamoDB table S3DynamoDBTable (partition key LockID).
+	S3Bucket        string
+	S3Region        string
+	S3DynamoDBTable string `env:"S3_DYNAMODB_TABLE"`
+	// OSSBucket in OSSRegion holds the state of Aliyun instances, locked in
+	// the TableStore table OSSTablestoreTable at OSSTablestoreEndpoint.
+	OSSBucket             string
+	OSSRegion             string
+	OSSTablestoreEndpoint string
+	OSSTablestoreTable    string
+	// LockTimeout is how long terraform waits for a lock another process
+	// holds before failing.
+	LockTimeout time.Duration
+}
+
 // Validate checks the server configuration for common misconfigurations.
 func (c *ServerConfig) Validate() []string {
 	var warnings []string
 
-	if c.APIKey == "" {
+	switch {
+	case c.APIKey == "" && c.JWTSecret == "":
 		warnings = append(warnings, "SECURITY: API_KEY is not set — all API endpoints are unauthenticated")
+	case c.APIKey == "":
+		warnings = append(warnings, "CONFIG: API_KEY is not set — API endpoints take only operator tokens, so builders and the dashboard cannot authenticate their server calls")
 	}
 	if len(c.CORSAllowedOrigins) == 0 {
 		warnings = append(warnings, "SECURITY: CORS_ALLOWED_ORIGINS is not set — defaulting to allow all origins (*)")
 	}
+	if c.JWTSecret != "" && len(c.JWTSecret) < 32 {
+		warnings = append(warnings, fmt.Sprintf("SECURITY: JWT_SECRET is too short (%d chars). Use at least 32 characters", len(c.JWTSecret)))
+	}
 	if c.Port <= 0 || c.Port > 65535 {
 		warnings = append(warnings, fmt.Sprintf("CONFIG: SERVER_PORT %d is invalid, must be 1-65535", c.Port))
 	}
 	if c.MaxWorkers <= 0 {
 		warnings = append(warnings, "CONFIG: MAX_WORKERS must be > 0")
 	}
+	switch c.SchedulingStrategy {
+	case "", SchedulingFirst, SchedulingRoundRobin, SchedulingLeastLoaded:
+	default:
+		warnings = append(warnings, fmt.Sprintf("CONFIG: SCHEDULING_STRATEGY %q is unknown, using %s", c.SchedulingStrategy, SchedulingRoundRobin))
+	}
+	for _, dup := range c.duplicateBuilders {
+		warnings = append(warnings, "CONFIG: REMOTE_BUILDERS lists "+dup+"; ignoring the duplicate")
+	}
+	if sb := c.StateBackend; sb.S3Bucket != "" && sb.S3DynamoDBTable == "" {
+		warnings = append(warnings, "CONFIG: STATE_BACKEND_S3_DYNAMODB_TABLE is not set — AWS instance state in S3 is not locked")
+	}
+	if sb := c.StateBackend; sb.OSSBucket != "" && (sb.OSSTablestoreEndpoint == "" || sb.OSSTablestoreTable == "") {
+		warnings = append(warnings, "CONFIG: STATE_BACKEND_OSS_TABLESTORE_ENDPOINT/TABLE are not set — Aliyun instance state in OSS is not locked")
+	}
+	if c.TreeRefuseAfter > 0 && c.TreeStaleAfter > 0 && c.TreeRefuseAfter < c.TreeStaleAfter {
+		warnings = append(warnings, fmt.Sprintf("CONFIG: TREE_REFUSE_AFTER %s is below TREE_STALE_AFTER %s; builders are refused before they are flagged stale",
+			c.TreeRefuseAfter, c.TreeStaleAfter))
+	}
 
 	return warnings
 }
@@ -129,12 +290,22 @@ type DashboardConfig struct {
 	AuthEnabled     bool
 	JWTSecret       string
 	AdminUser       string // Username accepted by the login handler
-	AdminPassword   string // Password accepted by the login handler
+	AdminPassword   string // AdminUser's password, preferably as a bcrypt hash
+	UsersFile       string // htpasswd-style file of further users with bcrypt hashes
 	TokenTTLMinutes int    // Issued-token lifetime in minutes
 	AllowAnonymous  bool
 	MetricsEnabled  bool
 	MetricsPort     string
 	MetricsPassword string
+	// HTTP clients for calls to the server: API requests are bounded by
+	// APITimeout, while artifact downloads and streams are only bounded by
+	// DownloadTimeout (0 = no limit) and abandoned after DownloadIdleTimeout
+	// without data. MaxServerConns caps each client's connections to the
+	// server.
+	APITimeout          time.Duration
+	DownloadTimeout     time.Duration
+	DownloadIdleTimeout time.Duration
+	MaxServerConns      int
 }
 
 // Validate checks the dashboard configuration for common misconfigurations.
@@ -159,9 +330,9 @@ func (c *DashboardConfig) Validate() error {
 		// When anonymous access is disabled, the login handler must be able to
 		// authenticate a real operator; otherwise the dashboard is unreachable.
 		if !c.AllowAnonymous {
-			if c.AdminUser == "" || c.AdminPassword == "" {
+			if (c.AdminUser == "" || c.AdminPassword == "") && c.UsersFile == "" {
 				return fmt.Errorf(
-					"SECURITY: ALLOW_ANONYMOUS is false but ADMIN_USER/ADMIN_PASSWORD are not set; " +
+					"SECURITY: ALLOW_ANONYMOUS is false but neither ADMIN_USER/ADMIN_PASSWORD nor USERS_FILE is set; " +
 						"set credentials so operators can log in",
 				)
 			}
@@ -174,6 +345,9 @@ func (c *DashboardConfig) Validate() error {
 type BuilderConfig struct {
 	Port               int
 	AuthToken          string // Shared secret required on build/job endpoints (empty = auth disabled)
+	AdminToken         string // Secret for admin endpoints such as tree sync, as X-Admin-Key (empty = disabled)
+	RateLimitPerMinute int    // Build submissions per minute per client IP (0 = no limit)
+	RateLimitBurst     int    // Burst of build submissions per client IP
 	Workers            int
 	InstanceID         string
 	Architecture       string
@@ -184,12 +358,30 @@ type BuilderConfig struct {
 	ArtifactDir        string
 	DataDir            string
 	PersistenceEnabled bool
+	JobStore           string // Persistence backend: "json" (default, one file) or "sqlite" (a row per job)
 	RetentionDays      int
-	GPGEnabled         bool
-	GPGKeyID           string
-	GPGKeyPath         string
-	GPGAutoSync        bool   // Auto-sync GPG key from server
-	GPGHome            string // Custom GNUPGHOME directory
+	// JobRetention is how long finished jobs are kept after they end (0 =
+	// no age limit); it defaults to RetentionDays.
+	JobRetention time.Duration
+	// JobRetentionMaxJobs caps the number of finished jobs kept (0 = no cap);
+	// the ones that ended longest ago go first.
+	JobRetentionMaxJobs int
+	// PruneArtifacts also deletes a pruned job's artifacts from ArtifactDir.
+	PruneArtifacts bool
+	// UploadedArtifactRetention is how long the local copies of artifacts
+	// uploaded to remote storage are kept, independently of the job record
+	// (0 = as long as the job).
+	UploadedArtifactRetention time.Duration
+	// JobPruneInterval is how often the retention policy is applied.
+	JobPruneInterval  time.Duration
+	GPGEnabled        bool
+	GPGKeyID          string
+	GPGKeyPath        string
+	GPGAutoSync       bool   // Auto-sync GPG key from server
+	GPGSyncRetries    int    // Key sync retries (exponential backoff) before falling back to periodic re-attempts
+	GPGSyncBackoff    int    // Initial key sync retry backoff in seconds (doubles per retry)
+	GPGExpiryWarnDays int    // Warn when the signing key expires within this many days
+	GPGHome           string // Custom GNUPGHOME directory
 	// BinpkgFormat selects the binary package format Portage produces: "gpkg"
 	// (modern, GPG-signable) or "xpak" (legacy .tbz2, deprecated). Defaults to
 	// "gpkg"; only GPKG supports native OpenPGP signing/verification.
@@ -197,14 +389,130 @@ type BuilderConfig struct {
 	// BuildFeatures is appended to the build container's make.conf FEATURES.
 	// Docker builds need "-userpriv -usersandbox" (no unshare/privilege drop);
 	// a full Gentoo VM would leave this empty. WebUI-configurable.
-	BuildFeatures   string
+	BuildFeatures string
+	// SeparateFetch runs `emerge --fetchonly` as its own phase before the
+	// build, so source download failures are reported (and retried) apart
+	// from compile failures.
+	SeparateFetch bool
+	// DefaultBuildTimeout bounds a build whose request sets no build_timeout.
+	DefaultBuildTimeout time.Duration
+	// ShutdownGracePeriod is how long a shutdown lets running builds finish
+	// before cancelling them. Queued builds fail at once as retryable.
+	ShutdownGracePeriod time.Duration
+	// MinFreeDiskGB is the free space, in GB, the work and artifact
+	// directories need for a build to start (0 = no check).
+	MinFreeDiskGB int
+	// DiskSpaceAction is what happens to a job short of disk space: "fail"
+	// (retryable) or "wait" (it stays queued until space is freed).
+	DiskSpaceAction string
+	// DiskHighWatermark is the disk usage percentage above which a warning
+	// notification is sent (0 = never).
+	DiskHighWatermark int
+	// MemoryHeadroomGB keeps a queued build waiting while starting it would
+	// likely leave less than this much memory, in GB, free (0 = no check).
+	// A build is estimated to need BuildMemoryGB, or its PackageMemoryGB
+	// entry by category/name.
+	MemoryHeadroomGB float64
+	BuildMemoryGB    float64
+	PackageMemoryGB  map[string]float64
+	// ArtifactWaitTimeout bounds how long a finished container build waits
+	// for its binary packages to appear, complete, in the output dir.
+	ArtifactWaitTimeout time.Duration
+	// ArtifactDebug logs every candidate file considered when picking the
+	// requested package among a build's artifacts.
+	ArtifactDebug bool
+	// ArtifactCompression also stores each artifact compressed, as "gzip" or
+	// "zstd", for upload and download; "none" (default) stores it as built.
+	ArtifactCompression string
+	// ArtifactCompressionLevel is the gzip (1-9) or zstd (1-22) level, 0 for
+	// the codec's default.
+	ArtifactCompressionLevel int
+	// ReuseIdenticalBuilds lets a job that waited for an identical build
+	// (same package, version, arch and configuration) on this builder take
+	// over its artifacts instead of building again.
+	ReuseIdenticalBuilds bool
+	// BuildResultCache answers a request identical to one that already
+	// succeeded against the same portage tree snapshot (and build image)
+	// with that build's artifacts, unless the request sets force_rebuild.
+	BuildResultCache bool
+	// EnableQEMU builds requests for another arch than the builder's in a
+	// container of that arch's platform, emulated by QEMU user-mode
+	// binfmt_misc handlers, which must be registered on the host.
+	EnableQEMU bool
+	// CrossArchImage is the stage3 image of such builds, "{arch}" replaced
+	// by the Gentoo arch. Empty uses the build image for the target platform.
+	CrossArchImage string
+	// MaxBuildRetries retries a failed build up to this many times when its
+	// output matches one of BuildRetryPatterns (case-insensitive), waiting
+	// BuildRetryBackoff before the first retry and twice as long before each
+	// further one. 0 disables retries.
+	MaxBuildRetries    int
+	BuildRetryPatterns []string
+	BuildRetryBackoff  time.Duration
+	// LogRedactPatterns are regular expressions masked with "***" in build
+	// logs before they are stored, streamed or sent in notifications. With a
+	// capture group, only the first group is masked. The values of secret
+	// environment variables (names containing TOKEN, SECRET, PASSWORD, ...)
+	// and of the builder's own tokens are always masked.
+	LogRedactPatterns []string
+	// TreeSyncMaxAge syncs the portage tree before a build when its last
+	// sync is older than this (0 = never sync automatically).
+	TreeSyncMaxAge time.Duration
+	// TreeSyncInterval syncs the shared portage tree in the background
+	// whenever it is older than this, independent of builds (0 = off).
+	TreeSyncInterval time.Duration
+	// SpotInterruptionCheck polls the EC2 instance metadata for a spot
+	// interruption notice and, once one arrives, fails every queued and
+	// running job so the server rebuilds them elsewhere. Only for builders
+	// on AWS spot instances.
+	SpotInterruptionCheck bool
+	// CCacheEnabled builds with FEATURES=ccache against the persistent
+	// CCacheDir, mounted into build containers, so repeated builds of the
+	// same packages reuse their compiled objects.
+	CCacheEnabled bool
+	CCacheDir     string
+	// DistccHosts are distcc helper hosts (distcc's HOST[:PORT][/LIMIT]
+	// specs) container builds distribute compilation to. Each is probed
+	// before a build and unreachable ones are left out.
+	DistccHosts []string `env:",spaces"`
+	// MakeOpts is the MAKEOPTS of builds, e.g. "-j8 -l8", and EmergeJobs
+	// the emerge options building several packages at once, e.g.
+	// "--jobs=2 --load-average=8". Empty derives both from the builder's
+	// CPUs and memory.
+	MakeOpts   string `env:"MAKEOPTS"`
+	EmergeJobs string
+	// AcceptLicense is the builder-wide ACCEPT_LICENSE, used when a request
+	// does not carry its own. Empty keeps make.conf/profile's value ("-* @FREE"
+	// on a stock Gentoo), so non-free licenses must be granted explicitly.
+	AcceptLicense string
+	// SimulationMode fakes every build instead of running containers or
+	// emerge, for demos and development without a Gentoo environment: a
+	// build logs a fixed fake emerge run over SimulationDelay, then fails
+	// with probability SimulationFailureRate or yields a synthetic binary
+	// package.
+	SimulationMode        bool
+	SimulationDelay       time.Duration
+	SimulationFailureRate float64
+
 	StorageType     string
 	StorageLocalDir string
 	StorageS3Bucket string
 	StorageS3Region string
 	StorageS3Prefix string
-	StorageHTTPBase string
-	ServerURL       string
+	// StorageS3Endpoint is an S3-compatible endpoint (e.g. MinIO); empty uses
+	// AWS.
+	StorageS3Endpoint string
+	// StorageS3AccessKey and StorageS3SecretKey are the S3 credentials
+	// (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY).
+	StorageS3AccessKey string
+	StorageS3SecretKey string
+	// S3MultipartThreshold is the artifact size in bytes from which S3 uploads
+	// use multipart upload (STORAGE_S3_MULTIPART_THRESHOLD_MB, default 100).
+	S3MultipartThreshold int64
+	// S3UploadConcurrency is the number of multipart upload parts sent at once.
+	S3UploadConcurrency int
+	StorageHTTPBase     string
+	ServerURL           string
 	// ServerAPIKey is the central server's API key, attached to registration
 	// and heartbeat calls (required when the server sets API_KEY).
 	ServerAPIKey string
@@ -239,6 +547,9 @@ func (c *BuilderConfig) Validate() []string {
 	if c.AuthToken == "" {
 		warnings = append(warnings, "SECURITY: BUILDER_TOKEN is not set — the build endpoint is unauthenticated and allows arbitrary remote builds")
 	}
+	if c.RateLimitPerMinute < 0 || c.RateLimitBurst < 0 {
+		warnings = append(warnings, "CONFIG: BUILDER_RATE_LIMIT_PER_MINUTE and BUILDER_RATE_LIMIT_BURST must not be negative")
+	}
 	if c.UseDocker && c.DockerImage == "" {
 		warnings = append(warnings, "CONFIG: USE_DOCKER is true but DOCKER_IMAGE is empty")
 	}
@@ -248,10 +559,53 @@ func (c *BuilderConfig) Validate() []string {
 	if c.ArtifactDir == "" {
 		warnings = append(warnings, "CONFIG: BUILD_ARTIFACT_DIR is not set")
 	}
+	if c.JobStore != "" && c.JobStore != "json" && c.JobStore != "sqlite" {
+		warnings = append(warnings, fmt.Sprintf("CONFIG: JOB_STORE %q is unknown, using the JSON file store (want json or sqlite)", c.JobStore))
+	}
+	switch c.ArtifactCompression {
+	case "", "none", "gzip", "zstd":
+	default:
+		warnings = append(warnings, fmt.Sprintf("CONFIG: ARTIFACT_COMPRESSION %q is unknown, artifacts are stored uncompressed (want none, gzip or zstd)", c.ArtifactCompression))
+	}
+	for _, p := range c.LogRedactPatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			warnings = append(warnings, fmt.Sprintf("CONFIG: LOG_REDACT_PATTERNS entry %q is not a valid regular expression and is ignored: %v", p, err))
+		}
+	}
+	if c.MakeOpts != "" && !allFieldsMatch(makeOptPattern, c.MakeOpts) {
+		warnings = append(warnings, fmt.Sprintf("CONFIG: MAKEOPTS %q is not a list of -jN/-lN options and is ignored", c.MakeOpts))
+	}
+	if c.EmergeJobs != "" && !allFieldsMatch(emergeJobOptPattern, c.EmergeJobs) {
+		warnings = append(warnings, fmt.Sprintf("CONFIG: EMERGE_JOBS %q is not a list of --jobs=N/--load-average=N options and is ignored", c.EmergeJobs))
+	}
+	if c.SimulationMode {
+		warnings = append(warnings, "CONFIG: SIMULATION_MODE is on — builds are simulated and their packages are fake")
+		if c.SimulationFailureRate < 0 || c.SimulationFailureRate > 1 {
+			warnings = append(warnings, fmt.Sprintf("CONFIG: SIMULATION_FAILURE_RATE %v is not between 0 and 1", c.SimulationFailureRate))
+		}
+	}
 
 	return warnings
 }
 
+// The options MAKEOPTS and EMERGE_JOBS may hold: job counts and load limits.
+var (
+	makeOptPattern      = regexp.MustCompile(`^(-[jl]|--jobs=|--load-average=)[0-9]+(\.[0-9]+)?$`)
+	emergeJobOptPattern = regexp.MustCompile(`^--(jobs|load-average)=[0-9]+(\.[0-9]+)?$`)
+)
+
+// allFieldsMatch reports whether s has at least one whitespace-separated
+// field and every one matches p.
+func allFieldsMatch(p *regexp.Regexp, s string) bool {
+	fields := strings.Fields(s)
+	for _, f := range fields {
+		if !p.MatchString(f) {
+			return false
+		}
+	}
+	return len(fields) > 0
+}
+
 // unquoteEnvValue strips a single matching pair of surrounding single or double
 // quotes from a config value, so a quoted secret/path is not silently corrupted
 // by the literal quotes. Unquoted values (and mismatched quotes) are returned
@@ -323,6 +677,18 @@ func getEnvInt(env map[string]string, key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvFloat gets float value from env map with fallback to system env.
+func getEnvFloat(env map[string]string, key string, defaultValue float64) float64 {
+	val := getEnvString(env, key, "")
+	if val == "" {
+		return defaultValue
+	}
+	if f, err := strconv.ParseFloat(val, 64); err == nil {
+		return f
+	}
+	return defaultValue
+}
+
 // getEnvBool gets bool value from env map with fallback to system env.
 func getEnvBool(env map[string]string, key string, defaultValue bool) bool {
 	val := getEnvString(env, key, "")
@@ -333,6 +699,19 @@ func getEnvBool(env map[string]string, key string, defaultValue bool) bool {
 	return val == "true" || val == "1" || val == "yes"
 }
 
+// getEnvDuration gets a duration value (e.g. "6h") from env map with fallback
+// to system env.
+func getEnvDuration(env map[string]string, key string, defaultValue time.Duration) time.Duration {
+	val := getEnvString(env, key, "")
+	if val == "" {
+		return defaultValue
+	}
+	if d, err := time.ParseDuration(val); err == nil && d > 0 {
+		return d
+	}
+	return defaultValue
+}
+
 // LoadServerConfig loads server configuration from a file.
 func LoadServerConfig(path string) (*ServerConfig, error) {
 	// Set defaults
@@ -367,6 +746,7 @@ func LoadServerConfig(path string) (*ServerConfig, error) {
 	config.BinpkgPath = getEnvString(env, "BINPKG_PATH", config.BinpkgPath)
 	config.MaxWorkers = getEnvInt(env, "MAX_WORKERS", config.MaxWorkers)
 	config.BuildMode = getEnvString(env, "BUILD_MODE", config.BuildMode)
+	config.SchedulingStrategy = getEnvString(env, "SCHEDULING_STRATEGY", SchedulingRoundRobin)
 
 	config.StorageType = getEnvString(env, "STORAGE_TYPE", config.StorageType)
 	config.StorageLocalDir = getEnvString(env, "STORAGE_LOCAL_DIR", config.StorageLocalDir)
@@ -389,6 +769,9 @@ func LoadServerConfig(path string) (*ServerConfig, error) {
 	config.CloudAliyunZone = getEnvString(env, "CLOUD_ALIYUN_ZONE", "cn-hangzhou-a")
 	config.CloudAliyunAK = getEnvString(env, "CLOUD_ALIYUN_ACCESS_KEY", "")
 	config.CloudAliyunSK = getEnvString(env, "CLOUD_ALIYUN_SECRET_KEY", "")
+	config.CloudHetznerToken = getEnvString(env, "CLOUD_HETZNER_TOKEN", "")
+	config.CloudHetznerLocation = getEnvString(env, "CLOUD_HETZNER_LOCATION", "fsn1")
+	config.CloudHetznerServerType = getEnvString(env, "CLOUD_HETZNER_SERVER_TYPE", "")
 	config.CloudGCPProject = getEnvString(env, "CLOUD_GCP_PROJECT", config.CloudGCPProject)
 	config.CloudGCPRegion = getEnvString(env, "CLOUD_GCP_REGION", config.CloudGCPRegion)
 	config.CloudGCPZone = getEnvString(env, "CLOUD_GCP_ZONE", config.CloudGCPZone)
@@ -398,6 +781,7 @@ func LoadServerConfig(path string) (*ServerConfig, error) {
 	config.CloudGCPDiskType = getEnvString(env, "CLOUD_GCP_DISK_TYPE", "pd-ssd")
 	config.CloudGCPImageFamily = getEnvString(env, "CLOUD_GCP_IMAGE_FAMILY", "ubuntu-2204-lts")
 	config.CloudGCPImageProject = getEnvString(env, "CLOUD_GCP_IMAGE_PROJECT", "ubuntu-os-cloud")
+	config.CloudGCPImage = getEnvString(env, "CLOUD_GCP_IMAGE", "")
 	config.CloudGCPNetwork = getEnvString(env, "CLOUD_GCP_NETWORK", "default")
 	config.CloudGCPSubnetwork = getEnvString(env, "CLOUD_GCP_SUBNETWORK", "")
 	config.CloudGCPPreemptible = getEnvBool(env, "CLOUD_GCP_PREEMPTIBLE", false)
@@ -409,10 +793,21 @@ func LoadServerConfig(path string) (*ServerConfig, error) {
 		}
 	}
 	config.CloudInstanceTTL = getEnvInt(env, "CLOUD_INSTANCE_TTL", 60) // Default 60 minutes
+	config.CloudInstanceIdleTimeout = getEnvInt(env, "CLOUD_INSTANCE_IDLE_TIMEOUT", 0)
+	config.CloudInstanceMaxLifetime = getEnvInt(env, "CLOUD_INSTANCE_MAX_LIFETIME", 0)
+	config.CloudMaxInstances = getEnvInt(env, "CLOUD_MAX_INSTANCES", 0)
+	config.CloudMaxHourlySpend = getEnvFloat(env, "CLOUD_MAX_HOURLY_SPEND", 0)
+	config.CloudHourlyPrices = getEnvFloatMap(env, "CLOUD_HOURLY_PRICES", nil)
+	config.CloudBuilderReadyDelay = getEnvInt(env, "CLOUD_BUILDER_READY_DELAY", 0)
+	config.CloudBuilderReadyTimeout = getEnvInt(env, "CLOUD_BUILDER_READY_TIMEOUT", 0)
 	config.CloudAWSRegion = getEnvString(env, "CLOUD_AWS_REGION", "us-east-1")
 	config.CloudAWSZone = getEnvString(env, "CLOUD_AWS_ZONE", "us-east-1a")
 	config.CloudAWSAccessKey = getEnvString(env, "CLOUD_AWS_ACCESS_KEY", "")
 	config.CloudAWSSecretKey = getEnvString(env, "CLOUD_AWS_SECRET_KEY", "")
+	config.CloudAWSAMI = getEnvString(env, "CLOUD_AWS_AMI", "")
+	config.CloudAWSSpot = getEnvBool(env, "CLOUD_AWS_SPOT", false)
+	config.CloudAWSMaxSpotPrice = getEnvString(env, "CLOUD_AWS_MAX_SPOT_PRICE", "")
+	config.CloudPrebakedImage = getEnvBool(env, "CLOUD_PREBAKED_IMAGE", false)
 
 	// PVE (Proxmox VE) configuration
 	config.CloudPVEEndpoint = getEnvString(env, "CLOUD_PVE_ENDPOINT", "")
@@ -443,26 +838,55 @@ func LoadServerConfig(path string) (*ServerConfig, error) {
 	config.ServerCallbackURL = getEnvString(env, "SERVER_CALLBACK_URL", "")
 	config.CloudBuilderBinaryPath = getEnvString(env, "CLOUD_BUILDER_BINARY_PATH", "")
 	config.CloudBuilderBinaryURL = getEnvString(env, "CLOUD_BUILDER_BINARY_URL", "")
+	config.StateBackend = StateBackendConfig{
+		Prefix:                getEnvString(env, "STATE_BACKEND_PREFIX", "portage-engine"),
+		GCSBucket:             getEnvString(env, "STATE_BACKEND_GCS_BUCKET", ""),
+		S3Bucket:              getEnvString(env, "STATE_BACKEND_S3_BUCKET", ""),
+		S3Region:              getEnvString(env, "STATE_BACKEND_S3_REGION", ""),
+		S3DynamoDBTable:       getEnvString(env, "STATE_BACKEND_S3_DYNAMODB_TABLE", ""),
+		OSSBucket:             getEnvString(env, "STATE_BACKEND_OSS_BUCKET", ""),
+		OSSRegion:             getEnvString(env, "STATE_BACKEND_OSS_REGION", ""),
+		OSSTablestoreEndpoint: getEnvString(env, "STATE_BACKEND_OSS_TABLESTORE_ENDPOINT", ""),
+		OSSTablestoreTable:    getEnvString(env, "STATE_BACKEND_OSS_TABLESTORE_TABLE", ""),
+		LockTimeout:           getEnvDuration(env, "STATE_BACKEND_LOCK_TIMEOUT", 5*time.Minute),
+	}
 
 	config.MetricsEnabled = getEnvBool(env, "METRICS_ENABLED", false)
 	config.MetricsPort = getEnvString(env, "METRICS_PORT", "2112")
 	config.MetricsPassword = getEnvString(env, "METRICS_PASSWORD", "")
 
-	// Parse remote builders
+	// Parse remote builders, dropping entries that differ only in spelling
+	// (e.g. with and without http://) so no builder is counted twice.
 	if builders := getEnvString(env, "REMOTE_BUILDERS", ""); builders != "" {
-		config.RemoteBuilders = strings.Split(builders, ",")
-		for i := range config.RemoteBuilders {
-			config.RemoteBuilders[i] = strings.TrimSpace(config.RemoteBuilders[i])
-		}
+		config.RemoteBuilders, config.duplicateBuilders = DedupeBuilders(strings.Split(builders, ","))
 	}
+	config.TreeStaleAfter = getEnvDuration(env, "TREE_STALE_AFTER", 72*time.Hour)
+	config.TreeRefuseAfter = getEnvDuration(env, "TREE_REFUSE_AFTER", 0)
 
 	// Security settings
 	config.APIKey = getEnvString(env, "API_KEY", "")
 	config.BuilderToken = getEnvString(env, "BUILDER_TOKEN", "")
+	config.JWTSecret = getEnvString(env, "JWT_SECRET", "")
 	config.CORSAllowedOrigins = getEnvStringSlice(env, "CORS_ALLOWED_ORIGINS", nil)
 	config.MaxRequestBodyBytes = int64(getEnvInt(env, "MAX_REQUEST_BODY_BYTES", 10*1024*1024)) // Default 10MB
+	config.RateLimitPerMinute = getEnvInt(env, "RATE_LIMIT_PER_MINUTE", 60)
+	config.RateLimitBurst = getEnvInt(env, "RATE_LIMIT_BURST", 20)
+	config.FeaturesDenylist = getEnvStringSlice(env, "FEATURES_DENYLIST", defaultFeaturesDenylist)
+	config.AdminAPIKey = getEnvString(env, "ADMIN_API_KEY", "")
+	config.EphemeralArtifactTTL = getEnvInt(env, "EPHEMERAL_ARTIFACT_TTL", 30)
+	config.EphemeralCallbackHosts = getEnvStringSlice(env, "EPHEMERAL_CALLBACK_HOSTS", nil)
+	config.BuildStatsWindow = getEnvDuration(env, "BUILD_STATS_WINDOW", 30*24*time.Hour)
 	config.DataDir = getEnvString(env, "DATA_DIR", "/var/lib/portage-engine/server")
 
+	if err := applyEnvOverrides(ServerEnvPrefix, config); err != nil {
+		return nil, err
+	}
+	if _, ok := os.LookupEnv(ServerEnvPrefix + "REMOTE_BUILDERS"); ok {
+		config.RemoteBuilders, config.duplicateBuilders = DedupeBuilders(config.RemoteBuilders)
+	}
+	if len(config.FeaturesDenylist) == 1 && config.FeaturesDenylist[0] == "none" {
+		config.FeaturesDenylist = nil
+	}
 	return config, nil
 }
 
@@ -497,6 +921,7 @@ func LoadDashboardConfig(path string) (*DashboardConfig, error) {
 	config.JWTSecret = getEnvString(env, "JWT_SECRET", config.JWTSecret)
 	config.AdminUser = getEnvString(env, "ADMIN_USER", "")
 	config.AdminPassword = getEnvString(env, "ADMIN_PASSWORD", "")
+	config.UsersFile = getEnvString(env, "USERS_FILE", "")
 	config.TokenTTLMinutes = getEnvInt(env, "TOKEN_TTL_MINUTES", 720)
 	config.AllowAnonymous = getEnvBool(env, "ALLOW_ANONYMOUS", config.AllowAnonymous)
 
@@ -504,6 +929,14 @@ func LoadDashboardConfig(path string) (*DashboardConfig, error) {
 	config.MetricsPort = getEnvString(env, "METRICS_PORT", "2112")
 	config.MetricsPassword = getEnvString(env, "METRICS_PASSWORD", "")
 
+	config.APITimeout = getEnvDuration(env, "API_TIMEOUT", 10*time.Second)
+	config.DownloadTimeout = getEnvDuration(env, "DOWNLOAD_TIMEOUT", 0)
+	config.DownloadIdleTimeout = getEnvDuration(env, "DOWNLOAD_IDLE_TIMEOUT", time.Minute)
+	config.MaxServerConns = getEnvInt(env, "SERVER_MAX_CONNS", 32)
+
+	if err := applyEnvOverrides(DashboardEnvPrefix, config); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
@@ -544,6 +977,9 @@ func LoadBuilderConfig(path string) (*BuilderConfig, error) {
 
 	config.Port = getEnvInt(env, "BUILDER_PORT", config.Port)
 	config.AuthToken = getEnvString(env, "BUILDER_TOKEN", "")
+	config.AdminToken = getEnvString(env, "BUILDER_ADMIN_TOKEN", "")
+	config.RateLimitPerMinute = getEnvInt(env, "BUILDER_RATE_LIMIT_PER_MINUTE", 60)
+	config.RateLimitBurst = getEnvInt(env, "BUILDER_RATE_LIMIT_BURST", 20)
 	config.Workers = getEnvInt(env, "BUILDER_WORKERS", config.Workers)
 	config.InstanceID = getEnvString(env, "INSTANCE_ID", "")
 	config.Architecture = getEnvString(env, "ARCHITECTURE", "")
@@ -554,21 +990,70 @@ func LoadBuilderConfig(path string) (*BuilderConfig, error) {
 	config.ArtifactDir = getEnvString(env, "BUILD_ARTIFACT_DIR", config.ArtifactDir)
 	config.DataDir = getEnvString(env, "DATA_DIR", config.DataDir)
 	config.PersistenceEnabled = getEnvBool(env, "PERSISTENCE_ENABLED", config.PersistenceEnabled)
+	config.JobStore = getEnvString(env, "JOB_STORE", "json")
 	config.RetentionDays = getEnvInt(env, "RETENTION_DAYS", config.RetentionDays)
+	config.JobRetention = getEnvDuration(env, "JOB_RETENTION", time.Duration(config.RetentionDays)*24*time.Hour)
+	config.JobRetentionMaxJobs = getEnvInt(env, "JOB_RETENTION_MAX_JOBS", 0)
+	config.PruneArtifacts = getEnvBool(env, "PRUNE_ARTIFACTS", false)
+	config.UploadedArtifactRetention = getEnvDuration(env, "UPLOADED_ARTIFACT_RETENTION", 0)
+	config.JobPruneInterval = getEnvDuration(env, "JOB_PRUNE_INTERVAL", time.Hour)
 
 	config.GPGEnabled = getEnvBool(env, "GPG_ENABLED", config.GPGEnabled)
 	config.GPGKeyID = getEnvString(env, "GPG_KEY_ID", "")
 	config.GPGKeyPath = getEnvString(env, "GPG_KEY_PATH", "")
 	config.GPGAutoSync = getEnvBool(env, "GPG_AUTO_SYNC", false)
+	config.GPGSyncRetries = getEnvInt(env, "GPG_SYNC_RETRIES", 5)
+	config.GPGSyncBackoff = getEnvInt(env, "GPG_SYNC_BACKOFF", 2)
+	config.GPGExpiryWarnDays = getEnvInt(env, "GPG_EXPIRY_WARN_DAYS", 30)
 	config.GPGHome = getEnvString(env, "GPG_HOME", "/var/lib/portage-engine/gpg")
 	config.BinpkgFormat = getEnvString(env, "BINPKG_FORMAT", config.BinpkgFormat)
 	config.BuildFeatures = getEnvString(env, "BUILD_FEATURES", "-userpriv -usersandbox")
+	config.SeparateFetch = getEnvBool(env, "BUILD_SEPARATE_FETCH", false)
+	config.DefaultBuildTimeout = getEnvDuration(env, "BUILD_TIMEOUT", 2*time.Hour)
+	config.ShutdownGracePeriod = getEnvDuration(env, "SHUTDOWN_GRACE_PERIOD", 5*time.Minute)
+	config.MinFreeDiskGB = getEnvInt(env, "MIN_FREE_DISK_GB", 5)
+	config.DiskSpaceAction = getEnvString(env, "DISK_SPACE_ACTION", "fail")
+	config.DiskHighWatermark = getEnvInt(env, "DISK_HIGH_WATERMARK", 90)
+	config.MemoryHeadroomGB = getEnvFloat(env, "MEMORY_HEADROOM_GB", 1)
+	config.BuildMemoryGB = getEnvFloat(env, "BUILD_MEMORY_GB", 2)
+	config.PackageMemoryGB = getEnvFloatMap(env, "PACKAGE_MEMORY_GB", defaultPackageMemoryGB)
+	config.ArtifactWaitTimeout = getEnvDuration(env, "ARTIFACT_WAIT_TIMEOUT", 30*time.Second)
+	config.ArtifactDebug = getEnvBool(env, "ARTIFACT_DEBUG", false)
+	config.ArtifactCompression = getEnvString(env, "ARTIFACT_COMPRESSION", "none")
+	config.ArtifactCompressionLevel = getEnvInt(env, "ARTIFACT_COMPRESSION_LEVEL", 0)
+	config.ReuseIdenticalBuilds = getEnvBool(env, "REUSE_IDENTICAL_BUILDS", true)
+	config.BuildResultCache = getEnvBool(env, "BUILD_RESULT_CACHE", true)
+	config.EnableQEMU = getEnvBool(env, "ENABLE_QEMU", false)
+	config.CrossArchImage = getEnvString(env, "CROSS_ARCH_IMAGE", "")
+	config.MaxBuildRetries = getEnvInt(env, "MAX_BUILD_RETRIES", 0)
+	config.BuildRetryPatterns = getEnvStringSlice(env, "BUILD_RETRY_PATTERNS", defaultBuildRetryPatterns)
+	config.BuildRetryBackoff = getEnvDuration(env, "BUILD_RETRY_BACKOFF", 30*time.Second)
+	config.LogRedactPatterns = getEnvStringSlice(env, "LOG_REDACT_PATTERNS", defaultLogRedactPatterns)
+	config.TreeSyncMaxAge = getEnvDuration(env, "TREE_SYNC_MAX_AGE", 0)
+	config.TreeSyncInterval = getEnvDuration(env, "TREE_SYNC_INTERVAL", 0)
+	config.SpotInterruptionCheck = getEnvBool(env, "SPOT_INTERRUPTION_CHECK", false)
+	config.CCacheEnabled = getEnvBool(env, "CCACHE_ENABLED", false)
+	config.CCacheDir = getEnvString(env, "CCACHE_DIR", "/var/cache/ccache")
+	// Space-separated like distcc's own DISTCC_HOSTS: host specs may carry
+	// comma-separated options.
+	config.DistccHosts = strings.Fields(getEnvString(env, "DISTCC_HOSTS", ""))
+	config.MakeOpts = getEnvString(env, "MAKEOPTS", "")
+	config.EmergeJobs = getEnvString(env, "EMERGE_JOBS", "")
+	config.AcceptLicense = getEnvString(env, "ACCEPT_LICENSE", "")
+	config.SimulationMode = getEnvBool(env, "SIMULATION_MODE", false)
+	config.SimulationDelay = getEnvDuration(env, "SIMULATION_DELAY", 10*time.Second)
+	config.SimulationFailureRate = getEnvFloat(env, "SIMULATION_FAILURE_RATE", 0)
 
 	config.StorageType = getEnvString(env, "STORAGE_TYPE", config.StorageType)
 	config.StorageLocalDir = getEnvString(env, "STORAGE_LOCAL_DIR", config.StorageLocalDir)
 	config.StorageS3Bucket = getEnvString(env, "STORAGE_S3_BUCKET", "")
 	config.StorageS3Region = getEnvString(env, "STORAGE_S3_REGION", "")
 	config.StorageS3Prefix = getEnvString(env, "STORAGE_S3_PREFIX", "")
+	config.StorageS3Endpoint = getEnvString(env, "STORAGE_S3_ENDPOINT", "")
+	config.StorageS3AccessKey = getEnvString(env, "AWS_ACCESS_KEY_ID", "")
+	config.StorageS3SecretKey = getEnvString(env, "AWS_SECRET_ACCESS_KEY", "")
+	config.S3MultipartThreshold = int64(getEnvInt(env, "STORAGE_S3_MULTIPART_THRESHOLD_MB", 100)) << 20
+	config.S3UploadConcurrency = getEnvInt(env, "STORAGE_S3_UPLOAD_CONCURRENCY", 4)
 	config.StorageHTTPBase = getEnvString(env, "STORAGE_HTTP_BASE", "")
 
 	config.ServerURL = getEnvString(env, "SERVER_URL", "")
@@ -589,9 +1074,49 @@ func LoadBuilderConfig(path string) (*BuilderConfig, error) {
 	config.MetricsPort = getEnvString(env, "METRICS_PORT", "2112")
 	config.MetricsPassword = getEnvString(env, "METRICS_PASSWORD", "")
 
+	if err := applyEnvOverrides(BuilderEnvPrefix, config); err != nil {
+		return nil, err
+	}
+	if len(config.LogRedactPatterns) == 1 && config.LogRedactPatterns[0] == "none" {
+		config.LogRedactPatterns = nil
+	}
 	return config, nil
 }
 
+// getEnvFloatMap reads comma-separated KEY=NUMBER entries from the env map.
+// Returns defaultValue if the key is empty or an entry is malformed.
+func getEnvFloatMap(env map[string]string, key string, defaultValue map[string]float64) map[string]float64 {
+	raw := getEnvString(env, key, "")
+	if raw == "" {
+		return defaultValue
+	}
+	m, err := parseFloatMap(raw)
+	if err != nil {
+		return defaultValue
+	}
+	return m
+}
+
+// parseFloatMap parses comma-separated KEY=NUMBER entries.
+func parseFloatMap(raw string) (map[string]float64, error) {
+	m := map[string]float64{}
+	for _, entry := range strings.Split(raw, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("entry %q is not KEY=NUMBER", entry)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q is not KEY=NUMBER", entry)
+		}
+		m[strings.TrimSpace(k)] = f
+	}
+	return m, nil
+}
+
 // getEnvStringSlice reads a comma-separated string from the env map and returns
 // it as a trimmed slice. Returns defaultValue if the key is empty.
 func getEnvStringSlice(env map[string]string, key string, defaultValue []string) []string {
@@ -2,7 +2,12 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestLoadServerConfig tests loading server configuration.
@@ -85,6 +90,7 @@ SERVER_URL=http://test-server:8080
 AUTH_ENABLED=false
 JWT_SECRET=test-secret
 ALLOW_ANONYMOUS=false
+DOWNLOAD_TIMEOUT=2h
 `
 
 	if err := os.WriteFile(tmpFile, []byte(configData), 0600); err != nil {
@@ -116,6 +122,10 @@ ALLOW_ANONYMOUS=false
 	if cfg.AllowAnonymous {
 		t.Error("Expected AllowAnonymous=false, got true")
 	}
+
+	if cfg.APITimeout != 10*time.Second || cfg.DownloadTimeout != 2*time.Hour || cfg.DownloadIdleTimeout != time.Minute || cfg.MaxServerConns != 32 {
+		t.Errorf("HTTP client settings: API %s, download %s, max conns %d", cfg.APITimeout, cfg.DownloadTimeout, cfg.MaxServerConns)
+	}
 }
 
 // TestLoadBuilderConfig tests loading builder configuration.
@@ -188,6 +198,23 @@ NOTIFY_CONFIG=/path/to/notify.json
 	if cfg.NotifyConfig != "/path/to/notify.json" {
 		t.Errorf("Expected NotifyConfig=/path/to/notify.json, got %s", cfg.NotifyConfig)
 	}
+
+	if !cfg.ReuseIdenticalBuilds {
+		t.Error("Expected ReuseIdenticalBuilds=true by default")
+	}
+	if !cfg.BuildResultCache {
+		t.Error("Expected BuildResultCache=true by default")
+	}
+	if cfg.ShutdownGracePeriod != 5*time.Minute {
+		t.Errorf("Expected ShutdownGracePeriod=5m by default, got %s", cfg.ShutdownGracePeriod)
+	}
+	if cfg.MinFreeDiskGB != 5 || cfg.DiskSpaceAction != "fail" || cfg.DiskHighWatermark != 90 {
+		t.Errorf("disk space defaults: min %d GB, action %q, watermark %d%%", cfg.MinFreeDiskGB, cfg.DiskSpaceAction, cfg.DiskHighWatermark)
+	}
+
+	if cfg.MaxBuildRetries != 0 || len(cfg.BuildRetryPatterns) == 0 || cfg.BuildRetryBackoff != 30*time.Second {
+		t.Errorf("build retry defaults: retries %d, %d patterns, backoff %s", cfg.MaxBuildRetries, len(cfg.BuildRetryPatterns), cfg.BuildRetryBackoff)
+	}
 }
 
 // TestLoadConfigDefaults tests that default values are used when config file doesn't exist.
@@ -383,3 +410,468 @@ func TestLoadEnvFileStripsQuotes(t *testing.T) {
 		t.Errorf("DATA_DIR = %q, want single-quoted", env["DATA_DIR"])
 	}
 }
+
+func TestLoadServerConfigFeaturesDenylist(t *testing.T) {
+	cfg, err := LoadServerConfig("/nonexistent/path/server.conf")
+	if err != nil {
+		t.Fatalf("LoadServerConfig failed: %v", err)
+	}
+	if len(cfg.FeaturesDenylist) == 0 || cfg.FeaturesDenylist[0] != "-sandbox" {
+		t.Errorf("default FeaturesDenylist = %v, want the built-in denylist", cfg.FeaturesDenylist)
+	}
+
+	t.Setenv("FEATURES_DENYLIST", "none")
+	cfg, err = LoadServerConfig("/nonexistent/path/server.conf")
+	if err != nil {
+		t.Fatalf("LoadServerConfig failed: %v", err)
+	}
+	if cfg.FeaturesDenylist != nil {
+		t.Errorf("FEATURES_DENYLIST=none gave %v, want no denylist", cfg.FeaturesDenylist)
+	}
+}
+
+func TestLoadBuilderConfigLogRedactPatterns(t *testing.T) {
+	cfg, err := LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if len(cfg.LogRedactPatterns) != len(defaultLogRedactPatterns) {
+		t.Errorf("default LogRedactPatterns = %v, want the built-in patterns", cfg.LogRedactPatterns)
+	}
+	for _, w := range cfg.Validate() {
+		if strings.Contains(w, "LOG_REDACT_PATTERNS") {
+			t.Errorf("built-in patterns flagged invalid: %s", w)
+		}
+	}
+
+	t.Setenv("LOG_REDACT_PATTERNS", `token=\S+,(unclosed`)
+	cfg, err = LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	invalid := 0
+	for _, w := range cfg.Validate() {
+		if strings.Contains(w, "LOG_REDACT_PATTERNS") {
+			invalid++
+		}
+	}
+	if len(cfg.LogRedactPatterns) != 2 || invalid != 1 {
+		t.Errorf("LogRedactPatterns = %v with %d invalid, want 2 patterns with 1 invalid", cfg.LogRedactPatterns, invalid)
+	}
+
+	t.Setenv("LOG_REDACT_PATTERNS", "none")
+	cfg, err = LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if cfg.LogRedactPatterns != nil {
+		t.Errorf("LOG_REDACT_PATTERNS=none gave %v, want no patterns", cfg.LogRedactPatterns)
+	}
+}
+
+func TestLoadBuilderConfigDistccHosts(t *testing.T) {
+	t.Setenv("DISTCC_HOSTS", " 10.0.0.21/8  helper:4000/4,lzo ")
+	cfg, err := LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if want := []string{"10.0.0.21/8", "helper:4000/4,lzo"}; !slices.Equal(cfg.DistccHosts, want) {
+		t.Errorf("DistccHosts = %q, want %q", cfg.DistccHosts, want)
+	}
+}
+
+func TestLoadBuilderConfigS3Multipart(t *testing.T) {
+	cfg, err := LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if cfg.S3MultipartThreshold != 100<<20 || cfg.S3UploadConcurrency != 4 {
+		t.Errorf("defaults = %d bytes, %d parts, want 100 MiB, 4", cfg.S3MultipartThreshold, cfg.S3UploadConcurrency)
+	}
+
+	t.Setenv("STORAGE_S3_MULTIPART_THRESHOLD_MB", "512")
+	t.Setenv("STORAGE_S3_UPLOAD_CONCURRENCY", "8")
+	cfg, err = LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if cfg.S3MultipartThreshold != 512<<20 || cfg.S3UploadConcurrency != 8 {
+		t.Errorf("got %d bytes, %d parts, want 512 MiB, 8", cfg.S3MultipartThreshold, cfg.S3UploadConcurrency)
+	}
+}
+
+func TestLoadBuilderConfigArtifactCompression(t *testing.T) {
+	cfg, err := LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if cfg.ArtifactCompression != "none" || cfg.ArtifactCompressionLevel != 0 {
+		t.Errorf("defaults = %q level %d, want none level 0", cfg.ArtifactCompression, cfg.ArtifactCompressionLevel)
+	}
+
+	t.Setenv("ARTIFACT_COMPRESSION", "zstd")
+	t.Setenv("ARTIFACT_COMPRESSION_LEVEL", "19")
+	cfg, err = LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if cfg.ArtifactCompression != "zstd" || cfg.ArtifactCompressionLevel != 19 {
+		t.Errorf("got %q level %d, want zstd level 19", cfg.ArtifactCompression, cfg.ArtifactCompressionLevel)
+	}
+
+	cfg.ArtifactCompression = "xz"
+	found := false
+	for _, w := range cfg.Validate() {
+		if strings.Contains(w, "ARTIFACT_COMPRESSION") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Validate() did not warn about an unknown ARTIFACT_COMPRESSION")
+	}
+}
+
+func TestLoadBuilderConfigJobControl(t *testing.T) {
+	t.Setenv("MAKEOPTS", "-j4 -l6.5")
+	t.Setenv("EMERGE_JOBS", "--jobs=2 --load-average=6")
+	cfg, err := LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if cfg.MakeOpts != "-j4 -l6.5" || cfg.EmergeJobs != "--jobs=2 --load-average=6" {
+		t.Errorf("got MakeOpts %q, EmergeJobs %q", cfg.MakeOpts, cfg.EmergeJobs)
+	}
+	for _, w := range cfg.Validate() {
+		if strings.Contains(w, "MAKEOPTS") || strings.Contains(w, "EMERGE_JOBS") {
+			t.Errorf("unexpected warning: %s", w)
+		}
+	}
+
+	cfg.MakeOpts = "-j4; rm -rf /"
+	cfg.EmergeJobs = "--jobs=2 --usepkg"
+	var warned []string
+	for _, w := range cfg.Validate() {
+		if strings.Contains(w, "MAKEOPTS") || strings.Contains(w, "EMERGE_JOBS") {
+			warned = append(warned, w)
+		}
+	}
+	if len(warned) != 2 {
+		t.Errorf("Validate() warnings = %v, want one each for MAKEOPTS and EMERGE_JOBS", warned)
+	}
+}
+
+func TestLoadBuilderConfigMemoryAdmission(t *testing.T) {
+	cfg, err := LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if cfg.MemoryHeadroomGB != 1 || cfg.BuildMemoryGB != 2 || cfg.PackageMemoryGB["www-client/chromium"] != 16 {
+		t.Errorf("defaults = headroom %v, build %v, packages %v", cfg.MemoryHeadroomGB, cfg.BuildMemoryGB, cfg.PackageMemoryGB)
+	}
+
+	t.Setenv("MEMORY_HEADROOM_GB", "2.5")
+	t.Setenv("PACKAGE_MEMORY_GB", "www-client/chromium=24, dev-qt/qtwebengine = 16")
+	cfg, err = LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	want := map[string]float64{"www-client/chromium": 24, "dev-qt/qtwebengine": 16}
+	if cfg.MemoryHeadroomGB != 2.5 || !reflect.DeepEqual(cfg.PackageMemoryGB, want) {
+		t.Errorf("got headroom %v, packages %v", cfg.MemoryHeadroomGB, cfg.PackageMemoryGB)
+	}
+
+	t.Setenv("PORTAGE_BUILDER_PACKAGE_MEMORY_GB", "www-client/chromium=32")
+	cfg, err = LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.PackageMemoryGB, map[string]float64{"www-client/chromium": 32}) {
+		t.Errorf("prefixed override = %v", cfg.PackageMemoryGB)
+	}
+	t.Setenv("PORTAGE_BUILDER_PACKAGE_MEMORY_GB", "www-client/chromium")
+	if _, err := LoadBuilderConfig("/nonexistent/path/builder.conf"); err == nil {
+		t.Error("a malformed PORTAGE_BUILDER_PACKAGE_MEMORY_GB was accepted")
+	}
+}
+
+func TestLoadBuilderConfigSimulation(t *testing.T) {
+	cfg, err := LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if cfg.SimulationMode || cfg.SimulationDelay != 10*time.Second || cfg.SimulationFailureRate != 0 {
+		t.Errorf("defaults = mode %v, delay %s, failure rate %v", cfg.SimulationMode, cfg.SimulationDelay, cfg.SimulationFailureRate)
+	}
+
+	t.Setenv("SIMULATION_MODE", "true")
+	t.Setenv("SIMULATION_DELAY", "2s")
+	t.Setenv("SIMULATION_FAILURE_RATE", "1.5")
+	cfg, err = LoadBuilderConfig("/nonexistent/path/builder.conf")
+	if err != nil {
+		t.Fatalf("LoadBuilderConfig failed: %v", err)
+	}
+	if !cfg.SimulationMode || cfg.SimulationDelay != 2*time.Second || cfg.SimulationFailureRate != 1.5 {
+		t.Errorf("got mode %v, delay %s, failure rate %v", cfg.SimulationMode, cfg.SimulationDelay, cfg.SimulationFailureRate)
+	}
+	var modeWarned, rateWarned bool
+	for _, w := range cfg.Validate() {
+		modeWarned = modeWarned || strings.Contains(w, "SIMULATION_MODE is on")
+		rateWarned = rateWarned || strings.Contains(w, "SIMULATION_FAILURE_RATE")
+	}
+	if !modeWarned || !rateWarned {
+		t.Errorf("expected warnings for simulation mode and the failure rate, got mode %v, rate %v", modeWarned, rateWarned)
+	}
+}
+
+func TestCanonicalBuilderURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"builder1:9090":          "http://builder1:9090",
+		"http://Builder1:9090/":  "http://builder1:9090",
+		" https://b.example:443": "https://b.example",
+		"http://b.example:80":    "http://b.example",
+		"https://b.example/api/": "https://b.example/api",
+	} {
+		if got := CanonicalBuilderURL(in); got != want {
+			t.Errorf("CanonicalBuilderURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoadServerConfigDedupesRemoteBuilders(t *testing.T) {
+	t.Setenv("REMOTE_BUILDERS", "builder1:9090, http://builder1:9090/,builder2:9090,,HTTP://BUILDER2:9090")
+	cfg, err := LoadServerConfig("/nonexistent/path/server.conf")
+	if err != nil {
+		t.Fatalf("LoadServerConfig failed: %v", err)
+	}
+	if len(cfg.RemoteBuilders) != 2 || cfg.RemoteBuilders[0] != "builder1:9090" || cfg.RemoteBuilders[1] != "builder2:9090" {
+		t.Errorf("RemoteBuilders = %q, want the first spelling of each builder", cfg.RemoteBuilders)
+	}
+
+	var dupWarnings int
+	for _, w := range cfg.Validate() {
+		if strings.Contains(w, "REMOTE_BUILDERS") {
+			dupWarnings++
+		}
+	}
+	if dupWarnings != 2 {
+		t.Errorf("got %d duplicate builder warnings, want 2", dupWarnings)
+	}
+}
+
+func TestLoadServerConfigSchedulingStrategy(t *testing.T) {
+	cfg, err := LoadServerConfig("/nonexistent/path/server.conf")
+	if err != nil {
+		t.Fatalf("LoadServerConfig failed: %v", err)
+	}
+	if cfg.SchedulingStrategy != SchedulingRoundRobin {
+		t.Errorf("default SchedulingStrategy = %q, want %q", cfg.SchedulingStrategy, SchedulingRoundRobin)
+	}
+
+	t.Setenv("SCHEDULING_STRATEGY", "random")
+	cfg, err = LoadServerConfig("/nonexistent/path/server.conf")
+	if err != nil {
+		t.Fatalf("LoadServerConfig failed: %v", err)
+	}
+	var warned bool
+	for _, w := range cfg.Validate() {
+		warned = warned || strings.Contains(w, "SCHEDULING_STRATEGY")
+	}
+	if !warned {
+		t.Error("expected a warning for an unknown strategy")
+	}
+}
+
+func TestLoadServerConfigTreeFreshness(t *testing.T) {
+	cfg, err := LoadServerConfig("/nonexistent/path/server.conf")
+	if err != nil {
+		t.Fatalf("LoadServerConfig failed: %v", err)
+	}
+	if cfg.TreeStaleAfter != 72*time.Hour || cfg.TreeRefuseAfter != 0 {
+		t.Errorf("defaults: TreeStaleAfter = %s, TreeRefuseAfter = %s", cfg.TreeStaleAfter, cfg.TreeRefuseAfter)
+	}
+
+	t.Setenv("TREE_STALE_AFTER", "48h")
+	t.Setenv("TREE_REFUSE_AFTER", "24h")
+	cfg, err = LoadServerConfig("/nonexistent/path/server.conf")
+	if err != nil {
+		t.Fatalf("LoadServerConfig failed: %v", err)
+	}
+	if cfg.TreeStaleAfter != 48*time.Hour || cfg.TreeRefuseAfter != 24*time.Hour {
+		t.Errorf("TreeStaleAfter = %s, TreeRefuseAfter = %s", cfg.TreeStaleAfter, cfg.TreeRefuseAfter)
+	}
+	var warned bool
+	for _, w := range cfg.Validate() {
+		warned = warned || strings.Contains(w, "TREE_REFUSE_AFTER")
+	}
+	if !warned {
+		t.Error("expected a warning for TREE_REFUSE_AFTER below TREE_STALE_AFTER")
+	}
+}
+
+func TestLoadServerConfigBuildStatsWindow(t *testing.T) {
+	cfg, err := LoadServerConfig("/nonexistent/path/server.conf")
+	if err != nil {
+		t.Fatalf("LoadServerConfig failed: %v", err)
+	}
+	if cfg.BuildStatsWindow != 30*24*time.Hour {
+		t.Errorf("default BuildStatsWindow = %s, want 720h", cfg.BuildStatsWindow)
+	}
+
+	t.Setenv("BUILD_STATS_WINDOW", "168h")
+	cfg, err = LoadServerConfig("/nonexistent/path/server.conf")
+	if err != nil {
+		t.Fatalf("LoadServerConfig failed: %v", err)
+	}
+	if cfg.BuildStatsWindow != 168*time.Hour {
+		t.Errorf("BuildStatsWindow = %s, want 168h", cfg.BuildStatsWindow)
+	}
+}
+
+func TestLoadServerConfigStateBackend(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.conf")
+	if err := os.WriteFile(path, []byte("STATE_BACKEND_S3_BUCKET=tf-state\nSTATE_BACKEND_S3_REGION=eu-west-1\nSTATE_BACKEND_OSS_BUCKET=oss-state\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadServerConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb := cfg.StateBackend
+	if sb.Prefix != "portage-engine" || sb.LockTimeout != 5*time.Minute || sb.GCSBucket != "" {
+		t.Errorf("defaults: %+v", sb)
+	}
+	if sb.S3Bucket != "tf-state" || sb.S3Region != "eu-west-1" || sb.OSSBucket != "oss-state" {
+		t.Errorf("file values: %+v", sb)
+	}
+	var s3Warned, ossWarned bool
+	for _, w := range cfg.Validate() {
+		s3Warned = s3Warned || strings.Contains(w, "STATE_BACKEND_S3_DYNAMODB_TABLE")
+		ossWarned = ossWarned || strings.Contains(w, "STATE_BACKEND_OSS_TABLESTORE")
+	}
+	if !s3Warned || !ossWarned {
+		t.Errorf("expected warnings for the unlocked S3 and OSS backends, got S3 %v, OSS %v", s3Warned, ossWarned)
+	}
+
+	t.Setenv("STATE_BACKEND_LOCK_TIMEOUT", "90s")
+	t.Setenv("PORTAGE_SERVER_STATE_BACKEND_S3_BUCKET", "shared-state")
+	t.Setenv("PORTAGE_SERVER_STATE_BACKEND_S3_DYNAMODB_TABLE", "tf-locks")
+	cfg, err = LoadServerConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb = cfg.StateBackend
+	if sb.LockTimeout != 90*time.Second || sb.S3Bucket != "shared-state" || sb.S3DynamoDBTable != "tf-locks" {
+		t.Errorf("overrides: %+v", sb)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for field, want := range map[string]string{
+		"Port":                 "PORT",
+		"GPGKeyID":             "GPG_KEY_ID",
+		"CORSAllowedOrigins":   "CORS_ALLOWED_ORIGINS",
+		"StorageS3Bucket":      "STORAGE_S3_BUCKET",
+		"S3MultipartThreshold": "S3_MULTIPART_THRESHOLD",
+		"CloudGCPDiskSizeGB":   "CLOUD_GCP_DISK_SIZE_GB",
+		"EnableQEMU":           "ENABLE_QEMU",
+	} {
+		if got := envName(field); got != want {
+			t.Errorf("envName(%s) = %s, want %s", field, got, want)
+		}
+	}
+}
+
+func TestLoadConfigPrefixedEnvOverrides(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "builder.conf")
+	if err := os.WriteFile(path, []byte("BUILDER_PORT=9100\nBUILDER_WORKERS=4\nBUILD_WORK_DIR=/srv/work\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BUILDER_WORKERS", "6")
+	t.Setenv("PORTAGE_BUILDER_WORKERS", "8")
+	t.Setenv("PORTAGE_BUILDER_USE_DOCKER", "false")
+	t.Setenv("PORTAGE_BUILDER_SHUTDOWN_GRACE_PERIOD", "90s")
+	t.Setenv("PORTAGE_BUILDER_DISTCC_HOSTS", "10.0.0.21/8 helper:4000/4,lzo")
+	t.Setenv("PORTAGE_BUILDER_LOG_REDACT_PATTERNS", "none")
+
+	cfg, err := LoadBuilderConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 9100 || cfg.WorkDir != "/srv/work" {
+		t.Errorf("file values lost: port %d, work dir %s", cfg.Port, cfg.WorkDir)
+	}
+	if cfg.Workers != 8 || cfg.UseDocker || cfg.ShutdownGracePeriod != 90*time.Second {
+		t.Errorf("prefixed overrides not applied: workers %d, docker %v, grace %s", cfg.Workers, cfg.UseDocker, cfg.ShutdownGracePeriod)
+	}
+	if len(cfg.DistccHosts) != 2 || cfg.DistccHosts[1] != "helper:4000/4,lzo" {
+		t.Errorf("DistccHosts = %q, want the two whitespace-separated hosts", cfg.DistccHosts)
+	}
+	if cfg.LogRedactPatterns != nil {
+		t.Errorf("LogRedactPatterns = %q, want none", cfg.LogRedactPatterns)
+	}
+
+	t.Setenv("PORTAGE_SERVER_PORT", "8181")
+	t.Setenv("PORTAGE_SERVER_REMOTE_BUILDERS", "builder1:9090,http://builder1:9090")
+	t.Setenv("PORTAGE_SERVER_CLOUD_AWS_AMI", "ami-123")
+	srv, err := LoadServerConfig(filepath.Join(dir, "missing.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Port != 8181 || len(srv.RemoteBuilders) != 1 || srv.CloudAWSAMI != "ami-123" {
+		t.Errorf("server overrides: port %d, builders %q, AMI %q", srv.Port, srv.RemoteBuilders, srv.CloudAWSAMI)
+	}
+
+	t.Setenv("PORTAGE_DASHBOARD_API_TIMEOUT", "soon")
+	if _, err := LoadDashboardConfig(filepath.Join(dir, "missing.conf")); err == nil || !strings.Contains(err.Error(), "PORTAGE_DASHBOARD_API_TIMEOUT") {
+		t.Errorf("LoadDashboardConfig() with an invalid override error = %v", err)
+	}
+}
+
+func TestServerConfigReload(t *testing.T) {
+	running := &ServerConfig{Port: 8080, MaxWorkers: 5, RemoteBuilders: []string{"b1:9090"}, RateLimitPerMinute: 60}
+	next := *running
+	next.Port = 9000
+	next.MaxWorkers = 8
+	next.RemoteBuilders = []string{"b1:9090", "b2:9090"}
+	next.SchedulingStrategy = SchedulingLeastLoaded
+	next.RateLimitPerMinute = 10
+
+	if got := running.RestartRequired(&next); !slices.Equal(got, []string{"Port", "MaxWorkers"}) {
+		t.Errorf("RestartRequired = %v, want [Port MaxWorkers]", got)
+	}
+	if err := next.CheckReload(); err != nil {
+		t.Errorf("CheckReload: %v", err)
+	}
+	for _, bad := range []func(c *ServerConfig){
+		func(c *ServerConfig) { c.SchedulingStrategy = "fastest" },
+		func(c *ServerConfig) { c.RateLimitBurst = -1 },
+		func(c *ServerConfig) { c.Port = 0 },
+	} {
+		c := next
+		bad(&c)
+		if err := c.CheckReload(); err == nil {
+			t.Errorf("CheckReload accepted %+v", c)
+		}
+	}
+}
+
+func TestBuilderConfigReload(t *testing.T) {
+	running := &BuilderConfig{Port: 9090, Workers: 2, NotifyConfig: "a.json"}
+	next := *running
+	next.Workers = 4
+	next.NotifyConfig = "b.json"
+	if got := running.RestartRequired(&next); !slices.Equal(got, []string{"Workers"}) {
+		t.Errorf("RestartRequired = %v, want [Workers]", got)
+	}
+	next.Workers = 0
+	if err := next.CheckReload(); err == nil {
+		t.Error("CheckReload accepted 0 workers")
+	}
+	next.Workers = 4
+	next.RateLimitBurst = -1
+	if err := next.CheckReload(); err == nil {
+		t.Error("CheckReload accepted a negative rate limit burst")
+	}
+}
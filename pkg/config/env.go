@@ -0,0 +1,154 @@
+// Package config provides the prefixed environment variable overrides.
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Prefixes of the environment variables that override any config field,
+// e.g. PORTAGE_SERVER_PORT or PORTAGE_BUILDER_WORKERS.
+const (
+	ServerEnvPrefix    = "PORTAGE_SERVER_"
+	BuilderEnvPrefix   = "PORTAGE_BUILDER_"
+	DashboardEnvPrefix = "PORTAGE_DASHBOARD_"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	floatMapType = reflect.TypeOf(map[string]float64(nil))
+)
+
+// applyEnvOverrides sets every exported field of the struct cfg points to
+// from the environment variable prefix+NAME, when it is set. NAME is the
+// field's name in upper snake case (GPGKeyID is GPG_KEY_ID) unless an
+// `env:"NAME"` tag gives it; `env:"-"` leaves the field out. The fields of a
+// struct field are set from prefix+NAME_FIELD. Slices are comma-separated,
+// or whitespace-separated with the `env:",spaces"` option; maps of numbers
+// are comma-separated KEY=NUMBER entries; durations are Go durations such as
+// "90s". Overrides are applied after the config file, so they win over it
+// and over the unprefixed variables.
+func applyEnvOverrides(prefix string, cfg interface{}) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("env"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = envName(field.Name)
+		}
+		key := prefix + name
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnvOverrides(key+"_", v.Field(i).Addr().Interface()); err != nil {
+				return err
+			}
+			continue
+		}
+		raw, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(v.Field(i), raw, opts == "spaces"); err != nil {
+			return fmt.Errorf("invalid %s=%q: %w", key, raw, err)
+		}
+	}
+	return nil
+}
+
+// setFromEnv parses raw into the field f.
+func setFromEnv(f reflect.Value, raw string, spaces bool) error {
+	raw = strings.TrimSpace(raw)
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return fmt.Errorf("duration must not be negative")
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Bool:
+		switch strings.ToLower(raw) {
+		case "true", "1", "yes":
+			f.SetBool(true)
+		case "false", "0", "no", "":
+			f.SetBool(false)
+		default:
+			return fmt.Errorf("want true or false")
+		}
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(x)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", f.Type())
+		}
+		sep := func(r rune) bool { return r == ',' }
+		if spaces {
+			sep = unicode.IsSpace
+		}
+		var items []string
+		for _, item := range strings.FieldsFunc(raw, sep) {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		if f.Type() != floatMapType {
+			return fmt.Errorf("unsupported field type %s", f.Type())
+		}
+		m, err := parseFloatMap(raw)
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}
+
+// envName turns a Go field name into its environment variable name:
+// upper snake case, with acronyms kept whole (CORSAllowedOrigins is
+// CORS_ALLOWED_ORIGINS, StorageS3Bucket is STORAGE_S3_BUCKET).
+func envName(field string) string {
+	runes := []rune(field)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
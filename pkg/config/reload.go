@@ -0,0 +1,89 @@
+// Package config provides the checks behind reloading a config on SIGHUP.
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// reloadableServerFields are the ServerConfig fields a running server picks
+// up on reload; every other field only changes on restart.
+var reloadableServerFields = map[string]bool{
+	"RemoteBuilders":     true,
+	"SchedulingStrategy": true,
+	"RateLimitPerMinute": true,
+	"RateLimitBurst":     true,
+}
+
+// reloadableBuilderFields are the BuilderConfig fields a running builder
+// picks up on reload.
+var reloadableBuilderFields = map[string]bool{
+	"NotifyConfig": true,
+}
+
+// CheckReload returns why c cannot replace the running server config, or
+// nil. Unlike Validate, which only warns so a server still starts, it
+// rejects values a running server would otherwise silently fall back on.
+func (c *ServerConfig) CheckReload() error {
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("SERVER_PORT %d is invalid, must be 1-65535", c.Port)
+	}
+	if c.MaxWorkers <= 0 {
+		return fmt.Errorf("MAX_WORKERS must be > 0")
+	}
+	switch c.SchedulingStrategy {
+	case "", SchedulingFirst, SchedulingRoundRobin, SchedulingLeastLoaded:
+	default:
+		return fmt.Errorf("SCHEDULING_STRATEGY %q is unknown", c.SchedulingStrategy)
+	}
+	if c.RateLimitPerMinute < 0 || c.RateLimitBurst < 0 {
+		return fmt.Errorf("RATE_LIMIT_PER_MINUTE and RATE_LIMIT_BURST must not be negative")
+	}
+	return nil
+}
+
+// RestartRequired returns the names of the fields that differ between c and
+// next but that a running server only applies on restart.
+func (c *ServerConfig) RestartRequired(next *ServerConfig) []string {
+	return changedFields(c, next, reloadableServerFields)
+}
+
+// CheckReload returns why c cannot replace the running builder config, or
+// nil.
+func (c *BuilderConfig) CheckReload() error {
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("BUILDER_PORT %d is invalid, must be 1-65535", c.Port)
+	}
+	if c.Workers <= 0 {
+		return fmt.Errorf("BUILDER_WORKERS must be > 0")
+	}
+	if c.RateLimitPerMinute < 0 || c.RateLimitBurst < 0 {
+		return fmt.Errorf("BUILDER_RATE_LIMIT_PER_MINUTE and BUILDER_RATE_LIMIT_BURST must not be negative")
+	}
+	return nil
+}
+
+// RestartRequired returns the names of the fields that differ between c and
+// next but that a running builder only applies on restart.
+func (c *BuilderConfig) RestartRequired(next *BuilderConfig) []string {
+	return changedFields(c, next, reloadableBuilderFields)
+}
+
+// changedFields returns the names of the exported fields, other than the
+// reloadable ones, whose values differ between the structs a and b point
+// to, in declaration order.
+func changedFields(a, b interface{}, reloadable map[string]bool) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	t := va.Type()
+	var changed []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || reloadable[field.Name] {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, field.Name)
+		}
+	}
+	return changed
+}